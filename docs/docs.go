@@ -72,7 +72,7 @@ const docTemplate = `{
         },
         "/api/details": {
             "get": {
-                "description": "获取代码补全服务的详细信息",
+                "description": "获取代码补全服务的详细信息，列表按固定顺序输出：模型池按模型名称，客户端按最近活动时间从新到旧，请求按接收时间；seq和time用于判断快照是否过时",
                 "consumes": [
                     "application/json"
                 ],
@@ -83,6 +83,26 @@ const docTemplate = `{
                     "debug"
                 ],
                 "summary": "获取详细信息",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "字段选择，summary或full，默认full",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "客户端和请求列表跳过的条数，默认0",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "客户端和请求列表最多返回的条数，默认100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -90,6 +110,13 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
@@ -136,6 +163,30 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/metrics-mapping": {
+            "get": {
+                "description": "获取改名指标的旧名称、新名称、标签以及新指标中取值被规范化的标签，用于迁移看板；legacy表示当前是否仍输出旧名称",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "debug"
+                ],
+                "summary": "获取指标新旧名称对照",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/api/stats": {
             "get": {
                 "description": "获取代码补全服务的统计信息",
@@ -160,6 +211,29 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/version": {
+            "get": {
+                "description": "获取服务的构建信息、Go运行时版本、配置文件结构版本，以及按当前配置启用的功能",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "获取服务版本",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.VersionInfo"
+                        }
+                    }
+                }
+            }
+        },
         "/code-completion/api/v1/completions": {
             "post": {
                 "description": "根据提供的代码上下文生成代码补全建议",
@@ -361,6 +435,10 @@ const docTemplate = `{
                 "client_id": {
                     "type": "string"
                 },
+                "client_sequence": {
+                    "description": "插件给请求分配的递增序号，用于发现乱序的响应",
+                    "type": "integer"
+                },
                 "completion_id": {
                     "type": "string"
                 },
@@ -418,9 +496,25 @@ const docTemplate = `{
                         "$ref": "#/definitions/completions.CompletionChoice"
                     }
                 },
+                "client_sequence": {
+                    "description": "原样返回请求中的client_sequence",
+                    "type": "integer"
+                },
                 "created": {
                     "type": "integer"
                 },
+                "discarded_by": {
+                    "description": "使补全内容为空的后置处理器，如discard-syntax_error",
+                    "type": "string"
+                },
+                "empty_message": {
+                    "description": "补全为空的原因的简短说明",
+                    "type": "string"
+                },
+                "empty_reason": {
+                    "description": "补全为空或被拒绝的原因，稳定的标识: model_empty/extreme_repetition/syntax_error/not_match_language/invalid_brackets/css_content/discarded/cut_to_empty/blank_prompt/cursor_at_line_end/word_after_cursor，见EmptyReason*",
+                    "type": "string"
+                },
                 "error": {
                     "type": "string"
                 },
@@ -433,9 +527,17 @@ const docTemplate = `{
                 "object": {
                     "type": "string"
                 },
+                "server_sequence": {
+                    "description": "服务端按返回顺序给该客户端分配的递增序号",
+                    "type": "integer"
+                },
                 "status": {
                     "$ref": "#/definitions/model.CompletionStatus"
                 },
+                "superseded": {
+                    "description": "该客户端更新的请求已先返回，插件应丢弃该响应",
+                    "type": "boolean"
+                },
                 "usage": {
                     "$ref": "#/definitions/completions.CompletionPerformance"
                 },
@@ -582,6 +684,35 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "server.VersionInfo": {
+            "type": "object",
+            "properties": {
+                "buildTag": {
+                    "type": "string"
+                },
+                "buildTime": {
+                    "type": "string"
+                },
+                "commitId": {
+                    "type": "string"
+                },
+                "configVersion": {
+                    "type": "integer"
+                },
+                "features": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "boolean"
+                    }
+                },
+                "goVersion": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
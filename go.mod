@@ -3,6 +3,7 @@ module code-completion
 go 1.23.2
 
 require (
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/gin-gonic/gin v1.9.1
 	github.com/prometheus/client_golang v1.23.2
 	github.com/sugarme/tokenizer v0.3.0
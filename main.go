@@ -19,16 +19,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "code-completion/docs"
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
 	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/loadtest"
 	"code-completion/pkg/logger"
 	_ "code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
+	"code-completion/pkg/optimize"
 	"code-completion/pkg/stream_controller"
 	"code-completion/server"
 
@@ -73,8 +83,12 @@ func main() {
 	var (
 		port = flag.String("port", "8080", "服务器端口")
 		mode = flag.String("mode", "release", "运行模式 (debug/release)")
+		path = flag.String("config", "", "配置文件路径，未指定时使用环境变量"+config.EnvConfigPath+"，再查找config.yaml")
+		dev  = flag.Bool("dev", false, "本地开发模式，使用内置的最小配置(一个模拟模型)，不读取配置文件")
+		url  = flag.String("dev-model-url", "", "开发模式下模拟模型的补全地址，未指定时使用环境变量"+config.EnvDevModelURL)
 	)
 	flag.Parse()
+	initConfig(config.LoadOptions{Path: *path, Dev: *dev, DevModelURL: *url})
 
 	// 设置Gin运行模式
 	if *mode == "release" {
@@ -82,12 +96,40 @@ func main() {
 	}
 	logger.SetMode(*mode)
 	defer logger.Sync()
+	// 崩溃时输出诊断快照
+	defer stream_controller.DumpOnPanic()
+	stream_controller.WatchFatalSignals()
 
+	initMetrics()
+	initLanguages()
+	initPruners()
+	initScoreExperiment()
+	initGeneratedFilter()
+	// 内置的压测模式: code-completion [-mode debug] load [选项]
+	if flag.Arg(0) == "load" {
+		runLoadTest(flag.Args()[1:])
+		return
+	}
+	// 离线搜索修剪阈值: code-completion optimize -corpus 记录文件 -space 参数空间
+	if flag.Arg(0) == "optimize" {
+		runOptimize(flag.Args()[1:])
+		return
+	}
+	if err := initFeatureFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "初始化功能开关失败: %v\n", err)
+		os.Exit(2)
+	}
 	initModels()
 	initStreamController()
+	completions.Tuner.Start()
 
 	// 创建路由
-	r := server.SetupRouter()
+	r := server.SetupRouter(server.BuildInfo{
+		Version:   SoftwareVer,
+		BuildTime: BuildTime,
+		BuildTag:  BuildTag,
+		CommitID:  BuildCommitId,
+	})
 
 	// 创建服务器
 	addr := ":" + *port
@@ -100,6 +142,30 @@ func main() {
 	}
 }
 
+/**
+ * 加载配置，在初始化其它模块之前调用
+ * @param {config.LoadOptions} opts - 命令行指定的加载选项
+ * @description
+ * - 找不到配置文件或配置无效时输出原因并退出
+ * - 内置的压测模式和阈值搜索可以没有配置文件，使用默认值
+ */
+func initConfig(opts config.LoadOptions) {
+	_, err := config.Load(opts)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, config.ErrConfigNotFound) && flag.Arg(0) == "load" {
+		fmt.Printf("没有配置文件，压测使用默认的模型配置\n")
+		return
+	}
+	if errors.Is(err, config.ErrConfigNotFound) && flag.Arg(0) == "optimize" {
+		fmt.Printf("没有配置文件，阈值搜索以默认值为基准\n")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "加载配置失败: %v\n", err)
+	os.Exit(2)
+}
+
 /**
  * 初始化时区设置，使程序能够识别容器的TZ环境变量
  * @description
@@ -197,10 +263,145 @@ func initModels() {
 	}
 }
 
+// 按配置设置是否同时输出旧名称的指标，仍在输出时提醒迁移看板
+func initMetrics() {
+	deprecated := metrics.SetLegacyNames(!config.Config.Metrics.LegacyDisabled)
+	if len(deprecated) > 0 {
+		zap.L().Warn("Deprecated metric names are still emitted, migrate dashboards via /api/metrics-mapping",
+			zap.Strings("metrics", deprecated))
+	}
+}
+
+// 合并配置的语言覆盖到内置的语言配置，并校验语言回退的配置，配置无效时不启动
+func initLanguages() {
+	if err := completions.InitLanguageProfiles(config.Wrapper.Languages); err != nil {
+		panic(err)
+	}
+	if err := completions.CheckLanguageFallback(&config.Wrapper.Fallback); err != nil {
+		panic(err)
+	}
+}
+
+// 按配置创建后置处理器链，配置的处理器名称无效时不启动
+func initPruners() {
+	if err := completions.InitPrunerChains(&config.Wrapper.Prune); err != nil {
+		panic(err)
+	}
+}
+
+// 按配置创建隐藏分权重实验，配置无效时不启动
+func initScoreExperiment() {
+	if err := completions.InitScoreExperiment(&config.Wrapper.Score.Experiment); err != nil {
+		panic(err)
+	}
+}
+
+// 按配置编译生成文件检测的文件头正则，配置无效时不启动
+func initGeneratedFilter() {
+	if err := completions.InitGeneratedFilter(&config.Wrapper.Generated); err != nil {
+		panic(err)
+	}
+}
+
+// 按配置创建功能开关的来源，配置无效时返回错误
+func initFeatureFlags() error {
+	return feature_flag.Init(&config.Config.FeatureFlags)
+}
+
+/**
+ * 运行内置的压测
+ * @param {[]string} args - load子命令的参数
+ * @description
+ * - 不指定-target时在进程内启动服务，模型替换为模拟后端；指定时压测该地址的服务
+ * - 中断信号停止压测，输出已完成请求的报告
+ * - 有请求失败(transport_error)时以非0状态退出
+ * @example
+ * code-completion load -duration 1m -concurrency 32 -rate 200 -languages go=3,python=2 -seed 7
+ * code-completion load -smoke
+ */
+func runLoadTest(args []string) {
+	opts := loadtest.Options{Timeout: 30 * time.Second}
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	fs.StringVar(&opts.Target, "target", "", "压测的服务地址，为空时在进程内启动服务和模拟模型")
+	fs.DurationVar(&opts.Duration, "duration", 30*time.Second, "压测时长")
+	fs.IntVar(&opts.Concurrency, "concurrency", 8, "并发数")
+	fs.Float64Var(&opts.Rate, "rate", 0, "每秒最大请求数，0表示不限制")
+	fs.IntVar(&opts.Clients, "clients", 50, "模拟的客户端数")
+	fs.Int64Var(&opts.Seed, "seed", 1, "随机数种子")
+	fs.StringVar(&opts.Corpus, "corpus", "", "提示词模板文件(JSON)，为空时使用内置模板")
+	fs.DurationVar(&opts.ModelLatency, "model-latency", 150*time.Millisecond, "模拟模型的时延")
+	fs.BoolVar(&opts.UseContext, "context", false, "获取代码库上下文")
+	languages := fs.String("languages", "", "语言的混合比例，如go=3,python=2")
+	triggers := fs.String("triggers", "", "触发方式的混合比例，如AUTO=9,MANUAL=1")
+	smoke := fs.Bool("smoke", false, "冒烟模式：进程内、短时间、低并发")
+	fs.Parse(args)
+
+	if *smoke {
+		opts = loadtest.SmokeOptions()
+	}
+	var err error
+	if opts.Languages, err = loadtest.ParseMix(*languages); err != nil {
+		fmt.Println("invalid -languages:", err)
+		os.Exit(2)
+	}
+	if opts.Triggers, err = loadtest.ParseMix(*triggers); err != nil {
+		fmt.Println("invalid -triggers:", err)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	report, err := loadtest.Run(ctx, opts)
+	if err != nil {
+		fmt.Println("load test failed:", err)
+		os.Exit(1)
+	}
+	report.Print(os.Stdout)
+	if report.Statuses[loadtest.StatusTransportError] > 0 {
+		os.Exit(1)
+	}
+}
+
+/**
+ * 在录制的补全上搜索重复和重叠处理器的阈值
+ * @param {[]string} args - optimize子命令的参数
+ * @description
+ * - 记录文件每行一个补全样本(/api/samples导出)，样本的accepted由下一次请求的采纳反馈关联，没有反馈的样本跳过
+ * - 按样本录制的语言族、代码风格、字符串字面量和参数列表，用生产的处理器链重新修剪模型输出
+ * - 输出得分最高的配置和各参数的敏感度，把最优配置写入prune.params
+ * @example
+ * code-completion optimize -corpus samples.jsonl -space space.json -top 10
+ */
+func runOptimize(args []string) {
+	var opts optimize.Options
+	fs := flag.NewFlagSet("optimize", flag.ExitOnError)
+	corpus := fs.String("corpus", "", "录制的补全(JSON Lines)")
+	space := fs.String("space", "", "参数空间文件(JSON)")
+	fs.IntVar(&opts.Workers, "workers", 0, "并发数，0表示使用GOMAXPROCS")
+	fs.IntVar(&opts.Top, "top", 5, "列出的最优配置数")
+	fs.Parse(args)
+
+	if *corpus == "" || *space == "" {
+		fmt.Println("optimize requires -corpus and -space")
+		os.Exit(2)
+	}
+	records, err := optimize.LoadCorpus(*corpus)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	s, err := optimize.LoadSpace(*space)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	optimize.Run(records, s, opts).Print(os.Stdout)
+}
+
+// 创建所有请求共享的代码上下文客户端，注入到流控制器
 func initStreamController() {
 	zap.L().Info("Initialize the stream-controller")
 
-	sc := stream_controller.NewStreamController()
+	sc := stream_controller.NewStreamController(codebase_context.NewContextClient())
 	sc.Init()
 	stream_controller.Controller = sc
 }
@@ -3,6 +3,8 @@ package codebase_context
 import (
 	"bytes"
 	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/store"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -13,6 +15,12 @@ import (
 	"go.uber.org/zap"
 )
 
+// HeaderPromptFingerprint 提示词指纹的请求头，检索服务记录该值以便与补全服务、插件的日志关联
+const HeaderPromptFingerprint = "X-Prompt-Fingerprint"
+
+// 错误响应中说明的最大字节数
+const maxErrorMessageBytes = 256
+
 // HTTPClient 接口定义
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
@@ -32,6 +40,13 @@ func NewAPIClient() *APIClient {
 	}
 }
 
+// CloseIdleConnections 关闭空闲连接，释放被放弃的检索请求占用的socket
+func (c *APIClient) CloseIdleConnections() {
+	if closer, ok := c.client.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
 // RequestParam 请求参数
 type RequestParam struct {
 	ClientID       string  `json:"clientId"`
@@ -47,6 +62,37 @@ type RequestParam struct {
 	IncludeContent bool    `json:"includeContent,omitempty"`
 }
 
+// StatusError 检索服务返回非2xx状态码，Message为错误响应中的说明(如有)
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("request failed with status %d", e.StatusCode)
+}
+
+// 检索服务错误响应中的说明，响应不是JSON或没有说明时返回空字符串
+func errorMessage(data []byte) string {
+	var rsp struct {
+		Message string `json:"message"`
+		Msg     string `json:"msg"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &rsp); err != nil {
+		return ""
+	}
+	for _, message := range []string{rsp.Message, rsp.Msg, rsp.Error} {
+		if message != "" {
+			return store.Retain(message, maxErrorMessageBytes)
+		}
+	}
+	return ""
+}
+
 // ResponseData 响应数据结构
 type ResponseData struct {
 	Data struct {
@@ -93,7 +139,7 @@ func (c *APIClient) DoRequest(ctx context.Context, requestURL string, params Req
 	var req *http.Request
 	body, err := json.Marshal(params)
 	if err != nil {
-		zap.L().Warn("Failed to marshal request params", zap.Error(err), zap.String("url", requestURL))
+		logger.FromContext(ctx).Warn("Failed to marshal request params", zap.Error(err), zap.String("url", requestURL))
 		return nil, err
 	}
 	if method == "POST" {
@@ -101,7 +147,7 @@ func (c *APIClient) DoRequest(ctx context.Context, requestURL string, params Req
 	} else {
 		var paramsMap map[string]interface{}
 		if err := json.Unmarshal(body, &paramsMap); err != nil {
-			zap.L().Warn("Failed to unmarshal params for query", zap.Error(err), zap.String("url", requestURL))
+			logger.FromContext(ctx).Warn("Failed to unmarshal params for query", zap.Error(err), zap.String("url", requestURL))
 			return nil, err
 		}
 		query := kvs2UrlValues(paramsMap)
@@ -112,7 +158,7 @@ func (c *APIClient) DoRequest(ctx context.Context, requestURL string, params Req
 		req, err = http.NewRequestWithContext(ctx, method, rawUrl, nil)
 	}
 	if err != nil {
-		zap.L().Warn("Failed to create request", zap.Error(err), zap.String("url", requestURL))
+		logger.FromContext(ctx).Warn("Failed to create request", zap.Error(err), zap.String("url", requestURL))
 		return nil, err
 	}
 	// 设置请求头,只包含这几个
@@ -120,10 +166,13 @@ func (c *APIClient) DoRequest(ctx context.Context, requestURL string, params Req
 	req.Header.Set("Authorization", headers.Get("Authorization"))
 	req.Header.Set("X-Costrict-Version", headers.Get("X-Costrict-Version"))
 	req.Header.Set("Content-Type", "application/json")
+	if fp := headers.Get(HeaderPromptFingerprint); fp != "" {
+		req.Header.Set(HeaderPromptFingerprint, fp)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		zap.L().Warn("Request failed", zap.Error(err),
+		logger.FromContext(ctx).Warn("Request failed", zap.Error(err),
 			zap.String("url", requestURL),
 			zap.String("body", string(body)))
 		return nil, err
@@ -132,17 +181,17 @@ func (c *APIClient) DoRequest(ctx context.Context, requestURL string, params Req
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		data, _ := io.ReadAll(resp.Body)
-		zap.L().Warn("Request returned non-200 status",
+		logger.FromContext(ctx).Warn("Request returned non-200 status",
 			zap.Int("status", resp.StatusCode),
 			zap.String("url", requestURL),
 			zap.Any("headers", headers2zapAny(req.Header)),
 			zap.String("params", string(body)),
 			zap.String("resp", string(data)))
-		return nil, fmt.Errorf("request failed with status %d", resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: errorMessage(data)}
 	}
 	var result ResponseData
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		zap.L().Warn("Failed to decode response", zap.Error(err), zap.String("url", requestURL))
+		logger.FromContext(ctx).Warn("Failed to decode response", zap.Error(err), zap.String("url", requestURL))
 		return nil, err
 	}
 	return &result, nil
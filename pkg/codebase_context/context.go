@@ -2,12 +2,15 @@ package codebase_context
 
 import (
 	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/model"
 	"context"
 	"fmt"
 	"net/http"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -15,6 +18,8 @@ import (
 // ContextClient 上下文客户端
 type ContextClient struct {
 	apiClient *APIClient
+	inflight  sync.WaitGroup // 所有尚未退出的检索协程
+	health    *healthCache   // 各客户端项目的检索服务状态，见Health
 }
 
 /**
@@ -31,14 +36,103 @@ type ContextClient struct {
 func NewContextClient() *ContextClient {
 	return &ContextClient{
 		apiClient: NewAPIClient(),
+		health:    newHealthCache(config.Context.HealthTTL, nil),
 	}
 }
 
+// NewContextClientWith 使用指定的HTTP客户端创建上下文客户端，用于在测试中替换检索服务
+func NewContextClientWith(client HTTPClient) *ContextClient {
+	return &ContextClient{
+		apiClient: &APIClient{client: client},
+		health:    newHealthCache(config.Context.HealthTTL, nil),
+	}
+}
+
+type totalTimeoutKey struct{}
+
+// WithTotalTimeout 为ctx下的上下文获取指定总超时，代替配置context.totalTimeout(如后台获取上下文)
+func WithTotalTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, totalTimeoutKey{}, timeout)
+}
+
+// 上下文获取的总超时，ctx中没有指定时使用配置context.totalTimeout
+func totalTimeoutOf(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(totalTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return timeout
+	}
+	return config.Context.TotalTimeout
+}
+
 // SearchResult 搜索结果
 type SearchResult struct {
 	DefinitionResults []*ResponseData
 	SemanticResults   []*ResponseData
 	RelationResults   []*ResponseData
+	SourceResults     []*ResponseData // 被测源文件的定义检索结果，只在补全测试文件时检索
+}
+
+/**
+ * Search group tracking the goroutines of one RequestContext fan-out
+ * @description
+ * - Counts goroutines both per call (wg) and per client (ContextClient.inflight)
+ * - Serializes writes to the result slices with the caller's read
+ * - Once abandoned (total timeout fired), late results are dropped so the
+ *   returned slices are never written concurrently
+ */
+type searchGroup struct {
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	abandoned bool
+}
+
+func (g *searchGroup) add(c *ContextClient) {
+	g.wg.Add(1)
+	c.inflight.Add(1)
+}
+
+func (g *searchGroup) done(c *ContextClient) {
+	c.inflight.Done()
+	g.wg.Done()
+}
+
+func (g *searchGroup) store(results []*ResponseData, idx int, data *ResponseData) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if !g.abandoned {
+		results[idx] = data
+	}
+}
+
+func (g *searchGroup) abandon() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.abandoned = true
+}
+
+/**
+ * Wait until every search goroutine spawned by this client has exited
+ * @param {time.Duration} timeout - Maximum time to wait
+ * @returns {bool} Returns true if all goroutines exited within timeout
+ * @description
+ * - Used by graceful shutdown and tests to assert that no fan-out goroutine
+ *   outlives RequestContext beyond a small grace period
+ * @example
+ * if !client.WaitIdle(100 * time.Millisecond) {
+ *     zap.L().Warn("context search goroutines still running")
+ * }
+ */
+func (c *ContextClient) WaitIdle(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 /**
@@ -49,26 +143,29 @@ type SearchResult struct {
  * @param {string} filePath - Path to the file containing the code snippet
  * @param {string} codeSnippet - Code snippet to search for definitions
  * @param {http.Header} headers - HTTP headers for the request
- * @param {sync.WaitGroup} wg - Wait group for synchronization
+ * @param {*searchGroup} g - Search group tracking the fan-out goroutines
  * @param {[]*ResponseData} results - Slice to store search results
  * @param {int} idx - Index in results slice to store the result
  * @description
  * - Performs asynchronous definition search for code snippet
  * - Updates results slice at specified index with search result
- * - Handles errors by storing error result in results slice
- * - Signals completion via done() on wait group
+ * - Stores the result only on success, failures are logged and leave the slot nil
+ * - Results arriving after the group was abandoned are dropped
+ * - Signals completion via done() on the search group
  * @example
- * wg.Add(1)
- * go client.searchDefinitionAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, &wg, results, 0)
+ * g.add(client)
+ * go client.searchDefinitionAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, g, results, 0)
  */
 func (c *ContextClient) searchDefinitionAsync(ctx context.Context, clientID, codebasePath, filePath, codeSnippet string,
-	headers http.Header, wg *sync.WaitGroup, results []*ResponseData, idx int) {
-	defer wg.Done()
+	headers http.Header, g *searchGroup, results []*ResponseData, idx int) {
+	defer g.done(c)
 
 	data, err := c.searchDefinition(ctx, clientID, codebasePath, filePath, codeSnippet, headers)
 	if err != nil {
-		results[idx] = data
+		logger.FromContext(ctx).Debug("Definition search failed", zap.String("clientID", clientID), zap.Error(err))
+		return
 	}
+	g.store(results, idx, data)
 }
 
 /**
@@ -79,26 +176,29 @@ func (c *ContextClient) searchDefinitionAsync(ctx context.Context, clientID, cod
  * @param {string} filePath - Path to the file containing the code snippet
  * @param {string} codeSnippet - Code snippet to search for relations
  * @param {http.Header} headers - HTTP headers for the request
- * @param {sync.WaitGroup} wg - Wait group for synchronization
+ * @param {*searchGroup} g - Search group tracking the fan-out goroutines
  * @param {[]*ResponseData} results - Slice to store search results
  * @param {int} idx - Index in results slice to store the result
  * @description
  * - Performs asynchronous relation search for code snippet
  * - Updates results slice at specified index with search result
- * - Handles errors by storing error result in results slice
- * - Signals completion via done() on wait group
+ * - Stores the result only on success, failures are logged and leave the slot nil
+ * - Results arriving after the group was abandoned are dropped
+ * - Signals completion via done() on the search group
  * @example
- * wg.Add(1)
- * go client.searchRelationAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, &wg, results, 1)
+ * g.add(client)
+ * go client.searchRelationAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, g, results, 1)
  */
 func (c *ContextClient) searchRelationAsync(ctx context.Context, clientID, codebasePath, filePath, codeSnippet string,
-	headers http.Header, wg *sync.WaitGroup, results []*ResponseData, idx int) {
-	defer wg.Done()
+	headers http.Header, g *searchGroup, results []*ResponseData, idx int) {
+	defer g.done(c)
 
 	data, err := c.searchRelation(ctx, clientID, codebasePath, filePath, codeSnippet, headers)
 	if err != nil {
-		results[idx] = data
+		logger.FromContext(ctx).Debug("Relation search failed", zap.String("clientID", clientID), zap.Error(err))
+		return
 	}
+	g.store(results, idx, data)
 }
 
 /**
@@ -108,26 +208,29 @@ func (c *ContextClient) searchRelationAsync(ctx context.Context, clientID, codeb
  * @param {string} codebasePath - Path to the codebase being searched
  * @param {string} query - Semantic query string to search for
  * @param {http.Header} headers - HTTP headers for the request
- * @param {sync.WaitGroup} wg - Wait group for synchronization
+ * @param {*searchGroup} g - Search group tracking the fan-out goroutines
  * @param {[]*ResponseData} results - Slice to store search results
  * @param {int} idx - Index in results slice to store the result
  * @description
  * - Performs asynchronous semantic search for code
  * - Updates results slice at specified index with search result
- * - Handles errors by storing error result in results slice
- * - Signals completion via done() on wait group
+ * - Stores the result only on success, failures are logged and leave the slot nil
+ * - Results arriving after the group was abandoned are dropped
+ * - Signals completion via done() on the search group
  * @example
- * wg.Add(1)
- * go client.searchSemanticAsync(ctx, "client-id", "/codebase", "database query", headers, &wg, results, 2)
+ * g.add(client)
+ * go client.searchSemanticAsync(ctx, "client-id", "/codebase", "database query", headers, g, results, 2)
  */
 func (c *ContextClient) searchSemanticAsync(ctx context.Context, clientID, codebasePath, query string, headers http.Header,
-	wg *sync.WaitGroup, results []*ResponseData, idx int) {
-	defer wg.Done()
+	g *searchGroup, results []*ResponseData, idx int) {
+	defer g.done(c)
 
 	data, err := c.searchSemantic(ctx, clientID, codebasePath, query, headers)
 	if err != nil {
-		results[idx] = data
+		logger.FromContext(ctx).Debug("Semantic search failed", zap.String("clientID", clientID), zap.Error(err))
+		return
 	}
+	g.store(results, idx, data)
 }
 
 /**
@@ -145,26 +248,35 @@ func (c *ContextClient) searchSemanticAsync(ctx context.Context, clientID, codeb
  * - Uses goroutines for concurrent execution of different search types
  * - Sets up timeout context based on configuration
  * - Returns partial results if context timeout occurs
+ * - On timeout the group is abandoned: in-flight requests are canceled through
+ *   the shared context, late results are dropped and idle connections closed
  * - Respects configuration flags for enabling/disabling specific search types
  * @example
  * result := client.RequestContext(ctx, "client-id", "/codebase", "file.go",
  *     []string{"func test()"}, []string{"database query"}, headers)
  */
 func (c *ContextClient) RequestContext(ctx context.Context, clientID, codebasePath, filePath string,
+	codeSnippets []string, queries []string, headers http.Header) *SearchResult {
+	return c.requestContext(ctx, clientID, codebasePath, filePath, "", codeSnippets, queries, headers)
+}
+
+// 同RequestContext，sourcePath不为空时，在被测源文件中对第一个代码片段再做一次定义检索
+func (c *ContextClient) requestContext(ctx context.Context, clientID, codebasePath, filePath, sourcePath string,
 	codeSnippets []string, queries []string, headers http.Header) *SearchResult {
 	if clientID == "" || codebasePath == "" || filePath == "" {
 		return &SearchResult{}
 	}
 
 	// 创建上下文，设置超时
-	ctx, cancel := context.WithTimeout(ctx, config.Context.TotalTimeout)
+	ctx, cancel := context.WithTimeout(ctx, totalTimeoutOf(ctx))
 	defer cancel()
 
-	var wg sync.WaitGroup
+	g := &searchGroup{}
 	// 初始化结果数组
 	definitionResults := make([]*ResponseData, len(codeSnippets))
 	relationResults := make([]*ResponseData, len(codeSnippets))
 	semanticResults := make([]*ResponseData, len(queries))
+	sourceResults := make([]*ResponseData, 1)
 
 	// 定义检索
 	if len(codeSnippets) > 0 && !config.Context.Definition.Disabled {
@@ -172,18 +284,23 @@ func (c *ContextClient) RequestContext(ctx context.Context, clientID, codebasePa
 			if codeSnippet == "" {
 				continue
 			}
-			wg.Add(1)
-			go c.searchDefinitionAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, &wg, definitionResults, i)
+			g.add(c)
+			go c.searchDefinitionAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, g, definitionResults, i)
 		}
 	}
+	// 被测源文件的定义检索
+	if sourcePath != "" && len(codeSnippets) > 0 && codeSnippets[0] != "" && !config.Context.Definition.Disabled {
+		g.add(c)
+		go c.searchDefinitionAsync(ctx, clientID, codebasePath, sourcePath, codeSnippets[0], headers, g, sourceResults, 0)
+	}
 	// 调用链检索
 	if len(codeSnippets) > 0 && !config.Context.Relation.Disabled {
 		for i, codeSnippet := range codeSnippets {
 			if codeSnippet == "" {
 				continue
 			}
-			wg.Add(1)
-			go c.searchRelationAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, &wg, relationResults, i)
+			g.add(c)
+			go c.searchRelationAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, g, relationResults, i)
 		}
 	}
 
@@ -193,15 +310,15 @@ func (c *ContextClient) RequestContext(ctx context.Context, clientID, codebasePa
 			if query == "" {
 				continue
 			}
-			wg.Add(1)
-			go c.searchSemanticAsync(ctx, clientID, codebasePath, query, headers, &wg, semanticResults, i)
+			g.add(c)
+			go c.searchSemanticAsync(ctx, clientID, codebasePath, query, headers, g, semanticResults, i)
 		}
 	}
 
 	// 等待所有请求完成或上下文取消
 	done := make(chan struct{})
 	go func() {
-		wg.Wait()
+		g.wg.Wait()
 		close(done)
 	}()
 
@@ -209,71 +326,170 @@ func (c *ContextClient) RequestContext(ctx context.Context, clientID, codebasePa
 	select {
 	case <-done: // 所有请求完成
 	case <-ctx.Done(): // 上下文取消，直接返回已收集的结果
-		zap.L().Warn("Context timeout, returning partial results", zap.Error(ctx.Err()))
+		logger.FromContext(ctx).Warn("Context timeout, returning partial results", zap.Error(ctx.Err()))
+		// 放弃本组检索：丢弃迟到的结果，取消在途请求并释放空闲连接
+		g.abandon()
+		cancel()
+		c.apiClient.CloseIdleConnections()
 	}
 	return &SearchResult{
 		DefinitionResults: definitionResults,
 		SemanticResults:   semanticResults,
 		RelationResults:   relationResults,
+		SourceResults:     sourceResults,
 	}
 }
 
-// 获取上下文信息
-func (c *ContextClient) GetContext(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) string {
+// 获取上下文信息，skipSemantic为true时不做语义检索(如只补全标识符或导入路径的微补全)
+// 检索结果先经过校验(见searchItems)，不合格的结果被丢弃；命中忽略规则(见ignoredBy)的文件的片段也被丢弃
+// sourcePath为测试文件对应的被测源文件(项目内的相对路径)，不为空时其定义检索结果排在最前面
+// 同时按合并顺序返回各片段的来源(含被校验、忽略规则和去重丢弃的片段)，各检索去重后贡献的字节数见Contributed
+func (c *ContextClient) GetContext(ctx context.Context, clientID, projectPath, filePath, sourcePath, prefix, suffix, importContent string, headers http.Header, skipSemantic bool) (string, []model.ContextSnippet) {
 	if clientID == "" || projectPath == "" || filePath == "" || (prefix == "" && suffix == "") {
-		return ""
+		return "", nil
 	}
 
 	// 构建完整文件路径
 	fullFilePath := filepath.Join(projectPath, filePath)
+	fullSourcePath := ""
+	if sourcePath != "" {
+		fullSourcePath = filepath.Join(projectPath, sourcePath)
+	}
 
 	// Windows路径处理
 	if len(projectPath) > 1 && projectPath[1:3] == ":\\" {
 		fullFilePath = strings.ReplaceAll(fullFilePath, "/", "\\")
+		fullSourcePath = strings.ReplaceAll(fullSourcePath, "/", "\\")
 	}
 
 	// 获取语义搜索内容（前缀最后几行）
 	semanticSearchContent := rSliceAfterNthInstance(prefix, "\n", 4)
+	if skipSemantic {
+		semanticSearchContent = ""
+	}
 
 	// 定义检索代码片段
 	definitionCodeSnaps := []string{
 		fmt.Sprintf("%s%s%s", importContent, prefix, suffix),
 	}
 
-	searchResult := c.RequestContext(ctx, clientID, projectPath, fullFilePath,
+	searchResult := c.requestContext(ctx, clientID, projectPath, fullFilePath, fullSourcePath,
 		definitionCodeSnaps, []string{semanticSearchContent}, headers)
 
 	// 解析语义检索结果
-	semanticCodes := parseSemantic(searchResult.SemanticResults)
+	semanticItems, semanticDropped := searchItems(ctx, ProviderSemantic, searchResult.SemanticResults)
+	semanticCodes := parseSemantic(semanticItems)
 
 	// 解析定义检索结果
-	defCodes := parseDefinition(searchResult.DefinitionResults)
+	defItems, defDropped := searchItems(ctx, ProviderDefinition, searchResult.DefinitionResults)
+	defCodes := parseDefinition(defItems)
 
 	// 解析关系检索结果
-	relationCodes := parseRelation(searchResult.RelationResults)
+	relationItems, relationDropped := searchItems(ctx, ProviderRelation, searchResult.RelationResults)
+	relationCodes := parseRelation(relationItems)
+
+	sourceItems, sourceDropped := searchItems(ctx, ProviderSourceUnderTest, searchResult.SourceResults)
 
 	var allCodes []string
+	var snippets []model.ContextSnippet
+	// 不同检索返回的相同代码只保留第一次出现的，记录保留了该代码的检索
+	// 合并前先清洗片段中操纵模型的文字(见sanitizeContent)，按清洗后的内容去重
+	seen := make(map[string]string)
+	merge := func(provider, filePath, content string, score float64) {
+		snippet := model.ContextSnippet{Provider: provider, FilePath: filePath, Score: score}
+		if rule := ignoredBy(filePath); rule != "" {
+			snippet.Dropped, snippet.Detail = model.SnippetIgnored, rule
+			snippets = append(snippets, snippet)
+			return
+		}
+		content, snippet.Sanitized = sanitizeContent(ctx, provider, filePath, content)
+		if first, ok := seen[content]; ok {
+			snippet.Dropped, snippet.DuplicateOf = model.SnippetDedup, first
+			snippets = append(snippets, snippet)
+			return
+		}
+		seen[content] = provider
+		allCodes = append(allCodes, filePath, content)
+		snippets = append(snippets, snippet)
+	}
+
+	// 被测源文件的定义最相关，最先合并
+	for _, item := range parseDefinition(sourceItems) {
+		merge(ProviderSourceUnderTest, item.FilePath, item.Content, item.Score)
+	}
+	snippets = append(snippets, sourceDropped...)
 
 	// 合并定义检索结果
 	for _, item := range defCodes {
-		allCodes = append(allCodes, item.FilePath, item.Content)
+		merge(ProviderDefinition, item.FilePath, item.Content, item.Score)
 	}
+	snippets = append(snippets, defDropped...)
 
 	// 合并语义检索结果
 	for _, item := range semanticCodes {
-		allCodes = append(allCodes, item.FilePath, item.Content)
+		merge(ProviderSemantic, item.FilePath, item.Content, item.Score)
 	}
+	snippets = append(snippets, semanticDropped...)
 
 	// 合并关系检索结果
 	for _, item := range relationCodes {
-		allCodes = append(allCodes, item.FilePath, item.Content)
+		merge(ProviderRelation, item.FilePath, item.Content, item.Score)
 	}
+	snippets = append(snippets, relationDropped...)
 
 	// 合并所有结果
 	semanticResult := strings.Join(allCodes, "\n")
 
 	// 添加注释
-	return getComment(fullFilePath, semanticResult)
+	code := getComment(fullFilePath, semanticResult)
+	locateSnippets(fullFilePath, code, allCodes, snippets)
+	return code, snippets
+}
+
+/**
+ * 定位各片段在加上注释后的上下文中的位置
+ * @param {string} filePath - 补全的文件，决定注释风格
+ * @param {string} code - 加上注释后的上下文
+ * @param {[]string} allCodes - 依次为每个保留的片段的文件路径和内容
+ * @param {[]model.ContextSnippet} snippets - 片段的来源，设置其中保留的片段的Offset和Bytes
+ * @description
+ * - 逐行注释的风格下，单独注释一个片段的结果与它在整个上下文中的注释结果相同；
+ *   整体包裹的注释风格(如块注释)下片段本身不变，按原文定位
+ */
+func locateSnippets(filePath, code string, allCodes []string, snippets []model.ContextSnippet) {
+	cursor, j := 0, 0
+	for i := range snippets {
+		if snippets[i].Dropped != "" {
+			continue
+		}
+		piece := allCodes[2*j] + "\n" + allCodes[2*j+1]
+		j++
+		text := getComment(filePath, piece)
+		idx := strings.Index(code[cursor:], text)
+		if idx < 0 {
+			text = piece
+			idx = strings.Index(code[cursor:], text)
+		}
+		if idx < 0 {
+			continue
+		}
+		snippets[i].Offset, snippets[i].Bytes = cursor+idx, len(text)
+		cursor += idx + len(text)
+	}
+}
+
+// 各检索去重后贡献的字节数(加上注释后)，没有片段时返回nil
+func Contributed(snippets []model.ContextSnippet) map[string]int {
+	if len(snippets) == 0 {
+		return nil
+	}
+	contributed := make(map[string]int)
+	for _, s := range snippets {
+		if s.Dropped == "" {
+			contributed[s.Provider] += s.Bytes
+		}
+	}
+	return contributed
 }
 
 // 搜索代码定义
@@ -285,7 +501,9 @@ func (c *ContextClient) searchDefinition(ctx context.Context, clientID, codebase
 		CodeSnippet:  codeSnippet,
 	}
 
-	return c.apiClient.DoRequest(ctx, config.Context.Definition.Url, params, headers, "GET")
+	data, err := c.apiClient.DoRequest(ctx, config.Context.Definition.Url, params, headers, "GET")
+	c.health.record(clientID, codebasePath, ProviderDefinition, providerState(ctx, err))
+	return data, err
 }
 
 // 语义搜索
@@ -298,7 +516,9 @@ func (c *ContextClient) searchSemantic(ctx context.Context, clientID, codebasePa
 		ScoreThreshold: config.Context.Semantic.ScoreThreshold,
 	}
 
-	return c.apiClient.DoRequest(ctx, config.Context.Semantic.Url, params, headers, "POST")
+	data, err := c.apiClient.DoRequest(ctx, config.Context.Semantic.Url, params, headers, "POST")
+	c.health.record(clientID, codebasePath, ProviderSemantic, providerState(ctx, err))
+	return data, err
 }
 
 // 关系检索
@@ -312,5 +532,44 @@ func (c *ContextClient) searchRelation(ctx context.Context, clientID, codebasePa
 		IncludeContent: config.Context.Relation.IncludeContent,
 	}
 
-	return c.apiClient.DoRequest(ctx, config.Context.Relation.Url, params, headers, "GET")
+	data, err := c.apiClient.DoRequest(ctx, config.Context.Relation.Url, params, headers, "GET")
+	c.health.record(clientID, codebasePath, ProviderRelation, providerState(ctx, err))
+	return data, err
+}
+
+// 检索服务的名称
+const (
+	ProviderDefinition = "definition"
+	ProviderSemantic   = "semantic"
+	ProviderRelation   = "relation"
+	// 测试文件对应的被测源文件的定义检索，复用定义检索服务
+	ProviderSourceUnderTest = "source_under_test"
+)
+
+/**
+ * 单独调用一个检索服务，用于配置检查
+ * @param {context.Context} ctx - 请求上下文
+ * @param {string} provider - 检索服务(definition/semantic/relation)
+ * @param {string} clientID - 客户端ID
+ * @param {string} codebasePath - 项目路径
+ * @param {string} filePath - 文件的完整路径
+ * @param {string} snippet - 定义和关系检索的代码片段，或语义检索的查询
+ * @param {http.Header} headers - 原始请求头，转发认证信息
+ * @returns {*ResponseData} 返回检索结果
+ * @returns {error} 非2xx状态码时为*StatusError
+ * @description
+ * - 不受配置的disabled限制，调用者自行判断
+ * - 不做总超时控制，由ctx控制
+ * - 结果同样更新检索服务的状态，见Health
+ */
+func (c *ContextClient) Probe(ctx context.Context, provider, clientID, codebasePath, filePath, snippet string, headers http.Header) (*ResponseData, error) {
+	switch provider {
+	case ProviderDefinition:
+		return c.searchDefinition(ctx, clientID, codebasePath, filePath, snippet, headers)
+	case ProviderSemantic:
+		return c.searchSemantic(ctx, clientID, codebasePath, snippet, headers)
+	case ProviderRelation:
+		return c.searchRelation(ctx, clientID, codebasePath, filePath, snippet, headers)
+	}
+	return nil, fmt.Errorf("unknown provider %q", provider)
 }
@@ -0,0 +1,167 @@
+package codebase_context
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// setupContextConfig points all context searches to the given server
+func setupContextConfig(url string, totalTimeout time.Duration) func() {
+	saved := *config.Context
+	config.Context.Definition = config.DefinitionConfig{Url: url}
+	config.Context.Semantic = config.SemanticConfig{Url: url, TopK: 5}
+	config.Context.Relation = config.RelationConfig{Url: url, Layer: 1}
+	config.Context.RequestTimeout = 5 * time.Second
+	config.Context.TotalTimeout = totalTimeout
+	return func() {
+		*config.Context = saved
+	}
+}
+
+// to test successful results propagation
+// go test ./pkg/codebase_context/ -v -run Test_RequestContextResults
+func Test_RequestContextResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"list":[{"filePath":"a.go","content":"func A() {}","score":0.9}]}}`))
+	}))
+	defer server.Close()
+	defer setupContextConfig(server.URL, time.Second)()
+
+	client := NewContextClient()
+	result := client.RequestContext(context.Background(), "client", "/project", "/project/main.go",
+		[]string{"func main() {"}, []string{"main"}, http.Header{})
+
+	for name, results := range map[string][]*ResponseData{
+		"definition": result.DefinitionResults,
+		"relation":   result.RelationResults,
+		"semantic":   result.SemanticResults,
+	} {
+		if len(results) != 1 || results[0] == nil {
+			t.Errorf("%s: expected 1 result, got %v", name, results)
+			continue
+		}
+		if len(results[0].Data.List) != 1 {
+			t.Errorf("%s: expected 1 item, got %d", name, len(results[0].Data.List))
+		}
+	}
+	if !client.WaitIdle(100 * time.Millisecond) {
+		t.Error("search goroutines still running after completion")
+	}
+}
+
+// to test prompt cancellation when the total timeout fires
+// go test ./pkg/codebase_context/ -v -run Test_RequestContextCancel
+func Test_RequestContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	defer setupContextConfig(server.URL, 100*time.Millisecond)()
+
+	client := NewContextClient()
+	start := time.Now()
+	result := client.RequestContext(context.Background(), "client", "/project", "/project/main.go",
+		[]string{"func main() {"}, []string{"main"}, http.Header{})
+	elapsed := time.Since(start)
+
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("RequestContext returned after %v, expected about 100ms", elapsed)
+	}
+	if result.DefinitionResults[0] != nil || result.SemanticResults[0] != nil || result.RelationResults[0] != nil {
+		t.Error("expected no results from the slow server")
+	}
+	if !client.WaitIdle(200 * time.Millisecond) {
+		t.Error("search goroutines outlived RequestContext beyond the grace period")
+	}
+}
+
+// to test the source-under-test definitions are merged first for test files
+// go test ./pkg/codebase_context/ -v -run Test_GetContextSourceUnderTest
+func Test_GetContextSourceUnderTest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("filePath") == "/project/pkg/util.go" {
+			w.Write([]byte(`{"data":{"list":[{"filePath":"pkg/util.go","content":"func Trim(s string) string"}]}}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"list":[{"filePath":"pkg/other.go","content":"func Other()"}]}}`))
+	}))
+	defer server.Close()
+	defer setupContextConfig(server.URL, time.Second)()
+	config.Context.Semantic.Disabled = true
+	config.Context.Relation.Disabled = true
+
+	client := NewContextClient()
+	code, snippets := client.GetContext(context.Background(), "client", "/project", "pkg/util_test.go", "pkg/util.go",
+		"func TestTrim(t *testing.T) {\n\t", "\n}", "", http.Header{}, false)
+	source, other := strings.Index(code, "func Trim"), strings.Index(code, "func Other")
+	if source < 0 || other < 0 || source > other {
+		t.Errorf("expected the source definition first, got %q", code)
+	}
+	if contributed := Contributed(snippets); contributed[ProviderSourceUnderTest] == 0 || contributed[ProviderDefinition] == 0 {
+		t.Errorf("unexpected contributions %v", snippets)
+	}
+
+	// 不是测试文件时不检索被测源文件
+	_, snippets = client.GetContext(context.Background(), "client", "/project", "pkg/util_test.go", "",
+		"func TestTrim(t *testing.T) {\n\t", "\n}", "", http.Header{}, false)
+	if _, ok := Contributed(snippets)[ProviderSourceUnderTest]; ok {
+		t.Errorf("unexpected source contribution %v", snippets)
+	}
+}
+
+// to test snippets dropped by validation and ignore rules are reported in the provenance
+// go test ./pkg/codebase_context/ -v -run Test_GetContextDropped
+func Test_GetContextDropped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"list":[` +
+			`{"filePath":"pkg/util.go","content":"func Trim(s string) string"},` +
+			`{"filePath":"vendor/lib/lib.go","content":"func Lib()"},` +
+			`{"filePath":"pkg/a.go","content":"func A()"},` +
+			`{"filePath":"pkg/empty.go","content":""}]}}`))
+	}))
+	defer server.Close()
+	defer setupContextConfig(server.URL, time.Second)()
+	config.Context.Semantic.Disabled = true
+	config.Context.Relation.Disabled = true
+	config.Context.Ignore = []string{"[", "vendor/*/*"}
+
+	client := NewContextClient()
+	code, snippets := client.GetContext(context.Background(), "client", "/project", "main.go", "",
+		"func main() {\n\t", "\n}", "", http.Header{}, false)
+	if strings.Contains(code, "func Lib") || !strings.Contains(code, "func Trim") {
+		t.Errorf("unexpected context %q", code)
+	}
+	var ignored, invalid *model.ContextSnippet
+	for i := range snippets {
+		switch snippets[i].Dropped {
+		case model.SnippetIgnored:
+			ignored = &snippets[i]
+		case model.SnippetInvalid:
+			invalid = &snippets[i]
+		}
+	}
+	if ignored == nil || ignored.FilePath != "vendor/lib/lib.go" || ignored.Detail != "vendor/*/*" {
+		t.Errorf("expected the vendor snippet ignored, got %+v", snippets)
+	}
+	if invalid == nil || invalid.FilePath != "pkg/empty.go" || invalid.Detail != InvalidEmptyContent {
+		t.Errorf("expected the empty snippet invalid, got %+v", snippets)
+	}
+	if contributed := Contributed(snippets); contributed[ProviderDefinition] == 0 {
+		t.Errorf("unexpected contributions %v", contributed)
+	}
+}
@@ -0,0 +1,127 @@
+package codebase_context
+
+import (
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/store"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 检索服务的状态，用作响应的context_status和completion_context_provider_state_total的state标签
+const (
+	StateOK           = "ok"           // 最近一次检索正常返回(结果可能为空)
+	StateNotIndexed   = "not_indexed"  // 检索服务返回404，工作区还没有索引
+	StateUnauthorized = "unauthorized" // 检索服务返回401/403，插件没有转发有效的认证信息
+	StateTimeout      = "timeout"      // 单个请求或上下文获取总超时
+	StateError        = "error"        // 其他失败(5xx、连接失败、响应无法解析)
+)
+
+// 没有配置context.healthTTL时检索服务状态的缓存时间
+const defaultHealthTTL = time.Minute
+
+// 检索服务状态的键，每个客户端的每个项目分别记录
+type healthKey struct {
+	clientID    string
+	projectPath string
+	provider    string
+}
+
+/**
+ * 检索服务状态的缓存
+ * @description
+ * - 只根据补全(及预检)实际发出的检索请求的结果更新，不额外探测
+ * - 每个检索服务的状态单独过期，长时间没有检索的服务不再报告
+ * - 被测源文件的定义检索复用定义检索服务，记为definition
+ */
+type healthCache struct {
+	states *store.Store[healthKey, string]
+}
+
+func newHealthCache(ttl time.Duration, clock func() time.Time) *healthCache {
+	if ttl <= 0 {
+		ttl = defaultHealthTTL
+	}
+	return &healthCache{states: store.New(store.Options[healthKey, string]{
+		Name:       "context_health",
+		MaxEntries: 30000,
+		TTL:        ttl,
+		Clock:      clock,
+		Metadata:   true,
+	})}
+}
+
+// 记录一次检索的状态，state为空时不记录
+func (h *healthCache) record(clientID, projectPath, provider, state string) {
+	if h == nil || state == "" || clientID == "" || projectPath == "" {
+		return
+	}
+	metrics.IncrementContextProviderState(provider, state)
+	h.states.Put(healthKey{clientID, projectPath, provider}, state)
+}
+
+// 客户端项目的各检索服务最近的状态，都没有记录时返回nil
+func (h *healthCache) summary(clientID, projectPath string) map[string]string {
+	var states map[string]string
+	for _, provider := range []string{ProviderDefinition, ProviderSemantic, ProviderRelation} {
+		state, ok := h.states.Get(healthKey{clientID, projectPath, provider})
+		if !ok {
+			continue
+		}
+		if states == nil {
+			states = make(map[string]string)
+		}
+		states[provider] = state
+	}
+	return states
+}
+
+/**
+ * 按检索请求的结果判断检索服务的状态
+ * @param {context.Context} ctx - 检索请求的上下文
+ * @param {error} err - 检索请求返回的错误
+ * @returns {string} 返回检索服务的状态，请求被调用方取消(如客户端断开)时返回空字符串，不代表检索服务的状态
+ * @example
+ * state := providerState(ctx, &StatusError{StatusCode: 404})
+ * // state = "not_indexed"
+ */
+func providerState(ctx context.Context, err error) string {
+	var statusErr *StatusError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return StateOK
+	case errors.As(err, &statusErr):
+		switch statusErr.StatusCode {
+		case http.StatusNotFound:
+			return StateNotIndexed
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return StateUnauthorized
+		}
+		return StateError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return StateTimeout
+	case ctx.Err() != nil:
+		return ""
+	}
+	return StateError
+}
+
+/**
+ * 客户端项目的检索服务状态摘要，用于补全响应的context_status和预检
+ * @param {string} clientID - 客户端ID
+ * @param {string} projectPath - 项目路径
+ * @returns {map[string]string} 返回各检索服务(definition/semantic/relation)最近的状态，
+ *   缓存时间(context.healthTTL)内没有检索过的服务不包含在内，都没有时返回nil
+ * @example
+ * status := client.Health("client-id", "/project")
+ * // status = {"definition": "ok", "semantic": "not_indexed", "relation": "timeout"}
+ */
+func (c *ContextClient) Health(clientID, projectPath string) map[string]string {
+	if c == nil || c.health == nil || clientID == "" || projectPath == "" {
+		return nil
+	}
+	return c.health.summary(clientID, projectPath)
+}
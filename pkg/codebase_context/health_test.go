@@ -0,0 +1,106 @@
+package codebase_context
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+)
+
+// to test each upstream failure shape maps to the right provider state
+// go test ./pkg/codebase_context/ -v -run Test_ProviderHealth
+func Test_ProviderHealth(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/definition":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"message":"workspace not indexed"}`))
+		case "/semantic":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/relation":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	defer setupContextConfig(server.URL, time.Second)()
+	config.Context.Definition.Url = server.URL + "/definition"
+	config.Context.Semantic.Url = server.URL + "/semantic"
+	config.Context.Relation.Url = server.URL + "/relation"
+	config.Context.RequestTimeout = 50 * time.Millisecond
+
+	client := NewContextClient()
+	if client.Health("client", "/project") != nil {
+		t.Fatal("expected no states before any search")
+	}
+	client.RequestContext(context.Background(), "client", "/project", "/project/main.go",
+		[]string{"func main() {"}, []string{"main"}, http.Header{})
+	expected := map[string]string{ProviderDefinition: StateNotIndexed, ProviderSemantic: StateUnauthorized, ProviderRelation: StateTimeout}
+	status := client.Health("client", "/project")
+	for provider, state := range expected {
+		if status[provider] != state {
+			t.Errorf("%s: expected %s, got %v", provider, state, status)
+		}
+	}
+	// 状态按客户端和项目分别记录，读取状态不发起检索
+	requests := hits.Load()
+	if client.Health("client", "/other") != nil || client.Health("other", "/project") != nil {
+		t.Error("expected the states scoped to the client and project")
+	}
+	if hits.Load() != requests {
+		t.Error("expected no probing when reading the states")
+	}
+	_, err := client.Probe(context.Background(), ProviderDefinition, "client", "/project", "/project/main.go", "x", http.Header{})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Message != "workspace not indexed" {
+		t.Errorf("expected the upstream message parsed, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for err, state := range map[error]string{
+		nil:                           StateOK,
+		&StatusError{StatusCode: 403}: StateUnauthorized,
+		&StatusError{StatusCode: 502}: StateError,
+		context.DeadlineExceeded:      StateTimeout,
+		errors.New("connect: connection refused"): StateError,
+	} {
+		if got := providerState(context.Background(), err); got != state {
+			t.Errorf("%v: expected %s, got %s", err, state, got)
+		}
+	}
+	// 调用方取消(如客户端断开)不代表检索服务的状态
+	if got := providerState(canceled, context.Canceled); got != "" {
+		t.Errorf("expected no state for a canceled request, got %s", got)
+	}
+}
+
+// to test provider states are reused within the TTL and expire after it
+// go test ./pkg/codebase_context/ -v -run Test_ProviderHealthTTL
+func Test_ProviderHealthTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	h := newHealthCache(time.Minute, func() time.Time { return now })
+	h.record("client", "/project", ProviderSemantic, StateNotIndexed)
+	h.record("client", "/project", ProviderSourceUnderTest, "")
+
+	now = now.Add(30 * time.Second)
+	h.record("client", "/project", ProviderDefinition, StateOK)
+	if status := h.summary("client", "/project"); len(status) != 2 || status[ProviderSemantic] != StateNotIndexed {
+		t.Errorf("expected the cached states reused within the ttl, got %v", status)
+	}
+	// 每个检索服务的状态单独过期
+	now = now.Add(45 * time.Second)
+	if status := h.summary("client", "/project"); len(status) != 1 || status[ProviderDefinition] != StateOK {
+		t.Errorf("expected only the semantic state expired, got %v", status)
+	}
+	now = now.Add(time.Minute)
+	if status := h.summary("client", "/project"); status != nil {
+		t.Errorf("expected all states expired, got %v", status)
+	}
+}
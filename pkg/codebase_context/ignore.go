@@ -0,0 +1,53 @@
+package codebase_context
+
+import (
+	"code-completion/pkg/config"
+	"path"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 已记录过日志的无效忽略规则
+var invalidIgnoreRules sync.Map
+
+/**
+ * 检索片段所在的文件命中的忽略规则
+ * @param {string} filePath - 片段所在的文件，Windows路径按/处理
+ * @returns {string} 返回命中的第一条规则，没有命中时返回空
+ * @description
+ * - 规则见context.ignore，按path.Match匹配文件路径及其任意结尾部分(去掉开头的若干级目录)
+ * - 无效的规则不生效，只记录一次日志
+ * @example
+ * config.Context.Ignore = []string{"vendor/*"}
+ * rule := ignoredBy("/project/vendor/lib.go")
+ * // rule = "vendor/*"
+ */
+func ignoredBy(filePath string) string {
+	if len(config.Context.Ignore) == 0 || filePath == "" {
+		return ""
+	}
+	p := strings.ReplaceAll(filePath, "\\", "/")
+	for _, rule := range config.Context.Ignore {
+		for sub := p; ; {
+			matched, err := path.Match(rule, sub)
+			if err != nil {
+				if _, logged := invalidIgnoreRules.LoadOrStore(rule, true); !logged {
+					zap.L().Error("Invalid config: 'context.ignore' contains invalid rule",
+						zap.String("rule", rule), zap.Error(err))
+				}
+				break
+			}
+			if matched {
+				return rule
+			}
+			i := strings.IndexByte(sub, '/')
+			if i < 0 {
+				break
+			}
+			sub = sub[i+1:]
+		}
+	}
+	return ""
+}
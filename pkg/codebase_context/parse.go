@@ -17,6 +17,7 @@ type ParsedDefinitionResult struct {
 	Name     string
 	FilePath string
 	Content  string
+	Score    float64
 }
 
 // ParsedRelationResult 解析后的关系搜索结果
@@ -26,42 +27,27 @@ type ParsedRelationResult struct {
 	Score    float64
 }
 
-// parseSemantic 解析语义检索结果
-func parseSemantic(data []*ResponseData) []ParsedSemanticResult {
-	if len(data) == 0 {
+// parseSemantic 解析语义检索结果，items为校验过的检索结果
+func parseSemantic(items []SearchItem) []ParsedSemanticResult {
+	if len(items) == 0 {
 		return nil
 	}
 
 	var result []ParsedSemanticResult
 	contextSet := make(map[string]bool)
 
-	for _, item := range data {
-		if item == nil {
+	for _, semantic := range items {
+		// 获取相似代码信息
+		if contextSet[semantic.Content] {
 			continue
 		}
 
-		semanticList := item.Data.List
-		for _, semantic := range semanticList {
-			if semantic == nil {
-				continue
-			}
-
-			// 获取相似代码信息
-			content := getStringValue(semantic, "content")
-			if content == "" || contextSet[content] {
-				continue
-			}
-
-			contextSet[content] = true
-			filePath := getStringValue(semantic, "filePath")
-			score := getFloat64Value(semantic, "score")
-
-			result = append(result, ParsedSemanticResult{
-				FilePath: filePath,
-				Content:  content,
-				Score:    score,
-			})
-		}
+		contextSet[semantic.Content] = true
+		result = append(result, ParsedSemanticResult{
+			FilePath: semantic.FilePath,
+			Content:  semantic.Content,
+			Score:    semantic.Score,
+		})
 	}
 
 	// 按分数从高到低排序
@@ -78,57 +64,40 @@ func parseSemantic(data []*ResponseData) []ParsedSemanticResult {
 	return result
 }
 
-// parseDefinition 解析定义检索结果
-func parseDefinition(data []*ResponseData) []ParsedDefinitionResult {
-	if len(data) == 0 {
+// parseDefinition 解析定义检索结果，items为校验过的检索结果
+func parseDefinition(items []SearchItem) []ParsedDefinitionResult {
+	if len(items) == 0 {
 		return nil
 	}
 
 	var result []ParsedDefinitionResult
 	contextSet := make(map[string]bool)
 
-	for _, item := range data {
-		if item == nil {
-			continue
+	for _, defItem := range items {
+		// 根据类型处理内容
+		content := defItem.Content
+		switch defItem.Type {
+		case "definition.method", "definition.function", "declaration.method", "declaration.function":
+			content = sliceBeforeNthInstance(content, "\n", 20)
+		case "definition.class", "definition.struct", "declaration.struct", "declaration.class":
+			content = sliceBeforeNthInstance(content, "\n", 50)
+		default:
+			content = sliceBeforeNthInstance(content, "\n", 10)
 		}
 
-		defList := item.Data.List
-		for _, defItem := range defList {
-			if defItem == nil {
-				continue
-			}
-
-			// 提取filePath, name, content
-			filePath := getStringValue(defItem, "filePath")
-			name := getStringValue(defItem, "name")
-			content := getStringValue(defItem, "content")
-			defType := getStringValue(defItem, "type")
-
-			// 根据类型处理内容
-			if content != "" {
-				switch defType {
-				case "definition.method", "definition.function", "declaration.method", "declaration.function":
-					content = sliceBeforeNthInstance(content, "\n", 20)
-				case "definition.class", "definition.struct", "declaration.struct", "declaration.class":
-					content = sliceBeforeNthInstance(content, "\n", 50)
-				default:
-					content = sliceBeforeNthInstance(content, "\n", 10)
-				}
-			}
-
-			// 去重
-			key := fmt.Sprintf("%s:%s", filePath, name)
-			if contextSet[key] {
-				continue
-			}
-
-			contextSet[key] = true
-			result = append(result, ParsedDefinitionResult{
-				Name:     name,
-				FilePath: filePath,
-				Content:  content,
-			})
+		// 去重
+		key := fmt.Sprintf("%s:%s", defItem.FilePath, defItem.Name)
+		if contextSet[key] {
+			continue
 		}
+
+		contextSet[key] = true
+		result = append(result, ParsedDefinitionResult{
+			Name:     defItem.Name,
+			FilePath: defItem.FilePath,
+			Content:  content,
+			Score:    defItem.Score,
+		})
 	}
 
 	// // 转换为字符串数组格式
@@ -140,35 +109,20 @@ func parseDefinition(data []*ResponseData) []ParsedDefinitionResult {
 	return result
 }
 
-// parseRelation 解析关系检索结果
-func parseRelation(data []*ResponseData) []ParsedRelationResult {
-	if len(data) == 0 {
+// parseRelation 解析关系检索结果，items为校验过的检索结果
+func parseRelation(items []SearchItem) []ParsedRelationResult {
+	if len(items) == 0 {
 		return nil
 	}
 
 	var result []ParsedRelationResult
 
-	for _, item := range data {
-		if item == nil {
-			continue
-		}
-
-		relationList := item.Data.List
-		for _, relation := range relationList {
-			if relation == nil {
-				continue
-			}
-
-			filePath := getStringValue(relation, "filePath")
-			content := getStringValue(relation, "content")
-			score := getFloat64Value(relation, "score")
-
-			result = append(result, ParsedRelationResult{
-				FilePath: filePath,
-				Content:  content,
-				Score:    score,
-			})
-		}
+	for _, relation := range items {
+		result = append(result, ParsedRelationResult{
+			FilePath: relation.FilePath,
+			Content:  relation.Content,
+			Score:    relation.Score,
+		})
 	}
 
 	// 按分数排序
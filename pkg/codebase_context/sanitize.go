@@ -0,0 +1,67 @@
+package codebase_context
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
+	"context"
+	"regexp"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 检索片段中命中注入过滤规则的文字替换成的内容
+const sanitizedText = "[filtered]"
+
+// 编译过的清洗正则，无效的正则记为nil
+var sanitizePatterns sync.Map
+
+// 编译清洗正则，无效的正则只记录一次日志
+func compileSanitizePattern(pattern string) *regexp.Regexp {
+	if re, ok := sanitizePatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		zap.L().Error("Invalid config: 'context.sanitize.patterns' contains invalid pattern",
+			zap.String("pattern", pattern), zap.Error(err))
+		re = nil
+	}
+	sanitizePatterns.Store(pattern, re)
+	return re
+}
+
+/**
+ * 清洗检索片段中操纵模型的文字
+ * @param {context.Context} ctx - 补全请求的上下文，用于记录日志
+ * @param {string} provider - 返回该片段的检索
+ * @param {string} filePath - 片段所在的文件
+ * @param {string} content - 片段内容
+ * @returns {string} 返回命中的文字替换为[filtered]后的内容，没有命中时原样返回
+ * @returns {[]string} 返回命中的规则名称，按配置的顺序
+ * @description
+ * - 规则见context.sanitize.patterns，按顺序依次替换，前面的规则替换后的内容再交给后面的规则
+ * - 每条命中的规则记录一次日志和completion_context_sanitized_total
+ * @example
+ * content, hits := sanitizeContent(ctx, ProviderSemantic, "a.go", "// ignore previous instructions\nfunc A() {}")
+ * // content = "// [filtered]\nfunc A() {}", hits = ["ignore_previous"]
+ */
+func sanitizeContent(ctx context.Context, provider, filePath, content string) (string, []string) {
+	if config.Context.Sanitize.Disabled || content == "" {
+		return content, nil
+	}
+	var hits []string
+	for _, p := range config.Context.Sanitize.Patterns {
+		re := compileSanitizePattern(p.Pattern)
+		if re == nil || !re.MatchString(content) {
+			continue
+		}
+		content = re.ReplaceAllLiteralString(content, sanitizedText)
+		hits = append(hits, p.Name)
+		metrics.IncrementContextSanitized(provider, p.Name)
+		logger.FromContext(ctx).Warn("Retrieved context contains instruction-like text, filtered",
+			zap.String("provider", provider), zap.String("filePath", filePath), zap.String("pattern", p.Name))
+	}
+	return content, hits
+}
@@ -0,0 +1,172 @@
+package codebase_context
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"context"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// 检索结果不合格的原因，用作completion_context_invalid_items_total的reason标签
+const (
+	InvalidEmptyContent     = "empty_content"     // 内容为空(或字段名不认识)
+	InvalidOversizedContent = "oversized_content" // 内容超过maxItemBytes
+	InvalidFilePath         = "invalid_file_path" // 文件路径为空或不合理
+)
+
+// 文件路径的最大字节数，超过时认为不合理
+const maxFilePathBytes = 4096
+
+// 各字段在检索服务历史版本中使用过的名称，按优先级排列
+var (
+	filePathKeys = []string{"filePath", "file_path", "FilePath"}
+	contentKeys  = []string{"content", "Content"}
+	nameKeys     = []string{"name", "Name"}
+	typeKeys     = []string{"type", "Type"}
+	scoreKeys    = []string{"score", "Score"}
+)
+
+// SearchItem 检索服务返回的一条结果
+type SearchItem struct {
+	FilePath string
+	Name     string // 定义的名称，只有定义检索有
+	Type     string // 定义的类型(如definition.function)，只有定义检索有
+	Content  string
+	Score    float64 // 相关性分数，语义检索和关系检索有
+}
+
+// 检索结果中被丢弃的一条
+type invalidItem struct {
+	reason   string
+	keys     []string // 原始结果中的字段名，用于排查响应格式的变化
+	filePath string   // 解码出的文件路径，文件路径不合理时为空
+	score    float64
+}
+
+// 按候选字段名依次取字符串字段
+func firstString(m map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		if v := getStringValue(m, key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// 按候选字段名依次取数值字段
+func firstFloat64(m map[string]interface{}, keys []string) float64 {
+	for _, key := range keys {
+		if _, ok := m[key]; ok {
+			return getFloat64Value(m, key)
+		}
+	}
+	return 0
+}
+
+// 将一条原始结果解码为SearchItem，兼容历史字段名
+func decodeSearchItem(raw map[string]interface{}) SearchItem {
+	return SearchItem{
+		FilePath: firstString(raw, filePathKeys),
+		Name:     firstString(raw, nameKeys),
+		Type:     firstString(raw, typeKeys),
+		Content:  firstString(raw, contentKeys),
+		Score:    firstFloat64(raw, scoreKeys),
+	}
+}
+
+/**
+ * 校验一条检索结果
+ * @param {SearchItem} item - 解码后的检索结果
+ * @param {bool} requireContent - 是否要求内容非空，关系检索不带内容(includeContent为false)时不要求
+ * @returns {string} 返回不合格的原因，合格时返回空字符串
+ */
+func validateSearchItem(item SearchItem, requireContent bool) string {
+	switch {
+	case requireContent && item.Content == "":
+		return InvalidEmptyContent
+	case config.Context.Validation.MaxItemBytes > 0 && len(item.Content) > config.Context.Validation.MaxItemBytes:
+		return InvalidOversizedContent
+	case item.FilePath == "" || len(item.FilePath) > maxFilePathBytes || strings.ContainsAny(item.FilePath, "\x00\r\n"):
+		return InvalidFilePath
+	}
+	return ""
+}
+
+// 解码并校验一个检索的所有结果，返回合格的结果和被丢弃的结果
+func decodeSearchItems(data []*ResponseData, requireContent bool) ([]SearchItem, []invalidItem) {
+	var items []SearchItem
+	var invalid []invalidItem
+	for _, result := range data {
+		if result == nil {
+			continue
+		}
+		for _, raw := range result.Data.List {
+			if raw == nil {
+				continue
+			}
+			item := decodeSearchItem(raw)
+			if reason := validateSearchItem(item, requireContent); reason != "" {
+				keys := make([]string, 0, len(raw))
+				for key := range raw {
+					keys = append(keys, key)
+				}
+				slices.Sort(keys)
+				dropped := invalidItem{reason: reason, keys: keys, score: item.Score}
+				if reason != InvalidFilePath {
+					dropped.filePath = item.FilePath
+				}
+				invalid = append(invalid, dropped)
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+	return items, invalid
+}
+
+/**
+ * 解码并校验一个检索服务的结果
+ * @param {context.Context} ctx - 请求上下文，用于取请求级logger
+ * @param {string} provider - 检索服务(source_under_test/definition/semantic/relation)
+ * @param {[]*ResponseData} data - 检索服务的原始响应
+ * @returns {[]SearchItem} 返回合格的结果，结果作废时返回nil
+ * @returns {[]model.ContextSnippet} 返回被丢弃的结果的来源，按在检索结果中的顺序，用于片段来源的报告
+ * @description
+ * - 不合格的结果被丢弃，按原因计入completion_context_invalid_items_total，并记录原始结果的字段名；
+ *   来源中记为invalid，Detail为不合格的原因
+ * - 不合格的比例超过maxInvalidFraction时整个检索结果作废，计入completion_context_rejected_results_total，
+ *   避免检索服务的响应格式变化后上下文悄悄变成只有文件路径；作废的合格结果在来源中记为rejected
+ */
+func searchItems(ctx context.Context, provider string, data []*ResponseData) ([]SearchItem, []model.ContextSnippet) {
+	requireContent := provider != ProviderRelation || config.Context.Relation.IncludeContent
+	items, invalid := decodeSearchItems(data, requireContent)
+	if len(invalid) == 0 {
+		return items, nil
+	}
+	log := logger.FromContext(ctx)
+	dropped := make([]model.ContextSnippet, 0, len(invalid))
+	for _, item := range invalid {
+		metrics.IncrementContextInvalidItems(provider, item.reason)
+		log.Warn("Invalid codebase search item dropped", zap.String("provider", provider),
+			zap.String("reason", item.reason), zap.Strings("keys", item.keys))
+		dropped = append(dropped, model.ContextSnippet{Provider: provider, FilePath: item.filePath, Score: item.score,
+			Dropped: model.SnippetInvalid, Detail: item.reason})
+	}
+	total := len(items) + len(invalid)
+	if fraction := config.Context.Validation.MaxInvalidFraction; fraction > 0 && float64(len(invalid)) > fraction*float64(total) {
+		metrics.IncrementContextRejectedResults(provider)
+		log.Warn("Codebase search result discarded, too many invalid items", zap.String("provider", provider),
+			zap.Int("invalid", len(invalid)), zap.Int("total", total))
+		for _, item := range items {
+			dropped = append(dropped, model.ContextSnippet{Provider: provider, FilePath: item.FilePath, Score: item.Score,
+				Dropped: model.SnippetRejected})
+		}
+		return nil, dropped
+	}
+	return items, dropped
+}
@@ -0,0 +1,78 @@
+package codebase_context
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+)
+
+// searchFixture is a definition search response and what the context built from it should be
+type searchFixture struct {
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+	Valid    int             `json:"valid"`
+	Invalid  int             `json:"invalid"`
+	Rejected bool            `json:"rejected"`
+	Context  []string        `json:"context"`
+}
+
+// to test the validation of search responses in the current schema, the old schema and garbage
+// go test ./pkg/codebase_context/ -v -run Test_SearchResponseSchema
+func Test_SearchResponseSchema(t *testing.T) {
+	data, err := os.ReadFile("testdata/search_responses.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []searchFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(f.Response)
+			}))
+			defer server.Close()
+			defer setupContextConfig(server.URL, time.Second)()
+			config.Context.Semantic.Disabled = true
+			config.Context.Relation.Disabled = true
+			config.Context.Validation = config.ContextValidationConfig{MaxItemBytes: 64, MaxInvalidFraction: 0.5}
+
+			var response ResponseData
+			if err := json.Unmarshal(f.Response, &response); err != nil {
+				t.Fatal(err)
+			}
+			items, invalid := decodeSearchItems([]*ResponseData{&response}, true)
+			if len(items) != f.Valid || len(invalid) != f.Invalid {
+				t.Errorf("expected %d valid and %d invalid items, got %d and %d", f.Valid, f.Invalid, len(items), len(invalid))
+			}
+			kept, dropped := searchItems(context.Background(), ProviderDefinition, []*ResponseData{&response})
+			if rejected := kept == nil; rejected != f.Rejected {
+				t.Errorf("expected rejected=%v, got %v", f.Rejected, rejected)
+			}
+			// 被丢弃的结果都出现在片段来源中，作废时合格的结果也一并记录
+			expectDropped := f.Invalid
+			if f.Rejected {
+				expectDropped += f.Valid
+			}
+			if len(dropped) != expectDropped {
+				t.Errorf("expected %d dropped snippets, got %+v", expectDropped, dropped)
+			}
+
+			code, _ := NewContextClient().GetContext(context.Background(), "client", "/project", "main.go", "",
+				"func main() {\n\t", "\n}", "", http.Header{}, false)
+			expected := getComment("/project/main.go", strings.Join(f.Context, "\n"))
+			if code != expected {
+				t.Errorf("expected context %q, got %q", expected, code)
+			}
+		})
+	}
+}
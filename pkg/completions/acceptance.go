@@ -0,0 +1,212 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"errors"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// 部分采纳反馈无效的原因
+var (
+	ErrAcceptanceNegative = errors.New("negative accepted count")
+	ErrAcceptanceTooLong  = errors.New("accepted count exceeds the served completion")
+)
+
+/**
+ * 上一次补全的采纳结果，由下一次请求的反馈得到
+ * @description
+ * - CompletionID: 被反馈的补全的completion_id
+ * - Fraction: 采纳比例(0~1)
+ * - Accepted: 是否视为采纳，部分采纳按PartialThreshold判断，与重新给出的previous_label相同
+ */
+type AcceptanceFeedback struct {
+	CompletionID string
+	Fraction     float64
+	Accepted     bool
+	tuned        *tunedScore // 被反馈的补全参与阈值调整时的语言和隐藏分
+}
+
+// 返回给客户端、等待下一次请求告知采纳结果的补全
+type servedCompletion struct {
+	id       string // 补全的completion_id
+	client   string // 返回给的客户端，反馈的客户端不同时不处理
+	model    string
+	language string
+	chars    int         // 补全内容的字符数(按unicode字符计)
+	lines    int         // 补全内容的行数
+	mode     string      // 代码上下文的使用方式，见CompletionInput.ContextMode
+	variant  string      // 计算隐藏分使用的权重变体
+	tuned    *tunedScore // 参与阈值调整时的语言和隐藏分，见HiddenScoreFilter.Judge
+}
+
+var (
+	servedOnce sync.Once
+	served     *store.Store[string, *servedCompletion]
+	latest     *store.Store[string, string]
+)
+
+// 等待反馈的补全，key为completion_id，同一客户端并发的补全互不覆盖；首次使用时按配置创建
+func servedStore() *store.Store[string, *servedCompletion] {
+	servedOnce.Do(initServedStores)
+	return served
+}
+
+// 各客户端最近一次返回的补全的completion_id，用于没有给出previous_completion_id的反馈
+func latestStore() *store.Store[string, string] {
+	servedOnce.Do(initServedStores)
+	return latest
+}
+
+func initServedStores() {
+	served = store.New(store.Options[string, *servedCompletion]{
+		Name:       "served_completions",
+		MaxEntries: config.Wrapper.Acceptance.MaxClients,
+		TTL:        config.Wrapper.Acceptance.TTL,
+		Metadata:   true, // 只有补全内容的字符数和行数
+	})
+	latest = store.New(store.Options[string, string]{
+		Name:       "latest_served_completions",
+		MaxEntries: config.Wrapper.Acceptance.MaxClients,
+		TTL:        config.Wrapper.Acceptance.TTL,
+		Metadata:   true, // 只有completion_id
+	})
+}
+
+/**
+ * 丢弃返回给客户端的补全，不再用于统计采纳结果
+ * @param {string} clientID - 客户端ID
+ * @param {string} completionID - 作废的补全的completion_id，不是返回给该客户端的补全时不处理
+ * @returns {bool} 返回是否丢弃了记录
+ */
+func ForgetServed(clientID, completionID string) bool {
+	s, ok := servedStore().Get(completionID)
+	if !ok || s.client != clientID {
+		return false
+	}
+	return servedStore().Delete(completionID)
+}
+
+// 补全内容的字符数和行数，末尾的换行不计为新的一行
+func completionSize(text string) (int, int) {
+	return utf8.RuneCountInString(text), strings.Count(strings.TrimRight(text, "\n"), "\n") + 1
+}
+
+/**
+ * 计算上一次补全的采纳比例
+ * @param {*servedCompletion} s - 上一次返回的补全
+ * @param {*HiddenScoreOptions} scores - 携带previous_label和部分采纳计数的隐藏分参数
+ * @returns {float64, bool} 返回采纳比例(0~1)，以及是否为部分采纳的反馈
+ * @returns {error} 计数为负或超过补全的长度(比例大于1)时返回错误
+ * @description
+ * - 提供了字符数时按字符数计算，只提供行数时按行数计算
+ * - 两者都提供时都要校验
+ * - 都没有提供时按previous_label，采纳为1，否则为0
+ * @example
+ * acceptanceFraction(&servedCompletion{chars: 40, lines: 4}, &HiddenScoreOptions{AcceptedLineCount: &one})
+ * // 0.25, true, nil
+ */
+func acceptanceFraction(s *servedCompletion, scores *HiddenScoreOptions) (float64, bool, error) {
+	chars, lines := scores.AcceptedCharCount, scores.AcceptedLineCount
+	if chars == nil && lines == nil {
+		if scores.PreviousLabel == 1 {
+			return 1, false, nil
+		}
+		return 0, false, nil
+	}
+	if (chars != nil && *chars < 0) || (lines != nil && *lines < 0) {
+		return 0, true, ErrAcceptanceNegative
+	}
+	if (chars != nil && *chars > s.chars) || (lines != nil && *lines > s.lines) {
+		return 0, true, ErrAcceptanceTooLong
+	}
+	if chars != nil {
+		return float64(*chars) / float64(s.chars), true, nil
+	}
+	return float64(*lines) / float64(s.lines), true, nil
+}
+
+/**
+ * 处理上一次补全的采纳反馈
+ * @param {*CompletionContext} c - 补全上下文
+ * @description
+ * - 按previous_completion_id指定的补全计算采纳比例，没有指定时为该客户端最近返回的补全，
+ *   记录到按模型、语言和隐藏分权重变体的指标，以及按代码上下文使用方式的指标
+ * - 只消费被反馈的补全，该客户端并发返回的其他补全保留，等待各自的反馈
+ * - 部分采纳的反馈有效时，按PartialThreshold重新给出previous_label，供隐藏分和阈值自动调整使用
+ * - 无效的部分采纳反馈被忽略，previous_label保持插件给出的值
+ * - 每个返回的补全只处理一次反馈，有效的反馈记录到Feedback，用于关联补全样本
+ */
+func (in *CompletionInput) applyAcceptance(c *CompletionContext) {
+	cfg := &config.Wrapper.Acceptance
+	if !c.Enabled(feature_flag.Acceptance) || in.internal() || in.HideScores == nil || in.ClientID == "" {
+		return
+	}
+	id := in.HideScores.PreviousCompletionID
+	if id == "" {
+		var ok bool
+		if id, ok = latestStore().Get(in.ClientID); !ok {
+			return
+		}
+	}
+	s, ok := servedStore().Get(id)
+	// 并发的反馈只有一个能删除记录，每个补全只处理一次
+	if !ok || s.client != in.ClientID || !servedStore().Delete(id) {
+		return
+	}
+	fraction, partial, err := acceptanceFraction(s, in.HideScores)
+	if err != nil {
+		c.Log().Debug("Invalid partial acceptance ignored", zap.Error(err), zap.Int("servedChars", s.chars),
+			zap.Int("servedLines", s.lines))
+		reason := "too_long"
+		if errors.Is(err, ErrAcceptanceNegative) {
+			reason = "negative"
+		}
+		metrics.IncrementAcceptanceInvalid(reason)
+		return
+	}
+	metrics.ObserveAcceptance(s.model, languageLabel(s.language), s.variant, fraction)
+	if s.mode != "" {
+		metrics.ObserveContextModeAcceptance(s.mode, fraction)
+	}
+	if partial {
+		in.HideScores.PreviousLabel = 0
+		if fraction >= cfg.PartialThreshold {
+			in.HideScores.PreviousLabel = 1
+		}
+	}
+	in.Feedback = &AcceptanceFeedback{CompletionID: s.id, Fraction: fraction, Accepted: in.HideScores.PreviousLabel == 1,
+		tuned: s.tuned}
+}
+
+// 记录返回给客户端的补全，等待下一次请求告知采纳结果，flags为请求的功能开关
+func (in *CompletionInput) TrackServed(flags *feature_flag.Evaluator, rsp *CompletionResponse) {
+	if !flags.Enabled(feature_flag.Acceptance) || in.internal() || rsp == nil || in.ClientID == "" || in.CompletionID == "" ||
+		rsp.Status != model.StatusSuccess || len(rsp.Choices) == 0 || rsp.Choices[0].Text == "" {
+		return
+	}
+	chars, lines := completionSize(rsp.Choices[0].Text)
+	variant := in.ScoreVariant
+	if variant == "" {
+		variant = NoScoreVariant
+	}
+	servedStore().Put(in.CompletionID, &servedCompletion{
+		id:       in.CompletionID,
+		client:   in.ClientID,
+		model:    rsp.Model,
+		language: in.EffectiveLanguage(),
+		chars:    chars,
+		lines:    lines,
+		mode:     in.ContextMode,
+		variant:  variant,
+		tuned:    in.tuned,
+	})
+	latestStore().Put(in.ClientID, in.CompletionID)
+}
@@ -0,0 +1,171 @@
+package completions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+// to test validation of partial acceptance against the served completion
+// go test ./pkg/completions/ -v -run Test_AcceptanceFraction
+func Test_AcceptanceFraction(t *testing.T) {
+	s := &servedCompletion{chars: 40, lines: 4}
+	cases := []struct {
+		name     string
+		scores   HiddenScoreOptions
+		fraction float64
+		partial  bool
+		err      error
+	}{
+		{"boolean accepted", HiddenScoreOptions{PreviousLabel: 1}, 1, false, nil},
+		{"boolean rejected", HiddenScoreOptions{}, 0, false, nil},
+		{"first line", HiddenScoreOptions{AcceptedLineCount: intPtr(1)}, 0.25, true, nil},
+		{"chars preferred", HiddenScoreOptions{AcceptedCharCount: intPtr(30), AcceptedLineCount: intPtr(1)}, 0.75, true, nil},
+		{"all chars", HiddenScoreOptions{AcceptedCharCount: intPtr(40)}, 1, true, nil},
+		{"too many chars", HiddenScoreOptions{AcceptedCharCount: intPtr(41)}, 0, true, ErrAcceptanceTooLong},
+		{"too many lines", HiddenScoreOptions{AcceptedCharCount: intPtr(10), AcceptedLineCount: intPtr(5)}, 0, true, ErrAcceptanceTooLong},
+		{"negative", HiddenScoreOptions{AcceptedCharCount: intPtr(-1)}, 0, true, ErrAcceptanceNegative},
+	}
+	for _, tc := range cases {
+		fraction, partial, err := acceptanceFraction(s, &tc.scores)
+		if fraction != tc.fraction || partial != tc.partial || !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v/%v/%v, got %v/%v/%v", tc.name, tc.fraction, tc.partial, tc.err, fraction, partial, err)
+		}
+	}
+	if chars, lines := completionSize("if ok {\n\treturn\n}\n"); chars != 18 || lines != 3 {
+		t.Errorf("unexpected completion size %d/%d", chars, lines)
+	}
+}
+
+// 指标中某个模型和语言的采纳比例样本数
+func acceptanceSamples(t *testing.T, modelName, language string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "completion_acceptance_fraction" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["model"] == modelName && labels["language"] == language {
+				return m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+// to test the previous_label derived from partial acceptance and the metric emission
+// go test ./pkg/completions/ -v -run Test_AcceptancePreviousLabel
+func Test_AcceptancePreviousLabel(t *testing.T) {
+	saved := config.Wrapper.Acceptance
+	defer func() { config.Wrapper.Acceptance = saved }()
+	config.Wrapper.Acceptance.PartialThreshold = 0.5
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	seq := 0
+	serve := func(clientID string) {
+		seq++
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: clientID, CompletionID: fmt.Sprintf("cmpl-%d", seq), LanguageID: "go"}}
+		in.TrackServed(nil, &CompletionResponse{Model: "accept-model", Status: model.StatusSuccess,
+			Choices: []CompletionChoice{{Text: "a := 1\nb := 2\nc := 3\nd := 4"}}})
+	}
+	feedback := func(clientID string, scores HiddenScoreOptions) int {
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: clientID, HideScores: &scores}}
+		in.applyAcceptance(c)
+		return in.HideScores.PreviousLabel
+	}
+
+	before := acceptanceSamples(t, "accept-model", "go")
+	serve("accept-1")
+	if label := feedback("accept-1", HiddenScoreOptions{PreviousLabel: 1, AcceptedLineCount: intPtr(1)}); label != 0 {
+		t.Errorf("expected one of four lines below the threshold, got label %d", label)
+	}
+	serve("accept-1")
+	if label := feedback("accept-1", HiddenScoreOptions{AcceptedLineCount: intPtr(3)}); label != 1 {
+		t.Errorf("expected three of four lines above the threshold, got label %d", label)
+	}
+	serve("accept-1")
+	if label := feedback("accept-1", HiddenScoreOptions{PreviousLabel: 1}); label != 1 {
+		t.Errorf("expected the boolean payload kept, got label %d", label)
+	}
+	// 超过补全长度的反馈被忽略，不记录指标
+	serve("accept-1")
+	if label := feedback("accept-1", HiddenScoreOptions{PreviousLabel: 1, AcceptedLineCount: intPtr(5)}); label != 1 {
+		t.Errorf("expected invalid feedback ignored, got label %d", label)
+	}
+	// 每个补全只处理一次反馈
+	if label := feedback("accept-1", HiddenScoreOptions{AcceptedLineCount: intPtr(3)}); label != 0 {
+		t.Errorf("expected no served completion left, got label %d", label)
+	}
+	if n := acceptanceSamples(t, "accept-model", "go") - before; n != 3 {
+		t.Errorf("expected 3 acceptance samples, got %d", n)
+	}
+
+	// 有效的反馈记录被反馈的补全及结果，用于关联补全样本
+	served := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "accept-1", CompletionID: "cmpl-served", LanguageID: "go"}}
+	served.TrackServed(nil, &CompletionResponse{Model: "accept-model", Status: model.StatusSuccess,
+		Choices: []CompletionChoice{{Text: "a := 1\nb := 2"}}})
+	in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "accept-1",
+		HideScores: &HiddenScoreOptions{AcceptedLineCount: intPtr(1)}}}
+	in.applyAcceptance(c)
+	if f := in.Feedback; f == nil || f.CompletionID != "cmpl-served" || f.Fraction != 0.5 || !f.Accepted {
+		t.Errorf("expected the feedback of cmpl-served recorded, got %+v", f)
+	}
+
+	config.Wrapper.Acceptance.PartialThreshold = 0.2
+	serve("accept-2")
+	if label := feedback("accept-2", HiddenScoreOptions{AcceptedLineCount: intPtr(1)}); label != 1 {
+		t.Errorf("expected a lower threshold to count the first line, got label %d", label)
+	}
+}
+
+// to test that concurrent completions of a client wait for their own feedback and unknown languages share one label
+// go test ./pkg/completions/ -v -run Test_AcceptanceByCompletion
+func Test_AcceptanceByCompletion(t *testing.T) {
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	serve := func(completionID, language string) {
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "accept-3", CompletionID: completionID, LanguageID: language}}
+		in.TrackServed(nil, &CompletionResponse{Model: "accept-model", Status: model.StatusSuccess,
+			Choices: []CompletionChoice{{Text: "a := 1\nb := 2"}}})
+	}
+	feedback := func(clientID, completionID string) *AcceptanceFeedback {
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: clientID,
+			HideScores: &HiddenScoreOptions{PreviousLabel: 1, PreviousCompletionID: completionID}}}
+		in.applyAcceptance(c)
+		return in.Feedback
+	}
+
+	before := acceptanceSamples(t, "accept-model", metrics.LabelOther)
+	serve("cmpl-a", "go")
+	serve("cmpl-b", "not-a-language")
+	if f := feedback("accept-4", "cmpl-a"); f != nil {
+		t.Errorf("expected feedback from another client ignored, got %+v", f)
+	}
+	if f := feedback("accept-3", "cmpl-a"); f == nil || f.CompletionID != "cmpl-a" {
+		t.Errorf("expected the feedback of cmpl-a, got %+v", f)
+	}
+	// cmpl-a的反馈没有删除同一客户端的cmpl-b
+	if f := feedback("accept-3", ""); f == nil || f.CompletionID != "cmpl-b" {
+		t.Errorf("expected the latest completion cmpl-b kept, got %+v", f)
+	}
+	if n := acceptanceSamples(t, "accept-model", metrics.LabelOther) - before; n != 1 {
+		t.Errorf("expected the unknown language recorded as %s, got %d samples", metrics.LabelOther, n)
+	}
+}
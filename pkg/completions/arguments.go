@@ -0,0 +1,351 @@
+package completions
+
+import (
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/model"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+/**
+ * 语言的参数列表语法，为nil的语言不识别光标所在的参数列表
+ * @description
+ * - Assign: 命名参数(或字段)的赋值符号，python/kotlin为"="，go/rust结构体字面量、c#、swift为":"，为空表示只有位置参数
+ * - StructBrace: "{"也可以是参数列表(go的Point{X: 1}、rust的Point { x: 1 })
+ */
+type ArgumentSyntax struct {
+	Assign      string
+	StructBrace bool
+}
+
+// 只有位置参数的语言
+var positionalArguments = &ArgumentSyntax{}
+
+// 扫描参数列表时向前(向后)查看的最大字节数
+const maxArgumentScan = 4096
+
+// 不是调用的括号前的关键字，如if (...)、for x in (...)
+var nonCallKeywords = map[string]bool{
+	"if": true, "elif": true, "for": true, "while": true, "switch": true, "catch": true, "return": true,
+	"and": true, "or": true, "not": true, "in": true, "with": true, "select": true, "case": true,
+	"func": true, "fn": true, "def": true, "struct": true, "interface": true, "else": true, "match": true,
+}
+
+// 大括号是代码块而不是结构体字面量的行，如if x {、func f() {
+var blockLine = regexp.MustCompile(`^\s*(?:\}\s*)?(?:if|for|switch|select|else|func|type|case|default|go|defer|fn|impl|match|loop|while|mod|trait|enum|struct|union)\b`)
+
+/**
+ * 识别光标所在的调用参数列表(或结构体字面量)
+ * @param {string} language - 光标处的语言
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @returns {*model.ArgumentList} 返回光标所在的参数列表，不在调用中时返回nil
+ * @description
+ * - IsCursorInParentheses判断光标在括号中后，找到前缀中最内层未闭合的"("(或结构体字面量的"{")，
+ *   括号前必须是标识符(或")"、"]"、">")，不能是if/for等关键字
+ * - 在后缀中找到匹配的闭合括号，后缀截断时保留到闭合括号为止(End)
+ * - 收集前缀和后缀中已有参数的名称，用于裁剪补全中重复的命名参数
+ * - 扫描时跳过字符串和行注释，只查看光标前后maxArgumentScan字节
+ * @example
+ * detectArguments("python", "plot(x, color=", ", label='a')\nshow()")
+ * // &model.ArgumentList{Open: "(", Close: ")", Assign: "=", Names: ["color", "label"], End: 12, Continues: true}
+ */
+func detectArguments(language, prefix, suffix string) *model.ArgumentList {
+	profile := profileOf(language)
+	syntax := profile.Arguments
+	if syntax == nil || !IsCursorInParentheses(prefix, suffix) {
+		return nil
+	}
+	quotes := quotesOf(language)
+	lineComment := profile.Comment.Line
+
+	// 前缀中最内层未闭合的括号
+	start := 0
+	if len(prefix) > maxArgumentScan {
+		start = len(prefix) - maxArgumentScan
+		if idx := strings.IndexByte(prefix[start:], '\n'); idx >= 0 {
+			start += idx + 1
+		}
+	}
+	var opens []int
+	scanCode(prefix[start:], quotes, lineComment, func(i int, ch byte) bool {
+		switch ch {
+		case '(', '[', '{':
+			opens = append(opens, start+i)
+		case ')', ']', '}':
+			if len(opens) > 0 {
+				opens = opens[:len(opens)-1]
+			}
+		}
+		return true
+	})
+	if len(opens) == 0 {
+		return nil
+	}
+	open := opens[len(opens)-1]
+	closing := map[byte]string{'(': ")", '{': "}"}[prefix[open]]
+	if closing == "" || (closing == "}" && !syntax.StructBrace) || !isCallOpen(prefix[:open], prefix[open]) {
+		return nil
+	}
+
+	// 后缀中匹配的闭合括号
+	scan := suffix
+	if len(scan) > maxArgumentScan {
+		scan = scan[:maxArgumentScan]
+	}
+	end, depth := -1, 0
+	scanCode(scan, quotes, lineComment, func(i int, ch byte) bool {
+		switch ch {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth > 0 {
+				depth--
+				return true
+			}
+			if string(ch) == closing {
+				end = i
+			}
+			return false
+		}
+		return true
+	})
+	if end < 0 {
+		return nil
+	}
+
+	before := prefix[open+1:]
+	current := before[lastTopLevelComma(before, quotes, lineComment)+1:]
+	list := &model.ArgumentList{
+		Open:      string(prefix[open]),
+		Close:     closing,
+		Assign:    syntax.Assign,
+		End:       end + len(closing),
+		AtStart:   strings.TrimSpace(current) == "",
+		Continues: strings.HasPrefix(strings.TrimLeft(suffix[:end], " \t"), ","),
+	}
+	if syntax.Assign != "" {
+		for _, arg := range splitArguments(before, quotes, lineComment) {
+			list.Names = appendArgumentName(list.Names, arg, syntax.Assign)
+		}
+		for _, arg := range splitArguments(suffix[:end], quotes, lineComment) {
+			list.Names = appendArgumentName(list.Names, arg, syntax.Assign)
+		}
+	}
+	return list
+}
+
+// 按字节扫描代码，跳过字符串和行注释，visit返回false时停止
+func scanCode(code, quotes, lineComment string, visit func(i int, ch byte) bool) {
+	for i := 0; i < len(code); i++ {
+		ch := code[i]
+		switch {
+		case strings.IndexByte(quotes, ch) >= 0:
+			for i++; i < len(code) && code[i] != ch && code[i] != '\n'; i++ {
+				if code[i] == '\\' {
+					i++
+				}
+			}
+		case lineComment != "" && strings.HasPrefix(code[i:], lineComment):
+			for i < len(code) && code[i] != '\n' {
+				i++
+			}
+		default:
+			if !visit(i, ch) {
+				return
+			}
+		}
+	}
+}
+
+// 括号前的内容是否表明这是调用(或结构体字面量)的参数列表
+func isCallOpen(before string, open byte) bool {
+	if open == '{' {
+		before = strings.TrimRight(before, " \t")
+		line := before[strings.LastIndexByte(before, '\n')+1:]
+		if blockLine.MatchString(line) {
+			return false
+		}
+	}
+	if before == "" {
+		return false
+	}
+	last := before[len(before)-1]
+	if last == ')' || last == ']' || last == '>' {
+		return open == '('
+	}
+	n := len(before)
+	for n > 0 && isWordByte(before[n-1]) {
+		n--
+	}
+	word := before[n:]
+	return word != "" && !nonCallKeywords[word]
+}
+
+// 最后一个不在嵌套括号中的","的位置，没有时返回-1
+func lastTopLevelComma(code, quotes, lineComment string) int {
+	pos, depth := -1, 0
+	scanCode(code, quotes, lineComment, func(i int, ch byte) bool {
+		switch ch {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				pos = i
+			}
+		}
+		return true
+	})
+	return pos
+}
+
+// 按不在嵌套括号中的","拆分参数
+func splitArguments(code, quotes, lineComment string) []string {
+	var args []string
+	begin, depth := 0, 0
+	scanCode(code, quotes, lineComment, func(i int, ch byte) bool {
+		switch ch {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, code[begin:i])
+				begin = i + 1
+			}
+		}
+		return true
+	})
+	return append(args, code[begin:])
+}
+
+/**
+ * 参数的名称
+ * @param {string} arg - 一个参数
+ * @param {string} assign - 命名参数的赋值符号
+ * @returns {string} 返回参数名，位置参数返回空
+ * @example
+ * argumentName(" color='red'", "=") // "color"
+ * argumentName("x == 1", "=")       // ""
+ * argumentName("Name: \"a\"", ":")  // "Name"
+ */
+func argumentName(arg, assign string) string {
+	arg = strings.TrimLeft(arg, " \t\r\n")
+	n := 0
+	for n < len(arg) && isWordByte(arg[n]) {
+		n++
+	}
+	if n == 0 {
+		return ""
+	}
+	rest := strings.TrimLeft(arg[n:], " \t")
+	if !strings.HasPrefix(rest, assign) || strings.HasPrefix(rest[len(assign):], assign) {
+		return ""
+	}
+	return arg[:n]
+}
+
+// 追加参数名，位置参数和重复的名称不追加
+func appendArgumentName(names []string, arg, assign string) []string {
+	name := argumentName(arg, assign)
+	if name == "" {
+		return names
+	}
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+/**
+ * 按光标所在的参数列表裁剪补全
+ * @param {string} code - 补全内容
+ * @param {*model.ArgumentList} args - 光标所在的参数列表
+ * @param {string} suffix - 光标后的内容
+ * @param {string} quotes - 字符串的引号
+ * @param {string} lineComment - 行注释的起始符号
+ * @returns {string} 返回裁剪后的补全
+ * @description
+ * - 补全中不在嵌套括号中的闭合括号结束了参数列表，后缀中已经有闭合括号，在它之前裁剪(去掉末尾空白)
+ * - 光标后紧接着","时补全只完成当前参数，在第一个不在嵌套括号中的","之前裁剪
+ * - 补全中的某个参数与已有参数同名时，在该参数之前裁剪；参数之前的空白中后缀开头已有的部分不保留
+ * @example
+ * cutArguments("'red', label='a')", &model.ArgumentList{Close: ")", Assign: "=", Names: []string{"label"}}, "label='a')", "'\"", "#")
+ * // "'red', "
+ */
+func cutArguments(code string, args *model.ArgumentList, suffix, quotes, lineComment string) string {
+	if args.AtStart && args.Assign != "" && containsString(args.Names, argumentName(code, args.Assign)) {
+		return ""
+	}
+	cut, trim, depth := -1, false, 0
+	scanCode(code, quotes, lineComment, func(i int, ch byte) bool {
+		switch ch {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				cut, trim = i, true
+				return false
+			}
+			depth--
+		case ',':
+			if depth > 0 {
+				return true
+			}
+			if args.Continues {
+				cut, trim = i, true
+				return false
+			}
+			if args.Assign == "" || !containsString(args.Names, argumentName(code[i+1:], args.Assign)) {
+				return true
+			}
+			// 参数之前的空白，后缀开头已有的部分(如换行和缩进)由后缀提供
+			rest := code[i+1:]
+			space := rest[:len(rest)-len(strings.TrimLeft(rest, " \t\r\n"))]
+			suffixSpace := suffix[:len(suffix)-len(strings.TrimLeft(suffix, " \t\r\n"))]
+			cut = i + 1 + len(strings.TrimSuffix(space, suffixSpace))
+			return false
+		}
+		return true
+	})
+	if cut < 0 {
+		return code
+	}
+	if trim {
+		return strings.TrimRight(code[:cut], " \t\r\n")
+	}
+	return code[:cut]
+}
+
+// 切片中是否包含s，s为空时返回false
+func containsString(list []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 识别光标所在的参数列表，后缀截断时保留整个参数列表，补全由ArgumentCutter裁剪
+func (in *CompletionInput) detectArguments(c *CompletionContext) {
+	if !c.Enabled(feature_flag.Arguments) || in.Shape != nil || in.Literal != nil {
+		return
+	}
+	in.Arguments = detectArguments(in.EffectiveLanguage(), in.Processed.Prefix, in.Processed.Suffix)
+	if in.Arguments != nil {
+		in.Processed.SuffixKeep = in.Arguments.End
+		c.Log().Debug("Cursor inside argument list", zap.String("close", in.Arguments.Close),
+			zap.Strings("names", in.Arguments.Names))
+	}
+}
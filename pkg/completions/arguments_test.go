@@ -0,0 +1,99 @@
+package completions
+
+import (
+	"strings"
+	"testing"
+)
+
+// to test the argument list at the cursor is detected with the existing argument names
+// go test ./pkg/completions/ -v -run Test_DetectArguments
+func Test_DetectArguments(t *testing.T) {
+	tests := []struct {
+		name      string
+		language  string
+		prefix    string
+		suffix    string
+		close     string
+		names     string
+		end       int
+		atStart   bool
+		continues bool
+	}{
+		{"python keyword arguments", "python", "plt.plot(x, y, color=", ", label='a', lw=2)\nplt.show()", ")", "color,label,lw", 18, false, true},
+		{"python argument start", "python", "requests.get(url,\n    timeout=3,\n    ", "headers=h,\n)\n", ")", "timeout,headers", 12, true, false},
+		{"go struct literal fields", "go", "p := Person{\n\tName: \"a\",\n\t", "Age: 3,\n}\n", "}", "Name,Age", 9, true, false},
+		{"nested call", "python", "f(a=1, b=g(", "x, y), c=3)\n", ")", "", 5, true, false},
+		{"string in arguments", "python", "f(sep=\",\", ", "end='')\n", ")", "sep,end", 7, true, false},
+		{"go block is not a literal", "go", "if ok {\n\t", "\n}\n", "", "", 0, false, false},
+		{"keyword before paren", "python", "if (", "x)\n", "", "", 0, false, false},
+		{"unmatched suffix", "python", "f(a, ", "\n", "", "", 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := detectArguments(tt.language, tt.prefix, tt.suffix)
+			if tt.close == "" {
+				if args != nil {
+					t.Errorf("expected no argument list, got %+v", args)
+				}
+				return
+			}
+			if args == nil {
+				t.Fatal("expected an argument list")
+			}
+			if args.Close != tt.close || strings.Join(args.Names, ",") != tt.names || args.End != tt.end ||
+				args.AtStart != tt.atStart || args.Continues != tt.continues {
+				t.Errorf("unexpected argument list %+v", args)
+			}
+		})
+	}
+}
+
+// to test the completion is cut at a duplicated argument, the closing paren and the continuing comma
+// go test ./pkg/completions/ -v -run Test_ArgumentCutter
+func Test_ArgumentCutter(t *testing.T) {
+	tests := []struct {
+		name       string
+		language   string
+		prefix     string
+		suffix     string
+		completion string
+		expected   string
+	}{
+		{"python duplicated keyword", "python", "plot(x, ", "label='a')\n", "color='red', label='a')\nshow()", "color='red', "},
+		{"python continuing comma", "python", "plot(x, color=", ", label='a')\n", "'red', label='b')", "'red'"},
+		{"python multi-line arguments", "python", "get(url,\n    timeout=3,\n    ", "\n    headers=h,\n)\n", "verify=False,\n    headers=h,\n)", "verify=False,"},
+		{"python duplicate at start", "python", "get(url, ", "timeout=3)\n", "timeout=5)", ""},
+		{"go struct literal field", "go", "p := Person{\n\tName: \"a\",\n\t", "Age: 3,\n}\n", "Email: \"e\",\n\tAge: 3,\n}", "Email: \"e\",\n\t"},
+		{"nested call keeps inner parens", "python", "f(a=1, b=g(", "), c=3)\n", "x, h(y)), c=3)", "x, h(y)"},
+		{"closing paren ends the call", "javascript", "foo(a, ", ")\nbar()\n", "b, c)\nbar()", "b, c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := detectArguments(tt.language, tt.prefix, tt.suffix)
+			if args == nil {
+				t.Fatal("expected an argument list")
+			}
+			ctx := &PrunerContext{Language: tt.language, CompletionCode: tt.completion, Prefix: tt.prefix,
+				Suffix: tt.suffix, Arguments: args}
+			(&ArgumentCutter{}).Process(ctx)
+			if ctx.CompletionCode != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, ctx.CompletionCode)
+			}
+		})
+	}
+}
+
+// to test suffix truncation never cuts inside the argument list at the cursor
+// go test ./pkg/completions/ -v -run Test_TrimLastLineKeepsArguments
+func Test_TrimLastLineKeepsArguments(t *testing.T) {
+	h := &CompletionHandler{}
+	if got := h.trimLastLine("a=1,\nb=2) + c", 9); got != "a=1,\nb=2)" {
+		t.Errorf("expected the argument list kept, got %q", got)
+	}
+	if got := h.trimLastLine("a=1,\nb=2) + c", 0); got != "a=1,\n" {
+		t.Errorf("expected the last line trimmed, got %q", got)
+	}
+	if got := h.trimLastLine("a=1,\nb=2)\nc = 3\nd", 9); got != "a=1,\nb=2)\nc = 3\n" {
+		t.Errorf("expected only the last line trimmed, got %q", got)
+	}
+}
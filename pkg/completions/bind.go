@@ -0,0 +1,222 @@
+package completions
+
+import (
+	"bytes"
+	"code-completion/pkg/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 请求体解析错误的类别，作为指标completion_binding_failures_total的class标签
+const (
+	BindEmpty        = "empty"         // 请求体为空
+	BindSyntax       = "syntax"        // 不是合法的JSON
+	BindType         = "type"          // 字段类型不匹配
+	BindUnknownField = "unknown_field" // 未知字段，只在开启disallowUnknownFields时出现
+	BindDepth        = "depth"         // 嵌套过深
+	BindExtraKeys    = "extra_keys"    // extra的键过多
+	BindExtraSize    = "extra_size"    // extra中的值过大
+	BindEncoding     = "encoding"      // 不支持的Content-Encoding或压缩数据损坏
+	BindTooLarge     = "too_large"     // 请求体或解压后的请求体过大
+	BindInvalid      = "invalid"       // 其他错误
+)
+
+/**
+ * 结构化的请求体解析错误
+ * @description
+ * - 返回给插件的400响应中的binding字段，不包含Go的内部错误信息
+ * - Field: 出错字段的JSON路径(如prompt_options.prefix)，extra中的值为extra.<key>
+ * - Expected/Got: 期望的和实际的JSON类型(string/number/boolean/object/array)，或限制和实际值
+ * - Offset: 语法错误在请求体中的字节偏移
+ * @example
+ * {"class": "type", "field": "temperature", "expected": "number", "got": "string",
+ *  "message": "field 'temperature': expected number, got string"}
+ */
+type BindingError struct {
+	Class    string `json:"class"`
+	Field    string `json:"field,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Got      string `json:"got,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (e *BindingError) Error() string {
+	return e.Message
+}
+
+/**
+ * 按配置的限制解析请求体
+ * @param {[]byte} body - 请求体
+ * @param {interface{}} obj - 解析的目标，须为指针
+ * @param {*config.BindingConfig} cfg - 解析限制
+ * @returns {*BindingError} 解析成功时返回nil
+ * @description
+ * - 解析前检查嵌套深度，避免深度嵌套的请求体消耗解析资源
+ * - 开启DisallowUnknownFields时，请求体中有目标结构没有的字段时返回unknown_field错误
+ * - 只检查通用限制，extra的限制见CompletionRequest.CheckExtra
+ * @example
+ * var req CompletionRequest
+ * if err := DecodeJSON(body, &req, &config.Config.Binding); err != nil {
+ *     // 返回400，错误详情为err
+ * }
+ */
+func DecodeJSON(body []byte, obj interface{}, cfg *config.BindingConfig) *BindingError {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &BindingError{Class: BindEmpty, Message: "empty request body"}
+	}
+	if cfg.MaxDepth > 0 && exceedsJSONDepth(body, cfg.MaxDepth) {
+		return &BindingError{
+			Class:    BindDepth,
+			Expected: fmt.Sprintf("<= %d", cfg.MaxDepth),
+			Message:  fmt.Sprintf("JSON nesting depth exceeds %d", cfg.MaxDepth),
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if cfg.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(obj); err != nil {
+		return bindingErrorOf(err)
+	}
+	return nil
+}
+
+// 将encoding/json的错误转换为结构化的错误
+func bindingErrorOf(err error) *BindingError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &BindingError{
+			Class:   BindSyntax,
+			Offset:  syntaxErr.Offset,
+			Message: fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset),
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &BindingError{Class: BindSyntax, Message: "unexpected end of JSON input"}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "(body)"
+		}
+		expected := jsonTypeOf(typeErr.Type)
+		return &BindingError{
+			Class:    BindType,
+			Field:    field,
+			Expected: expected,
+			Got:      typeErr.Value,
+			Offset:   typeErr.Offset,
+			Message:  fmt.Sprintf("field '%s': expected %s, got %s", field, expected, typeErr.Value),
+		}
+	}
+	// encoding/json没有导出未知字段的错误类型，只能从错误信息中解析
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+		return &BindingError{Class: BindUnknownField, Field: name, Message: fmt.Sprintf("unknown field '%s'", name)}
+	}
+	return &BindingError{Class: BindInvalid, Message: "invalid request body"}
+}
+
+// Go类型对应的JSON类型
+func jsonTypeOf(t reflect.Type) string {
+	if t == nil {
+		return "unknown"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Pointer:
+		return jsonTypeOf(t.Elem())
+	}
+	return t.String()
+}
+
+// 请求体的对象/数组嵌套深度是否超过limit，字符串中的括号不计入
+func exceedsJSONDepth(body []byte, limit int) bool {
+	depth := 0
+	inString := false
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		if inString {
+			switch ch {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > limit {
+				return true
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return false
+}
+
+/**
+ * 检查extra字段的限制
+ * @param {*config.BindingConfig} cfg - 解析限制
+ * @returns {*BindingError} 没有超出限制时返回nil
+ * @description
+ * - 键数超过MaxExtraKeys时返回extra_keys错误
+ * - 某个值序列化后超过MaxExtraValueBytes字节时返回extra_size错误，按键排序检查，报告的字段是确定的
+ */
+func (req *CompletionRequest) CheckExtra(cfg *config.BindingConfig) *BindingError {
+	if cfg.MaxExtraKeys > 0 && len(req.Extra) > cfg.MaxExtraKeys {
+		return &BindingError{
+			Class:    BindExtraKeys,
+			Field:    "extra",
+			Expected: fmt.Sprintf("<= %d keys", cfg.MaxExtraKeys),
+			Got:      fmt.Sprintf("%d keys", len(req.Extra)),
+			Message:  fmt.Sprintf("extra has %d keys, at most %d allowed", len(req.Extra), cfg.MaxExtraKeys),
+		}
+	}
+	if cfg.MaxExtraValueBytes <= 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(req.Extra))
+	for key := range req.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		data, _ := json.Marshal(req.Extra[key])
+		if len(data) > cfg.MaxExtraValueBytes {
+			return &BindingError{
+				Class:    BindExtraSize,
+				Field:    "extra." + key,
+				Expected: fmt.Sprintf("<= %d bytes", cfg.MaxExtraValueBytes),
+				Got:      fmt.Sprintf("%d bytes", len(data)),
+				Message:  fmt.Sprintf("extra.%s is %d bytes, at most %d allowed", key, len(data), cfg.MaxExtraValueBytes),
+			}
+		}
+	}
+	return nil
+}
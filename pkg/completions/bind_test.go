@@ -0,0 +1,86 @@
+package completions
+
+import (
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+func newBindingConfig() *config.BindingConfig {
+	return &config.BindingConfig{MaxDepth: 8, MaxExtraKeys: 3, MaxExtraValueBytes: 32}
+}
+
+// to test the limits on the extra field
+// go test ./pkg/completions/ -v -run Test_BindExtra
+func Test_BindExtra(t *testing.T) {
+	cfg := newBindingConfig()
+	cases := map[string]string{
+		`{"client_id": "c1", "extra": {"a": 1, "b": "x"}}`:                  "",
+		`{"extra": {"a": 1, "b": 2, "c": 3, "d": 4}}`:                       BindExtraKeys,
+		`{"extra": {"a": 1, "big": "` + strings.Repeat("x", 40) + `"}}`:     BindExtraSize,
+		`{"extra": {"a": [[[[[[[[[1]]]]]]]]], "b": "[[[[[[[[[[[[[[[[[[["}}`: BindDepth,
+		`{"extra": {"a": "[[[[[[[[[[[[[[[[[[[[\"[[[[[[["}}`:                 "",
+	}
+	for body, class := range cases {
+		var req CompletionRequest
+		err := DecodeJSON([]byte(body), &req, cfg)
+		if err == nil {
+			err = req.CheckExtra(cfg)
+		}
+		if (err == nil && class != "") || (err != nil && err.Class != class) {
+			t.Errorf("%s: expected class %q, got %+v", body, class, err)
+		}
+	}
+	var req CompletionRequest
+	DecodeJSON([]byte(`{"extra": {"z": 1, "big": "`+strings.Repeat("x", 40)+`"}}`), &req, cfg)
+	if err := req.CheckExtra(cfg); err == nil || err.Field != "extra.big" || err.Got != "42 bytes" {
+		t.Errorf("expected the oversized field reported, got %+v", err)
+	}
+}
+
+// to test the structured errors of malformed and wrong-typed bodies
+// go test ./pkg/completions/ -v -run Test_BindErrors
+func Test_BindErrors(t *testing.T) {
+	cfg := newBindingConfig()
+	var req CompletionRequest
+	err := DecodeJSON([]byte(`{"client_id": "c1", "temperature": "0.2"}`), &req, cfg)
+	if err == nil || err.Class != BindType || err.Field != "temperature" || err.Expected != "number" || err.Got != "string" {
+		t.Fatalf("expected a type error on temperature, got %+v", err)
+	}
+	if err.Message != "field 'temperature': expected number, got string" {
+		t.Errorf("unexpected message %q", err.Message)
+	}
+	err = DecodeJSON([]byte(`{"prompt_options": {"prefix": 1}}`), &req, cfg)
+	if err == nil || err.Field != "prompt_options.prefix" || err.Expected != "string" || err.Got != "number" {
+		t.Errorf("expected the nested field path, got %+v", err)
+	}
+	err = DecodeJSON([]byte(`{"client_id": "c1",}`), &req, cfg)
+	if err == nil || err.Class != BindSyntax || err.Offset == 0 || strings.Contains(err.Message, "invalid character") {
+		t.Errorf("expected a syntax error without the raw Go message, got %+v", err)
+	}
+	for _, body := range []string{`{"client_id": "c1"`, ``, `  `} {
+		if err := DecodeJSON([]byte(body), &req, cfg); err == nil || (err.Class != BindSyntax && err.Class != BindEmpty) {
+			t.Errorf("%q: expected a syntax or empty error, got %+v", body, err)
+		}
+	}
+}
+
+// to test rejecting unknown fields in the strict mode
+// go test ./pkg/completions/ -v -run Test_BindUnknownFields
+func Test_BindUnknownFields(t *testing.T) {
+	cfg := newBindingConfig()
+	body := []byte(`{"client_id": "c1", "prompt_options": {"prefix": "a", "cursor": 1}}`)
+	var req CompletionRequest
+	if err := DecodeJSON(body, &req, cfg); err != nil || req.Prompts.Prefix != "a" {
+		t.Fatalf("expected unknown fields ignored by default, got %+v", err)
+	}
+	cfg.DisallowUnknownFields = true
+	err := DecodeJSON(body, &CompletionRequest{}, cfg)
+	if err == nil || err.Class != BindUnknownField || err.Field != "cursor" {
+		t.Errorf("expected an unknown field error, got %+v", err)
+	}
+	if err := DecodeJSON([]byte(`{"client_id": "c1", "extra": {"any": 1}}`), &CompletionRequest{}, cfg); err != nil {
+		t.Errorf("expected keys inside extra allowed in the strict mode, got %+v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// 非空白字符数
+func nonSpaceCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+/**
+ * 处理空白提示词
+ * @param {*CompletionContext} c - 补全上下文，包含性能统计信息
+ * @returns {*CompletionResponse} 返回空补全响应；不是空白提示词或可以只用上下文补全时返回nil
+ * @description
+ * - 前缀和后缀的非空白字符数少于配置的MinChars时为空白提示词，见config.BlankPromptConfig
+ * - 手动触发且请求携带了足够的代码上下文或导入内容时，改为只用上下文的提示词，继续补全
+ * - 其他情况不调用模型(也不经过过滤器链)，返回StatusEmpty，Error中说明原因
+ */
+func (in *CompletionInput) handleBlankPrompt(c *CompletionContext) *CompletionResponse {
+	cfg := &config.Wrapper.Blank
+	if nonSpaceCount(in.Processed.Prefix)+nonSpaceCount(in.Processed.Suffix) >= cfg.MinChars {
+		return nil
+	}
+	contextChars := nonSpaceCount(in.Processed.CodeContext) + nonSpaceCount(in.Processed.ImportContent)
+	if strings.ToUpper(in.TriggerMode) == "MANUAL" && contextChars >= cfg.MinContextChars {
+		in.useContextOnlyPrompt()
+		c.Log().Debug("Blank prompt completed with context only")
+		return nil
+	}
+	in.Empty = &EmptyResult{Reason: EmptyReasonBlankPrompt}
+	rsp := CancelRequest(in.CompletionID, in.Model, c.Perf, model.StatusEmpty,
+		fmt.Errorf("blank prompt: fewer than %d non-whitespace characters", cfg.MinChars))
+	in.AttachVerbose(rsp)
+	return rsp
+}
+
+/**
+ * 只用上下文的提示词
+ * @description
+ * - 导入内容并入代码上下文，代码上下文视为请求已提供，不再检索(空白的前缀检索不到有用的内容)
+ * - 前缀和后缀去掉空白，光标处于上下文之后的新行
+ */
+func (in *CompletionInput) useContextOnlyPrompt() {
+	parts := make([]string, 0, 2)
+	for _, part := range []string{in.Processed.ImportContent, in.Processed.CodeContext} {
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, strings.TrimRight(part, " \t\r\n"))
+		}
+	}
+	in.Processed.CodeContext = strings.Join(parts, "\n")
+	in.Processed.ImportContent = ""
+	in.Processed.Prefix = ""
+	in.Processed.Suffix = ""
+}
@@ -0,0 +1,65 @@
+package completions
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test the early handling of empty and whitespace-only prompts
+// go test ./pkg/completions/ -v -run Test_BlankPrompt
+func Test_BlankPrompt(t *testing.T) {
+	hits, restore := setupContextServer(`{"data":{"list":[]}}`)
+	defer restore()
+	config.Wrapper.Blank = config.BlankPromptConfig{MinChars: 1, MinContextChars: 1}
+
+	newInput := func(mode, prefix, suffix string) *CompletionInput {
+		in := newContextInput(false)
+		in.TriggerMode = mode
+		in.Prompts.Prefix = prefix
+		in.Prompts.Suffix = suffix
+		in.Prompts.ImportContent = ""
+		return in
+	}
+
+	// a freshly created file
+	_, rsp := preprocess(newInput("AUTO", "", "\n"))
+	if rsp == nil || rsp.Status != model.StatusEmpty || !strings.Contains(rsp.Error, "blank prompt") {
+		t.Fatalf("expected an empty response for a new file, got %+v", rsp)
+	}
+	if atomic.LoadInt32(hits) != 0 {
+		t.Error("expected no context search for a blank prompt")
+	}
+	// manual trigger without any context
+	if _, rsp := preprocess(newInput("MANUAL", "  \n\t", "")); rsp == nil || rsp.Status != model.StatusEmpty {
+		t.Errorf("expected an empty response without context, got %+v", rsp)
+	}
+
+	// manual trigger with context completes from the context only
+	in := newInput("MANUAL", "\n\n    ", "\n")
+	in.Prompts.CodeContext = "// date.js\nexport function formatDate(d) {}\n"
+	in.Prompts.ImportContent = "import { formatDate } from './date';\n"
+	if _, rsp := preprocess(in); rsp != nil {
+		t.Fatalf("expected the manual request with context to go to the model, got %+v", rsp)
+	}
+	if in.Processed.Prefix != "" || in.Processed.Suffix != "" ||
+		in.Processed.CodeContext != "import { formatDate } from './date';\n// date.js\nexport function formatDate(d) {}" {
+		t.Errorf("unexpected context only prompt %+v", in.Processed)
+	}
+	if in.ContextOutcome != ContextProvided || atomic.LoadInt32(hits) != 0 {
+		t.Errorf("expected the context used as provided, got %s", in.ContextOutcome)
+	}
+
+	// a file containing only a comment header is a prompt by default
+	header := "// Copyright (c) 2024 Example Corp.\n// SPDX-License-Identifier: MIT\n\n"
+	if _, rsp := preprocess(newInput("AUTO", header, "")); rsp != nil {
+		t.Errorf("expected the comment header to go to the model, got %+v", rsp)
+	}
+	config.Wrapper.Blank.MinChars = 80
+	if _, rsp := preprocess(newInput("AUTO", header, "")); rsp == nil || rsp.Status != model.StatusEmpty {
+		t.Errorf("expected an empty response below the raised threshold, got %+v", rsp)
+	}
+}
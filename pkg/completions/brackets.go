@@ -0,0 +1,153 @@
+package completions
+
+import (
+	"strings"
+
+	"code-completion/pkg/config"
+)
+
+// 括号和引号扫描的默认上限，见config.ScanConfig
+const (
+	defaultScanPrefixBytes = 16 * 1024
+	defaultScanSuffixBytes = 16 * 1024
+	defaultScanDepth       = 256
+)
+
+// 成对的括号，CursorScan中各数组的下标与之对应
+var bracketPairs = [3][2]byte{{'(', ')'}, {'[', ']'}, {'{', '}'}}
+
+// 参与判断光标是否在字符串中的引号
+var scanQuotes = [2]byte{'"', '\''}
+
+/**
+ * 光标前后的一次扫描结果
+ * @description
+ * - Opens: 前缀中各括号未闭合的左括号数，按bracketPairs的顺序；没有对应左括号的右括号忽略
+ * - Closes: 后缀中是否出现各右括号
+ * - Quotes: 前缀中各引号的个数，按scanQuotes的顺序，反斜杠之后的字符不计
+ * - Truncated: 前缀或后缀超过上限，只扫描了靠近光标的部分
+ * - Aborted: 未闭合的括号超过上限提前结束，此时不在括号、字符串中
+ */
+type CursorScan struct {
+	Opens     [3]int
+	Closes    [3]bool
+	Quotes    [2]int
+	Truncated bool
+	Aborted   bool
+}
+
+// 扫描上限，没有配置时使用默认值
+func scanLimits() (prefixBytes, suffixBytes, depth int) {
+	cfg := &config.Wrapper.Scan
+	prefixBytes, suffixBytes, depth = cfg.MaxPrefixBytes, cfg.MaxSuffixBytes, cfg.MaxDepth
+	if prefixBytes <= 0 {
+		prefixBytes = defaultScanPrefixBytes
+	}
+	if suffixBytes <= 0 {
+		suffixBytes = defaultScanSuffixBytes
+	}
+	if depth <= 0 {
+		depth = defaultScanDepth
+	}
+	return prefixBytes, suffixBytes, depth
+}
+
+/**
+ * 一次扫描光标前后，同时得到括号的平衡、光标是否在括号中和是否在字符串中
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @returns {CursorScan} 返回扫描结果
+ * @description
+ * - 只扫描前缀末尾和后缀开头的一部分，见config.ScanConfig；前缀从扫描范围内的第一个完整行开始
+ * - 按字节扫描，括号和引号都是ASCII字符，不会与多字节字符的部分字节混淆
+ * - 后缀中各右括号都出现后提前结束
+ * @example
+ * s := ScanCursor("plot(x, ", ")")
+ * // s.InParentheses() = true, s.Opens = [1, 0, 0]
+ */
+func ScanCursor(prefix, suffix string) CursorScan {
+	var s CursorScan
+	prefixBytes, suffixBytes, depth := scanLimits()
+	if len(prefix) > prefixBytes {
+		s.Truncated = true
+		start := len(prefix) - prefixBytes
+		if idx := strings.IndexByte(prefix[start:], '\n'); idx >= 0 {
+			start += idx + 1
+		}
+		prefix = prefix[start:]
+	}
+	if len(suffix) > suffixBytes {
+		s.Truncated = true
+		suffix = suffix[:suffixBytes]
+	}
+
+	opens, escaped := 0, false
+	for i := 0; i < len(prefix); i++ {
+		ch := prefix[i]
+		switch {
+		case escaped:
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		default:
+			for k, quote := range scanQuotes {
+				if ch == quote {
+					s.Quotes[k]++
+				}
+			}
+		}
+		for k, pair := range bracketPairs {
+			switch ch {
+			case pair[0]:
+				s.Opens[k]++
+				opens++
+			case pair[1]:
+				if s.Opens[k] > 0 {
+					s.Opens[k]--
+					opens--
+				}
+			}
+		}
+		if opens > depth {
+			s.Aborted = true
+			return s
+		}
+	}
+
+	found := 0
+	for i := 0; i < len(suffix) && found < len(bracketPairs); i++ {
+		for k, pair := range bracketPairs {
+			if suffix[i] == pair[1] && !s.Closes[k] {
+				s.Closes[k] = true
+				found++
+			}
+		}
+	}
+	return s
+}
+
+// 光标是否在括号中：前缀中有未闭合的左括号，且后缀中有对应的右括号
+func (s *CursorScan) InParentheses() bool {
+	if s.Aborted {
+		return false
+	}
+	for k := range bracketPairs {
+		if s.Opens[k] > 0 && s.Closes[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// 光标是否在字符串中：前缀中某种引号的个数为奇数
+func (s *CursorScan) InString() bool {
+	if s.Aborted {
+		return false
+	}
+	for _, n := range s.Quotes {
+		if n%2 == 1 {
+			return true
+		}
+	}
+	return false
+}
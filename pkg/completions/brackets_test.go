@@ -0,0 +1,133 @@
+package completions
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+)
+
+// to test the bracket and quote scanners on ordinary inputs
+// go test ./pkg/completions/ -v -run Test_BracketScanners
+func Test_BracketScanners(t *testing.T) {
+	parens := []struct {
+		prefix, suffix string
+		expected       bool
+	}{
+		{"foo(", ")", true},
+		{"foo(a)", ")", false},
+		{"foo(", "", false},
+		{"x = [1, ", "]", true},
+		{"{ a: (1), ", "}", true},
+		{"f(", "x]", false},
+		{")(", "", false},
+		{"a) (", "b)", true},
+		{"f(a, g(b)", ")", true},
+		{"f(a, g(b))", ")", false},
+		{"f(", "\n}\n)", true},
+		{"打印(", "）)", true},
+		{"f\\(", ")", true},
+	}
+	for _, c := range parens {
+		if got := IsCursorInParentheses(c.prefix, c.suffix); got != c.expected {
+			t.Errorf("IsCursorInParentheses(%q, %q) = %v", c.prefix, c.suffix, got)
+		}
+	}
+
+	strs := []struct {
+		prefix   string
+		expected bool
+	}{
+		{`x = "abc`, true},
+		{`x = "a\"b`, true},
+		{`x = 'a'`, false},
+		{`x = "a" + 'b`, true},
+		{`s = "\\"`, false},
+		{`s = "字符`, true},
+		{"", false},
+	}
+	for _, c := range strs {
+		if got := IsCursorInString(c.prefix); got != c.expected {
+			t.Errorf("IsCursorInString(%q) = %v", c.prefix, got)
+		}
+	}
+
+	valid := []struct {
+		text     string
+		expected bool
+	}{
+		{"f(a[1]) {}", true},
+		{"f(]", false},
+		{"(", false},
+		{")", false},
+		{"", true},
+		{`"("`, false},
+		{"{[()]}", true},
+		{"{[(])}", false},
+	}
+	for _, c := range valid {
+		if got := IsValidBrackets(c.text); got != c.expected {
+			t.Errorf("IsValidBrackets(%q) = %v", c.text, got)
+		}
+	}
+
+	if got := CountPairedSymbols("f(a(b)) [x] )"); !maps.Equal(got, map[string]int{"(": 2, "[": 1}) {
+		t.Errorf("unexpected paired symbols %v", got)
+	}
+	if got := CountPairedSymbols(""); len(got) != 0 {
+		t.Errorf("unexpected paired symbols %v", got)
+	}
+}
+
+// 病态输入：500KB的左括号
+func pathologicalPrefix() string {
+	return "x = " + strings.Repeat("(", 500*1024)
+}
+
+// to test the scans of pathological inputs stopping early with a conservative answer
+// go test ./pkg/completions/ -v -run Test_CursorScanLimits
+func Test_CursorScanLimits(t *testing.T) {
+	old := config.Wrapper.Scan
+	defer func() { config.Wrapper.Scan = old }()
+	config.Wrapper.Scan = config.ScanConfig{}
+
+	start := time.Now()
+	s := ScanCursor(pathologicalPrefix(), ")")
+	if !s.Truncated || !s.Aborted || s.InParentheses() || s.InString() {
+		t.Errorf("expected a conservative truncated scan, got %+v", s)
+	}
+	if IsCursorInParentheses(pathologicalPrefix(), ")") || IsValidBrackets(pathologicalPrefix()+strings.Repeat(")", 500*1024)) {
+		t.Error("expected the conservative answers for the pathological inputs")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("scans took %s", elapsed)
+	}
+
+	// 只扫描前缀末尾的部分，从完整的一行开始
+	long := strings.Repeat("x = \"a\"\n", 4096) + "f(a, "
+	s = ScanCursor(long, ")")
+	if !s.Truncated || s.Aborted || !s.InParentheses() || s.InString() {
+		t.Errorf("expected the cursor in parentheses from the scanned window, got %+v", s)
+	}
+	config.Wrapper.Scan.MaxDepth = 2
+	if s = ScanCursor("f(g(h(", ")"); !s.Aborted || s.InParentheses() {
+		t.Errorf("expected the configured depth limit, got %+v", s)
+	}
+}
+
+// go test ./pkg/completions/ -bench Benchmark_CursorScan -run ^$
+func Benchmark_CursorScanPathological(b *testing.B) {
+	prefix := pathologicalPrefix()
+	for i := 0; i < b.N; i++ {
+		IsCursorInParentheses(prefix, ")")
+	}
+}
+
+func Benchmark_CursorScanOrdinary(b *testing.B) {
+	prefix := strings.Repeat("value := compute(a, b[1], \"text\")\n", 100) + "call(x, "
+	for i := 0; i < b.N; i++ {
+		IsCursorInParentheses(prefix, ")\n")
+	}
+}
@@ -0,0 +1,107 @@
+package completions
+
+import (
+	"time"
+
+	"code-completion/pkg/model"
+)
+
+// 预算报告中提示词各部分的名称
+const (
+	SectionPrefix          = "prefix"
+	SectionSuffix          = "suffix"
+	SectionImportContent   = "import_content"
+	SectionClientContext   = "client_context"
+	SectionCodebaseContext = "codebase_context"
+)
+
+/**
+ * 创建提示词预算报告
+ * @param {*PromptOptions} ppt - 解析后的提示词
+ * @returns {*model.BudgetReport} 返回记录了各部分收到的字节数的报告
+ * @description
+ * - 只在请求verbose时创建，之后由GetContext、truncatePrompt、CallLLM依次补充
+ */
+func newBudgetReport(ppt *PromptOptions) *model.BudgetReport {
+	return &model.BudgetReport{
+		Sections: map[string]*model.BudgetSection{
+			SectionPrefix:          {Bytes: len(ppt.Prefix)},
+			SectionSuffix:          {Bytes: len(ppt.Suffix)},
+			SectionImportContent:   {Bytes: len(ppt.ImportContent)},
+			SectionClientContext:   {Bytes: len(ppt.CodeContext)},
+			SectionCodebaseContext: {},
+		},
+	}
+}
+
+// 上下文计入客户端提供的部分，客户端没有提供时计入代码库检索的部分
+func contextSection(b *model.BudgetReport) string {
+	if b.Sections[SectionClientContext].Bytes > 0 {
+		return SectionClientContext
+	}
+	return SectionCodebaseContext
+}
+
+// 截断提示词的一部分的结果
+type sectionCut struct {
+	lines  int // 截掉的行数
+	marker int // 插入的截断标记的token数
+}
+
+/**
+ * 记录截断提示词的结果
+ * @param {*model.BudgetReport} b - 预算报告，为nil时不记录
+ * @param {[3]int} tokens - 前缀、后缀、上下文截断前的token数
+ * @param {[3]int} kept - 前缀、后缀、上下文截断后的token数
+ * @param {[3]sectionCut} cuts - 前缀、后缀、上下文截掉的行数和插入的截断标记的token数
+ * @param {int} preamble - 前言的token数
+ * @param {int} separator - 上下文与前缀之间分隔符的token数，没有拼接分隔符时为0
+ * @param {time.Duration} tokenize - 分词耗时
+ * @description
+ * - GetContext记录的各检索贡献的字节数，在这里按字节占比换算为token数
+ */
+func recordTruncation(b *model.BudgetReport, tokens, kept [3]int, cuts [3]sectionCut, preamble, separator int, tokenize time.Duration) {
+	if b == nil {
+		return
+	}
+	b.PromptTokens = preamble + separator
+	for i, section := range []string{SectionPrefix, SectionSuffix, contextSection(b)} {
+		s := b.Sections[section]
+		s.Tokens, s.Kept = tokens[i], kept[i]
+		s.RemovedTokens, s.RemovedLines, s.MarkerTokens = tokens[i]-kept[i], cuts[i].lines, cuts[i].marker
+		b.PromptTokens += kept[i] + cuts[i].marker
+	}
+	b.PreambleTokens = preamble
+	b.SeparatorTokens = separator
+	b.Latency.TokenizeUs = tokenize.Microseconds()
+
+	total := 0
+	for _, n := range b.Providers {
+		total += n
+	}
+	for provider, n := range b.Providers {
+		if total > 0 {
+			b.Providers[provider] = b.Sections[SectionCodebaseContext].Tokens * n / total
+		}
+	}
+}
+
+/**
+ * 将预算报告附加到响应的Verbose中
+ * @param {*CompletionResponse} rsp - 补全响应
+ * @param {*model.BudgetReport} b - 预算报告，为nil(没有请求verbose)时不附加
+ * @param {*CompletionPerformance} perf - 性能统计，提供排队时长和收到请求的时间
+ * @returns {*CompletionResponse} 返回传入的响应
+ */
+func attachBudget(rsp *CompletionResponse, b *model.BudgetReport, perf *CompletionPerformance) *CompletionResponse {
+	if rsp == nil || b == nil {
+		return rsp
+	}
+	b.Latency.QueueUs = perf.QueueDuration * 1000
+	b.Latency.TotalUs = time.Since(perf.ReceiveTime).Microseconds()
+	if rsp.Verbose == nil {
+		rsp.Verbose = &model.CompletionVerbose{}
+	}
+	rsp.Verbose.Budget = b
+	return rsp
+}
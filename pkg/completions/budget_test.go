@@ -0,0 +1,267 @@
+package completions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+)
+
+// byteTokenizerLLM uses a byte level tokenizer without merges, every byte is one token
+type byteTokenizerLLM struct {
+	scriptedLLM
+	tokenizer *tokenizers.Tokenizer
+}
+
+func (m *byteTokenizerLLM) Tokenizer() *tokenizers.Tokenizer {
+	return m.tokenizer
+}
+
+func newByteTokenizerLLM(t *testing.T, cfg config.ModelConfig, texts ...string) *byteTokenizerLLM {
+	tokenizer, err := tokenizers.NewTokenizer("testdata/tokenizer/tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &byteTokenizerLLM{scriptedLLM: scriptedLLM{cfg: cfg, texts: texts}, tokenizer: tokenizer}
+}
+
+// setupContextCap sets the fraction of the prefix budget retrieved context may take, returns a restore func
+func setupContextCap(fraction float64) func() {
+	saved := config.Context.Sanitize.MaxPromptFraction
+	config.Context.Sanitize.MaxPromptFraction = fraction
+	return func() {
+		config.Context.Sanitize.MaxPromptFraction = saved
+	}
+}
+
+// to test the prompt budget report in verbose
+// go test ./pkg/completions/ -v -run Test_BudgetReport
+func Test_BudgetReport(t *testing.T) {
+	_, restore := setupContextServer(`{"data":{"list":[{"filePath":"date.js","content":"export function formatDate(d) {\n  return d.toISOString();\n}"}]}}`)
+	defer restore()
+	defer setupPruneRetry(config.PruneRetryConfig{})()
+	// the context fills the budget left by the prefix, no cap on its share
+	defer setupContextCap(1)()
+
+	cfg := config.ModelConfig{ModelName: "budget", MaxPrefix: 60, MaxSuffix: 100, MaxOutput: 32}
+	run := func(verbose bool) (*CompletionInput, *CompletionResponse) {
+		in := newContextInput(false)
+		in.Verbose = verbose
+		c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+		c.ContextClient = codebase_context.NewContextClient()
+		if rsp := in.Preprocess(c); rsp != nil {
+			t.Fatalf("unexpected rejection: %s", rsp.Error)
+		}
+		h := NewCompletionHandler(newByteTokenizerLLM(t, cfg, "new Date()"))
+		return in, h.CallLLM(c, h.Adapt(in))
+	}
+
+	in, rsp := run(true)
+	if rsp.Verbose == nil || rsp.Verbose.Budget == nil {
+		t.Fatal("expected a budget report")
+	}
+	b := rsp.Verbose.Budget
+	req := in.Prompts
+	prefix, suffix := b.Sections[SectionPrefix], b.Sections[SectionSuffix]
+	if prefix.Bytes != len(req.Prefix) || prefix.Tokens != len(req.Prefix) || prefix.Kept != len(req.Prefix) {
+		t.Errorf("unexpected prefix section %+v", prefix)
+	}
+	if suffix.Bytes != len(req.Suffix) || suffix.Tokens != len(req.Suffix) || suffix.Kept != len(req.Suffix) {
+		t.Errorf("unexpected suffix section %+v", suffix)
+	}
+	if imports := b.Sections[SectionImportContent]; imports.Bytes != len(req.ImportContent) || imports.Tokens != 0 {
+		t.Errorf("unexpected import section %+v", imports)
+	}
+	if client := b.Sections[SectionClientContext]; client.Bytes != 0 || client.Kept != 0 {
+		t.Errorf("unexpected client context section %+v", client)
+	}
+	// the "\n" separating the context from the prefix takes one token of the prefix budget
+	codebase := b.Sections[SectionCodebaseContext]
+	if codebase.Bytes == 0 || codebase.Tokens != codebase.Bytes || codebase.Kept != cfg.MaxPrefix-len(req.Prefix)-1 || b.SeparatorTokens != 1 {
+		t.Errorf("unexpected codebase context section %+v", codebase)
+	}
+	if len(in.Processed.CodeContext) != codebase.Kept {
+		t.Errorf("expected %d context tokens sent, got %d", codebase.Kept, len(in.Processed.CodeContext))
+	}
+	// the three searches return the same code, only the first one is kept
+	if len(b.Providers) != 1 || b.Providers["definition"] != codebase.Tokens {
+		t.Errorf("unexpected provider tokens %v", b.Providers)
+	}
+	if b.PromptTokens != cfg.MaxPrefix+len(req.Suffix) || b.ModelWindow != cfg.MaxPrefix+cfg.MaxSuffix {
+		t.Errorf("unexpected prompt tokens %d of %d", b.PromptTokens, b.ModelWindow)
+	}
+	if b.Latency.ContextUs <= 0 || b.Latency.TotalUs < b.Latency.ContextUs+b.Latency.ModelUs {
+		t.Errorf("unexpected latency %+v", b.Latency)
+	}
+
+	if _, rsp := run(false); rsp.Verbose != nil && rsp.Verbose.Budget != nil {
+		t.Error("expected no budget report without verbose")
+	}
+}
+
+// to test that the context separator takes the prefix budget only when it is joined
+// go test ./pkg/completions/ -v -run Test_TruncateSeparator
+func Test_TruncateSeparator(t *testing.T) {
+	fence := "\n// ---\n"
+	prefix := "abcdefghij\nklmnopqrst\n"
+	cases := []struct {
+		maxPrefix int
+		separator *string
+		context   string
+		kept      string
+		tokens    int
+	}{
+		{22, nil, "", "", 22},
+		{30, nil, "0123456789", "3456789", 30},
+		{34, &fence, "0123456789", "6789", 34},
+		// the separator does not fit, the context is dropped and the prefix keeps the whole budget
+		{30, &fence, "0123456789", "", 22},
+	}
+	for i, c := range cases {
+		cfg := config.ModelConfig{MaxPrefix: c.maxPrefix, MaxSuffix: 10, ContextSeparator: c.separator}
+		h := NewCompletionHandler(newByteTokenizerLLM(t, cfg))
+		ppt := PromptOptions{Prefix: prefix, CodeContext: c.context}
+		budget := newBudgetReport(&ppt)
+		h.truncatePrompt(&cfg, &ppt, "", budget, "")
+		if ppt.CodeContext != c.kept || ppt.Prefix != prefix {
+			t.Errorf("case %d: unexpected prompt %+v", i, ppt)
+		}
+		if budget.PromptTokens != c.tokens {
+			t.Errorf("case %d: expected %d prompt tokens, got %d", i, c.tokens, budget.PromptTokens)
+		}
+	}
+}
+
+// to test the truncation markers inserted at the cut boundaries and their budget accounting
+// go test ./pkg/completions/ -v -run Test_TruncationMarkers
+func Test_TruncationMarkers(t *testing.T) {
+	lines := func(prefix string, n int) string {
+		text := ""
+		for i := 0; i < n; i++ {
+			text += fmt.Sprintf("%s%02d := compute(%02d)\n", prefix, i, i)
+		}
+		return text
+	}
+	const (
+		earlier = "// ... earlier code omitted ...\n"
+		context = "// ... earlier context omitted ...\n"
+		later   = "// ... later code omitted ..."
+	)
+	cfg := config.ModelConfig{MaxPrefix: 100, MaxSuffix: 80, TruncationMarkers: true}
+	h := NewCompletionHandler(newByteTokenizerLLM(t, cfg))
+	truncate := func(cfg config.ModelConfig, ppt PromptOptions) (PromptOptions, *model.BudgetReport, int) {
+		ppt.Language = "go"
+		budget := newBudgetReport(&ppt)
+		tokens, _ := h.truncatePrompt(&cfg, &ppt, "", budget, "")
+		return ppt, budget, tokens
+	}
+
+	// 前缀截掉了文件开头: 标记在前缀开头，标记和保留的前缀都在预算内
+	ppt, budget, tokens := truncate(cfg, PromptOptions{Prefix: lines("a", 10) + "\treturn "})
+	prefix := budget.Sections[SectionPrefix]
+	if !strings.HasPrefix(ppt.Prefix, earlier) || !strings.HasSuffix(ppt.Prefix, "\treturn ") ||
+		strings.Contains(ppt.Prefix, "a00") {
+		t.Errorf("expected the marker at the top of the prefix, got %q", ppt.Prefix)
+	}
+	if prefix.MarkerTokens != len(earlier) || len(ppt.Prefix) > cfg.MaxPrefix || prefix.RemovedTokens != prefix.Tokens-prefix.Kept {
+		t.Errorf("unexpected prefix section %+v for %d bytes", prefix, len(ppt.Prefix))
+	}
+	if removed := 10 - strings.Count(ppt.Prefix, "a0"); prefix.RemovedLines != removed {
+		t.Errorf("expected %d removed lines, got %d", removed, prefix.RemovedLines)
+	}
+	if tokens != prefix.Kept+prefix.MarkerTokens || budget.PromptTokens != tokens {
+		t.Errorf("expected the marker counted in the prompt tokens, got %d/%d", tokens, budget.PromptTokens)
+	}
+
+	// 后缀截掉了文件末尾: 标记独占后缀的最后一行
+	ppt, budget, tokens = truncate(cfg, PromptOptions{Prefix: "x := ", Suffix: "\n" + lines("b", 10)})
+	suffix := budget.Sections[SectionSuffix]
+	if !strings.HasSuffix(ppt.Suffix, "\n"+later) || !strings.HasPrefix(ppt.Suffix, "\nb00") || len(ppt.Suffix) > cfg.MaxSuffix {
+		t.Errorf("expected the marker at the end of the suffix, got %q", ppt.Suffix)
+	}
+	if suffix.MarkerTokens != len(later)+1 || suffix.RemovedLines != 10-strings.Count(ppt.Suffix, "b0") ||
+		tokens != len("x := ")+suffix.Kept+suffix.MarkerTokens {
+		t.Errorf("unexpected suffix section %+v, %d tokens", suffix, tokens)
+	}
+
+	// 上下文截掉了开头: 标记在上下文开头，前缀完整保留
+	ppt, budget, tokens = truncate(cfg, PromptOptions{Prefix: "y := ", CodeContext: lines("c", 10)})
+	codebase := budget.Sections[SectionClientContext]
+	if !strings.HasPrefix(ppt.CodeContext, context) || ppt.Prefix != "y := " {
+		t.Errorf("expected the marker at the top of the context, got %q", ppt.CodeContext)
+	}
+	if codebase.MarkerTokens != len(context) || tokens != cfg.MaxPrefix || len(ppt.CodeContext)+1+len(ppt.Prefix) != cfg.MaxPrefix {
+		t.Errorf("unexpected context section %+v, %d tokens", codebase, tokens)
+	}
+
+	// 没有截断时不插入标记
+	whole := PromptOptions{Prefix: lines("d", 2), Suffix: lines("e", 2), CodeContext: "// util.go\n"}
+	ppt, budget, _ = truncate(cfg, whole)
+	for name, section := range budget.Sections {
+		if section.MarkerTokens != 0 || section.RemovedLines != 0 || section.RemovedTokens != 0 {
+			t.Errorf("%s: expected nothing cut, got %+v", name, section)
+		}
+	}
+	if ppt.Prefix != whole.Prefix || ppt.Suffix != whole.Suffix || ppt.CodeContext != whole.CodeContext {
+		t.Errorf("expected the prompt unchanged, got %+v", ppt)
+	}
+
+	// 模型没有开启截断标记
+	cfg.TruncationMarkers = false
+	ppt, budget, _ = truncate(cfg, PromptOptions{Prefix: lines("a", 10)})
+	if strings.Contains(ppt.Prefix, "omitted") || budget.Sections[SectionPrefix].MarkerTokens != 0 ||
+		budget.Sections[SectionPrefix].RemovedLines == 0 {
+		t.Errorf("expected no marker when disabled, got %q", ppt.Prefix)
+	}
+}
+
+// to test retrieved context is fenced and capped to its share of the prefix budget
+// go test ./pkg/completions/ -v -run Test_ContextFence
+func Test_ContextFence(t *testing.T) {
+	prefix := "package main\n"
+	body := strings.Repeat("x := 1\n", 100)
+	begin, end, fenceTokens := contextFences(func(s string) int { return len(s) }, "go")
+	if !strings.HasPrefix(begin, "// <<< retrieved context") || !strings.HasPrefix(end, "// <<< end") {
+		t.Fatalf("unexpected fences %q %q", begin, end)
+	}
+	cfg := config.ModelConfig{MaxPrefix: 1000, MaxSuffix: 10}
+	h := NewCompletionHandler(newByteTokenizerLLM(t, cfg))
+	run := func(fraction float64, retrieved bool) (PromptOptions, *model.BudgetReport) {
+		defer setupContextCap(fraction)()
+		ppt := PromptOptions{Prefix: prefix, CodeContext: body, Language: "go"}
+		if retrieved {
+			ppt.Provenance = []model.ContextSnippet{{Provider: codebase_context.ProviderSemantic, FilePath: "x.go", Bytes: len(body)}}
+		}
+		budget := newBudgetReport(&ppt)
+		h.truncatePrompt(&cfg, &ppt, "", budget, "")
+		return ppt, budget
+	}
+
+	// 默认比例上限0.5，围栏占用前缀预算
+	ppt, budget := run(0.5, true)
+	contextMax := (cfg.MaxPrefix - fenceTokens) / 2
+	if !strings.HasPrefix(ppt.CodeContext, begin+"\n") || !strings.HasSuffix(ppt.CodeContext, "\n"+end) {
+		t.Fatalf("expected the context fenced, got %q", ppt.CodeContext)
+	}
+	if kept := len(ppt.CodeContext) - fenceTokens; kept != contextMax {
+		t.Errorf("expected the context capped to %d tokens, got %d", contextMax, kept)
+	}
+	if budget.FenceTokens != fenceTokens || budget.PromptTokens != len(prefix)+1+contextMax+fenceTokens {
+		t.Errorf("unexpected fence accounting %d, prompt tokens %d", budget.FenceTokens, budget.PromptTokens)
+	}
+
+	// 不限制比例时上下文全部保留
+	if ppt, _ := run(1, true); ppt.CodeContext != begin+"\n"+body+"\n"+end {
+		t.Errorf("expected the whole context fenced, got %q", ppt.CodeContext)
+	}
+	// 不是检索的上下文不加围栏，也不限制比例
+	if ppt, budget := run(0.5, false); ppt.CodeContext != body || budget.FenceTokens != 0 {
+		t.Errorf("expected the context unchanged, got %q", ppt.CodeContext)
+	}
+}
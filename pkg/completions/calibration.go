@@ -0,0 +1,167 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"math"
+	"sort"
+	"sync"
+)
+
+// 上下文超长时的默认收紧倍数
+const defaultCalibrationTighten = 1.1
+
+// 一个模型的token数校准状态
+type calibrationState struct {
+	ratio    float64 // 上游token数/本地token数的滑动平均
+	samples  int
+	tightens int // 因上下文超长收紧的次数
+}
+
+// 一个模型的token数校准状态，用于/api/stats
+type CalibrationStats struct {
+	Model    string  `json:"model"`
+	Factor   float64 `json:"factor"`
+	Ratio    float64 `json:"ratio"`
+	Samples  int     `json:"samples"`
+	Tightens int     `json:"tightens"`
+}
+
+/**
+ * 本地token数与上游token数的校准
+ * @description
+ * - 按模型统计上游报告的提示词token数与本地计算的token数之比(滑动平均)，见config.TokenCalibrationConfig
+ * - 系数大于1表示本地少算，截断时预算按系数缩小；小于1表示本地多算，预算按系数放大
+ * - 只保存在内存中，重启后重新学习
+ */
+type TokenCalibration struct {
+	cfg    *config.TokenCalibrationConfig
+	mutex  sync.Mutex
+	models map[string]*calibrationState
+}
+
+// 全局的token数校准
+var Calibration = NewTokenCalibration(&config.Wrapper.Calibration)
+
+// 创建token数校准
+func NewTokenCalibration(cfg *config.TokenCalibrationConfig) *TokenCalibration {
+	return &TokenCalibration{cfg: cfg, models: make(map[string]*calibrationState)}
+}
+
+// 将系数限制在配置的范围内
+func (t *TokenCalibration) clamp(factor float64) float64 {
+	if t.cfg.MinFactor > 0 {
+		factor = math.Max(factor, t.cfg.MinFactor)
+	}
+	if t.cfg.MaxFactor > 0 {
+		factor = math.Min(factor, t.cfg.MaxFactor)
+	}
+	return factor
+}
+
+// 当前系数，调用者需持有mutex
+func (t *TokenCalibration) factorOf(s *calibrationState) float64 {
+	if s == nil || s.samples < t.cfg.MinSamples || s.ratio <= 0 {
+		return 1.0
+	}
+	return t.clamp(s.ratio)
+}
+
+/**
+ * 获取模型的校准系数
+ * @param {string} model - 模型名称
+ * @returns {float64} 返回系数，关闭校准或样本数不足时为1.0
+ */
+func (t *TokenCalibration) Factor(model string) float64 {
+	if t.cfg.Disabled {
+		return 1.0
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.factorOf(t.models[model])
+}
+
+/**
+ * 记录一次补全的本地和上游提示词token数
+ * @param {string} model - 模型名称
+ * @param {int} local - 截断后本地计算的提示词token数
+ * @param {int} upstream - 上游报告的usage.prompt_tokens
+ * @description
+ * - 任一为0时不记录(没有tokenizer或上游不报告用量)
+ * - 上游的提示词包含FIM标记等模板token，比值中一并计入
+ */
+func (t *TokenCalibration) Observe(model string, local, upstream int) {
+	if t.cfg.Disabled || local <= 0 || upstream <= 0 {
+		return
+	}
+	ratio := float64(upstream) / float64(local)
+	alpha := 2 / float64(max(t.cfg.Window, 1)+1)
+	t.mutex.Lock()
+	s := t.models[model]
+	if s == nil {
+		s = &calibrationState{ratio: ratio}
+		t.models[model] = s
+	} else {
+		s.ratio += alpha * (ratio - s.ratio)
+	}
+	s.samples++
+	factor := t.factorOf(s)
+	t.mutex.Unlock()
+	metrics.UpdateTokenCalibration(model, factor)
+}
+
+/**
+ * 上游报告提示词超过上下文长度时立即收紧系数
+ * @param {string} model - 模型名称
+ * @returns {float64} 返回收紧后的系数
+ * @description
+ * - 系数乘以配置的倍数(不低于1.0再乘)，并立即生效(样本数补足到MinSamples)
+ * - 之后的样本按滑动平均逐渐修正收紧的系数
+ */
+func (t *TokenCalibration) Tighten(model string) float64 {
+	if t.cfg.Disabled {
+		return 1.0
+	}
+	tighten := t.cfg.Tighten
+	if tighten <= 1 {
+		tighten = defaultCalibrationTighten
+	}
+	t.mutex.Lock()
+	s := t.models[model]
+	if s == nil {
+		s = &calibrationState{}
+		t.models[model] = s
+	}
+	s.ratio = t.clamp(math.Max(t.factorOf(s), 1.0) * tighten)
+	s.samples = max(s.samples, t.cfg.MinSamples)
+	s.tightens++
+	factor := t.factorOf(s)
+	t.mutex.Unlock()
+	metrics.UpdateTokenCalibration(model, factor)
+	return factor
+}
+
+// 各模型的校准状态，按模型名称排序
+func (t *TokenCalibration) GetStats() []CalibrationStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stats := make([]CalibrationStats, 0, len(t.models))
+	for model, s := range t.models {
+		factor := 1.0
+		if !t.cfg.Disabled {
+			factor = t.factorOf(s)
+		}
+		stats = append(stats, CalibrationStats{Model: model, Factor: factor, Ratio: s.ratio,
+			Samples: s.samples, Tightens: s.tightens})
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Model < stats[b].Model })
+	return stats
+}
+
+// 按校准系数调整token预算
+func calibratedBudget(budget int, factor float64) int {
+	if factor <= 0 || budget <= 0 {
+		return budget
+	}
+	return int(float64(budget) / factor)
+}
@@ -0,0 +1,114 @@
+package completions
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+func setupCalibration(cfg config.TokenCalibrationConfig) func() {
+	saved, savedCfg := Calibration, config.Wrapper.Calibration
+	config.Wrapper.Calibration = cfg
+	Calibration = NewTokenCalibration(&config.Wrapper.Calibration)
+	return func() {
+		Calibration, config.Wrapper.Calibration = saved, savedCfg
+	}
+}
+
+// to test the calibration factor converges to the upstream ratio within the bounds, and the budget follows it
+// go test ./pkg/completions/ -v -run Test_TokenCalibration
+func Test_TokenCalibration(t *testing.T) {
+	defer setupCalibration(config.TokenCalibrationConfig{MinSamples: 5, Window: 20, MinFactor: 0.8, MaxFactor: 1.5, Tighten: 1.1})()
+	feed := func(n, local, upstream int) {
+		for i := 0; i < n; i++ {
+			Calibration.Observe("m", local, upstream)
+		}
+	}
+
+	feed(4, 1000, 1250)
+	if f := Calibration.Factor("m"); f != 1.0 {
+		t.Errorf("expected 1.0 before enough samples, got %v", f)
+	}
+	feed(100, 1000, 1250)
+	if f := Calibration.Factor("m"); math.Abs(f-1.25) > 0.001 || calibratedBudget(1000, f) != 800 {
+		t.Errorf("expected the factor 1.25 and a budget of 800, got %v %d", f, calibratedBudget(1000, f))
+	}
+
+	// 上游的计数变化后逐渐收敛到新的比值
+	feed(100, 1000, 900)
+	if f := Calibration.Factor("m"); math.Abs(f-0.9) > 0.01 || calibratedBudget(1000, f) <= 1000 {
+		t.Errorf("expected the factor to converge to 0.9, got %v", f)
+	}
+	feed(100, 100, 300)
+	if f := Calibration.Factor("m"); f != 1.5 {
+		t.Errorf("expected the factor bounded by 1.5, got %v", f)
+	}
+
+	// 收紧立即生效，不需要样本
+	if f := Calibration.Tighten("fresh"); math.Abs(f-1.1) > 1e-9 || Calibration.Factor("fresh") != f {
+		t.Errorf("expected the tightened factor 1.1, got %v", f)
+	}
+	if stats := Calibration.GetStats(); len(stats) != 2 || stats[0].Model != "fresh" || stats[0].Tightens != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	Calibration.Observe("unknown", 0, 100)
+	if f := Calibration.Factor("unknown"); f != 1.0 {
+		t.Errorf("expected no sample without local tokens, got %v", f)
+	}
+}
+
+// 按字节数的ratio倍计算提示词token数的上游，超过window时报告上下文超长
+type windowLLM struct {
+	byteTokenizerLLM
+	ratio   float64
+	window  int
+	prompts []int
+}
+
+func (m *windowLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	tokens := int(float64(len(p.Prefix)+len(p.CodeContext)+len(p.Suffix)) * m.ratio)
+	m.prompts = append(m.prompts, tokens)
+	if tokens > m.window {
+		return nil, &model.CompletionVerbose{}, model.StatusModelError, fmt.Errorf("%w: Invalid StatusCode(400)", model.ErrContextLength)
+	}
+	rsp := &model.CompletionResponse{Choices: []model.CompletionChoice{{Text: "done()"}}, Usage: model.CompletionUsage{PromptTokens: tokens}}
+	return rsp, &model.CompletionVerbose{}, model.StatusSuccess, nil
+}
+
+// to test an upstream context length error tightens the factor and retries once with a smaller prompt
+// go test ./pkg/completions/ -v -run Test_ContextLengthRetry
+func Test_ContextLengthRetry(t *testing.T) {
+	defer setupCalibration(config.TokenCalibrationConfig{MinSamples: 5, Window: 20, MinFactor: 0.8, MaxFactor: 1.5, Tighten: 1.1})()
+	defer setupPruneRetry(config.PruneRetryConfig{})()
+	cfg := config.ModelConfig{ModelName: "window", MaxPrefix: 100, MaxSuffix: 20, MaxOutput: 16}
+	llm := &windowLLM{byteTokenizerLLM: *newByteTokenizerLLM(t, cfg), ratio: 1.08, window: 105}
+	h := NewCompletionHandler(llm)
+	para := &model.CompletionParameter{Model: "window", Prefix: strings.Repeat("abcdefghi\n", 10), Verbose: true}
+	para.PromptTokens, para.TokenFactor = h.truncatePrompt(&cfg, &PromptOptions{Prefix: para.Prefix}, "", nil, "")
+
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	rsp := h.CallLLM(c, para)
+	if rsp.Status != model.StatusSuccess || len(llm.prompts) != 2 || llm.prompts[1] >= llm.prompts[0] {
+		t.Fatalf("expected a successful retry with a smaller prompt, got %s %v", rsp.Status, llm.prompts)
+	}
+	if rsp.Verbose == nil || math.Abs(rsp.Verbose.TokenFactor-1.1) > 1e-9 {
+		t.Errorf("expected the tightened factor in verbose, got %+v", rsp.Verbose)
+	}
+	if f := Calibration.Factor("window"); f <= 1.0 || f > 1.1 {
+		t.Errorf("expected the factor kept tightened after the retry, got %v", f)
+	}
+
+	// 收紧后提示词没有变短时不重试
+	llm.prompts, llm.window = nil, 5
+	para = &model.CompletionParameter{Model: "window", Prefix: "short\n"}
+	para.PromptTokens, para.TokenFactor = h.truncatePrompt(&cfg, &PromptOptions{Prefix: para.Prefix}, "", nil, "")
+	if rsp := h.CallLLM(c, para); rsp.Status != model.StatusModelError || len(llm.prompts) != 1 {
+		t.Errorf("expected no retry for a prompt within the budget, got %s %v", rsp.Status, llm.prompts)
+	}
+}
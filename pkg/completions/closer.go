@@ -0,0 +1,200 @@
+package completions
+
+import (
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+)
+
+// 本地补全闭合符号时响应中的模型名
+const LocalCloserModel = "local-closer"
+
+// 语言没有配置引号时的字符串引号，语句结束符等见LanguageProfile
+const defaultCloserQuotes = "\"'`"
+
+// 行首是这些关键字时是控制语句，闭合括号后不追加语句结束符
+var closerControlKeywords = []string{"if", "else", "for", "while", "switch", "catch", "do", "with", "elif", "until"}
+
+var closingPairs = map[rune]rune{'(': ')', '[': ']', '{': '}'}
+
+/**
+ * 自动触发时在本地补全闭合符号
+ * @param {*CompletionContext} c - 补全上下文
+ * @returns {*CompletionResponse} 返回本地生成的成功响应，不适用时返回nil
+ * @description
+ * - 只处理自动触发(AUTO)的请求，手动触发和续写都交给模型
+ * - 光标行的语句只缺右括号/引号时，直接返回闭合序列，不调用模型，模型名为local-closer
+ * - 记录本地补全的次数
+ */
+func (in *CompletionInput) serveLocalCloser(c *CompletionContext) *CompletionResponse {
+	if !c.Enabled(feature_flag.Closer) || strings.ToUpper(in.TriggerMode) != "AUTO" {
+		return nil
+	}
+	language := in.EffectiveLanguage()
+	text := localCloser(language, in.Processed.Prefix, in.Processed.Suffix)
+	if text == "" {
+		return nil
+	}
+	metrics.IncrementLocalCloser(languageLabel(language))
+	c.Log().Debug("Serve local closer", zap.String("closer", text))
+	rsp := SuccessResponse(in.CompletionID, LocalCloserModel, text, CompletionAnchor{}, c.Perf,
+		&model.CompletionVerbose{Id: in.CompletionID})
+	rsp.Choices[0].Confidence = 1 // 闭合符号由本地确定，不是模型生成的
+	in.AttachVerbose(rsp)
+	return attachBudget(rsp, in.Budget, c.Perf)
+}
+
+/**
+ * 计算光标行语句缺少的闭合序列
+ * @param {string} language - 编程语言
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @returns {string} 返回闭合序列，不适用时返回空字符串
+ * @description
+ * - 光标须在行尾(行后缀为空白)，光标行有未闭合的括号或字符串
+ * - 字符串中的括号不计入，转义字符跳过
+ * - 语句看起来还没写完时不处理：最后一个字符是左括号、逗号、运算符等，或字符串刚开始
+ * - 后缀的第一个非空白字符已经是需要的闭合符号时不处理
+ * - 按嵌套顺序闭合，完全闭合后按语言追加语句结束符(控制语句、续行除外)
+ * - 只看光标行，跨行的语句和end之类的块关键字交给模型
+ * @example
+ * localCloser("python", "x = foo(bar[1", "\n")
+ * // "])"
+ * localCloser("javascript", "let a = 1;\nlog(\"a (b", "\n")
+ * // "\");"
+ */
+func localCloser(language, prefix, suffix string) string {
+	lineStart := strings.LastIndex(prefix, "\n") + 1
+	line := prefix[lineStart:]
+	lineSuffix := suffix
+	if idx := strings.Index(suffix, "\n"); idx >= 0 {
+		lineSuffix = suffix[:idx]
+	}
+	if strings.TrimSpace(lineSuffix) != "" || strings.TrimSpace(line) == "" {
+		return ""
+	}
+
+	stack, quote, last, ok := scanStatement(language, line)
+	if !ok || (len(stack) == 0 && quote == 0) {
+		return ""
+	}
+	// 字符串刚开始，或最后是左括号、逗号、运算符等，语句还没写完
+	if quote != 0 {
+		if last == quote {
+			return ""
+		}
+	} else if !unicode.IsLetter(last) && !unicode.IsDigit(last) && last != '_' &&
+		!strings.ContainsRune(")]}"+quotesOf(language), last) {
+		return ""
+	}
+
+	var sb strings.Builder
+	if quote != 0 {
+		sb.WriteRune(quote)
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		sb.WriteRune(closingPairs[stack[i]])
+	}
+	closer := sb.String()
+
+	// 后缀已经闭合
+	if next := strings.TrimLeft(suffix, " \t\r\n"); next != "" && next[0] == closer[0] {
+		return ""
+	}
+	if len(stack) > 0 && needTerminator(language, prefix[:lineStart], line) {
+		closer += profileOf(language).Terminator
+	}
+	return closer
+}
+
+// 语言的字符串引号
+func quotesOf(language string) string {
+	if quotes := profileOf(language).Quotes; quotes != "" {
+		return quotes
+	}
+	return defaultCloserQuotes
+}
+
+/**
+ * 扫描光标行的括号和字符串
+ * @returns 未闭合的左括号(按出现顺序)，未闭合字符串的引号(0表示不在字符串中)，
+ *   最后一个非空白字符，是否可以处理(遇到注释或多余的右括号时不处理)
+ */
+func scanStatement(language, line string) ([]rune, rune, rune, bool) {
+	quotes := quotesOf(language)
+	comment := profileOf(language).Comment.Line
+	if comment == "" {
+		comment = "//"
+	}
+	var stack []rune
+	var quote, last rune
+	escaped := false
+	for i, ch := range line {
+		if !unicode.IsSpace(ch) {
+			last = ch
+		}
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line[i:], comment) || strings.HasPrefix(line[i:], "/*"):
+			return nil, 0, 0, false
+		case strings.ContainsRune(quotes, ch):
+			quote = ch
+		case closingPairs[ch] != 0:
+			stack = append(stack, ch)
+		case ch == ')' || ch == ']' || ch == '}':
+			if len(stack) == 0 || closingPairs[stack[len(stack)-1]] != ch {
+				return nil, 0, 0, false
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return stack, quote, last, true
+}
+
+// 完全闭合后是否追加语句结束符
+func needTerminator(language, before, line string) bool {
+	profile := profileOf(language)
+	terminator := profile.Terminator
+	if terminator == "" {
+		return false
+	}
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	if len(fields) > 0 {
+		for _, keyword := range closerControlKeywords {
+			if fields[0] == keyword {
+				return false
+			}
+		}
+	}
+	// 上一行没有结束，光标行是续行
+	prevLines := strings.Split(strings.TrimRight(before, " \t\r\n"), "\n")
+	prev := strings.TrimSpace(prevLines[len(prevLines)-1])
+	if strings.HasSuffix(prev, "(") || strings.HasSuffix(prev, "[") || strings.HasSuffix(prev, ",") {
+		return false
+	}
+	if !profile.OptionalTerminator {
+		return true
+	}
+	for _, l := range prevLines {
+		if strings.HasSuffix(strings.TrimSpace(l), terminator) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package completions
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test synthesizing the closing sequence of the cursor line
+// go test ./pkg/completions/ -v -run Test_LocalCloser
+func Test_LocalCloser(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		prefix   string
+		suffix   string
+		closer   string
+	}{
+		{"nested brackets", "python", "def f():\n    x = foo(bar[baz(1", "\n", ")])"},
+		{"nested with terminator", "java", "class A {\n  void f() {\n    list.add(map.get(keys[0", "\n  }\n}\n", "]));"},
+		{"object argument", "javascript", "let a = 1;\nrender({ id: 1", "\n", "});"},
+		{"semicolon-less style", "javascript", "let a = 1\nrender({ id: 1", "\n", "})"},
+		{"brackets in string", "python", "print(\"a(b[\" + name", "\n", ")"},
+		{"escaped quote in string", "go", "fmt.Println(\"say \\\"(hi\\\"\", name", "\n}\n", ")"},
+		{"cursor in string", "javascript", "let a = 1;\nconsole.log(\"hello (world", "\n", "\");"},
+		{"control statement", "c", "int main() {\n  if (a > b", "\n}\n", ")"},
+		{"continuation line", "c", "  printf(\"%d\",\n    max(a, b", "\n", ")"},
+		{"closed in line suffix", "python", "x = foo(1", ")\n", ""},
+		{"closed on next line", "javascript", "render({ id: 1", "\n})\n", ""},
+		{"block opened", "go", "func main() {", "\n}\n", ""},
+		{"trailing comma", "python", "x = foo(1, ", "\n", ""},
+		{"string just opened", "python", "x = foo(\"", "\n", ""},
+		{"balanced", "python", "x = foo(1)", "\n", ""},
+		{"comment", "javascript", "// call foo(1", "\n", ""},
+		{"cursor in the middle", "python", "x = foo(1", " + 2\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localCloser(tt.language, tt.prefix, tt.suffix); got != tt.closer {
+				t.Errorf("expected %q, got %q", tt.closer, got)
+			}
+		})
+	}
+}
+
+// to test that only enabled AUTO requests take the fast path
+// go test ./pkg/completions/ -v -run Test_LocalCloserPreprocess
+func Test_LocalCloserPreprocess(t *testing.T) {
+	hits, restore := setupContextServer(`{"data":{"list":[]}}`)
+	defer restore()
+	saved := config.Wrapper.Closer
+	defer func() { config.Wrapper.Closer = saved }()
+
+	newInput := func(mode string) *CompletionInput {
+		in := newContextInput(false)
+		in.TriggerMode = mode
+		in.Prompts.Prefix = "const a = 1;\nconst date = formatDate(now"
+		in.Prompts.Suffix = "\n"
+		return in
+	}
+
+	config.Wrapper.Closer.Enabled = false
+	if _, rsp := preprocess(newInput("AUTO")); rsp != nil {
+		t.Fatalf("expected the model path when disabled, got %+v", rsp)
+	}
+
+	config.Wrapper.Closer.Enabled = true
+	before := atomic.LoadInt32(hits)
+	_, rsp := preprocess(newInput("AUTO"))
+	if rsp == nil || rsp.Model != LocalCloserModel || rsp.Choices[0].Text != ");" {
+		t.Fatalf("expected a local closer response, got %+v", rsp)
+	}
+	if atomic.LoadInt32(hits) != before {
+		t.Error("expected no context search on the fast path")
+	}
+	if _, rsp := preprocess(newInput("MANUAL")); rsp != nil {
+		t.Errorf("expected manual requests to go to the model, got %+v", rsp)
+	}
+}
@@ -2,11 +2,20 @@ package completions
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"code-completion/pkg/codebase_context"
 	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
+
+	"go.uber.org/zap"
 )
 
 /**
@@ -28,14 +37,21 @@ type CompletionHandler struct {
  * - 封装补全处理过程中需要的上下文信息
  * - 包含context.Context用于请求控制和超时处理
  * - 包含性能统计信息用于监控补全处理过程
+ * - 包含请求级logger，该请求各阶段的日志都带有completion_id等字段
+ * - 包含代码上下文客户端，由服务初始化时创建并注入，为nil时不获取代码上下文
+ * - 包含渐进式上下文的缓存，由流控管理器注入，为nil时同步获取代码上下文
  * - 用于在补全处理的不同阶段传递状态和数据
  * @example
  * perf := &CompletionPerformance{ReceiveTime: time.Now()}
  * ctx := NewCompletionContext(context.Background(), perf)
+ * ctx.ContextClient = client
  */
 type CompletionContext struct {
-	Ctx  context.Context
-	Perf *CompletionPerformance
+	Ctx           context.Context
+	Perf          *CompletionPerformance
+	Logger        *zap.Logger
+	ContextClient *codebase_context.ContextClient
+	Prefetch      *PrefetchCache
 }
 
 /**
@@ -46,6 +62,7 @@ type CompletionContext struct {
  * @description
  * - 初始化补全上下文对象
  * - 设置上下文对象和性能统计信息
+ * - 使用ctx中的请求级logger，没有时使用全局logger
  * - 用于在补全处理过程中传递状态和数据
  * - 简单的构造函数模式
  * @example
@@ -54,32 +71,62 @@ type CompletionContext struct {
  */
 func NewCompletionContext(ctx context.Context, perf *CompletionPerformance) *CompletionContext {
 	return &CompletionContext{
-		Ctx:  ctx,
-		Perf: perf,
+		Ctx:    ctx,
+		Perf:   perf,
+		Logger: logger.FromContext(ctx),
+	}
+}
+
+// Log 返回请求级logger，未设置时返回全局logger
+func (c *CompletionContext) Log() *zap.Logger {
+	if c == nil || c.Logger == nil {
+		return zap.L()
 	}
+	return c.Logger
+}
+
+// Enabled 返回功能开关对该请求是否开启，同一开关在请求内只求值一次，见feature_flag.Evaluator
+func (c *CompletionContext) Enabled(flag string) bool {
+	if c == nil {
+		return feature_flag.FromContext(nil).Enabled(flag)
+	}
+	return feature_flag.FromContext(c.Ctx).Enabled(flag)
+}
+
+/**
+ * 创建请求级logger
+ * @param {string} completionID - 补全请求ID
+ * @param {string} clientID - 客户端ID
+ * @param {string} modelName - 模型名称
+ * @param {string} language - 编程语言
+ * @returns {*zap.Logger} 返回带有请求字段的logger
+ * @description
+ * - 通过logger.WithContext放入请求上下文后，过滤器、上下文检索、模型池、模型和后置处理都使用它记录日志
+ * @example
+ * l := NewRequestLogger("cmpl-1", "client-1", "deepseek", "go")
+ * ctx = logger.WithContext(ctx, l)
+ */
+func NewRequestLogger(completionID, clientID, modelName, language string) *zap.Logger {
+	return zap.L().With(
+		zap.String("completion_id", completionID),
+		zap.String("client_id", clientID),
+		zap.String("model", modelName),
+		zap.String("language", language))
 }
 
 /**
  * 创建新的补全处理器
- * @param {model.LLM} m - 大语言模型实例，如果为nil则使用自动选择的模型
+ * @param {model.LLM} m - 大语言模型实例，由模型池选择
  * @returns {*CompletionHandler} 返回初始化好的补全处理器对象指针
  * @description
  * - 创建并初始化补全处理器实例
- * - 如果传入的模型为nil，使用自动选择的模型
  * - 获取模型配置信息并保存到处理器中
  * - 返回可用于处理补全请求的处理器
+ * - 模型(含同一个逻辑模型的多个副本)只由stream_controller的模型池选择
  * @example
- * handler := NewCompletionHandler(nil)
- * // 使用自动选择的模型
- *
- * customModel := model.GetAutoModel()
- * handler := NewCompletionHandler(customModel)
- * // 使用指定的模型
+ * handler := NewCompletionHandler(pool.llm)
  */
 func NewCompletionHandler(m model.LLM) *CompletionHandler {
-	if m == nil {
-		m = model.GetAutoModel()
-	}
 	return &CompletionHandler{
 		llm: m,
 		cfg: m.Config(),
@@ -88,7 +135,17 @@ func NewCompletionHandler(m model.LLM) *CompletionHandler {
 
 func (h *CompletionHandler) Adapt(input *CompletionInput) *model.CompletionParameter {
 	// 3. 补全模型相关的前置处理 （拼接prompt策略，单行/多行补全策略，裁剪过长上下文）
-	h.truncatePrompt(h.cfg, &input.Processed)
+	preamble := renderPreamble(h.cfg.PromptPreamble, PreambleData{
+		FilePath: input.Processed.FileProjectPath,
+		Language: input.LanguageID,
+	})
+	if input.Budget != nil {
+		input.Budget.ModelWindow = h.cfg.MaxPrefix + h.cfg.MaxSuffix
+	}
+	input.Processed.Language = input.EffectiveLanguage()
+	input.Processed.SuffixPolicy = resolveSuffixPolicy(h.cfg, input.EffectiveLanguage(), input.Extra)
+	promptTokens, tokenFactor := h.truncatePrompt(h.cfg, &input.Processed, preamble, input.Budget,
+		prefixCacheKey(input.ClientID, input.Processed.FileProjectPath))
 
 	// 4. 准备停用词，根据是否单行补全调整停用词
 	stopWords := h.prepareStopWords(input)
@@ -98,13 +155,46 @@ func (h *CompletionHandler) Adapt(input *CompletionInput) *model.CompletionParam
 	para.Model = input.Model
 	para.ClientID = input.ClientID
 	para.CompletionID = input.CompletionID
-	para.Language = input.LanguageID
+	para.Language = input.EffectiveLanguage()
+	if input.Region != nil {
+		para.Block = input.Region.Block
+	}
 	para.Prefix = input.Processed.Prefix
 	para.Suffix = input.Processed.Suffix
 	para.CodeContext = input.Processed.CodeContext
+	para.PromptTokens = promptTokens
+	para.TokenFactor = tokenFactor
+	para.SuffixPolicy = input.Processed.SuffixPolicy.SuffixPolicy
+	para.SuffixLines = input.Processed.SuffixPolicy.SuffixLines
 	para.Stop = stopWords
 	para.MaxTokens = h.cfg.MaxOutput
+	// 测试用例通常比生产代码长，按比例放大输出长度
+	if input.TestFile != nil && config.Wrapper.TestFile.MaxOutputScale > 0 {
+		para.MaxTokens = int(float64(para.MaxTokens) * config.Wrapper.TestFile.MaxOutputScale)
+	}
+	if input.Shape != nil && input.Shape.MaxTokens > 0 {
+		para.MaxTokens = min(para.MaxTokens, input.Shape.MaxTokens)
+	}
 	para.Temperature = float32(input.Temperature)
+	para.TriggerMode = input.TriggerMode
+	para.PruneMode = input.PruneMode
+	if input.Fallback != nil {
+		para.LanguageFamily = input.Fallback.Family
+	}
+	if input.Literal != nil {
+		para.Literal = input.Literal.Kind
+	}
+	para.Arguments = input.Arguments
+	para.MaxLineWidth = input.MaxLineWidth
+	para.Verbose = input.Verbose
+	para.FastPath = input.FastPath
+	para.Budget = input.Budget
+	para.Provenance = input.Processed.Provenance
+	para.Style = input.Style
+	para.Authorization = input.Headers.Get("Authorization")
+	if score, ok := input.Extra["score"].(float64); ok {
+		para.HideScore = &score
+	}
 	return &para
 }
 
@@ -121,6 +211,7 @@ func (h *CompletionHandler) Adapt(input *CompletionInput) *model.CompletionParam
  * - 记录模型处理时间和token使用情况
  * - 对生成的补全结果进行后处理和修剪
  * - 构建并返回最终的补全响应
+ * - 没有经过Adapt的请求(V2、OpenAI接口)按模型的后缀策略裁剪后缀，见applyPreparedSuffixPolicy
  * @throws
  * - 模型响应失败时返回错误响应
  * - 补全结果为空时返回空状态响应
@@ -131,34 +222,319 @@ func (h *CompletionHandler) Adapt(input *CompletionInput) *model.CompletionParam
  */
 func (h *CompletionHandler) CallLLM(c *CompletionContext, para *model.CompletionParameter) *CompletionResponse {
 	modelStartTime := time.Now().Local()
-	rsp, verbose, completionStatus, err := h.llm.Completions(c.Ctx, para)
+	requested := para.PruneMode
+	para.PruneMode = h.resolvePruneMode(requested)
+	metrics.IncrementPruneMode(para.Model, requestedPruneMode(requested), para.PruneMode)
+	if para.PruneMode != PruneFull {
+		c.Log().Info("Prune mode relaxed by request",
+			zap.String("requested", requested),
+			zap.String("effective", para.PruneMode))
+	}
+	h.applyPreparedSuffixPolicy(para)
+	a := h.attempt(c, para)
+	if errors.Is(a.err, model.ErrContextLength) {
+		a = h.retryContextLength(c, para, a, modelStartTime)
+	}
+	if a.verbose != nil && para.TokenFactor > 0 {
+		a.verbose.TokenFactor = para.TokenFactor
+	}
+	if a.verbose != nil {
+		a.verbose.SuffixPolicy, a.verbose.SuffixLines = para.SuffixPolicy, para.SuffixLines
+	}
+	if a.discarded() && h.canRetryPrune(c, para) {
+		a = h.retryAfterPrune(c, para, a)
+	}
 	modelEndTime := time.Now().Local()
 	c.Perf.LLMDuration = modelEndTime.Sub(modelStartTime).Milliseconds()
+	if para.Budget != nil {
+		para.Budget.Latency.ModelUs = modelEndTime.Sub(modelStartTime).Microseconds() - para.Budget.Latency.PruneUs
+	}
 
-	if completionStatus != model.StatusSuccess {
+	if a.status != model.StatusSuccess {
 		c.Perf.PromptTokens = h.getTokensCount(para.Prefix) + h.getTokensCount(para.CodeContext)
-		return ErrorResponse(para.CompletionID, para.Model, completionStatus, c.Perf, verbose, err)
+		accountEarlier(c.Perf, a.earlier)
+		return attachBudget(ErrorResponse(para.CompletionID, para.Model, a.status, c.Perf, a.verbose, a.err), para.Budget, c.Perf)
 	}
 
-	var completionText string
-	if len(rsp.Choices) > 0 {
-		completionText = rsp.Choices[0].Text
+	Calibration.Observe(h.cfg.ModelName, para.PromptTokens, a.rsp.Usage.PromptTokens)
+	c.Perf.PromptTokens = a.rsp.Usage.PromptTokens
+	c.Perf.CompletionTokens = a.rsp.Usage.CompletionTokens
+	if len(a.rsp.Choices) > 1 {
+		h.accountChoices(c, a)
 	}
-	if completionText != "" {
-		if !h.cfg.DisablePrune {
-			completionText = h.pruneCompletionCode(completionText, para.Prefix, para.Suffix, para.Language)
+	accountEarlier(c.Perf, a.earlier)
+	c.Perf.TotalTokens = c.Perf.CompletionTokens + c.Perf.PromptTokens
+
+	if a.text == "" {
+		rsp := ErrorResponse(para.CompletionID, para.Model, model.StatusEmpty, c.Perf, a.verbose, fmt.Errorf("empty"))
+		rsp.Raw = a.raw
+		rsp.Hits = a.hits
+		rsp.Discarded = a.discarded()
+		return attachBudget(rsp, para.Budget, c.Perf)
+	}
+
+	// 7. 构建响应，附加置信度，请求verbose时附加提示词预算报告
+	rsp := SuccessResponse(para.CompletionID, para.Model, a.text, a.anchor, c.Perf, a.verbose)
+	rsp.Raw = a.raw
+	rsp.Hits = a.hits
+	h.attachConfidence(rsp, para, a, c.Perf)
+	return attachBudget(rsp, para.Budget, c.Perf)
+}
+
+/**
+ * 上游报告提示词超过上下文长度时，收紧校准系数，截止时间允许时按新的预算截断后重试一次
+ * @param {*CompletionContext} c - 补全上下文
+ * @param {*model.CompletionParameter} para - 补全参数，重试时截断其中的提示词
+ * @param {*completionAttempt} a - 失败的调用
+ * @param {time.Time} start - 开始调用模型的时间，剩余时间不足一次调用的耗时时不重试
+ * @returns {*completionAttempt} 返回重试的结果，不重试时返回a
+ * @description
+ * - 前言已拼接在上下文中，重试截断上下文时从最前面(前言)开始截掉
+ * - 收紧后提示词没有变短(本来就在预算内)时不重试
+ */
+func (h *CompletionHandler) retryContextLength(c *CompletionContext, para *model.CompletionParameter, a *completionAttempt, start time.Time) *completionAttempt {
+	factor := Calibration.Tighten(h.cfg.ModelName)
+	deadline, ok := c.Ctx.Deadline()
+	if para.PromptTokens == 0 || ok && time.Until(deadline) < time.Since(start) {
+		metrics.IncrementContextLengthErrors(h.cfg.ModelName, false)
+		return a
+	}
+	ppt := PromptOptions{Prefix: para.Prefix, Suffix: para.Suffix, CodeContext: para.CodeContext,
+		SuffixPolicy: config.ModelLanguageConfig{SuffixPolicy: para.SuffixPolicy, SuffixLines: para.SuffixLines}}
+	promptTokens, tokenFactor := h.truncatePrompt(h.cfg, &ppt, "", nil, "")
+	if promptTokens >= para.PromptTokens {
+		metrics.IncrementContextLengthErrors(h.cfg.ModelName, false)
+		return a
+	}
+	metrics.IncrementContextLengthErrors(h.cfg.ModelName, true)
+	c.Log().Warn("Prompt exceeded the upstream context length, retry with a tightened budget",
+		zap.Float64("factor", factor), zap.Int("promptTokens", para.PromptTokens), zap.Int("retryTokens", promptTokens))
+	// 重新截断只从上下文开头截掉，按仍保留的末尾更新片段的来源
+	if len(para.Provenance) > 0 {
+		para.Provenance = retraceSnippets(para.Provenance, commonSuffixLen(para.CodeContext, ppt.CodeContext), h.cfg.TruncationMarkers)
+		if para.Budget != nil {
+			para.Budget.Snippets = para.Provenance
 		}
 	}
-	c.Perf.PromptTokens = rsp.Usage.PromptTokens
-	c.Perf.CompletionTokens = rsp.Usage.CompletionTokens
-	c.Perf.TotalTokens = c.Perf.CompletionTokens + c.Perf.PromptTokens
+	para.Prefix, para.Suffix, para.CodeContext = ppt.Prefix, ppt.Suffix, ppt.CodeContext
+	para.PromptTokens, para.TokenFactor = promptTokens, tokenFactor
+	retry := h.attempt(c, para)
+	retry.earlier = a.billed()
+	return retry
+}
 
-	if completionText == "" {
-		return ErrorResponse(para.CompletionID, para.Model, model.StatusEmpty, c.Perf, verbose, fmt.Errorf("empty"))
+// 根据本次补全的信号计算置信度，附加到补全结果和Verbose中
+func (h *CompletionHandler) attachConfidence(rsp *CompletionResponse, para *model.CompletionParameter, a *completionAttempt, perf *CompletionPerformance) {
+	signals := confidenceSignals{
+		hideScore:        para.HideScore,
+		hits:             a.hits,
+		completionTokens: perf.CompletionTokens,
+		maxTokens:        para.MaxTokens,
+	}
+	if len(a.rsp.Choices) > 0 {
+		signals.finishReason = a.rsp.Choices[0].FinishReason
+	}
+	report := computeConfidence(config.Wrapper.Confidence, signals)
+	rsp.Choices[0].Confidence = report.Score
+	metrics.ObserveConfidence(languageLabel(para.Language), report.Score)
+	if para.FastPath && !para.Verbose {
+		return
+	}
+	if rsp.Verbose == nil {
+		rsp.Verbose = &model.CompletionVerbose{}
 	}
+	rsp.Verbose.Confidence = report
+}
+
+// 一次模型调用及其后置处理的结果
+type completionAttempt struct {
+	rsp     *model.CompletionResponse
+	verbose *model.CompletionVerbose
+	status  model.CompletionStatus
+	err     error
+	raw     string           // 模型输出的补全内容
+	text    string           // 后置处理后的补全内容
+	anchor  CompletionAnchor // 补全锚点信息
+	hits    []string         // 命中的后置处理器
+	earlier billedUsage      // 被本次调用取代的此前调用(上下文长度超限、后置处理丢弃后重试)的用量合计
+}
+
+// 模型给出了补全内容，但被后置处理整体丢弃
+func (a *completionAttempt) discarded() bool {
+	return a.status == model.StatusSuccess && a.raw != "" && a.text == ""
+}
+
+func (a *completionAttempt) record(temperature float32) model.CompletionAttempt {
+	record := model.CompletionAttempt{
+		Temperature: temperature,
+		Text:        a.raw,
+		Pruned:      a.text,
+		Hits:        a.hits,
+	}
+	if a.verbose != nil {
+		record.Output = a.verbose.Output
+	}
+	return record
+}
+
+// 调用模型并对补全内容进行后置处理
+func (h *CompletionHandler) attempt(c *CompletionContext, para *model.CompletionParameter) *completionAttempt {
+	var a completionAttempt
+	deadline := StrictDeadlineFrom(c.Ctx)
+	deadline.Enter(PhaseModel)
+	a.rsp, a.verbose, a.status, a.err = h.llm.Completions(c.Ctx, para)
+	if a.status != model.StatusSuccess {
+		return &a
+	}
+	deadline.Enter(PhasePrune)
+	postStart := time.Now()
+	if para.Budget != nil {
+		defer func() {
+			para.Budget.Latency.PruneUs += time.Since(postStart).Microseconds()
+		}()
+	}
+	var finishReason string
+	if len(a.rsp.Choices) > 0 {
+		a.raw = a.rsp.Choices[0].Text
+		finishReason = a.rsp.Choices[0].FinishReason
+	}
+	a.text = a.raw
+	// 补全结束清理：去掉停用词残留，不受DisablePrune和修剪模式影响
+	if a.raw != "" {
+		finish := &PrunerContext{
+			CompletionCode: a.raw,
+			Stop:           para.Stop,
+			Sentinels:      modelSentinels(h.cfg),
+			FinishReason:   finishReason,
+		}
+		if cleanupFinish(finish) {
+			a.text = finish.CompletionCode
+			a.hits = append(a.hits, CleanupFinish)
+			c.Log().Debug("Cleanup stop artifacts of completion",
+				zap.String("pre", a.raw),
+				zap.String("post", a.text),
+				zap.String("finishReason", finishReason))
+		}
+	}
+	// 指令模型的输出提取代码块：去掉代码围栏和之前的说明文字，按模型配置开启，不受修剪模式影响
+	if a.text != "" && h.cfg.ExtractResponse {
+		extract := &PrunerContext{
+			Language:       para.Language,
+			CompletionCode: a.text,
+			Prefix:         para.Prefix,
+			Suffix:         para.Suffix,
+		}
+		if extraction := extractResponse(extract); extraction != nil {
+			c.Log().Debug("Extract code block from model response",
+				zap.String("pre", a.text),
+				zap.String("post", extract.CompletionCode),
+				zap.Int("fences", extraction.Fences))
+			a.text = extract.CompletionCode
+			a.hits = append(a.hits, ExtractResponse)
+			if a.verbose != nil {
+				a.verbose.Extraction = extraction
+			}
+		}
+	}
+	a.anchor = CompletionAnchor{CursorOffset: utf8.RuneCountInString(a.text)}
+	// 严格截止时间模式下，后置处理没有完成时返回只经过结束清理的内容
+	deadline.Offer(para.Model, a.text, a.anchor)
+	if a.text != "" && para.PruneMode != PruneOff {
+		var hits []string
+		a.text, a.anchor, hits = h.pruneCompletionCode(c, a.text, para)
+		a.hits = append(a.hits, hits...)
+	}
+	// 按插件的最大渲染宽度折行或截断，是显示上的限制，不受修剪模式影响
+	if a.text != "" && para.MaxLineWidth > 0 {
+		fit := &PrunerContext{
+			Language:       para.Language,
+			CompletionCode: a.text,
+			Prefix:         para.Prefix,
+			Suffix:         para.Suffix,
+			Anchor:         a.anchor,
+			Logger:         c.Log(),
+		}
+		if action := fitLineWidth(fit, para.MaxLineWidth); action != "" {
+			c.Log().Debug("Fit completion to the render width",
+				zap.String("pre", a.text),
+				zap.String("post", fit.CompletionCode),
+				zap.Int("width", para.MaxLineWidth))
+			a.text, a.anchor = fit.CompletionCode, fit.Anchor
+			a.hits = append(a.hits, action)
+		}
+	}
+	if a.verbose != nil {
+		a.verbose.PruneMode = para.PruneMode
+	}
+	deadline.Offer(para.Model, a.text, a.anchor)
+	return &a
+}
 
-	// 7. 构建响应
-	return SuccessResponse(para.CompletionID, para.Model, completionText, c.Perf, verbose)
+/**
+ * 判断补全被整体丢弃后是否可以重试
+ * @param {*CompletionContext} c - 补全上下文，用于判断剩余时间
+ * @param {*model.CompletionParameter} para - 补全参数，包含触发方式
+ * @returns {bool} 返回是否可以重试
+ * @description
+ * - 需要启用重试(功能开关pruneRetry)
+ * - 手动触发的请求才重试，除非配置允许自动触发的请求重试
+ * - 补全请求的剩余时间不少于配置的MinRemaining
+ */
+func (h *CompletionHandler) canRetryPrune(c *CompletionContext, para *model.CompletionParameter) bool {
+	cfg := &config.Wrapper.Prune.Retry
+	if !c.Enabled(feature_flag.PruneRetry) {
+		return false
+	}
+	if strings.ToUpper(para.TriggerMode) != "MANUAL" && !cfg.AllowAuto {
+		return false
+	}
+	if deadline, ok := c.Ctx.Deadline(); ok && time.Until(deadline) < cfg.MinRemaining {
+		return false
+	}
+	return true
+}
+
+/**
+ * 补全被整体丢弃后，调高温度重试一次
+ * @param {*CompletionContext} c - 补全上下文
+ * @param {*model.CompletionParameter} para - 已构建好的补全参数，重试时复用，不再重新获取上下文
+ * @param {*completionAttempt} first - 第一次尝试的结果
+ * @returns {*completionAttempt} 返回得到有效补全的尝试，都没有时返回第一次尝试
+ * @description
+ * - 两次尝试的模型输出和命中的处理器都记录在Verbose.Attempts中
+ * - 记录重试次数和重试得到有效补全的次数
+ */
+func (h *CompletionHandler) retryAfterPrune(c *CompletionContext, para *model.CompletionParameter, first *completionAttempt) *completionAttempt {
+	retryPara := *para
+	retryPara.Temperature = para.Temperature + float32(config.Wrapper.Prune.Retry.TemperatureStep)
+	if retryPara.Temperature > 1.0 {
+		retryPara.Temperature = 1.0
+	}
+	second := h.attempt(c, &retryPara)
+
+	rescued := second.status == model.StatusSuccess && second.text != ""
+	metrics.IncrementPruneRetries(para.Model, rescued)
+	c.Log().Info("Retry after pruning discarded the completion",
+		zap.Any("hits", first.hits),
+		zap.Float32("temperature", retryPara.Temperature),
+		zap.Bool("rescued", rescued))
+
+	result, loser := first, second
+	if rescued {
+		result, loser = second, first
+	}
+	// 两次调用都已计费，没有采用的调用计入用量
+	result.earlier = loser.billed().add(result.earlier)
+	var verbose model.CompletionVerbose
+	if result.verbose != nil {
+		verbose = *result.verbose
+	}
+	verbose.Attempts = []model.CompletionAttempt{
+		first.record(para.Temperature),
+		second.record(retryPara.Temperature),
+	}
+	result.verbose = &verbose
+	return result
 }
 
 /**
@@ -183,5 +559,6 @@ func (h *CompletionHandler) HandleCompletionOpenAI(c *CompletionContext, r *mode
 	para.Stop = r.Stop
 	para.MaxTokens = min(h.cfg.MaxOutput, r.MaxTokens)
 	para.Temperature = float32(r.Temperature)
+	para.Authorization = r.Authorization
 	return h.CallLLM(c, &para)
 }
@@ -0,0 +1,210 @@
+package completions
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// scriptedLLM returns the scripted texts in order and records the temperatures
+type scriptedLLM struct {
+	cfg          config.ModelConfig
+	texts        []string
+	temperatures []float32
+	usage        model.CompletionUsage // 每次调用报告的用量
+}
+
+func (m *scriptedLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	i := len(m.temperatures)
+	m.temperatures = append(m.temperatures, p.Temperature)
+	rsp := &model.CompletionResponse{Choices: []model.CompletionChoice{{Text: m.texts[i]}}, Usage: m.usage}
+	return rsp, &model.CompletionVerbose{Id: "scripted"}, model.StatusSuccess, nil
+}
+
+func (m *scriptedLLM) Config() *config.ModelConfig {
+	return &m.cfg
+}
+
+func (m *scriptedLLM) Tokenizer() *tokenizers.Tokenizer {
+	return nil
+}
+
+func setupPruneRetry(retry config.PruneRetryConfig) func() {
+	saved := config.Wrapper.Prune
+	config.Wrapper.Prune = config.PruneConfig{
+		Pruners: []string{DiscardExtremeRepetition},
+		Retry:   retry,
+	}
+	InitPrunerChains(&config.Wrapper.Prune)
+	return func() {
+		config.Wrapper.Prune = saved
+		InitPrunerChains(&config.Wrapper.Prune)
+	}
+}
+
+func callScripted(trigger string, timeout time.Duration, texts ...string) (*CompletionResponse, *scriptedLLM) {
+	llm := &scriptedLLM{cfg: config.ModelConfig{ModelName: "scripted"}, texts: texts,
+		usage: model.CompletionUsage{PromptTokens: 20, CompletionTokens: 5}}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c := NewCompletionContext(ctx, &CompletionPerformance{ReceiveTime: time.Now()})
+	para := &model.CompletionParameter{
+		CompletionID: "retry",
+		Model:        "scripted",
+		Language:     "javascript",
+		Prefix:       "function main() {\n",
+		Temperature:  0.1,
+		TriggerMode:  trigger,
+	}
+	return NewCompletionHandler(llm).CallLLM(c, para), llm
+}
+
+var garbageCompletion = strings.Repeat("console.log(\"hello world\");\n", 10)
+
+// to test retry after pruning discarded the whole completion
+// go test ./pkg/completions/ -v -run Test_RetryAfterPrune
+func Test_RetryAfterPrune(t *testing.T) {
+	defer setupPruneRetry(config.PruneRetryConfig{Enabled: true, MinRemaining: 100 * time.Millisecond, TemperatureStep: 0.3})()
+
+	rsp, llm := callScripted("MANUAL", time.Second, garbageCompletion, "return 0;")
+	if rsp.Status != model.StatusSuccess || rsp.Choices[0].Text != "return 0;" {
+		t.Fatalf("expected the retry to rescue the completion, got %s %q", rsp.Status, rsp.Choices[0].Text)
+	}
+	if len(llm.temperatures) != 2 || llm.temperatures[1] <= llm.temperatures[0] {
+		t.Errorf("expected one retry with higher temperature, got %v", llm.temperatures)
+	}
+	attempts := rsp.Verbose.Attempts
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts in verbose, got %d", len(attempts))
+	}
+	if attempts[0].Pruned != "" || len(attempts[0].Hits) != 1 || attempts[0].Hits[0] != DiscardExtremeRepetition {
+		t.Errorf("unexpected first attempt %+v", attempts[0])
+	}
+	if attempts[1].Pruned != "return 0;" {
+		t.Errorf("unexpected second attempt %+v", attempts[1])
+	}
+	// 两次调用都计费，返回的补全只计第二次
+	if rsp.Usage.PromptTokens != 40 || rsp.Usage.GeneratedTokens != 10 || rsp.Usage.CompletionTokens != 5 {
+		t.Errorf("expected both attempts billed, got prompt %d generated %d completion %d",
+			rsp.Usage.PromptTokens, rsp.Usage.GeneratedTokens, rsp.Usage.CompletionTokens)
+	}
+
+	// 两次都被丢弃时只重试一次
+	rsp, llm = callScripted("MANUAL", time.Second, garbageCompletion, garbageCompletion)
+	if rsp.Status != model.StatusEmpty || len(llm.temperatures) != 2 || len(rsp.Verbose.Attempts) != 2 {
+		t.Errorf("expected empty after one retry, got %s with %d calls", rsp.Status, len(llm.temperatures))
+	}
+}
+
+// to test the conditions that skip the retry
+// go test ./pkg/completions/ -v -run Test_RetryAfterPruneSkipped
+func Test_RetryAfterPruneSkipped(t *testing.T) {
+	defer setupPruneRetry(config.PruneRetryConfig{Enabled: true, MinRemaining: 500 * time.Millisecond, TemperatureStep: 0.3})()
+
+	if rsp, llm := callScripted("AUTO", time.Second, garbageCompletion, "return 0;"); rsp.Status != model.StatusEmpty || len(llm.temperatures) != 1 {
+		t.Errorf("expected no retry for AUTO trigger, got %s with %d calls", rsp.Status, len(llm.temperatures))
+	}
+	if rsp, llm := callScripted("MANUAL", 100*time.Millisecond, garbageCompletion, "return 0;"); rsp.Status != model.StatusEmpty || len(llm.temperatures) != 1 {
+		t.Errorf("expected no retry near the deadline, got %s with %d calls", rsp.Status, len(llm.temperatures))
+	}
+
+	config.Wrapper.Prune.Retry.AllowAuto = true
+	if rsp, llm := callScripted("AUTO", time.Second, garbageCompletion, "return 0;"); rsp.Status != model.StatusSuccess || len(llm.temperatures) != 2 {
+		t.Errorf("expected retry for AUTO trigger when allowed, got %s with %d calls", rsp.Status, len(llm.temperatures))
+	}
+}
+
+// to test that logs from inside the pruning chain carry the request fields
+// go test ./pkg/completions/ -v -run Test_RequestScopedLogger
+func Test_RequestScopedLogger(t *testing.T) {
+	defer setupPruneRetry(config.PruneRetryConfig{})()
+	core, logs := observer.New(zapcore.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	ctx := logger.WithContext(context.Background(), NewRequestLogger("cmpl-log", "client-log", "scripted", "javascript"))
+	c := NewCompletionContext(ctx, &CompletionPerformance{ReceiveTime: time.Now()})
+	handler := NewCompletionHandler(&scriptedLLM{cfg: config.ModelConfig{ModelName: "scripted"}})
+	if text, _, _ := handler.pruneCompletionCode(c, garbageCompletion, &model.CompletionParameter{Prefix: "function main() {\n", Language: "javascript"}); text != "" {
+		t.Fatalf("expected the completion discarded, got %q", text)
+	}
+
+	entries := logs.FilterMessage("Completion discarded by pruner").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 pruner log, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["completion_id"] != "cmpl-log" || fields["client_id"] != "client-log" {
+		t.Errorf("expected request fields in pruner log, got %v", fields)
+	}
+
+	// 未设置请求级logger时使用全局logger
+	var nilContext *CompletionContext
+	if nilContext.Log() != zap.L() {
+		t.Error("expected fallback to the global logger")
+	}
+}
+
+func callPruneMode(mode, text string) *CompletionResponse {
+	llm := &scriptedLLM{cfg: config.ModelConfig{ModelName: "scripted"}, texts: []string{text}}
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	para := &model.CompletionParameter{
+		CompletionID: "prune-mode",
+		Model:        "scripted",
+		Language:     "javascript",
+		Prefix:       regeneratePrefix,
+		Temperature:  0.1,
+		PruneMode:    mode,
+	}
+	return NewCompletionHandler(llm).CallLLM(c, para)
+}
+
+var regeneratePrefix = "function total(items) {\n  let sum = 0;\n  for (const item of items) {\n    sum += item.price;\n  }\n  return sum;\n}\n\n"
+
+// the client asks to regenerate the function above the cursor exactly
+var regenerateCompletion = "function total(items) {\n  let sum = 0;\n  for (const item of items) {\n    sum += item.price;\n  }\n  return sum;\n}"
+
+// to test the prune modes requested by clients
+// go test ./pkg/completions/ -v -run Test_PruneMode
+func Test_PruneMode(t *testing.T) {
+	saved := config.Wrapper.Prune
+	defer func() { config.Wrapper.Prune = saved }()
+	config.Wrapper.Prune = config.PruneConfig{AllowedModes: []string{"full", "light", "off"}}
+
+	rsp := callPruneMode("", regenerateCompletion)
+	if rsp.Status != model.StatusEmpty || rsp.Verbose.PruneMode != PruneFull {
+		t.Fatalf("expected the regenerated code blanked under full, got %s %q", rsp.Status, rsp.Verbose.PruneMode)
+	}
+	rsp = callPruneMode("light", regenerateCompletion)
+	if rsp.Status != model.StatusSuccess || rsp.Choices[0].Text != regenerateCompletion || rsp.Verbose.PruneMode != PruneLight {
+		t.Fatalf("expected the regenerated code kept under light, got %s %q", rsp.Status, rsp.Verbose.PruneMode)
+	}
+
+	// light still discards runaway output and cleans up stop artifacts
+	if rsp = callPruneMode("light", garbageCompletion); rsp.Status != model.StatusEmpty {
+		t.Errorf("expected extreme repetition discarded under light, got %s", rsp.Status)
+	}
+	if rsp = callPruneMode("light", regenerateCompletion+defaultFimStop); rsp.Status != model.StatusSuccess || rsp.Choices[0].Text != regenerateCompletion {
+		t.Errorf("expected the stop word removed under light, got %q", rsp.Choices[0].Text)
+	}
+	if rsp = callPruneMode("OFF", garbageCompletion+defaultFimStop); rsp.Status != model.StatusSuccess || rsp.Choices[0].Text != garbageCompletion {
+		t.Errorf("expected no pruning but the stop cleanup under off, got %s", rsp.Status)
+	}
+
+	// modes missing from the allow-list and unknown modes fall back to full
+	config.Wrapper.Prune.AllowedModes = []string{"full"}
+	for _, mode := range []string{"light", "off", "none"} {
+		if rsp = callPruneMode(mode, regenerateCompletion); rsp.Status != model.StatusEmpty || rsp.Verbose.PruneMode != PruneFull {
+			t.Errorf("expected %s to fall back to full, got %s %q", mode, rsp.Status, rsp.Verbose.PruneMode)
+		}
+	}
+}
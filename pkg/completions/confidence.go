@@ -0,0 +1,107 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"math"
+	"strings"
+)
+
+// 置信度的信号名，用于Verbose中的信号取值和权重
+const (
+	SignalHideScore    = "hideScore"
+	SignalPruners      = "pruners"
+	SignalFinishReason = "finishReason"
+	SignalLength       = "length"
+	SignalSyntaxTrim   = "syntaxTrim"
+)
+
+// 计算置信度所需的信号，都来自已经完成的处理，不需要额外调用模型或分析代码
+type confidenceSignals struct {
+	hideScore        *float64 // 隐藏分，没有计算时为nil
+	hits             []string // 命中的后置处理器
+	finishReason     string   // 模型的结束原因
+	completionTokens int      // 补全token数
+	maxTokens        int      // 请求的最大输出token数
+}
+
+// 信号的取值(0-1)，不存在的信号不在结果中
+func (s *confidenceSignals) values() map[string]float64 {
+	values := map[string]float64{}
+	if s.hideScore != nil {
+		values[SignalHideScore] = clamp01(*s.hideScore)
+	}
+	pruners := 0
+	values[SignalSyntaxTrim] = 1
+	for _, hit := range s.hits {
+		// 按规则处理的命中为"名称:规则"
+		name, _, _ := strings.Cut(hit, ":")
+		switch name {
+		case CleanupFinish, CutIndentStyle, CutQuoteStyle, WrapLineWidth, CutTerminalPunctuation: // 只调整格式，不代表补全内容有问题
+		case CutSyntaxError:
+			values[SignalSyntaxTrim] = 0
+		default:
+			pruners++
+		}
+	}
+	values[SignalPruners] = 1 / float64(1+pruners)
+	switch s.finishReason {
+	case "":
+	case "stop":
+		values[SignalFinishReason] = 1
+	case "length":
+		values[SignalFinishReason] = 0
+	default:
+		values[SignalFinishReason] = 0.5
+	}
+	if s.maxTokens > 0 && s.completionTokens > 0 {
+		values[SignalLength] = clamp01(1 - float64(s.completionTokens)/float64(s.maxTokens))
+	}
+	return values
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+/**
+ * 计算补全的置信度
+ * @param {config.ConfidenceConfig} cfg - 各信号的权重
+ * @param {confidenceSignals} s - 本次补全的信号
+ * @returns {*model.ConfidenceReport} 返回各信号的取值、生效的权重和置信度
+ * @description
+ * - 置信度为存在的信号按权重的加权平均，不存在的信号不参与计算(剩余权重重新归一化)
+ * - 隐藏分: 原值；后置处理器: 1/(1+命中数)，不计结束清理和语法错误裁剪
+ * - 结束原因: stop为1，length为0，其他为0.5；长度: 1-补全token数/最大输出
+ * - 语法错误裁剪: 生效时为0，否则为1
+ * - 没有权重大于0的信号时为0.5
+ * - 置信度只用于请求之间的比较，不是校准过的概率
+ * @example
+ * computeConfidence(cfg, confidenceSignals{finishReason: "length"})
+ */
+func computeConfidence(cfg config.ConfidenceConfig, s confidenceSignals) *model.ConfidenceReport {
+	weights := map[string]float64{
+		SignalHideScore:    cfg.HideScore,
+		SignalPruners:      cfg.Pruners,
+		SignalFinishReason: cfg.FinishReason,
+		SignalLength:       cfg.Length,
+		SignalSyntaxTrim:   cfg.SyntaxTrim,
+	}
+	report := &model.ConfidenceReport{
+		Signals: s.values(),
+		Weights: map[string]float64{},
+		Score:   0.5,
+	}
+	total, sum := 0.0, 0.0
+	for name, value := range report.Signals {
+		if w := weights[name]; w > 0 {
+			report.Weights[name] = w
+			total += w
+			sum += w * value
+		}
+	}
+	if total > 0 {
+		report.Score = math.Round(sum/total*1000) / 1000
+	}
+	return report
+}
@@ -0,0 +1,45 @@
+package completions
+
+import (
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test the confidence of constructed signal combinations, update the pinned scores deliberately when the weights change
+// go test ./pkg/completions/ -v -run Test_ComputeConfidence
+func Test_ComputeConfidence(t *testing.T) {
+	weights := config.ConfidenceConfig{HideScore: 0.35, Pruners: 0.2, FinishReason: 0.2, Length: 0.1, SyntaxTrim: 0.15}
+	high, low := 0.8, 0.4
+	cases := []struct {
+		name     string
+		weights  config.ConfidenceConfig
+		signals  confidenceSignals
+		expected float64
+		missing  string
+	}{
+		{"clean auto completion", weights,
+			confidenceSignals{hideScore: &high, finishReason: "stop", completionTokens: 20, maxTokens: 100}, 0.91, ""},
+		{"formatting only", weights,
+			confidenceSignals{hideScore: &high, hits: []string{CutIndentStyle, CutTerminalPunctuation + ":strip-semicolon-statement"},
+				finishReason: "stop", completionTokens: 20, maxTokens: 100}, 0.91, ""},
+		{"manual completion cut at max tokens", weights,
+			confidenceSignals{hits: []string{CleanupFinish, CutRepetitiveText, CutSuffixOverlap}, finishReason: "length", completionTokens: 100, maxTokens: 100}, 0.333, SignalHideScore},
+		{"syntax trimmed without usage", weights,
+			confidenceSignals{hideScore: &low, hits: []string{CutSyntaxError}, finishReason: "eos"}, 0.489, SignalLength},
+		{"no weighted signal", config.ConfidenceConfig{Length: 1},
+			confidenceSignals{finishReason: "stop"}, 0.5, SignalLength},
+	}
+	for _, c := range cases {
+		report := computeConfidence(c.weights, c.signals)
+		if report.Score != c.expected {
+			t.Errorf("%s: expected %v, got %v (%+v)", c.name, c.expected, report.Score, report.Signals)
+		}
+		if _, ok := report.Signals[c.missing]; ok {
+			t.Errorf("%s: signal %s should be excluded", c.name, c.missing)
+		}
+		if report.Score < 0 || report.Score > 1 {
+			t.Errorf("%s: score %v out of range", c.name, report.Score)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package completions
+
+import (
+	"code-completion/pkg/model"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 严格截止时间模式中请求所处的阶段，按先后顺序
+const (
+	PhasePreprocess = "preprocess" // 预处理，含获取代码库上下文和截断提示词
+	PhaseQueue      = "queue"      // 排队等待模型池
+	PhaseModel      = "model"      // 调用模型
+	PhasePrune      = "prune"      // 后置处理
+	PhaseFinalize   = "finalize"   // 组装verbose、记录统计
+)
+
+var deadlinePhases = []string{PhasePreprocess, PhaseQueue, PhaseModel, PhasePrune, PhaseFinalize}
+
+/**
+ * 严格截止时间模式的结果，附带在响应的deadline字段中
+ * @description
+ * - 在截止时间内正常完成时只有DeadlineMs
+ * - 看门狗触发时Interrupted为当时所处的阶段，Skipped为被打断的阶段及之后的阶段
+ */
+type DeadlineReport struct {
+	DeadlineMs  int      `json:"deadline_ms"`           // 请求的strict_deadline_ms
+	Interrupted string   `json:"interrupted,omitempty"` // 看门狗触发时所处的阶段，见Phase*
+	Skipped     []string `json:"skipped,omitempty"`     // 没有执行完的阶段
+}
+
+/**
+ * 一个严格截止时间模式请求的看门狗状态
+ * @description
+ * - 各处理阶段通过Enter报告所处的阶段，通过Offer报告目前最好的补全内容
+ * - 看门狗在FireAt触发(Fire)，返回预先构建好的最小响应，只填入补全内容和阶段，不经过后置处理和verbose的组装
+ * - 触发之后处理协程的结果被丢弃，Offer不再生效
+ * - 所有方法都可以在nil上调用(普通请求)，不做任何处理
+ */
+type StrictDeadline struct {
+	DeadlineMs int       // 请求的截止时长(毫秒)
+	FireAt     time.Time // 看门狗触发的时间，截止时间减去安全余量
+
+	mutex sync.Mutex
+	phase string
+	fired bool
+	rsp   CompletionResponse // 预先构建的最小响应
+}
+
+/**
+ * 创建V1请求的严格截止时间模式的看门狗状态
+ * @param {*CompletionInput} input - 补全输入，StrictDeadlineMs为请求的截止时长
+ * @param {time.Time} receive - 开始计时的时间，收到请求的时间见ReceiveTimeFrom
+ * @param {time.Duration} margin - 安全余量，留给序列化和写出响应
+ * @returns {*StrictDeadline} 返回看门狗状态，所处阶段为预处理
+ * @example
+ * sd := NewStrictDeadline(input, time.Now(), 50*time.Millisecond)
+ * // input.StrictDeadlineMs = 900时，sd.FireAt为850ms之后
+ */
+func NewStrictDeadline(input *CompletionInput, receive time.Time, margin time.Duration) *StrictDeadline {
+	d := NewStrictDeadlineFor(input.StrictDeadlineMs, input.CompletionID, input.Model, receive, margin)
+	d.rsp.ClientSequence = input.ClientSequence
+	return d
+}
+
+// 创建V2和OpenAI格式请求的看门狗状态，最小响应只带补全ID和模型名称，见NewStrictDeadline
+func NewStrictDeadlineFor(deadlineMs int, completionID, modelName string, receive time.Time, margin time.Duration) *StrictDeadline {
+	deadline := time.Duration(deadlineMs) * time.Millisecond
+	return &StrictDeadline{
+		DeadlineMs: deadlineMs,
+		FireAt:     receive.Add(deadline - margin),
+		phase:      PhasePreprocess,
+		rsp: CompletionResponse{
+			ID:      completionID,
+			Model:   modelName,
+			Object:  "text_completion",
+			Choices: []CompletionChoice{{Text: ""}},
+			Created: int(receive.Unix()),
+			Usage:   CompletionPerformance{ReceiveTime: receive},
+		},
+	}
+}
+
+// 最小响应的补全ID，用于记录日志
+func (d *StrictDeadline) CompletionID() string {
+	return d.rsp.ID
+}
+
+// 进入新的处理阶段，到了触发时间之后不再改变，保留被打断的阶段
+func (d *StrictDeadline) Enter(phase string) {
+	if d == nil || d.Expired() {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.phase = phase
+}
+
+// 报告目前最好的补全内容(后置处理可能没有完成)，modelName为空时不改变模型名称
+func (d *StrictDeadline) Offer(modelName, text string, anchor CompletionAnchor) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.fired {
+		return
+	}
+	if modelName != "" {
+		d.rsp.Model = modelName
+	}
+	d.rsp.Choices[0] = CompletionChoice{Text: text, CompletionAnchor: anchor}
+}
+
+// 看门狗是否已到触发时间，此后各阶段应尽快结束
+func (d *StrictDeadline) Expired() bool {
+	return d != nil && !time.Now().Before(d.FireAt)
+}
+
+// 看门狗是否已触发，已返回最小响应
+func (d *StrictDeadline) Fired() bool {
+	if d == nil {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.fired
+}
+
+/**
+ * 看门狗触发，返回目前最好的结果
+ * @returns {*CompletionResponse} 返回最小响应：有补全内容时状态为success，否则为timeout；deadline说明被打断和跳过的阶段
+ * @description
+ * - 不记录补全的耗时指标，处理协程结束时仍按实际结果记录；请求数在写响应时按返回的响应记录
+ * - 锚点的光标位置按报告的补全内容重新计算，后置处理没有完成时可能没有校正
+ */
+func (d *StrictDeadline) Fire() *CompletionResponse {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.fired = true
+	rsp := d.rsp
+	rsp.Choices = slices.Clone(d.rsp.Choices)
+	rsp.Choices[0].CursorOffset = utf8.RuneCountInString(rsp.Choices[0].Text)
+	rsp.Usage.TotalDuration = time.Since(rsp.Usage.ReceiveTime).Milliseconds()
+	rsp.Status = model.StatusSuccess
+	if rsp.Choices[0].Text == "" {
+		rsp.Status = model.StatusTimeout
+		rsp.Error = fmt.Sprintf("strict deadline of %dms reached during %s", d.DeadlineMs, d.phase)
+	}
+	rsp.Deadline = &DeadlineReport{
+		DeadlineMs:  d.DeadlineMs,
+		Interrupted: d.phase,
+		Skipped:     deadlinePhases[max(slices.Index(deadlinePhases, d.phase), 0):],
+	}
+	return &rsp
+}
+
+// 在截止时间内正常完成的响应附带deadline，返回副本，不修改可能被去重重放共用的响应
+func (d *StrictDeadline) Met(rsp *CompletionResponse) *CompletionResponse {
+	if d == nil || rsp == nil {
+		return rsp
+	}
+	met := *rsp
+	met.Deadline = &DeadlineReport{DeadlineMs: d.DeadlineMs}
+	return &met
+}
+
+type strictDeadlineKey struct{}
+
+// 将请求的看门狗状态放入ctx，之后的处理阶段(包括模型池中的调用)从ctx中取出
+func WithStrictDeadline(ctx context.Context, d *StrictDeadline) context.Context {
+	return context.WithValue(ctx, strictDeadlineKey{}, d)
+}
+
+// ctx中请求的看门狗状态，不是严格截止时间模式时返回nil
+func StrictDeadlineFrom(ctx context.Context) *StrictDeadline {
+	if ctx == nil {
+		return nil
+	}
+	d, _ := ctx.Value(strictDeadlineKey{}).(*StrictDeadline)
+	return d
+}
+
+type receiveTimeKey struct{}
+
+// 将收到请求的时间放入ctx，由接口的中间件在读取请求体之前调用
+func WithReceiveTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, receiveTimeKey{}, t)
+}
+
+// ctx中收到请求的时间，没有时返回零值
+func ReceiveTimeFrom(ctx context.Context) time.Time {
+	if ctx == nil {
+		return time.Time{}
+	}
+	t, _ := ctx.Value(receiveTimeKey{}).(time.Time)
+	return t
+}
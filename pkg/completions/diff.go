@@ -0,0 +1,190 @@
+package completions
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//
+//	代码评审的diff视图中补全: 插件发送的"文件"是带+/-/空格标记和@@头的补丁片段，还原为光标附近的有效源码后再补全
+//
+
+// 光标所在行在diff中的种类，见CompletionResponse.DiffLine
+const (
+	DiffLineContext = "context" // 上下文行(空格标记)，补全修改了原有的行
+	DiffLineAdded   = "added"   // 新增的行(+标记)，或光标位于行首、还没有标记的新行
+)
+
+// 启用diff模式的依据
+const (
+	DiffSourceFlag   = "flag"   // 请求指定了diff_mode
+	DiffSourceHeader = "header" // 前缀中有@@ hunk头
+)
+
+// unified diff的hunk头: @@ -l,s +l,s @@ 可选的段落标题
+var diffHunkHeader = regexp.MustCompile(`^@@ -\d+(,\d+)? \+\d+(,\d+)? @@`)
+
+/**
+ * diff视图的还原结果
+ * @description
+ * - Source: 启用diff模式的依据，见DiffSource*
+ * - Hunks: 前缀中的hunk数
+ * - Removed: 丢弃的删除行(-标记)数
+ * - Markers: 还原后前缀各行原来的标记(空格或+)，最后一个字符为光标所在行的标记，光标位于行首时为空
+ * - CursorLine: 光标所在行的种类，见DiffLine*
+ * - LineStart: 光标位于新行的行首，该行还没有标记
+ */
+type DiffView struct {
+	Source     string `json:"source"`
+	Hunks      int    `json:"hunks"`
+	Removed    int    `json:"removed"`
+	Markers    string `json:"markers"`
+	CursorLine string `json:"cursorLine"`
+	LineStart  bool   `json:"lineStart,omitempty"`
+}
+
+/**
+ * 去掉diff的装饰，还原光标附近的有效源码
+ * @param {string} prefix - 光标前的补丁内容
+ * @param {string} suffix - 光标后的补丁内容
+ * @param {bool} flag - 请求是否指定了diff_mode
+ * @returns {*DiffView} 返回还原结果，不是diff时返回nil
+ * @returns {string} 返回还原后的前缀
+ * @returns {string} 返回还原后的后缀
+ * @description
+ * - 没有指定diff_mode时，前缀中有@@ hunk头才按diff处理
+ * - 第一个hunk头之前和"diff "开头的行之后的文件头(diff --git、index、---、+++)丢弃；没有hunk头时所有行都按hunk内的行处理
+ * - 保留上下文行和新增行并去掉标记，丢弃删除行、hunk头和"\ No newline at end of file"
+ * - 不同hunk的内容直接相接；后缀只取到下一个hunk头为止，之后的内容与光标不相邻
+ * - 光标在删除行上时不是有效的编辑位置，按上下文行处理
+ * @example
+ * view, prefix, suffix := stripDiff("@@ -1,2 +1,3 @@\n func a() {\n+\treturn", "\n }\n", false)
+ * // prefix = "func a() {\n\treturn", suffix = "\n}\n", view.CursorLine = "added"
+ */
+func stripDiff(prefix, suffix string, flag bool) (*DiffView, string, string) {
+	lines := strings.Split(prefix, "\n")
+	hasHeader := slices.ContainsFunc(lines[:len(lines)-1], diffHunkHeader.MatchString)
+	if !flag && !hasHeader {
+		return nil, prefix, suffix
+	}
+	view := &DiffView{Source: DiffSourceFlag}
+	if !flag {
+		view.Source = DiffSourceHeader
+	}
+
+	var b strings.Builder
+	var markers []byte
+	inHunk := !hasHeader
+	for _, line := range lines[:len(lines)-1] {
+		if diffHunkHeader.MatchString(line) {
+			inHunk = true
+			view.Hunks++
+			continue
+		}
+		// 多文件的diff中下一个文件的文件头
+		if strings.HasPrefix(line, "diff ") {
+			inHunk = false
+		}
+		if !inHunk {
+			continue
+		}
+		marker, content := splitDiffLine(line)
+		switch marker {
+		case '-':
+			view.Removed++
+			continue
+		case '\\':
+			continue
+		}
+		markers = append(markers, marker)
+		b.WriteString(content)
+		b.WriteByte('\n')
+	}
+
+	// 光标所在行: 标记之后的内容在前缀中，其余在后缀中
+	cursor := lines[len(lines)-1]
+	view.CursorLine = DiffLineAdded
+	view.LineStart = cursor == ""
+	if cursor != "" {
+		marker, content := splitDiffLine(cursor)
+		markers = append(markers, marker)
+		if marker != '+' {
+			view.CursorLine = DiffLineContext
+		}
+		b.WriteString(content)
+	}
+	view.Markers = string(markers)
+	return view, b.String(), stripDiffSuffix(suffix)
+}
+
+// 拆分diff的一行为标记和内容，空行(部分工具会去掉上下文空行的空格)按上下文行处理
+func splitDiffLine(line string) (byte, string) {
+	if line == "" || line == "\r" {
+		return ' ', line
+	}
+	switch line[0] {
+	case ' ', '+', '-', '\\':
+		return line[0], line[1:]
+	}
+	return ' ', line
+}
+
+// 还原后缀: 光标行的剩余部分原样保留，之后的行去掉标记，到下一个hunk头或文件头为止
+func stripDiffSuffix(suffix string) string {
+	lines := strings.Split(suffix, "\n")
+	var b strings.Builder
+	b.WriteString(lines[0])
+	for _, line := range lines[1:] {
+		if diffHunkHeader.MatchString(line) || strings.HasPrefix(line, "diff ") {
+			b.WriteByte('\n')
+			break
+		}
+		marker, content := splitDiffLine(line)
+		if marker == '-' || marker == '\\' {
+			continue
+		}
+		b.WriteByte('\n')
+		b.WriteString(content)
+	}
+	return b.String()
+}
+
+/**
+ * 给补全内容加上diff标记，插件可以直接插入补丁视图
+ * @param {string} text - 补全内容
+ * @param {*DiffView} view - 还原结果
+ * @returns {string} 返回加上标记的补全内容
+ * @description
+ * - 补全的第一行接在光标所在行之后，光标行已有标记时不再加，光标位于行首时加上+
+ * - 之后的每一行都是新增的行，加上+
+ */
+func applyDiffMarkers(text string, view *DiffView) string {
+	if text == "" {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		if i > 0 || view.LineStart {
+			lines[i] = "+" + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+/**
+ * diff模式下给返回的补全内容加上diff标记
+ * @param {*CompletionResponse} rsp - 补全响应
+ * @description
+ * - 在采纳跟踪、样本记录之后调用，这些使用还原后的源码
+ * - 响应的diff_line告知光标所在行的种类，上下文行被补全修改时插件需要把该行改为删除行加新增行
+ */
+func (in *CompletionInput) RestoreDiff(rsp *CompletionResponse) {
+	if in.Diff == nil || rsp == nil {
+		return
+	}
+	rsp.DiffLine = in.Diff.CursorLine
+	for i := range rsp.Choices {
+		rsp.Choices[i].Text = applyDiffMarkers(rsp.Choices[i].Text, in.Diff)
+	}
+}
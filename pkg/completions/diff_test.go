@@ -0,0 +1,82 @@
+package completions
+
+import (
+	"strings"
+	"testing"
+)
+
+// a two-hunk unified diff of a go file, the cursor is placed at the end of the prefix
+const testDiffPrefix = `diff --git a/cache.go b/cache.go
+index 3f2a1c4..8b7d9e0 100644
+--- a/cache.go
++++ b/cache.go
+@@ -10,6 +10,7 @@ type Cache struct {
+ 	mutex   sync.Mutex
+-	entries map[string]string
++	entries map[string]entry
++	ttl     time.Duration
+ }
+
+\ No newline at end of file
+@@ -42,5 +43,6 @@ func (c *Cache) Get(key string) (string, bool) {
+`
+
+// to test reconstructing the source of a multi-hunk diff and re-applying the markers
+// go test ./pkg/completions/ -v -run Test_StripDiff
+func Test_StripDiff(t *testing.T) {
+	// 光标在新增行上，hunk头紧邻光标行的上方
+	view, prefix, suffix := stripDiff(testDiffPrefix+"+\tif e, ok := c.", "entries[key]; ok {\n \treturn \"\", false\n-\told()\n@@ -60,2 +62,2 @@\n+more\n", false)
+	if view == nil || view.Source != DiffSourceHeader || view.Hunks != 2 || view.Removed != 1 {
+		t.Fatalf("unexpected diff view %+v", view)
+	}
+	expected := "\tmutex   sync.Mutex\n\tentries map[string]entry\n\tttl     time.Duration\n}\n\n\tif e, ok := c."
+	if prefix != expected {
+		t.Errorf("expected prefix %q, got %q", expected, prefix)
+	}
+	if suffix != "entries[key]; ok {\n\treturn \"\", false\n" {
+		t.Errorf("expected the suffix up to the next hunk, got %q", suffix)
+	}
+	if view.CursorLine != DiffLineAdded || view.Markers != " ++  +" {
+		t.Errorf("expected the cursor on an added line, got %+v", view)
+	}
+	if text := applyDiffMarkers("entries[key]\n\treturn e.value", view); text != "entries[key]\n+\treturn e.value" {
+		t.Errorf("expected + re-applied to the following lines, got %q", text)
+	}
+
+	// 光标在上下文行上
+	view, prefix, _ = stripDiff(testDiffPrefix+" \tc.mutex.", "Lock()\n", false)
+	if view == nil || view.CursorLine != DiffLineContext || !strings.HasSuffix(prefix, "}\n\n\tc.mutex.") {
+		t.Errorf("expected the cursor on a context line, got %+v %q", view, prefix)
+	}
+
+	// 光标位于hunk头下方新行的行首
+	view, prefix, _ = stripDiff(testDiffPrefix, "", false)
+	if view == nil || !view.LineStart || view.CursorLine != DiffLineAdded || !strings.HasSuffix(prefix, "}\n\n") {
+		t.Errorf("expected the cursor at the start of a new line, got %+v %q", view, prefix)
+	}
+	if text := applyDiffMarkers("func (c *Cache) Len() int {\n\treturn 0", view); text != "+func (c *Cache) Len() int {\n+\treturn 0" {
+		t.Errorf("expected every line marked, got %q", text)
+	}
+
+	// 没有hunk头也没有指定diff_mode时不处理，指定时所有行按hunk内的行处理
+	if view, _, _ := stripDiff("+a := 1\n-b := 2\n c", "", false); view != nil {
+		t.Errorf("expected no diff without hunk headers, got %+v", view)
+	}
+	view, prefix, _ = stripDiff("+a := 1\n-b := 2\n c", "", true)
+	if view == nil || view.Source != DiffSourceFlag || prefix != "a := 1\nc" {
+		t.Errorf("expected the flagged diff stripped, got %+v %q", view, prefix)
+	}
+
+	// 请求解析时还原，返回时加上标记
+	in := &CompletionInput{CompletionRequest: CompletionRequest{Prompts: &PromptOptions{
+		Prefix: testDiffPrefix + "+\treturn ", Suffix: "\n }\n"}}}
+	in.GetPrompts()
+	if in.Diff == nil || in.Processed.Suffix != "\n}\n" {
+		t.Fatalf("expected the prompts reconstructed, got %+v %q", in.Diff, in.Processed.Suffix)
+	}
+	rsp := &CompletionResponse{Choices: []CompletionChoice{{Text: "c.entries[key].value,\n\t\ttrue"}}}
+	in.RestoreDiff(rsp)
+	if rsp.DiffLine != DiffLineAdded || rsp.Choices[0].Text != "c.entries[key].value,\n+\t\ttrue" {
+		t.Errorf("unexpected restored response %q %q", rsp.DiffLine, rsp.Choices[0].Text)
+	}
+}
@@ -0,0 +1,345 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 补全为空(或被过滤器拒绝)的原因
+const (
+	EmptyReasonModel             = "model_empty"        // 模型没有给出补全内容
+	EmptyReasonExtremeRepetition = "extreme_repetition" // 补全内容极端重复，被整体丢弃
+	EmptyReasonSyntaxError       = "syntax_error"       // 补全内容有语法错误，被整体丢弃
+	EmptyReasonNotMatchLanguage  = "not_match_language" // 补全内容与文件的语言不符，被整体丢弃
+	EmptyReasonInvalidBrackets   = "invalid_brackets"   // 补全内容的括号不匹配，被整体丢弃
+	EmptyReasonCssContent        = "css_content"        // 非样式文件中补全了CSS，被整体丢弃
+	EmptyReasonDiscarded         = "discarded"          // 被其它后置处理器整体丢弃
+	EmptyReasonCutToEmpty        = "cut_to_empty"       // 没有被丢弃，但裁剪后没有剩余内容
+	EmptyReasonBlankPrompt       = "blank_prompt"       // 空白提示词，没有调用模型
+	EmptyReasonCursorAtLineEnd   = "cursor_at_line_end" // 光标位于完整语句的行尾，被过滤器拒绝
+	EmptyReasonWordAfterCursor   = "word_after_cursor"  // 光标后紧跟标识符，被过滤器拒绝
+)
+
+// 给客户端的重试建议
+const (
+	RetryNo         = "no_retry"          // 不要重试
+	RetryAfterEdit  = "retry_after_edit"  // 用户编辑后再请求
+	RetryWithManual = "retry_with_manual" // 改为手动触发可能有结果
+)
+
+// 内置的重试建议，可以按原因在config.EmptyResultConfig.Advice中覆盖
+var defaultRetryAdvice = map[string]string{
+	EmptyReasonModel:             RetryAfterEdit,
+	EmptyReasonExtremeRepetition: RetryNo,
+	EmptyReasonSyntaxError:       RetryWithManual,
+	EmptyReasonNotMatchLanguage:  RetryWithManual,
+	EmptyReasonInvalidBrackets:   RetryWithManual,
+	EmptyReasonCssContent:        RetryAfterEdit,
+	EmptyReasonDiscarded:         RetryAfterEdit,
+	EmptyReasonCutToEmpty:        RetryAfterEdit,
+	EmptyReasonBlankPrompt:       RetryAfterEdit,
+	EmptyReasonCursorAtLineEnd:   RetryAfterEdit,
+	EmptyReasonWordAfterCursor:   RetryAfterEdit,
+}
+
+// 内置丢弃器对应的空补全原因
+var discardReasons = map[string]string{
+	DiscardExtremeRepetition: EmptyReasonExtremeRepetition,
+	DiscardNotMatchLanguage:  EmptyReasonNotMatchLanguage,
+	DiscardInvalidBrackets:   EmptyReasonInvalidBrackets,
+	DiscardSyntaxError:       EmptyReasonSyntaxError,
+	DicardCssContent:         EmptyReasonCssContent,
+}
+
+// 空补全原因的简短说明，写入响应的empty_message
+var emptyMessages = map[string]string{
+	EmptyReasonModel:             "the model returned no completion",
+	EmptyReasonExtremeRepetition: "the completion repeats itself excessively",
+	EmptyReasonSyntaxError:       "the completion does not parse with the surrounding code",
+	EmptyReasonNotMatchLanguage:  "the completion is written in a different language than the file",
+	EmptyReasonInvalidBrackets:   "the completion has unbalanced brackets",
+	EmptyReasonCssContent:        "the completion is CSS in a file that is not a stylesheet",
+	EmptyReasonDiscarded:         "the completion was discarded by post-processing",
+	EmptyReasonCutToEmpty:        "nothing was left after trimming the completion",
+	EmptyReasonBlankPrompt:       "the prompt is blank, the model was not called",
+	EmptyReasonCursorAtLineEnd:   "the cursor is at the end of a complete statement",
+	EmptyReasonWordAfterCursor:   "an identifier follows the cursor",
+}
+
+/**
+ * 空补全结果的说明
+ * @description
+ * - Reason: 补全为空的原因，见EmptyReason*
+ * - Advice: 给客户端的重试建议，见Retry*
+ * - DiscardedBy: 使补全内容为空的后置处理器，见discarderOf
+ * - Memoized: 命中负结果缓存，没有调用模型
+ */
+type EmptyResult struct {
+	Reason      string `json:"reason"`
+	Advice      string `json:"advice"`
+	DiscardedBy string `json:"discarded_by,omitempty"`
+	Memoized    bool   `json:"memoized,omitempty"`
+}
+
+/**
+ * 按原因选择重试建议
+ * @param {*config.EmptyResultConfig} cfg - 空补全结果的配置
+ * @param {string} reason - 补全为空的原因
+ * @returns {string} 返回重试建议，没有对应建议时返回空
+ * @description
+ * - 配置的建议优先，配置的建议无效时使用内置的建议
+ * @example
+ * retryAdvice(&config.EmptyResultConfig{}, EmptyReasonExtremeRepetition)
+ * // "no_retry"
+ */
+func retryAdvice(cfg *config.EmptyResultConfig, reason string) string {
+	if advice, ok := cfg.Advice[reason]; ok {
+		switch advice {
+		case RetryNo, RetryAfterEdit, RetryWithManual:
+			return advice
+		}
+		zap.L().Warn("Invalid config: 'wrapper.empty.advice' contains invalid advice",
+			zap.String("reason", reason), zap.String("advice", advice))
+	}
+	return defaultRetryAdvice[reason]
+}
+
+// 使补全内容为空的后置处理器：第一个命中的丢弃器，没有丢弃器时为最后一个命中的裁剪器
+func discarderOf(rsp *CompletionResponse) string {
+	last := ""
+	for _, hit := range rsp.Hits {
+		if strings.HasPrefix(hit, "discard-") {
+			return hit
+		}
+		last = hit
+	}
+	return last
+}
+
+/**
+ * 按后置处理命中的处理器判断空补全的原因
+ * @param {*CompletionResponse} rsp - 补全为空的响应
+ * @returns {string} 返回原因，见EmptyReason*
+ * @description
+ * - 模型没有给出内容时为model_empty，与被后置处理丢弃区分
+ * - 内置丢弃器对应各自的原因，其它丢弃器为discarded，只被裁剪器裁剪为空时为cut_to_empty
+ */
+func emptyReasonOf(rsp *CompletionResponse) string {
+	if !rsp.Discarded {
+		return EmptyReasonModel
+	}
+	by := discarderOf(rsp)
+	if reason, ok := discardReasons[by]; ok {
+		return reason
+	}
+	if strings.HasPrefix(by, "discard-") {
+		return EmptyReasonDiscarded
+	}
+	return EmptyReasonCutToEmpty
+}
+
+// 负结果缓存作废条目的原因
+const (
+	MemoStale       = "stale"        // 文件哈希和文档版本都与缓存时不符
+	MemoFileChanged = "file_changed" // 收到文件保存通知
+)
+
+// 负结果缓存的条目
+type emptyMemoEntry struct {
+	reason  string // 补全为空的原因
+	version int64  // 缓存时的文档版本，0表示请求没有提供
+	hash    string // 缓存时的文件内容哈希，为空表示请求没有提供
+	owner   string // 写入条目的请求凭据(Authorization头)的摘要，为空表示请求没有带凭据
+}
+
+/**
+ * 缓存的条目对当前的文件是否仍然有效
+ * @param {int64} version - 请求的文档版本，0表示没有提供
+ * @param {string} hash - 请求的文件内容哈希，为空表示没有提供
+ * @param {int64} drift - 允许的文档版本差
+ * @returns {bool} 返回是否有效
+ * @description
+ * - 双方都有文件哈希且相同，或双方都有文档版本且相差不超过drift时有效
+ * - 文件哈希和文档版本都无法比较时有效，即只按光标行判断
+ * @example
+ * emptyMemoEntry{version: 3, hash: "a"}.fresh(5, "b", 0)
+ * // false
+ */
+func (e emptyMemoEntry) fresh(version int64, hash string, drift int64) bool {
+	compared := false
+	if e.hash != "" && hash != "" {
+		if e.hash == hash {
+			return true
+		}
+		compared = true
+	}
+	if e.version > 0 && version > 0 {
+		if diff := version - e.version; diff <= drift && diff >= -drift {
+			return true
+		}
+		compared = true
+	}
+	return !compared
+}
+
+var (
+	emptyMemoOnce sync.Once
+	emptyMemo     *store.Store[string, emptyMemoEntry]
+)
+
+// 全局的负结果缓存，首次使用时按配置创建
+func emptyMemoStore() *store.Store[string, emptyMemoEntry] {
+	emptyMemoOnce.Do(func() {
+		emptyMemo = store.New(store.Options[string, emptyMemoEntry]{
+			Name:       "empty_memo",
+			MaxEntries: config.Wrapper.Empty.MemoMaxEntries,
+			TTL:        config.Wrapper.Empty.MemoTTL,
+			Metadata:   true, // 只有原因、文档版本和文件哈希
+		})
+	})
+	return emptyMemo
+}
+
+/**
+ * 负结果缓存的key：客户端、文件在项目内的路径，以及项目路径、文件哈希和光标所在行的哈希
+ * @returns {string} 缺少客户端ID或文件路径时为空
+ * @description
+ * - 不同项目中相同路径的文件、内容不同(文件哈希不同)的同一文件不共用缓存的结果
+ * - 文档版本不放入key，由emptyMemoEntry.fresh按允许的版本差判断
+ */
+func (in *CompletionInput) emptyMemoKey() string {
+	if in.ClientID == "" || in.Processed.FileProjectPath == "" {
+		return ""
+	}
+	line := in.Processed.Prefix[strings.LastIndex(in.Processed.Prefix, "\n")+1:]
+	if i := strings.Index(in.Processed.Suffix, "\n"); i >= 0 {
+		line += "\x00" + in.Processed.Suffix[:i]
+	} else {
+		line += "\x00" + in.Processed.Suffix
+	}
+	h := fnv.New64a()
+	h.Write([]byte(in.Processed.ProjectPath + "\x00" + in.FileHash + "\x00" + line))
+	return in.ClientID + "\x00" + in.Processed.FileProjectPath + "\x00" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+/**
+ * 查找负结果缓存
+ * @param {*CompletionContext} c - 补全上下文
+ * @returns {*CompletionResponse} 命中时返回空补全响应，否则返回nil
+ * @description
+ * - 手动触发的请求不查找，用户主动请求时总是调用模型
+ * - 重放的请求不查找，总是调用模型
+ * - 缓存的条目与请求的文件哈希和文档版本不符时视为过期，作废该条目，不命中
+ * - 命中时不调用模型，直接返回StatusEmpty，重试建议与缓存时的原因相同
+ */
+func (in *CompletionInput) recallEmpty(c *CompletionContext) *CompletionResponse {
+	cfg := &config.Wrapper.Empty
+	if !c.Enabled(feature_flag.EmptyAdvice) || in.internal() || strings.ToUpper(in.TriggerMode) == "MANUAL" {
+		return nil
+	}
+	key := in.emptyMemoKey()
+	if key == "" {
+		return nil
+	}
+	entry, ok := emptyMemoStore().Get(key)
+	if !ok {
+		return nil
+	}
+	if !entry.fresh(in.DocumentVersion, in.FileHash, cfg.VersionDrift) {
+		if emptyMemoStore().Delete(key) {
+			metrics.IncrementStoreInvalidations("empty_memo", MemoStale, 1)
+		}
+		c.Log().Debug("Memoized empty result is stale", zap.Int64("memoVersion", entry.version),
+			zap.Int64("documentVersion", in.DocumentVersion))
+		return nil
+	}
+	in.Empty = &EmptyResult{Reason: entry.reason, Memoized: true}
+	c.Log().Debug("Empty result memoized", zap.String("reason", entry.reason))
+	return CancelRequest(in.CompletionID, in.Model, c.Perf, model.StatusEmpty,
+		fmt.Errorf("empty: same request returned empty recently (%s)", entry.reason))
+}
+
+/**
+ * 为空补全或被过滤器拒绝的响应给出原因和重试建议
+ * @param {*feature_flag.Evaluator} flags - 请求的功能开关
+ * @param {*CompletionResponse} rsp - 补全响应
+ * @description
+ * - 调用模型后为空的，按命中的后置处理器判断原因，并记录到负结果缓存(重放的请求不记录)
+ * - 空白提示词、光标在行尾等在预处理时已确定原因，不调用模型，不需要缓存
+ * - 原因写入响应的empty_reason、discarded_by和empty_message，不受开关emptyAdvice关闭的影响
+ * - 重试建议写入响应的retry_advice，原因和建议附加到Verbose，并记录指标
+ */
+func (in *CompletionInput) AdviseRetry(flags *feature_flag.Evaluator, rsp *CompletionResponse) {
+	cfg := &config.Wrapper.Empty
+	if rsp == nil {
+		return
+	}
+	enabled := flags.Enabled(feature_flag.EmptyAdvice)
+	if in.Empty == nil && rsp.Status == model.StatusEmpty {
+		in.Empty = &EmptyResult{Reason: emptyReasonOf(rsp)}
+		if rsp.Discarded {
+			in.Empty.DiscardedBy = discarderOf(rsp)
+		}
+		if key := in.emptyMemoKey(); key != "" && enabled && !in.internal() {
+			emptyMemoStore().Put(key, emptyMemoEntry{reason: in.Empty.Reason, version: in.DocumentVersion,
+				hash: in.FileHash, owner: store.Digest(in.Headers.Get("Authorization"))})
+		}
+	}
+	if in.Empty == nil || (rsp.Status != model.StatusEmpty && rsp.Status != model.StatusRejected) {
+		return
+	}
+	rsp.EmptyReason, rsp.DiscardedBy = in.Empty.Reason, in.Empty.DiscardedBy
+	rsp.EmptyMessage = emptyMessages[in.Empty.Reason]
+	if !enabled {
+		return
+	}
+	in.Empty.Advice = retryAdvice(cfg, in.Empty.Reason)
+	rsp.RetryAdvice = in.Empty.Advice
+	verboseInput(rsp)["empty"] = in.Empty
+	metrics.IncrementEmptyResults(in.Empty.Reason, in.Empty.Advice, in.Empty.Memoized)
+}
+
+/**
+ * 文件保存后作废客户端缓存的补全结果
+ * @param {string} clientID - 客户端ID
+ * @param {string} credential - 通知请求的凭据(Authorization头)，只作废用同一凭据写入的条目
+ * @param {[]string} files - 保存的文件在项目内的路径，为空时作废该客户端的所有条目
+ * @returns {int} 返回作废的条目数
+ * @description
+ * - 保存可能改变了光标上方的代码(如重命名类型)，按光标行缓存的结果不再可靠
+ * - client_id由客户端自己填写，只凭client_id不能作废别人的条目，没有凭据的请求不作废任何条目
+ * - 没有带凭据的请求写入的条目不能被通知作废，只能等过期淘汰
+ * - 作废计入completion_store_invalidations_total，与过期淘汰分开统计
+ * @example
+ * n := PurgeClientResults("client-1", "Bearer xxx", []string{"src/main.go"})
+ */
+func PurgeClientResults(clientID, credential string, files []string) int {
+	if clientID == "" || credential == "" {
+		return 0
+	}
+	owner := store.Digest(credential)
+	purged := 0
+	emptyMemoStore().Range(func(key string, e emptyMemoEntry) bool {
+		parts := strings.SplitN(key, "\x00", 3)
+		if len(parts) == 3 && parts[0] == clientID && e.owner == owner &&
+			(len(files) == 0 || slices.Contains(files, parts[1])) && emptyMemoStore().Delete(key) {
+			purged++
+		}
+		return true
+	})
+	if purged > 0 {
+		metrics.IncrementStoreInvalidations("empty_memo", MemoFileChanged, purged)
+	}
+	return purged
+}
@@ -0,0 +1,184 @@
+package completions
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test the empty reason and retry advice mapping
+// go test ./pkg/completions/ -v -run Test_RetryAdvice
+func Test_RetryAdvice(t *testing.T) {
+	cases := []struct {
+		rsp    *CompletionResponse
+		reason string
+		advice string
+	}{
+		{&CompletionResponse{}, EmptyReasonModel, RetryAfterEdit},
+		{&CompletionResponse{Discarded: true, Hits: []string{CutPrefixOverlap, DiscardExtremeRepetition}}, EmptyReasonExtremeRepetition, RetryNo},
+		{&CompletionResponse{Discarded: true, Hits: []string{DiscardSyntaxError}}, EmptyReasonSyntaxError, RetryWithManual},
+		{&CompletionResponse{Discarded: true, Hits: []string{DiscardNotMatchLanguage}}, EmptyReasonNotMatchLanguage, RetryWithManual},
+		{&CompletionResponse{Discarded: true, Hits: []string{"discard-custom"}}, EmptyReasonDiscarded, RetryAfterEdit},
+		{&CompletionResponse{Discarded: true, Hits: []string{CutPrefixOverlap}}, EmptyReasonCutToEmpty, RetryAfterEdit},
+	}
+	cfg := &config.EmptyResultConfig{}
+	for _, tc := range cases {
+		reason := emptyReasonOf(tc.rsp)
+		if advice := retryAdvice(cfg, reason); reason != tc.reason || advice != tc.advice {
+			t.Errorf("%v: expected %s/%s, got %s/%s", tc.rsp.Hits, tc.reason, tc.advice, reason, advice)
+		}
+	}
+	for _, reason := range []string{EmptyReasonBlankPrompt, EmptyReasonCursorAtLineEnd, EmptyReasonWordAfterCursor} {
+		if advice := retryAdvice(cfg, reason); advice != RetryAfterEdit {
+			t.Errorf("%s: expected %s, got %s", reason, RetryAfterEdit, advice)
+		}
+	}
+
+	// 配置覆盖内置的建议，无效的建议被忽略
+	cfg.Advice = map[string]string{EmptyReasonSyntaxError: RetryNo, EmptyReasonModel: "later"}
+	if advice := retryAdvice(cfg, EmptyReasonSyntaxError); advice != RetryNo {
+		t.Errorf("expected the configured advice, got %s", advice)
+	}
+	if advice := retryAdvice(cfg, EmptyReasonModel); advice != RetryAfterEdit {
+		t.Errorf("expected the builtin advice for an invalid config, got %s", advice)
+	}
+}
+
+// to test the filter rejection reason reaches the response advice
+// go test ./pkg/completions/ -v -run Test_AdviseRetryRejected
+func Test_AdviseRetryRejected(t *testing.T) {
+	in := &CompletionInput{}
+	in.Processed.Prefix = "const date = formatDate();<FILL_HERE>\n"
+	filter := NewSyntaxFilter(&config.SyntaxFilterConfig{})
+	if code := filter.Judge(nil, in); code != FeatureNotSupport {
+		t.Fatalf("expected the line end rejection, got %s", code)
+	}
+	rsp := &CompletionResponse{Status: model.StatusRejected}
+	in.AdviseRetry(nil, rsp)
+	if rsp.RetryAdvice != RetryAfterEdit || in.Empty.Reason != EmptyReasonCursorAtLineEnd {
+		t.Errorf("unexpected advice %q for %+v", rsp.RetryAdvice, in.Empty)
+	}
+}
+
+// to test each built-in discarder surfaces its reason on the empty response, distinct from an empty model output
+// go test ./pkg/completions/ -v -run Test_EmptyDiscardReasons
+func Test_EmptyDiscardReasons(t *testing.T) {
+	saved := config.Wrapper.Prune
+	defer func() {
+		config.Wrapper.Prune = saved
+		InitPrunerChains(&config.Wrapper.Prune)
+	}()
+
+	tests := []struct {
+		pruner   string
+		language string
+		prefix   string
+		text     string
+		reason   string
+	}{
+		{DiscardExtremeRepetition, "javascript", "function main() {\n", garbageCompletion, EmptyReasonExtremeRepetition},
+		{DiscardNotMatchLanguage, "javascript", "function main() {\n", "const name = this.name\n    return self.name\n", EmptyReasonNotMatchLanguage},
+		{DiscardInvalidBrackets, "javascript", "function main() {\n", "return foo(a, b));\n}", EmptyReasonInvalidBrackets},
+		{DicardCssContent, "typescript", "function main() {\n", ".title {\n  color: red;\n  font-size: 12px;\n  margin: 0 auto;\n}\n", EmptyReasonCssContent},
+		{DiscardSyntaxError, "go", "package main\n\nfunc main() {\n\t", "x := := ]] 1\n", EmptyReasonSyntaxError},
+		{DiscardExtremeRepetition, "javascript", "function main() {\n", "", EmptyReasonModel},
+		{DiscardExtremeRepetition, "javascript", "function main() {\n", " \n\t", EmptyReasonCutToEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			config.Wrapper.Prune = config.PruneConfig{Pruners: []string{tt.pruner}}
+			InitPrunerChains(&config.Wrapper.Prune)
+			llm := &scriptedLLM{cfg: config.ModelConfig{ModelName: "scripted"}, texts: []string{tt.text}}
+			c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+			para := &model.CompletionParameter{CompletionID: "empty", Model: "scripted", Language: tt.language,
+				Prefix: tt.prefix, Suffix: "\n}\n", TriggerMode: "AUTO"}
+			rsp := NewCompletionHandler(llm).CallLLM(c, para)
+			(&CompletionInput{}).AdviseRetry(nil, rsp)
+
+			discardedBy := ""
+			if tt.reason != EmptyReasonModel && tt.reason != EmptyReasonCutToEmpty {
+				discardedBy = tt.pruner
+			}
+			if rsp.Status != model.StatusEmpty || rsp.EmptyReason != tt.reason || rsp.DiscardedBy != discardedBy || rsp.EmptyMessage == "" {
+				t.Errorf("expected %s by %q, got %s %q by %q (%q)", tt.reason, discardedBy, rsp.Status,
+					rsp.EmptyReason, rsp.DiscardedBy, rsp.EmptyMessage)
+			}
+		})
+	}
+}
+
+// to test the memoized empty result validated against the document version and file hash
+// go test ./pkg/completions/ -v -run Test_EmptyMemoVersion
+func Test_EmptyMemoVersion(t *testing.T) {
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{})
+	project := "/work/shapes"
+	request := func(version int64, hash, above string) *CompletionInput {
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "memo-client", CompletionID: "memo",
+			TriggerMode: "AUTO", DocumentVersion: version, FileHash: hash},
+			Headers: http.Header{"Authorization": []string{"Bearer memo-user"}}}
+		in.Processed = PromptOptions{ProjectPath: project, FileProjectPath: "src/shape.go",
+			Prefix: above + "\nfunc area(s Shape) float64 {\n\treturn ", Suffix: "\n}\n"}
+		return in
+	}
+	// 模型返回空补全，按光标行缓存
+	request(3, "h1", "type Shape struct{}").AdviseRetry(nil, &CompletionResponse{Status: model.StatusEmpty})
+
+	// 文件没有变化时命中
+	if rsp := request(3, "h1", "type Shape struct{}").recallEmpty(c); rsp == nil || rsp.Status != model.StatusEmpty {
+		t.Fatalf("expected the unchanged file to hit, got %+v", rsp)
+	}
+	// 光标上方重命名了类型，光标行没有变化，但文件哈希变了
+	if rsp := request(3, "h2", "type Figure struct{}").recallEmpty(c); rsp != nil {
+		t.Errorf("expected the rename above the cursor to miss, got %+v", rsp)
+	}
+	// 另一个项目中相同路径的文件
+	project = "/work/other"
+	if rsp := request(3, "h1", "type Shape struct{}").recallEmpty(c); rsp != nil {
+		t.Errorf("expected the same path in another project to miss, got %+v", rsp)
+	}
+	project = "/work/shapes"
+
+	// 没有提供版本和哈希时只按光标行判断
+	request(0, "", "type Shape struct{}").AdviseRetry(nil, &CompletionResponse{Status: model.StatusEmpty})
+	if rsp := request(0, "", "type Shape struct{}").recallEmpty(c); rsp == nil {
+		t.Error("expected a hit without version and hash")
+	}
+	// 只有文档版本时按版本差判断，超出的条目作废
+	request(3, "", "type Shape struct{}").AdviseRetry(nil, &CompletionResponse{Status: model.StatusEmpty})
+	if rsp := request(5, "", "type Figure struct{}").recallEmpty(c); rsp != nil {
+		t.Errorf("expected the version change to miss, got %+v", rsp)
+	}
+	if rsp := request(3, "", "type Shape struct{}").recallEmpty(c); rsp != nil {
+		t.Error("expected the stale entry invalidated")
+	}
+
+	// 版本在允许的偏差内时命中
+	saved := config.Wrapper.Empty
+	defer func() { config.Wrapper.Empty = saved }()
+	config.Wrapper.Empty.VersionDrift = 2
+	request(5, "", "type Figure struct{}").AdviseRetry(nil, &CompletionResponse{Status: model.StatusEmpty})
+	if rsp := request(7, "", "type Figure struct{}").recallEmpty(c); rsp == nil {
+		t.Error("expected a hit within the version drift")
+	}
+
+	// 文件保存通知作废该客户端用同一凭据写入的条目
+	if n := PurgeClientResults("other-client", "Bearer memo-user", nil); n != 0 {
+		t.Errorf("expected nothing purged for another client, got %d", n)
+	}
+	if n := PurgeClientResults("memo-client", "Bearer other-user", nil); n != 0 {
+		t.Errorf("expected nothing purged with another credential, got %d", n)
+	}
+	if n := PurgeClientResults("memo-client", "", nil); n != 0 {
+		t.Errorf("expected nothing purged without a credential, got %d", n)
+	}
+	if n := PurgeClientResults("memo-client", "Bearer memo-user", []string{"src/shape.go"}); n != 2 {
+		t.Errorf("expected the entries with and without the file hash purged, got %d", n)
+	}
+	if rsp := request(5, "h2", "type Figure struct{}").recallEmpty(c); rsp != nil {
+		t.Error("expected a miss after the file changed")
+	}
+}
@@ -0,0 +1,131 @@
+package completions
+
+import (
+	"strings"
+
+	"code-completion/pkg/model"
+)
+
+// 模型输出提取的名称，提取生效时记录在命中的处理器中
+const ExtractResponse string = "extract-response"
+
+// 代码围栏
+const codeFence = "```"
+
+// 模型输出中的一个代码块
+type fencedBlock struct {
+	language string // 围栏的语言标记，没有时为空
+	code     string // 围栏之间的内容
+	begin    int    // 开始围栏所在行的起始偏移
+	end      int    // 结束围栏所在行之后的偏移，没有结束围栏时为输出的长度
+}
+
+// 围栏行的语言标记，不是围栏行时返回false
+func fenceLine(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimLeft(line, " \t"), codeFence)
+	if !ok {
+		return "", false
+	}
+	fields := strings.Fields(strings.TrimLeft(rest, "`"))
+	if len(fields) == 0 {
+		return "", true
+	}
+	return fields[0], true
+}
+
+/**
+ * 找出模型输出中的代码块
+ * @param {string} text - 模型输出的补全内容
+ * @returns {[]fencedBlock} 返回按出现顺序排列的代码块
+ * @description
+ * - 以```开头的行为围栏，开始围栏之后可以带语言标记，结束围栏不带
+ * - 最后一个代码块没有结束围栏时(输出长度达到上限)，内容到输出结束为止
+ */
+func findFencedBlocks(text string) []fencedBlock {
+	var blocks []fencedBlock
+	var current *fencedBlock
+	var body strings.Builder
+	for offset := 0; offset < len(text); {
+		next := strings.IndexByte(text[offset:], '\n')
+		lineEnd := len(text)
+		if next >= 0 {
+			lineEnd = offset + next + 1
+		}
+		line := strings.TrimRight(text[offset:lineEnd], "\r\n")
+		language, fence := fenceLine(line)
+		switch {
+		case current == nil && fence:
+			current = &fencedBlock{language: language, begin: offset}
+			body.Reset()
+		case current != nil && fence && language == "":
+			current.code = strings.TrimSuffix(body.String(), "\n")
+			current.end = lineEnd
+			blocks = append(blocks, *current)
+			current = nil
+		case current != nil:
+			body.WriteString(text[offset:lineEnd])
+		}
+		offset = lineEnd
+	}
+	if current != nil {
+		current.code = strings.TrimSuffix(body.String(), "\n")
+		current.end = len(text)
+		blocks = append(blocks, *current)
+	}
+	return blocks
+}
+
+/**
+ * 从指令模型的输出中提取代码块，在补全结束清理之后、后置处理器链之前执行
+ * @param {*PrunerContext} ctx - 后置处理器上下文，需要设置Language、Prefix和Suffix
+ * @returns {*model.ResponseExtraction} 返回提取的记录，没有提取时返回nil
+ * @description
+ * - 指令模型在补全模式下也会用```包裹代码，或在代码之前给出说明文字，这些内容交给后置处理器链的结果不可预期
+ * - 只有出现代码围栏时才提取，没有围栏的输出原样保留
+ * - 带语言标记的代码块，标记须与请求的语言一致(别名按语言配置解析)，不一致的代码块不选
+ * - 只有一个可选的代码块时直接选中；多个时选第一个与前后缀拼接后语法正确的，都不正确时选第一个
+ * - 选中的代码块之前的内容(说明文字)和之后的内容都去掉，记录在返回值中
+ * - markdown文件中的代码围栏是文件内容，不提取
+ * @example
+ * ctx := &PrunerContext{
+ *     Language:       "python",
+ *     CompletionCode: "Here is the code:\n```python\nreturn a + b\n```",
+ * }
+ * extraction := extractResponse(ctx)
+ * // ctx.CompletionCode = "return a + b"，extraction.Leading = "Here is the code:\n"
+ */
+func extractResponse(ctx *PrunerContext) *model.ResponseExtraction {
+	language := profileOf(ctx.Language).ID
+	if language == "markdown" {
+		return nil
+	}
+	blocks := findFencedBlocks(ctx.CompletionCode)
+	candidates := make([]int, 0, len(blocks))
+	for i, b := range blocks {
+		if b.language == "" || profileOf(b.language).ID == language {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	chosen := candidates[0]
+	if len(candidates) > 1 {
+		for _, i := range candidates {
+			if isCodeSyntax(ctx.Language, blocks[i].code, ctx.Prefix, ctx.Suffix) {
+				chosen = i
+				break
+			}
+		}
+	}
+	b := blocks[chosen]
+	extraction := &model.ResponseExtraction{
+		Fences:   len(blocks),
+		Chosen:   chosen,
+		Language: b.language,
+		Leading:  ctx.CompletionCode[:b.begin],
+		Trailing: ctx.CompletionCode[b.end:],
+	}
+	ctx.CompletionCode = b.code
+	return extraction
+}
@@ -0,0 +1,83 @@
+package completions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// extractFixture is an output of an instruct model answering a completion request
+type extractFixture struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Prefix   string `json:"prefix"`
+	Suffix   string `json:"suffix"`
+	Text     string `json:"text"`
+	Expected string `json:"expected"`
+	Fences   int    `json:"fences"`
+	Chosen   int    `json:"chosen"`
+	Leading  string `json:"leading"`
+}
+
+// to test extracting the code block from instruct model outputs
+// go test ./pkg/completions/ -v -run Test_ExtractResponse
+func Test_ExtractResponse(t *testing.T) {
+	data, err := os.ReadFile("testdata/extract_response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []extractFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			ctx := &PrunerContext{Language: f.Language, CompletionCode: f.Text, Prefix: f.Prefix, Suffix: f.Suffix}
+			extraction := extractResponse(ctx)
+			if ctx.CompletionCode != f.Expected {
+				t.Errorf("expected %q, got %q", f.Expected, ctx.CompletionCode)
+			}
+			if f.Text == f.Expected {
+				if extraction != nil {
+					t.Errorf("expected no extraction, got %+v", extraction)
+				}
+				return
+			}
+			if extraction == nil {
+				t.Fatal("expected an extraction")
+			}
+			if extraction.Fences != f.Fences || extraction.Chosen != f.Chosen || extraction.Leading != f.Leading {
+				t.Errorf("expected fences=%d chosen=%d leading=%q, got %+v", f.Fences, f.Chosen, f.Leading, extraction)
+			}
+		})
+	}
+}
+
+// to test the extraction runs before pruning only for models that enable it, and is recorded in Verbose
+// go test ./pkg/completions/ -v -run Test_ExtractResponseByModel
+func Test_ExtractResponseByModel(t *testing.T) {
+	text := "Here is the code:\n```javascript\nreturn a + b;\n```"
+	for _, extract := range []bool{true, false} {
+		llm := &scriptedLLM{cfg: config.ModelConfig{ModelName: "scripted", DisablePrune: true, ExtractResponse: extract}, texts: []string{text}}
+		c := NewCompletionContext(context.Background(), &CompletionPerformance{})
+		para := &model.CompletionParameter{CompletionID: "extract", Model: "scripted", Language: "javascript",
+			Prefix: "function add(a, b) {\n", Suffix: "\n}"}
+		rsp := NewCompletionHandler(llm).CallLLM(c, para)
+		if !extract {
+			if rsp.Choices[0].Text != text || rsp.Verbose.Extraction != nil {
+				t.Errorf("FIM model output should pass through, got %q", rsp.Choices[0].Text)
+			}
+			continue
+		}
+		if rsp.Choices[0].Text != "return a + b;" || rsp.Verbose.Extraction == nil || rsp.Verbose.Extraction.Leading != "Here is the code:\n" {
+			t.Errorf("expected the code block extracted, got %q", rsp.Choices[0].Text)
+		}
+		if len(rsp.Hits) == 0 || rsp.Hits[0] != ExtractResponse {
+			t.Errorf("expected %s in hits, got %v", ExtractResponse, rsp.Hits)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// 回退到语言族的依据
+const (
+	FallbackProfile   = "profile"    // 语言配置指定了语言族
+	FallbackInvalidID = "invalid_id" // 语言标识不像语言(如MIME类型、含控制字符)，按纯文本处理
+	FallbackExtension = "extension"  // 按文件扩展名判断
+	FallbackContent   = "content"    // 按前后缀的内容判断
+	FallbackDefault   = "default"    // 判断不出，使用配置的默认语言族
+)
+
+// 按内容判断时最多检查的前缀末尾和后缀开头的字节数
+const (
+	fallbackPrefixSample = 4096
+	fallbackSuffixSample = 1024
+)
+
+// 像语言标识的取值：小写字母开头，只含字母、数字和少量符号(如c++、c#、objective-c)
+var languageIDPattern = regexp.MustCompile(`^[a-z][a-z0-9+#._-]{0,31}$`)
+
+// 内置的文件扩展名到语言族的映射，配置wrapper.fallback.extensions覆盖
+var fallbackExtensions = map[string]string{
+	".tf": FamilyCLike, ".tfvars": FamilyCLike, ".hcl": FamilyCLike, ".proto": FamilyCLike, ".thrift": FamilyCLike,
+	".sol": FamilyCLike, ".zig": FamilyCLike, ".dart": FamilyCLike, ".d": FamilyCLike, ".v": FamilyCLike,
+	".gradle": FamilyCLike, ".glsl": FamilyCLike, ".hlsl": FamilyCLike, ".cu": FamilyCLike, ".hx": FamilyCLike,
+	".nim": FamilyIndentBased, ".nims": FamilyIndentBased, ".coffee": FamilyIndentBased, ".pug": FamilyIndentBased,
+	".sass": FamilyIndentBased, ".haml": FamilyIndentBased, ".slim": FamilyIndentBased, ".styl": FamilyIndentBased,
+	".fs": FamilyIndentBased, ".fsx": FamilyIndentBased, ".elm": FamilyIndentBased, ".gd": FamilyIndentBased,
+	".bzl": FamilyIndentBased, ".star": FamilyIndentBased,
+	".xml": FamilyMarkup, ".xsd": FamilyMarkup, ".xsl": FamilyMarkup, ".svg": FamilyMarkup, ".xhtml": FamilyMarkup,
+	".svelte": FamilyMarkup, ".astro": FamilyMarkup, ".jsp": FamilyMarkup, ".erb": FamilyMarkup, ".hbs": FamilyMarkup,
+	".mustache": FamilyMarkup, ".twig": FamilyMarkup, ".cshtml": FamilyMarkup, ".plist": FamilyMarkup, ".csproj": FamilyMarkup,
+	".txt": FamilyPlain, ".log": FamilyPlain, ".csv": FamilyPlain, ".bin": FamilyPlain, ".dat": FamilyPlain,
+}
+
+/**
+ * 语言回退的决定
+ * @description
+ * - Language: 上报的语言标识(小写)
+ * - Family: 回退到的语言族，见LanguageFamily
+ * - Source: 回退的依据，见Fallback*
+ */
+type LanguageFallback struct {
+	Language string `json:"language"`
+	Family   string `json:"family"`
+	Source   string `json:"source"`
+}
+
+/**
+ * 确定语言是否回退到语言族，以及回退到哪个语言族
+ * @param {*config.LanguageFallbackConfig} cfg - 配置wrapper.fallback
+ * @param {string} language - 光标处的语言标识
+ * @param {string} filePath - 文件在项目内的路径
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @returns {*LanguageFallback} 返回回退的决定，不回退时返回nil
+ * @description
+ * - 有语言配置的语言只在配置指定了Family时回退，没有上报语言时不回退
+ * - 语言标识不像语言时直接回退到plain
+ * - 否则依次按文件扩展名、前后缀的内容判断，都判断不出时使用配置的默认语言族
+ * @example
+ * f := resolveFallback(&config.Wrapper.Fallback, "terraform", "infra/main.tf", "resource \"x\" \"y\" {\n", "}")
+ * // f.Family = "c-like", f.Source = "extension"
+ */
+func resolveFallback(cfg *config.LanguageFallbackConfig, language, filePath, prefix, suffix string) *LanguageFallback {
+	id := strings.ToLower(strings.TrimSpace(language))
+	if cfg.Disabled || id == "" {
+		return nil
+	}
+	if p, ok := LookupLanguage(id); ok {
+		if p.Family == "" {
+			return nil
+		}
+		return &LanguageFallback{Language: id, Family: p.Family, Source: FallbackProfile}
+	}
+	fallback := &LanguageFallback{Language: id}
+	if !languageIDPattern.MatchString(id) {
+		fallback.Family, fallback.Source = FamilyPlain, FallbackInvalidID
+		return fallback
+	}
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(filePath, "\\", "/")))
+	if family, ok := cfg.Extensions[ext]; ok && lookupFamily(family) != nil {
+		fallback.Family, fallback.Source = family, FallbackExtension
+		return fallback
+	}
+	if family, ok := fallbackExtensions[ext]; ok {
+		fallback.Family, fallback.Source = family, FallbackExtension
+		return fallback
+	}
+	if family := guessFamily(prefix, suffix); family != "" {
+		fallback.Family, fallback.Source = family, FallbackContent
+		return fallback
+	}
+	fallback.Family, fallback.Source = cfg.Default, FallbackDefault
+	if lookupFamily(fallback.Family) == nil {
+		fallback.Family = FamilyPlain
+	}
+	return fallback
+}
+
+/**
+ * 按前缀末尾和后缀开头的内容判断语言族
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @returns {string} 返回语言族，判断不出时返回空
+ * @description
+ * - 含NUL或超过10%的控制字符、无效UTF-8的内容按plain处理
+ * - 分别统计以{、}、;结尾的行(c-like)，以:结尾且下一行缩进更深的行(indent-based)，以<开头的标签行(markup)
+ * - 最多的一类至少有2行，且多于其它类时采用
+ */
+func guessFamily(prefix, suffix string) string {
+	if len(prefix) > fallbackPrefixSample {
+		prefix = prefix[len(prefix)-fallbackPrefixSample:]
+	}
+	if len(suffix) > fallbackSuffixSample {
+		suffix = suffix[:fallbackSuffixSample]
+	}
+	sample := prefix + suffix
+	if sample == "" {
+		return ""
+	}
+	if binaryLike(sample) {
+		return FamilyPlain
+	}
+	counts := map[string]int{}
+	lines := strings.Split(sample, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "<") && strings.Contains(trimmed, ">"):
+			counts[FamilyMarkup]++
+		case strings.HasSuffix(trimmed, "{") || strings.HasSuffix(trimmed, "}") || strings.HasSuffix(trimmed, ";"):
+			counts[FamilyCLike]++
+		case strings.HasSuffix(trimmed, ":") && i+1 < len(lines) && indentWidth(lines[i+1]) > indentWidth(line):
+			counts[FamilyIndentBased]++
+		}
+	}
+	best, tie := "", false
+	for _, family := range []string{FamilyCLike, FamilyIndentBased, FamilyMarkup} {
+		switch {
+		case counts[family] > counts[best]:
+			best, tie = family, false
+		case counts[family] == counts[best] && best != "":
+			tie = true
+		}
+	}
+	if best == "" || tie || counts[best] < 2 {
+		return ""
+	}
+	return best
+}
+
+// 内容是否像二进制数据
+func binaryLike(text string) bool {
+	if strings.ContainsRune(text, 0) || !utf8.ValidString(text) {
+		return true
+	}
+	control := 0
+	for _, r := range text {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			control++
+		}
+	}
+	return control*10 > utf8.RuneCountInString(text)
+}
+
+// 行首缩进的宽度，制表符按4个空格计
+func indentWidth(line string) int {
+	width := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// 确定语言回退，回退时记录日志和指标
+func (in *CompletionInput) resolveFallback(c *CompletionContext) {
+	in.Fallback = resolveFallback(&config.Wrapper.Fallback, in.EffectiveLanguage(), in.Processed.FileProjectPath,
+		in.Processed.Prefix, in.Processed.Suffix)
+	if in.Fallback == nil {
+		return
+	}
+	label := in.Fallback.Language
+	if in.Fallback.Source == FallbackInvalidID {
+		label = metrics.LabelOther
+	}
+	metrics.IncrementLanguageFallbacks(label, in.Fallback.Family, in.Fallback.Source)
+	c.Log().Debug("Language falls back to a family", zap.String("family", in.Fallback.Family),
+		zap.String("source", in.Fallback.Source))
+}
+
+// 回退的语言族，不回退时返回nil
+func (in *CompletionInput) family() *LanguageFamily {
+	if in.Fallback == nil {
+		return nil
+	}
+	return lookupFamily(in.Fallback.Family)
+}
+
+// 计算隐藏分使用的语言，回退时使用语言族指定的语言
+func (in *CompletionInput) scoreLanguage() string {
+	if f := in.family(); f != nil {
+		return f.ScoreLanguage
+	}
+	return in.EffectiveLanguage()
+}
+
+/**
+ * 校验语言回退的配置，启动时调用
+ * @param {*config.LanguageFallbackConfig} cfg - 配置wrapper.fallback
+ * @returns {error} 默认语言族或扩展名映射的语言族未知、扩展名不以.开头时返回错误
+ */
+func CheckLanguageFallback(cfg *config.LanguageFallbackConfig) error {
+	if cfg.Default != "" && lookupFamily(cfg.Default) == nil {
+		return fmt.Errorf("wrapper.fallback.default: unknown family '%s'", cfg.Default)
+	}
+	for ext, family := range cfg.Extensions {
+		if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			return fmt.Errorf("wrapper.fallback.extensions: extension '%s' must be lower case and start with '.'", ext)
+		}
+		if lookupFamily(family) == nil {
+			return fmt.Errorf("wrapper.fallback.extensions.%s: unknown family '%s'", ext, family)
+		}
+	}
+	return nil
+}
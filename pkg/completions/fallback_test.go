@@ -0,0 +1,115 @@
+package completions
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test resolving the fallback family of languages without a profile
+// go test ./pkg/completions/ -v -run Test_ResolveFallback
+func Test_ResolveFallback(t *testing.T) {
+	cfg := &config.LanguageFallbackConfig{}
+	cases := []struct {
+		language, path, prefix string
+		family, source         string
+	}{
+		{"terraform", "infra/main.tf", "", FamilyCLike, FallbackExtension},
+		{"Terraform", "", "resource \"aws_s3_bucket\" \"b\" {\n  bucket = \"logs\"\n}\n\nlocals {\n", FamilyCLike, FallbackContent},
+		{"nim", "src/app.nim", "proc main() =\n  ", FamilyIndentBased, FallbackProfile},
+		{"mojo", "", "fn main():\n    let x = 1\n    if x:\n        print(x)\n", FamilyIndentBased, FallbackContent},
+		{"application/octet-stream", "data/blob", "\x00\x01\x02", FamilyPlain, FallbackInvalidID},
+		{"blob", "", "\x7fELF\x02\x01\x01\x00\x00\x00", FamilyPlain, FallbackContent},
+		{"unknownlang", "", "some words", FamilyPlain, FallbackDefault},
+	}
+	for _, c := range cases {
+		f := resolveFallback(cfg, c.language, c.path, c.prefix, "")
+		if f == nil || f.Family != c.family || f.Source != c.source {
+			t.Errorf("%s: expected %s by %s, got %+v", c.language, c.family, c.source, f)
+		}
+	}
+	for _, language := range []string{"", "go", "TS", "yaml"} {
+		if f := resolveFallback(cfg, language, "a.tf", "", ""); f != nil {
+			t.Errorf("%q: expected no fallback, got %+v", language, f)
+		}
+	}
+	cfg.Extensions = map[string]string{".tf": FamilyMarkup}
+	if f := resolveFallback(cfg, "terraform", "main.tf", "", ""); f == nil || f.Family != FamilyMarkup {
+		t.Errorf("expected the configured extension used, got %+v", f)
+	}
+	cfg.Disabled = true
+	if f := resolveFallback(cfg, "terraform", "main.tf", "", ""); f != nil {
+		t.Errorf("expected no fallback when disabled, got %+v", f)
+	}
+
+	if CheckLanguageFallback(&config.LanguageFallbackConfig{Default: "lisp-like"}) == nil ||
+		CheckLanguageFallback(&config.LanguageFallbackConfig{Extensions: map[string]string{"tf": FamilyCLike}}) == nil {
+		t.Error("expected an error for an invalid fallback config")
+	}
+	defer InitLanguageProfiles(nil)
+	unknown := "lisp-like"
+	if InitLanguageProfiles(map[string]config.LanguageOverride{"starlark": {Family: &unknown}}) == nil {
+		t.Error("expected an error for an unknown family of a language")
+	}
+}
+
+// to test the reduced feature set applied for each fallback family
+// go test ./pkg/completions/ -v -run Test_FallbackFeatures
+func Test_FallbackFeatures(t *testing.T) {
+	h := NewCompletionHandler(&scriptedLLM{cfg: config.ModelConfig{ModelName: "fallback"}})
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{})
+	input := func(language, path, prefix, suffix string) *CompletionInput {
+		in := &CompletionInput{CompletionRequest: CompletionRequest{LanguageID: language}}
+		in.Processed = PromptOptions{Prefix: prefix, Suffix: suffix, FileProjectPath: path}
+		in.resolveFallback(c)
+		return in
+	}
+	prune := func(in *CompletionInput, text string) (string, []string) {
+		para := h.Adapt(in)
+		para.PruneMode = PruneFull
+		code, _, hits := h.pruneCompletionCode(c, text, para)
+		return code, hits
+	}
+
+	// terraform: 按c-like检查括号匹配
+	tf := input("terraform", "infra/main.tf", "resource \"aws_s3_bucket\" \"b\" {\n", "\n")
+	if tf.scoreLanguage() != "c" {
+		t.Errorf("expected the c weights for the hidden score, got %s", tf.scoreLanguage())
+	}
+	if code, hits := prune(tf, "  bucket = \"logs\"\n}\n}"); code != "" || !slices.Equal(hits, []string{DiscardSyntaxError}) {
+		t.Errorf("expected unbalanced brackets discarded, got %q %v", code, hits)
+	}
+	if code, _ := prune(tf, "  bucket = \"logs\"\n}"); code != "  bucket = \"logs\"\n}" {
+		t.Errorf("expected balanced code kept, got %q", code)
+	}
+
+	// nim: 不做语法检查
+	nim := input("nim", "src/app.nim", "proc main() =\n", "\n")
+	if code, _ := prune(nim, "  echo(\"hi\""); code != "  echo(\"hi\"" {
+		t.Errorf("expected no syntax check for indent-based, got %q", code)
+	}
+
+	// 像二进制的语言标识: 纯文本，只做极端重复和重复文本的处理，停在空行
+	bin := input("application/octet-stream", "", "header\n", "footer line")
+	if !slices.Contains(h.prepareStopWords(bin), "\n\n") {
+		t.Errorf("expected the blank line stop for plain text, got %q", h.prepareStopWords(bin))
+	}
+	if code, hits := prune(bin, "body }\nfooter line"); code != "body }\nfooter line" || len(hits) != 0 {
+		t.Errorf("expected pruning mostly disabled for plain text, got %q %v", code, hits)
+	}
+	rsp := &CompletionResponse{}
+	bin.AttachVerbose(rsp)
+	expected := &LanguageFallback{Language: "application/octet-stream", Family: FamilyPlain, Source: FallbackInvalidID}
+	if rsp.Verbose == nil || !reflect.DeepEqual(rsp.Verbose.Input["languageFallback"], expected) {
+		t.Errorf("expected the fallback decision in verbose, got %+v", rsp.Verbose)
+	}
+
+	// 有语言配置的语言不回退
+	goInput := input("go", "main.go", "func main() {\n", "\n}")
+	if goInput.Fallback != nil || h.Adapt(goInput).LanguageFamily != "" {
+		t.Errorf("expected no fallback for go, got %+v", goInput.Fallback)
+	}
+}
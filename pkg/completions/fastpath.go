@@ -0,0 +1,81 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+	"slices"
+)
+
+// 没有配置wrapper.fastPath.maxPromptBytes时，极小请求前后缀合计的字节上限(约1k token)
+const defaultFastPathPromptBytes = 4096
+
+/**
+ * 识别极小请求，极小请求走快速路径
+ * @param {*CompletionContext} c - 补全上下文，提供请求的功能开关
+ * @returns {bool} 返回是否为极小请求
+ * @description
+ * - 需要开启功能开关fastPath
+ * - 单行补全：请求的停用词含"\n"
+ * - 不获取代码库上下文：请求没有自带上下文，且请求关闭了上下文或缺少获取所需的字段
+ * - 不在单文件组件的区块中(区块之外的内容要拼接到上下文)
+ * - 前后缀合计不超过MaxPromptBytes字节
+ * - 在解析提示词之后调用
+ * @example
+ * in.FastPath = in.detectFastPath(c)
+ */
+func (in *CompletionInput) detectFastPath(c *CompletionContext) bool {
+	if !c.Enabled(feature_flag.FastPath) || !slices.Contains(in.Stop, "\n") {
+		return false
+	}
+	if in.Processed.CodeContext != "" || in.Region != nil || in.contextSkipReason() == "" {
+		return false
+	}
+	limit := config.Wrapper.FastPath.MaxPromptBytes
+	if limit <= 0 {
+		limit = defaultFastPathPromptBytes
+	}
+	return len(in.Processed.Prefix)+len(in.Processed.Suffix) <= limit
+}
+
+// 快速路径不获取上下文，记录跳过的原因
+func (in *CompletionInput) skipFastPathContext(c *CompletionContext) {
+	in.ContextOutcome = ContextSkipped
+	in.ContextSkip = in.contextSkipReason()
+	c.Perf.ContextDuration = 0
+	metrics.IncrementContextFetches(in.ContextOutcome)
+}
+
+// 快速路径不使用的处理器：只对多行补全起作用，单行补全上不修改内容的
+var fastPathSkippedPruners = map[string]bool{
+	DiscardExtremeRepetition: true, // 至少5行
+	CutRepetitiveText:        true, // 至少3行
+	CutIndentStyle:           true, // 只转换第二行起的缩进
+}
+
+/**
+ * 创建快速路径使用的后置处理器链
+ * @param {*PrunerChain} full - full模式使用的处理器链
+ * @returns {*PrunerChain} 返回去掉只对多行补全起作用的处理器后的处理器链
+ * @description
+ * - 极小请求只补全一行，重复、缩进风格等多行相关的处理器对单行补全不修改内容，快速路径不构造和执行
+ * - 语法检查会改变单行补全的结果(如与行后缀组合后括号不匹配时丢弃)，保留在链中，保证结果与完整路径一致
+ * - 其余处理器保持full模式的配置和顺序
+ * - 补全结束清理在处理器链之前执行，不在链中
+ * @example
+ * chain := NewFastPrunerChain(NewDefaultPrunerChain())
+ * // chain的丢弃器为：语言不匹配、语法错误
+ * // chain的裁剪器为：引号风格、首行缩进、前缀重叠、重复参数、后缀重叠、语法错误、末尾标点
+ */
+func NewFastPrunerChain(full *PrunerChain) *PrunerChain {
+	keep := func(pruners []Pruner) []Pruner {
+		kept := make([]Pruner, 0, len(pruners))
+		for _, p := range pruners {
+			if !fastPathSkippedPruners[p.Name()] {
+				kept = append(kept, p)
+			}
+		}
+		return kept
+	}
+	return NewPrunerChain(keep(full.discarders), keep(full.cutters))
+}
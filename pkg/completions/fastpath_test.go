@@ -0,0 +1,105 @@
+package completions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 按是否开启快速路径处理一个单行、不获取上下文的极小请求
+func callFastPath(enabled bool, text string) (*CompletionInput, *CompletionResponse) {
+	config.Wrapper.FastPath = config.FastPathConfig{Enabled: enabled}
+	in := newContextInput(true)
+	in.Stop = []string{"\n"}
+	in.Prompts.ImportContent = ""
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	if rsp := in.Preprocess(c); rsp != nil {
+		return in, rsp
+	}
+	h := NewCompletionHandler(&scriptedLLM{cfg: config.ModelConfig{ModelName: "fast"}, texts: []string{text}})
+	return in, h.CallLLM(c, h.Adapt(in))
+}
+
+// to test tiny requests produce the same completions on the fast path as on the full path
+// go test ./pkg/completions/ -v -run Test_FastPath
+func Test_FastPath(t *testing.T) {
+	hits, restore := setupContextServer(`{"data":{"list":[]}}`)
+	defer restore()
+
+	for _, text := range []string{"d", "new Date()  ", "now<｜end▁of▁sentence｜>", "value, 'YYYY-MM-DD'", "d);"} {
+		slowIn, slow := callFastPath(false, text)
+		fastIn, fast := callFastPath(true, text)
+		if slowIn.FastPath || !fastIn.FastPath || !fast.Usage.FastPath {
+			t.Fatalf("%q: expected only the enabled run on the fast path", text)
+		}
+		if fast.Status != slow.Status || len(fast.Choices) != len(slow.Choices) {
+			t.Fatalf("%q: expected the same status, got %s and %s", text, fast.Status, slow.Status)
+		}
+		for i := range fast.Choices {
+			if fast.Choices[i].Text != slow.Choices[i].Text || fast.Choices[i].Confidence != slow.Choices[i].Confidence {
+				t.Errorf("%q: expected %+v, got %+v", text, slow.Choices[i], fast.Choices[i])
+			}
+		}
+		if fastIn.ContextOutcome != ContextSkipped || fastIn.ContextSkip != "disabled by request" {
+			t.Errorf("%q: expected the context skipped, got %s (%s)", text, fastIn.ContextOutcome, fastIn.ContextSkip)
+		}
+		if fast.Verbose != nil && fast.Verbose.Confidence != nil {
+			t.Errorf("%q: expected no verbose confidence on the fast path", text)
+		}
+	}
+	if *hits != 0 {
+		t.Errorf("expected no context requests, got %d", *hits)
+	}
+
+	// 请求verbose时仍生成调试信息
+	config.Wrapper.FastPath = config.FastPathConfig{Enabled: true}
+	in := newContextInput(true)
+	in.Stop = []string{"\n"}
+	in.Verbose = true
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	in.Preprocess(c)
+	h := NewCompletionHandler(&scriptedLLM{cfg: config.ModelConfig{ModelName: "fast"}, texts: []string{"d"}})
+	if rsp := h.CallLLM(c, h.Adapt(in)); !in.FastPath || rsp.Verbose == nil || rsp.Verbose.Confidence == nil {
+		t.Error("expected the verbose confidence when requested")
+	}
+
+	// 不符合极小请求的不走快速路径
+	for name, change := range map[string]func(in *CompletionInput){
+		"multi-line":   func(in *CompletionInput) { in.Stop = nil },
+		"context":      func(in *CompletionInput) { in.DisableContext = false },
+		"long prompt":  func(in *CompletionInput) { config.Wrapper.FastPath.MaxPromptBytes = 8 },
+		"with context": func(in *CompletionInput) { in.Prompts.CodeContext = "function formatDate(d) {}" },
+	} {
+		config.Wrapper.FastPath = config.FastPathConfig{Enabled: true}
+		in := newContextInput(true)
+		in.Stop = []string{"\n"}
+		change(in)
+		in.GetPrompts()
+		if in.detectFastPath(NewCompletionContext(context.Background(), &CompletionPerformance{})) {
+			t.Errorf("%s: expected the full path", name)
+		}
+	}
+}
+
+// go test ./pkg/completions/ -bench Benchmark_FastPath -benchmem -run ^$
+func Benchmark_FastPath(b *testing.B) {
+	_, restore := setupContextServer(`{"data":{"list":[]}}`)
+	defer restore()
+
+	for _, enabled := range []bool{false, true} {
+		name := "off"
+		if enabled {
+			name = "on"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, rsp := callFastPath(enabled, "d"); rsp.Status != model.StatusSuccess {
+					b.Fatal(rsp.Status)
+				}
+			}
+		})
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"os"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"code-completion/pkg/config"
-	"code-completion/pkg/logger"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
 
 	"go.uber.org/zap"
 )
@@ -22,11 +25,12 @@ const (
 	LowHiddenScore    RejectCode = "LOW_HIDDEN_SCORE"
 	AuthFail          RejectCode = "AUTH_FAIL"
 	FeatureNotSupport RejectCode = "FEATURE_NOT_SUPPORT"
+	GeneratedFile     RejectCode = "GENERATED_FILE"
 )
 
 // 补全过滤器接口
 type Filter interface {
-	Judge(in *CompletionInput) RejectCode
+	Judge(c *CompletionContext, in *CompletionInput) RejectCode
 }
 
 // 补全拒绝规则链
@@ -34,27 +38,111 @@ type FilterChain struct {
 	filters []Filter
 }
 
+/**
+ * 过滤器链依赖的运行时状态，单元测试时注入确定的值
+ * @description
+ * - Clock: 当前时间，用于计算距上一次补全的时间间隔，为nil时使用time.Now
+ * - Tuner: 隐藏分阈值的自动调整，为nil时使用独立的新实例
+ * - Boost: 模型安全模式和启动预热对阈值的提高幅度，为nil时不提高
+ * - Weights: 隐藏分权重，为nil时从hidden-scores.json加载，文件不存在时使用内置权重
+ */
+type FilterDeps struct {
+	Clock   func() time.Time
+	Tuner   *ThresholdTuner
+	Boost   func(modelName, triggerMode string) float64
+	Weights *HiddenScoreFilter
+
+	experiments func() *scoreExperimentSet // 隐藏分实验，为nil时按配置创建
+}
+
+// 服务运行时使用的全局依赖
+func globalFilterDeps() FilterDeps {
+	return FilterDeps{
+		Clock:       time.Now,
+		Tuner:       Tuner,
+		Boost:       globalThresholdBoost,
+		experiments: scoreExperiments,
+	}
+}
+
+// 模型处于安全模式时提高阈值，启动预热期间只提高AUTO触发的阈值
+func globalThresholdBoost(modelName, triggerMode string) float64 {
+	boost := ThresholdBoost(modelName)
+	if strings.ToUpper(triggerMode) == "AUTO" {
+		boost += WarmupBoost()
+	}
+	return boost
+}
+
 /**
  * Create new filter chain for completion request processing
  * @param {config.CompletionWrapperConfig} cfg - Configuration wrapper containing filter settings
  * @returns {FilterChain} Returns configured filter chain instance
  * @description
  * - Creates a chain of filters to evaluate completion requests
+ * - Adds generated/minified file filter first if not disabled in configuration
  * - Adds hidden score filter if not disabled in configuration
  * - Adds language feature filter if not disabled in configuration
  * - Filters are executed in the order they are added
  * @example
  * chain := NewFilterChain(config)
- * err := chain.Handle(request)
+ * err := chain.Handle(c, request)
  * if err != nil {
  *     // Handle rejection
  * }
  */
 func NewFilterChain(cfg *config.WrapperConfig) *FilterChain {
+	chain, _ := newFilterChain(cfg, globalFilterDeps(), &config.Wrapper.TestFile)
+	return chain
+}
+
+/**
+ * 按指定的配置和依赖创建过滤器链，不读取全局配置和全局状态
+ * @param {*config.WrapperConfig} cfg - 补全包装配置
+ * @param {FilterDeps} deps - 注入的依赖，未设置的使用与全局状态无关的默认值
+ * @returns {*FilterChain, error} 返回过滤器链；隐藏分实验配置无效时返回错误
+ * @description
+ * - 用于可重复的单元测试：固定时钟后相同的请求总是得到相同的拒绝结果
+ * @example
+ * clock := func() time.Time { return time.UnixMilli(1700000000000) }
+ * chain, err := NewFilterChainWith(&cfg, FilterDeps{Clock: clock})
+ */
+func NewFilterChainWith(cfg *config.WrapperConfig, deps FilterDeps) (*FilterChain, error) {
+	if deps.Clock == nil {
+		deps.Clock = time.Now
+	}
+	if deps.Tuner == nil {
+		deps.Tuner = NewThresholdTuner()
+	}
+	if deps.Boost == nil {
+		deps.Boost = func(string, string) float64 { return 0 }
+	}
+	if deps.experiments == nil {
+		set, err := newScoreExperimentSet(&cfg.Score.Experiment)
+		if err != nil {
+			return nil, err
+		}
+		deps.experiments = func() *scoreExperimentSet { return set }
+	}
+	return newFilterChain(cfg, deps, &cfg.TestFile)
+}
+
+func newFilterChain(cfg *config.WrapperConfig, deps FilterDeps, testFile *config.TestFileConfig) (*FilterChain, error) {
 	handlers := make([]Filter, 0)
 
+	// 是否识别生成/压缩文件按请求的开关判断，见feature_flag.Generated
+	handlers = append(handlers, NewGeneratedFilter(&cfg.Generated))
+
 	if !cfg.Score.Disabled {
-		handlers = append(handlers, NewScoreFilter(&cfg.Score))
+		filter := NewScoreFilter(&cfg.Score)
+		if deps.Weights != nil {
+			weights := *deps.Weights
+			weights.ThresholdScore = filter.ThresholdScore
+			filter = &weights
+		}
+		filter.now, filter.tuner, filter.boost = deps.Clock, deps.Tuner, deps.Boost
+		filter.experiments, filter.testFile = deps.experiments, testFile
+		handlers = append(handlers, filter)
 	}
 
 	if !cfg.Syntax.Disabled {
@@ -63,11 +151,12 @@ func NewFilterChain(cfg *config.WrapperConfig) *FilterChain {
 
 	return &FilterChain{
 		filters: handlers,
-	}
+	}, nil
 }
 
 /**
  * Handle completion request through filter chain
+ * @param {CompletionContext} c - Completion context carrying the request-scoped logger
  * @param {CompletionInput} in - Completion request data to be evaluated
  * @returns {error} Returns error if any filter rejects the request, nil if all filters accept
  * @description
@@ -76,14 +165,14 @@ func NewFilterChain(cfg *config.WrapperConfig) *FilterChain {
  * - Request must pass all filters to be accepted
  * - Returns specific error message indicating which filter rejected the request
  * @example
- * err := chain.Handle(request)
+ * err := chain.Handle(c, request)
  * if err != nil {
  *     log.Printf("Request rejected: %v", err)
  * }
  */
-func (c *FilterChain) Handle(in *CompletionInput) error {
+func (c *FilterChain) Handle(ctx *CompletionContext, in *CompletionInput) error {
 	for _, handler := range c.filters {
-		if rejectCode := handler.Judge(in); rejectCode != Accepted {
+		if rejectCode := handler.Judge(ctx, in); rejectCode != Accepted {
 			return fmt.Errorf("%s", rejectCode)
 		}
 	}
@@ -113,7 +202,7 @@ type CodeFilters struct {
  * - Uses default values if not provided in configuration
  * @example
  * filter := NewSyntaxFilter(config)
- * rejectCode := filter.Judge(request)
+ * rejectCode := filter.Judge(c, request)
  * if rejectCode == Accepted {
  *     // Process completion
  * }
@@ -180,17 +269,19 @@ func NewCodeFilters(minPromptLine int, strPattern, treePattern, endTag string) *
  *     // Process code completion
  * }
  */
-func (c *CodeFilters) Judge(in *CompletionInput) RejectCode {
+func (c *CodeFilters) Judge(ctx *CompletionContext, in *CompletionInput) RejectCode {
 	// 跳过手动触发模式
 	mode := strings.ToUpper(in.TriggerMode)
 	if mode == "MANUAL" || mode == "CONTINUE" {
 		return Accepted
 	}
 	if c.cursorIsAtTheEnd(in) {
+		in.Empty = &EmptyResult{Reason: EmptyReasonCursorAtLineEnd}
 		return FeatureNotSupport
 	}
 
 	if c.textAfterFillHereStartWithWord(in) {
+		in.Empty = &EmptyResult{Reason: EmptyReasonWordAfterCursor}
 		return FeatureNotSupport
 	}
 	// 简化实现，其他复杂的过滤逻辑暂时关闭
@@ -321,7 +412,7 @@ func (c *CodeFilters) parseEndTag() []string {
  * @returns {bool} Returns true if text after fill starts with word character, false otherwise
  * @description
  * - Extracts text after cursor position from prompt
- * - Checks if first character is letter (a-z, A-Z) or digit (0-9)
+ * - Checks if first character is a Unicode letter or digit, so CJK, Arabic and Hebrew identifiers count as words
  * - Returns true if text after cursor starts with word character
  * - Used to skip completion when modifying variable names
  * @example
@@ -330,11 +421,11 @@ func (c *CodeFilters) parseEndTag() []string {
  * }
  */
 func (c *CodeFilters) textAfterFillHereStartWithWord(in *CompletionInput) bool {
-	// 补全后面直接是英文字母开头或数字的不补全，比如修改变量名称的场景
+	// 补全后面直接是字母(包括非英文的标识符)或数字开头的不补全，比如修改变量名称的场景
 	_, textAfterCursor := c.splitPrompt(in.Processed.Prefix)
 	if textAfterCursor != "" {
-		firstChar := textAfterCursor[0]
-		if (firstChar >= 'a' && firstChar <= 'z') || (firstChar >= 'A' && firstChar <= 'Z') || (firstChar >= '0' && firstChar <= '9') {
+		firstChar, _ := utf8.DecodeRuneInString(textAfterCursor)
+		if unicode.IsLetter(firstChar) || unicode.IsDigit(firstChar) {
 			// fmt.Printf("光标后面是字符`%c`，跳过自动补全\n", firstChar)
 			return true
 		}
@@ -386,6 +477,29 @@ type HiddenScoreFilter struct {
 	ContextualFilterAcceptThreshold float64
 	ContextualFilterIntercept       float64
 	ContextualFilterCharacterMap    map[string]int
+
+	// 运行时依赖，见FilterDeps；直接创建的过滤器使用全局状态
+	now         func() time.Time
+	tuner       *ThresholdTuner
+	boost       func(modelName, triggerMode string) float64
+	experiments func() *scoreExperimentSet
+	testFile    *config.TestFileConfig
+}
+
+// 未注入依赖时使用全局状态
+func (h *HiddenScoreFilter) deps() FilterDeps {
+	if h.now == nil {
+		return globalFilterDeps()
+	}
+	return FilterDeps{Clock: h.now, Tuner: h.tuner, Boost: h.boost, experiments: h.experiments}
+}
+
+// 测试文件的阈值配置
+func (h *HiddenScoreFilter) testFileConfig() *config.TestFileConfig {
+	if h.testFile == nil {
+		return &config.Wrapper.TestFile
+	}
+	return h.testFile
 }
 
 /**
@@ -398,7 +512,7 @@ type HiddenScoreFilter struct {
  * - Initializes hide score configuration with default threshold if not provided
  * @example
  * filter := NewScoreFilter(config)
- * rejectCode := filter.Judge(request)
+ * rejectCode := filter.Judge(c, request)
  * if rejectCode == Accepted {
  *     // Process completion
  * }
@@ -413,21 +527,31 @@ func NewScoreFilter(cfg *config.ScoreFilterConfig) *HiddenScoreFilter {
 
 /**
  * Judge if completion request should be accepted based on hidden score
+ * @param {CompletionContext} c - Completion context carrying the request-scoped logger
  * @param {CompletionInput} in - Completion request data with score calculation info
  * @returns {RejectCode} Returns AcceptCode if score is above threshold, LowHiddenScore otherwise
  * @description
  * - Skips filtering for manual and continue trigger modes (always accepts)
- * - Calculates hidden score using configured algorithm
+ * - Calculates hidden score using configured algorithm, with the weights of the assigned experiment variant if any
  * - Updates request data with calculated score
  * - Rejects completions with scores below threshold
+ * - With auto tune on, feeds the acceptance of the completion fed back to the tuner, and keeps the score of
+ *   an accepted request so the completion becomes a sample once delivered
  * - Logs debug information for rejected completions
  * @example
- * rejectCode := filter.Judge(request)
+ * rejectCode := filter.Judge(c, request)
  * if rejectCode == LowHiddenScore {
  *     log.Printf("Completion rejected due to low score")
  * }
  */
-func (h *HiddenScoreFilter) Judge(in *CompletionInput) RejectCode {
+func (h *HiddenScoreFilter) Judge(c *CompletionContext, in *CompletionInput) RejectCode {
+	deps := h.deps()
+	// 被反馈的补全的采纳结果，用于按语言自动调整阈值；是否自动调整按请求的开关判断
+	tune := c.Enabled(feature_flag.AutoTune)
+	if tune {
+		deps.Tuner.Feedback(in.Feedback)
+	}
+
 	// 跳过手动触发和继续补全模式
 	mode := strings.ToUpper(in.TriggerMode)
 	if mode == "MANUAL" || mode == "CONTINUE" {
@@ -439,10 +563,18 @@ func (h *HiddenScoreFilter) Judge(in *CompletionInput) RejectCode {
 		return Accepted
 	}
 
+	// 参与隐藏分实验时使用分配的变体的权重
+	weights := h
+	in.ScoreVariant = NoScoreVariant
+	if variant := deps.experiments().assign(in.ClientID); variant != nil {
+		weights, in.ScoreVariant = variant.weights, variant.name
+	}
+
 	score := 0.0
 	if in.HideScores.DocumentLength != 0 {
-		score = h.CalculateHideScore(in.HideScores, in.Processed.Prefix, in.LanguageID)
+		score = weights.hideScore(in.HideScores, in.Processed.Prefix, in.scoreLanguage(), deps.Clock())
 	}
+	metrics.ObserveHiddenScore(in.ScoreVariant, score)
 
 	// 将分数更新到请求数据中（问题4修复）
 	if in.Extra == nil {
@@ -450,17 +582,32 @@ func (h *HiddenScoreFilter) Judge(in *CompletionInput) RejectCode {
 	}
 	in.Extra["score"] = score
 
-	// 通过配置阈值来过滤隐藏分低的补全
-	if score < h.ThresholdScore {
+	// 通过配置阈值来过滤隐藏分低的补全，启用自动调整时使用该语言调整后的阈值，模型处于安全模式时再提高
+	// 测试文件的阈值单独调整，并在配置的阈值上加偏移；没有语言配置的语言合并为other，按语言的记录和指标不会无限增长
+	tunerKey, base := languageLabel(in.EffectiveLanguage()), h.ThresholdScore
+	if in.TestFile != nil {
+		tunerKey += "/" + FileKindTest
+		if offset := h.testFileConfig().ThresholdOffset; offset != nil {
+			base += *offset
+		}
+	}
+	threshold := base
+	if tune {
+		threshold = deps.Tuner.Threshold(tunerKey, base)
+	}
+	threshold += deps.Boost(in.Model, mode)
+	if score < threshold {
 		// 添加日志记录（问题1修复）
-		logger.Debug("低隐藏分数拒绝补全",
+		c.Log().Debug("低隐藏分数拒绝补全",
 			zap.Float64("score", score),
-			zap.Float64("threshold", h.ThresholdScore),
-			zap.String("completion_id", in.CompletionID),
-			zap.String("language", in.LanguageID))
+			zap.Float64("threshold", threshold))
 		return LowHiddenScore
 	}
 
+	// 补全成功返回后才随返回的补全记录为样本，被拒绝或返回失败的补全没有采纳结果
+	if tune {
+		in.tuned = &tunedScore{language: tunerKey, score: score}
+	}
 	return Accepted
 }
 
@@ -494,17 +641,19 @@ func NewHiddenScoreFilter(configPath string, thresholdScore float64) *HiddenScor
 	if thresholdScore == 0.0 {
 		thresholdScore = 0.3
 	}
-	if filter := loadHiddenScoreFilter(configPath); filter != nil {
-		filter.ThresholdScore = thresholdScore
-		return filter
+	filter := loadHiddenScoreFilter(configPath)
+	if filter == nil {
+		filter = newDefaultHiddenScoreFilter()
 	}
+	filter.ThresholdScore = thresholdScore
+	return filter
+}
+
+// 内置的隐藏分权重，没有权重文件时使用
+func newDefaultHiddenScoreFilter() *HiddenScoreFilter {
 	// 默认配置，模拟YAML文件中的配置
-	filter := &HiddenScoreFilter{
-		ContextualFilterLanguageMap: map[string]int{
-			"python": 0, "javascript": 1, "typescript": 2, "java": 3, "go": 4,
-			"c": 5, "cpp": 6, "csharp": 7, "php": 8, "ruby": 9,
-			"rust": 10, "kotlin": 11, "scala": 12, "swift": 13, "objective-c": 14,
-		},
+	return &HiddenScoreFilter{
+		ContextualFilterLanguageMap: scoreLanguageMap(), // 见LanguageProfile.ScoreIndex
 		ContextualFilterWeights: []float64{
 			0.99,   // 上一个标签的权重
 			0.7,    // 当前行光标后为空的权重
@@ -527,8 +676,6 @@ func NewHiddenScoreFilter(configPath string, thresholdScore float64) *HiddenScor
 			"$": 27, "%": 28, "^": 29, "&": 30, "|": 31, "~": 32, "`": 33,
 		},
 	}
-	filter.ThresholdScore = thresholdScore
-	return filter
 }
 
 /**
@@ -549,6 +696,11 @@ func NewHiddenScoreFilter(configPath string, thresholdScore float64) *HiddenScor
  * }
  */
 func (h *HiddenScoreFilter) CalculateHideScore(scores *HiddenScoreOptions, prefix, language string) float64 {
+	return h.hideScore(scores, prefix, language, time.Now())
+}
+
+// 按指定的当前时间计算隐藏分，见CalculateHideScore
+func (h *HiddenScoreFilter) hideScore(scores *HiddenScoreOptions, prefix, language string, now time.Time) float64 {
 	// 判断光标权重
 	whitespaceAfterCursor := 0.0
 	if scores.IsWhitespaceAfterCursor {
@@ -556,7 +708,7 @@ func (h *HiddenScoreFilter) CalculateHideScore(scores *HiddenScoreOptions, prefi
 	}
 
 	// 触发时间间隔
-	timeSincePreviousLabel := float64(time.Now().Unix()*1000-scores.PreviousLabelTimestamp) / 1000.0
+	timeSincePreviousLabel := float64(now.Unix()*1000-scores.PreviousLabelTimestamp) / 1000.0
 
 	// 3.6最小值参考copilot的设置
 	timeSincePreviousLabelLog := math.Log(1.0 + math.Max(3.6, timeSincePreviousLabel))
@@ -567,7 +719,7 @@ func (h *HiddenScoreFilter) CalculateHideScore(scores *HiddenScoreOptions, prefi
 
 	if prefixStr != "" {
 		prefixLengthLog = math.Log(1.0 + float64(h.getLastLineLength(prefixStr)))
-		prefixLastChar := prefixStr[len(prefixStr)-1:]
+		prefixLastChar := scoreCharacter(prefixStr)
 		if weight, exists := h.ContextualFilterCharacterMap[prefixLastChar]; exists {
 			prefixLastCharWeight = weight
 		}
@@ -580,7 +732,7 @@ func (h *HiddenScoreFilter) CalculateHideScore(scores *HiddenScoreOptions, prefi
 	trimmedSuffixStr := strings.TrimRight(prefixStr, " \t\n\r")
 	if trimmedSuffixStr != "" {
 		suffixLengthLog = math.Log(1.0 + float64(h.getLastLineLength(trimmedSuffixStr)))
-		suffixLastChar := trimmedSuffixStr[len(trimmedSuffixStr)-1:]
+		suffixLastChar := scoreCharacter(trimmedSuffixStr)
 		if weight, exists := h.ContextualFilterCharacterMap[suffixLastChar]; exists {
 			suffixLastCharWeight = weight
 		}
@@ -592,7 +744,7 @@ func (h *HiddenScoreFilter) CalculateHideScore(scores *HiddenScoreOptions, prefi
 
 	// 若不支持该语言，默认走python
 	languageWeight := 4 // python的默认值
-	if weight, exists := h.ContextualFilterLanguageMap[language]; exists {
+	if weight, exists := h.ContextualFilterLanguageMap[profileOf(language).ID]; exists {
 		languageWeight = weight
 	}
 
@@ -667,7 +819,7 @@ func (h *HiddenScoreFilter) CalculateHideScore(scores *HiddenScoreOptions, prefi
  * @returns {int} Returns length of last line, 0 if text is empty
  * @description
  * - Splits text into individual lines
- * - Returns length of the last line in the text
+ * - Returns length of the last line in characters (runes), multi-byte characters count once
  * - Handles empty text by returning 0
  * - Used for calculating prefix and suffix lengths in hide score calculation
  * @example
@@ -682,5 +834,38 @@ func (h *HiddenScoreFilter) getLastLineLength(text string) int {
 	if len(lines) == 0 {
 		return 0
 	}
-	return len(lines[len(lines)-1])
+	return utf8.RuneCountInString(lines[len(lines)-1])
+}
+
+// 中文标点与ASCII标点的对应，全角字符(U+FF01-U+FF5E)按固定偏移转换，见scoreCharacter
+var cjkPunctuation = map[rune]rune{
+	'\u3000': ' ', '、': ',', '。': '.', '「': '"', '」': '"', '『': '"', '』': '"',
+	'【': '[', '】': ']', '《': '<', '》': '>', '〈': '<', '〉': '>',
+	'\u2018': '\'', '\u2019': '\'', '\u201c': '"', '\u201d': '"',
+}
+
+/**
+ * Get the last character of text as a key of the character map
+ * @param {string} text - Non-empty text to take the last character from
+ * @returns {string} Returns the last character, fullwidth and CJK punctuation normalized to ASCII
+ * @description
+ * - Takes the last rune instead of the last byte, so multi-byte characters are never split
+ * - Fullwidth forms (U+FF01-U+FF5E) and the ideographic space map to their ASCII counterparts
+ * - Common CJK punctuation (、。「」【】《》 and curly quotes) maps to the closest ASCII punctuation
+ * - ASCII input is returned unchanged; letters outside the map still fall back to weight index 0
+ * @example
+ * ch := scoreCharacter("foo(a，")
+ * // ch will be ","
+ */
+func scoreCharacter(text string) string {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	switch {
+	case r >= '\uff01' && r <= '\uff5e':
+		r -= 0xfee0
+	default:
+		if ascii, ok := cjkPunctuation[r]; ok {
+			r = ascii
+		}
+	}
+	return string(r)
 }
@@ -0,0 +1,130 @@
+package completions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"code-completion/pkg/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// filterFixture is a synthetic completion request written by hand in the plugin's format, with the reject decision expected
+type filterFixture struct {
+	Name     string            `json:"name"`
+	Now      int64             `json:"now"`      // milliseconds, the clock of the hidden score
+	Expected RejectCode        `json:"expected"` // required, tuning changes must update it explicitly
+	Request  CompletionRequest `json:"request"`
+}
+
+// to test the reject decisions of the whole filter chain on synthetic requests with the default config, see testdata/filter
+// go test ./pkg/completions/ -v -run Test_FilterFixtures
+func Test_FilterFixtures(t *testing.T) {
+	data, err := os.ReadFile("testdata/filter/wrapper.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var overlay config.WrapperConfig
+	if err := yaml.Unmarshal(data, &overlay); err != nil {
+		t.Fatal(err)
+	}
+	// 其它项取代码中的默认值，默认值调整后用例随之更新
+	saved := *config.Config
+	*config.Config = config.SoftwareConfig{Wrapper: overlay}
+	config.ApplyDefaults()
+	cfg := config.Config.Wrapper
+	*config.Config = saved
+	files, err := filepath.Glob("testdata/filter/cases/*.json")
+	if err != nil || len(files) < 20 {
+		t.Fatalf("expected at least 20 fixtures, got %d %v", len(files), err)
+	}
+	known := map[RejectCode]bool{Accepted: true, LowHiddenScore: true, FeatureNotSupport: true, GeneratedFile: true}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f filterFixture
+			if err := json.Unmarshal(data, &f); err != nil {
+				t.Fatal(err)
+			}
+			if !known[f.Expected] || f.Now == 0 {
+				t.Fatalf("%s: every fixture must set 'now' and a known 'expected', got %q", f.Name, f.Expected)
+			}
+			// 每个用例使用独立的阈值调整和固定的时钟，结果与执行顺序和当前时间无关
+			chain, err := NewFilterChainWith(&cfg, FilterDeps{
+				Clock:   func() time.Time { return time.UnixMilli(f.Now) },
+				Weights: newDefaultHiddenScoreFilter(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := &CompletionInput{CompletionRequest: f.Request}
+			in.GetPrompts()
+			if !cfg.TestFile.Disabled {
+				in.TestFile = detectTestFile(in.EffectiveLanguage(), in.Processed.FileProjectPath)
+			}
+			code := Accepted
+			if err := chain.Handle(NewCompletionContext(context.Background(), &CompletionPerformance{}), in); err != nil {
+				code = RejectCode(err.Error())
+			}
+			if code != f.Expected {
+				t.Errorf("%s: expected %s, got %s (score %v)", f.Name, f.Expected, code, in.Extra["score"])
+			}
+		})
+	}
+}
+
+// to test the cursor-line heuristics on CJK identifiers and fullwidth punctuation, and that ASCII input is unchanged
+// go test ./pkg/completions/ -v -run Test_UnicodeCursorLine
+func Test_UnicodeCursorLine(t *testing.T) {
+	filters := NewCodeFilters(0, "", "", "")
+	wordAfter := func(after string) bool {
+		in := &CompletionInput{}
+		in.Processed.Prefix = "x = <FILL_HERE>" + after
+		return filters.textAfterFillHereStartWithWord(in)
+	}
+	// ASCII字符的判断与只认英文字母和数字时相同
+	for c := rune(0); c < utf8.RuneSelf; c++ {
+		expected := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if wordAfter(string(c)+" rest") != expected {
+			t.Errorf("%q: expected %v", c, expected)
+		}
+	}
+	for after, expected := range map[string]bool{"用户名 = 1": true, "متغير": true, "שם": true, "٣": true, "，b": false, "（": false} {
+		if wordAfter(after) != expected {
+			t.Errorf("%q: expected %v", after, expected)
+		}
+	}
+
+	// 全角标点按对应的ASCII标点取权重，ASCII前缀的分数不变
+	h := newDefaultHiddenScoreFilter()
+	h.ContextualFilterWeights = append(h.ContextualFilterWeights, make([]float64, 160-len(h.ContextualFilterWeights))...)
+	for _, index := range h.ContextualFilterCharacterMap {
+		h.ContextualFilterWeights[29+index] = float64(index) / 10
+		h.ContextualFilterWeights[125+index] = float64(index) / 20
+	}
+	opts := &HiddenScoreOptions{DocumentLength: 100, PromptEndPos: 50}
+	now := time.UnixMilli(1700000000000)
+	score := func(prefix string) float64 { return h.hideScore(opts, prefix, "go", now) }
+	if fullwidth, ascii := score("call(a，"), score("call(a,"); fullwidth != ascii {
+		t.Errorf("expected the fullwidth comma weighted as a comma, got %v and %v", fullwidth, ascii)
+	}
+	if cjk, ascii := score("f(用户名，"), score("f(abc,"); cjk != ascii {
+		t.Errorf("expected CJK characters counted once in the line length, got %v and %v", cjk, ascii)
+	}
+	if score("call(a,") == score("call(a") {
+		t.Error("expected the comma weight applied")
+	}
+	for _, prefix := range []string{"x := 1\n\tfoo(", "return a.b;", "if x {\n  \n"} {
+		if scoreCharacter(prefix) != prefix[len(prefix)-1:] {
+			t.Errorf("%q: expected the last byte of ASCII input", prefix)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package completions
+
+import (
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 没有配置盐时，本次启动随机生成的盐
+var (
+	randomSaltOnce sync.Once
+	randomSalt     []byte
+)
+
+// 计算指纹使用的盐
+func fingerprintSalt(cfg *config.FingerprintConfig) []byte {
+	if cfg.Salt != "" {
+		return []byte(cfg.Salt)
+	}
+	randomSaltOnce.Do(func() {
+		randomSalt = make([]byte, 32)
+		rand.Read(randomSalt)
+		zap.L().Warn("No fingerprint salt configured, prompt fingerprints differ across restarts and instances")
+	})
+	return randomSalt
+}
+
+/**
+ * 规范化参与指纹计算的代码
+ * @param {string} text - 前缀或后缀
+ * @returns {[]string} 返回规范化后的非空行
+ * @description
+ * - 换行统一为\n(\r\n和单独的\r都视为换行)
+ * - 每行连续的空白(空格、制表符等)合并为一个空格，并去掉行首行尾的空白
+ * - 去掉空行，只改变缩进、空行或换行符的编辑不改变指纹
+ */
+func normalizeFingerprintLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// 规范化文件路径：反斜杠统一为斜杠，去掉首尾空白和开头的./
+func normalizeFingerprintPath(path string) string {
+	path = strings.ReplaceAll(strings.TrimSpace(path), "\\", "/")
+	return strings.TrimPrefix(path, "./")
+}
+
+/**
+ * 计算提示词指纹
+ * @param {*config.FingerprintConfig} cfg - 指纹配置
+ * @param {string} prefix - 光标前的代码
+ * @param {string} suffix - 光标后的代码
+ * @param {string} filePath - 文件路径
+ * @returns {string} 返回十六进制的指纹
+ * @description
+ * - 是否计算指纹由调用方按请求的开关判断，见feature_flag.Fingerprint
+ * - 取规范化后前缀的末尾PrefixLines行、后缀的开头SuffixLines行，见normalizeFingerprintLines
+ * - 以盐为密钥计算HMAC-SHA256，截取前Length个十六进制字符
+ * - 指纹只用于关联各系统的日志，不能还原代码；不同的盐得到的指纹不能比对
+ * @example
+ * fp := PromptFingerprint(&config.Wrapper.Fingerprint, "func main() {\n\t", "\n}", "cmd/main.go")
+ */
+func PromptFingerprint(cfg *config.FingerprintConfig, prefix, suffix, filePath string) string {
+	prefixLines := normalizeFingerprintLines(prefix)
+	prefixLines = prefixLines[max(len(prefixLines)-max(cfg.PrefixLines, 1), 0):]
+	suffixLines := normalizeFingerprintLines(suffix)
+	suffixLines = suffixLines[:min(max(cfg.SuffixLines, 1), len(suffixLines))]
+
+	mac := hmac.New(sha256.New, fingerprintSalt(cfg))
+	mac.Write([]byte(normalizeFingerprintPath(filePath)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strings.Join(prefixLines, "\n")))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strings.Join(suffixLines, "\n")))
+	fp := hex.EncodeToString(mac.Sum(nil))
+	if cfg.Length > 0 && cfg.Length < len(fp) {
+		fp = fp[:cfg.Length]
+	}
+	return fp
+}
+
+/**
+ * 计算请求的提示词指纹
+ * @param {*feature_flag.Evaluator} flags - 请求的功能开关
+ * @returns {string} 返回指纹，开关feature_flag.Fingerprint关闭时返回空字符串
+ * @description
+ * - 使用请求中原始的前缀、后缀和文件路径，与预处理的顺序无关
+ * - 指纹加入发往代码库检索服务的请求头，请求头被复制，不修改原始请求
+ */
+func (in *CompletionInput) ComputeFingerprint(flags *feature_flag.Evaluator) string {
+	if !flags.Enabled(feature_flag.Fingerprint) {
+		in.Fingerprint = ""
+		return ""
+	}
+	prefix, suffix, filePath := in.Prompt, "", in.FileProjectPath
+	if in.Prompts != nil {
+		prefix, suffix = in.Prompts.Prefix, in.Prompts.Suffix
+		if in.Prompts.FileProjectPath != "" {
+			filePath = in.Prompts.FileProjectPath
+		}
+	}
+	in.Fingerprint = PromptFingerprint(&config.Wrapper.Fingerprint, prefix, suffix, filePath)
+	if in.Fingerprint != "" {
+		headers := in.Headers.Clone()
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set(codebase_context.HeaderPromptFingerprint, in.Fingerprint)
+		in.Headers = headers
+	}
+	return in.Fingerprint
+}
@@ -0,0 +1,78 @@
+package completions
+
+import (
+	"net/http"
+	"testing"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+)
+
+// to test the same editing state always produces the same fingerprint, whatever the whitespace and line endings
+// go test ./pkg/completions/ -v -run Test_PromptFingerprint
+func Test_PromptFingerprint(t *testing.T) {
+	cfg := &config.FingerprintConfig{Salt: "deployment-a", PrefixLines: 3, SuffixLines: 2, Length: 16}
+	prefix := "package main\n\nfunc add(a, b int) int {\n\tsum := a + b\n\treturn "
+	suffix := "\n}\n\nfunc main() {}\n"
+	fp := PromptFingerprint(cfg, prefix, suffix, "cmd/main.go")
+	if len(fp) != 16 {
+		t.Fatalf("expected a fingerprint of 16 hex chars, got %q", fp)
+	}
+
+	same := map[string][3]string{
+		"crlf":        {"package main\r\n\r\nfunc add(a, b int) int {\r\n\tsum := a + b\r\n\treturn ", "\r\n}\r\n\r\nfunc main() {}\r\n", "cmd/main.go"},
+		"indentation": {"package main\n\nfunc add(a, b int) int {\n    sum  :=  a + b   \n        return ", "\n}\n\nfunc  main()  {}", "cmd/main.go"},
+		"blank lines": {prefix, "\n\n\n}\n\n\nfunc main() {}", "cmd/main.go"},
+		"older lines": {"// edited\nfunc add(a, b int) int {\n\tsum := a + b\n\treturn ", suffix, "cmd/main.go"},
+		"path":        {prefix, suffix, ".\\cmd\\main.go"},
+	}
+	for name, c := range same {
+		if got := PromptFingerprint(cfg, c[0], c[1], c[2]); got != fp {
+			t.Errorf("%s: expected the same fingerprint %s, got %s", name, fp, got)
+		}
+	}
+	different := map[string][3]string{
+		"cursor line": {prefix + "sum", suffix, "cmd/main.go"},
+		"suffix":      {prefix, "\n}\n\nfunc other() {}\n", "cmd/main.go"},
+		"file":        {prefix, suffix, "cmd/add.go"},
+	}
+	for name, c := range different {
+		if got := PromptFingerprint(cfg, c[0], c[1], c[2]); got == fp {
+			t.Errorf("%s: expected a different fingerprint", name)
+		}
+	}
+	other := *cfg
+	other.Salt = "deployment-b"
+	if PromptFingerprint(&other, prefix, suffix, "cmd/main.go") == fp {
+		t.Error("expected a different fingerprint with another salt")
+	}
+}
+
+// to test the fingerprint of a request goes to the codebase search headers without touching the original ones
+// go test ./pkg/completions/ -v -run Test_ComputeFingerprint
+func Test_ComputeFingerprint(t *testing.T) {
+	saved := config.Wrapper.Fingerprint
+	defer func() { config.Wrapper.Fingerprint = saved }()
+	config.Wrapper.Fingerprint = config.FingerprintConfig{Salt: "s", PrefixLines: 3, SuffixLines: 2, Length: 12}
+
+	original := http.Header{"Authorization": {"Bearer t"}}
+	in := &CompletionInput{CompletionRequest: CompletionRequest{
+		Prompts: &PromptOptions{Prefix: "x := ", Suffix: "\n", FileProjectPath: "a.go"},
+	}, Headers: original}
+	fp := in.ComputeFingerprint(nil)
+	if fp == "" || fp != PromptFingerprint(&config.Wrapper.Fingerprint, "x := ", "\n", "a.go") {
+		t.Fatalf("unexpected fingerprint %q", fp)
+	}
+	if in.Headers.Get(codebase_context.HeaderPromptFingerprint) != fp || in.Headers.Get("Authorization") != "Bearer t" {
+		t.Errorf("expected the fingerprint added to the headers, got %v", in.Headers)
+	}
+	if original.Get(codebase_context.HeaderPromptFingerprint) != "" {
+		t.Error("expected the original headers unchanged")
+	}
+
+	config.Wrapper.Fingerprint.Disabled = true
+	in = &CompletionInput{CompletionRequest: CompletionRequest{Prompt: "x := "}, Headers: original}
+	if fp := in.ComputeFingerprint(nil); fp != "" || in.Headers.Get(codebase_context.HeaderPromptFingerprint) != "" {
+		t.Errorf("expected no fingerprint when disabled, got %q", fp)
+	}
+}
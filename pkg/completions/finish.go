@@ -0,0 +1,119 @@
+package completions
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"code-completion/pkg/config"
+)
+
+// 补全结束清理的名称，清理生效时记录在命中的处理器中
+const CleanupFinish string = "cleanup-finish"
+
+// 默认的FIM停用词
+const defaultFimStop = "<｜end▁of▁sentence｜>"
+
+// 残留的哨兵词前缀至少包含的字符数，避免误删代码中的单个字符(如"<")
+const minSentinelFragment = 2
+
+/**
+ * Collect the sentinel tokens of the model
+ * @param {*config.ModelConfig} cfg - Model configuration with FIM markers
+ * @returns {[]string} Returns FIM markers, FIM stop words and the default FIM stop word
+ */
+func modelSentinels(cfg *config.ModelConfig) []string {
+	sentinels := make([]string, 0, len(cfg.FimStop)+4)
+	for _, s := range []string{cfg.FimBegin, cfg.FimEnd, cfg.FimHole} {
+		if s != "" {
+			sentinels = append(sentinels, s)
+		}
+	}
+	for _, s := range cfg.FimStop {
+		if s != "" {
+			sentinels = append(sentinels, s)
+		}
+	}
+	return append(sentinels, defaultFimStop)
+}
+
+/**
+ * 补全结束清理，在模型返回补全内容后、后置处理器链之前执行
+ * @param {*PrunerContext} ctx - 后置处理器上下文，需要设置Stop、Sentinels和FinishReason
+ * @returns {bool} 返回是否修改了补全内容
+ * @description
+ * - 有的模型后端在返回内容末尾带上触发的停用词，有的不带；去掉末尾的停用词和哨兵词
+ * - 去掉末尾残留的哨兵词前缀(如"<｜")
+ * - 去掉了停用词或哨兵词，或者因长度限制结束(FinishReason为length)时，若最后一行明显不完整(引号数量为奇数，或以左括号结尾)，丢弃最后一行
+ * - 模型自然结束(FinishReason为stop)且没有停用词残留时，最后一行是模型自己给出的，不丢弃
+ * - 单行补全不丢弃，交给后置处理器链处理
+ * - 没有停用词残留也不是因长度限制结束的补全保持不变
+ * @example
+ * ctx := &PrunerContext{
+ *     CompletionCode: "a = 1\nb = \"x<｜",
+ *     Sentinels: []string{"<｜end▁of▁sentence｜>"},
+ * }
+ * cleanupFinish(ctx)
+ * // ctx.CompletionCode = "a = 1"
+ */
+func cleanupFinish(ctx *PrunerContext) bool {
+	text := ctx.CompletionCode
+	stripped := false
+	for {
+		trimmed := trimStopSuffix(text, ctx.Stop, ctx.Sentinels)
+		if trimmed == text {
+			break
+		}
+		text = trimmed
+		stripped = true
+	}
+	if stripped || ctx.FinishReason == "length" {
+		text = dropUnterminatedLine(text)
+	}
+	if text == ctx.CompletionCode {
+		return false
+	}
+	ctx.CompletionCode = text
+	return true
+}
+
+// 去掉末尾的一个停用词、哨兵词或哨兵词前缀
+func trimStopSuffix(text string, stops, sentinels []string) string {
+	for _, words := range [][]string{stops, sentinels} {
+		for _, word := range words {
+			if word != "" && strings.HasSuffix(text, word) {
+				return strings.TrimSuffix(text, word)
+			}
+		}
+	}
+	for _, sentinel := range sentinels {
+		runes := []rune(sentinel)
+		for n := len(runes) - 1; n >= minSentinelFragment; n-- {
+			if fragment := string(runes[:n]); strings.HasSuffix(text, fragment) {
+				return strings.TrimSuffix(text, fragment)
+			}
+		}
+	}
+	return text
+}
+
+// 丢弃明显不完整的最后一行，单行补全保持不变
+func dropUnterminatedLine(text string) string {
+	idx := strings.LastIndex(text, "\n")
+	if idx < 0 {
+		return text
+	}
+	last := strings.TrimRight(text[idx+1:], " \t\r")
+	if last == "" {
+		return text
+	}
+	if IsCursorInString(last) || endsWithOpenBracket(last) {
+		return text[:idx]
+	}
+	return text
+}
+
+// 是否以左括号结尾
+func endsWithOpenBracket(line string) bool {
+	r, _ := utf8.DecodeLastRuneInString(line)
+	return r == '(' || r == '[' || r == '{'
+}
@@ -0,0 +1,55 @@
+package completions
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// finishFixture is a model output captured from a backend, with or without the stop sequence
+type finishFixture struct {
+	Name         string `json:"name"`
+	Backend      string `json:"backend"`
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason"`
+	Expected     string `json:"expected"`
+}
+
+// to test cleaning up stop artifacts after receiving the model text
+// go test ./pkg/completions/ -v -run Test_CleanupFinish
+func Test_CleanupFinish(t *testing.T) {
+	data, err := os.ReadFile("testdata/finish_cleanup.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []finishFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ModelConfig{
+		FimBegin: "<｜fim▁begin｜>",
+		FimEnd:   "<｜fim▁end｜>",
+		FimHole:  "<｜fim▁hole｜>",
+		FimStop:  []string{"<|EOT|>"},
+	}
+	stops := []string{defaultFimStop, "\n\n", "\n\n\n"}
+	for _, f := range fixtures {
+		t.Run(f.Backend+"/"+f.Name, func(t *testing.T) {
+			ctx := &PrunerContext{
+				CompletionCode: f.Text,
+				Stop:           stops,
+				Sentinels:      modelSentinels(cfg),
+				FinishReason:   f.FinishReason,
+			}
+			changed := cleanupFinish(ctx)
+			if ctx.CompletionCode != f.Expected {
+				t.Errorf("expected %q, got %q", f.Expected, ctx.CompletionCode)
+			}
+			if changed != (f.Text != f.Expected) {
+				t.Errorf("expected changed=%v, got %v", f.Text != f.Expected, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// 生成/压缩文件的处理方式
+const (
+	GeneratedFlag    = "flag"    // 只在verbose和日志中标记，照常补全
+	GeneratedReject  = "reject"  // 拒绝补全
+	GeneratedDegrade = "degrade" // 缩减提示词后只补全一行
+)
+
+// 检测文件头时检查的前缀字节数
+const generatedHeaderBytes = 2048
+
+/**
+ * 生成/压缩文件的检测结果
+ * @description
+ * - Signal: 命中的判断依据(file/header/line_length/whitespace)
+ * - Detail: 命中的文件名后缀、文件头正则或统计值
+ * - Action: 处理方式(flag/reject/degrade)
+ */
+type GeneratedDecision struct {
+	Signal string `json:"signal"`
+	Detail string `json:"detail"`
+	Action string `json:"action"`
+}
+
+// 生成/压缩文件过滤器
+type GeneratedFilter struct {
+	cfg     *config.GeneratedFilterConfig
+	headers []*regexp.Regexp
+}
+
+// 编译好的文件头正则
+type generatedHeaders struct {
+	patterns []string
+	res      []*regexp.Regexp
+}
+
+// 启动时按配置编译的文件头正则，见InitGeneratedFilter
+var compiledHeaders atomic.Pointer[generatedHeaders]
+
+/**
+ * 按配置编译文件头正则，启动时调用
+ * @param {*config.GeneratedFilterConfig} cfg - 配置wrapper.generated
+ * @returns {error} 正则无效时返回错误，仍使用之前编译的正则
+ */
+func InitGeneratedFilter(cfg *config.GeneratedFilterConfig) error {
+	h := &generatedHeaders{patterns: slices.Clone(cfg.HeaderPatterns)}
+	for _, pattern := range cfg.HeaderPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("wrapper.generated.headerPatterns: invalid pattern '%s': %v", pattern, err)
+		}
+		h.res = append(h.res, re)
+	}
+	compiledHeaders.Store(h)
+	return nil
+}
+
+/**
+ * 创建生成/压缩文件过滤器
+ * @param {*config.GeneratedFilterConfig} cfg - 过滤器配置
+ * @returns {*GeneratedFilter} 返回过滤器
+ * @description
+ * - 文件头正则与启动时编译的相同时直接使用，每个请求不再编译
+ * - 其他配置(如测试中的配置)现场编译，无效的正则被忽略
+ */
+func NewGeneratedFilter(cfg *config.GeneratedFilterConfig) *GeneratedFilter {
+	f := &GeneratedFilter{cfg: cfg}
+	if h := compiledHeaders.Load(); h != nil && slices.Equal(h.patterns, cfg.HeaderPatterns) {
+		f.headers = h.res
+		return f
+	}
+	for _, pattern := range cfg.HeaderPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			zap.L().Error("Invalid config: 'wrapper.generated.headerPatterns' contains invalid pattern",
+				zap.String("pattern", pattern), zap.Error(err))
+			continue
+		}
+		f.headers = append(f.headers, re)
+	}
+	return f
+}
+
+/**
+ * 判断光标所在的文件是否为生成或压缩过的代码
+ * @param {*CompletionContext} c - 补全上下文
+ * @param {*CompletionInput} in - 补全输入，检测结果记录在in.Generated中
+ * @returns {RejectCode} 配置为reject时返回GENERATED_FILE，否则返回ACCEPTED
+ * @description
+ * - flag只记录检测结果，请求按普通文件处理
+ */
+func (f *GeneratedFilter) Judge(c *CompletionContext, in *CompletionInput) RejectCode {
+	if !c.Enabled(feature_flag.Generated) {
+		return Accepted
+	}
+	decision := f.Detect(in.Processed.FileProjectPath, in.Processed.Prefix)
+	if decision == nil {
+		return Accepted
+	}
+	in.Generated = decision
+	c.Log().Info("Generated or minified file detected", zap.String("signal", decision.Signal),
+		zap.String("detail", decision.Detail), zap.String("action", decision.Action))
+	if decision.Action != GeneratedReject {
+		return Accepted
+	}
+	return GeneratedFile
+}
+
+/**
+ * 检测生成/压缩文件
+ * @param {string} filePath - 文件路径
+ * @param {string} prefix - 光标前的内容
+ * @returns {*GeneratedDecision} 返回检测结果，不是生成文件时返回nil
+ * @description
+ * - 依次检查文件名、文件头、平均行长和空白字符比例，第一个命中的依据生效
+ * - 前缀太短时不按行长和空白比例判断，避免误判刚开始编写的文件
+ * - 平均行长和空白比例都按unicode字符计算，非ASCII的代码不会因多字节字符被误判
+ * @example
+ * decision := filter.Detect("api/user.pb.go", "// Code generated by protoc-gen-go. DO NOT EDIT.\n")
+ * // decision.Signal = "file"
+ */
+func (f *GeneratedFilter) Detect(filePath, prefix string) *GeneratedDecision {
+	decide := func(signal, detail string) *GeneratedDecision {
+		return &GeneratedDecision{Signal: signal, Detail: detail, Action: f.action()}
+	}
+	name := strings.ToLower(filePath)
+	for _, pattern := range f.cfg.FilePatterns {
+		if pattern != "" && strings.HasSuffix(name, strings.ToLower(pattern)) {
+			return decide("file", pattern)
+		}
+	}
+	header := prefix
+	if len(header) > generatedHeaderBytes {
+		header = header[:generatedHeaderBytes]
+	}
+	for _, re := range f.headers {
+		if re.MatchString(header) {
+			return decide("header", re.String())
+		}
+	}
+	if len(prefix) == 0 || len(prefix) < f.cfg.MinSampleBytes {
+		return nil
+	}
+	chars := utf8.RuneCountInString(prefix)
+	lines := strings.Count(prefix, "\n") + 1
+	if avg := chars / lines; f.cfg.MaxAvgLineLength > 0 && avg > f.cfg.MaxAvgLineLength {
+		return decide("line_length", fmt.Sprintf("average line length %d", avg))
+	}
+	spaces := 0
+	for _, r := range prefix {
+		if unicode.IsSpace(r) {
+			spaces++
+		}
+	}
+	if ratio := float64(spaces) / float64(chars); ratio < f.cfg.MinWhitespaceRatio {
+		return decide("whitespace", fmt.Sprintf("whitespace ratio %.3f", ratio))
+	}
+	return nil
+}
+
+// 配置的处理方式，未知的取值按flag处理
+func (f *GeneratedFilter) action() string {
+	switch strings.ToLower(f.cfg.Action) {
+	case GeneratedReject:
+		return GeneratedReject
+	case GeneratedDegrade:
+		return GeneratedDegrade
+	}
+	return GeneratedFlag
+}
+
+// 判定为生成/压缩文件并按degrade处理，flag只标记不改变处理
+func (in *CompletionInput) generated() bool {
+	return in.Generated != nil && in.Generated.Action == GeneratedDegrade
+}
+
+/**
+ * 对判定为生成/压缩文件但继续补全的请求缩减提示词
+ * @description
+ * - 前缀只保留最后DegradeBytes字节，后缀只保留最前DegradeBytes字节，按行边界截断
+ * - 丢弃导入内容和客户端提供的上下文，并跳过代码上下文的获取
+ * - 停用词中加入换行，只补全一行(见prepareStopWords)
+ */
+func (in *CompletionInput) degradeGenerated() {
+	if !in.generated() {
+		return
+	}
+	budget := config.Wrapper.Generated.DegradeBytes
+	if budget > 0 && len(in.Processed.Prefix) > budget {
+		start := len(in.Processed.Prefix) - budget
+		for start < len(in.Processed.Prefix) && !utf8.RuneStart(in.Processed.Prefix[start]) {
+			start++
+		}
+		prefix := in.Processed.Prefix[start:]
+		if idx := strings.Index(prefix, "\n"); idx >= 0 && idx < len(prefix)-1 {
+			prefix = prefix[idx+1:]
+		}
+		in.Processed.Prefix = prefix
+	}
+	if budget > 0 && len(in.Processed.Suffix) > budget {
+		end := budget
+		for end > 0 && !utf8.RuneStart(in.Processed.Suffix[end]) {
+			end--
+		}
+		suffix := in.Processed.Suffix[:end]
+		if idx := strings.LastIndex(suffix, "\n"); idx > 0 {
+			suffix = suffix[:idx+1]
+		}
+		in.Processed.Suffix = suffix
+	}
+	in.Processed.ImportContent = ""
+	in.Processed.CodeContext = ""
+	in.Processed.Provenance = nil
+}
@@ -0,0 +1,143 @@
+package completions
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+func defaultGeneratedConfig() config.GeneratedFilterConfig {
+	return config.GeneratedFilterConfig{
+		Action:             GeneratedReject,
+		MaxAvgLineLength:   300,
+		MinWhitespaceRatio: 0.05,
+		MinSampleBytes:     1024,
+		DegradeBytes:       1024,
+		HeaderPatterns:     []string{`(?i)code generated .*do not edit`, `@generated\b`},
+		FilePatterns:       []string{".min.js", ".bundle.js", "_pb.go", ".pb.go"},
+	}
+}
+
+func readFixture(t *testing.T, name string) string {
+	data, err := os.ReadFile("testdata/generated/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// to test detecting generated and minified files
+// go test ./pkg/completions/ -v -run Test_GeneratedFilter
+func Test_GeneratedFilter(t *testing.T) {
+	cfg := defaultGeneratedConfig()
+	f := NewGeneratedFilter(&cfg)
+	tests := []struct {
+		name   string
+		path   string
+		prefix string
+		signal string
+	}{
+		{"minified js", "static/app.js", readFixture(t, "minified.js"), "line_length"},
+		{"protoc header", "api/user/v1/user.go", readFixture(t, "user.pb.go.txt"), "header"},
+		{"protoc file name", "api/user/v1/user.pb.go", "package userv1\n", "file"},
+		{"bundle file name", "dist/vendor.bundle.js", "", "file"},
+		{"normal long lines", "src/invoice.js", readFixture(t, "normal_long.js"), ""},
+		{"short file", "src/a.js", "const a=1;", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := f.Detect(tt.path, tt.prefix)
+			signal := ""
+			if decision != nil {
+				signal = decision.Signal
+			}
+			if signal != tt.signal {
+				t.Errorf("expected signal %q, got %q", tt.signal, signal)
+			}
+		})
+	}
+
+	// low whitespace ratio without long lines
+	dense := strings.Repeat("a=b+c*d/e;f=g(h,i);j=k[l]-m%n;\n", 100)
+	if decision := f.Detect("src/dense.js", dense); decision == nil || decision.Signal != "whitespace" {
+		t.Errorf("expected whitespace signal, got %+v", decision)
+	}
+	// 多字节字符按字符计算，中文注释的代码不会被误判
+	chinese := strings.Repeat("//计算订单的总金额包含运费和优惠券的抵扣以及会员折扣然后四舍五入到分\nconst total = sum(items) -coupon;\n", 40)
+	if decision := f.Detect("src/order.js", chinese); decision != nil {
+		t.Errorf("expected a normal file with chinese comments, got %+v", decision)
+	}
+
+	// 与启动时编译的文件头正则相同时复用
+	if err := InitGeneratedFilter(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if NewGeneratedFilter(&cfg).headers[0] != compiledHeaders.Load().res[0] {
+		t.Error("expected the header patterns compiled at startup reused")
+	}
+	invalid := cfg
+	invalid.HeaderPatterns = []string{"("}
+	if err := InitGeneratedFilter(&invalid); err == nil {
+		t.Error("expected an invalid header pattern rejected")
+	}
+}
+
+// to test rejecting or degrading completions in generated files
+// go test ./pkg/completions/ -v -run Test_GeneratedAction
+func Test_GeneratedAction(t *testing.T) {
+	hits, restore := setupContextServer(`{"data":{"list":[{"filePath":"a.js","content":"export const a = 1;"}]}}`)
+	defer restore()
+	config.Wrapper.Generated = defaultGeneratedConfig()
+	minified := readFixture(t, "minified.js")
+
+	in := newContextInput(false)
+	in.Prompts.Prefix = minified
+	_, rsp := preprocess(in)
+	if rsp == nil || rsp.Error != string(GeneratedFile) {
+		t.Fatalf("expected GENERATED_FILE rejection, got %+v", rsp)
+	}
+	if rsp.Verbose == nil || rsp.Verbose.Input["generated"] != in.Generated {
+		t.Error("expected the decision in verbose")
+	}
+
+	config.Wrapper.Generated.Action = GeneratedDegrade
+	in = newContextInput(false)
+	in.Prompts.Prefix = minified
+	if _, rsp := preprocess(in); rsp != nil {
+		t.Fatalf("unexpected rejection: %s", rsp.Error)
+	}
+	if in.Generated == nil || in.Generated.Action != GeneratedDegrade {
+		t.Fatalf("expected a degrade decision, got %+v", in.Generated)
+	}
+	if len(in.Processed.Prefix) > config.Wrapper.Generated.DegradeBytes || !strings.HasSuffix(minified, in.Processed.Prefix) {
+		t.Errorf("expected the prefix cut to the last %d bytes, got %d", config.Wrapper.Generated.DegradeBytes, len(in.Processed.Prefix))
+	}
+	if *hits != 0 || in.ContextSkip != "generated file" || in.Processed.ImportContent != "" {
+		t.Errorf("expected no context, got %d searches, skip %q", *hits, in.ContextSkip)
+	}
+	stop := NewCompletionHandler(&scriptedLLM{}).prepareStopWords(in)
+	if stop[len(stop)-1] != "\n" {
+		t.Errorf("expected single line stop, got %q", stop)
+	}
+
+	// flag只标记，照常获取上下文和补全
+	config.Wrapper.Generated.Action = GeneratedFlag
+	*hits = 0
+	in = newContextInput(false)
+	in.Prompts.Prefix = minified
+	if _, rsp := preprocess(in); rsp != nil {
+		t.Fatalf("unexpected rejection: %s", rsp.Error)
+	}
+	if in.Generated == nil || in.Generated.Action != GeneratedFlag || in.Processed.Prefix != minified || in.ContextSkip == "generated file" {
+		t.Errorf("expected the file flagged and served as usual, got %+v, skip %q", in.Generated, in.ContextSkip)
+	}
+
+	// normal files are not affected
+	in = newContextInput(false)
+	in.Prompts.Prefix = readFixture(t, "normal_long.js")
+	if _, rsp := preprocess(in); rsp != nil || in.Generated != nil {
+		t.Errorf("expected a normal completion, got %+v", in.Generated)
+	}
+}
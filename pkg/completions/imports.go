@@ -0,0 +1,294 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/model"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 导入语句的风格
+const (
+	ImportStyleGo     = "go"
+	ImportStylePython = "python"
+	ImportStyleES     = "es" // js/ts的ES模块
+)
+
+/**
+ * 语言的导入语法和内置的已知名称
+ * @description
+ * - Style: 导入语句的风格，见ImportStyle*
+ * - Symbols: 补全中可能用到的名称和导入的模块，只包含常用的标准库，值的含义见config.ImportsConfig
+ * - Evidence: 能看到文件导入区的证据(如go的package子句、已有的导入语句)，没有时不建议，避免前缀不含文件开头时误判
+ */
+type ImportSyntax struct {
+	Style    string
+	Symbols  map[string]string
+	Evidence *regexp.Regexp
+}
+
+var goImports = &ImportSyntax{
+	Style:    ImportStyleGo,
+	Evidence: regexp.MustCompile(`(?m)^\s*(?:package|import)\b`),
+	Symbols: map[string]string{
+		"atomic": "sync/atomic", "base64": "encoding/base64", "bufio": "bufio", "bytes": "bytes",
+		"context": "context", "errors": "errors", "exec": "os/exec", "filepath": "path/filepath",
+		"fmt": "fmt", "hex": "encoding/hex", "http": "net/http", "io": "io", "json": "encoding/json",
+		"log": "log", "maps": "maps", "math": "math", "os": "os", "reflect": "reflect", "regexp": "regexp",
+		"sha256": "crypto/sha256", "slices": "slices", "sort": "sort", "strconv": "strconv",
+		"strings": "strings", "sync": "sync", "time": "time", "unicode": "unicode", "utf8": "unicode/utf8",
+	},
+}
+
+var pythonImports = &ImportSyntax{
+	Style:    ImportStylePython,
+	Evidence: regexp.MustCompile(`(?m)^\s*(?:import|from)\s+\w`),
+	Symbols: map[string]string{
+		"argparse": "argparse", "asyncio": "asyncio", "base64": "base64", "collections": "collections",
+		"copy": "copy", "csv": "csv", "functools": "functools", "glob": "glob", "hashlib": "hashlib",
+		"itertools": "itertools", "json": "json", "logging": "logging", "math": "math", "os": "os",
+		"pickle": "pickle", "random": "random", "re": "re", "shutil": "shutil", "subprocess": "subprocess",
+		"sys": "sys", "tempfile": "tempfile", "threading": "threading", "time": "time", "traceback": "traceback",
+		"uuid":    "uuid",
+		"Counter": "collections.Counter", "OrderedDict": "collections.OrderedDict", "defaultdict": "collections.defaultdict",
+		"namedtuple": "collections.namedtuple", "dataclass": "dataclasses.dataclass", "partial": "functools.partial",
+		"Path": "pathlib.Path", "Any": "typing.Any", "Dict": "typing.Dict", "List": "typing.List",
+		"Optional": "typing.Optional", "Tuple": "typing.Tuple", "Union": "typing.Union",
+	},
+}
+
+var esImports = &ImportSyntax{
+	Style:    ImportStyleES,
+	Evidence: regexp.MustCompile(`(?m)^\s*import\b|\brequire\(`),
+	Symbols: map[string]string{
+		"crypto": "crypto", "fs": "fs", "http": "http", "https": "https", "os": "os", "readline": "readline",
+		"util": "util", "zlib": "zlib", "EventEmitter": "events", "promisify": "util",
+	},
+}
+
+// python的通配导入，文件中有通配导入时无法判断名称是否已导入
+var pythonWildcardImport = regexp.MustCompile(`(?m)^\s*from\s+\S+\s+import\s+\*`)
+
+// 已有的ES导入语句，用于沿用引号和分号的风格
+var esImportLine = regexp.MustCompile(`(?m)^\s*import\b.*(['"])\s*(;?)\s*$`)
+
+/**
+ * 语言的已知名称，配置按语言补充或覆盖内置的名称
+ * @param {*LanguageProfile} profile - 语言配置
+ * @returns {map[string]string} 返回名称和导入的模块，值为空的名称已移除
+ */
+func importSymbols(profile *LanguageProfile) map[string]string {
+	symbols := make(map[string]string, len(profile.Imports.Symbols))
+	for name, module := range profile.Imports.Symbols {
+		symbols[name] = module
+	}
+	for language, extra := range config.Wrapper.Imports.Symbols {
+		if profileOf(language).ID != profile.ID {
+			continue
+		}
+		for name, module := range extra {
+			if module == "" {
+				delete(symbols, name)
+			} else {
+				symbols[name] = module
+			}
+		}
+	}
+	return symbols
+}
+
+/**
+ * 建议补全需要的导入语句
+ * @param {string} language - 光标处的语言
+ * @param {string} completion - 后置处理后的补全内容
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @param {string} importContent - 客户端上报的导入语句
+ * @returns {[]string} 返回按名称排序的导入语句，没有时返回nil
+ * @description
+ * - 只建议已知的名称，且补全中该名称都作为限定符或被调用(后面紧接着"."、"("或"[")，不在"."之后
+ * - 补全中该名称被声明或赋值(如strings := ...)时视为局部变量，不建议
+ * - 前缀、后缀或导入语句中出现了该名称(或go的导入路径)时视为已导入或是局部变量，不建议
+ * - 看不到文件的导入区(见ImportSyntax.Evidence)或有python通配导入时不建议
+ * - 字符串和行注释中的名称不计
+ * @example
+ * suggestImports("go", "var sb strings.Builder", "package main\n\nfunc f() {\n\t", "\n}\n", "")
+ * // []string{`import "strings"`}
+ */
+func suggestImports(language, completion, prefix, suffix, importContent string) []string {
+	profile := profileOf(language)
+	syntax := profile.Imports
+	if syntax == nil || completion == "" {
+		return nil
+	}
+	visible := importContent + "\n" + prefix
+	if !syntax.Evidence.MatchString(visible) ||
+		syntax.Style == ImportStylePython && pythonWildcardImport.MatchString(visible) {
+		return nil
+	}
+	symbols := importSymbols(profile)
+	quotes, lineComment := quotesOf(language), profile.Comment.Line
+	used := referencedSymbols(maskCode(completion, quotes, lineComment), symbols)
+	if len(used) == 0 {
+		return nil
+	}
+	known := identifiersOf(visible + "\n" + suffix)
+	var imports []string
+	for _, name := range used {
+		module := symbols[name]
+		if known[name] || syntax.Style == ImportStyleGo && strings.Contains(visible, `"`+module+`"`) {
+			continue
+		}
+		imports = append(imports, importStatement(syntax.Style, name, module, visible))
+	}
+	return imports
+}
+
+// 把字符串和行注释替换为空格，保持位置不变
+func maskCode(code, quotes, lineComment string) string {
+	masked := []byte(strings.Repeat(" ", len(code)))
+	scanCode(code, quotes, lineComment, func(i int, ch byte) bool {
+		masked[i] = ch
+		return true
+	})
+	return string(masked)
+}
+
+// 遍历代码中的标识符，跳过"."之后的成员名
+func eachIdentifier(code string, visit func(start, end int)) {
+	for i := 0; i < len(code); {
+		if !isWordByte(code[i]) || ('0' <= code[i] && code[i] <= '9') {
+			i++
+			continue
+		}
+		start := i
+		for i < len(code) && isWordByte(code[i]) {
+			i++
+		}
+		if start > 0 && code[start-1] == '.' {
+			continue
+		}
+		visit(start, i)
+	}
+}
+
+// 代码中出现的标识符(不含成员名)
+func identifiersOf(code string) map[string]bool {
+	idents := make(map[string]bool)
+	eachIdentifier(code, func(start, end int) {
+		idents[code[start:end]] = true
+	})
+	return idents
+}
+
+/**
+ * 补全中作为限定符或被调用的已知名称
+ * @param {string} code - 去掉了字符串和注释的补全内容
+ * @param {map[string]string} symbols - 已知的名称
+ * @returns {[]string} 返回按名称排序的名称
+ * @description
+ * - 名称后面紧接着"."、"("或"["时为使用；其它出现(如声明、赋值、作为参数)说明是局部变量，该名称不返回
+ */
+func referencedSymbols(code string, symbols map[string]string) []string {
+	uses := make(map[string]bool)
+	locals := make(map[string]bool)
+	eachIdentifier(code, func(start, end int) {
+		name := code[start:end]
+		if _, ok := symbols[name]; !ok {
+			return
+		}
+		if end < len(code) && strings.IndexByte(".([", code[end]) >= 0 && !declaredAt(code, start) {
+			uses[name] = true
+		} else {
+			locals[name] = true
+		}
+	})
+	var names []string
+	for name := range uses {
+		if !locals[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 声明名称的关键字，如def json(...)、function fs(...)
+var declareKeywords = map[string]bool{
+	"def": true, "class": true, "func": true, "function": true, "var": true, "let": true, "const": true, "type": true,
+}
+
+// 名称之前是否是声明关键字
+func declaredAt(code string, start int) bool {
+	before := strings.TrimRight(code[:start], " \t")
+	n := len(before)
+	for n > 0 && isWordByte(before[n-1]) {
+		n--
+	}
+	return declareKeywords[before[n:]]
+}
+
+/**
+ * 按语言的惯例格式化导入语句
+ * @param {string} style - 导入语句的风格
+ * @param {string} name - 补全中使用的名称
+ * @param {string} module - 导入的模块，含义见config.ImportsConfig
+ * @param {string} visible - 文件中可见的导入区，js/ts沿用已有导入语句的引号和分号
+ * @returns {string} 返回导入语句
+ * @example
+ * importStatement("go", "filepath", "path/filepath", "")               // `import "path/filepath"`
+ * importStatement("python", "defaultdict", "collections.defaultdict", "") // "from collections import defaultdict"
+ * importStatement("python", "np", "numpy", "")                          // "import numpy as np"
+ * importStatement("es", "EventEmitter", "events", "import fs from 'fs';") // "import { EventEmitter } from 'events';"
+ */
+func importStatement(style, name, module, visible string) string {
+	switch style {
+	case ImportStyleGo:
+		if module[strings.LastIndexByte(module, '/')+1:] == name {
+			return `import "` + module + `"`
+		}
+		return "import " + name + ` "` + module + `"`
+	case ImportStylePython:
+		dot := strings.LastIndexByte(module, '.')
+		switch {
+		case module == name:
+			return "import " + name
+		case dot >= 0 && module[dot+1:] == name:
+			return "from " + module[:dot] + " import " + name
+		}
+		return "import " + module + " as " + name
+	}
+	quote, semicolon := `"`, ""
+	if m := esImportLine.FindStringSubmatch(visible); m != nil {
+		quote, semicolon = m[1], m[2]
+	}
+	if strings.TrimPrefix(module, "node:") == name {
+		return "import * as " + name + " from " + quote + module + quote + semicolon
+	}
+	return "import { " + name + " } from " + quote + module + quote + semicolon
+}
+
+/**
+ * 补全引用了文件中未导入的包时，在响应中建议导入语句，不修改补全内容
+ * @param {*feature_flag.Evaluator} flags - 请求的功能开关
+ * @param {*CompletionResponse} rsp - 补全响应
+ * @description
+ * - 开关imports关闭或请求的disable_imports为true时跳过
+ * - 导入区取客户端原始的前缀和导入语句，不受截断和缩减的影响
+ */
+func (in *CompletionInput) SuggestImports(flags *feature_flag.Evaluator, rsp *CompletionResponse) {
+	if !flags.Enabled(feature_flag.Imports) || in.DisableImports || rsp == nil ||
+		rsp.Status != model.StatusSuccess || len(rsp.Choices) == 0 {
+		return
+	}
+	prefix, suffix, importContent := in.Prompt, "", in.ImportContent
+	if in.Prompts != nil {
+		prefix, suffix = in.Prompts.Prefix, in.Prompts.Suffix
+		if in.Prompts.ImportContent != "" {
+			importContent = in.Prompts.ImportContent
+		}
+	}
+	rsp.SuggestedImports = suggestImports(in.EffectiveLanguage(), rsp.Choices[0].Text, prefix, suffix, importContent)
+}
@@ -0,0 +1,96 @@
+package completions
+
+import (
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test missing imports are suggested for go standard packages, python from-imports and node modules
+// go test ./pkg/completions/ -v -run Test_SuggestImports
+func Test_SuggestImports(t *testing.T) {
+	tests := []struct {
+		name          string
+		language      string
+		completion    string
+		prefix        string
+		suffix        string
+		importContent string
+		expected      string
+	}{
+		{"go standard packages", "go", "var sb strings.Builder\n\tsb.WriteString(strconv.Itoa(n))\n\tdata, _ := json.Marshal(sb.String())",
+			"package main\n\nimport \"fmt\"\n\nfunc f(n int) {\n\t", "\n\tfmt.Println(data)\n}\n", "", `import "encoding/json",import "strconv",import "strings"`},
+		{"go imported package", "go", "strings.TrimSpace(s)", "package main\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n\nfunc f(s string) {\n\t", "\n}\n", "", ""},
+		{"go imported by client", "go", "filepath.Join(a, b)", "func f(a, b string) string {\n\treturn ", "\n}\n", "import \"path/filepath\"", ""},
+		{"go without package clause", "go", "strings.TrimSpace(s)", "\treturn ", "\n}\n", "", ""},
+		{"python from-import", "python", "counts = defaultdict(int)\n    return json.dumps(counts)", "import os\n\ndef f(items):\n    ", "\n", "", "from collections import defaultdict,import json"},
+		{"python existing from-import", "python", "counts = defaultdict(int)", "from collections import defaultdict\n\ndef f():\n    ", "\n", "", ""},
+		{"python wildcard import", "python", "counts = defaultdict(int)", "from collections import *\n\ndef f():\n    ", "\n", "", ""},
+		{"typescript ambiguous use", "typescript", "const data = fs.readFileSync(p)\nclass Bus extends EventEmitter {}\nconst emitter = new EventEmitter()",
+			"import path from 'path';\n\nfunction load(p: string) {\n  ", "\n}\n", "", "import * as fs from 'fs';"},
+		{"typescript named import", "typescript", "const data = fs.readFileSync(p)\nconst emitter = new EventEmitter()",
+			"import path from 'path';\n\nfunction load(p: string) {\n  ", "\n}\n", "", "import { EventEmitter } from 'events';,import * as fs from 'fs';"},
+		{"names in strings and comments", "go", "fmt.Println(\"strings.Builder\") // uses time.Now", "package main\n\nimport \"fmt\"\n\nfunc f() {\n\t", "\n}\n", "", ""},
+		{"unsupported language", "ruby", "JSON.parse(s)", "require 'set'\n", "\n", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := suggestImports(tt.language, tt.completion, tt.prefix, tt.suffix, tt.importContent)
+			if strings.Join(got, ",") != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// to test a qualifier that is a local variable is not taken as a package
+// go test ./pkg/completions/ -v -run Test_SuggestImportsLocalVariable
+func Test_SuggestImportsLocalVariable(t *testing.T) {
+	tests := []struct {
+		name       string
+		language   string
+		completion string
+		prefix     string
+	}{
+		{"declared in the prefix", "go", "strings.Len()", "package main\n\nfunc f() {\n\tstrings := newList()\n\t"},
+		{"parameter in the prefix", "python", "json.get('a')", "import os\n\ndef f(json):\n    "},
+		{"declared in the completion", "go", "strings := []string{a, b}\n\treturn strings.Len()", "package main\n\nfunc f() {\n\t"},
+		{"loop variable in the completion", "python", "for json in items:\n        print(json.get('a'))", "import os\n\ndef f(items):\n    "},
+		{"member of another value", "go", "return cfg.strings.Len()", "package main\n\nfunc f() {\n\t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestImports(tt.language, tt.completion, tt.prefix, "\n", ""); got != nil {
+				t.Errorf("expected no suggestion, got %q", got)
+			}
+		})
+	}
+}
+
+// to test configured symbols extend the built-in names, and the suggestion is skippable per request
+// go test ./pkg/completions/ -v -run Test_SuggestImportsConfig
+func Test_SuggestImportsConfig(t *testing.T) {
+	old := config.Wrapper.Imports
+	defer func() { config.Wrapper.Imports = old }()
+	config.Wrapper.Imports.Symbols = map[string]map[string]string{"py": {"np": "numpy", "json": ""}}
+
+	if got := suggestImports("python", "x = np.array(json.loads(s))", "import os\n", "\n", ""); strings.Join(got, ",") != "import numpy as np" {
+		t.Errorf("expected the configured numpy import only, got %q", got)
+	}
+
+	in := &CompletionInput{CompletionRequest: CompletionRequest{LanguageID: "python",
+		Prompts: &PromptOptions{Prefix: "import os\n\ndef f():\n    ", Suffix: "\n"}}}
+	rsp := &CompletionResponse{Status: model.StatusSuccess, Choices: []CompletionChoice{{Text: "return np.zeros(3)"}}}
+	in.SuggestImports(nil, rsp)
+	if strings.Join(rsp.SuggestedImports, ",") != "import numpy as np" || rsp.Choices[0].Text != "return np.zeros(3)" {
+		t.Errorf("expected the suggestion without changing the completion, got %q %q", rsp.SuggestedImports, rsp.Choices[0].Text)
+	}
+	in.DisableImports = true
+	rsp.SuggestedImports = nil
+	in.SuggestImports(nil, rsp)
+	if rsp.SuggestedImports != nil {
+		t.Errorf("expected no suggestion when disabled by the request, got %q", rsp.SuggestedImports)
+	}
+}
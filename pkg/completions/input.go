@@ -3,9 +3,21 @@ package completions
 import (
 	"code-completion/pkg/codebase_context"
 	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
 	"net/http"
 	"time"
+
+	"go.uber.org/zap"
+)
+
+// 代码上下文的获取结果
+const (
+	ContextProvided = "provided" // 客户端已提供上下文，无需获取
+	ContextSkipped  = "skipped"  // 跳过获取(请求关闭了上下文，或缺少获取上下文的必要信息)
+	ContextEmpty    = "empty"    // 获取了，但各检索都没有结果
+	ContextFound    = "found"    // 获取到上下文
 )
 
 /**
@@ -24,20 +36,38 @@ import (
  * response := input.Preprocess(ctx)
  */
 type CompletionInput struct {
-	CompletionRequest               //原始请求中的BODY
-	Headers           http.Header   //原始请求中的头部
-	Processed         PromptOptions //加工过的提示词
+	CompletionRequest                     //原始请求中的BODY
+	Headers           http.Header         //原始请求中的头部
+	Processed         PromptOptions       //加工过的提示词
+	Reductions        []FieldReduction    //超大辅助字段的缩减记录
+	Region            *SFCRegion          //单文件组件中光标所在的区块
+	Shape             *CompletionShape    //识别出的微补全，为nil表示普通补全
+	Generated         *GeneratedDecision  //生成/压缩文件的检测结果，为nil表示普通文件
+	TestFile          *TestFileDecision   //测试文件的检测结果，为nil表示非测试文件
+	Literal           *StringLiteral      //光标所在的字符串字面量，为nil表示不在字符串中
+	Arguments         *model.ArgumentList //光标所在调用的参数列表，为nil表示不在调用中
+	Empty             *EmptyResult        //补全为空或被过滤器拒绝的原因，见AdviseRetry
+	ContextOutcome    string              //代码上下文的获取结果
+	ContextSkip       string              //跳过获取代码上下文的原因
+	Style             *model.StyleProfile //推断的代码风格，没有明确偏好时为nil
+	Budget            *model.BudgetReport //提示词预算报告，只在请求verbose时记录
+	Replay            bool                //运维重放的请求，不读写面向客户端的存储(负结果缓存、采纳反馈、风格档案)
+	Probe             bool                //定时自测探针的请求，与重放一样不读写面向客户端的存储，不计入补全请求的指标
+	Preview           bool                //提示词预览的请求，只组装提示词不调用模型，与重放一样不读写面向客户端的存储
+	ContextMode       string              //代码上下文的使用方式(同步获取、后台获取中、使用缓存)，没有获取时为空
+	ScoreVariant      string              //计算隐藏分使用的权重变体，没有计算隐藏分时为空
+	Fingerprint       string              //提示词指纹，关闭时为空，见ComputeFingerprint
+	Fallback          *LanguageFallback   //没有语言配置的语言回退到的语言族，为nil表示不回退
+	Diff              *DiffView           //diff视图的还原结果，为nil表示不是diff
+	FastPath          bool                //极小请求，走快速路径，见detectFastPath
+	Feedback          *AcceptanceFeedback //本次请求对上一次补全的采纳反馈，没有有效反馈时为nil
+	tuned             *tunedScore         //参与阈值调整的语言和隐藏分，返回补全后随采纳记录保存，见TrackServed
 }
 
-/**
- * 代码上下文客户端实例
- * @description
- * - 全局单例，用于获取代码上下文信息
- * - 在GetContext方法中延迟初始化
- * - 提供代码库上下文查询功能
- * - 用于增强补全请求的上下文信息
- */
-var contextClient *codebase_context.ContextClient
+// 服务自己发起的请求(运维重放、自测探针、提示词预览)，不读写面向客户端的存储
+func (in *CompletionInput) internal() bool {
+	return in.Replay || in.Probe || in.Preview
+}
 
 /**
  * 处理补全请求
@@ -45,10 +75,21 @@ var contextClient *codebase_context.ContextClient
  * @returns {*CompletionResponse} 返回补全响应对象，如果预处理失败则返回错误响应
  * @description
  * - 执行补全请求的预处理流程
- * - 首先通过过滤器链处理补全拒绝规则
- * - 如果拒绝规则匹配，返回拒绝响应
- * - 解析请求参数获取提示词
- * - 获取代码上下文信息
+ * - 首先解析请求参数获取提示词，定位单文件组件中光标所在的区块
+ * - 处理上一次补全的采纳反馈，部分采纳时按采纳比例给出previous_label
+ * - 空白提示词直接返回空补全，手动触发且有上下文时只用上下文补全
+ * - 相同的自动触发请求最近调用模型的结果为空的，直接返回空补全(负结果缓存)
+ * - 没有语言配置的语言按扩展名和内容回退到语言族，见resolveFallback
+ * - 识别测试文件，测试文件使用单独的阈值、停用词、输出长度，并检索被测源文件的定义
+ * - 通过过滤器链处理补全拒绝规则
+ * - 如果拒绝规则匹配，返回拒绝响应，Verbose中记录生成文件的检测结果
+ * - 自动触发时光标行只缺闭合符号的，返回本地补全的成功响应(local-closer)
+ * - 识别标识符、导入路径等微补全
+ * - 光标在字符串中时，补全限制在字符串(或插值表达式)内
+ * - 光标在调用的参数列表中时，后缀截断保留整个参数列表，补全中重复的参数被裁剪
+ * - 推断代码风格，并累计到客户端的风格档案
+ * - 获取代码上下文信息，区块之外的文件内容追加到上下文
+ * - 极小请求(单行、不获取上下文、提示词很短)走快速路径，不调用上下文客户端，见detectFastPath
  * - 是补全处理的第一步
  * @throws
  * - 如果过滤器链处理失败，返回拒绝响应
@@ -61,15 +102,60 @@ var contextClient *codebase_context.ContextClient
  * }
  */
 func (in *CompletionInput) Preprocess(c *CompletionContext) *CompletionResponse {
-	// 0. 补全拒绝规则链处理
-	err := NewFilterChain(config.Wrapper).Handle(in)
+	// 0. 解析请求参数，过滤器依赖解析后的提示词和区块语言
+	in.GetPrompts()
+	// 0.0 没有语言配置的语言回退到语言族，隐藏分、停用词和后置处理按语言族处理
+	in.resolveFallback(c)
+	// 0.0.1 处理上一次补全的(部分)采纳反馈，隐藏分使用处理后的previous_label
+	in.applyAcceptance(c)
+	// 0.0.2 识别极小请求，走快速路径
+	in.FastPath = in.detectFastPath(c)
+	c.Perf.FastPath = in.FastPath
+	// 0.1 空白提示词(如刚新建的文件)不调用模型，手动触发且有上下文时只用上下文补全
+	if rsp := in.handleBlankPrompt(c); rsp != nil {
+		return rsp
+	}
+	// 0.1.1 最近为空的相同请求直接返回空补全，避免插件立即重试
+	if rsp := in.recallEmpty(c); rsp != nil {
+		return rsp
+	}
+	// 0.2 识别测试文件，隐藏分过滤器按测试文件的阈值判断
+	in.detectTestFile(c)
+	// 1. 补全拒绝规则链处理
+	err := NewFilterChain(config.Wrapper).Handle(c, in)
 	if err != nil {
-		return CancelRequest(in.CompletionID, in.Model, c.Perf, model.StatusRejected, err)
+		rsp := CancelRequest(in.CompletionID, in.Model, c.Perf, model.StatusRejected, err)
+		in.AttachVerbose(rsp)
+		return rsp
+	}
+	// 1.1 光标行只缺闭合符号的，直接在本地补全，不调用模型
+	if rsp := in.serveLocalCloser(c); rsp != nil {
+		return rsp
+	}
+	// 1.2 缩减超大的辅助字段，生成/压缩文件只保留光标附近的内容
+	in.ReduceOversized()
+	in.degradeGenerated()
+	// 1.3 识别微补全，微补全不做语义检索，并在适配模型参数时收紧停用词和输出长度
+	if c.Enabled(feature_flag.Shape) {
+		in.Shape = detectShape(in.EffectiveLanguage(), in.Processed.Prefix, in.Processed.Suffix)
+	}
+	if in.Shape != nil {
+		c.Log().Debug("Shape micro-completion", zap.String("shape", in.Shape.Shape),
+			zap.String("linePrefix", in.Shape.LinePrefix))
+	}
+	// 1.3.1 光标在字符串中时补全不越过闭合引号，插值中按表达式补全
+	in.detectLiteral(c)
+	// 1.3.2 光标在调用的参数列表中时，记录已有的参数，后缀截断不切断参数列表
+	in.detectArguments(c)
+	// 1.4 学习客户端的代码风格，用于缩进和引号风格规范化
+	in.observeStyle(c)
+	// 2. 获取上下文信息，快速路径不获取
+	if in.FastPath {
+		in.skipFastPathContext(c)
+		return nil
 	}
-	// 1. 解析请求参数
-	in.GetPrompts()
-	// 2. 获取上下文信息
 	in.GetContext(c)
+	in.joinRegionContext()
 	return nil
 }
 
@@ -78,29 +164,150 @@ func (in *CompletionInput) Preprocess(c *CompletionContext) *CompletionResponse
  * @param {*CompletionContext} c - 补全上下文，包含请求上下文和性能统计信息
  * @description
  * - 如果代码上下文已存在，直接返回
- * - 延迟初始化上下文客户端
+ * - 请求关闭了上下文(disable_context)或生成文件降级处理时跳过获取，耗时记为0
+ * - 使用补全上下文中注入的上下文客户端，没有注入时跳过获取
+ * - 微补全不做语义检索，只做定义和关系检索
  * - 调用上下文客户端获取代码上下文
- * - 记录获取上下文的耗时
+ * - 记录获取上下文的耗时(只计检索本身，不含之前的预处理)，以及获取结果(各检索都为空的时延是浪费的)
+ * - 测试文件同时检索被测源文件的定义(可配置关闭)，排在上下文的最前面
+ * - 请求verbose时，预算报告中记录检索耗时、检索到的字节数和各检索去重后贡献的字节数
+ * - 开启渐进式上下文时不等待检索，见progressiveContext
  * - 用于增强补全请求的上下文信息
  */
 func (in *CompletionInput) GetContext(c *CompletionContext) {
 	if in.Processed.CodeContext != "" {
+		in.ContextOutcome = ContextProvided
+		metrics.IncrementContextFetches(in.ContextOutcome)
 		return
 	}
-	if contextClient == nil {
-		contextClient = codebase_context.NewContextClient()
+	reason := in.contextSkipReason()
+	if reason == "" && c.ContextClient == nil {
+		reason = "no context client"
+	}
+	if reason != "" {
+		in.ContextOutcome = ContextSkipped
+		in.ContextSkip = reason
+		c.Perf.ContextDuration = 0
+		metrics.IncrementContextFetches(in.ContextOutcome)
+		return
+	}
+	sourcePath := ""
+	if in.TestFile != nil && !config.Wrapper.TestFile.DisableSourceContext {
+		sourcePath = in.TestFile.Source
+	}
+	if c.Enabled(feature_flag.Progressive) && c.Prefetch.enabled() {
+		in.progressiveContext(c, sourcePath)
+		return
 	}
-	in.Processed.CodeContext = contextClient.GetContext(
+	in.ContextMode = ContextModeUsed
+	start := time.Now()
+	in.Processed.CodeContext, in.Processed.Provenance = c.ContextClient.GetContext(
 		c.Ctx,
 		in.ClientID,
 		in.Processed.ProjectPath,
 		in.Processed.FileProjectPath,
+		sourcePath,
 		in.Processed.Prefix,
 		in.Processed.Suffix,
 		in.Processed.ImportContent,
 		in.Headers,
+		in.Shape != nil,
 	)
-	c.Perf.ContextDuration = time.Since(c.Perf.ReceiveTime).Milliseconds()
+	in.ContextOutcome = ContextFound
+	if in.Processed.CodeContext == "" {
+		in.ContextOutcome = ContextEmpty
+	}
+	metrics.IncrementContextFetches(in.ContextOutcome)
+	contributed := codebase_context.Contributed(in.Processed.Provenance)
+	if in.TestFile != nil {
+		in.TestFile.SourceBytes = contributed[codebase_context.ProviderSourceUnderTest]
+	}
+	c.Perf.ContextDuration = time.Since(start).Milliseconds()
+	metrics.RecordContextFetchDuration(in.ContextOutcome, c.Perf.ContextDuration)
+	if in.Budget != nil {
+		in.Budget.Latency.ContextUs = time.Since(start).Microseconds()
+		in.Budget.Sections[SectionCodebaseContext].Bytes = len(in.Processed.CodeContext)
+		in.Budget.Providers = contributed
+	}
+}
+
+// 跳过获取代码上下文的原因，返回空字符串表示需要获取
+func (in *CompletionInput) contextSkipReason() string {
+	switch {
+	case in.DisableContext:
+		return "disabled by request"
+	case in.generated():
+		return "generated file"
+	}
+	return ""
+}
+
+// 将预处理过程的记录(缩减的字段、语言回退、识别的微补全、生成文件和测试文件检测、光标所在的字符串和参数列表、推断的代码风格、隐藏分权重变体、跳过、为空或来自缓存的上下文)附加到响应的Verbose中，快速路径只在请求verbose时附加
+func (in *CompletionInput) AttachVerbose(rsp *CompletionResponse) {
+	in.AttachReductions(rsp)
+	if rsp == nil || in.FastPath && !in.Verbose {
+		return
+	}
+	if in.Style != nil && in.Verbose {
+		if rsp.Verbose == nil {
+			rsp.Verbose = &model.CompletionVerbose{}
+		}
+		rsp.Verbose.Style = in.Style
+	}
+	if in.Fallback != nil {
+		verboseInput(rsp)["languageFallback"] = in.Fallback
+	}
+	if in.Shape != nil {
+		verboseInput(rsp)["shape"] = in.Shape
+	}
+	if in.Generated != nil {
+		verboseInput(rsp)["generated"] = in.Generated
+	}
+	if in.TestFile != nil {
+		verboseInput(rsp)["testFile"] = in.TestFile
+	}
+	if in.Literal != nil {
+		verboseInput(rsp)["literal"] = in.Literal
+	}
+	if in.Arguments != nil {
+		verboseInput(rsp)["arguments"] = in.Arguments
+	}
+	if in.Diff != nil {
+		verboseInput(rsp)["diff"] = in.Diff
+	}
+	if in.ScoreVariant != "" && in.ScoreVariant != NoScoreVariant {
+		verboseInput(rsp)["scoreVariant"] = in.ScoreVariant
+	}
+	if in.ContextOutcome != ContextSkipped && in.ContextOutcome != ContextEmpty && in.ContextMode != ContextModeCacheHit {
+		return
+	}
+	note := map[string]interface{}{"outcome": in.ContextOutcome}
+	if in.ContextSkip != "" {
+		note["skip"] = in.ContextSkip
+	}
+	if in.ContextMode != "" {
+		note["mode"] = in.ContextMode
+	}
+	verboseInput(rsp)["context"] = note
+}
+
+// 将该客户端项目的检索服务状态附加到响应中，只读取最近检索的结果，不额外发起检索
+func (in *CompletionInput) AttachContextStatus(client *codebase_context.ContextClient, rsp *CompletionResponse) {
+	if rsp == nil {
+		return
+	}
+	rsp.ContextStatus = client.Health(in.ClientID, in.Processed.ProjectPath)
+}
+
+// 响应Verbose中的输入记录，不存在时创建
+func verboseInput(rsp *CompletionResponse) map[string]interface{} {
+	if rsp.Verbose == nil {
+		rsp.Verbose = &model.CompletionVerbose{}
+	}
+	if rsp.Verbose.Input == nil {
+		rsp.Verbose.Input = make(map[string]interface{})
+	}
+	return rsp.Verbose.Input
 }
 
 /**
@@ -111,6 +318,8 @@ func (in *CompletionInput) GetContext(c *CompletionContext) {
  * - 否则从简单提示词中提取前缀
  * - 如果行前缀为空，从前缀中提取最后一行
  * - 如果行后缀为空，从后缀中提取第一行
+ * - vue/svelte文件定位光标所在的区块，前缀和后缀限制在区块内
+ * - 请求verbose时创建预算报告，记录各部分收到的字节数
  * - 用于预处理补全请求的提示词
  */
 func (in *CompletionInput) GetPrompts() {
@@ -131,4 +340,19 @@ func (in *CompletionInput) GetPrompts() {
 	if in.Processed.ImportContent == "" {
 		in.Processed.ImportContent = req.ImportContent
 	}
+	// 代码评审的diff视图中补全时，去掉diff的装饰还原有效源码
+	if view, prefix, suffix := stripDiff(in.Processed.Prefix, in.Processed.Suffix, req.DiffMode); view != nil {
+		in.Diff = view
+		in.Processed.Prefix = prefix
+		in.Processed.Suffix = suffix
+	}
+	if req.Verbose {
+		in.Budget = newBudgetReport(&in.Processed)
+	}
+	region, prefix, suffix := detectSFCRegion(in.LanguageID, in.Processed.Prefix, in.Processed.Suffix)
+	if region != nil {
+		in.Region = region
+		in.Processed.Prefix = prefix
+		in.Processed.Suffix = suffix
+	}
 }
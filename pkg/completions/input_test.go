@@ -0,0 +1,189 @@
+package completions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/model"
+)
+
+// setupContextServer points the codebase context searches to a server answering body, and counts the searches
+func setupContextServer(body string) (*int32, func()) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	savedContext := *config.Context
+	savedWrapper := *config.Wrapper
+	config.Context.Definition = config.DefinitionConfig{Url: server.URL}
+	config.Context.Semantic = config.SemanticConfig{Url: server.URL, TopK: 5}
+	config.Context.Relation = config.RelationConfig{Url: server.URL, Layer: 1}
+	config.Context.RequestTimeout = time.Second
+	config.Context.TotalTimeout = time.Second
+	config.Wrapper.Score.Disabled = true
+	config.Wrapper.Syntax.Disabled = true
+	config.Wrapper.Reduce = config.ReduceConfig{MaxImportBytes: 64, MaxContextBytes: 64 * 1024, NearCursorLines: 20}
+	return &hits, func() {
+		server.Close()
+		*config.Context = savedContext
+		*config.Wrapper = savedWrapper
+	}
+}
+
+func newContextInput(disable bool) *CompletionInput {
+	return &CompletionInput{
+		CompletionRequest: CompletionRequest{
+			ClientID:       "client",
+			CompletionID:   "completion",
+			LanguageID:     "javascript",
+			DisableContext: disable,
+			Prompts: &PromptOptions{
+				Prefix:          "const date = formatDate(",
+				Suffix:          ");\n",
+				ProjectPath:     "/project",
+				FileProjectPath: "src/main.js",
+				ImportContent:   "import { formatDate } from './date';\n" + strings.Repeat("import { unused } from './unused';\n", 10),
+			},
+		},
+	}
+}
+
+func preprocess(in *CompletionInput) (*CompletionContext, *CompletionResponse) {
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	c.ContextClient = codebase_context.NewContextClient()
+	return c, in.Preprocess(c)
+}
+
+// to test the per-request opt-out of codebase context
+// go test ./pkg/completions/ -v -run Test_DisableContext
+func Test_DisableContext(t *testing.T) {
+	hits, restore := setupContextServer(`{"data":{"list":[{"filePath":"date.js","content":"export function formatDate() {}"}]}}`)
+	defer restore()
+
+	in := newContextInput(true)
+	c, rsp := preprocess(in)
+	if rsp != nil {
+		t.Fatalf("unexpected rejection: %s", rsp.Error)
+	}
+	if n := atomic.LoadInt32(hits); n != 0 {
+		t.Errorf("expected no context search, got %d", n)
+	}
+	if in.ContextOutcome != ContextSkipped || in.ContextSkip != "disabled by request" || c.Perf.ContextDuration != 0 {
+		t.Errorf("unexpected skip accounting: %s %q %d", in.ContextOutcome, in.ContextSkip, c.Perf.ContextDuration)
+	}
+	out := &CompletionResponse{}
+	in.AttachVerbose(out)
+	if out.Verbose == nil || out.Verbose.Input["context"] == nil {
+		t.Error("expected the skip noted in verbose")
+	}
+
+	// other preprocessing is the same as with the context enabled
+	enabled := newContextInput(false)
+	if _, rsp := preprocess(enabled); rsp != nil {
+		t.Fatalf("unexpected rejection: %s", rsp.Error)
+	}
+	if atomic.LoadInt32(hits) == 0 || enabled.ContextOutcome != ContextFound {
+		t.Errorf("expected context found, got %s", enabled.ContextOutcome)
+	}
+	if in.Processed.Prefix != enabled.Processed.Prefix || in.Processed.Suffix != enabled.Processed.Suffix {
+		t.Error("expected the same prompts with the context disabled")
+	}
+	if in.Processed.ImportContent != enabled.Processed.ImportContent || len(in.Reductions) != 1 || len(enabled.Reductions) != 1 {
+		t.Errorf("expected the same reductions, got %v and %v", in.Reductions, enabled.Reductions)
+	}
+}
+
+// to test the accounting of context fetches returning nothing
+// go test ./pkg/completions/ -v -run Test_EmptyContextOutcome
+func Test_EmptyContextOutcome(t *testing.T) {
+	hits, restore := setupContextServer(`{"data":{"list":[]}}`)
+	defer restore()
+
+	in := newContextInput(false)
+	if _, rsp := preprocess(in); rsp != nil {
+		t.Fatalf("unexpected rejection: %s", rsp.Error)
+	}
+	if atomic.LoadInt32(hits) == 0 {
+		t.Error("expected context searches")
+	}
+	if in.ContextOutcome != ContextEmpty || in.Processed.CodeContext != "" {
+		t.Errorf("expected empty outcome, got %s %q", in.ContextOutcome, in.Processed.CodeContext)
+	}
+	out := &CompletionResponse{}
+	in.AttachVerbose(out)
+	if out.Verbose == nil || out.Verbose.Input["context"] == nil {
+		t.Error("expected the empty outcome noted in verbose")
+	}
+
+	// only the request's opt-out skips the fetch, a missing client id is left to the context service
+	in = newContextInput(false)
+	in.ClientID = ""
+	preprocess(in)
+	if in.ContextOutcome == ContextSkipped || in.ContextSkip != "" {
+		t.Errorf("expected no skip for missing client id, got %s %q", in.ContextOutcome, in.ContextSkip)
+	}
+}
+
+// 按名称关闭开关的来源，其余开关按本地配置
+type disabledFlags map[string]bool
+
+func (d disabledFlags) IsEnabled(flag, clientID, tenantID string) bool {
+	return !d[flag] && feature_flag.StaticProvider{}.IsEnabled(flag, clientID, tenantID)
+}
+
+func (d disabledFlags) Snapshot() feature_flag.Snapshot {
+	return feature_flag.StaticProvider{}.Snapshot()
+}
+
+// to test the preprocessing gates follow the request's feature flags
+// go test ./pkg/completions/ -v -run Test_FlagGates
+func Test_FlagGates(t *testing.T) {
+	run := func() (*CompletionInput, *CompletionResponse) {
+		in := &CompletionInput{
+			CompletionRequest: CompletionRequest{
+				ClientID:       "client",
+				CompletionID:   "completion",
+				LanguageID:     "go",
+				DisableContext: true,
+				Prompts: &PromptOptions{
+					Prefix:          "package util\n\nimport \"net/ht",
+					Suffix:          "\n",
+					FileProjectPath: "pkg/util/strings_test.go",
+				},
+			},
+		}
+		c := NewCompletionContext(feature_flag.WithContext(context.Background(), feature_flag.ForRequest("client", "")),
+			&CompletionPerformance{ReceiveTime: time.Now()})
+		if rsp := in.Preprocess(c); rsp != nil {
+			t.Fatalf("unexpected rejection: %s", rsp.Error)
+		}
+		in.ComputeFingerprint(feature_flag.FromContext(c.Ctx))
+		rsp := &CompletionResponse{Status: model.StatusEmpty}
+		in.AdviseRetry(feature_flag.FromContext(c.Ctx), rsp)
+		return in, rsp
+	}
+	in, rsp := run()
+	if in.Shape == nil || in.TestFile == nil || rsp.RetryAdvice == "" || in.Fingerprint == "" {
+		t.Fatalf("expected the gates on by the static config, got shape %+v, test file %+v, advice %q, fingerprint %q",
+			in.Shape, in.TestFile, rsp.RetryAdvice, in.Fingerprint)
+	}
+
+	feature_flag.Use(disabledFlags{feature_flag.Shape: true, feature_flag.TestFile: true, feature_flag.EmptyAdvice: true,
+		feature_flag.Fingerprint: true})
+	defer feature_flag.Use(nil)
+	in, rsp = run()
+	if in.Shape != nil || in.TestFile != nil || rsp.RetryAdvice != "" || in.Fingerprint != "" {
+		t.Errorf("expected the gates off by the flags, got shape %+v, test file %+v, advice %q, fingerprint %q",
+			in.Shape, in.TestFile, rsp.RetryAdvice, in.Fingerprint)
+	}
+}
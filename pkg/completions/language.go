@@ -0,0 +1,459 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// 语言的注释语法
+type CommentSyntax struct {
+	Line       string // 行注释的起始符号，如"//"、"#"
+	LineEnd    string // 行注释的结束符号，如html的"-->"
+	BlockBegin string // 只有块注释的语言(如css)的起始符号
+	BlockEnd   string // 块注释的结束符号
+}
+
+/**
+ * 一种语言的全部语言相关行为
+ * @description
+ * - 内置常用语言的配置，配置wrapper.languages可以按字段覆盖或新增语言，见config.LanguageOverride
+ * - 语言标识不区分大小写，别名(如ts、golang)解析为语言标识
+ * - 没有配置的语言使用零值：没有注释语法、缩进无语义、没有语句结束符、默认引号
+ * - 没有隐藏分权重和语法支持的语言可以指定Family，按语言族回退，见LanguageFallback
+ */
+type LanguageProfile struct {
+	ID                 string                   // 语言标识(插件上报的languageId)
+	Aliases            []string                 // 别名
+	Comment            CommentSyntax            // 注释语法，用于提示词前言和本地补全闭合符号
+	IndentSignificant  bool                     // 缩进有语义，比较重复行时保留行首缩进
+	AllowPythonText    bool                     // 补全像python代码时保留，否则丢弃
+	FrontEnd           bool                     // 补全中可能混入css的前端语言
+	ScoreIndex         int                      // 隐藏分模型中语言权重的序号(从1开始)，0表示没有单独的权重
+	Terminator         string                   // 语句结束符，本地补全闭合符号后追加
+	OptionalTerminator bool                     // 语句结束符可省略，前缀中有以结束符结尾的行时才追加
+	Quotes             string                   // 字符串的引号，为空时使用defaultCloserQuotes
+	QuoteStyle         bool                     // 单引号和双引号字符串等价，学习并规范化引号风格，见QuoteStyleCutter
+	ShapeRules         []config.ShapeRule       // 微补全识别规则，先匹配的规则生效
+	TestPatterns       []string                 // 测试文件的路径模式，见matchTestPattern
+	Literals           *LiteralSyntax           // 字符串字面量的语法，为nil时不识别光标所在的字符串，见detectLiteral
+	Arguments          *ArgumentSyntax          // 参数列表的语法，为nil时不识别光标所在的参数列表，见detectArguments
+	Imports            *ImportSyntax            // 导入语句的语法，为nil时不建议导入语句，见suggestImports
+	Family             string                   // 回退的语言族，为空表示不回退
+	LineWidth          string                   // 补全行超过插件的最大渲染宽度时的处理(wrap/truncate/ignore)，为空表示truncate，见fitLineWidth
+	Punctuation        []config.PunctuationRule // 补全末尾分号/逗号的规范化规则，见TerminalPunctuationCutter
+}
+
+var (
+	slashComment = CommentSyntax{Line: "//"}
+	hashComment  = CommentSyntax{Line: "#"}
+	dashComment  = CommentSyntax{Line: "--"}
+	tagComment   = CommentSyntax{Line: "<!--", LineEnd: "-->"}
+	starComment  = CommentSyntax{BlockBegin: "/*", BlockEnd: "*/"}
+)
+
+// js/ts系语言共用的微补全规则
+var tsShapeRules = []config.ShapeRule{
+	{Shape: ShapeImport, LinePrefix: `^\s*(?:import|export)\s+(?:.*\sfrom\s+)?['"][^'"]*$`},
+	{Shape: ShapeImport, LinePrefix: `\brequire\(\s*['"][^'"]*$`},
+	{Shape: ShapeIdentifier, LinePrefix: `(?:^|[^\w$."'])[A-Za-z_$][\w$]*$`, LineSuffix: `^\s*[=,;:)\]}(.<{?]`},
+}
+
+// js/ts系语言共用的字符串字面量语法
+var tsLiterals = &LiteralSyntax{Template: "`"}
+
+// js/ts系语言共用的测试文件模式
+var tsTestPatterns = []string{"*.test.*", "*.spec.*", "__tests__/"}
+
+// go的分号由编译器插入，补全末尾的分号都去掉；多行复合字面量要求末尾的逗号，保持原样
+var goPunctuation = []config.PunctuationRule{
+	{Mark: ";", Action: PunctuationStrip},
+}
+
+// rust的多行结构体字面量、match分支习惯保留末尾的逗号
+var rustPunctuation = []config.PunctuationRule{
+	{Mark: ",", Position: PositionElement, Action: PunctuationKeep},
+}
+
+// json不允许闭合括号前的逗号
+var jsonPunctuation = []config.PunctuationRule{
+	{Mark: ",", Position: PositionElement, Action: PunctuationStrip},
+}
+
+var includeShapeRules = []config.ShapeRule{
+	{Shape: ShapeImport, LinePrefix: `^\s*#\s*include\s*[<"][^>"]*$`},
+}
+
+// 内置的语言配置
+var builtinProfiles = []LanguageProfile{
+	{ID: "python", Aliases: []string{"py"}, Comment: hashComment, LineWidth: LineWidthWrap, IndentSignificant: true, AllowPythonText: true, ScoreIndex: 1,
+		TestPatterns: []string{"test_*.py", "*_test.py", "conftest.py"}, Literals: &LiteralSyntax{Triple: true, FString: true}, Arguments: &ArgumentSyntax{Assign: "="}, Imports: pythonImports,
+		ShapeRules: []config.ShapeRule{
+			{Shape: ShapeImport, LinePrefix: `^\s*(?:from|import)\s+[\w.]*$`},
+			{Shape: ShapeIdentifier, LinePrefix: `(?:^|[^\w."'])[A-Za-z_]\w*$`, LineSuffix: `^\s*[=,:)\]}(.]`},
+		}},
+	{ID: "javascript", Aliases: []string{"js"}, Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 2, Terminator: ";", OptionalTerminator: true, QuoteStyle: true, ShapeRules: tsShapeRules, TestPatterns: tsTestPatterns, Literals: tsLiterals, Arguments: positionalArguments, Imports: esImports},
+	{ID: "typescript", Aliases: []string{"ts"}, Comment: slashComment, LineWidth: LineWidthWrap, FrontEnd: true, ScoreIndex: 3, Terminator: ";", OptionalTerminator: true, QuoteStyle: true, ShapeRules: tsShapeRules, TestPatterns: tsTestPatterns, Literals: tsLiterals, Arguments: positionalArguments, Imports: esImports},
+	{ID: "javascriptreact", Aliases: []string{"jsx"}, Comment: slashComment, LineWidth: LineWidthWrap, Terminator: ";", OptionalTerminator: true, ShapeRules: tsShapeRules, TestPatterns: tsTestPatterns, Literals: tsLiterals, Arguments: positionalArguments, Imports: esImports},
+	{ID: "typescriptreact", Aliases: []string{"tsx"}, Comment: slashComment, LineWidth: LineWidthWrap, Terminator: ";", OptionalTerminator: true, ShapeRules: tsShapeRules, TestPatterns: tsTestPatterns, Literals: tsLiterals, Arguments: positionalArguments, Imports: esImports},
+	{ID: "java", Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 4, Terminator: ";", TestPatterns: []string{"*Test.java", "*Tests.java", "src/test/"}, Literals: plainLiterals, Arguments: positionalArguments},
+	{ID: "go", Aliases: []string{"golang"}, Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 5, Quotes: "\"'`", TestPatterns: []string{"*_test.go"}, Literals: &LiteralSyntax{Raw: "`"}, Arguments: &ArgumentSyntax{Assign: ":", StructBrace: true}, Imports: goImports, Punctuation: goPunctuation,
+		ShapeRules: []config.ShapeRule{
+			{Shape: ShapeImport, LinePrefix: `^\s*import\s+(?:[\w.]+\s+)?"[^"]*$`},
+			{Shape: ShapeIdentifier, LinePrefix: `(?:^|[^\w."'])[A-Za-z_]\w*$`, LineSuffix: `^\s*(?::=|[=,;:)\]}(.{])`},
+		}},
+	{ID: "c", Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 6, Terminator: ";", ShapeRules: includeShapeRules, Literals: plainLiterals, Arguments: positionalArguments},
+	{ID: "cpp", Aliases: []string{"c++"}, Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 7, Terminator: ";", ShapeRules: includeShapeRules,
+		TestPatterns: []string{"*_test.cc", "*_test.cpp", "*_unittest.cc"}, Literals: plainLiterals, Arguments: positionalArguments},
+	{ID: "csharp", Aliases: []string{"c#", "cs"}, Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 8, Terminator: ";", TestPatterns: []string{"*Tests.cs", "*Test.cs"}, Literals: plainLiterals, Arguments: &ArgumentSyntax{Assign: ":"}},
+	{ID: "php", Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 9, Terminator: ";", TestPatterns: []string{"*Test.php"}, Literals: plainLiterals, Arguments: &ArgumentSyntax{Assign: ":"}},
+	{ID: "ruby", Aliases: []string{"rb"}, Comment: hashComment, ScoreIndex: 10, TestPatterns: []string{"*_spec.rb", "*_test.rb"}, Literals: plainLiterals, Arguments: &ArgumentSyntax{Assign: ":"}},
+	{ID: "rust", Aliases: []string{"rs"}, Comment: slashComment, LineWidth: LineWidthWrap, ScoreIndex: 11, Quotes: "\"", TestPatterns: []string{"tests/"}, Literals: plainLiterals, Arguments: &ArgumentSyntax{Assign: ":", StructBrace: true}, Punctuation: rustPunctuation}, // 单引号还用于生命周期
+	{ID: "kotlin", Aliases: []string{"kt"}, Comment: slashComment, ScoreIndex: 12, TestPatterns: []string{"*Test.kt", "src/test/"}, Literals: plainLiterals, Arguments: &ArgumentSyntax{Assign: "="}},
+	{ID: "scala", Comment: slashComment, ScoreIndex: 13, TestPatterns: []string{"*Spec.scala", "*Test.scala", "src/test/"}, Literals: plainLiterals, Arguments: &ArgumentSyntax{Assign: "="}},
+	{ID: "swift", Comment: slashComment, ScoreIndex: 14, TestPatterns: []string{"*Tests.swift"}, Literals: plainLiterals, Arguments: &ArgumentSyntax{Assign: ":"}},
+	{ID: "objective-c", Aliases: []string{"objc"}, Comment: slashComment, ScoreIndex: 15},
+	{ID: "shell", Aliases: []string{"shellscript", "sh", "bash"}, Comment: hashComment},
+	{ID: "groovy", Comment: slashComment},
+	{ID: "perl", Comment: hashComment},
+	{ID: "r", Comment: hashComment},
+	{ID: "yaml", Aliases: []string{"yml"}, Comment: hashComment, IndentSignificant: true},
+	{ID: "makefile", Comment: hashComment, IndentSignificant: true},
+	{ID: "dockerfile", Comment: hashComment},
+	{ID: "lua", Comment: dashComment},
+	{ID: "sql", Comment: dashComment},
+	{ID: "haskell", Comment: dashComment},
+	{ID: "bat", Comment: CommentSyntax{Line: "@REM"}},
+	{ID: "latex", Comment: CommentSyntax{Line: "%"}, LineWidth: LineWidthIgnore},
+	{ID: "tex", Comment: CommentSyntax{Line: "%"}, LineWidth: LineWidthIgnore},
+	{ID: "lisp", Comment: CommentSyntax{Line: ";"}},
+	{ID: "ini", Comment: CommentSyntax{Line: ";"}},
+	{ID: "json", Quotes: "\"", Punctuation: jsonPunctuation},
+	{ID: "css", Comment: starComment, FrontEnd: true},
+	{ID: "scss", Comment: starComment},
+	{ID: "less", Comment: starComment},
+	{ID: "html", Comment: tagComment, FrontEnd: true},
+	{ID: "xml", Comment: tagComment},
+	{ID: "vue", Comment: tagComment, FrontEnd: true},
+	{ID: "markdown", Aliases: []string{"md"}, Comment: tagComment, LineWidth: LineWidthIgnore},
+	{ID: "coffeescript", IndentSignificant: true, Family: FamilyIndentBased},
+	{ID: "pug", IndentSignificant: true, Family: FamilyIndentBased},
+	{ID: "sass", IndentSignificant: true, Family: FamilyIndentBased},
+	{ID: "haml", IndentSignificant: true, Family: FamilyIndentBased},
+	{ID: "nim", IndentSignificant: true, Family: FamilyIndentBased},
+	{ID: "fsharp", IndentSignificant: true, Family: FamilyIndentBased},
+}
+
+// 回退的语言族
+const (
+	FamilyCLike       = "c-like"       // 以括号分隔代码块、分号结束语句的语言，如terraform、proto
+	FamilyIndentBased = "indent-based" // 以缩进分隔代码块的语言，如nim
+	FamilyMarkup      = "markup"       // 标记语言，如xml方言、模板
+	FamilyPlain       = "plain"        // 判断不出的语言和纯文本
+)
+
+/**
+ * 语言族: 没有语言配置或语法支持的语言回退使用的一组一致的简化功能
+ * @description
+ * - Pruners: full修剪模式运行的后置处理器，light和off模式不受影响
+ * - Stop: 追加的停用词
+ * - SyntaxCheck: 是否做语法检查，只检查括号匹配(parser中该语言族的简化实现)
+ * - ScoreLanguage: 隐藏分使用该语言的权重
+ */
+type LanguageFamily struct {
+	Name          string
+	Pruners       []string
+	Stop          []string
+	SyntaxCheck   bool
+	ScoreLanguage string
+
+	chain *PrunerChain // 按Pruners创建的处理器链
+}
+
+// 内置的语言族
+var builtinFamilies = []LanguageFamily{
+	{Name: FamilyCLike, SyntaxCheck: true, ScoreLanguage: "c",
+		Pruners: []string{DiscardExtremeRepetition, DiscardSyntaxError, CutFirstLineIndent, CutRepetitiveText, CutPrefixOverlap, CutSuffixOverlap, CutSyntaxError, CutTerminalPunctuation}},
+	{Name: FamilyIndentBased, ScoreLanguage: "python",
+		Pruners: []string{DiscardExtremeRepetition, CutFirstLineIndent, CutRepetitiveText, CutPrefixOverlap, CutSuffixOverlap}},
+	{Name: FamilyMarkup, ScoreLanguage: "javascript", // 标记语言多见于前端
+		Pruners: []string{DiscardExtremeRepetition, CutRepetitiveText, CutPrefixOverlap, CutSuffixOverlap}},
+	{Name: FamilyPlain, ScoreLanguage: "python", Stop: []string{"\n\n"},
+		Pruners: []string{DiscardExtremeRepetition, CutRepetitiveText}},
+}
+
+// 语言配置的注册表，启动后只读
+type languageRegistry struct {
+	profiles map[string]*LanguageProfile // key为语言标识
+	aliases  map[string]string           // 别名到语言标识
+	families map[string]*LanguageFamily  // key为语言族名称
+}
+
+var languageProfiles atomic.Pointer[languageRegistry]
+
+func init() {
+	r, err := newLanguageRegistry(builtinProfiles, nil)
+	if err != nil {
+		panic(err)
+	}
+	languageProfiles.Store(r)
+}
+
+/**
+ * 合并配置覆盖并加载语言配置，启动时调用
+ * @param {map[string]config.LanguageOverride} overrides - 配置wrapper.languages
+ * @returns {error} 配置无效时返回错误，仍使用之前的语言配置
+ */
+func InitLanguageProfiles(overrides map[string]config.LanguageOverride) error {
+	r, err := newLanguageRegistry(builtinProfiles, overrides)
+	if err != nil {
+		return err
+	}
+	languageProfiles.Store(r)
+	return nil
+}
+
+/**
+ * 创建语言配置的注册表
+ * @param {[]LanguageProfile} builtin - 内置的语言配置
+ * @param {map[string]config.LanguageOverride} overrides - 按字段覆盖的配置
+ * @returns {*languageRegistry, error} 返回注册表；别名冲突、块注释不成对、微补全规则无效、语言族未知时返回错误
+ */
+func newLanguageRegistry(builtin []LanguageProfile, overrides map[string]config.LanguageOverride) (*languageRegistry, error) {
+	r := &languageRegistry{
+		profiles: make(map[string]*LanguageProfile),
+		aliases:  make(map[string]string),
+		families: make(map[string]*LanguageFamily),
+	}
+	for i := range builtinFamilies {
+		f := builtinFamilies[i]
+		chain, err := NewPrunerChainByNames(f.Pruners)
+		if err != nil {
+			return nil, fmt.Errorf("language family %s: %v", f.Name, err)
+		}
+		f.chain = chain
+		r.families[f.Name] = &f
+	}
+	builtinAliases := make(map[string]string)
+	for i := range builtin {
+		p := builtin[i]
+		p.Aliases = append([]string(nil), p.Aliases...)
+		r.profiles[p.ID] = &p
+		for _, alias := range p.Aliases {
+			builtinAliases[alias] = p.ID
+		}
+	}
+	// 按语言标识排序合并，出错时报告的语言是确定的
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		id := strings.ToLower(strings.TrimSpace(key))
+		if id == "" {
+			return nil, fmt.Errorf("wrapper.languages: empty language id")
+		}
+		if builtinID, ok := builtinAliases[id]; ok {
+			id = builtinID
+		}
+		p, ok := r.profiles[id]
+		if !ok {
+			p = &LanguageProfile{ID: id}
+			r.profiles[id] = p
+		}
+		if err := p.merge(overrides[key]); err != nil {
+			return nil, fmt.Errorf("wrapper.languages.%s: %v", key, err)
+		}
+	}
+	for _, p := range r.profiles {
+		if _, ok := r.families[p.Family]; p.Family != "" && !ok {
+			return nil, fmt.Errorf("wrapper.languages.%s: unknown family '%s'", p.ID, p.Family)
+		}
+		for _, alias := range p.Aliases {
+			alias = strings.ToLower(alias)
+			if other, ok := r.aliases[alias]; ok && other != p.ID {
+				return nil, fmt.Errorf("wrapper.languages: alias '%s' of '%s' is already used by '%s'", alias, p.ID, other)
+			}
+			if _, ok := r.profiles[alias]; ok {
+				return nil, fmt.Errorf("wrapper.languages: alias '%s' of '%s' is a language id", alias, p.ID)
+			}
+			r.aliases[alias] = p.ID
+		}
+	}
+	return r, nil
+}
+
+// 按字段合并配置覆盖，并校验
+func (p *LanguageProfile) merge(o config.LanguageOverride) error {
+	p.Aliases = append(p.Aliases, o.Aliases...)
+	if o.LineComment != nil {
+		p.Comment.Line = *o.LineComment
+	}
+	if o.LineCommentEnd != nil {
+		p.Comment.LineEnd = *o.LineCommentEnd
+	}
+	switch len(o.BlockComment) {
+	case 0:
+	case 2:
+		p.Comment.BlockBegin, p.Comment.BlockEnd = o.BlockComment[0], o.BlockComment[1]
+	default:
+		return fmt.Errorf("blockComment requires the begin and end symbols, got %d", len(o.BlockComment))
+	}
+	if o.IndentSignificant != nil {
+		p.IndentSignificant = *o.IndentSignificant
+	}
+	if o.AllowPythonText != nil {
+		p.AllowPythonText = *o.AllowPythonText
+	}
+	if o.FrontEnd != nil {
+		p.FrontEnd = *o.FrontEnd
+	}
+	if o.Terminator != nil {
+		p.Terminator = *o.Terminator
+	}
+	if o.OptionalTerminator != nil {
+		p.OptionalTerminator = *o.OptionalTerminator
+	}
+	if o.Quotes != nil {
+		p.Quotes = *o.Quotes
+	}
+	if o.QuoteStyle != nil {
+		p.QuoteStyle = *o.QuoteStyle
+	}
+	if len(o.ShapeRules) > 0 {
+		for _, rule := range o.ShapeRules {
+			if rule.Shape != ShapeIdentifier && rule.Shape != ShapeImport {
+				return fmt.Errorf("unknown shape '%s'", rule.Shape)
+			}
+			for _, pattern := range []string{rule.LinePrefix, rule.LineSuffix} {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("invalid shape pattern '%s': %v", pattern, err)
+				}
+			}
+		}
+		p.ShapeRules = o.ShapeRules
+	}
+	if len(o.TestPatterns) > 0 {
+		for _, pattern := range o.TestPatterns {
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil || pattern == "" {
+				return fmt.Errorf("invalid test pattern '%s'", pattern)
+			}
+		}
+		p.TestPatterns = o.TestPatterns
+	}
+	if o.Family != nil {
+		p.Family = *o.Family
+	}
+	if o.LineWidth != nil {
+		switch *o.LineWidth {
+		case "", LineWidthWrap, LineWidthTruncate, LineWidthIgnore:
+			p.LineWidth = *o.LineWidth
+		default:
+			return fmt.Errorf("unknown lineWidth '%s'", *o.LineWidth)
+		}
+	}
+	if len(o.Punctuation) > 0 {
+		for _, rule := range o.Punctuation {
+			if err := checkPunctuationRule(rule); err != nil {
+				return err
+			}
+		}
+		p.Punctuation = o.Punctuation
+	}
+	return nil
+}
+
+/**
+ * 获取语言配置
+ * @param {string} language - 语言标识或别名，不区分大小写
+ * @returns {*LanguageProfile, bool} 返回语言配置；没有配置时返回false
+ * @example
+ * p, ok := LookupLanguage("ts")
+ * // p.ID = "typescript", ok = true
+ */
+func LookupLanguage(language string) (*LanguageProfile, bool) {
+	r := languageProfiles.Load()
+	id := strings.ToLower(strings.TrimSpace(language))
+	if alias, ok := r.aliases[id]; ok {
+		id = alias
+	}
+	p, ok := r.profiles[id]
+	return p, ok
+}
+
+// 指标的language标签取值，已知语言取语言标识(别名归一)，未知的记为other，避免序列数随请求增长
+func languageLabel(language string) string {
+	if p, ok := LookupLanguage(language); ok {
+		return p.ID
+	}
+	return metrics.LabelOther
+}
+
+// 获取语言族，没有该语言族时返回nil
+func lookupFamily(name string) *LanguageFamily {
+	return languageProfiles.Load().families[name]
+}
+
+// 获取语言配置，没有配置的语言返回零值的配置(ID为小写的语言标识)
+func profileOf(language string) *LanguageProfile {
+	if p, ok := LookupLanguage(language); ok {
+		return p
+	}
+	return &LanguageProfile{ID: strings.ToLower(strings.TrimSpace(language))}
+}
+
+/**
+ * 用语言的注释语法注释代码
+ * @param {string} code - 代码
+ * @returns {string, bool} 返回注释后的代码；语言没有注释语法时返回false
+ * @description
+ * - 有行注释时逐行注释，空白行保持为空
+ * - 只有块注释时，整段代码放在块注释中
+ * @example
+ * profileOf("html").CommentCode("a\nb")
+ * // "<!-- a -->\n<!-- b -->"
+ */
+func (p *LanguageProfile) CommentCode(code string) (string, bool) {
+	c := &p.Comment
+	switch {
+	case c.Line != "":
+		if code == "" {
+			return "", true
+		}
+		lines := strings.Split(code, "\n")
+		for i, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				lines[i] = ""
+				continue
+			}
+			lines[i] = c.Line + " " + line
+			if c.LineEnd != "" {
+				lines[i] += " " + c.LineEnd
+			}
+		}
+		return strings.Join(lines, "\n"), true
+	case c.BlockBegin != "":
+		if code == "" {
+			return "", true
+		}
+		return c.BlockBegin + "\n" + code + "\n" + c.BlockEnd, true
+	}
+	return "", false
+}
+
+// 隐藏分模型的语言序号，用于默认的隐藏分配置
+func scoreLanguageMap() map[string]int {
+	m := make(map[string]int)
+	for id, p := range languageProfiles.Load().profiles {
+		if p.ScoreIndex > 0 {
+			m[id] = p.ScoreIndex - 1
+		}
+	}
+	return m
+}
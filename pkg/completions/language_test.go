@@ -0,0 +1,188 @@
+package completions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test the resolution of language ids and aliases
+// go test ./pkg/completions/ -v -run Test_LookupLanguage
+func Test_LookupLanguage(t *testing.T) {
+	cases := map[string]string{
+		"ts":          "typescript",
+		"golang":      "go",
+		"Python":      "python",
+		" TSX ":       "typescriptreact",
+		"shellscript": "shell",
+		"c++":         "cpp",
+		"go":          "go",
+	}
+	for language, expected := range cases {
+		p, ok := LookupLanguage(language)
+		if !ok || p.ID != expected {
+			t.Errorf("%q: expected %s, got %v", language, expected, p)
+		}
+	}
+	if _, ok := LookupLanguage("brainfuck"); ok {
+		t.Error("expected no profile for an unknown language")
+	}
+	if p := profileOf("Brainfuck"); p.ID != "brainfuck" || p.Terminator != "" || p.IndentSignificant {
+		t.Errorf("expected a zero profile for an unknown language, got %+v", p)
+	}
+}
+
+// to test merging the configured overrides over the built-in profiles
+// go test ./pkg/completions/ -v -run Test_LanguageOverride
+func Test_LanguageOverride(t *testing.T) {
+	defer InitLanguageProfiles(nil)
+	empty, hash, yes := "", "#", true
+	err := InitLanguageProfiles(map[string]config.LanguageOverride{
+		"ts":       {Terminator: &empty, Aliases: []string{"mts"}},
+		"starlark": {Aliases: []string{"bzl"}, LineComment: &hash, IndentSignificant: &yes, AllowPythonText: &yes},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ts, _ := LookupLanguage("mts")
+	if ts == nil || ts.ID != "typescript" || ts.Terminator != "" || !ts.OptionalTerminator || ts.Comment.Line != "//" || ts.ScoreIndex != 3 {
+		t.Errorf("expected only the overridden fields changed, got %+v", ts)
+	}
+	star, ok := LookupLanguage("BZL")
+	if !ok || star.ID != "starlark" || !indentSignificant("starlark") || !star.AllowPythonText {
+		t.Errorf("expected a new language from the override, got %+v", star)
+	}
+	if text, ok := star.CommentCode("a\n\nb"); !ok || text != "# a\n\n# b" {
+		t.Errorf("unexpected comment %q", text)
+	}
+	// the built-in profiles are not modified by overrides
+	if builtinProfiles[2].ID != "typescript" || builtinProfiles[2].Terminator != ";" || len(builtinProfiles[2].Aliases) != 1 {
+		t.Errorf("built-in profile modified: %+v", builtinProfiles[2])
+	}
+
+	invalid := []map[string]config.LanguageOverride{
+		{"css": {BlockComment: []string{"/*"}}},
+		{"starlark": {Aliases: []string{"py"}}},
+		{"starlark": {Aliases: []string{"go"}}},
+		{"go": {ShapeRules: []config.ShapeRule{{Shape: "word", LinePrefix: `\w+$`}}}},
+		{"go": {ShapeRules: []config.ShapeRule{{Shape: ShapeImport, LinePrefix: `(`}}}},
+	}
+	for i, overrides := range invalid {
+		if err := InitLanguageProfiles(overrides); err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+	// the last valid profiles are kept
+	if p, ok := LookupLanguage("bzl"); !ok || p.ID != "starlark" {
+		t.Error("expected the previous profiles kept after an invalid override")
+	}
+}
+
+// to test that the migrated lookups behave as the former per-feature tables
+// go test ./pkg/completions/ -v -run Test_LanguageMigration
+func Test_LanguageMigration(t *testing.T) {
+	// the former hidden score language map
+	scores := map[string]int{
+		"python": 0, "javascript": 1, "typescript": 2, "java": 3, "go": 4,
+		"c": 5, "cpp": 6, "csharp": 7, "php": 8, "ruby": 9,
+		"rust": 10, "kotlin": 11, "scala": 12, "swift": 13, "objective-c": 14,
+	}
+	if got := scoreLanguageMap(); !reflect.DeepEqual(got, scores) {
+		t.Errorf("hidden score language map changed: %v", got)
+	}
+
+	// the former JudgeCss front languages, NotMatchLanguageDiscarder python check and indentation languages
+	front := []string{"vue", "html", "typescript", "css"}
+	indent := []string{"python", "yaml", "makefile", "coffeescript", "pug", "sass", "haml", "nim", "fsharp"}
+	for _, p := range builtinProfiles {
+		if p.FrontEnd != contains(front, p.ID) {
+			t.Errorf("%s: front end changed", p.ID)
+		}
+		if p.IndentSignificant != contains(indent, p.ID) {
+			t.Errorf("%s: indentation significance changed", p.ID)
+		}
+		if p.AllowPythonText != (p.ID == "python") {
+			t.Errorf("%s: python text check changed", p.ID)
+		}
+	}
+
+	// the former local closer tables
+	terminators := map[string]string{"c": ";", "cpp": ";", "java": ";", "csharp": ";", "php": ";",
+		"javascript": ";", "typescript": ";", "javascriptreact": ";", "typescriptreact": ";"}
+	optional := []string{"javascript", "typescript", "javascriptreact", "typescriptreact"}
+	hashComments := []string{"python", "ruby", "shell"}
+	for _, language := range []string{"c", "cpp", "java", "csharp", "php", "javascript", "typescript",
+		"javascriptreact", "typescriptreact", "go", "rust", "python", "ruby", "shell", "kotlin", "swift"} {
+		p := profileOf(language)
+		if p.Terminator != terminators[language] || p.OptionalTerminator != contains(optional, language) {
+			t.Errorf("%s: statement terminator changed", language)
+		}
+		comment := "//"
+		if contains(hashComments, language) {
+			comment = "#"
+		}
+		if p.Comment.Line != comment {
+			t.Errorf("%s: closer line comment changed", language)
+		}
+	}
+	if quotesOf("rust") != "\"" || quotesOf("go") != "\"'`" || quotesOf("java") != "\"'`" {
+		t.Error("closer quotes changed")
+	}
+
+	// the former comment syntax table of the prompt preamble
+	comments := map[string]string{
+		"python": "# a", "shellscript": "# a", "yaml": "# a", "dockerfile": "# a",
+		"go": "// a", "typescriptreact": "// a", "objective-c": "// a", "groovy": "// a",
+		"lua": "-- a", "sql": "-- a", "haskell": "-- a",
+		"bat": "@REM a", "latex": "% a", "lisp": "; a", "ini": "; a",
+		"css": "/*\na\n*/", "less": "/*\na\n*/",
+		"html": "<!-- a -->", "vue": "<!-- a -->", "markdown": "<!-- a -->",
+	}
+	for language, expected := range comments {
+		if got, ok := profileOf(language).CommentCode("a"); !ok || got != expected {
+			t.Errorf("%s: expected comment %q, got %q", language, expected, got)
+		}
+	}
+	if _, ok := profileOf("pug").CommentCode("a"); ok {
+		t.Error("expected no comment syntax for pug")
+	}
+
+	// the former default micro-completion rules, filtered by language
+	ts := []string{"typescript", "javascript", "typescriptreact", "javascriptreact"}
+	oldRules := []config.ShapeRule{
+		{Shape: ShapeImport, Languages: []string{"go"}, LinePrefix: `^\s*import\s+(?:[\w.]+\s+)?"[^"]*$`},
+		{Shape: ShapeImport, Languages: []string{"python"}, LinePrefix: `^\s*(?:from|import)\s+[\w.]*$`},
+		{Shape: ShapeImport, Languages: ts, LinePrefix: `^\s*(?:import|export)\s+(?:.*\sfrom\s+)?['"][^'"]*$`},
+		{Shape: ShapeImport, Languages: ts, LinePrefix: `\brequire\(\s*['"][^'"]*$`},
+		{Shape: ShapeImport, Languages: []string{"c", "cpp"}, LinePrefix: `^\s*#\s*include\s*[<"][^>"]*$`},
+		{Shape: ShapeIdentifier, Languages: []string{"go"}, LinePrefix: `(?:^|[^\w."'])[A-Za-z_]\w*$`, LineSuffix: `^\s*(?::=|[=,;:)\]}(.{])`},
+		{Shape: ShapeIdentifier, Languages: []string{"python"}, LinePrefix: `(?:^|[^\w."'])[A-Za-z_]\w*$`, LineSuffix: `^\s*[=,:)\]}(.]`},
+		{Shape: ShapeIdentifier, Languages: ts, LinePrefix: `(?:^|[^\w$."'])[A-Za-z_$][\w$]*$`, LineSuffix: `^\s*[=,;:)\]}(.<{?]`},
+	}
+	for _, p := range builtinProfiles {
+		var expected []string
+		for _, rule := range oldRules {
+			if contains(rule.Languages, p.ID) {
+				expected = append(expected, rule.Shape+rule.LinePrefix+rule.LineSuffix)
+			}
+		}
+		var got []string
+		for _, rule := range p.ShapeRules {
+			got = append(got, rule.Shape+rule.LinePrefix+rule.LineSuffix)
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("%s: shape rules changed", p.ID)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
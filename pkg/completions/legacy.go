@@ -0,0 +1,142 @@
+package completions
+
+import (
+	"code-completion/pkg/model"
+	"net/http"
+)
+
+// 旧版Python服务的补全状态
+const (
+	LegacyStatusOK           = "ok"              // 补全成功
+	LegacyStatusNoResult     = "no_result"       // 补全为空
+	LegacyStatusFiltered     = "filtered"        // 根据规则拒绝补全
+	LegacyStatusCancelled    = "cancelled"       // 请求被取消
+	LegacyStatusTimeout      = "timeout"         // 请求超时
+	LegacyStatusOverloaded   = "overloaded"      // 服务端繁忙
+	LegacyStatusInvalid      = "invalid_request" // 请求存在错误
+	LegacyStatusUnauthorized = "unauthorized"    // 缺少或被拒绝的用户认证信息
+	LegacyStatusModelError   = "model_error"     // 模型响应错误
+	LegacyStatusInternal     = "internal_error"  // 服务端错误
+)
+
+// 旧版服务响应中的性能统计，时长为毫秒
+type LegacyPerf struct {
+	ContextMs        int64 `json:"context_ms"`
+	QueueMs          int64 `json:"queue_ms"`
+	LlmMs            int64 `json:"llm_ms"`
+	TotalMs          int64 `json:"total_ms"`
+	PromptTokens     int   `json:"prompt_tokens"`
+	CompletionTokens int   `json:"completion_tokens"`
+}
+
+/**
+ * 旧版Python服务的补全响应格式
+ * @description
+ * - Completion: 第一个候选的补全内容，没有候选时为空字符串
+ * - Status: 旧版的状态取值，见LegacyStatus*；Message为错误信息或补全为空的说明
+ * - Extra: 原样返回请求的extra，并附带请求的client_id、completion_id、language_id、trigger_mode
+ */
+type LegacyResponse struct {
+	ID         string                 `json:"id"`
+	Model      string                 `json:"model"`
+	Created    int                    `json:"created"`
+	Completion string                 `json:"completion"`
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message,omitempty"`
+	Perf       LegacyPerf             `json:"perf"`
+	Extra      map[string]interface{} `json:"extra"`
+}
+
+// 补全状态对应的旧版状态
+func LegacyStatus(status model.CompletionStatus) string {
+	switch status {
+	case model.StatusSuccess:
+		return LegacyStatusOK
+	case model.StatusEmpty:
+		return LegacyStatusNoResult
+	case model.StatusRejected:
+		return LegacyStatusFiltered
+	case model.StatusCanceled:
+		return LegacyStatusCancelled
+	case model.StatusTimeout:
+		return LegacyStatusTimeout
+	case model.StatusBusy:
+		return LegacyStatusOverloaded
+	case model.StatusReqError:
+		return LegacyStatusInvalid
+	case model.StatusUnauthorized:
+		return LegacyStatusUnauthorized
+	case model.StatusModelError:
+		return LegacyStatusModelError
+	default:
+		return LegacyStatusInternal
+	}
+}
+
+// 旧版状态对应的HTTP状态码：旧版服务对有结果的请求(含空补全、拒绝、取消)都返回200
+func legacyStatusCode(status string) int {
+	switch status {
+	case LegacyStatusOK, LegacyStatusNoResult, LegacyStatusFiltered, LegacyStatusCancelled:
+		return http.StatusOK
+	case LegacyStatusInvalid:
+		return http.StatusBadRequest
+	case LegacyStatusUnauthorized:
+		return http.StatusUnauthorized
+	case LegacyStatusOverloaded:
+		return http.StatusServiceUnavailable
+	case LegacyStatusTimeout:
+		return http.StatusGatewayTimeout
+	case LegacyStatusModelError:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+/**
+ * 将补全响应转换为旧版Python服务的格式
+ * @param {*CompletionRequest} req - 补全请求，用于回显extra，可以为nil
+ * @param {*CompletionResponse} rsp - 补全响应
+ * @returns {int} 返回旧版约定的HTTP状态码
+ * @returns {*LegacyResponse} 返回旧版格式的响应
+ * @description
+ * - 纯函数，不修改req和rsp，不记录指标；指标仍按标准的补全状态记录
+ * - 旧版没有的字段(置信度、锚点、重试建议等)被丢弃
+ * @example
+ * code, legacy := ToLegacy(&req.CompletionRequest, rsp)
+ * c.JSON(code, legacy)
+ */
+func ToLegacy(req *CompletionRequest, rsp *CompletionResponse) (int, *LegacyResponse) {
+	out := &LegacyResponse{
+		ID:      rsp.ID,
+		Model:   rsp.Model,
+		Created: rsp.Created,
+		Status:  LegacyStatus(rsp.Status),
+		Message: rsp.Error,
+		Perf: LegacyPerf{
+			ContextMs:        rsp.Usage.ContextDuration,
+			QueueMs:          rsp.Usage.QueueDuration,
+			LlmMs:            rsp.Usage.LLMDuration,
+			TotalMs:          rsp.Usage.TotalDuration,
+			PromptTokens:     rsp.Usage.PromptTokens,
+			CompletionTokens: rsp.Usage.CompletionTokens,
+		},
+		Extra: map[string]interface{}{},
+	}
+	if len(rsp.Choices) > 0 {
+		out.Completion = rsp.Choices[0].Text
+	}
+	if out.Message == "" {
+		out.Message = rsp.EmptyMessage
+	}
+	if req != nil {
+		for k, v := range req.Extra {
+			out.Extra[k] = v
+		}
+		out.Extra["client_id"] = req.ClientID
+		out.Extra["completion_id"] = req.CompletionID
+		out.Extra["language_id"] = req.LanguageID
+		out.Extra["trigger_mode"] = req.TriggerMode
+	}
+	return legacyStatusCode(out.Status), out
+}
@@ -0,0 +1,66 @@
+package completions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// legacyGolden is a response of the legacy Python service
+type legacyGolden struct {
+	Code int             `json:"code"`
+	Body json.RawMessage `json:"body"`
+}
+
+// to test the mapping to the legacy response format against the golden files
+// go test ./pkg/completions/ -v -run Test_ToLegacy
+func Test_ToLegacy(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/legacy/*.input.json")
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("no golden files: %v", err)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".input.json")
+		t.Run(name, func(t *testing.T) {
+			var in struct {
+				Request  *CompletionRequest  `json:"request"`
+				Response *CompletionResponse `json:"response"`
+			}
+			var golden legacyGolden
+			for path, v := range map[string]interface{}{input: &in, "testdata/legacy/" + name + ".golden.json": &golden} {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal(data, v); err != nil {
+					t.Fatalf("%s: %v", path, err)
+				}
+			}
+			before, _ := json.Marshal(in.Response)
+			code, legacy := ToLegacy(in.Request, in.Response)
+			if after, _ := json.Marshal(in.Response); string(after) != string(before) {
+				t.Error("expected the response not modified")
+			}
+			if code != golden.Code {
+				t.Errorf("expected http status %d, got %d", golden.Code, code)
+			}
+			// 按JSON比较，与字段顺序和格式无关
+			var got, expected interface{}
+			data, _ := json.Marshal(legacy)
+			json.Unmarshal(data, &got)
+			json.Unmarshal(golden.Body, &expected)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %s, got %s", golden.Body, data)
+			}
+		})
+	}
+
+	// 没有请求时extra为空对象
+	_, legacy := ToLegacy(nil, &CompletionResponse{Status: "unknown"})
+	if legacy.Status != LegacyStatusInternal || legacy.Extra == nil || len(legacy.Extra) != 0 {
+		t.Errorf("unexpected legacy response %+v", legacy)
+	}
+}
@@ -0,0 +1,226 @@
+package completions
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+//
+//	插件的最大渲染宽度: 窄编辑器窗口中过长的补全行会溢出并被截断显示，用户即使补全正确也会拒绝
+//
+
+// 超过最大渲染宽度的补全行的处理方式，按语言配置，见LanguageProfile.LineWidth
+const (
+	LineWidthWrap     = "wrap"     // 在安全的断点处折行，无法安全折行时按truncate处理
+	LineWidthTruncate = "truncate" // 在超宽行之前截断补全
+	LineWidthIgnore   = "ignore"   // 不处理
+)
+
+// 最大渲染宽度处理的名称，生效时记录在命中的处理器中
+const (
+	WrapLineWidth = "wrap-line_width"
+	CutLineWidth  = "cut-line_width"
+)
+
+// 计算显示宽度时tab占的列数
+const lineWidthTabSize = 4
+
+// 续行缩进的单位，语言推断不出缩进风格时使用
+const continuationIndent = "    "
+
+// 可以在其后折行的二元运算符，两侧需要有空格，避免误判一元运算符(如-x、*p、&v)
+var wrapOperators = []string{"&&", "||", "??", "+", "-", "*", "/", "%", "|", "&"}
+
+// 一行的显示宽度，tab按lineWidthTabSize对齐
+func displayWidth(col int, text string) int {
+	for _, ch := range text {
+		if ch == '\t' {
+			col += lineWidthTabSize - col%lineWidthTabSize
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+// 行首的空白
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// 跨行扫描的状态: 未闭合的括号数和未闭合字符串的引号
+type wrapScanState struct {
+	depth int
+	quote rune
+}
+
+/**
+ * 找出一行中可以安全折行的位置
+ * @param {string} language - 语言
+ * @param {string} line - 补全中的一行
+ * @param {*wrapScanState} state - 行首的扫描状态，扫描后更新为行尾的状态
+ * @returns {[]int} 返回断点在行中的字节偏移(断点之前的内容留在本行)，按偏移递增
+ * @description
+ * - 断点: 逗号之后、两侧有空格的二元运算符之后、链式调用中")."的点之后
+ * - 字符串和行注释中不折行；行首已在字符串中时(多行字符串)整行不折行
+ * - 缩进有语义的语言(如python)只在括号内折行，括号外折行需要续行符
+ */
+func wrapBreaks(language, line string, state *wrapScanState) []int {
+	profile := profileOf(language)
+	quotes := quotesOf(language)
+	comment := profile.Comment.Line
+	safe := state.quote == 0
+	var breaks []int
+	escaped := false
+	for i, ch := range line {
+		if state.quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == state.quote:
+				state.quote = 0
+			}
+			continue
+		}
+		if comment != "" && strings.HasPrefix(line[i:], comment) {
+			break
+		}
+		switch {
+		case strings.ContainsRune(quotes, ch):
+			state.quote = ch
+			continue
+		case closingPairs[ch] != 0:
+			state.depth++
+		case ch == ')' || ch == ']' || ch == '}':
+			state.depth = max(state.depth-1, 0)
+		}
+		if !safe || (profile.IndentSignificant && state.depth == 0) {
+			continue
+		}
+		rest := line[i+utf8.RuneLen(ch):]
+		switch {
+		case ch == ',' && (rest == "" || rest[0] == ' '):
+			breaks = append(breaks, i+1)
+		case ch == '.' && i > 0 && line[i-1] == ')' && rest != "" && (unicode.IsLetter(rune(rest[0])) || rest[0] == '_'):
+			breaks = append(breaks, i+1)
+		case ch == ' ' && i > 0:
+			for _, op := range wrapOperators {
+				if after, ok := strings.CutPrefix(rest, op); ok && strings.HasPrefix(after, " ") {
+					breaks = append(breaks, i+1+len(op))
+					break
+				}
+			}
+		}
+	}
+	return breaks
+}
+
+/**
+ * 在断点处把超宽的一行折成多行
+ * @param {string} line - 补全中的一行，首行为光标之后的内容
+ * @param {int} col - 行首所在的列，首行为光标所在的列
+ * @param {string} indent - 续行的缩进
+ * @param {[]int} breaks - 可以折行的位置，见wrapBreaks
+ * @param {int} width - 最大渲染宽度
+ * @returns {string, bool} 返回折行后的内容；某一段在所有断点处都放不下时返回false
+ * @description
+ * - 每段尽量放下更多内容，断点处的空白去掉，续行缩进比原行多一级
+ */
+func wrapLine(line string, col int, indent string, breaks []int, width int) (string, bool) {
+	var segments []string
+	start := 0
+	for displayWidth(col, line[start:]) > width {
+		end := -1
+		for _, b := range breaks {
+			if b <= start {
+				continue
+			}
+			segment := strings.TrimRight(line[start:b], " ")
+			if strings.TrimSpace(segment) != "" && displayWidth(col, segment) <= width {
+				end = b
+			}
+		}
+		if end < 0 {
+			return "", false
+		}
+		segments = append(segments, strings.TrimRight(line[start:end], " "))
+		start = end
+		for start < len(line) && line[start] == ' ' {
+			start++
+		}
+		col = displayWidth(0, indent)
+	}
+	segments = append(segments, line[start:])
+	return strings.Join(segments, "\n"+indent), true
+}
+
+/**
+ * 按插件的最大渲染宽度处理补全内容
+ * @param {*PrunerContext} ctx - 后置处理器上下文，处理后更新CompletionCode和锚点
+ * @param {int} width - 插件提示的最大渲染宽度(列数)，不大于0时不处理
+ * @returns {string} 返回生效的处理(WrapLineWidth/CutLineWidth)，没有超宽的行或不处理时返回空
+ * @description
+ * - 首行接在光标之后，宽度包括光标所在行已有的内容
+ * - 处理方式按语言配置，见LanguageProfile.LineWidth：
+ *   wrap在逗号、运算符、链式调用的点之后折行，续行缩进多一级；字符串中不折行，无法安全折行时按truncate处理
+ *   truncate在第一个超宽行之前截断，首行超宽时补全为空
+ * @example
+ * ctx := &PrunerContext{Language: "go", Prefix: "\tq := ", CompletionCode: "db.Where(\"a = ?\", a).Order(\"b\").Find(&rows)"}
+ * action := fitLineWidth(ctx, 40)
+ * // ctx.CompletionCode = "db.Where(\"a = ?\", a).Order(\"b\").\n\t\tFind(&rows)"，action = "wrap-line_width"
+ */
+func fitLineWidth(ctx *PrunerContext, width int) string {
+	mode := profileOf(ctx.Language).LineWidth
+	if width <= 0 || mode == LineWidthIgnore || ctx.CompletionCode == "" {
+		return ""
+	}
+	cursorLine := ctx.Prefix[strings.LastIndexByte(ctx.Prefix, '\n')+1:]
+	cursorCol := displayWidth(0, cursorLine)
+	var state wrapScanState
+	if stack, quote, _, ok := scanStatement(ctx.Language, cursorLine); ok {
+		state = wrapScanState{depth: len(stack), quote: quote}
+	}
+
+	lines := strings.Split(ctx.CompletionCode, "\n")
+	action := ""
+	for i, line := range lines {
+		col, indent := 0, leadingSpace(line)
+		if i == 0 {
+			col, indent = cursorCol, leadingSpace(cursorLine)
+		}
+		breaks := wrapBreaks(ctx.Language, line, &state)
+		if displayWidth(col, line) <= width {
+			continue
+		}
+		if mode == LineWidthWrap {
+			unit := continuationIndent
+			if strings.HasPrefix(indent, "\t") || (indent == "" && profileOf(ctx.Language).ID == "go") {
+				unit = "\t"
+			}
+			if wrapped, ok := wrapLine(line, col, indent+unit, breaks, width); ok {
+				lines[i] = wrapped
+				action = WrapLineWidth
+				continue
+			}
+		}
+		ctx.log().Debug("Truncate completion at the line exceeding the render width",
+			zap.Int("line", i), zap.Int("width", width))
+		lines = lines[:i]
+		action = CutLineWidth
+		break
+	}
+	if action == "" {
+		return ""
+	}
+	ctx.CompletionCode = strings.TrimRight(strings.Join(lines, "\n"), " \t\n")
+	if ctx.Anchor.ReplaceLineSuffix && !endsWithLineSuffix(ctx.CompletionCode, ctx.Suffix) {
+		ctx.Anchor.ReplaceLineSuffix = false
+	}
+	ctx.Anchor.CursorOffset = utf8.RuneCountInString(ctx.CompletionCode)
+	return action
+}
@@ -0,0 +1,67 @@
+package completions
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test wrapping a long go call chain and not wrapping python lines inside strings
+// go test ./pkg/completions/ -v -run Test_FitLineWidth
+func Test_FitLineWidth(t *testing.T) {
+	cases := []struct {
+		name     string
+		language string
+		prefix   string
+		code     string
+		width    int
+		action   string
+		expected string
+	}{
+		{"go chain wrapped after commas and chained dots", "go",
+			"func load(db *gorm.DB) ([]User, error) {\n\tvar users []User\n\terr := ",
+			"db.Model(&User{}).Where(\"name = ? AND age > ?\", name, age).Order(\"created_at desc\").Limit(10).Find(&users).Error\n\treturn users, err",
+			60, WrapLineWidth,
+			"db.Model(&User{}).Where(\"name = ? AND age > ?\",\n\t\tname, age).Order(\"created_at desc\").Limit(10).\n\t\tFind(&users).Error\n\treturn users, err"},
+		{"python string expression outside brackets truncated", "python",
+			"def greet(name):\n    ",
+			"print(name)\n    message = \"Hello, \" + name + \", welcome back, we missed you!\"\n    return message",
+			40, CutLineWidth, "print(name)"},
+		{"python wrapped inside brackets after the operator, not in the string", "python",
+			"def check(name):\n    if not name.isalpha():\n        ",
+			"raise ValueError(\"invalid name, expected letters only: \" + name)",
+			68, WrapLineWidth, "raise ValueError(\"invalid name, expected letters only: \" +\n            name)"},
+		{"python breaks only inside the string", "python",
+			"def check(name):\n    ",
+			"log.info(\"checking the name, the length and the characters of it\")",
+			40, CutLineWidth, ""},
+		{"no hint", "go", "\t", "fmt.Println(\"a\", \"b\", \"c\")", 0, "", "fmt.Println(\"a\", \"b\", \"c\")"},
+		{"ignored language", "markdown", "", "a very long sentence, with commas, that exceeds the width", 20, "", "a very long sentence, with commas, that exceeds the width"},
+		{"truncate language", "shell", "", "echo ok\ncurl -sSL https://example.com/install.sh | bash -s -- --yes", 30, CutLineWidth, "echo ok"},
+	}
+	for _, c := range cases {
+		ctx := &PrunerContext{Language: c.language, Prefix: c.prefix, CompletionCode: c.code}
+		if action := fitLineWidth(ctx, c.width); action != c.action || ctx.CompletionCode != c.expected {
+			t.Errorf("%s: expected %q %q, got %q %q", c.name, c.action, c.expected, action, ctx.CompletionCode)
+		}
+	}
+
+	// 处理结果记录在命中的处理器中
+	llm := &scriptedLLM{cfg: config.ModelConfig{ModelName: "scripted", DisablePrune: true}, texts: []string{cases[0].code}}
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	para := &model.CompletionParameter{
+		CompletionID: "line-width",
+		Model:        "scripted",
+		Language:     "go",
+		Prefix:       cases[0].prefix,
+		MaxLineWidth: 60,
+	}
+	rsp := NewCompletionHandler(llm).CallLLM(c, para)
+	if rsp.Choices[0].Text != cases[0].expected || !slices.Contains(rsp.Hits, WrapLineWidth) {
+		t.Errorf("expected the wrap reflected in the hits, got %q %v", rsp.Choices[0].Text, rsp.Hits)
+	}
+}
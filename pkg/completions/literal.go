@@ -0,0 +1,201 @@
+package completions
+
+import (
+	"code-completion/pkg/feature_flag"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// 光标所在字符串字面量的种类
+const (
+	LiteralString        = "string"        // 单引号/双引号字符串
+	LiteralRaw           = "raw"           // 没有转义的原始字符串(如go的反引号字符串)
+	LiteralTriple        = "triple"        // python的三引号字符串
+	LiteralTemplate      = "template"      // js/ts的模板字符串
+	LiteralInterpolation = "interpolation" // 模板字符串${}或f-string{}中的插值表达式
+)
+
+/**
+ * 语言的字符串字面量语法，为nil的语言不识别光标所在的字符串
+ * @description
+ * - 单引号/双引号等普通字符串的引号见LanguageProfile.Quotes，不能跨行
+ * - Raw: 可以跨行、没有转义的原始字符串的引号
+ * - Template: 可以跨行、支持${}插值的模板字符串的引号
+ * - Triple: 支持三引号的跨行字符串
+ * - FString: 支持f前缀的插值字符串
+ */
+type LiteralSyntax struct {
+	Raw      string
+	Template string
+	Triple   bool
+	FString  bool
+}
+
+// 只有普通字符串的语言
+var plainLiterals = &LiteralSyntax{}
+
+/**
+ * 光标所在的字符串字面量
+ * @description
+ * - Kind: 字面量的种类，见Literal*
+ * - Quote: 闭合字符串的引号；插值表达式中为闭合插值的"}"
+ * - Stop: 追加的停用词，补全停在闭合符号之前，并且只补全一行
+ */
+type StringLiteral struct {
+	Kind  string   `json:"kind"`
+	Quote string   `json:"quote"`
+	Stop  []string `json:"stop"`
+}
+
+// 扫描时的一层字面量，code为true时是插值表达式，depth为其中未闭合的大括号数
+type literalFrame struct {
+	code      bool
+	depth     int
+	kind      string
+	quote     string
+	multiline bool
+	escapes   bool
+	interp    string // 插值的起始符号，"${"或"{"，为空表示不支持插值
+}
+
+/**
+ * 识别光标所在的字符串字面量
+ * @param {string} language - 光标处的语言
+ * @param {string} prefix - 光标前的内容
+ * @returns {*StringLiteral} 返回光标所在的字符串，不在字符串中(或在注释中、语言没有配置字面量语法)时返回nil
+ * @description
+ * - 从前缀开头扫描，跳过注释，转义的引号不闭合字符串
+ * - 普通字符串到行尾还没闭合时视为已结束
+ * - 模板字符串${}和f-string{}中是插值表达式，其中可以再嵌套字符串
+ * @example
+ * detectLiteral("javascript", "const s = `hello ${user.na")
+ * // &StringLiteral{Kind: "interpolation", Quote: "}", Stop: ["}", "\n"]}
+ * detectLiteral("go", "msg := \"say \\\"hi\\\"\" + na")
+ * // nil
+ */
+func detectLiteral(language, prefix string) *StringLiteral {
+	profile := profileOf(language)
+	syntax := profile.Literals
+	if syntax == nil {
+		return nil
+	}
+	quotes := quotesOf(language)
+	lineComment := profile.Comment.Line
+	blockBegin, blockEnd := profile.Comment.BlockBegin, profile.Comment.BlockEnd
+	if lineComment == "//" && blockBegin == "" {
+		blockBegin, blockEnd = "/*", "*/"
+	}
+
+	var stack []*literalFrame
+	for i := 0; i < len(prefix); {
+		var top *literalFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		// 字符串中
+		if top != nil && !top.code {
+			switch {
+			case top.escapes && prefix[i] == '\\':
+				i += 2
+			case strings.HasPrefix(prefix[i:], top.quote):
+				stack = stack[:len(stack)-1]
+				i += len(top.quote)
+			case prefix[i] == '\n' && !top.multiline:
+				stack = stack[:len(stack)-1]
+				i++
+			case top.interp == "{" && strings.HasPrefix(prefix[i:], "{{"):
+				i += 2
+			case top.interp != "" && strings.HasPrefix(prefix[i:], top.interp):
+				stack = append(stack, &literalFrame{code: true, quote: top.quote})
+				i += len(top.interp)
+			default:
+				i++
+			}
+			continue
+		}
+		// 代码中(或插值表达式中)
+		ch := prefix[i]
+		switch {
+		case lineComment != "" && strings.HasPrefix(prefix[i:], lineComment):
+			j := strings.IndexByte(prefix[i:], '\n')
+			if j < 0 {
+				return nil
+			}
+			i += j
+		case blockBegin != "" && strings.HasPrefix(prefix[i:], blockBegin):
+			j := strings.Index(prefix[i+len(blockBegin):], blockEnd)
+			if j < 0 {
+				return nil
+			}
+			i += len(blockBegin) + j + len(blockEnd)
+		case top != nil && ch == '{':
+			top.depth++
+			i++
+		case top != nil && ch == '}':
+			if top.depth == 0 {
+				stack = stack[:len(stack)-1]
+			} else {
+				top.depth--
+			}
+			i++
+		case syntax.Raw != "" && strings.HasPrefix(prefix[i:], syntax.Raw):
+			stack = append(stack, &literalFrame{kind: LiteralRaw, quote: syntax.Raw, multiline: true})
+			i += len(syntax.Raw)
+		case syntax.Template != "" && strings.HasPrefix(prefix[i:], syntax.Template):
+			stack = append(stack, &literalFrame{kind: LiteralTemplate, quote: syntax.Template, multiline: true,
+				escapes: true, interp: "${"})
+			i += len(syntax.Template)
+		case strings.IndexByte(quotes, ch) >= 0:
+			frame := &literalFrame{kind: LiteralString, quote: string(ch), escapes: true}
+			if syntax.Triple && strings.HasPrefix(prefix[i:], strings.Repeat(string(ch), 3)) {
+				frame.kind, frame.quote, frame.multiline = LiteralTriple, strings.Repeat(string(ch), 3), true
+			}
+			if syntax.FString && isFStringPrefix(prefix[:i]) {
+				frame.interp = "{"
+			}
+			stack = append(stack, frame)
+			i += len(frame.quote)
+		default:
+			i++
+		}
+	}
+	if len(stack) == 0 {
+		return nil
+	}
+	top := stack[len(stack)-1]
+	if top.code {
+		return &StringLiteral{Kind: LiteralInterpolation, Quote: "}", Stop: []string{"}", "\n"}}
+	}
+	return &StringLiteral{Kind: top.kind, Quote: top.quote, Stop: []string{top.quote, "\n"}}
+}
+
+// 引号前是否是f-string的前缀(f、rf、fr等，不区分大小写)
+func isFStringPrefix(before string) bool {
+	n := len(before)
+	for n > 0 && n > len(before)-2 && strings.IndexByte("rRbBfFuU", before[n-1]) >= 0 {
+		n--
+	}
+	letters := before[n:]
+	if n > 0 && isWordByte(before[n-1]) {
+		return false
+	}
+	return strings.ContainsAny(letters, "fF")
+}
+
+// 是否是标识符中的字符
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// 识别光标所在的字符串，补全限制在字符串(或插值表达式)内
+func (in *CompletionInput) detectLiteral(c *CompletionContext) {
+	if !c.Enabled(feature_flag.Literal) || in.Shape != nil {
+		return
+	}
+	in.Literal = detectLiteral(in.EffectiveLanguage(), in.Processed.Prefix)
+	if in.Literal != nil {
+		c.Log().Debug("Cursor inside string literal", zap.String("kind", in.Literal.Kind),
+			zap.String("quote", in.Literal.Quote))
+	}
+}
@@ -0,0 +1,79 @@
+package completions
+
+import (
+	"testing"
+)
+
+// to test detecting the string literal around the cursor
+// go test ./pkg/completions/ -v -run Test_DetectLiteral
+func Test_DetectLiteral(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		prefix   string
+		kind     string
+		quote    string
+	}{
+		{"go string", "go", "func main() {\n\tfmt.Println(\"hello, wor", LiteralString, "\""},
+		{"go raw string", "go", "const query = `\n\tSELECT id\n\tFROM users WHERE na", LiteralRaw, "`"},
+		{"go closed raw string", "go", "const query = `SELECT \"id\"`\nvar n = ", "", ""},
+		{"go escaped quote", "go", "msg := \"say \\\"hi\\\"\" + na", "", ""},
+		{"go escaped quote inside", "go", "msg := \"say \\\"hi", LiteralString, "\""},
+		{"go comment", "go", "// it's a \"comment\nx := 1 // don't", "", ""},
+		{"python f-string", "python", "name = 'x'\nprint(f\"hello {name} and wel", LiteralString, "\""},
+		{"python f-string interpolation", "python", "print(f\"total: {sum(pri", LiteralInterpolation, "}"},
+		{"python f-string escaped brace", "python", "print(f\"{{lit", LiteralString, "\""},
+		{"python plain brace", "python", "print(\"{sum(pri", LiteralString, "\""},
+		{"python triple", "python", "def f():\n    \"\"\"Return the\n    sum of", LiteralTriple, "\"\"\""},
+		{"python closed docstring", "python", "def f():\n    \"\"\"Doc.\"\"\"\n    return ", "", ""},
+		{"python comment", "python", "x = 1  # it's\ny = ", "", ""},
+		{"js template", "javascript", "const s = `hello\n${name} wel", LiteralTemplate, "`"},
+		{"js open interpolation", "typescript", "const s = `hello ${user.na", LiteralInterpolation, "}"},
+		{"js nested braces", "javascript", "const s = `${fn({a: 1}).va", LiteralInterpolation, "}"},
+		{"js nested string", "javascript", "const s = `${names.join(', ", LiteralString, "'"},
+		{"js closed interpolation", "javascript", "const s = `${a}-${b} and", LiteralTemplate, "`"},
+		{"js block comment", "javascript", "/* it's */ const a = ", "", ""},
+		{"js string ends at line end", "javascript", "const a = 'oops\nconst b = ", "", ""},
+		{"markdown not detected", "markdown", "It's a ", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			literal := detectLiteral(tt.language, tt.prefix)
+			kind, quote := "", ""
+			if literal != nil {
+				kind, quote = literal.Kind, literal.Quote
+			}
+			if kind != tt.kind || quote != tt.quote {
+				t.Errorf("expected %q %q, got %q %q", tt.kind, tt.quote, kind, quote)
+			}
+		})
+	}
+}
+
+// to test the literal mode adds the closing stop words and skips the syntax pruners
+// go test ./pkg/completions/ -v -run Test_LiteralCompletion
+func Test_LiteralCompletion(t *testing.T) {
+	in := &CompletionInput{CompletionRequest: CompletionRequest{LanguageID: "go"}}
+	in.Processed.Prefix = "fmt.Println(\"hello, wor"
+	in.Processed.Suffix = "\")\n"
+	in.Literal = detectLiteral("go", in.Processed.Prefix)
+	stops := NewCompletionHandler(&scriptedLLM{}).prepareStopWords(in)
+	if !contains(stops, "\"") || !contains(stops, "\n") {
+		t.Errorf("expected the closing quote and newline as stop words, got %q", stops)
+	}
+	para := NewCompletionHandler(&scriptedLLM{}).Adapt(in)
+	if para.Literal != LiteralString {
+		t.Errorf("expected the literal kind passed to pruning, got %q", para.Literal)
+	}
+
+	ctx := &PrunerContext{Language: "go", CompletionCode: "ld", Prefix: in.Processed.Prefix, Suffix: in.Processed.Suffix, Literal: para.Literal}
+	if (&SyntaxErrorDiscarder{}).Process(ctx) || (&SyntaxErrorCutter{}).Process(ctx) || ctx.CompletionCode != "ld" {
+		t.Errorf("expected the syntax pruners to keep the string fragment, got %q", ctx.CompletionCode)
+	}
+
+	rsp := &CompletionResponse{}
+	in.AttachVerbose(rsp)
+	if literal, ok := rsp.Verbose.Input["literal"].(*StringLiteral); !ok || literal.Kind != LiteralString {
+		t.Errorf("expected the literal mode in verbose, got %+v", rsp.Verbose.Input["literal"])
+	}
+}
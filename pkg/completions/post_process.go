@@ -2,56 +2,114 @@ package completions
 
 import (
 	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"strings"
 
 	"go.uber.org/zap"
 )
 
+// 修剪模式
+const (
+	PruneFull  = "full"  // 完整的后置处理器链
+	PruneLight = "light" // 只丢弃极端重复的补全
+	PruneOff   = "off"   // 不修剪
+)
+
+/**
+ * 确定补全实际生效的修剪模式
+ * @param {string} requested - 客户端请求的修剪模式，为空表示full
+ * @returns {string} 返回实际生效的修剪模式
+ * @description
+ * - 模型配置了DisablePrune时为off
+ * - 未知的模式，或不在配置wrapper.prune.allowedModes中的模式，按full处理
+ * - 无论哪种模式，补全结束清理(停用词、哨兵词残留)都会执行
+ * @example
+ * config.Wrapper.Prune.AllowedModes = []string{"full", "light"}
+ * mode := handler.resolvePruneMode("light")
+ * // mode = "light"
+ */
+func (h *CompletionHandler) resolvePruneMode(requested string) string {
+	if h.cfg.DisablePrune {
+		return PruneOff
+	}
+	mode := strings.ToLower(requested)
+	if mode != PruneLight && mode != PruneOff {
+		return PruneFull
+	}
+	for _, allowed := range config.Wrapper.Prune.AllowedModes {
+		if strings.ToLower(allowed) == mode {
+			return mode
+		}
+	}
+	return PruneFull
+}
+
+// 指标中使用的请求修剪模式，限定取值避免客户端传入任意标签
+func requestedPruneMode(requested string) string {
+	switch mode := strings.ToLower(requested); mode {
+	case "":
+		return "default"
+	case PruneFull, PruneLight, PruneOff:
+		return mode
+	default:
+		return "invalid"
+	}
+}
+
 /**
  * 修剪补全结果
+ * @param {*CompletionContext} c - 补全上下文，后置处理器通过其中的请求级logger记录日志
  * @param {string} completionText - 原始补全文本内容
- * @param {string} prefix - 代码前缀文本
- * @param {string} suffix - 代码后缀文本
- * @param {string} lang - 编程语言标识符
- * @returns {string} 返回修剪后的补全文本
+ * @param {*model.CompletionParameter} para - 补全参数，提供前后缀、语言、区块和已生效的修剪模式
+ * @returns {string, CompletionAnchor, []string} 返回修剪后的补全文本、修剪过程中得到的锚点信息，以及命中的处理器
  * @description
  * - 使用后置处理器链修剪补全结果
- * - 如果配置了自定义修剪器，使用自定义链
- * - 否则使用默认的后置处理器链
- * - 记录修剪过程的调试信息
+ * - 使用启动时按修剪模式创建的处理器链，见InitPrunerChains
+ * - light模式只使用极端重复丢弃器
+ * - 快速路径的full模式跳过多行和语法检查处理器，见NewFastPrunerChain
+ * - 语言回退到语言族时，full模式使用语言族的处理器链，语法检查按语言族进行(只有c-like检查括号匹配)
+ * - 如果配置了自定义修剪器，使用自定义链，否则使用默认的后置处理器链
+ * - 记录修剪过程的调试信息，包括各命中处理器的内容变化
  * - 用于优化补全结果的质量和格式
  * @example
  * result := handler.pruneCompletionCode(
+ *     c,
  *     "function test() {\n    return;\n}\nfunction test2() {}",
- *     "function test() {",
- *     "}",
- *     "javascript"
+ *     &model.CompletionParameter{Prefix: "function test() {", Suffix: "}", Language: "javascript"}
  * )
  * // 结果可能移除重复的函数定义
  */
-func (h *CompletionHandler) pruneCompletionCode(completionText, prefix, suffix, lang string) string {
+func (h *CompletionHandler) pruneCompletionCode(c *CompletionContext, completionText string, para *model.CompletionParameter) (string, CompletionAnchor, []string) {
+	chain, prunerContext := newPrunerRun(para, completionText)
+	prunerContext.Logger, prunerContext.Ctx = c.Log(), c.Ctx
+	result := chain.Process(prunerContext)
+	if result.Modified {
+		c.Log().Info("Prune by Pruners",
+			zap.String("pre", completionText),
+			zap.String("post", result.Code),
+			zap.Any("hits", result.Hits))
+	}
+	return result.Code, result.Anchor, result.HitNames()
+}
+
+// 按请求参数选择处理器链并创建处理器上下文，离线评估(PruneOffline)与线上使用同一逻辑
+func newPrunerRun(para *model.CompletionParameter, completionText string) (*PrunerChain, *PrunerContext) {
 	prunerContext := &PrunerContext{
-		Language:       lang,
+		Language:       para.Language,
+		Block:          para.Block,
 		CompletionCode: completionText,
-		Prefix:         prefix,
-		Suffix:         suffix,
-	}
-	var chain *PrunerChain
-	var err error
-	if len(config.Wrapper.Prune.Pruners) > 0 {
-		chain, err = NewPrunerChainByNames(config.Wrapper.Prune.Pruners)
-		if err != nil {
-			zap.L().Error("Invalid config: 'wrapper.prune.pruners' contains invalid pruner names",
-				zap.Any("pruners", config.Wrapper.Prune.Pruners))
-		}
+		Prefix:         para.Prefix,
+		Suffix:         para.Suffix,
+		Style:          para.Style,
+		Literal:        para.Literal,
+		Arguments:      para.Arguments,
 	}
-	if chain == nil {
-		chain = NewDefaultPrunerChain()
-	}
-	if chain.Process(prunerContext) {
-		zap.L().Info("Prune by Pruners",
-			zap.String("pre", completionText),
-			zap.String("post", prunerContext.CompletionCode),
-			zap.Any("hits", chain.GetHitProcessors()))
+	chain := prunerChainFor(para.PruneMode)
+	if f := lookupFamily(para.LanguageFamily); f != nil && para.PruneMode == PruneFull {
+		chain = f.chain
+		prunerContext.SyntaxLanguage = f.Name
+	} else if para.FastPath && para.PruneMode == PruneFull {
+		chain = fastPrunerChain()
 	}
-	return prunerContext.CompletionCode
+	return chain, prunerContext
 }
@@ -2,19 +2,38 @@ package completions
 
 import (
 	"code-completion/pkg/config"
+	"code-completion/pkg/model"
 	"strings"
+	"time"
 )
 
 /**
  * 截断超长的提示词(前缀，后缀，上下文)
  * @param {*config.ModelConfig} cfg - 模型配置，包含最大前缀和后缀token限制
  * @param {*PromptOptions} ppt - 提示词选项，包含前缀、后缀和代码上下文
+ * @param {string} preamble - 提示词前言，置于上下文之前，其token数计入前缀预算
+ * @param {*model.BudgetReport} budget - 预算报告，不为nil时记录截断前后的token数和分词耗时
+ * @param {string} cacheKey - 前缀增量分词缓存的key，见prefixCacheKey，为空时完整分词
+ * @returns {int} 返回截断后本地计算的提示词token数(前缀、上下文、前言、分隔符和后缀)，没有tokenizer时返回0
+ * @returns {float64} 返回使用的token数校准系数，没有tokenizer时返回0
  * @description
  * - 检查并截断超过模型限制的长提示词
  * - 优先保留最靠近补全位置的代码
  * - 如果前缀已超长，完全丢弃上下文
  * - 否则截断上下文以保留前缀
- * - 同时处理后缀的截断
+ * - 同时处理后缀的截断，不在光标所在调用的参数列表中截断(SuffixKeep)，参数列表放不下时不带后缀
+ * - 截断完成后再将前言拼接到上下文之前，避免前言被截掉
+ * - 上下文(含前言)不为空时，上下文与前缀之间的分隔符占用前缀预算，见config.ModelConfig.GetContextSeparator
+ * - 预算报告只使用截断时已经计算的token数，不额外分词
+ * - 前缀使用增量分词缓存，只对变化的分块和截断时保留的末尾分块分词，结果与完整分词相同，见PrefixTokenCache
+ * - 前缀和后缀的预算按模型的token数校准系数调整，见TokenCalibration
+ * - 模型开启截断标记时，在前缀、上下文的开头和后缀的末尾的截断处插入一行注释，标记占用该部分的预算，见truncationMarker
+ * - 整个丢弃的上下文和后缀不插入标记
+ * - 截断前先按后缀策略(ppt.SuffixPolicy)裁剪后缀，first_k_lines/none策略没有用完的后缀预算让给前缀和上下文；
+ *   full策略保持原有的预算
+ * - 按截断结果更新上下文各片段的来源(ppt.Provenance)，预算报告中附带，见traceSnippets
+ * - 有检索上下文(ppt.Provenance不为空)时，上下文最多占用前缀预算的context.sanitize.maxPromptFraction，超过时从开头截断；
+ *   截断后用注释围栏包围上下文(在前言之后)，围栏占用前缀预算，见contextFences；片段来源的位置仍相对于围栏内的上下文
  * @example
  * cfg := &config.ModelConfig{MaxPrefix: 1000, MaxSuffix: 500}
  * ppt := &PromptOptions{
@@ -22,17 +41,37 @@ import (
  *     Suffix: "long suffix...",
  *     CodeContext: "long context...",
  * }
- * handler.truncatePrompt(cfg, ppt)
+ * handler.truncatePrompt(cfg, ppt, "", nil, "")
  * // ppt中的内容会被截断到模型限制范围内
  */
-func (h *CompletionHandler) truncatePrompt(cfg *config.ModelConfig, ppt *PromptOptions) {
+func (h *CompletionHandler) truncatePrompt(cfg *config.ModelConfig, ppt *PromptOptions, preamble string, budget *model.BudgetReport, cacheKey string) (int, float64) {
+	ppt.Suffix = applySuffixPolicy(ppt.SuffixPolicy, ppt.Suffix, ppt.SuffixKeep)
 	tokenizer := h.llm.Tokenizer()
+	retrieved := len(ppt.Provenance) > 0 && ppt.CodeContext != ""
 	if tokenizer == nil {
-		return
+		fenceBegin, fenceEnd := "", ""
+		if retrieved {
+			fenceBegin, fenceEnd, _ = contextFences(nil, ppt.Language)
+		}
+		ppt.Provenance = traceSnippets(ppt.Provenance, len(ppt.CodeContext), len(ppt.CodeContext), 0, 0,
+			fenceTrailing(fenceBegin, fenceEnd), cfg.TruncationMarkers)
+		ppt.CodeContext = joinPreamble(preamble, fenceContext(fenceBegin, fenceEnd, ppt.CodeContext))
+		if budget != nil {
+			budget.SuffixPolicy, budget.Snippets = ppt.SuffixPolicy.SuffixPolicy, ppt.Provenance
+		}
+		return 0, 0
 	}
+	fenceBegin, fenceEnd, fenceTokensNum := "", "", 0
+	defer func() {
+		ppt.CodeContext = joinPreamble(preamble, fenceContext(fenceBegin, fenceEnd, ppt.CodeContext))
+	}()
 
-	prefixTokens := tokenizer.Encode(ppt.Prefix)
-	prefixTokensNum := len(prefixTokens)
+	tokenizeStart := time.Now()
+	original := *ppt
+	var cuts [3]sectionCut
+	prefix := PrefixCache().Tokenize(tokenizer, cfg.ModelName, cacheKey, ppt.Prefix)
+	prefixTokensNum := prefix.count
+	prefixKept := prefixTokensNum
 
 	suffixTokens := tokenizer.Encode(ppt.Suffix)
 	suffixTokensNum := len(suffixTokens)
@@ -40,30 +79,180 @@ func (h *CompletionHandler) truncatePrompt(cfg *config.ModelConfig, ppt *PromptO
 	contextTokens := tokenizer.Encode(ppt.CodeContext)
 	contextTokensNum := len(contextTokens)
 
-	// 获取最大模型长度限制
-	prefixMax := h.llm.Config().MaxPrefix
-	suffixMax := h.llm.Config().MaxSuffix
+	// 获取最大模型长度限制(按校准系数调整)，前言占用前缀预算
+	factor := Calibration.Factor(cfg.ModelName)
+	prefixMax := calibratedBudget(h.llm.Config().MaxPrefix, factor)
+	suffixMax := calibratedBudget(h.llm.Config().MaxSuffix, factor)
+	// 后缀策略不发送的后缀让出预算
+	reallocated := 0
+	if policy := ppt.SuffixPolicy.SuffixPolicy; policy != "" && policy != config.SuffixFull {
+		reallocated = suffixMax - min(suffixTokensNum, suffixMax)
+		prefixMax += reallocated
+	}
+	preambleTokensNum := 0
+	if preamble != "" {
+		preambleTokensNum = tokenizer.GetTokenCount(preamble)
+		prefixMax, preamble = reservePreambleBudget(prefixMax, preambleTokensNum, preamble)
+		if preamble == "" {
+			preambleTokensNum = 0
+		}
+	}
+	// 检索上下文的围栏占用前缀预算，上下文最多占用预算的一定比例
+	contextMax := prefixMax
+	if retrieved {
+		fenceBegin, fenceEnd, fenceTokensNum = contextFences(tokenizer.GetTokenCount, ppt.Language)
+		// 与前言相同，围栏占用一半以上的预算时不加围栏
+		if fenceTokensNum*2 > prefixMax {
+			fenceBegin, fenceEnd, fenceTokensNum = "", "", 0
+		}
+		prefixMax -= fenceTokensNum
+		contextMax = contextBudget(prefixMax, config.Context.Sanitize.MaxPromptFraction)
+	}
+	// 上下文和前言都为空时不拼接分隔符，不占用预算
+	separatorTokensNum := 0
+	if ppt.CodeContext != "" || preamble != "" {
+		separatorTokensNum = tokenizer.GetTokenCount(cfg.GetContextSeparator())
+	}
+	tokenizeDuration := time.Since(tokenizeStart)
+	if budget != nil {
+		defer func() {
+			separator := 0
+			if len(contextTokens) > 0 || preamble != "" {
+				separator = separatorTokensNum
+			}
+			recordTruncation(budget, [3]int{prefixTokensNum, suffixTokensNum, contextTokensNum},
+				[3]int{prefixKept, len(suffixTokens), len(contextTokens)}, cuts, preambleTokensNum, separator, tokenizeDuration)
+			budget.SuffixPolicy, budget.ReallocatedTokens = ppt.SuffixPolicy.SuffixPolicy, reallocated
+			if len(contextTokens) > 0 {
+				budget.FenceTokens = fenceTokensNum
+				budget.PromptTokens += fenceTokensNum
+			}
+			budget.Snippets = ppt.Provenance
+		}()
+	}
 
-	// 如果总token数超过限制，需要截断
-	if prefixTokensNum+contextTokensNum > prefixMax {
-		needCutTokens := prefixTokensNum + contextTokensNum - prefixMax
+	// 如果总token数超过限制，或检索上下文超过比例上限，需要截断
+	contextKept := len(ppt.CodeContext)
+	needCutTokens := max(prefixTokensNum+contextTokensNum+separatorTokensNum-prefixMax, contextTokensNum-contextMax)
+	if needCutTokens > 0 {
 
-		// 前缀都已经超长了，就把上下文完全丢弃掉
-		if prefixTokensNum >= prefixMax {
-			prefixTokens = prefixTokens[prefixTokensNum-prefixMax:]
+		// 前缀都已经超长了，就把上下文完全丢弃掉，没有前言时也不再拼接分隔符
+		if prefixTokensNum+separatorTokensNum >= prefixMax {
+			keepTokens := prefixMax
+			if preamble != "" {
+				keepTokens -= separatorTokensNum
+			}
+			if prefixTokensNum >= keepTokens {
+				marker, markerTokens := truncationMarker(cfg, tokenizer.GetTokenCount, ppt.Language, markerEarlierCode, keepTokens)
+				prefixTokens := prefix.tail(keepTokens - markerTokens)
+				prefixKept = len(prefixTokens)
+				ppt.Prefix = tokenizer.Decode(prefixTokens)
+				ppt.Prefix = h.trimFirstLine(ppt.Prefix)
+				cuts[0] = sectionCut{lines: lineCount(original.Prefix) - lineCount(ppt.Prefix), marker: markerTokens}
+				if marker != "" {
+					ppt.Prefix = marker + "\n" + ppt.Prefix
+				}
+			}
+			cuts[2].lines = lineCount(original.CodeContext)
+			contextTokens = nil
 			ppt.CodeContext = ""
-			ppt.Prefix = tokenizer.Decode(prefixTokens)
-			ppt.Prefix = h.trimFirstLine(ppt.Prefix)
+			contextKept = 0
 		} else {
-			contextTokens = contextTokens[needCutTokens:]
+			marker, markerTokens := truncationMarker(cfg, tokenizer.GetTokenCount, ppt.Language, markerEarlierContext,
+				len(contextTokens)-needCutTokens)
+			contextTokens = contextTokens[needCutTokens+markerTokens:]
 			ppt.CodeContext = tokenizer.Decode(contextTokens)
+			contextKept = len(ppt.CodeContext)
+			cuts[2] = sectionCut{lines: lineCount(original.CodeContext) - lineCount(ppt.CodeContext), marker: markerTokens}
+			if marker != "" {
+				ppt.CodeContext = marker + "\n" + ppt.CodeContext
+			}
 		}
 	}
 	if suffixTokensNum > suffixMax {
-		suffixTokens = suffixTokens[:suffixMax]
-		ppt.Suffix = tokenizer.Decode(suffixTokens)
-		ppt.Suffix = h.trimLastLine(ppt.Suffix)
+		marker, markerTokens := truncationMarker(cfg, tokenizer.GetTokenCount, ppt.Language, markerLaterCode, suffixMax)
+		suffixTokens = suffixTokens[:suffixMax-markerTokens]
+		suffix := tokenizer.Decode(suffixTokens)
+		if len(suffix) < ppt.SuffixKeep {
+			suffix, suffixTokens = "", nil
+		}
+		ppt.Suffix = h.trimLastLine(suffix, ppt.SuffixKeep)
+		cuts[1].lines = lineCount(original.Suffix) - lineCount(ppt.Suffix)
+		if marker != "" && ppt.Suffix != "" {
+			cuts[1].marker = markerTokens
+			ppt.Suffix = appendMarker(ppt.Suffix, marker)
+		}
+	}
+	ppt.Provenance = traceSnippets(ppt.Provenance, len(original.CodeContext), contextKept, contextTokensNum, len(contextTokens),
+		fenceTrailing(fenceBegin, fenceEnd), cfg.TruncationMarkers)
+	promptTokens := prefixKept + len(contextTokens) + preambleTokensNum + len(suffixTokens)
+	for _, cut := range cuts {
+		promptTokens += cut.marker
 	}
+	if len(contextTokens) > 0 || preamble != "" {
+		promptTokens += separatorTokensNum
+	}
+	if len(contextTokens) > 0 {
+		promptTokens += fenceTokensNum
+	} else {
+		fenceBegin, fenceEnd = "", ""
+	}
+	return promptTokens, factor
+}
+
+// 截断标记的说明文字
+const (
+	markerEarlierCode    = "earlier code omitted"    // 前缀截掉了文件开头
+	markerEarlierContext = "earlier context omitted" // 上下文截掉了开头
+	markerLaterCode      = "later code omitted"      // 后缀截掉了文件末尾
+)
+
+/**
+ * 生成截断处插入的标记
+ * @param {*config.ModelConfig} cfg - 模型配置，TruncationMarkers为false时不插入标记
+ * @param {func(string) int} count - 计算token数的函数
+ * @param {string} language - 语言，使用其注释语法
+ * @param {string} text - 标记的说明文字，见marker*
+ * @param {int} budget - 该部分截断后可用的token数
+ * @returns {string} 返回一行注释(不含换行)，不插入标记时返回空
+ * @returns {int} 返回标记连同换行的token数，不插入标记时为0
+ * @description
+ * - 语言没有注释语法时不插入标记
+ * - 标记占用该部分一半以上的预算时不插入，保留代码
+ * @example
+ * marker, n := truncationMarker(cfg, tokenizer.GetTokenCount, "go", markerEarlierCode, 1000)
+ * // marker = "// ... earlier code omitted ..."
+ */
+func truncationMarker(cfg *config.ModelConfig, count func(string) int, language, text string, budget int) (string, int) {
+	if !cfg.TruncationMarkers {
+		return "", 0
+	}
+	marker, ok := profileOf(language).CommentCode("... " + text + " ...")
+	if !ok {
+		return "", 0
+	}
+	tokens := count(marker + "\n")
+	if tokens*2 > budget {
+		return "", 0
+	}
+	return marker, tokens
+}
+
+// 在后缀末尾追加截断标记，保证标记独占一行
+func appendMarker(suffix, marker string) string {
+	if strings.HasSuffix(suffix, "\n") {
+		return suffix + marker
+	}
+	return suffix + "\n" + marker
+}
+
+// 文本的行数，最后一行没有换行符时也计入
+func lineCount(text string) int {
+	n := strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		n++
+	}
+	return n
 }
 
 /**
@@ -96,26 +285,34 @@ func (h *CompletionHandler) trimFirstLine(prompt string) string {
 /**
  * 修剪后缀的最后一行
  * @param {string} suffix - 要修剪的后缀文本
+ * @param {int} keep - 至少保留的字节数(光标所在调用的参数列表)，为0时不限制
  * @returns {string} 返回修剪后的后缀文本
  * @description
  * - 从后缀中移除最后一行（如果不是以换行符结尾）
  * - 使用SplitAfter方法分割文本
  * - 保留除最后一行外的所有内容
+ * - 移除后不足keep字节时保留到keep为止，不在参数列表中截断
  * - 用于处理后缀格式，确保正确的代码结构
  * @example
- * result := handler.trimLastLine("line1\nline2\nline3")
+ * result := handler.trimLastLine("line1\nline2\nline3", 0)
  * // result = "line1\nline2"
  *
- * result = handler.trimLastLine("line1\nline2\n")
+ * result = handler.trimLastLine("line1\nline2\n", 0)
  * // result = "line1\nline2\n" (最后一行以换行符结尾，保留)
+ *
+ * result = handler.trimLastLine("a=1,\nb=2) + c", 9)
+ * // result = "a=1,\nb=2)"
  */
-func (h *CompletionHandler) trimLastLine(suffix string) string {
+func (h *CompletionHandler) trimLastLine(suffix string, keep int) string {
 	lines := strings.SplitAfter(suffix, "\n")
 	if len(lines) > 0 {
 		if len(lines) > 1 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
 			lines = lines[:len(lines)-1]
 		}
-		return strings.Join(lines, "")
+		if trimmed := strings.Join(lines, ""); len(trimmed) >= keep || len(suffix) < keep {
+			return trimmed
+		}
+		return suffix[:keep]
 	}
 	return suffix
 }
@@ -134,7 +331,11 @@ func (h *CompletionHandler) trimLastLine(suffix string) string {
  * // count = 10 (实际数量取决于tokenizer实现)
  */
 func (h *CompletionHandler) getTokensCount(prompt string) int {
-	return h.llm.Tokenizer().GetTokenCount(prompt)
+	tokenizer := h.llm.Tokenizer()
+	if tokenizer == nil {
+		return 0
+	}
+	return tokenizer.GetTokenCount(prompt)
 }
 
 /**
@@ -144,7 +345,10 @@ func (h *CompletionHandler) getTokensCount(prompt string) int {
  * @description
  * - 合并请求中的停用词和系统默认停用词
  * - 添加默认的FIM停用词"<｜end▁of▁sentence｜>"
- * - 如果后缀为空或只包含空白字符，添加多行停用词
+ * - 如果后缀为空或只包含空白字符，添加多行停用词；测试文件的用例之间常有空行，除非配置了blankLineStop，不添加
+ * - 微补全追加更激进的停用词(换行，标识符还有空白)，强制单行
+ * - 生成/压缩文件继续补全时追加换行停用词，强制单行
+ * - 语言回退到语言族时追加语言族的停用词
  * - 用于控制补全生成的停止条件
  * @example
  * input := &CompletionInput{
@@ -163,12 +367,33 @@ func (h *CompletionHandler) prepareStopWords(input *CompletionInput) []string {
 	}
 
 	// 添加默认的FIM停用词
-	stopWords = append(stopWords, "<｜end▁of▁sentence｜>")
+	stopWords = append(stopWords, defaultFimStop)
 
 	// 如果后缀为空，添加系统停用词
-	if input.Processed.Suffix == "" || strings.TrimSpace(input.Processed.Suffix) == "" {
+	blankLineStop := input.TestFile == nil || config.Wrapper.TestFile.BlankLineStop
+	if blankLineStop && strings.TrimSpace(input.Processed.Suffix) == "" {
 		stopWords = append(stopWords, "\n\n", "\n\n\n")
 	}
 
+	// 微补全只补全标识符或导入路径
+	if input.Shape != nil {
+		stopWords = append(stopWords, input.Shape.Stop...)
+	}
+
+	// 光标在字符串中时停在闭合引号之前，并且只补全一行
+	if input.Literal != nil {
+		stopWords = append(stopWords, input.Literal.Stop...)
+	}
+
+	// 生成/压缩文件只补全一行
+	if input.generated() {
+		stopWords = append(stopWords, "\n")
+	}
+
+	// 回退的语言族的停用词，如纯文本停在空行
+	if f := input.family(); f != nil {
+		stopWords = append(stopWords, f.Stop...)
+	}
+
 	return stopWords
 }
@@ -0,0 +1,102 @@
+package completions
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+/**
+ * 提示词前言模板的渲染数据
+ * @description
+ * - FilePath: 当前文件在项目中的路径
+ * - Language: 插件上报的语言标识
+ */
+type PreambleData struct {
+	FilePath string
+	Language string
+}
+
+// 已解析的前言模板缓存，key为模板文本
+var preambleTemplates sync.Map
+
+func getPreambleTemplate(text string) (*template.Template, error) {
+	if t, ok := preambleTemplates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("preamble").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	preambleTemplates.Store(text, t)
+	return t, nil
+}
+
+/**
+ * Render prompt preamble comment for models that infer language from content
+ * @param {string} text - Preamble template configured on the model, e.g. "Path: {{.FilePath}}\nLanguage: {{.Language}}"
+ * @param {PreambleData} data - Template data
+ * @returns {string} Returns the preamble commented with the language's syntax, or "" if skipped
+ * @description
+ * - Skipped when the template is empty, invalid or renders to blank text
+ * - Skipped when the language is unknown, since the comment syntax can't be chosen
+ * - Each rendered line is commented with the language's syntax (// vs # vs <!-- -->)
+ * @example
+ * preamble := renderPreamble("Language: {{.Language}}", PreambleData{Language: "go"})
+ * // preamble = "// Language: go"
+ */
+func renderPreamble(text string, data PreambleData) string {
+	if text == "" || data.Language == "" {
+		return ""
+	}
+	t, err := getPreambleTemplate(text)
+	if err != nil {
+		zap.L().Error("Invalid config: 'promptPreamble' is not a valid template", zap.Error(err))
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		zap.L().Warn("Render prompt preamble failed", zap.Error(err))
+		return ""
+	}
+	rendered := strings.Trim(buf.String(), "\r\n")
+	if strings.TrimSpace(rendered) == "" {
+		return ""
+	}
+	preamble, ok := profileOf(data.Language).CommentCode(rendered)
+	if !ok {
+		return ""
+	}
+	return preamble
+}
+
+/**
+ * Reserve token budget of the preamble from the prefix budget
+ * @param {int} prefixMax - Token budget shared by prefix and code context
+ * @param {int} preambleTokens - Token count of the preamble
+ * @param {string} preamble - Rendered preamble
+ * @returns {int, string} Returns the budget left for prefix/context and the preamble to keep
+ * @description
+ * - The preamble is a hint only, it never takes more than half of the budget;
+ *   when it would, it is dropped and the whole budget goes to the code
+ */
+func reservePreambleBudget(prefixMax, preambleTokens int, preamble string) (int, string) {
+	if preamble == "" || preambleTokens*2 > prefixMax {
+		return prefixMax, ""
+	}
+	return prefixMax - preambleTokens, preamble
+}
+
+// joinPreamble 将前言置于代码上下文之前
+func joinPreamble(preamble, codeContext string) string {
+	if preamble == "" {
+		return codeContext
+	}
+	if codeContext == "" {
+		return preamble
+	}
+	return preamble + "\n" + codeContext
+}
@@ -0,0 +1,55 @@
+package completions
+
+import (
+	"testing"
+)
+
+// to test comment syntax selection of the prompt preamble
+// go test ./pkg/completions/ -v -run Test_RenderPreamble
+func Test_RenderPreamble(t *testing.T) {
+	tmpl := "Path: {{.FilePath}}\nLanguage: {{.Language}}\n"
+	cases := []struct {
+		name     string
+		tmpl     string
+		data     PreambleData
+		expected string
+	}{
+		{"slash", tmpl, PreambleData{"main.go", "go"}, "// Path: main.go\n// Language: go"},
+		{"hash", tmpl, PreambleData{"a.py", "Python"}, "# Path: a.py\n# Language: Python"},
+		{"tag", tmpl, PreambleData{"a.html", "html"}, "<!-- Path: a.html -->\n<!-- Language: html -->"},
+		{"unknown language", tmpl, PreambleData{"a.xyz", "xyz"}, ""},
+		{"empty language", tmpl, PreambleData{"a.go", ""}, ""},
+		{"empty render", "{{if .FilePath}}Path: {{.FilePath}}{{end}}", PreambleData{"", "go"}, ""},
+		{"invalid template", "{{.FilePath", PreambleData{"a.go", "go"}, ""},
+		{"no template", "", PreambleData{"a.go", "go"}, ""},
+	}
+	for _, c := range cases {
+		if got := renderPreamble(c.tmpl, c.data); got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+}
+
+// to test budget accounting of the prompt preamble
+// go test ./pkg/completions/ -v -run Test_ReservePreambleBudget
+func Test_ReservePreambleBudget(t *testing.T) {
+	budget, preamble := reservePreambleBudget(512, 12, "// Language: go")
+	if budget != 500 || preamble != "// Language: go" {
+		t.Errorf("expected budget 500 with preamble, got %d %q", budget, preamble)
+	}
+	budget, preamble = reservePreambleBudget(20, 12, "// Language: go")
+	if budget != 20 || preamble != "" {
+		t.Errorf("expected preamble dropped with full budget, got %d %q", budget, preamble)
+	}
+	budget, preamble = reservePreambleBudget(512, 0, "")
+	if budget != 512 || preamble != "" {
+		t.Errorf("expected untouched budget, got %d %q", budget, preamble)
+	}
+
+	if got := joinPreamble("// Language: go", "ctx"); got != "// Language: go\nctx" {
+		t.Errorf("unexpected joined context %q", got)
+	}
+	if got := joinPreamble("// Language: go", ""); got != "// Language: go" {
+		t.Errorf("unexpected joined context %q", got)
+	}
+}
@@ -0,0 +1,79 @@
+package completions
+
+import (
+	"net/http"
+
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+)
+
+/**
+ * 由V2接口的补全参数创建补全输入
+ * @param {*model.CompletionParameter} para - V2接口的补全参数，提示词已由调用方组装好
+ * @returns {*CompletionInput} 返回补全输入，只用于各接口共用的处理，见PrepareParameter
+ * @description
+ * - V2和OpenAI接口不经过Preprocess，不获取上下文，不走过滤器链
+ * - 没有文件路径、diff、区块等信息，依赖这些信息的处理(测试文件、负结果缓存、diff还原、风格)自然跳过
+ */
+func NewParameterInput(para *model.CompletionParameter) *CompletionInput {
+	in := &CompletionInput{}
+	in.Model = para.Model
+	in.ClientID = para.ClientID
+	in.CompletionID = para.CompletionID
+	in.LanguageID = para.Language
+	in.TriggerMode = para.TriggerMode
+	in.Verbose = para.Verbose
+	in.Prompts = &PromptOptions{Prefix: para.Prefix, Suffix: para.Suffix, CodeContext: para.CodeContext}
+	in.Processed = *in.Prompts
+	in.Headers = authorizationHeader(para.Authorization)
+	return in
+}
+
+/**
+ * 由OpenAI接口的补全请求创建补全输入
+ * @param {*model.CompletionRequest} r - OpenAI v1/completions协议的补全请求
+ * @returns {*CompletionInput} 返回补全输入，没有客户端ID和completion_id，依赖它们的处理(采纳跟踪、样本、响应序号)自然跳过
+ */
+func NewOpenAIInput(r *model.CompletionRequest) *CompletionInput {
+	in := &CompletionInput{}
+	in.Model = r.Model
+	in.Prompts = &PromptOptions{Prefix: r.Prompt, Suffix: r.Suffix}
+	in.Processed = *in.Prompts
+	in.Headers = authorizationHeader(r.Authorization)
+	return in
+}
+
+// 只含Authorization的请求头，负结果缓存按它区分调用方
+func authorizationHeader(authorization string) http.Header {
+	headers := http.Header{}
+	if authorization != "" {
+		headers.Set("Authorization", authorization)
+	}
+	return headers
+}
+
+/**
+ * 对已组装好提示词的V2请求做调用模型前的处理，与V1的Preprocess共用
+ * @param {*CompletionContext} c - 补全上下文
+ * @param {*model.CompletionParameter} para - V2接口的补全参数，处理结果写回
+ * @description
+ * - 计算提示词指纹
+ * - 没有语言配置的语言回退到语言族，后置处理按语言族处理
+ * - 缩减超大的代码上下文
+ * - 调用方提供了代码上下文的，计入上下文获取的指标(provided)
+ * - 后缀策略在调用模型时按模型的配置处理，见CallLLM
+ */
+func (in *CompletionInput) PrepareParameter(c *CompletionContext, para *model.CompletionParameter) {
+	c.Perf.Fingerprint = in.ComputeFingerprint(feature_flag.FromContext(c.Ctx))
+	in.resolveFallback(c)
+	if in.Fallback != nil {
+		para.LanguageFamily = in.Fallback.Family
+	}
+	in.ReduceOversized()
+	para.CodeContext = in.Processed.CodeContext
+	if para.CodeContext != "" {
+		in.ContextOutcome = ContextProvided
+		metrics.IncrementContextFetches(in.ContextOutcome)
+	}
+}
@@ -0,0 +1,184 @@
+package completions
+
+import (
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 代码上下文的使用方式，用作completion_context_mode_*指标的mode标签
+const (
+	ContextModeUsed           = "used"            // 同步获取代码上下文
+	ContextModeSkippedPending = "skipped_pending" // 不等待代码上下文，在后台获取
+	ContextModeCacheHit       = "cache_hit"       // 使用附近位置后台获取的代码上下文
+)
+
+// 后台获取并缓存的代码上下文
+type prefetchedContext struct {
+	line     int                    // 获取时的光标行
+	context  string                 // 代码上下文
+	snippets []model.ContextSnippet // 各片段的来源
+	stripped bool                   // 隐私模式下去除了代码上下文，查找时视为未命中
+}
+
+// 隐私模式下只保留光标行和片段的来源，片段的来源不含代码
+func (c *prefetchedContext) Strip() *prefetchedContext {
+	return &prefetchedContext{line: c.line, snippets: c.snippets, stripped: true}
+}
+
+/**
+ * 渐进式代码上下文的缓存
+ * @description
+ * - 按(客户端, 项目, 文件, 光标区域)缓存后台获取的代码上下文，见config.ProgressiveConfig
+ * - 同一光标区域同时只有一个后台获取，获取中的区域记录在pending中
+ * - 由流控管理器创建，与代码上下文客户端一起注入到补全上下文，所有请求共享
+ */
+type PrefetchCache struct {
+	cfg     *config.ProgressiveConfig
+	entries *store.Store[string, *prefetchedContext]
+	pending sync.Map // 正在后台获取的区域key -> struct{}
+	wg      sync.WaitGroup
+}
+
+// 创建渐进式代码上下文的缓存
+func NewPrefetchCache(cfg *config.ProgressiveConfig) *PrefetchCache {
+	return &PrefetchCache{
+		cfg: cfg,
+		entries: store.New(store.Options[string, *prefetchedContext]{
+			Name:       "prefetched_contexts",
+			MaxEntries: cfg.MaxEntries,
+			TTL:        cfg.TTL,
+		}),
+	}
+}
+
+// 缓存是否可用，隐私模式下缓存不保存代码上下文，不可用；请求是否开启见feature_flag.Progressive
+func (p *PrefetchCache) enabled() bool {
+	return p != nil && p.cfg.RegionLines > 0 && !store.Private()
+}
+
+// 光标区域的缓存key
+func regionKey(file string, region int) string {
+	return file + "\x00" + strconv.Itoa(region)
+}
+
+/**
+ * 查找附近位置缓存的代码上下文
+ * @param {string} file - 客户端、项目和文件组成的key
+ * @param {int} line - 光标所在行
+ * @returns {*prefetchedContext} 返回光标行距离最近、且不超过NearbyLines的缓存，没有时返回nil
+ */
+func (p *PrefetchCache) lookup(file string, line int) *prefetchedContext {
+	var found *prefetchedContext
+	distance := p.cfg.NearbyLines + 1
+	first, last := max(line-p.cfg.NearbyLines, 0)/p.cfg.RegionLines, (line+p.cfg.NearbyLines)/p.cfg.RegionLines
+	for region := first; region <= last; region++ {
+		cached, ok := p.entries.Get(regionKey(file, region))
+		if !ok || cached.stripped {
+			continue
+		}
+		if d := max(cached.line-line, line-cached.line); d < distance {
+			found, distance = cached, d
+		}
+	}
+	return found
+}
+
+/**
+ * 在后台获取光标区域的代码上下文，获取完成后缓存
+ * @param {string} file - 客户端、项目和文件组成的key
+ * @param {int} line - 光标所在行
+ * @param {*zap.Logger} log - 请求级logger
+ * @param {func(context.Context) (string, []model.ContextSnippet)} fetch - 获取代码上下文
+ * @description
+ * - 该区域已有后台获取时不再获取
+ * - 总超时为配置的FetchTimeout，与补全请求的截止时间无关
+ */
+func (p *PrefetchCache) prefetch(file string, line int, log *zap.Logger, fetch func(context.Context) (string, []model.ContextSnippet)) {
+	key := regionKey(file, line/p.cfg.RegionLines)
+	if _, loaded := p.pending.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.pending.Delete(key)
+		ctx := codebase_context.WithTotalTimeout(logger.WithContext(context.Background(), log), p.cfg.FetchTimeout)
+		start := time.Now()
+		text, snippets := fetch(ctx)
+		p.entries.Put(key, &prefetchedContext{line: line, context: text, snippets: snippets})
+		log.Debug("Prefetched codebase context", zap.Int("line", line), zap.Int("bytes", len(text)),
+			zap.Duration("duration", time.Since(start)))
+	}()
+}
+
+// 等待所有后台获取完成，用于测试
+func (p *PrefetchCache) Wait() {
+	p.wg.Wait()
+}
+
+/**
+ * 渐进式获取代码上下文
+ * @param {*CompletionContext} c - 补全上下文，包含代码上下文客户端和渐进式上下文的缓存
+ * @param {string} sourcePath - 测试文件对应的被测源文件，为空时不检索
+ * @description
+ * - 附近位置有缓存的上下文时直接使用(cache_hit)，耗时记为0
+ * - 否则不等待，在后台获取该光标区域的上下文(skipped_pending)，本次请求不带上下文
+ * - 光标行按前缀中的换行数计算
+ */
+func (in *CompletionInput) progressiveContext(c *CompletionContext, sourcePath string) {
+	p := c.Prefetch
+	file := in.ClientID + "\x00" + in.Processed.ProjectPath + "\x00" + in.Processed.FileProjectPath
+	line := strings.Count(in.Processed.Prefix, "\n")
+	c.Perf.ContextDuration = 0
+	if cached := p.lookup(file, line); cached != nil {
+		in.ContextMode = ContextModeCacheHit
+		in.Processed.CodeContext = cached.context
+		// 截断时会更新片段的token数，缓存的片段由多个请求共享，使用副本
+		in.Processed.Provenance = slices.Clone(cached.snippets)
+		contributed := codebase_context.Contributed(cached.snippets)
+		in.ContextOutcome = ContextFound
+		if cached.context == "" {
+			in.ContextOutcome = ContextEmpty
+		}
+		metrics.IncrementContextFetches(in.ContextOutcome)
+		if in.TestFile != nil {
+			in.TestFile.SourceBytes = contributed[codebase_context.ProviderSourceUnderTest]
+		}
+		if in.Budget != nil {
+			in.Budget.Sections[SectionCodebaseContext].Bytes = len(in.Processed.CodeContext)
+			in.Budget.Providers = contributed
+		}
+		return
+	}
+	in.ContextMode = ContextModeSkippedPending
+	in.ContextOutcome = ContextSkipped
+	in.ContextSkip = "pending background fetch"
+	metrics.IncrementContextFetches(in.ContextOutcome)
+
+	client, processed, headers := c.ContextClient, in.Processed, in.Headers.Clone()
+	clientID, skipSemantic := in.ClientID, in.Shape != nil
+	p.prefetch(file, line, c.Log(), func(ctx context.Context) (string, []model.ContextSnippet) {
+		return client.GetContext(ctx, clientID, processed.ProjectPath, processed.FileProjectPath, sourcePath,
+			processed.Prefix, processed.Suffix, processed.ImportContent, headers, skipSemantic)
+	})
+}
+
+// 记录成功补全的总耗时，按代码上下文的使用方式，用于比较渐进式上下文的时延
+func (in *CompletionInput) ObserveContextMode(rsp *CompletionResponse) {
+	if in.ContextMode == "" || rsp == nil || rsp.Status != model.StatusSuccess {
+		return
+	}
+	metrics.ObserveContextModeDuration(in.ContextMode, rsp.Usage.TotalDuration)
+}
@@ -0,0 +1,57 @@
+package completions
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+)
+
+// to test the first request of a cursor region skips the context, and nearby requests use the context fetched in background
+// go test ./pkg/completions/ -v -run Test_ProgressiveContext
+func Test_ProgressiveContext(t *testing.T) {
+	_, restore := setupContextServer(`{"data":{"list":[{"filePath":"date.js","content":"export function formatDate() {}"}]}}`)
+	defer restore()
+	saved := config.Wrapper.Progressive.Enabled
+	defer func() { config.Wrapper.Progressive.Enabled = saved }()
+	config.Wrapper.Progressive.Enabled = true
+	prefetch := NewPrefetchCache(&config.ProgressiveConfig{Enabled: true, RegionLines: 40, NearbyLines: 20,
+		FetchTimeout: time.Second, MaxEntries: 100, TTL: time.Minute})
+	run := func(lines int) *CompletionInput {
+		in := newContextInput(false)
+		in.Prompts.Prefix = strings.Repeat("\n", lines) + in.Prompts.Prefix
+		c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+		c.ContextClient = codebase_context.NewContextClient()
+		c.Prefetch = prefetch
+		if rsp := in.Preprocess(c); rsp != nil {
+			t.Fatalf("unexpected rejection: %s", rsp.Error)
+		}
+		return in
+	}
+
+	first := run(10)
+	if first.ContextMode != ContextModeSkippedPending || first.ContextOutcome != ContextSkipped || first.Processed.CodeContext != "" {
+		t.Errorf("expected the first request not to wait, got %s %s", first.ContextMode, first.ContextOutcome)
+	}
+	out := &CompletionResponse{}
+	first.AttachVerbose(out)
+	if note, ok := out.Verbose.Input["context"].(map[string]interface{}); !ok || note["mode"] != ContextModeSkippedPending {
+		t.Errorf("expected the mode noted in verbose, got %v", out.Verbose.Input["context"])
+	}
+	prefetch.Wait()
+
+	nearby := run(14)
+	if nearby.ContextMode != ContextModeCacheHit || nearby.ContextOutcome != ContextFound || nearby.Processed.CodeContext == "" {
+		t.Errorf("expected the prefetched context used, got %s %s", nearby.ContextMode, nearby.ContextOutcome)
+	}
+
+	// 距离超过NearbyLines的位置重新在后台获取
+	far := run(100)
+	if far.ContextMode != ContextModeSkippedPending || far.Processed.CodeContext != "" {
+		t.Errorf("expected a far cursor to skip the context, got %s", far.ContextMode)
+	}
+	prefetch.Wait()
+}
@@ -0,0 +1,89 @@
+package completions
+
+import (
+	"code-completion/pkg/model"
+)
+
+/**
+ * 按截断结果更新代码库上下文各片段的来源
+ * @param {[]model.ContextSnippet} snippets - 片段的来源，Offset/Bytes为在截断前的上下文中的位置
+ * @param {int} total - 截断前上下文的字节数
+ * @param {int} kept - 截断后保留的上下文末尾的字节数，不含截断标记
+ * @param {int} tokens - 截断前上下文的token数
+ * @param {int} keptTokens - 截断后保留的上下文的token数，不含截断标记
+ * @param {int} trailing - 最终的上下文(含围栏)中在检索上下文之后的字节数，见fenceTrailing
+ * @param {bool} markers - 是否开启了截断标记，开启时保留没有进入提示词的片段及其原因
+ * @returns {[]model.ContextSnippet} 返回按在上下文中的顺序排列的片段
+ * @description
+ * - 上下文从开头截断，开始位置在保留部分之前的片段只保留了末尾，结束位置也在之前的片段被整个截掉(budget)
+ * - 各片段的token数按字节占比从上下文的token数换算，与预算报告中各检索的token数一致
+ * - 没有开启截断标记时只返回进入了提示词的片段
+ * - 同时记录各片段保留的字节数(KeptBytes)及其末尾到最终上下文末尾的字节数(Tail)，超长重试时见retraceSnippets
+ */
+func traceSnippets(snippets []model.ContextSnippet, total, kept, tokens, keptTokens, trailing int, markers bool) []model.ContextSnippet {
+	if len(snippets) == 0 {
+		return snippets
+	}
+	cut := total - kept
+	result := snippets[:0]
+	for _, s := range snippets {
+		if s.Dropped == "" {
+			keptBytes := min(max(s.Offset+s.Bytes-cut, 0), s.Bytes)
+			if total > 0 {
+				s.Tokens = tokens * s.Bytes / total
+			}
+			if kept > 0 {
+				s.Kept = keptTokens * keptBytes / kept
+			}
+			if keptBytes == 0 {
+				s.Dropped, s.Kept = model.SnippetBudget, 0
+			}
+			s.KeptBytes, s.Tail = keptBytes, total-s.Offset-s.Bytes+trailing
+		}
+		if s.Dropped == "" || markers {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+/**
+ * 超长重试重新截断上下文后更新各片段的来源
+ * @param {[]model.ContextSnippet} snippets - 首次截断后的片段来源，见traceSnippets
+ * @param {int} tail - 重新截断后仍保留的原上下文末尾的字节数，见commonSuffixLen
+ * @param {bool} markers - 是否开启了截断标记，开启时保留没有进入提示词的片段及其原因
+ * @returns {[]model.ContextSnippet} 返回新的片段来源，不修改传入的切片
+ * @description
+ * - 重新截断同样从上下文开头截掉，片段保留的部分只剩末尾tail字节内的部分，整个被截掉的片段记为budget
+ * - 保留的token数按保留字节数的比例换算
+ * @example
+ * snippets = retraceSnippets(snippets, commonSuffixLen(oldContext, newContext), false)
+ */
+func retraceSnippets(snippets []model.ContextSnippet, tail int, markers bool) []model.ContextSnippet {
+	result := make([]model.ContextSnippet, 0, len(snippets))
+	for _, s := range snippets {
+		if s.Dropped == "" {
+			keptBytes := min(max(tail-s.Tail, 0), s.KeptBytes)
+			if s.KeptBytes > 0 {
+				s.Kept = s.Kept * keptBytes / s.KeptBytes
+			}
+			s.KeptBytes = keptBytes
+			if keptBytes == 0 {
+				s.Dropped, s.Kept = model.SnippetBudget, 0
+			}
+		}
+		if s.Dropped == "" || markers {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// commonSuffixLen 返回两个字符串相同的末尾部分的字节数
+func commonSuffixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,133 @@
+package completions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test the provenance of context snippets from three providers surviving dedup and truncation
+// go test ./pkg/completions/ -v -run Test_ContextProvenance
+func Test_ContextProvenance(t *testing.T) {
+	_, restore := setupContextServer(`{"data":{"list":[]}}`)
+	defer restore()
+	defer setupPruneRetry(config.PruneRetryConfig{})()
+	defer setupContextCap(1)()
+	bodies := map[string]string{
+		"/definition": `{"data":{"list":[` +
+			`{"filePath":"a.js","name":"a","content":"export function a() {\n  return '` + strings.Repeat("a", 80) + `';\n}"},` +
+			`{"filePath":"b.js","name":"b","content":"export function b() { return 'bbbbbbbbbbbbbbbbbbbb'; }"}]}}`,
+		"/semantic": `{"data":{"list":[` +
+			`{"filePath":"b.js","content":"export function b() { return 'bbbbbbbbbbbbbbbbbbbb'; }","score":0.5},` +
+			`{"filePath":"c.js","content":"export const c = 'cccccccccccccccccccc';","score":0.9}]}}`,
+		"/relation": `{"data":{"list":[{"filePath":"d.js","content":"export const d = 'dddddddddddddddddddd';","score":0.7}]}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(bodies[r.URL.Path]))
+	}))
+	defer server.Close()
+	config.Context.Definition.Url = server.URL + "/definition"
+	config.Context.Semantic.Url = server.URL + "/semantic"
+	config.Context.Relation.Url = server.URL + "/relation"
+
+	run := func(markers bool) (*CompletionInput, []model.ContextSnippet) {
+		in := newContextInput(false)
+		in.Verbose = true
+		c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+		c.ContextClient = codebase_context.NewContextClient()
+		if rsp := in.Preprocess(c); rsp != nil {
+			t.Fatalf("unexpected rejection: %s", rsp.Error)
+		}
+		// 截掉a.js的整个片段和b.js片段的前一半
+		var b model.ContextSnippet
+		for _, s := range in.Processed.Provenance {
+			if s.Provider == codebase_context.ProviderDefinition && s.FilePath == "b.js" {
+				b = s
+			}
+		}
+		keep := len(in.Processed.CodeContext) - b.Offset - b.Bytes/2
+		if markers {
+			keep += len("// ... earlier context omitted ...\n")
+		}
+		cfg := config.ModelConfig{ModelName: "provenance", MaxPrefix: len(in.Processed.Prefix) + 1 + keep, MaxSuffix: 100,
+			MaxOutput: 32, TruncationMarkers: markers}
+		h := NewCompletionHandler(newByteTokenizerLLM(t, cfg, "new Date()"))
+		rsp := h.CallLLM(c, h.Adapt(in))
+		if rsp.Verbose == nil || rsp.Verbose.Budget == nil {
+			t.Fatal("expected a budget report")
+		}
+		return in, rsp.Verbose.Budget.Snippets
+	}
+
+	in, snippets := run(true)
+	type origin struct{ provider, file, dropped, duplicateOf string }
+	expected := []origin{
+		{codebase_context.ProviderDefinition, "a.js", model.SnippetBudget, ""},
+		{codebase_context.ProviderDefinition, "b.js", "", ""},
+		{codebase_context.ProviderSemantic, "c.js", "", ""},
+		{codebase_context.ProviderSemantic, "b.js", model.SnippetDedup, codebase_context.ProviderDefinition},
+		{codebase_context.ProviderRelation, "d.js", "", ""},
+	}
+	if len(snippets) != len(expected) {
+		t.Fatalf("expected %d snippets, got %+v", len(expected), snippets)
+	}
+	for i, s := range snippets {
+		if (origin{s.Provider, s.FilePath, s.Dropped, s.DuplicateOf}) != expected[i] {
+			t.Errorf("snippet %d: expected %+v, got %+v", i, expected[i], s)
+		}
+	}
+	a, b, c, dup, d := snippets[0], snippets[1], snippets[2], snippets[3], snippets[4]
+	if a.Tokens != a.Bytes || a.Kept != 0 {
+		t.Errorf("expected the dropped snippet to keep no tokens, got %+v", a)
+	}
+	if b.Kept <= 0 || b.Kept >= b.Tokens {
+		t.Errorf("expected the snippet partially kept, got %+v", b)
+	}
+	if c.Score != 0.9 || c.Kept != c.Tokens || d.Score != 0.7 || d.Kept != d.Tokens {
+		t.Errorf("unexpected kept snippets %+v %+v", c, d)
+	}
+	if dup.Score != 0.5 || dup.Bytes != 0 || dup.Tokens != 0 {
+		t.Errorf("unexpected duplicate snippet %+v", dup)
+	}
+	if !strings.Contains(in.Processed.CodeContext, "// d.js") || strings.Contains(in.Processed.CodeContext, "a.js") {
+		t.Errorf("unexpected truncated context %q", in.Processed.CodeContext)
+	}
+
+	// 保留的部分按Tail和KeptBytes定位在最终的上下文(含围栏)中
+	final := in.Processed.CodeContext
+	for _, s := range []model.ContextSnippet{c, d} {
+		kept := final[len(final)-s.Tail-s.KeptBytes : len(final)-s.Tail]
+		if !strings.Contains(kept, strings.Repeat(s.FilePath[:1], 20)) {
+			t.Errorf("snippet %s located at %q", s.FilePath, kept)
+		}
+	}
+
+	// 超长重试从开头截到d.js片段的开始，前面保留的片段都被截掉
+	retried := retraceSnippets(snippets, commonSuffixLen(final, final[len(final)-d.Tail-d.KeptBytes:]), true)
+	if len(retried) != len(snippets) || retried[1].Dropped != model.SnippetBudget || retried[2].Dropped != model.SnippetBudget ||
+		retried[4].Dropped != "" || retried[4].Kept != d.Kept {
+		t.Errorf("unexpected retried snippets %+v", retried)
+	}
+	if snippets[1].Dropped != "" {
+		t.Error("retraceSnippets modified the first report")
+	}
+	// 只截掉c.js片段的一半
+	retried = retraceSnippets(snippets, c.Tail+c.KeptBytes/2, false)
+	if len(retried) != 2 || retried[0].FilePath != "c.js" || retried[0].Kept <= 0 || retried[0].Kept >= c.Kept || retried[1].Kept != d.Kept {
+		t.Errorf("expected c.js partially kept, got %+v", retried)
+	}
+
+	// 没有开启截断标记时只报告进入了提示词的片段
+	_, snippets = run(false)
+	if len(snippets) != 3 || snippets[0].FilePath != "b.js" || snippets[1].FilePath != "c.js" || snippets[2].FilePath != "d.js" {
+		t.Errorf("expected only the snippets in the prompt, got %+v", snippets)
+	}
+}
@@ -0,0 +1,72 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+
+	"go.uber.org/zap"
+)
+
+// 重复和重叠处理器阈值的默认值，见config.PruneParams
+var defaultPruneParams = config.PruneParams{
+	RepetitionMinLines: 3,
+	RepetitionRatio:    0.15,
+	ExtremeMinLines:    5,
+	ExtremeCount:       8,
+	ExtremeSimilarity:  0.5,
+	OverlapCutLine:     3,
+	IgnoreOverlapLen:   8,
+}
+
+// ResolvePruneParams 为0的阈值使用默认值
+func ResolvePruneParams(p config.PruneParams) config.PruneParams {
+	d := defaultPruneParams
+	if p.RepetitionMinLines <= 0 {
+		p.RepetitionMinLines = d.RepetitionMinLines
+	}
+	if p.RepetitionRatio <= 0 {
+		p.RepetitionRatio = d.RepetitionRatio
+	}
+	if p.ExtremeMinLines <= 0 {
+		p.ExtremeMinLines = d.ExtremeMinLines
+	}
+	if p.ExtremeCount <= 0 {
+		p.ExtremeCount = d.ExtremeCount
+	}
+	if p.ExtremeSimilarity <= 0 {
+		p.ExtremeSimilarity = d.ExtremeSimilarity
+	}
+	if p.OverlapCutLine <= 0 {
+		p.OverlapCutLine = d.OverlapCutLine
+	}
+	if p.IgnoreOverlapLen <= 0 {
+		p.IgnoreOverlapLen = d.IgnoreOverlapLen
+	}
+	return p
+}
+
+// 处理器使用的阈值，上下文没有指定时使用配置
+func (ctx *PrunerContext) params() config.PruneParams {
+	if ctx.Params != nil {
+		return ResolvePruneParams(*ctx.Params)
+	}
+	return ResolvePruneParams(config.Wrapper.Prune.Params)
+}
+
+/**
+ * 用生产的full模式处理器链修剪一条补全，用于离线评估处理器的阈值
+ * @param {*config.PruneParams} params - 阈值，为nil时使用配置
+ * @param {*model.CompletionParameter} para - 录制的请求参数，使用其中的语言、语言族、区块、前后缀、代码风格、字符串字面量和参数列表
+ * @param {string} completion - 模型输出的补全内容
+ * @returns {*PruneResult} 返回修剪结果
+ * @description
+ * - 与pruneCompletionCode按同样的方式选择处理器链(含配置的自定义链和语言族的链)，不输出处理器的日志
+ * - 修剪模式固定为full，快速路径的请求同样使用快速路径的链
+ */
+func PruneOffline(params *config.PruneParams, para *model.CompletionParameter, completion string) *PruneResult {
+	p := *para
+	p.PruneMode = PruneFull
+	chain, ctx := newPrunerRun(&p, completion)
+	ctx.Params, ctx.Logger = params, zap.NewNop()
+	return chain.Process(ctx)
+}
@@ -1,9 +1,18 @@
 package completions
 
 import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
 	"code-completion/pkg/parser"
+	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
 )
 
 /**
@@ -33,10 +42,15 @@ const (
 	DiscardSyntaxError       string = "discard-syntax_error"
 	DicardCssContent         string = "discard-css_content"
 	CutSingleLine            string = "cut-single-line"
+	CutFirstLineIndent       string = "cut-first_line_indent"
 	CutRepetitiveText        string = "cut-repetitive_text"
 	CutPrefixOverlap         string = "cut-prefix_overlap"
 	CutSuffixOverlap         string = "cut-suffix_overlap"
 	CutSyntaxError           string = "cut-syntax_error"
+	CutIndentStyle           string = "cut-indent_style"
+	CutQuoteStyle            string = "cut-quote_style"
+	CutDuplicateArgument     string = "cut-duplicate_argument"
+	CutTerminalPunctuation   string = "cut-terminal_punctuation"
 )
 
 /**
@@ -59,10 +73,15 @@ var prunerDefs map[string]Pruner = map[string]Pruner{
 	DiscardInvalidBrackets:   &InvalidBracketsDiscarder{},
 	DicardCssContent:         &CssContentDiscarder{},
 	CutSingleLine:            &SingleLineCutter{},
+	CutFirstLineIndent:       &FirstLineIndentCutter{},
 	CutRepetitiveText:        &RepetitiveTextCutter{},
 	CutPrefixOverlap:         &PrefixOverlapCutter{},
 	CutSuffixOverlap:         &SuffixOverlapCutter{},
 	CutSyntaxError:           &SyntaxErrorCutter{},
+	CutIndentStyle:           &IndentStyleCutter{},
+	CutQuoteStyle:            &QuoteStyleCutter{},
+	CutDuplicateArgument:     &ArgumentCutter{},
+	CutTerminalPunctuation:   &TerminalPunctuationCutter{},
 }
 
 /**
@@ -72,6 +91,8 @@ var prunerDefs map[string]Pruner = map[string]Pruner{
  * - 包含语言类型、补全代码、前缀和后缀
  * - 用于在处理器链中传递数据和状态
  * - 处理器可以修改CompletionCode字段
+ * - 补全结束清理使用停用词、哨兵词和结束原因(Stop/Sentinels/FinishReason)
+ * - 裁剪器在裁剪时记录锚点信息(Anchor)，告知插件补全内容的准确插入位置
  * @example
  * ctx := &PrunerContext{
  *     Language: "python",
@@ -81,11 +102,54 @@ var prunerDefs map[string]Pruner = map[string]Pruner{
  * }
  */
 type PrunerContext struct {
-	CompletionID   string `json:"completion_id"`
-	Language       string `json:"language"`
-	CompletionCode string `json:"completion_code"`
-	Prefix         string `json:"prefix"`
-	Suffix         string `json:"suffix"`
+	CompletionID   string              `json:"completion_id"`
+	Language       string              `json:"language"`
+	Block          string              `json:"block"`
+	CompletionCode string              `json:"completion_code"`
+	Prefix         string              `json:"prefix"`
+	Suffix         string              `json:"suffix"`
+	Stop           []string            `json:"stop"`          // 生效的停用词
+	Sentinels      []string            `json:"sentinels"`     // 模型的哨兵词(FIM标记等)
+	FinishReason   string              `json:"finish_reason"` // 模型结束生成的原因
+	Anchor         CompletionAnchor    `json:"anchor"`
+	Style          *model.StyleProfile `json:"style"`     // 推断的代码风格，为nil时不做风格规范化
+	Literal        string              `json:"literal"`   // 光标所在字符串字面量的种类，补全是字符串片段时不做语法检查
+	Arguments      *model.ArgumentList `json:"arguments"` // 光标所在调用的参数列表，为nil时不按参数列表裁剪
+	SyntaxLanguage string              `json:"syntax"`    // 语法检查使用的语言，为空时使用Language；回退到语言族时为语言族名称
+	Params         *config.PruneParams `json:"-"`         // 重复和重叠处理器的阈值，为nil时使用配置wrapper.prune.params，见params
+	Rule           string              `json:"-"`         // 命中的处理器生效的规则，由处理器设置，记录在PrunerHit.Rule中
+	Logger         *zap.Logger         `json:"-"`
+	Ctx            context.Context     `json:"-"` // 请求上下文，耗时的处理器(如语法错误裁剪)取消后停止处理
+}
+
+// 语法检查使用的语言
+func (ctx *PrunerContext) syntaxLanguage() string {
+	if ctx.SyntaxLanguage != "" {
+		return ctx.SyntaxLanguage
+	}
+	return ctx.Language
+}
+
+// 严格截止时间模式下看门狗已到触发时间，剩余的处理器(从name开始)不再执行
+func (ctx *PrunerContext) expired(name string) bool {
+	if !StrictDeadlineFrom(ctx.Ctx).Expired() {
+		return false
+	}
+	ctx.log().Debug("Strict deadline reached, skip remaining pruners", zap.String("pruner", name))
+	return true
+}
+
+// 严格截止时间模式下报告处理到目前的补全内容，看门狗触发时返回
+func (ctx *PrunerContext) offer() {
+	StrictDeadlineFrom(ctx.Ctx).Offer("", ctx.CompletionCode, ctx.Anchor)
+}
+
+// 后置处理器使用的logger，未设置时使用全局logger
+func (ctx *PrunerContext) log() *zap.Logger {
+	if ctx.Logger == nil {
+		return zap.L()
+	}
+	return ctx.Logger
 }
 
 /**
@@ -96,6 +160,7 @@ type PrunerContext struct {
  * - Name: 返回处理器名称，用于标识和调试
  * - Type: 返回处理器类型，决定处理行为
  * - 支持多种处理器的统一接口
+ * - 处理器实例被所有请求并发共用，不能保存状态，请求相关的数据只能放在PrunerContext中
  * @example
  * type MyPruner struct{}
  *
@@ -127,19 +192,61 @@ type Pruner interface {
  * @description
  * - 管理一组后置处理器的执行链
  * - 分别管理丢弃器和裁剪器
- * - 记录命中的处理器列表
+ * - 链和处理器都不保存请求状态，命中的处理器记录在每次Process返回的结果中
+ * - 同一条链可以被并发的请求共用，启动时创建一次
  * - 按顺序执行处理器，支持提前终止
  * @example
  * chain := NewDefaultPrunerChain()
- * modified := chain.Process(ctx)
- * if modified {
+ * result := chain.Process(ctx)
+ * if result.Modified {
  *     log.Println("补全内容被后置处理器修改")
  * }
  */
 type PrunerChain struct {
-	discarders    []Pruner
-	cutters       []Pruner
-	hitProcessors []string
+	discarders []Pruner
+	cutters    []Pruner
+}
+
+/**
+ * 一个命中的后置处理器
+ * @description
+ * - Delta: 处理前后补全内容的字节数变化，裁剪为负数，丢弃为补全内容字节数的相反数
+ * - Rule: 处理器按规则处理时生效的规则(如末尾标点规范化)，其它处理器为空
+ */
+type PrunerHit struct {
+	Name  string     `json:"name"`
+	Type  PrunerType `json:"type"`
+	Delta int        `json:"delta"`
+	Rule  string     `json:"rule,omitempty"`
+}
+
+/**
+ * 一次后置处理的结果
+ * @description
+ * - Code/Anchor: 处理后的补全内容和锚点信息，与PrunerContext中的相同
+ * - Modified: 是否对补全内容进行了修改
+ * - Discarded: 是否被丢弃器整体丢弃
+ * - Hits: 按执行顺序排列的命中的处理器，每个处理器最多出现一次
+ */
+type PruneResult struct {
+	Code      string
+	Anchor    CompletionAnchor
+	Modified  bool
+	Discarded bool
+	Hits      []PrunerHit
+}
+
+// 命中的处理器名称，按执行顺序；有生效的规则时为"名称:规则"
+func (r *PruneResult) HitNames() []string {
+	names := make([]string, 0, len(r.Hits))
+	for _, hit := range r.Hits {
+		if hit.Rule != "" {
+			names = append(names, hit.Name+":"+hit.Rule)
+			continue
+		}
+		names = append(names, hit.Name)
+	}
+	return names
 }
 
 /**
@@ -149,7 +256,7 @@ type PrunerChain struct {
  * @returns {*PrunerChain} 返回初始化好的处理器链
  * @description
  * - 使用提供的丢弃器和裁剪器创建处理器链
- * - 初始化命中处理器列表为空
+ * - 重复的处理器(名称相同)只保留第一个
  * - 返回可执行的处理器链实例
  * - 用于自定义处理器组合
  * @example
@@ -158,10 +265,20 @@ type PrunerChain struct {
  * chain := NewPrunerChain(discarders, cutters)
  */
 func NewPrunerChain(discarders, cutters []Pruner) *PrunerChain {
+	seen := make(map[string]bool)
+	unique := func(pruners []Pruner) []Pruner {
+		result := make([]Pruner, 0, len(pruners))
+		for _, p := range pruners {
+			if !seen[p.Name()] {
+				seen[p.Name()] = true
+				result = append(result, p)
+			}
+		}
+		return result
+	}
 	return &PrunerChain{
-		discarders:    discarders,
-		cutters:       cutters,
-		hitProcessors: make([]string, 0),
+		discarders: unique(discarders),
+		cutters:    unique(cutters),
 	}
 }
 
@@ -206,7 +323,7 @@ func NewPrunerChainByNames(names []string) (*PrunerChain, error) {
  * @description
  * - 创建包含标准处理器的默认链
  * - 丢弃器包含：极端重复、语言不匹配、语法错误
- * - 裁剪器包含：重复文本、前缀重叠、后缀重叠、语法错误
+ * - 裁剪器包含：缩进风格、引号风格、首行缩进、重复文本、前缀重叠、重复参数、后缀重叠、语法错误、末尾标点
  * - 用于大多数常规补全场景
  * @example
  * chain := NewDefaultPrunerChain()
@@ -215,39 +332,155 @@ func NewPrunerChainByNames(names []string) (*PrunerChain, error) {
 func NewDefaultPrunerChain() *PrunerChain {
 	return NewPrunerChain(
 		[]Pruner{
-			&ExtremeRepetitionDiscarder{},
-			&NotMatchLanguageDiscarder{},
-			&SyntaxErrorDiscarder{},
+			prunerDefs[DiscardExtremeRepetition],
+			prunerDefs[DiscardNotMatchLanguage],
+			prunerDefs[DiscardSyntaxError],
 		},
 		[]Pruner{
-			&RepetitiveTextCutter{},
-			&PrefixOverlapCutter{},
-			&SuffixOverlapCutter{},
-			&SyntaxErrorCutter{},
+			prunerDefs[CutIndentStyle],
+			prunerDefs[CutQuoteStyle],
+			prunerDefs[CutFirstLineIndent],
+			prunerDefs[CutRepetitiveText],
+			prunerDefs[CutPrefixOverlap],
+			prunerDefs[CutDuplicateArgument],
+			prunerDefs[CutSuffixOverlap],
+			prunerDefs[CutSyntaxError],
+			prunerDefs[CutTerminalPunctuation],
 		},
 	)
 }
 
+/**
+ * 创建light修剪模式使用的后置处理器链
+ * @returns {*PrunerChain} 返回只包含极端重复丢弃器的处理器链
+ * @description
+ * - 用于客户端要求按原样重新生成代码的场景(如重复前文的重构)
+ * - 不做前后缀重叠、重复文本等裁剪，只丢弃明显失控的输出
+ * @example
+ * chain := NewLightPrunerChain()
+ * result := chain.Process(ctx)
+ */
+func NewLightPrunerChain() *PrunerChain {
+	return NewPrunerChain([]Pruner{prunerDefs[DiscardExtremeRepetition]}, []Pruner{})
+}
+
+// 按修剪模式使用的处理器链，启动时创建
+type prunerChainSet struct {
+	full        *PrunerChain
+	light       *PrunerChain
+	fast        *PrunerChain // 快速路径的处理器链，由full链去掉多行和语法检查处理器得到
+	pythonRules []string     // 判断补全为python代码的特征文本，见IsPythonText
+}
+
+// 没有配置pythonTextRules时使用的特征文本
+var defaultPythonTextRules = []string{"return self.name"}
+
+// 按配置创建的python代码特征文本，未初始化时使用默认值
+func pythonTextRules() []string {
+	if set := prunerChains.Load(); set != nil {
+		return set.pythonRules
+	}
+	return defaultPythonTextRules
+}
+
+var prunerChains atomic.Pointer[prunerChainSet]
+
+// 默认的各修剪模式处理器链
+func newPrunerChainSet() *prunerChainSet {
+	full := NewDefaultPrunerChain()
+	return &prunerChainSet{full: full, light: NewLightPrunerChain(), fast: NewFastPrunerChain(full), pythonRules: defaultPythonTextRules}
+}
+
+/**
+ * 按配置创建各修剪模式使用的处理器链，启动时调用
+ * @param {*config.PruneConfig} cfg - 配置wrapper.prune
+ * @returns {error} 配置的处理器名称无效时返回错误，仍使用之前的处理器链
+ * @description
+ * - full模式使用配置的Pruners，未配置时使用默认链
+ * - light模式只使用极端重复丢弃器
+ * - 快速路径使用full链去掉多行和语法检查处理器后的链
+ * - 之后的请求共用这些链，不再逐个请求创建和校验
+ * - python代码的特征文本也在此时确定，不再在每次判断时读取
+ */
+func InitPrunerChains(cfg *config.PruneConfig) error {
+	set := newPrunerChainSet()
+	if len(cfg.PythonTextRules) > 0 {
+		set.pythonRules = cfg.PythonTextRules
+	}
+	if len(cfg.Pruners) > 0 {
+		chain, err := NewPrunerChainByNames(cfg.Pruners)
+		if err != nil {
+			return fmt.Errorf("wrapper.prune.pruners: %w", err)
+		}
+		set.full, set.fast = chain, NewFastPrunerChain(chain)
+	}
+	prunerChains.Store(set)
+	return nil
+}
+
+// 修剪模式使用的处理器链，未初始化时按当前配置创建(配置无效时使用默认链)
+func prunerChainFor(mode string) *PrunerChain {
+	set := loadPrunerChains()
+	if mode == PruneLight {
+		return set.light
+	}
+	return set.full
+}
+
+// 快速路径full模式使用的处理器链
+func fastPrunerChain() *PrunerChain {
+	return loadPrunerChains().fast
+}
+
+// 启动时创建的处理器链，未初始化时按当前配置创建
+func loadPrunerChains() *prunerChainSet {
+	set := prunerChains.Load()
+	if set == nil {
+		if err := InitPrunerChains(&config.Wrapper.Prune); err != nil {
+			zap.L().Error("Invalid config, using the default pruner chain", zap.Error(err))
+			prunerChains.CompareAndSwap(nil, newPrunerChainSet())
+		}
+		set = prunerChains.Load()
+	}
+	return set
+}
+
 /*
 *
 * 处理丢弃类型的处理器
 * @param {*PrunerContext} ctx - 后置处理器上下文
+* @param {*PruneResult} result - 本次处理的结果，记录命中的处理器
 * @returns {bool} 返回是否触发了丢弃处理
 * @description
 * - 按顺序执行所有丢弃类型处理器
 * - 如果任何一个处理器返回true，立即停止处理
 * - 记录命中的处理器名称
+* - 处理器没有丢弃但修改了内容(如语法错误恢复了开头的区域)时，也记录命中和内容变化，标记为修改
+* - 严格截止时间模式下看门狗到触发时间后不再执行剩余的处理器，每次修改后报告目前的内容，见StrictDeadline
 * - 返回是否触发了丢弃
 * - 内部方法，由Process方法调用
 * @example
 // 通常不直接调用，由Process方法内部使用
 */
-func (c *PrunerChain) processDiscard(ctx *PrunerContext) bool {
+func (c *PrunerChain) processDiscard(ctx *PrunerContext, result *PruneResult) bool {
 	for _, dicarder := range c.discarders {
+		if ctx.expired(dicarder.Name()) {
+			return false
+		}
+		before := len(ctx.CompletionCode)
+		ctx.Rule = ""
 		if dicarder.Process(ctx) {
-			c.hitProcessors = append(c.hitProcessors, dicarder.Name())
+			ctx.log().Debug("Completion discarded by pruner", zap.String("pruner", dicarder.Name()))
+			result.Hits = append(result.Hits, PrunerHit{Name: dicarder.Name(), Type: TypeDiscarder, Delta: -len(ctx.CompletionCode)})
 			return true
 		}
+		if len(ctx.CompletionCode) != before {
+			ctx.offer()
+			ctx.log().Debug("Completion recovered by pruner", zap.String("pruner", dicarder.Name()),
+				zap.String("rule", ctx.Rule), zap.String("code", ctx.CompletionCode))
+			result.Hits = append(result.Hits, PrunerHit{Name: dicarder.Name(), Type: TypeDiscarder, Delta: len(ctx.CompletionCode) - before, Rule: ctx.Rule})
+			result.Modified = true
+		}
 	}
 	return false
 }
@@ -256,82 +489,85 @@ func (c *PrunerChain) processDiscard(ctx *PrunerContext) bool {
 *
 * 处理裁剪类型的处理器
 * @param {*PrunerContext} ctx - 后置处理器上下文
+* @param {*PruneResult} result - 本次处理的结果，记录命中的处理器和内容变化
 * @returns {bool} 返回是否进行了裁剪修改
 * @description
 * - 按顺序执行所有裁剪类型处理器
-* - 记录所有命中的处理器名称
+* - 记录所有命中的处理器名称和处理前后的字节数变化
 * - 返回是否进行了任何裁剪修改
 * - 即使一个处理器修改了内容，仍会继续执行其他处理器
+* - 严格截止时间模式下看门狗到触发时间后不再执行剩余的处理器
 * - 内部方法，由Process方法调用
 * @example
 // 通常不直接调用，由Process方法内部使用
 */
-func (c *PrunerChain) processCut(ctx *PrunerContext) bool {
-	result := false
+func (c *PrunerChain) processCut(ctx *PrunerContext, result *PruneResult) bool {
+	modified := false
 	for _, cutter := range c.cutters {
+		if ctx.expired(cutter.Name()) {
+			break
+		}
+		before := len(ctx.CompletionCode)
+		ctx.Rule = ""
 		if cutter.Process(ctx) {
-			c.hitProcessors = append(c.hitProcessors, cutter.Name())
-			result = true
+			ctx.offer()
+			ctx.log().Debug("Completion cut by pruner", zap.String("pruner", cutter.Name()),
+				zap.String("code", ctx.CompletionCode))
+			result.Hits = append(result.Hits, PrunerHit{Name: cutter.Name(), Type: TypeCutter, Delta: len(ctx.CompletionCode) - before, Rule: ctx.Rule})
+			modified = true
 		}
 	}
-	return result
+	return modified
 }
 
 /**
  * 执行完整的后置处理流程
  * @param {*PrunerContext} ctx - 后置处理器上下文
- * @returns {bool} 返回是否对补全内容进行了修改
+ * @returns {*PruneResult} 返回本次处理的结果
  * @description
  * - 首先执行丢弃类型处理器
- * - 如果触发丢弃，清空补全内容并返回true
+ * - 如果触发丢弃，清空补全内容
  * - 否则执行裁剪类型处理器
- * - 最后去除补全内容末尾的空白字符
- * - 返回是否进行了任何修改
+ * - 最后去除补全内容末尾的空白字符，并根据最终的补全内容校正锚点信息
+ * - 处理后的内容同时写回ctx，链本身不记录任何状态，可以并发调用
  * @example
  * chain := NewDefaultPrunerChain()
  * ctx := &PrunerContext{
  *     CompletionCode: "  function test() { return; }  ",
  *     Language: "javascript",
  * }
- * modified := chain.Process(ctx)
- * // ctx.CompletionCode = "function test() { return; }" (去除末尾空白)
- * // modified = true
+ * result := chain.Process(ctx)
+ * // result.Code = "function test() { return; }" (去除末尾空白)
  */
-func (c *PrunerChain) Process(ctx *PrunerContext) bool {
+func (c *PrunerChain) Process(ctx *PrunerContext) *PruneResult {
+	result := &PruneResult{}
 	// 先处理内容丢弃情况，再处理内容裁剪情况
-	if c.processDiscard(ctx) {
+	if c.processDiscard(ctx, result) {
 		ctx.CompletionCode = ""
-		return true
+		ctx.Anchor = CompletionAnchor{}
+		result.Modified, result.Discarded = true, true
+		ctx.offer()
+		return result
 	}
 
-	result := c.processCut(ctx)
+	if c.processCut(ctx, result) {
+		result.Modified = true
+	}
 
 	// 后置验证：去除补全内容末尾的空格
 	if ctx.CompletionCode != "" {
 		ctx.CompletionCode = strings.TrimRight(ctx.CompletionCode, " \t\n\r")
 	}
+	// 后续裁剪器可能去掉了重新生成的行后缀
+	if ctx.Anchor.ReplaceLineSuffix && !endsWithLineSuffix(ctx.CompletionCode, ctx.Suffix) {
+		ctx.Anchor.ReplaceLineSuffix = false
+	}
+	ctx.Anchor.CursorOffset = utf8.RuneCountInString(ctx.CompletionCode)
 
+	result.Code, result.Anchor = ctx.CompletionCode, ctx.Anchor
 	return result
 }
 
-/**
- * 获取命中的处理器列表
- * @returns {[]string} 返回命中的处理器名称列表
- * @description
- * - 返回在Process执行过程中命中的处理器名称
- * - 包含所有返回true的处理器
- * - 用于调试和监控处理器的执行情况
- * - 每次Process调用后会重置
- * @example
- * chain.Process(ctx)
- * hitProcessors := chain.GetHitProcessors()
- * fmt.Println("命中的处理器:", hitProcessors)
- * // 输出: [discard-extreme_repetition cut-repetitive_text]
- */
-func (c *PrunerChain) GetHitProcessors() []string {
-	return c.hitProcessors
-}
-
 // ------------------------------------------------------------------------------
 //
 //	Pruners
@@ -411,7 +647,8 @@ type ExtremeRepetitionDiscarder struct{ Discarder }
 
 func (p *ExtremeRepetitionDiscarder) Process(ctx *PrunerContext) bool {
 	// 极端重复内容丢弃
-	flag, _, _ := isExtremeRepetition(ctx.CompletionCode)
+	params := ctx.params()
+	flag, _, _ := isExtremeRepetition(ctx.Language, ctx.CompletionCode, &params)
 	if !flag {
 		return false
 	}
@@ -427,7 +664,7 @@ func (p *ExtremeRepetitionDiscarder) Name() string {
  * 非匹配语言补全丢弃处理器
  * @description
  * - 检测并丢弃与目标语言不匹配的补全
- * - 特别检测非Python语言但生成Python代码的情况
+ * - 特别检测非Python语言但生成Python代码的情况，语言配置AllowPythonText的语言(如python)不检测
  * - 使用IsPythonText函数判断是否为Python代码
  * - 如果语言不匹配，清空补全内容
  * - 继承自Discarder基类
@@ -443,8 +680,8 @@ func (p *ExtremeRepetitionDiscarder) Name() string {
 type NotMatchLanguageDiscarder struct{ Discarder }
 
 func (p *NotMatchLanguageDiscarder) Process(ctx *PrunerContext) bool {
-	// 非python语言但是python代码，则丢弃补全内容
-	if strings.ToLower(ctx.Language) != "python" && IsPythonText(ctx.CompletionCode) {
+	// 非python类语言但是python代码，则丢弃补全内容
+	if !profileOf(ctx.Language).AllowPythonText && IsPythonText(ctx.CompletionCode) {
 		ctx.CompletionCode = ""
 		return true
 	}
@@ -473,7 +710,8 @@ func (p *NotMatchLanguageDiscarder) Name() string {
 type RepetitiveTextCutter struct{ Cutter }
 
 func (p *RepetitiveTextCutter) Process(ctx *PrunerContext) bool {
-	processedCode := cutRepetitiveText(ctx.CompletionCode)
+	params := ctx.params()
+	processedCode := cutRepetitiveText(ctx.CompletionCode, params.RepetitionMinLines, params.RepetitionRatio)
 	if processedCode != ctx.CompletionCode {
 		ctx.CompletionCode = processedCode
 		return true
@@ -490,7 +728,9 @@ func (p *RepetitiveTextCutter) Name() string {
  * @description
  * - 检测并裁剪与前缀重叠的补全内容
  * - 使用cutPrefixOverlap函数处理重叠部分
- * - 默认使用3行的cutLine参数
+ * - cutLine参数为PruneParams.OverlapCutLine，默认3行
+ * - 裁剪后补全首行重新生成了光标所在行已输入的内容(缩进可以不同)时，不修改补全内容，
+ *   记录Anchor.ReplacePrefixLen为光标前的长度，由插件替换光标所在行已输入的内容
  * - 如果检测到重叠并进行裁剪，返回true
  * - 继承自Cutter基类
  * @example
@@ -505,9 +745,11 @@ func (p *RepetitiveTextCutter) Name() string {
 type PrefixOverlapCutter struct{ Cutter }
 
 func (p *PrefixOverlapCutter) Process(ctx *PrunerContext) bool {
-	// 补全内容前缀重复处理
-	// 使用默认的cutLine参数值3
-	processedCode := cutPrefixOverlap(ctx.CompletionCode, ctx.Prefix, ctx.Suffix, 3)
+	// 补全内容前缀重复处理，比较OverlapCutLine行(默认3)
+	processedCode := cutPrefixOverlap(ctx.Language, ctx.CompletionCode, ctx.Prefix, ctx.Suffix, ctx.params().OverlapCutLine)
+	if n := linePrefixOverlap(processedCode, ctx.Prefix); n > 0 {
+		ctx.Anchor.ReplacePrefixLen = n
+	}
 	if processedCode != ctx.CompletionCode {
 		ctx.CompletionCode = processedCode
 		return true
@@ -515,6 +757,20 @@ func (p *PrefixOverlapCutter) Process(ctx *PrunerContext) bool {
 	return false
 }
 
+// 补全首行重新生成了光标所在行已输入的内容时，返回需要替换的光标前的字符数，否则返回0
+func linePrefixOverlap(completionCode, prefix string) int {
+	linePrefix := prefix[strings.LastIndex(prefix, "\n")+1:]
+	typed := strings.TrimLeft(linePrefix, " \t")
+	if typed == "" {
+		return 0
+	}
+	firstLine := strings.TrimLeft(strings.SplitN(completionCode, "\n", 2)[0], " \t")
+	if len(firstLine) <= len(typed) || !strings.HasPrefix(firstLine, typed) {
+		return 0
+	}
+	return utf8.RuneCountInString(linePrefix)
+}
+
 func (p *PrefixOverlapCutter) Name() string {
 	return string(CutPrefixOverlap)
 }
@@ -524,7 +780,7 @@ func (p *PrefixOverlapCutter) Name() string {
  * @description
  * - 检测并裁剪与后缀重叠的补全内容
  * - 使用cutSuffixOverlap函数处理重叠部分
- * - 默认使用3行的cutLine参数和8的ignoreOverlapLen参数
+ * - cutLine和ignoreOverlapLen参数为PruneParams.OverlapCutLine和IgnoreOverlapLen，默认3行和8
  * - 如果检测到重叠并进行裁剪，返回true
  * - 继承自Cutter基类
  * @example
@@ -539,8 +795,11 @@ func (p *PrefixOverlapCutter) Name() string {
 type SuffixOverlapCutter struct{ Cutter }
 
 func (p *SuffixOverlapCutter) Process(ctx *PrunerContext) bool {
-	// 使用默认的cutLine参数值3和ignoreOverlapLen参数值8
-	processedCode := cutSuffixOverlap(ctx.CompletionCode, ctx.Prefix, ctx.Suffix, 3, 8)
+	// 比较OverlapCutLine行(默认3)，不裁剪不超过IgnoreOverlapLen(默认8)的重叠
+	params := ctx.params()
+	processedCode := cutSuffixOverlap(ctx.CompletionCode, ctx.Prefix, ctx.Suffix, params.OverlapCutLine, params.IgnoreOverlapLen)
+	// 过短的重叠不会被裁剪(如闭合括号)，此时补全内容重新生成了行后缀，应替换光标后的剩余内容
+	ctx.Anchor.ReplaceLineSuffix = endsWithLineSuffix(processedCode, ctx.Suffix)
 	if processedCode != ctx.CompletionCode {
 		ctx.CompletionCode = processedCode
 		return true
@@ -552,6 +811,44 @@ func (p *SuffixOverlapCutter) Name() string {
 	return string(CutSuffixOverlap)
 }
 
+/**
+ * 参数列表裁剪处理器
+ * @description
+ * - 光标在调用(或结构体字面量)的参数列表中时，补全常把剩余的参数、闭合括号甚至下一条语句都补全出来，
+ *   而后缀中已经有部分参数，后缀重叠裁剪后留下参数中间的残片
+ * - 在补全中不在嵌套括号中的闭合括号之前裁剪；光标后紧接着","时在第一个","之前裁剪，
+ *   这两个停止位置不作为模型的停用词，避免嵌套调用中的括号和逗号提前结束补全
+ * - 在第一个与已有参数同名的参数之前裁剪
+ * - 在后缀重叠裁剪之前执行，继承自Cutter基类
+ * @example
+ * processor := &ArgumentCutter{}
+ * ctx := &PrunerContext{
+ *     Language: "python",
+ *     CompletionCode: "color='red', label='a')\nshow()",
+ *     Arguments: &model.ArgumentList{Close: ")", Assign: "=", Names: []string{"label"}},
+ * }
+ * modified := processor.Process(ctx)
+ * // ctx.CompletionCode = "color='red', "，modified = true
+ */
+type ArgumentCutter struct{ Cutter }
+
+func (p *ArgumentCutter) Process(ctx *PrunerContext) bool {
+	if ctx.Arguments == nil {
+		return false
+	}
+	processedCode := cutArguments(ctx.CompletionCode, ctx.Arguments, ctx.Suffix, quotesOf(ctx.Language),
+		profileOf(ctx.Language).Comment.Line)
+	if processedCode != ctx.CompletionCode {
+		ctx.CompletionCode = processedCode
+		return true
+	}
+	return false
+}
+
+func (p *ArgumentCutter) Name() string {
+	return string(CutDuplicateArgument)
+}
+
 // 以下是工具函数的占位符，后续需要从common.py移植实现
 
 /**
@@ -588,6 +885,7 @@ func (p *InvalidBracketsDiscarder) Name() string {
  * - 检测并丢弃非CSS语言中的CSS内容
  * - 使用JudgeCss函数判断是否为CSS内容
  * - 如果非CSS语言但包含CSS内容，清空补全内容
+ * - 单文件组件中按光标所在的区块判断：样式区块的CSS内容保留，脚本区块不使用CSS启发式判断
  * - 使用0.7的置信度阈值
  * - 继承自Discarder基类
  * @example
@@ -602,8 +900,11 @@ func (p *InvalidBracketsDiscarder) Name() string {
 type CssContentDiscarder struct{ Discarder }
 
 func (p *CssContentDiscarder) Process(ctx *PrunerContext) bool {
+	if IsStyleLanguage(ctx.Language) || ctx.Block == SFCStyle || ctx.Block == SFCScript {
+		return false
+	}
 	// 如果是非CSS语言但是包含CSS内容，则去除CSS内容
-	if strings.ToLower(ctx.Language) != "css" && JudgeCss(ctx.Language, ctx.CompletionCode, 0.7) {
+	if JudgeCss(ctx.Language, ctx.CompletionCode, 0.7) {
 		ctx.CompletionCode = ""
 		return true
 	}
@@ -621,6 +922,9 @@ func (p *CssContentDiscarder) Name() string {
  * - 使用isCodeSyntax函数验证语法正确性
  * - 考虑前缀和后缀的上下文进行语法检查
  * - 如果存在语法错误，清空补全内容
+ * - 开启区域恢复(开关syntaxRecovery，配置wrapper.prune.recovery)时，先按行查找开头最长的语法正确的区域，
+ *   找到时保留该区域(规则recovered)而不丢弃，见recoverSyntaxRegion
+ * - 光标在字符串中时不检查，字符串片段无法按代码解析
  * - 继承自Discarder基类
  * @example
  * processor := &SyntaxErrorDiscarder{}
@@ -636,24 +940,84 @@ func (p *CssContentDiscarder) Name() string {
 type SyntaxErrorDiscarder struct{ Discarder }
 
 func (p *SyntaxErrorDiscarder) Process(ctx *PrunerContext) bool {
-	if !isCodeSyntax(ctx.Language, ctx.CompletionCode, ctx.Prefix, ctx.Suffix) {
-		ctx.CompletionCode = ""
-		return true
+	if ctx.Literal != "" {
+		return false
 	}
-	return false
+	if isCodeSyntax(ctx.syntaxLanguage(), ctx.CompletionCode, ctx.Prefix, ctx.Suffix) {
+		return false
+	}
+	if region := recoverSyntaxRegion(ctx); region != "" {
+		ctx.CompletionCode = region
+		ctx.Rule = RuleSyntaxRecovered
+		return false
+	}
+	ctx.CompletionCode = ""
+	return true
 }
 
 func (p *SyntaxErrorDiscarder) Name() string {
 	return string(DiscardSyntaxError)
 }
 
+const (
+	RuleSyntaxRecovered     = "recovered" // 语法错误丢弃器保留了开头的有效区域
+	defaultRecoveryMinLines = 4           // 没有配置wrapper.prune.recovery.minLines时恢复要求的最少行数
+	syntaxRecoveryRecovered = "recovered"
+	syntaxRecoveryDiscarded = "discarded"
+)
+
+/**
+ * 查找有语法错误的补全开头最长的语法正确的区域
+ * @param {*PrunerContext} ctx - 后置处理器上下文，补全整体已确认有语法错误
+ * @returns {string} 返回恢复的区域(去除末尾空白)，没有开启、补全太短或找不到时返回空字符串
+ * @description
+ * - 开关syntaxRecovery关闭时不恢复，默认跟随配置wrapper.prune.recovery.enabled
+ * - 少于wrapper.prune.recovery.minLines行的补全不恢复，也不计入指标
+ * - 按行二分查找，最多分析wrapper.prune.syntaxParseBudget次，请求取消时停止
+ * - 按语言记录恢复或整体丢弃，舍弃的行数记录在日志中
+ */
+func recoverSyntaxRegion(ctx *PrunerContext) string {
+	if !feature_flag.FromContext(ctx.Ctx).Enabled(feature_flag.SyntaxRecovery) {
+		return ""
+	}
+	minLines := config.Wrapper.Prune.Recovery.MinLines
+	if minLines <= 0 {
+		minLines = defaultRecoveryMinLines
+	}
+	total := strings.Count(ctx.CompletionCode, "\n") + 1
+	if total < minLines {
+		return ""
+	}
+	language := ctx.syntaxLanguage()
+	tsUtil := parser.Acquire(language)
+	defer parser.Release(language, tsUtil)
+
+	reqCtx := ctx.Ctx
+	if reqCtx == nil {
+		reqCtx = context.Background()
+	}
+	prefix, suffix := tsUtil.ExtractAccurateBlockPrefixSuffix(ctx.Prefix, ctx.Suffix)
+	n := parser.LeadingValidLines(reqCtx, tsUtil, ctx.CompletionCode, prefix, suffix,
+		config.Wrapper.Prune.SyntaxParseBudget)
+	lines := strings.SplitN(ctx.CompletionCode, "\n", n+1)
+	region := strings.TrimRight(strings.Join(lines[:n], "\n"), " \t\r\n")
+	if strings.TrimSpace(region) == "" {
+		metrics.IncrementSyntaxRecovery(language, syntaxRecoveryDiscarded)
+		return ""
+	}
+	metrics.IncrementSyntaxRecovery(language, syntaxRecoveryRecovered)
+	ctx.log().Debug("Completion with syntax errors recovered", zap.Int("lines", n), zap.Int("sacrificed", total-n))
+	return region
+}
+
 /**
  * 语法错误裁剪处理器
  * @description
  * - 检测并裁剪包含语法错误的补全内容
  * - 使用TreeSitter进行语法分析和错误拦截
- * - 通过InterceptSyntaxErrorCode方法裁剪错误部分
+ * - 通过InterceptSyntaxErrorCode方法裁剪错误部分，最多分析wrapper.prune.syntaxParseBudget次，超出时不裁剪
  * - 如果进行了裁剪，返回true
+ * - 光标在字符串中时不裁剪，字符串片段无法按代码解析
  * - 继承自Cutter基类
  * @example
  * processor := &SyntaxErrorCutter{}
@@ -669,13 +1033,20 @@ func (p *SyntaxErrorDiscarder) Name() string {
 type SyntaxErrorCutter struct{ Cutter }
 
 func (p *SyntaxErrorCutter) Process(ctx *PrunerContext) bool {
-	// 进行语法错误拦截和代码裁剪
-	tsUtil := parser.NewSimpleParser(ctx.Language)
-	if tsUtil == nil {
+	if ctx.Literal != "" {
 		return false
 	}
+	// 进行语法错误拦截和代码裁剪
+	language := ctx.syntaxLanguage()
+	tsUtil := parser.Acquire(language)
+	defer parser.Release(language, tsUtil)
 
-	processedCode := tsUtil.InterceptSyntaxErrorCode(ctx.CompletionCode, ctx.Prefix, ctx.Suffix)
+	reqCtx := ctx.Ctx
+	if reqCtx == nil {
+		reqCtx = context.Background()
+	}
+	processedCode := tsUtil.InterceptSyntaxErrorCode(reqCtx, ctx.CompletionCode, ctx.Prefix, ctx.Suffix,
+		config.Wrapper.Prune.SyntaxParseBudget)
 	if processedCode != ctx.CompletionCode {
 		ctx.CompletionCode = processedCode
 		return true
@@ -687,6 +1058,61 @@ func (p *SyntaxErrorCutter) Name() string {
 	return string(CutSyntaxError)
 }
 
+/**
+ * 首行缩进裁剪处理器
+ * @description
+ * - 配置wrapper.prune.firstLineIndent开启时才生效，默认关闭，避免改变已有客户端的补全内容
+ * - 光标所在行光标前只有空白时，模型输出的首行通常带有完整的缩进
+ * - 补全首行的缩进以光标前的空白开头时，裁掉重复的部分
+ * - 否则(如减少缩进)保留补全内容，由插件替换光标前的空白，记录到Anchor.ReplacePrefixLen
+ * - 继承自Cutter基类
+ * @example
+ * processor := &FirstLineIndentCutter{}
+ * ctx := &PrunerContext{
+ *     Prefix: "if ok {\n    ",
+ *     CompletionCode: "    return nil",
+ * }
+ * modified := processor.Process(ctx)
+ * // ctx.CompletionCode = "return nil"，modified = true
+ */
+type FirstLineIndentCutter struct{ Cutter }
+
+func (p *FirstLineIndentCutter) Process(ctx *PrunerContext) bool {
+	if !config.Wrapper.Prune.FirstLineIndent {
+		return false
+	}
+	linePrefix := ctx.Prefix[strings.LastIndex(ctx.Prefix, "\n")+1:]
+	if linePrefix == "" || strings.TrimLeft(linePrefix, " \t") != "" {
+		return false
+	}
+	firstLine := strings.SplitN(ctx.CompletionCode, "\n", 2)[0]
+	body := strings.TrimLeft(firstLine, " \t")
+	if body == "" || body == firstLine {
+		return false
+	}
+	indent := firstLine[:len(firstLine)-len(body)]
+	if strings.HasPrefix(indent, linePrefix) {
+		ctx.CompletionCode = ctx.CompletionCode[len(linePrefix):]
+		ctx.Anchor.ReplacePrefixLen = 0
+		return true
+	}
+	ctx.Anchor.ReplacePrefixLen = utf8.RuneCountInString(linePrefix)
+	return false
+}
+
+func (p *FirstLineIndentCutter) Name() string {
+	return string(CutFirstLineIndent)
+}
+
+// 补全内容的末尾是否重新生成了光标所在行光标后的内容
+func endsWithLineSuffix(completionCode, suffix string) bool {
+	lineSuffix := strings.TrimSpace(strings.SplitN(suffix, "\n", 2)[0])
+	if lineSuffix == "" {
+		return false
+	}
+	return strings.HasSuffix(strings.TrimRight(completionCode, " \t\n\r"), lineSuffix)
+}
+
 type SingleLineCutter struct{ Cutter }
 
 func (p *SingleLineCutter) Process(ctx *PrunerContext) bool {
@@ -710,10 +1136,9 @@ func (p *SingleLineCutter) Name() string {
  * @param {string} suffix - 代码后缀，用于上下文
  * @returns {bool} 返回语法是否正确
  * @description
- * - 创建指定语言的简单语法分析器
+ * - 从注册表中取出指定语言的语法分析器，用完放回
  * - 提取准确的代码块前后缀
  * - 将前缀、代码和后缀组合进行语法检查
- * - 如果分析器创建失败，默认返回true
  * - 用于语法错误处理器的语法验证
  * @example
  * valid := isCodeSyntax("python", "    return", "def ", "\nprint('hello')")
@@ -723,10 +1148,8 @@ func (p *SingleLineCutter) Name() string {
  * // invalid = false (语法错误)
  */
 func isCodeSyntax(language, code, prefix, suffix string) bool {
-	tsUtil := parser.NewSimpleParser(language)
-	if tsUtil == nil {
-		return true
-	}
+	tsUtil := parser.Acquire(language)
+	defer parser.Release(language, tsUtil)
 
 	// 提取准确的代码块前后缀
 	newPrefix, newSuffix := tsUtil.ExtractAccurateBlockPrefixSuffix(prefix, suffix)
@@ -0,0 +1,232 @@
+package completions
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+func pruneWithAnchor(t *testing.T, code, prefix, suffix string) (string, CompletionAnchor) {
+	chain, err := NewPrunerChainByNames([]string{CutFirstLineIndent, CutSuffixOverlap})
+	if err != nil {
+		t.Fatalf("create pruner chain failed: %v", err)
+	}
+	ctx := &PrunerContext{Language: "go", CompletionCode: code, Prefix: prefix, Suffix: suffix}
+	chain.Process(ctx)
+	return ctx.CompletionCode, ctx.Anchor
+}
+
+// to test anchors of the first line indentation fix
+// go test ./pkg/completions/ -v -run Test_AnchorIndentFix
+func Test_AnchorIndentFix(t *testing.T) {
+	saved := config.Wrapper.Prune.FirstLineIndent
+	defer func() { config.Wrapper.Prune.FirstLineIndent = saved }()
+	config.Wrapper.Prune.FirstLineIndent = true
+	cases := []struct {
+		name     string
+		code     string
+		prefix   string
+		expected string
+		anchor   CompletionAnchor
+	}{
+		{"duplicated indent", "    return nil\n}", "if err != nil {\n    ", "return nil\n}", CompletionAnchor{0, false, 12}},
+		{"dedent", "    }", "        if ok {\n        ", "    }", CompletionAnchor{8, false, 5}},
+		{"no indent", "return nil", "if ok {\n    ", "return nil", CompletionAnchor{0, false, 10}},
+		{"typed code before cursor", "    x", "    foo", "    x", CompletionAnchor{0, false, 5}},
+	}
+	for _, c := range cases {
+		code, anchor := pruneWithAnchor(t, c.code, c.prefix, "")
+		if code != c.expected || anchor != c.anchor {
+			t.Errorf("%s: expected %q %+v, got %q %+v", c.name, c.expected, c.anchor, code, anchor)
+		}
+	}
+}
+
+// to test the first line indentation cutter is off by default
+// go test ./pkg/completions/ -v -run Test_FirstLineIndentDisabled
+func Test_FirstLineIndentDisabled(t *testing.T) {
+	code, anchor := pruneWithAnchor(t, "    return nil\n}", "if err != nil {\n    ", "")
+	if code != "    return nil\n}" || anchor != (CompletionAnchor{0, false, 16}) {
+		t.Errorf("expected the completion unchanged, got %q %+v", code, anchor)
+	}
+}
+
+// to test the anchor derived by the prefix overlap cutter when the model regenerates the typed part of the cursor line
+// go test ./pkg/completions/ -v -run Test_AnchorPrefixOverlap
+func Test_AnchorPrefixOverlap(t *testing.T) {
+	cases := []struct {
+		name   string
+		code   string
+		prefix string
+		anchor CompletionAnchor
+	}{
+		{"regenerated typed code", "result := compute(a, b)", "func f() {\n\tresult := comp", CompletionAnchor{15, false, 23}},
+		{"regenerated with other indent", "  if ok {", "\tif", CompletionAnchor{3, false, 9}},
+		{"continues the typed code", "ute(a, b)", "\tresult := comp", CompletionAnchor{0, false, 9}},
+		{"only the typed code", "comp", "\tcomp", CompletionAnchor{0, false, 4}},
+	}
+	for _, c := range cases {
+		chain, err := NewPrunerChainByNames([]string{CutPrefixOverlap})
+		if err != nil {
+			t.Fatalf("create pruner chain failed: %v", err)
+		}
+		ctx := &PrunerContext{Language: "go", CompletionCode: c.code, Prefix: c.prefix}
+		chain.Process(ctx)
+		if ctx.CompletionCode != c.code || ctx.Anchor != c.anchor {
+			t.Errorf("%s: expected %q %+v, got %q %+v", c.name, c.code, c.anchor, ctx.CompletionCode, ctx.Anchor)
+		}
+	}
+}
+
+// to test anchors when the model regenerates the rest of the cursor line
+// go test ./pkg/completions/ -v -run Test_AnchorSuffixRegeneration
+func Test_AnchorSuffixRegeneration(t *testing.T) {
+	code, anchor := pruneWithAnchor(t, "a, b)", "result := compute(", ")\nreturn result")
+	if code != "a, b)" || anchor != (CompletionAnchor{0, true, 5}) {
+		t.Errorf("expected regenerated suffix replaced, got %q %+v", code, anchor)
+	}
+
+	// 重叠足够长时裁剪器已去掉重复的行后缀，无需替换
+	code, anchor = pruneWithAnchor(t, "x := 1\nreturn compute(x, y)", "func f() {\n\t", "return compute(x, y)\n}")
+	if code != "x := 1" || anchor.ReplaceLineSuffix || anchor.CursorOffset != 6 {
+		t.Errorf("expected overlap cut without replacement, got %q %+v", code, anchor)
+	}
+}
+
+// to test the ordered, de-duplicated hit list with per-pruner deltas
+// go test ./pkg/completions/ -v -run Test_PrunerChainHits
+func Test_PrunerChainHits(t *testing.T) {
+	saved := config.Wrapper.Prune.FirstLineIndent
+	defer func() { config.Wrapper.Prune.FirstLineIndent = saved }()
+	config.Wrapper.Prune.FirstLineIndent = true
+	chain, err := NewPrunerChainByNames([]string{CutSuffixOverlap, CutFirstLineIndent, CutSuffixOverlap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &PrunerContext{Language: "go", CompletionCode: "    x := 1\nreturn x\n}", Prefix: "func f() int {\n    ", Suffix: "return x\n}"}
+	result := chain.Process(ctx)
+	expected := []PrunerHit{
+		{Name: CutSuffixOverlap, Type: TypeCutter, Delta: -10},
+		{Name: CutFirstLineIndent, Type: TypeCutter, Delta: -4},
+	}
+	if !reflect.DeepEqual(result.Hits, expected) || result.Code != "x := 1" || !result.Modified || result.Discarded {
+		t.Errorf("expected hits %+v, got %+v", expected, result)
+	}
+
+	// 每次调用的结果相互独立
+	result = chain.Process(&PrunerContext{Language: "go", CompletionCode: "y", Prefix: "x := ", Suffix: "\n"})
+	if len(result.Hits) != 0 || result.Modified || result.Code != "y" {
+		t.Errorf("expected no hits carried over, got %+v", result)
+	}
+	if _, err := NewPrunerChainByNames([]string{"cut-unknown"}); err == nil {
+		t.Error("expected an error for an unknown pruner")
+	}
+}
+
+// 默认链的样例输入
+func prunerSamples() []*PrunerContext {
+	return []*PrunerContext{
+		{Language: "go", CompletionCode: "    return nil\n}", Prefix: "if err != nil {\n    ", Suffix: "\n"},
+		{Language: "javascript", CompletionCode: "a, b)", Prefix: "const r = compute(", Suffix: ")\nreturn r"},
+		{Language: "python", CompletionCode: "x = 1\nx = 1\nx = 1\nx = 1\nx = 1\nx = 1", Prefix: "def f():\n    ", Suffix: ""},
+		{Language: "go", CompletionCode: "x := 1\nreturn compute(x, y)", Prefix: "func f() {\n\t", Suffix: "return compute(x, y)\n}"},
+	}
+}
+
+// to test that concurrent Process calls on a shared chain don't interfere (run with -race)
+// go test ./pkg/completions/ -race -v -run Test_PrunerChainConcurrent
+func Test_PrunerChainConcurrent(t *testing.T) {
+	chain := NewDefaultPrunerChain()
+	samples := prunerSamples()
+	expected := make([]*PruneResult, len(samples))
+	for i, sample := range samples {
+		ctx := *sample
+		expected[i] = chain.Process(&ctx)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				i := (g + n) % len(samples)
+				ctx := *samples[i]
+				if result := chain.Process(&ctx); !reflect.DeepEqual(result, expected[i]) {
+					t.Errorf("sample %d: expected %+v, got %+v", i, expected[i], result)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// 每次请求都创建并校验处理器链(之前的做法)，与启动时创建一次的链对比
+func benchmarkPrunerChain(b *testing.B, chainOf func() *PrunerChain) {
+	samples := prunerSamples()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := *samples[i%len(samples)]
+		chainOf().Process(&ctx)
+	}
+}
+
+// go test ./pkg/completions/ -bench Benchmark_PrunerChain -run ^$
+func Benchmark_PrunerChainRebuilt(b *testing.B) {
+	names := []string{DiscardExtremeRepetition, DiscardNotMatchLanguage, DiscardSyntaxError, CutIndentStyle, CutQuoteStyle,
+		CutFirstLineIndent, CutRepetitiveText, CutPrefixOverlap, CutDuplicateArgument, CutSuffixOverlap, CutSyntaxError, CutTerminalPunctuation}
+	benchmarkPrunerChain(b, func() *PrunerChain {
+		chain, err := NewPrunerChainByNames(names)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return chain
+	})
+}
+
+func Benchmark_PrunerChainShared(b *testing.B) {
+	chain := NewDefaultPrunerChain()
+	benchmarkPrunerChain(b, func() *PrunerChain {
+		return chain
+	})
+}
+
+// to test the syntax discarder keeps the leading valid region when recovery is enabled
+// go test ./pkg/completions/ -v -run Test_SyntaxRecovery
+func Test_SyntaxRecovery(t *testing.T) {
+	saved := config.Wrapper.Prune.Recovery
+	defer func() { config.Wrapper.Prune.Recovery = saved }()
+	chain, err := NewPrunerChainByNames([]string{DiscardSyntaxError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, suffix := "function f(a, b) {\n    ", "\n}"
+	cases := []struct {
+		name, code, expected string
+	}{
+		{"last line broken", "const x = a + b;\nconst y = x * 2;\nconst z = y - 1;\nreturn (z;", "const x = a + b;\nconst y = x * 2;\nconst z = y - 1;"},
+		{"middle line broken", "const x = a + b;\nconst y = (x * 2;\nconst z = y - 1;\nreturn z;", "const x = a + b;"},
+		{"all broken", "const x = (a + b;\nconst y = x * 2;\nconst z = y - 1;\nreturn z;", ""},
+		{"short completion", "const x = a + b;\nreturn (x;", ""},
+	}
+	config.Wrapper.Prune.Recovery = config.SyntaxRecoveryConfig{Enabled: true}
+	for _, c := range cases {
+		result := chain.Process(&PrunerContext{Language: "javascript", CompletionCode: c.code, Prefix: prefix, Suffix: suffix})
+		if result.Code != c.expected || result.Discarded != (c.expected == "") || !result.Modified {
+			t.Errorf("%s: expected %q, got %+v", c.name, c.expected, result)
+			continue
+		}
+		if c.expected != "" && result.HitNames()[0] != DiscardSyntaxError+":"+RuleSyntaxRecovered {
+			t.Errorf("%s: expected the recovery recorded, got %v", c.name, result.HitNames())
+		}
+	}
+
+	// 关闭时整体丢弃
+	config.Wrapper.Prune.Recovery.Enabled = false
+	if result := chain.Process(&PrunerContext{Language: "javascript", CompletionCode: cases[0].code, Prefix: prefix, Suffix: suffix}); !result.Discarded {
+		t.Errorf("expected the completion discarded with recovery disabled, got %+v", result)
+	}
+}
@@ -0,0 +1,196 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//
+//	补全末尾的标点: 模型模仿训练数据中占多数的风格，go补全以分号结尾、json在闭合括号前留下逗号，
+//	按语言配置的规则(LanguageProfile.Punctuation)去掉、追加或保留补全末尾的分号和逗号
+//
+
+// 补全末尾所在的语法位置
+const (
+	PositionStatement = "statement" // 补全结束在行尾(光标行的后缀为空)，不在闭合括号之前
+	PositionElement   = "element"   // 后缀的第一个非空白字符是闭合括号，补全是括号中的最后一个元素
+)
+
+// 规则的处理方式
+const (
+	PunctuationStrip   = "strip"   // 去掉末尾的标点
+	PunctuationRequire = "require" // 缺少时追加
+	PunctuationKeep    = "keep"    // 保持原样
+)
+
+// 规范化的标点和规则名称中使用的名称
+var punctuationMarks = map[string]string{";": "semicolon", ",": "comma"}
+
+// 校验一条规则
+func checkPunctuationRule(rule config.PunctuationRule) error {
+	if _, ok := punctuationMarks[rule.Mark]; !ok {
+		return fmt.Errorf("unknown punctuation mark '%s'", rule.Mark)
+	}
+	switch rule.Position {
+	case "", PositionStatement, PositionElement:
+	default:
+		return fmt.Errorf("unknown punctuation position '%s'", rule.Position)
+	}
+	switch rule.Action {
+	case PunctuationStrip, PunctuationRequire, PunctuationKeep:
+	default:
+		return fmt.Errorf("unknown punctuation action '%s'", rule.Action)
+	}
+	return nil
+}
+
+// 规则的名称，记录在命中的处理器中，如strip-semicolon-statement
+func punctuationRuleName(rule config.PunctuationRule, position string) string {
+	return rule.Action + "-" + punctuationMarks[rule.Mark] + "-" + position
+}
+
+/**
+ * 判断补全末尾所在的语法位置
+ * @param {string} suffix - 光标后的内容
+ * @returns {string, bool} 返回语法位置，判断不出时返回空；位置为element时返回闭合括号是否在之后的行
+ * @description
+ * - 后缀的第一个非空白字符是闭合括号时为element
+ * - 否则光标行的后缀为空时为statement；光标在行中间时判断不出
+ */
+func punctuationPosition(suffix string) (string, bool) {
+	next := strings.TrimLeft(suffix, " \t\r\n")
+	lineSuffix, _, _ := strings.Cut(suffix, "\n")
+	if next != "" && strings.ContainsRune(")]}", rune(next[0])) {
+		return PositionElement, strings.TrimSpace(lineSuffix) == ""
+	}
+	if strings.TrimSpace(lineSuffix) == "" {
+		return PositionStatement, false
+	}
+	return "", false
+}
+
+// 按顺序查找标点在该位置生效的规则
+func findPunctuationRule(rules []config.PunctuationRule, mark, position string) (config.PunctuationRule, bool) {
+	for _, rule := range rules {
+		if rule.Mark == mark && (rule.Position == "" || rule.Position == position) {
+			return rule, true
+		}
+	}
+	return config.PunctuationRule{}, false
+}
+
+// 一行的末尾是否在代码中，而不在行注释或未闭合的字符串中
+func endsInCode(language, line string) bool {
+	quotes := quotesOf(language)
+	comment := profileOf(language).Comment.Line
+	var quote rune
+	escaped := false
+	for i, ch := range line {
+		switch {
+		case quote == 0 && comment != "" && strings.HasPrefix(line[i:], comment):
+			return false
+		case quote == 0 && strings.ContainsRune(quotes, ch):
+			quote = ch
+		case quote == 0:
+		case escaped:
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == quote:
+			quote = 0
+		}
+	}
+	return quote == 0
+}
+
+// 可以在其后追加标点的结尾字符：标识符、数字、字符串和闭合括号
+func endsValue(ch rune) bool {
+	return ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch) || strings.ContainsRune(")]}\"'`", ch)
+}
+
+/**
+ * 按语言的规则规范化补全末尾的分号和逗号
+ * @param {*PrunerContext} ctx - 后置处理器上下文
+ * @returns {string, string} 返回规范化后的补全内容和生效的规则名称，没有修改时规则名称为空
+ * @description
+ * - 补全末尾在行注释或未闭合的字符串中时不处理
+ * - 补全以标点结尾时，按该标点在该位置的规则去掉(strip)或保留(keep)
+ * - 补全不以标点结尾时，按require规则追加；element位置只在闭合括号位于之后的行时追加，
+ *   避免在同一行的最后一个参数后追加逗号
+ * @example
+ * ctx := &PrunerContext{Language: "go", Prefix: "\tn := ", CompletionCode: "len(items);", Suffix: "\n\treturn n\n"}
+ * code, rule := normalizePunctuation(ctx)
+ * // code = "len(items)"，rule = "strip-semicolon-statement"
+ */
+func normalizePunctuation(ctx *PrunerContext) (string, string) {
+	profile := profileOf(ctx.Language)
+	code := strings.TrimRight(ctx.CompletionCode, " \t\r\n")
+	if len(profile.Punctuation) == 0 || code == "" {
+		return ctx.CompletionCode, ""
+	}
+	position, nextLine := punctuationPosition(ctx.Suffix)
+	if position == "" {
+		return ctx.CompletionCode, ""
+	}
+	// 末尾一行连同光标行的前缀一起扫描，判断是否在注释或字符串中
+	lastLine := code[strings.LastIndexByte(code, '\n')+1:]
+	if !strings.Contains(code, "\n") {
+		lastLine = ctx.Prefix[strings.LastIndexByte(ctx.Prefix, '\n')+1:] + code
+	}
+	if !endsInCode(ctx.Language, lastLine) {
+		return ctx.CompletionCode, ""
+	}
+
+	last, _ := utf8.DecodeLastRuneInString(code)
+	if mark := string(last); punctuationMarks[mark] != "" {
+		rule, ok := findPunctuationRule(profile.Punctuation, mark, position)
+		if !ok || rule.Action != PunctuationStrip {
+			return ctx.CompletionCode, ""
+		}
+		return strings.TrimRight(strings.TrimSuffix(code, mark), " \t"), punctuationRuleName(rule, position)
+	}
+	if !endsValue(last) || (position == PositionElement && !nextLine) {
+		return ctx.CompletionCode, ""
+	}
+	for _, rule := range profile.Punctuation {
+		if rule.Action == PunctuationRequire && (rule.Position == "" || rule.Position == position) {
+			return code + rule.Mark, punctuationRuleName(rule, position)
+		}
+	}
+	return ctx.CompletionCode, ""
+}
+
+/**
+ * 末尾标点规范化裁剪处理器
+ * @description
+ * - 按语言配置的规则(LanguageProfile.Punctuation，配置wrapper.languages.<语言>.punctuation覆盖)
+ *   去掉、追加或保留补全末尾的分号和逗号，见normalizePunctuation
+ * - 在重叠裁剪和语法错误裁剪之后，作为最后一个裁剪器执行
+ * - 补全重新生成了行后缀(替换光标后的剩余内容)时不处理，后缀无法说明补全末尾的位置
+ * - 生效的规则记录在命中的处理器中，如"cut-terminal_punctuation:strip-comma-element"
+ * @example
+ * ctx := &PrunerContext{Language: "json", Prefix: "{\n  \"a\": ", CompletionCode: "1,", Suffix: "\n}\n"}
+ * modified := (&TerminalPunctuationCutter{}).Process(ctx)
+ * // ctx.CompletionCode = "1"，modified = true
+ */
+type TerminalPunctuationCutter struct{ Cutter }
+
+func (p *TerminalPunctuationCutter) Process(ctx *PrunerContext) bool {
+	if ctx.Anchor.ReplaceLineSuffix {
+		return false
+	}
+	code, rule := normalizePunctuation(ctx)
+	if rule == "" {
+		return false
+	}
+	ctx.CompletionCode = code
+	ctx.Rule = rule
+	return true
+}
+
+func (p *TerminalPunctuationCutter) Name() string {
+	return CutTerminalPunctuation
+}
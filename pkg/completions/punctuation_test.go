@@ -0,0 +1,70 @@
+package completions
+
+import (
+	"slices"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test normalizing the trailing semicolon and comma by the language rules
+// go test ./pkg/completions/ -v -run Test_TerminalPunctuation
+func Test_TerminalPunctuation(t *testing.T) {
+	cases := []struct {
+		name     string
+		language string
+		prefix   string
+		code     string
+		suffix   string
+		expected string
+		rule     string
+	}{
+		{"go semicolon stripped", "go", "func count(items []int) int {\n\tn := ", "len(items);", "\n\treturn n\n}\n",
+			"len(items)", "strip-semicolon-statement"},
+		{"go semicolon stripped before the closing brace", "go", "func stop() {\n\t", "close(done);", "\n}\n",
+			"close(done)", "strip-semicolon-element"},
+		{"go semicolon inside the for clause kept", "go", "\tfor i := 0", "; ", " i < n; i++ {\n", "; ", ""},
+		{"go semicolon in the comment kept", "go", "\t", "run() // then stop;", "\n", "run() // then stop;", ""},
+		{"json trailing comma removed before the closing brace", "json", "{\n  \"name\": \"demo\",\n  \"version\": ",
+			"\"1.0.0\",\n  \"private\": true,", "\n}\n", "\"1.0.0\",\n  \"private\": true", "strip-comma-element"},
+		{"json comma before the next member kept", "json", "{\n  \"name\": ", "\"demo\",", "\n  \"version\": \"1.0.0\"\n}\n",
+			"\"demo\",", ""},
+		{"rust trailing comma preserved", "rust", "let p = Point {\n    x: 1,\n    ", "y: 2,", "\n};\n", "y: 2,", ""},
+		{"java without rules", "java", "\tint n = ", "count();", "\n", "count();", ""},
+	}
+	for _, c := range cases {
+		ctx := &PrunerContext{Language: c.language, Prefix: c.prefix, CompletionCode: c.code, Suffix: c.suffix}
+		modified := (&TerminalPunctuationCutter{}).Process(ctx)
+		if modified != (c.rule != "") || ctx.CompletionCode != c.expected || ctx.Rule != c.rule {
+			t.Errorf("%s: expected %q %q, got %q %q", c.name, c.expected, c.rule, ctx.CompletionCode, ctx.Rule)
+		}
+	}
+
+	// 生效的规则记录在命中的处理器中
+	ctx := &PrunerContext{Language: "go", Prefix: cases[0].prefix, CompletionCode: cases[0].code, Suffix: cases[0].suffix}
+	result := NewDefaultPrunerChain().Process(ctx)
+	if result.Code != "len(items)" || !slices.Contains(result.HitNames(), CutTerminalPunctuation+":strip-semicolon-statement") {
+		t.Errorf("expected the rule in the hits, got %q %v", result.Code, result.HitNames())
+	}
+
+	// 配置覆盖内置的规则
+	defer InitLanguageProfiles(nil)
+	err := InitLanguageProfiles(map[string]config.LanguageOverride{
+		"rust": {Punctuation: []config.PunctuationRule{{Mark: ",", Position: PositionElement, Action: PunctuationRequire}}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ctx = &PrunerContext{Language: "rust", Prefix: "let p = Point {\n    x: 1,\n    ", CompletionCode: "y: 2", Suffix: "\n};\n"}
+	if !(&TerminalPunctuationCutter{}).Process(ctx) || ctx.CompletionCode != "y: 2," {
+		t.Errorf("expected the comma required by the override, got %q", ctx.CompletionCode)
+	}
+	ctx = &PrunerContext{Language: "rust", Prefix: "let p = Point::new(1, ", CompletionCode: "2", Suffix: ");\n"}
+	if (&TerminalPunctuationCutter{}).Process(ctx) {
+		t.Errorf("expected no comma before the closing bracket on the same line, got %q", ctx.CompletionCode)
+	}
+	invalid := config.PunctuationRule{Mark: ":", Action: PunctuationStrip}
+	if err := InitLanguageProfiles(map[string]config.LanguageOverride{"go": {Punctuation: []config.PunctuationRule{invalid}}}); err == nil {
+		t.Error("expected an error for an unknown mark")
+	}
+}
@@ -0,0 +1,207 @@
+package completions
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+
+	"go.uber.org/zap"
+)
+
+/**
+ * 超大辅助字段的缩减记录
+ * @description
+ * - Field: 被缩减的字段名(import_content/code_context)
+ * - Before/After: 缩减前后的字节数
+ * - Kept/Total: 保留的行数(片段数)/原有的行数(片段数)
+ */
+type FieldReduction struct {
+	Field  string `json:"field"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+	Kept   int    `json:"kept"`
+	Total  int    `json:"total"`
+}
+
+var identifierPattern = regexp.MustCompile(`[A-Za-z_$][A-Za-z0-9_$]*`)
+
+// 导入语句中的关键字，不作为判断相关性的标识符
+var importKeywords = map[string]bool{
+	"import": true, "from": true, "as": true, "require": true, "export": true,
+	"default": true, "const": true, "let": true, "var": true, "type": true,
+	"include": true, "using": true, "use": true, "static": true, "package": true,
+}
+
+/**
+ * Reduce oversized auxiliary fields of the prompt instead of rejecting the request
+ * @description
+ * - ImportContent over MaxImportBytes keeps only the import lines referencing identifiers near the cursor
+ * - Client-provided CodeContext over MaxContextBytes keeps the top-ranked snippets under the byte cap
+ * - Each reduction is logged with before/after sizes and recorded for Verbose
+ */
+func (in *CompletionInput) ReduceOversized() {
+	cfg := &config.Wrapper.Reduce
+	var idents map[string]bool
+	nearCursor := func() map[string]bool {
+		if idents == nil {
+			idents = cursorIdentifiers(in.Processed.Prefix, in.Processed.Suffix, cfg.NearCursorLines)
+		}
+		return idents
+	}
+	if cfg.MaxImportBytes > 0 && len(in.Processed.ImportContent) > cfg.MaxImportBytes {
+		reduced, r := reduceImports(in.Processed.ImportContent, nearCursor(), cfg.MaxImportBytes)
+		in.Processed.ImportContent = reduced
+		in.recordReduction(r)
+	}
+	if cfg.MaxContextBytes > 0 && len(in.Processed.CodeContext) > cfg.MaxContextBytes {
+		reduced, r := reduceCodeContext(in.Processed.CodeContext, nearCursor(), cfg.MaxContextBytes)
+		in.Processed.CodeContext = reduced
+		in.recordReduction(r)
+	}
+}
+
+func (in *CompletionInput) recordReduction(r FieldReduction) {
+	in.Reductions = append(in.Reductions, r)
+	zap.L().Info("Reduce oversized prompt field",
+		zap.String("completionID", in.CompletionID),
+		zap.String("field", r.Field),
+		zap.Int("before", r.Before),
+		zap.Int("after", r.After),
+		zap.Int("kept", r.Kept),
+		zap.Int("total", r.Total))
+}
+
+// 将缩减记录附加到响应的Verbose中
+func (in *CompletionInput) AttachReductions(rsp *CompletionResponse) {
+	if rsp == nil || len(in.Reductions) == 0 {
+		return
+	}
+	if rsp.Verbose == nil {
+		rsp.Verbose = &model.CompletionVerbose{}
+	}
+	if rsp.Verbose.Input == nil {
+		rsp.Verbose.Input = make(map[string]interface{})
+	}
+	rsp.Verbose.Input["reductions"] = in.Reductions
+}
+
+/**
+ * Collect identifiers near the cursor
+ * @param {string} prefix - Text before the cursor
+ * @param {string} suffix - Text after the cursor
+ * @param {int} lines - Lines taken from each side of the cursor
+ * @returns {map[string]bool} Returns the set of identifiers
+ */
+func cursorIdentifiers(prefix, suffix string, lines int) map[string]bool {
+	before := strings.Split(prefix, "\n")
+	if len(before) > lines {
+		before = before[len(before)-lines:]
+	}
+	after := strings.Split(suffix, "\n")
+	if len(after) > lines {
+		after = after[:lines]
+	}
+	idents := make(map[string]bool)
+	for _, line := range append(before, after...) {
+		for _, id := range identifierPattern.FindAllString(line, -1) {
+			idents[id] = true
+		}
+	}
+	return idents
+}
+
+// 文本引用了idents中的标识符的数量，忽略导入关键字
+func referencedCount(text string, idents map[string]bool) int {
+	count := 0
+	seen := make(map[string]bool)
+	for _, id := range identifierPattern.FindAllString(text, -1) {
+		if importKeywords[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		if idents[id] {
+			count++
+		}
+	}
+	return count
+}
+
+/**
+ * Keep import lines referencing identifiers near the cursor
+ * @param {string} content - Import content sent by the client
+ * @param {map[string]bool} idents - Identifiers near the cursor
+ * @param {int} maxBytes - Byte cap of the reduced content
+ * @returns {string, FieldReduction} Returns the reduced content and the reduction record
+ */
+func reduceImports(content string, idents map[string]bool, maxBytes int) (string, FieldReduction) {
+	lines := strings.Split(content, "\n")
+	kept := make([]string, 0)
+	size := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" || referencedCount(line, idents) == 0 {
+			continue
+		}
+		if size+len(line)+1 > maxBytes {
+			break
+		}
+		kept = append(kept, line)
+		size += len(line) + 1
+	}
+	reduced := strings.Join(kept, "\n")
+	return reduced, FieldReduction{
+		Field:  "import_content",
+		Before: len(content),
+		After:  len(reduced),
+		Kept:   len(kept),
+		Total:  len(lines),
+	}
+}
+
+/**
+ * Keep top-ranked snippets of the code context under the byte cap
+ * @param {string} content - Code context sent by the client, snippets separated by blank lines
+ * @param {map[string]bool} idents - Identifiers near the cursor
+ * @param {int} maxBytes - Byte cap of the reduced content
+ * @returns {string, FieldReduction} Returns the reduced content and the reduction record
+ * @description
+ * - Snippets are ranked by the identifiers near the cursor they reference, then by their original order
+ * - Kept snippets stay in their original order
+ */
+func reduceCodeContext(content string, idents map[string]bool, maxBytes int) (string, FieldReduction) {
+	snippets := strings.Split(content, "\n\n")
+	ranks := make([]int, len(snippets))
+	order := make([]int, len(snippets))
+	for i, snippet := range snippets {
+		ranks[i] = referencedCount(snippet, idents)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return ranks[order[a]] > ranks[order[b]]
+	})
+	selected := make([]bool, len(snippets))
+	size := 0
+	for _, i := range order {
+		if size+len(snippets[i])+2 > maxBytes {
+			continue
+		}
+		selected[i] = true
+		size += len(snippets[i]) + 2
+	}
+	kept := make([]string, 0)
+	for i, snippet := range snippets {
+		if selected[i] {
+			kept = append(kept, snippet)
+		}
+	}
+	reduced := strings.Join(kept, "\n\n")
+	return reduced, FieldReduction{
+		Field:  "code_context",
+		Before: len(content),
+		After:  len(reduced),
+		Kept:   len(kept),
+		Total:  len(snippets),
+	}
+}
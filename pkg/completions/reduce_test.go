@@ -0,0 +1,74 @@
+package completions
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test reduction of a 2MB import block to the imports used near the cursor
+// go test ./pkg/completions/ -v -run Test_ReduceOversizedImports
+func Test_ReduceOversizedImports(t *testing.T) {
+	saved := config.Wrapper.Reduce
+	defer func() { config.Wrapper.Reduce = saved }()
+	config.Wrapper.Reduce = config.ReduceConfig{MaxImportBytes: 64 * 1024, MaxContextBytes: 64 * 1024, NearCursorLines: 20}
+
+	var sb strings.Builder
+	for i := 0; sb.Len() < 2*1024*1024; i++ {
+		fmt.Fprintf(&sb, "import { Generated%d } from './generated/module%d';\n", i, i)
+	}
+	relevant := []string{
+		"import { useState } from 'react';",
+		"import { formatDate } from './utils/date';",
+		"import Button from './components/Button';",
+	}
+	imports := relevant[0] + "\n" + sb.String() + relevant[1] + "\n" + relevant[2]
+
+	in := &CompletionInput{}
+	in.Processed = PromptOptions{
+		Prefix:        "function App() {\n  const [date, setDate] = useState(new Date());\n  return <Button label={formatDate(",
+		Suffix:        ")} />;\n}\n",
+		ImportContent: imports,
+	}
+	in.ReduceOversized()
+
+	if in.Processed.ImportContent != strings.Join(relevant, "\n") {
+		t.Errorf("expected only the relevant imports, got %q", in.Processed.ImportContent)
+	}
+	if len(in.Reductions) != 1 {
+		t.Fatalf("expected 1 reduction, got %d", len(in.Reductions))
+	}
+	r := in.Reductions[0]
+	if r.Field != "import_content" || r.Before != len(imports) || r.After != len(in.Processed.ImportContent) || r.Kept != 3 {
+		t.Errorf("unexpected reduction %+v", r)
+	}
+
+	rsp := &CompletionResponse{}
+	in.AttachReductions(rsp)
+	if rsp.Verbose == nil || rsp.Verbose.Input["reductions"] == nil {
+		t.Error("expected reductions in verbose")
+	}
+}
+
+// to test code context reduction under the byte cap
+// go test ./pkg/completions/ -v -run Test_ReduceCodeContext
+func Test_ReduceCodeContext(t *testing.T) {
+	idents := map[string]bool{"formatDate": true, "parseDate": true}
+	snippets := []string{
+		"// a.go\nfunc unrelated() {}",
+		"// b.go\nfunc formatDate(d Date) string { return parseDate(d) }",
+		"// c.go\nfunc other() {}",
+		"// d.go\nfunc parseDate(s string) Date {}",
+	}
+	content := strings.Join(snippets, "\n\n")
+	reduced, r := reduceCodeContext(content, idents, len(snippets[1])+len(snippets[3])+4)
+	expected := snippets[1] + "\n\n" + snippets[3]
+	if reduced != expected {
+		t.Errorf("expected %q, got %q", expected, reduced)
+	}
+	if r.Kept != 2 || r.Total != 4 {
+		t.Errorf("unexpected reduction %+v", r)
+	}
+}
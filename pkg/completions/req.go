@@ -1,24 +1,39 @@
 package completions
 
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
 // 补全请求结构
 type CompletionRequest struct {
-	Model           string                 `json:"model,omitempty"`
-	Prompt          string                 `json:"prompt"`                      //废弃
-	ProjectPath     string                 `json:"project_path,omitempty"`      //废弃
-	FileProjectPath string                 `json:"file_project_path,omitempty"` //废弃
-	ImportContent   string                 `json:"import_content,omitempty"`    //废弃
-	BetaMode        bool                   `json:"beta_mode,omitempty"`         //废弃
-	LanguageID      string                 `json:"language_id,omitempty"`
-	ClientID        string                 `json:"client_id,omitempty"`
-	CompletionID    string                 `json:"completion_id,omitempty"`
-	Temperature     float64                `json:"temperature,omitempty"`
-	TriggerMode     string                 `json:"trigger_mode,omitempty"`
-	ParentID        string                 `json:"parent_id,omitempty"`
-	Stop            []string               `json:"stop,omitempty"`
-	Verbose         bool                   `json:"verbose,omitempty"`
-	Extra           map[string]interface{} `json:"extra,omitempty"`
-	Prompts         *PromptOptions         `json:"prompt_options,omitempty"`
-	HideScores      *HiddenScoreOptions    `json:"calculate_hide_score,omitempty"`
+	Model            string                 `json:"model,omitempty"`
+	Prompt           string                 `json:"prompt"`                      //废弃
+	ProjectPath      string                 `json:"project_path,omitempty"`      //废弃
+	FileProjectPath  string                 `json:"file_project_path,omitempty"` //废弃
+	ImportContent    string                 `json:"import_content,omitempty"`    //废弃
+	BetaMode         bool                   `json:"beta_mode,omitempty"`         //废弃
+	LanguageID       string                 `json:"language_id,omitempty"`
+	ClientID         string                 `json:"client_id,omitempty"`
+	CompletionID     string                 `json:"completion_id,omitempty"`
+	Temperature      float64                `json:"temperature,omitempty"`
+	TriggerMode      string                 `json:"trigger_mode,omitempty"`
+	ParentID         string                 `json:"parent_id,omitempty"`
+	Stop             []string               `json:"stop,omitempty"`
+	Verbose          bool                   `json:"verbose,omitempty"`
+	DisableContext   bool                   `json:"disable_context,omitempty"` //不获取代码库上下文
+	DisableImports   bool                   `json:"disable_imports,omitempty"` //不建议补全需要的导入语句
+	PruneMode        string                 `json:"prune_mode,omitempty"`      //修剪模式(full/light/off)，需服务端配置允许
+	ClientSequence   int64                  `json:"client_sequence,omitempty"` //插件给请求分配的递增序号，用于发现乱序的响应
+	Invalidate       []string               `json:"invalidate,omitempty"`      //该客户端此前的请求中因光标移动而作废的completion_id
+	Extra            map[string]interface{} `json:"extra,omitempty"`
+	Prompts          *PromptOptions         `json:"prompt_options,omitempty"`
+	HideScores       *HiddenScoreOptions    `json:"calculate_hide_score,omitempty"`
+	DocumentVersion  int64                  `json:"document_version,omitempty"`   //编辑器维护的文档版本号，单调递增，用于校验缓存的结果
+	FileHash         string                 `json:"file_hash,omitempty"`          //文件内容的哈希，用于校验缓存的结果，只比较是否相同
+	DiffMode         bool                   `json:"diff_mode,omitempty"`          //前后缀是代码评审diff视图中的补丁片段，见stripDiff；不指定时按@@ hunk头识别
+	MaxLineWidth     int                    `json:"max_line_width,omitempty"`     //插件的最大渲染宽度(列数)，超宽的补全行按语言折行或截断，见fitLineWidth；不指定时不处理
+	StrictDeadlineMs int                    `json:"strict_deadline_ms,omitempty"` //严格截止时间(毫秒)，到时立即返回已得到的最好结果(可能为空)，见StrictDeadline；不指定时按普通请求处理
 }
 
 // 提示词选项
@@ -29,6 +44,12 @@ type PromptOptions struct {
 	ProjectPath     string `json:"project_path,omitempty"`
 	FileProjectPath string `json:"file_project_path,omitempty"`
 	ImportContent   string `json:"import_content,omitempty"`
+	SuffixKeep      int    `json:"-"` // 截断后缀时至少保留的字节数(光标所在调用的参数列表)，见detectArguments
+	Language        string `json:"-"` // 截断标记使用其注释语法的语言，见truncationMarker
+	// 截断时生效的后缀策略，为空表示full，见resolveSuffixPolicy
+	SuffixPolicy config.ModelLanguageConfig `json:"-"`
+	// 代码库上下文各片段的来源，截断时更新，见traceSnippets
+	Provenance []model.ContextSnippet `json:"-"`
 }
 
 // 计算隐藏分数配置
@@ -39,4 +60,7 @@ type HiddenScoreOptions struct {
 	PromptEndPos            int    `json:"prompt_end_pos"`             //光标在文档中的偏移
 	PreviousLabel           int    `json:"previous_label"`             //上个请求是否被接受
 	PreviousLabelTimestamp  int64  `json:"previous_label_timestamp"`   //上个请求被接受的时间戳
+	AcceptedCharCount       *int   `json:"accepted_char_count"`        //上个请求的补全被采纳的字符数(部分采纳)，不提供时只看previous_label
+	AcceptedLineCount       *int   `json:"accepted_line_count"`        //上个请求的补全被采纳的行数(部分采纳)
+	PreviousCompletionID    string `json:"previous_completion_id"`     //被反馈的补全的completion_id，不提供时为该客户端最近返回的补全
 }
@@ -30,7 +30,23 @@ type CompletionUsage struct {
  * - 用于向客户端返回补全建议
  */
 type CompletionChoice struct {
-	Text string `json:"text"`
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"` // 相对置信度(0-1)，不是校准过的概率，见computeConfidence
+	CompletionAnchor
+}
+
+/**
+ * 补全锚点信息，帮助插件准确放置补全内容
+ * @description
+ * - ReplacePrefixLen: 补全内容会替换光标所在行光标前的字符数，插入位置为光标前移该字符数
+ * - ReplaceLineSuffix: 补全内容是否替换光标所在行光标后的剩余内容(如模型重新生成了闭合括号)
+ * - CursorOffset: 插入后光标在补全内容中的位置(字符数)
+ * - 字符数按Unicode字符计算
+ */
+type CompletionAnchor struct {
+	ReplacePrefixLen  int  `json:"replace_prefix_len"`
+	ReplaceLineSuffix bool `json:"replace_line_suffix"`
+	CursorOffset      int  `json:"cursor_offset"`
 }
 
 /**
@@ -40,17 +56,50 @@ type CompletionChoice struct {
  * - 包含接收时间、上下文获取时间、排队时间、LLM处理时间和总时间
  * - 记录token使用统计信息
  * - 用于性能监控和优化分析
+ * - 各时长字段均为整数毫秒(int64)，JSON中同样以毫秒数序列化，不是Go的time.Duration(纳秒)
+ * - 上下文、排队、模型调用是先后互不重叠的阶段，total_duration >= context_duration + queue_duration + llm_duration
+ * - 未经历的阶段时长为0，如跳过获取上下文、不经过排队的OpenAI接口
  */
 type CompletionPerformance struct {
-	ReceiveTime      time.Time `json:"receive_time"`     //收到请求的时间
-	EnqueueTime      time.Time `json:"-"`                //开始排队时间
-	ContextDuration  int64     `json:"context_duration"` //获取上下文的时长(毫秒)
-	QueueDuration    int64     `json:"queue_duration"`   //排队时长(毫秒)
-	LLMDuration      int64     `json:"llm_duration"`     //调用大语言模型耗用的时长(毫秒)
-	TotalDuration    int64     `json:"total_duration"`   //总时长(毫秒)
-	PromptTokens     int       `json:"prompt_tokens"`
-	CompletionTokens int       `json:"completion_tokens"`
-	TotalTokens      int       `json:"total_tokens"`
+	ReceiveTime      time.Time `json:"receive_time"`               //收到请求的时间(RFC3339)
+	EnqueueTime      time.Time `json:"-"`                          //开始排队时间，在QueueManager.AddRequest中设置
+	ContextDuration  int64     `json:"context_duration"`           //获取代码库上下文的时长(毫秒)，只计检索本身
+	QueueDuration    int64     `json:"queue_duration"`             //从开始排队到被模型池调度的时长(毫秒)
+	LLMDuration      int64     `json:"llm_duration"`               //调用大语言模型耗用的时长(毫秒)，含后置处理和重试
+	TotalDuration    int64     `json:"total_duration"`             //从收到请求到返回响应的总时长(毫秒)
+	PromptTokens     int       `json:"prompt_tokens"`              //提示词token数
+	CompletionTokens int       `json:"completion_tokens"`          //补全内容token数，多候选时为返回的(后置处理后的)补全内容的token数
+	TotalTokens      int       `json:"total_tokens"`               //总token数
+	GeneratedTokens  int       `json:"generated_tokens,omitempty"` //模型返回多个候选或重试过时，各候选、各次调用合计生成的token数(计费用量)
+	Fingerprint      string    `json:"-"`                          //提示词指纹，作为耗时指标的exemplar
+	Probe            bool      `json:"-"`                          //定时自测探针的请求，不计入补全请求的指标
+	FastPath         bool      `json:"-"`                          //极小请求走了快速路径，耗时另外记录到fast_*阶段
+}
+
+/**
+ * 补全请求被取消或超时的原因
+ * @description
+ * - 在发起取消处设置，不从错误信息推断
+ * - 排队和调用模型时的超时分别记录，便于区分排队过长和模型过慢
+ */
+type CancelCause string
+
+const (
+	CancelSuperseded      CancelCause = "superseded"          // 同一客户端的新请求取代了该请求
+	CancelDisconnected    CancelCause = "client_disconnected" // 客户端断开连接
+	CancelDrain           CancelCause = "drain"               // 服务关闭前排空等待队列
+	CancelAdmin           CancelCause = "admin"               // 通过管理接口取消
+	CancelDeadlineQueued  CancelCause = "deadline_queued"     // 排队时超过截止时间
+	CancelDeadlineRunning CancelCause = "deadline_running"    // 调用模型时超过截止时间
+	CancelCursorMoved     CancelCause = "cursor_moved"        // 插件告知光标已移动或文档已编辑，补全作废
+)
+
+// 取消原因对应的补全状态，超过截止时间为timeout，其余为canceled
+func (c CancelCause) Status() model.CompletionStatus {
+	if c == CancelDeadlineQueued || c == CancelDeadlineRunning {
+		return model.StatusTimeout
+	}
+	return model.StatusCanceled
 }
 
 /**
@@ -59,6 +108,7 @@ type CompletionPerformance struct {
  * - 表示补全请求的完整响应
  * - 包含响应ID、模型名称、补全选择列表、使用统计和状态
  * - 支持错误信息和详细输出
+ * - 补全为空或被过滤器拒绝时，附带给客户端的重试建议
  * - 用于向客户端返回补全结果
  */
 type CompletionResponse struct {
@@ -71,6 +121,31 @@ type CompletionResponse struct {
 	Status  model.CompletionStatus   `json:"status"`
 	Error   string                   `json:"error,omitempty"`
 	Verbose *model.CompletionVerbose `json:"verbose,omitempty"`
+
+	RetryAdvice      string   `json:"retry_advice,omitempty"`      // 补全为空或被拒绝时的重试建议，见Retry*
+	SuggestedImports []string `json:"suggested_imports,omitempty"` // 补全引用了文件中未导入的包时，建议添加的导入语句，见SuggestImports
+
+	// 补全为空或被拒绝的原因，稳定的标识: model_empty/extreme_repetition/syntax_error/not_match_language/invalid_brackets/css_content/discarded/cut_to_empty/blank_prompt/cursor_at_line_end/word_after_cursor，见EmptyReason*
+	EmptyReason  string `json:"empty_reason,omitempty"`
+	DiscardedBy  string `json:"discarded_by,omitempty"`  // 使补全内容为空的后置处理器，如discard-syntax_error
+	EmptyMessage string `json:"empty_message,omitempty"` // 补全为空的原因的简短说明
+
+	ClientSequence int64  `json:"client_sequence,omitempty"` // 原样返回请求中的client_sequence
+	ServerSequence uint64 `json:"server_sequence,omitempty"` // 服务端按返回顺序给该客户端分配的递增序号
+	Superseded     bool   `json:"superseded,omitempty"`      // 该客户端更新的请求已先返回，插件应丢弃该响应
+
+	CancelCause CancelCause `json:"cancel_cause,omitempty"` // 请求被取消或超时的原因，见Cancel*
+	Fingerprint string      `json:"fingerprint,omitempty"`  // 提示词指纹，插件记录相同的值以关联各系统的日志
+	DiffLine    string      `json:"diff_line,omitempty"`    // diff模式下光标所在行的种类(context/added)，补全内容已加上diff标记
+
+	// 该项目各检索服务最近的状态，如{"definition":"ok","semantic":"not_indexed"}，插件据此提示用户建立索引，见codebase_context.State*
+	ContextStatus map[string]string `json:"context_status,omitempty"`
+	// 请求指定了strict_deadline_ms时，看门狗是否打断了处理以及跳过的阶段，见StrictDeadline
+	Deadline *DeadlineReport `json:"deadline,omitempty"`
+
+	Raw       string   `json:"-"` // 模型输出的补全内容(后置处理前)，用于补全样本
+	Hits      []string `json:"-"` // 命中的后置处理器，用于补全质量异常检测
+	Discarded bool     `json:"-"` // 模型给出了补全内容，但被后置处理整体丢弃
 }
 
 /**
@@ -80,17 +155,49 @@ type CompletionResponse struct {
  * @param {*CompletionPerformance} perf - 性能统计对象，包含各阶段耗时和token使用情况
  * @description
  * - 记录补全请求的各阶段耗时指标
- * - 记录补全请求计数指标
+ * - 不记录补全请求计数指标，请求数在写响应之后按是否送达客户端记录，见CountResponse
  * - 记录输入和输出token使用指标
  * - 使用metrics包进行指标上报
  * - 用于监控补全服务的性能和资源使用情况
+ * - 自测探针的请求不计入，探针有自己的指标
  */
 func Metrics(modelName string, status string, perf *CompletionPerformance) {
-	metrics.RecordCompletionDuration(modelName, status,
-		perf.QueueDuration, perf.ContextDuration, perf.LLMDuration, perf.TotalDuration)
-	metrics.IncrementCompletionRequests(modelName, status)
+	if perf.Probe {
+		return
+	}
+	metrics.RecordCompletionDurationWithFingerprint(modelName, status,
+		perf.QueueDuration, perf.ContextDuration, perf.LLMDuration, perf.TotalDuration, perf.Fingerprint)
+	if perf.FastPath {
+		metrics.RecordFastPathDuration(modelName, status, perf.TotalDuration,
+			max(perf.TotalDuration-perf.QueueDuration-perf.LLMDuration, 0))
+	}
 	metrics.RecordCompletionTokens(modelName, metrics.TokenTypeInput, perf.PromptTokens)
 	metrics.RecordCompletionTokens(modelName, metrics.TokenTypeOutput, perf.CompletionTokens)
+	billed := perf.CompletionTokens
+	if perf.GeneratedTokens > 0 {
+		billed = perf.GeneratedTokens
+	}
+	metrics.RecordCompletionTokens(modelName, metrics.TokenTypeBilled, billed)
+}
+
+/**
+ * 写响应之后记录补全请求数
+ * @param {*CompletionResponse} rsp - 写给客户端的补全响应
+ * @param {bool} delivered - 响应是否送达客户端
+ * @description
+ * - 送达的补全按补全状态计入completion_responses_total
+ * - 没有送达的补全(写响应时客户端连接已断开)计为deliveredFailed，不再按原状态计数
+ * - 自测探针的请求不计入
+ */
+func CountResponse(rsp *CompletionResponse, delivered bool) {
+	if rsp.Usage.Probe {
+		return
+	}
+	status := rsp.Status
+	if !delivered {
+		status = model.StatusDeliveredFailed
+	}
+	metrics.IncrementCompletionRequests(rsp.Model, string(status))
 }
 
 /**
@@ -134,6 +241,7 @@ func ErrorResponse(completionId, modelName string, status model.CompletionStatus
  * @param {string} completionId - 补全请求ID
  * @param {string} modelName - 模型名称
  * @param {string} completionText - 补全文本内容，表示生成的代码
+ * @param {CompletionAnchor} anchor - 补全锚点信息
  * @param {*CompletionPerformance} perf - 性能统计对象，包含耗时和token信息
  * @param {*model.CompletionVerbose} verbose - 详细输出信息
  * @returns {*CompletionResponse} 返回成功响应对象
@@ -144,8 +252,8 @@ func ErrorResponse(completionId, modelName string, status model.CompletionStatus
  * - 包含补全文本和性能统计信息
  * - 不包含错误信息
  */
-func SuccessResponse(completionId, modelName, completionText string, perf *CompletionPerformance,
-	verbose *model.CompletionVerbose) *CompletionResponse {
+func SuccessResponse(completionId, modelName, completionText string, anchor CompletionAnchor,
+	perf *CompletionPerformance, verbose *model.CompletionVerbose) *CompletionResponse {
 
 	perf.TotalDuration = time.Since(perf.ReceiveTime).Milliseconds()
 	Metrics(modelName, string(model.StatusSuccess), perf)
@@ -153,7 +261,7 @@ func SuccessResponse(completionId, modelName, completionText string, perf *Compl
 		ID:      completionId,
 		Model:   modelName,
 		Object:  "text_completion",
-		Choices: []CompletionChoice{{Text: completionText}}, // 使用后置处理后的补全结果
+		Choices: []CompletionChoice{{Text: completionText, CompletionAnchor: anchor}}, // 使用后置处理后的补全结果
 		Created: int(perf.ReceiveTime.Unix()),
 		Usage:   *perf,
 		Status:  model.StatusSuccess,
@@ -0,0 +1,209 @@
+package completions
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+
+	"code-completion/pkg/config"
+
+	"go.uber.org/zap"
+)
+
+// 没有参与隐藏分实验(没有计算隐藏分)的请求在指标中的variant标签
+const NoScoreVariant = "none"
+
+// 指定的变体不在实验中
+var ErrVariantNotFound = errors.New("score variant not found")
+
+// 隐藏分权重的一个变体
+type scoreVariant struct {
+	name    string
+	traffic int
+	weights *HiddenScoreFilter
+}
+
+/**
+ * 隐藏分权重实验的运行时状态，创建后不再修改，冻结和推广时整体替换
+ * @description
+ * - variants按配置顺序排列，total为流量份额之和
+ * - frozen不为nil时所有客户端使用该变体
+ * - 只有一个变体时(只配置一个或已推广)，直接返回该变体
+ */
+type scoreExperimentSet struct {
+	name     string
+	variants []*scoreVariant
+	byName   map[string]*scoreVariant
+	total    int
+	frozen   *scoreVariant
+}
+
+// 实验的状态，用于管理接口查看
+type ScoreExperimentStatus struct {
+	Name     string         `json:"name"`
+	Variants map[string]int `json:"variants"` // 各变体的流量份额
+	Frozen   string         `json:"frozen,omitempty"`
+}
+
+var scoreExperiment atomic.Pointer[scoreExperimentSet]
+
+/**
+ * 按配置创建隐藏分权重实验，启动时调用
+ * @param {*config.ScoreExperimentConfig} cfg - 配置wrapper.score.experiment
+ * @returns {error} 变体名称为空或重复、流量份额不为正、权重文件无法读取时返回错误，仍使用之前的实验
+ */
+func InitScoreExperiment(cfg *config.ScoreExperimentConfig) error {
+	set, err := newScoreExperimentSet(cfg)
+	if err != nil {
+		return err
+	}
+	scoreExperiment.Store(set)
+	return nil
+}
+
+// 按配置创建隐藏分权重实验，不替换当前的实验
+func newScoreExperimentSet(cfg *config.ScoreExperimentConfig) (*scoreExperimentSet, error) {
+	set := &scoreExperimentSet{name: cfg.Name, byName: make(map[string]*scoreVariant)}
+	for i, v := range cfg.Variants {
+		if v.Name == "" {
+			return nil, fmt.Errorf("wrapper.score.experiment.variants[%d]: name is required", i)
+		}
+		if _, ok := set.byName[v.Name]; ok {
+			return nil, fmt.Errorf("wrapper.score.experiment.variants[%d]: duplicated name '%s'", i, v.Name)
+		}
+		if v.Traffic <= 0 {
+			return nil, fmt.Errorf("wrapper.score.experiment.variants[%d]: traffic of '%s' must be positive", i, v.Name)
+		}
+		weights := newDefaultHiddenScoreFilter()
+		if v.File != "" {
+			if weights = loadHiddenScoreFilter(v.File); weights == nil {
+				return nil, fmt.Errorf("wrapper.score.experiment.variants[%d]: cannot load weights of '%s' from '%s'", i, v.Name, v.File)
+			}
+		}
+		variant := &scoreVariant{name: v.Name, traffic: v.Traffic, weights: weights}
+		set.variants = append(set.variants, variant)
+		set.byName[v.Name] = variant
+		set.total += v.Traffic
+	}
+	return set, nil
+}
+
+// 当前的隐藏分实验，未初始化时按当前配置创建(配置无效时不做实验)
+func scoreExperiments() *scoreExperimentSet {
+	set := scoreExperiment.Load()
+	if set == nil {
+		if err := InitScoreExperiment(&config.Wrapper.Score.Experiment); err != nil {
+			zap.L().Error("Invalid config, hidden score experiment disabled", zap.Error(err))
+			scoreExperiment.CompareAndSwap(nil, &scoreExperimentSet{})
+		}
+		set = scoreExperiment.Load()
+	}
+	return set
+}
+
+/**
+ * 为客户端分配隐藏分权重的变体
+ * @param {string} clientID - 客户端ID
+ * @returns {*scoreVariant} 返回分配的变体，没有配置实验时返回nil
+ * @description
+ * - 分桶只依赖实验名称和客户端ID的哈希，同一客户端总是分到同一变体，重启后不变
+ * - 冻结或只有一个变体时不计算哈希
+ */
+func (s *scoreExperimentSet) assign(clientID string) *scoreVariant {
+	if s.frozen != nil {
+		return s.frozen
+	}
+	switch len(s.variants) {
+	case 0:
+		return nil
+	case 1:
+		return s.variants[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s.name))
+	h.Write([]byte{0})
+	h.Write([]byte(clientID))
+	bucket := int(h.Sum32() % uint32(s.total))
+	for _, v := range s.variants {
+		if bucket < v.traffic {
+			return v
+		}
+		bucket -= v.traffic
+	}
+	return s.variants[len(s.variants)-1]
+}
+
+// 实验的当前状态
+func (s *scoreExperimentSet) status() ScoreExperimentStatus {
+	status := ScoreExperimentStatus{Name: s.name, Variants: make(map[string]int)}
+	for _, v := range s.variants {
+		status.Variants[v.name] = v.traffic
+	}
+	if s.frozen != nil {
+		status.Frozen = s.frozen.name
+	}
+	return status
+}
+
+// 获取隐藏分实验的当前状态
+func ScoreExperimentState() ScoreExperimentStatus {
+	return scoreExperiments().status()
+}
+
+/**
+ * 冻结隐藏分实验，所有客户端使用指定的变体
+ * @param {string} name - 变体名称，为空时解除冻结，恢复按哈希分组
+ * @returns {ScoreExperimentStatus} 返回冻结后的实验状态
+ * @returns {error} 变体不在实验中时返回ErrVariantNotFound
+ * @description
+ * - 只修改运行时状态，重启后按配置恢复
+ * - 整体替换实验状态，正在计算隐藏分的请求仍使用替换前的状态
+ */
+func FreezeScoreVariant(name string) (ScoreExperimentStatus, error) {
+	for {
+		current := scoreExperiments()
+		next := *current
+		next.frozen = nil
+		if name != "" {
+			v, ok := current.byName[name]
+			if !ok {
+				return current.status(), fmt.Errorf("%w: %s", ErrVariantNotFound, name)
+			}
+			next.frozen = v
+		}
+		if scoreExperiment.CompareAndSwap(current, &next) {
+			zap.L().Info("Hidden score experiment frozen", zap.String("variant", name))
+			return next.status(), nil
+		}
+	}
+}
+
+/**
+ * 推广隐藏分权重的变体，结束实验
+ * @param {string} name - 变体名称
+ * @returns {ScoreExperimentStatus} 返回推广后的实验状态，只剩该变体
+ * @returns {error} 变体不在实验中时返回ErrVariantNotFound
+ * @description
+ * - 推广后所有客户端使用该变体的权重，无法再解除冻结
+ * - 只修改运行时状态，需要同时修改配置才能在重启后保持
+ */
+func PromoteScoreVariant(name string) (ScoreExperimentStatus, error) {
+	for {
+		current := scoreExperiments()
+		v, ok := current.byName[name]
+		if !ok {
+			return current.status(), fmt.Errorf("%w: %s", ErrVariantNotFound, name)
+		}
+		next := &scoreExperimentSet{
+			name:     current.name,
+			variants: []*scoreVariant{v},
+			byName:   map[string]*scoreVariant{name: v},
+			total:    v.traffic,
+		}
+		if scoreExperiment.CompareAndSwap(current, next) {
+			zap.L().Info("Hidden score variant promoted", zap.String("variant", name))
+			return next.status(), nil
+		}
+	}
+}
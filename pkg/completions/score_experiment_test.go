@@ -0,0 +1,117 @@
+package completions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test the traffic split of the hidden score experiment over many clients and the determinism of the assignment
+// go test ./pkg/completions/ -v -run Test_ScoreExperimentAssignment
+func Test_ScoreExperimentAssignment(t *testing.T) {
+	saved := scoreExperiment.Load()
+	defer scoreExperiment.Store(saved)
+
+	cfg := &config.ScoreExperimentConfig{Name: "retrain", Variants: []config.ScoreVariantConfig{
+		{Name: "control", Traffic: 90},
+		{Name: "retrained", Traffic: 10},
+	}}
+	if err := InitScoreExperiment(cfg); err != nil {
+		t.Fatal(err)
+	}
+	const clients = 20000
+	assigned := make(map[string]string, clients)
+	counts := map[string]int{}
+	for i := 0; i < clients; i++ {
+		clientID := fmt.Sprintf("client-%d", i)
+		v := scoreExperiments().assign(clientID)
+		assigned[clientID] = v.name
+		counts[v.name]++
+	}
+	for name, share := range map[string]float64{"control": 0.9, "retrained": 0.1} {
+		if got := float64(counts[name]) / clients; math.Abs(got-share) > 0.02 {
+			t.Errorf("expected %s to get %.2f of the clients, got %.3f", name, share, got)
+		}
+	}
+
+	// 重新创建(模拟重启)后分组不变
+	if err := InitScoreExperiment(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for clientID, name := range assigned {
+		if v := scoreExperiments().assign(clientID); v.name != name {
+			t.Fatalf("assignment of %s changed from %s to %s", clientID, name, v.name)
+		}
+	}
+
+	// 冻结后所有客户端使用同一变体，解除后恢复分组
+	if _, err := FreezeScoreVariant("missing"); !errors.Is(err, ErrVariantNotFound) {
+		t.Errorf("expected variant not found, got %v", err)
+	}
+	if status, err := FreezeScoreVariant("retrained"); err != nil || status.Frozen != "retrained" {
+		t.Fatalf("unexpected freeze result %+v %v", status, err)
+	}
+	for clientID := range assigned {
+		if v := scoreExperiments().assign(clientID); v.name != "retrained" {
+			t.Fatalf("expected frozen variant for %s, got %s", clientID, v.name)
+		}
+	}
+	FreezeScoreVariant("")
+	for clientID, name := range assigned {
+		if v := scoreExperiments().assign(clientID); v.name != name {
+			t.Fatalf("assignment of %s not restored after unfreeze", clientID)
+		}
+	}
+
+	// 推广后只剩一个变体
+	if status, err := PromoteScoreVariant("retrained"); err != nil || len(status.Variants) != 1 {
+		t.Fatalf("unexpected promote result %+v %v", status, err)
+	}
+	if v := scoreExperiments().assign("client-0"); v.name != "retrained" {
+		t.Errorf("expected the promoted variant, got %s", v.name)
+	}
+
+	// 变体无效时不替换实验
+	err := InitScoreExperiment(&config.ScoreExperimentConfig{Variants: []config.ScoreVariantConfig{{Name: "broken", Traffic: 1, File: "testdata/missing.json"}}})
+	if err == nil {
+		t.Error("expected an error for missing weights file")
+	}
+}
+
+// to test the assigned variant is used for the hidden score and recorded in Verbose
+// go test ./pkg/completions/ -v -run Test_ScoreExperimentVerbose
+func Test_ScoreExperimentVerbose(t *testing.T) {
+	saved := scoreExperiment.Load()
+	defer scoreExperiment.Store(saved)
+	scoreExperiment.Store(nil)
+
+	filter := NewHiddenScoreFilter("", 0.3)
+	judge := func() *CompletionInput {
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "client-verbose",
+			HideScores: &HiddenScoreOptions{DocumentLength: 100, PromptEndPos: 50}}}
+		filter.Judge(NewCompletionContext(context.Background(), &CompletionPerformance{}), in)
+		return in
+	}
+
+	// 没有配置实验
+	in := judge()
+	rsp := &CompletionResponse{}
+	in.AttachVerbose(rsp)
+	if in.ScoreVariant != NoScoreVariant || rsp.Verbose != nil {
+		t.Errorf("expected no variant without experiment, got %q", in.ScoreVariant)
+	}
+
+	// 只有一个变体时所有客户端使用该变体
+	if err := InitScoreExperiment(&config.ScoreExperimentConfig{Variants: []config.ScoreVariantConfig{{Name: "only", Traffic: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	in = judge()
+	in.AttachVerbose(rsp)
+	if in.ScoreVariant != "only" || rsp.Verbose == nil || rsp.Verbose.Input["scoreVariant"] != "only" {
+		t.Errorf("expected the variant recorded in verbose, got %q", in.ScoreVariant)
+	}
+}
@@ -0,0 +1,365 @@
+package completions
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// 阈值调整方向
+const (
+	TuneUp   = "up"
+	TuneDown = "down"
+	TuneHold = "hold"
+)
+
+// 候选阈值的数量，粒度为1/thresholdSteps
+const thresholdSteps = 20
+
+// 一次补全展示后的采纳结果
+type scoreSample struct {
+	time     time.Time
+	score    float64
+	accepted bool
+}
+
+// 参与阈值调整的补全的语言和隐藏分，随返回的补全记录，等待反馈
+type tunedScore struct {
+	language string
+	score    float64
+}
+
+/**
+ * 某种语言的运行时阈值覆盖
+ * @description
+ * - Threshold: 当前生效的隐藏分阈值，覆盖全局配置
+ * - Direction: 最近一次调整的方向(up/down/hold)
+ * - AdjustedAt: 最近一次调整的时间
+ * - Samples: 最近一次调整时窗口内的样本数
+ * - Precision: 最近一次调整时窗口内的采纳率
+ */
+type ThresholdOverride struct {
+	Threshold  float64   `json:"threshold"`
+	Direction  string    `json:"direction"`
+	AdjustedAt time.Time `json:"adjusted_at"`
+	Samples    int       `json:"samples"`
+	Precision  float64   `json:"precision"`
+}
+
+/**
+ * 按语言自动调整隐藏分阈值
+ * @description
+ * - 补全成功返回且内容非空后才记录为样本，按completion_id随返回的补全保存(见TrackServed)，
+ *   下一次请求的反馈(见applyAcceptance)给出该补全的采纳结果，同一客户端并发的补全互不覆盖
+ * - 按语言在滑动窗口内统计不同隐藏分的采纳率，周期性地调整各语言阈值
+ * - 语言为languageLabel规范化后的标识，没有语言配置的语言共用other，样本、覆盖和指标的数量有上限
+ * - 调整结果作为运行时覆盖保存，可通过/api/thresholds查看和撤销
+ * - 请求是否参与自动调整(记录样本、使用覆盖的阈值)由调用方按请求的开关判断，见feature_flag.AutoTune
+ */
+type ThresholdTuner struct {
+	mutex     sync.Mutex
+	samples   map[string][]scoreSample      // 各语言的采纳样本，key为语言
+	overrides map[string]*ThresholdOverride // 各语言的阈值覆盖，key为语言
+	stop      chan struct{}                 // 关闭时调整协程退出，没有运行时为nil
+}
+
+var Tuner = NewThresholdTuner()
+
+func NewThresholdTuner() *ThresholdTuner {
+	return &ThresholdTuner{
+		samples:   make(map[string][]scoreSample),
+		overrides: make(map[string]*ThresholdOverride),
+	}
+}
+
+func tuneConfig() *config.ScoreTuneConfig {
+	return &config.Wrapper.Score.AutoTune
+}
+
+/**
+ * Start the periodic threshold adjustment routine
+ * @description
+ * - Runs whatever the kill switch, the requests switched on by feature_flag.AutoTune may change at runtime
+ * - Adjusts thresholds every configured interval, languages without samples are left alone
+ * - Does nothing when the routine is already running; it runs until Stop
+ */
+func (t *ThresholdTuner) Start() {
+	cfg := tuneConfig()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stop != nil {
+		return
+	}
+	stop, interval := make(chan struct{}), cfg.Interval
+	t.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				t.Adjust(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	zap.L().Info("Start score threshold tuner", zap.Duration("interval", cfg.Interval),
+		zap.Float64("targetPrecision", cfg.TargetPrecision))
+}
+
+// 停止定时调整，见StreamController.Stop；之后可以再次Start
+func (t *ThresholdTuner) Stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+}
+
+/**
+ * Get the effective threshold of the language
+ * @param {string} language - Language of the completion
+ * @param {float64} def - Threshold configured globally
+ * @returns {float64} Returns the runtime override if any, otherwise def
+ */
+func (t *ThresholdTuner) Threshold(language string, def float64) float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if o, ok := t.overrides[language]; ok {
+		return o.Threshold
+	}
+	return def
+}
+
+/**
+ * Record the acceptance of a served completion as a sample
+ * @param {AcceptanceFeedback} feedback - Feedback of the completion, see applyAcceptance
+ * @description
+ * - Only completions delivered with their score tuned (see HiddenScoreFilter.Judge) make samples
+ * - Drops the samples of the language older than the window, so they stay bounded between adjustments
+ */
+func (t *ThresholdTuner) Feedback(feedback *AcceptanceFeedback) {
+	if feedback == nil || feedback.tuned == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	s, now := feedback.tuned, time.Now()
+	samples := append(t.samples[s.language], scoreSample{
+		time:     now,
+		score:    s.score,
+		accepted: feedback.Accepted,
+	})
+	t.samples[s.language] = dropExpiredSamples(samples, now.Add(-tuneConfig().Window))
+}
+
+/**
+ * Adjust threshold of each language by the acceptance in the sliding window
+ * @param {time.Time} now - Time of the adjustment
+ * @description
+ * - Drops samples older than the window
+ * - Skips languages with fewer samples than MinSamples
+ * - Moves each threshold toward the target by at most MaxStep
+ */
+func (t *ThresholdTuner) Adjust(now time.Time) {
+	cfg := tuneConfig()
+	def := config.Wrapper.Score.Threshold
+	if def == 0 {
+		def = 0.3
+	}
+	expire := now.Add(-cfg.Window)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for language, samples := range t.samples {
+		samples = dropExpiredSamples(samples, expire)
+		t.samples[language] = samples
+		if len(samples) == 0 {
+			delete(t.samples, language)
+			continue
+		}
+		target, precision, ok := computeThreshold(samples, cfg.TargetPrecision, cfg.MinSamples)
+		if !ok {
+			continue
+		}
+		current := def
+		if o, exists := t.overrides[language]; exists {
+			current = o.Threshold
+		}
+		next, direction := stepThreshold(current, target, cfg.MaxStep, cfg.MinThreshold, cfg.MaxThreshold)
+		t.overrides[language] = &ThresholdOverride{
+			Threshold:  next,
+			Direction:  direction,
+			AdjustedAt: now,
+			Samples:    len(samples),
+			Precision:  precision,
+		}
+		metrics.UpdateScoreThreshold(language, next)
+		if direction != TuneHold {
+			zap.L().Info("Adjust score threshold", zap.String("language", language),
+				zap.Float64("from", current), zap.Float64("to", next),
+				zap.Int("samples", len(samples)), zap.Float64("precision", precision))
+		}
+	}
+}
+
+// 撤销所有语言的阈值覆盖，恢复为全局阈值
+func (t *ThresholdTuner) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for language := range t.overrides {
+		metrics.DeleteScoreThreshold(language)
+	}
+	t.overrides = make(map[string]*ThresholdOverride)
+	t.samples = make(map[string][]scoreSample)
+}
+
+// 获取阈值调整的统计信息
+func (t *ThresholdTuner) GetStats() map[string]interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	overrides := make(map[string]ThresholdOverride, len(t.overrides))
+	for language, o := range t.overrides {
+		overrides[language] = *o
+	}
+	samples := make(map[string]int, len(t.samples))
+	for language, s := range t.samples {
+		samples[language] = len(s)
+	}
+	return map[string]interface{}{
+		"enabled":   feature_flag.FromContext(nil).Enabled(feature_flag.AutoTune),
+		"overrides": overrides,
+		"samples":   samples,
+	}
+}
+
+// 丢弃早于expire的样本，样本按时间先后追加
+func dropExpiredSamples(samples []scoreSample, expire time.Time) []scoreSample {
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].time.Before(expire)
+	})
+	return append(samples[:0], samples[i:]...)
+}
+
+/**
+ * Compute the lowest threshold meeting the target precision
+ * @param {[]scoreSample} samples - Shown completions with their acceptance
+ * @param {float64} target - Minimum acceptance rate of completions above the threshold
+ * @param {int} minSamples - Minimum samples required to compute a threshold
+ * @returns {float64, float64, bool} Returns the threshold, the acceptance rate above it, and false if not enough samples
+ * @description
+ * - Candidate thresholds are multiples of 0.05 in [0, 1]
+ * - The lowest candidate whose acceptance rate reaches the target wins, keeping as many completions as possible
+ * - When no candidate reaches the target, returns 1.0 so the threshold keeps moving up
+ * @example
+ * threshold, precision, ok := computeThreshold(samples, 0.3, 100)
+ */
+func computeThreshold(samples []scoreSample, target float64, minSamples int) (float64, float64, bool) {
+	if len(samples) == 0 || len(samples) < minSamples {
+		return 0, 0, false
+	}
+	sorted := make([]scoreSample, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].score < sorted[j].score
+	})
+	// 后缀累计：accepted[i]为sorted[i:]中被采纳的数量
+	accepted := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		accepted[i] = accepted[i+1]
+		if sorted[i].accepted {
+			accepted[i]++
+		}
+	}
+	for k := 0; k <= thresholdSteps; k++ {
+		threshold := float64(k) / thresholdSteps
+		i := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i].score >= threshold
+		})
+		n := len(sorted) - i
+		if n == 0 {
+			break
+		}
+		precision := float64(accepted[i]) / float64(n)
+		if precision >= target {
+			return threshold, precision, true
+		}
+	}
+	return 1.0, float64(accepted[0]) / float64(len(sorted)), true
+}
+
+/**
+ * Move current threshold toward target with a clamped step
+ * @param {float64} current - Threshold in effect
+ * @param {float64} target - Threshold computed from the window
+ * @param {float64} maxStep - Maximum movement per adjustment
+ * @param {float64} min - Lower bound of the threshold
+ * @param {float64} max - Upper bound of the threshold
+ * @returns {float64, string} Returns the new threshold and the direction of the movement
+ */
+func stepThreshold(current, target, maxStep, min, max float64) (float64, string) {
+	next := target
+	if next > current+maxStep {
+		next = current + maxStep
+	} else if next < current-maxStep {
+		next = current - maxStep
+	}
+	if next < min {
+		next = min
+	}
+	if next > max {
+		next = max
+	}
+	switch {
+	case next > current+1e-9:
+		return next, TuneUp
+	case next < current-1e-9:
+		return next, TuneDown
+	default:
+		return current, TuneHold
+	}
+}
+
+// 处于安全模式的模型的隐藏分阈值提高幅度，key为模型名称，由补全质量异常检测设置
+var thresholdBoosts sync.Map
+
+// 设置模型的隐藏分阈值提高幅度，boost不大于0时撤销
+func SetThresholdBoost(modelName string, boost float64) {
+	if boost <= 0 {
+		thresholdBoosts.Delete(modelName)
+		return
+	}
+	thresholdBoosts.Store(modelName, boost)
+}
+
+// 获取模型的隐藏分阈值提高幅度，不在安全模式时为0
+func ThresholdBoost(modelName string) float64 {
+	if boost, ok := thresholdBoosts.Load(modelName); ok {
+		return boost.(float64)
+	}
+	return 0
+}
+
+// 启动预热期间AUTO触发的隐藏分阈值提高幅度，保存float64的位
+var warmupBoost atomic.Uint64
+
+// 设置启动预热的隐藏分阈值提高幅度，预热结束时设为0
+func SetWarmupBoost(boost float64) {
+	warmupBoost.Store(math.Float64bits(max(boost, 0)))
+}
+
+// 获取启动预热的隐藏分阈值提高幅度，不在预热时为0
+func WarmupBoost() float64 {
+	return math.Float64frombits(warmupBoost.Load())
+}
@@ -0,0 +1,219 @@
+package completions
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// synthSamples generates n samples per bucket, accepted with the rate returned by accept(score)
+func synthSamples(accept func(score float64) float64, n int) []scoreSample {
+	samples := make([]scoreSample, 0)
+	now := time.Now()
+	for b := 0; b < 20; b++ {
+		score := float64(b)*0.05 + 0.025
+		hits := int(accept(score)*float64(n) + 0.5)
+		for i := 0; i < n; i++ {
+			samples = append(samples, scoreSample{time: now, score: score, accepted: i < hits})
+		}
+	}
+	return samples
+}
+
+// to test threshold computation from synthetic acceptance distributions
+// go test ./pkg/completions/ -v -run Test_ComputeThreshold
+func Test_ComputeThreshold(t *testing.T) {
+	cases := []struct {
+		name      string
+		accept    func(score float64) float64
+		target    float64
+		expected  float64
+		precision float64
+	}{
+		// 采纳率等于分数，阈值t以上平均采纳率为(1+t)/2
+		{"linear", func(s float64) float64 { return s }, 0.6, 0.2, 0.6},
+		{"all accepted", func(s float64) float64 { return 1 }, 0.3, 0, 1},
+		{"none accepted", func(s float64) float64 { return 0 }, 0.3, 1, 0},
+		// 0.5以下从不采纳，以上全部采纳
+		{"step", func(s float64) float64 {
+			if s < 0.5 {
+				return 0
+			}
+			return 1
+		}, 0.95, 0.5, 1},
+	}
+	for _, c := range cases {
+		threshold, precision, ok := computeThreshold(synthSamples(c.accept, 10), c.target, 100)
+		if !ok {
+			t.Errorf("%s: expected threshold computed", c.name)
+			continue
+		}
+		if math.Abs(threshold-c.expected) > 1e-9 {
+			t.Errorf("%s: expected threshold %.2f, got %.2f", c.name, c.expected, threshold)
+		}
+		if math.Abs(precision-c.precision) > 1e-9 {
+			t.Errorf("%s: expected precision %.2f, got %.2f", c.name, c.precision, precision)
+		}
+	}
+
+	if _, _, ok := computeThreshold(synthSamples(func(s float64) float64 { return s }, 1), 0.3, 100); ok {
+		t.Error("expected no threshold with too few samples")
+	}
+}
+
+// to test clamped threshold movement
+// go test ./pkg/completions/ -v -run Test_StepThreshold
+func Test_StepThreshold(t *testing.T) {
+	cases := []struct {
+		current, target float64
+		expected        float64
+		direction       string
+	}{
+		{0.3, 0.8, 0.35, TuneUp},
+		{0.3, 0.0, 0.25, TuneDown},
+		{0.3, 0.32, 0.32, TuneUp},
+		{0.3, 0.3, 0.3, TuneHold},
+		{0.94, 1.0, 0.95, TuneUp},
+		{0.05, 0.0, 0.05, TuneHold},
+	}
+	for _, c := range cases {
+		next, direction := stepThreshold(c.current, c.target, 0.05, 0.05, 0.95)
+		if math.Abs(next-c.expected) > 1e-9 || direction != c.direction {
+			t.Errorf("step %.2f->%.2f: expected %.2f %s, got %.2f %s",
+				c.current, c.target, c.expected, c.direction, next, direction)
+		}
+	}
+}
+
+// to test the acceptance samples expiring on record, without waiting for the next adjustment
+// go test ./pkg/completions/ -v -run Test_FeedbackExpiresSamples
+func Test_FeedbackExpiresSamples(t *testing.T) {
+	saved := config.Wrapper.Score.AutoTune
+	defer func() { config.Wrapper.Score.AutoTune = saved }()
+	config.Wrapper.Score.AutoTune = config.ScoreTuneConfig{Enabled: true, Window: time.Hour}
+
+	tuner := NewThresholdTuner()
+	stale := time.Now().Add(-2 * time.Hour)
+	for i := 0; i < 100; i++ {
+		tuner.samples["python"] = append(tuner.samples["python"], scoreSample{time: stale, score: 0.5})
+	}
+	tuner.Feedback(&AcceptanceFeedback{Accepted: true, tuned: &tunedScore{language: "python", score: 0.6}})
+	if samples := tuner.samples["python"]; len(samples) != 1 || !samples[0].accepted {
+		t.Errorf("expected only the new sample kept, got %d samples", len(samples))
+	}
+}
+
+// to test that only delivered completions become samples, each with the feedback of its own completion_id
+// go test ./pkg/completions/ -v -run Test_TunerServedSamples
+func Test_TunerServedSamples(t *testing.T) {
+	saved := config.Wrapper.Score.AutoTune
+	defer func() { config.Wrapper.Score.AutoTune = saved }()
+	config.Wrapper.Score.AutoTune = config.ScoreTuneConfig{Enabled: true, Window: time.Hour}
+
+	tuner := NewThresholdTuner()
+	tuner.overrides["go"] = &ThresholdOverride{Threshold: -1}
+	filter := newTunedFilter(tuner)
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	request := func(completionID string, scores HiddenScoreOptions) *CompletionInput {
+		scores.DocumentLength, scores.PromptEndPos = 100, 50
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "tune-1", CompletionID: completionID,
+			LanguageID: "go", HideScores: &scores}}
+		if scores.PreviousCompletionID != "" {
+			in.applyAcceptance(c)
+		}
+		if code := filter.Judge(c, in); code != Accepted {
+			t.Fatalf("expected %s accepted, got %s", completionID, code)
+		}
+		return in
+	}
+	serve := func(completionID string, status model.CompletionStatus) {
+		request(completionID, HiddenScoreOptions{}).TrackServed(nil, &CompletionResponse{Model: "tune-model",
+			Status: status, Choices: []CompletionChoice{{Text: "a := 1"}}})
+	}
+
+	// 同一客户端并发的两个补全，以及一个没有成功返回的补全
+	serve("tune-a", model.StatusSuccess)
+	serve("tune-b", model.StatusSuccess)
+	serve("tune-c", model.StatusTimeout)
+	if n := len(tuner.samples["go"]); n != 0 {
+		t.Fatalf("expected no sample before feedback, got %d", n)
+	}
+
+	request("tune-d", HiddenScoreOptions{PreviousLabel: 1, PreviousCompletionID: "tune-a"})
+	// 手动触发的请求同样告知被反馈的补全的采纳结果
+	manual := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "tune-1", LanguageID: "go", TriggerMode: "MANUAL",
+		HideScores: &HiddenScoreOptions{PreviousCompletionID: "tune-b"}}}
+	manual.applyAcceptance(c)
+	filter.Judge(c, manual)
+	request("tune-e", HiddenScoreOptions{PreviousLabel: 1, PreviousCompletionID: "tune-c"})
+
+	samples := tuner.samples["go"]
+	if len(samples) != 2 || !samples[0].accepted || samples[1].accepted {
+		t.Errorf("expected tune-a accepted and tune-b rejected, got %+v", samples)
+	}
+}
+
+// to test the adjustment routine stopping and starting again
+// go test ./pkg/completions/ -v -run Test_TunerStop
+func Test_TunerStop(t *testing.T) {
+	saved := config.Wrapper.Score.AutoTune
+	defer func() { config.Wrapper.Score.AutoTune = saved }()
+	config.Wrapper.Score.AutoTune = config.ScoreTuneConfig{Interval: time.Hour, Window: time.Hour}
+
+	tuner := NewThresholdTuner()
+	tuner.Start()
+	running := tuner.stop
+	tuner.Start()
+	if running == nil || tuner.stop != running {
+		t.Fatal("expected one running routine")
+	}
+	tuner.Stop()
+	select {
+	case <-running:
+	default:
+		t.Error("expected the routine told to stop")
+	}
+	tuner.Stop()
+	tuner.Start()
+	defer tuner.Stop()
+	if tuner.stop == nil || tuner.stop == running {
+		t.Error("expected a new routine after Stop")
+	}
+}
+
+// 使用指定阈值调整的隐藏分过滤器，没有隐藏分实验和阈值提高
+func newTunedFilter(tuner *ThresholdTuner) *HiddenScoreFilter {
+	h := NewHiddenScoreFilter("", 0.3)
+	set := &scoreExperimentSet{}
+	h.now, h.tuner, h.boost = time.Now, tuner, func(string, string) float64 { return 0 }
+	h.experiments = func() *scoreExperimentSet { return set }
+	return h
+}
+
+// to test unknown languages sharing the other threshold, so made-up language ids do not grow the tuner
+// go test ./pkg/completions/ -v -run Test_TunerLanguageKey
+func Test_TunerLanguageKey(t *testing.T) {
+	saved := config.Wrapper.Score.AutoTune
+	defer func() { config.Wrapper.Score.AutoTune = saved }()
+	config.Wrapper.Score.AutoTune = config.ScoreTuneConfig{Enabled: true, Window: time.Hour}
+
+	tuner := NewThresholdTuner()
+	tuner.overrides["other"] = &ThresholdOverride{Threshold: 2}
+	tuner.overrides["go"] = &ThresholdOverride{Threshold: -1}
+	filter := newTunedFilter(tuner)
+	judge := func(language string) RejectCode {
+		in := &CompletionInput{CompletionRequest: CompletionRequest{ClientID: "c1", LanguageID: language,
+			HideScores: &HiddenScoreOptions{DocumentLength: 100, PromptEndPos: 50}}}
+		return filter.Judge(NewCompletionContext(context.Background(), &CompletionPerformance{}), in)
+	}
+	if code := judge("made-up-lang"); code != LowHiddenScore {
+		t.Errorf("expected the other threshold for an unknown language, got %s", code)
+	}
+	if code := judge("go"); code != Accepted {
+		t.Errorf("expected the go threshold, got %s", code)
+	}
+}
@@ -0,0 +1,187 @@
+package completions
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 单文件组件(SFC)的顶层区块
+const (
+	SFCScript   = "script"
+	SFCTemplate = "template"
+	SFCStyle    = "style"
+)
+
+/**
+ * 光标所在的单文件组件区块
+ * @description
+ * - Block: 区块类型(script/template/style)
+ * - Language: 区块的语言，由lang属性决定，缺省时按区块类型取默认语言
+ * - Outside: 区块之外的文件内容，作为代码上下文提供给模型
+ */
+type SFCRegion struct {
+	Block    string `json:"block"`
+	Language string `json:"language"`
+	Outside  string `json:"-"`
+}
+
+// 顶层区块的起始标签，顶层区块的标签位于行首，嵌套的<template>不会匹配
+var sfcOpenPattern = regexp.MustCompile(`(?m)^<(script|template|style)(\s[^>]*)?>`)
+
+var sfcLangPattern = regexp.MustCompile(`\blang\s*=\s*["']?([A-Za-z0-9_-]+)`)
+
+// 各顶层区块的结束标签
+var sfcClosePatterns = map[string]*regexp.Regexp{
+	SFCScript:   regexp.MustCompile(`(?m)^</script\s*>`),
+	SFCTemplate: regexp.MustCompile(`(?m)^</template\s*>`),
+	SFCStyle:    regexp.MustCompile(`(?m)^</style\s*>`),
+}
+
+// lang属性到语言标识的映射
+var sfcLanguages = map[string]string{
+	"ts":         "typescript",
+	"typescript": "typescript",
+	"tsx":        "typescriptreact",
+	"js":         "javascript",
+	"javascript": "javascript",
+	"jsx":        "javascriptreact",
+	"html":       "html",
+	"pug":        "pug",
+	"css":        "css",
+	"scss":       "scss",
+	"sass":       "sass",
+	"less":       "less",
+	"stylus":     "stylus",
+	"styl":       "stylus",
+	"postcss":    "postcss",
+}
+
+// 缺少lang属性时各区块的默认语言
+var sfcDefaultLanguages = map[string]string{
+	SFCScript:   "javascript",
+	SFCTemplate: "html",
+	SFCStyle:    "css",
+}
+
+// 样式语言
+var styleLanguages = map[string]bool{
+	"css": true, "scss": true, "sass": true, "less": true, "stylus": true, "postcss": true,
+}
+
+// IsStyleLanguage 判断是否为样式语言
+func IsStyleLanguage(language string) bool {
+	return styleLanguages[strings.ToLower(language)]
+}
+
+// 是否为单文件组件的语言
+func isSFCLanguage(language string) bool {
+	switch strings.ToLower(language) {
+	case "vue", "svelte":
+		return true
+	}
+	return false
+}
+
+// 区块的语言，由起始标签的lang属性决定
+func sfcBlockLanguage(block, attrs string) string {
+	if m := sfcLangPattern.FindStringSubmatch(attrs); m != nil {
+		if lang, ok := sfcLanguages[strings.ToLower(m[1])]; ok {
+			return lang
+		}
+		return strings.ToLower(m[1])
+	}
+	return sfcDefaultLanguages[block]
+}
+
+/**
+ * Locate the top-level block of a Vue/Svelte single-file component enclosing the cursor
+ * @param {string} language - Language ID reported by the client
+ * @param {string} prefix - Text before the cursor
+ * @param {string} suffix - Text after the cursor
+ * @returns {*SFCRegion, string, string} Returns the region, and prefix/suffix restricted to the block; nil if not located
+ * @description
+ * - Blocks are <script>, <template> and <style> tags starting at the beginning of a line
+ * - The language of the block comes from its lang attribute
+ * - In Svelte the markup outside script/style is treated as the template block
+ * - In Vue the cursor outside any block is not located
+ * @example
+ * region, prefix, suffix := detectSFCRegion("vue", "<script setup lang=\"ts\">\nconst a = ", "1\n</script>\n")
+ * // region.Block = "script", region.Language = "typescript", prefix = "\nconst a = ", suffix = "1\n"
+ */
+func detectSFCRegion(language, prefix, suffix string) (*SFCRegion, string, string) {
+	if !isSFCLanguage(language) {
+		return nil, prefix, suffix
+	}
+	opens := sfcOpenPattern.FindAllStringSubmatchIndex(prefix, -1)
+	if len(opens) > 0 {
+		open := opens[len(opens)-1]
+		block := prefix[open[2]:open[3]]
+		attrs := ""
+		if open[4] >= 0 {
+			attrs = prefix[open[4]:open[5]]
+		}
+		closePattern := sfcClosePatterns[block]
+		if !closePattern.MatchString(prefix[open[1]:]) {
+			blockSuffix, after := suffix, ""
+			if loc := closePattern.FindStringIndex(suffix); loc != nil {
+				blockSuffix, after = suffix[:loc[0]], suffix[loc[1]:]
+			}
+			return &SFCRegion{
+				Block:    block,
+				Language: sfcBlockLanguage(block, attrs),
+				Outside:  joinOutside(prefix[:open[0]], after),
+			}, prefix[open[1]:], blockSuffix
+		}
+	}
+	if strings.ToLower(language) != "svelte" {
+		return nil, prefix, suffix
+	}
+	// Svelte的标记内容位于script/style之外，范围为前后最近的区块之间
+	start := 0
+	for _, pattern := range sfcClosePatterns {
+		locs := pattern.FindAllStringIndex(prefix, -1)
+		if len(locs) > 0 && locs[len(locs)-1][1] > start {
+			start = locs[len(locs)-1][1]
+		}
+	}
+	end := len(suffix)
+	if loc := sfcOpenPattern.FindStringIndex(suffix); loc != nil {
+		end = loc[0]
+	}
+	return &SFCRegion{
+		Block:    SFCTemplate,
+		Language: sfcDefaultLanguages[SFCTemplate],
+		Outside:  joinOutside(prefix[:start], suffix[end:]),
+	}, prefix[start:], suffix[:end]
+}
+
+// 拼接区块前后的文件内容
+func joinOutside(before, after string) string {
+	parts := make([]string, 0, 2)
+	for _, part := range []string{before, after} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// 过滤和修剪使用的语言：光标位于单文件组件的区块内时为区块的语言
+func (in *CompletionInput) EffectiveLanguage() string {
+	if in.Region != nil {
+		return in.Region.Language
+	}
+	return in.LanguageID
+}
+
+// 将区块之外的文件内容追加到代码上下文，靠近前缀的内容在截断时优先保留
+func (in *CompletionInput) joinRegionContext() {
+	if in.Region == nil || in.Region.Outside == "" {
+		return
+	}
+	if in.Processed.CodeContext == "" {
+		in.Processed.CodeContext = in.Region.Outside
+		return
+	}
+	in.Processed.CodeContext = in.Processed.CodeContext + "\n\n" + in.Region.Outside
+}
@@ -0,0 +1,130 @@
+package completions
+
+import (
+	"strings"
+	"testing"
+)
+
+const sfcFile = `<template>
+  <div class="app">
+    <template v-if="ok">
+      <span>{{ msg }}</span>
+    </template>
+    <button @click="add">|</button>
+  </div>
+</template>
+
+<script setup lang="ts">
+import { ref } from 'vue'
+const count = ref(0)
+function add() {
+  |
+}
+</script>
+
+<style scoped lang="scss">
+.app {
+  color: red;
+  |
+}
+</style>
+`
+
+// newSFCInput places the cursor at the n-th "|" of the file
+func newSFCInput(language, file string, n int) *CompletionInput {
+	parts := strings.Split(file, "|")
+	prefix := strings.Join(parts[:n+1], "")
+	suffix := strings.Join(parts[n+1:], "")
+	return &CompletionInput{
+		CompletionRequest: CompletionRequest{
+			LanguageID: language,
+			Prompts:    &PromptOptions{Prefix: prefix, Suffix: suffix},
+		},
+	}
+}
+
+// to test locating the block enclosing the cursor in single-file components
+// go test ./pkg/completions/ -v -run Test_SFCRegion
+func Test_SFCRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		cursor   int
+		block    string
+		language string
+		prefix   string
+		suffix   string
+		outside  string
+	}{
+		{"cursor-in-template", 0, SFCTemplate, "html", `<span>{{ msg }}</span>`, `</button>`, `<script setup lang="ts">`},
+		{"cursor-in-script-setup-ts", 1, SFCScript, "typescript", `const count = ref(0)`, "\n}\n", `<div class="app">`},
+		{"cursor-in-scss-style", 2, SFCStyle, "scss", `color: red;`, "\n}\n", `function add()`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := newSFCInput("vue", sfcFile, tt.cursor)
+			in.GetPrompts()
+			if in.Region == nil {
+				t.Fatal("expected the cursor to be located in a block")
+			}
+			if in.Region.Block != tt.block || in.Region.Language != tt.language {
+				t.Errorf("expected %s/%s, got %s/%s", tt.block, tt.language, in.Region.Block, in.Region.Language)
+			}
+			if in.EffectiveLanguage() != tt.language {
+				t.Errorf("expected effective language %s, got %s", tt.language, in.EffectiveLanguage())
+			}
+			if !strings.Contains(in.Processed.Prefix, tt.prefix) || strings.Contains("\n"+in.Processed.Prefix, "\n<"+tt.block) {
+				t.Errorf("expected prefix restricted to the block, got %q", in.Processed.Prefix)
+			}
+			if !strings.HasPrefix(in.Processed.Suffix, tt.suffix) || strings.Contains("\n"+in.Processed.Suffix, "\n</"+tt.block) {
+				t.Errorf("expected suffix restricted to the block, got %q", in.Processed.Suffix)
+			}
+			in.joinRegionContext()
+			if !strings.Contains(in.Processed.CodeContext, tt.outside) {
+				t.Errorf("expected the rest of the file in code context, got %q", in.Processed.CodeContext)
+			}
+		})
+	}
+
+	// files other than vue/svelte are not split
+	in := newSFCInput("typescript", sfcFile, 1)
+	in.GetPrompts()
+	if in.Region != nil || in.EffectiveLanguage() != "typescript" {
+		t.Errorf("expected no region for typescript, got %+v", in.Region)
+	}
+}
+
+// to test the svelte markup between script and style blocks
+// go test ./pkg/completions/ -v -run Test_SvelteMarkup
+func Test_SvelteMarkup(t *testing.T) {
+	file := "<script>\n  let name = 'world'\n</script>\n\n<h1>Hello |</h1>\n\n<style>\n  h1 { color: red; }\n</style>\n"
+	in := newSFCInput("svelte", file, 0)
+	in.GetPrompts()
+	if in.Region == nil || in.Region.Block != SFCTemplate || in.Region.Language != "html" {
+		t.Fatalf("expected markup region, got %+v", in.Region)
+	}
+	if in.Processed.Prefix != "\n\n<h1>Hello " || in.Processed.Suffix != "</h1>\n\n" {
+		t.Errorf("unexpected prefix/suffix %q %q", in.Processed.Prefix, in.Processed.Suffix)
+	}
+}
+
+// to test that css content is only judged outside script and style blocks
+// go test ./pkg/completions/ -v -run Test_SFCCssContent
+func Test_SFCCssContent(t *testing.T) {
+	css := "  color: red;\n  margin: 0;\n"
+	tests := []struct {
+		language  string
+		block     string
+		discarded bool
+	}{
+		{"scss", SFCStyle, false},
+		{"typescript", SFCScript, false},
+		{"html", SFCTemplate, true},
+		{"vue", "", true},
+	}
+	for _, tt := range tests {
+		ctx := &PrunerContext{Language: tt.language, Block: tt.block, CompletionCode: css}
+		if discarded := (&CssContentDiscarder{}).Process(ctx); discarded != tt.discarded {
+			t.Errorf("%s/%s: expected discarded=%v, got %v", tt.language, tt.block, tt.discarded, discarded)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 微补全类型
+const (
+	ShapeIdentifier = "identifier" // 光标紧跟正在输入的标识符(如原地重命名)，只补全标识符
+	ShapeImport     = "import"     // 光标在导入语句中，只补全导入路径
+)
+
+// 各微补全类型追加的停用词
+var shapeStopWords = map[string][]string{
+	ShapeIdentifier: {" ", "\t", "\n"},
+	ShapeImport:     {"\n"},
+}
+
+/**
+ * 微补全的识别结果
+ * @description
+ * - Shape: 微补全类型(identifier/import)
+ * - LinePrefix/LineSuffix: 命中规则的正则
+ * - Stop: 追加的停用词，强制单行
+ * - MaxTokens: 输出token数上限
+ */
+type CompletionShape struct {
+	Shape      string   `json:"shape"`
+	LinePrefix string   `json:"linePrefix"`
+	LineSuffix string   `json:"lineSuffix,omitempty"`
+	Stop       []string `json:"stop"`
+	MaxTokens  int      `json:"maxTokens"`
+}
+
+// 编译过的规则正则，无效的正则记为nil
+var shapePatterns sync.Map
+
+// 编译规则正则，无效的正则只记录一次日志
+func compileShapePattern(pattern string) *regexp.Regexp {
+	if re, ok := shapePatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		zap.L().Error("Invalid config: 'wrapper.shape.rules' contains invalid pattern",
+			zap.String("pattern", pattern), zap.Error(err))
+		re = nil
+	}
+	shapePatterns.Store(pattern, re)
+	return re
+}
+
+// 规则是否适用于该语言
+func shapeRuleApplies(rule *config.ShapeRule, language string) bool {
+	if len(rule.Languages) == 0 {
+		return true
+	}
+	for _, lang := range rule.Languages {
+		if strings.EqualFold(lang, language) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 识别微补全
+ * @param {string} language - 光标处的语言
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @returns {*CompletionShape} 返回识别结果，不是微补全时返回nil
+ * @description
+ * - 用光标行前缀和光标行后缀依次匹配规则，第一个匹配的规则生效
+ * - 规则来自配置wrapper.shape.rules，未配置时使用语言配置的规则(LanguageProfile.ShapeRules)
+ * @example
+ * shape := detectShape("go", "package main\n\nimport \"str", "\n")
+ * // shape.Shape = "import", shape.Stop = ["\n"]
+ */
+func detectShape(language, prefix, suffix string) *CompletionShape {
+	cfg := &config.Wrapper.Shape
+	rules := cfg.Rules
+	if len(rules) == 0 {
+		rules = profileOf(language).ShapeRules
+	}
+	linePrefix := prefix[strings.LastIndex(prefix, "\n")+1:]
+	lineSuffix := suffix
+	if idx := strings.Index(suffix, "\n"); idx >= 0 {
+		lineSuffix = suffix[:idx]
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if !shapeRuleApplies(rule, language) {
+			continue
+		}
+		re := compileShapePattern(rule.LinePrefix)
+		if re == nil || !re.MatchString(linePrefix) {
+			continue
+		}
+		if rule.LineSuffix != "" {
+			re = compileShapePattern(rule.LineSuffix)
+			if re == nil || !re.MatchString(lineSuffix) {
+				continue
+			}
+		}
+		stop, ok := shapeStopWords[rule.Shape]
+		if !ok {
+			stop = []string{"\n"}
+		}
+		return &CompletionShape{
+			Shape:      rule.Shape,
+			LinePrefix: rule.LinePrefix,
+			LineSuffix: rule.LineSuffix,
+			Stop:       stop,
+			MaxTokens:  cfg.MaxTokens,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package completions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test detecting identifier and import micro-completions
+// go test ./pkg/completions/ -v -run Test_DetectShape
+func Test_DetectShape(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		prefix   string
+		suffix   string
+		shape    string
+	}{
+		{"go import", "go", "package main\n\nimport \"net/ht", "\n", ShapeImport},
+		{"go named import", "go", "package main\n\nimport log \"github.com/sir", "\n", ShapeImport},
+		{"go identifier", "go", "func main() {\n\tuserCou", " := len(users)\n}", ShapeIdentifier},
+		{"go statement", "go", "func main() {\n\tif err != nil ", "\n}", ""},
+		{"go new line", "go", "func main() {\n\tcount", "\n}", ""},
+		{"python from", "python", "import os\nfrom collections.ab", "\n", ShapeImport},
+		{"python import", "python", "import num", "\n", ShapeImport},
+		{"python import names", "python", "from os import ", "\n", ""},
+		{"python identifier", "python", "def main():\n    total_pri", " = sum(prices)\n", ShapeIdentifier},
+		{"python call", "python", "def main():\n    print(", ")\n", ""},
+		{"typescript import", "typescript", "import { useState } from 're", "\n", ShapeImport},
+		{"typescript side effect import", "typescript", "import './sty", "\n", ShapeImport},
+		{"typescript require", "javascript", "const fs = require('f", ")\n", ShapeImport},
+		{"typescript identifier", "typescript", "const userNa", ": string = name;\n", ShapeIdentifier},
+		{"typescript member", "typescript", "const n = user.na", ";\n", ""},
+		{"typescript string", "typescript", "const s = 'hel", "';\n", ""},
+		{"c include", "c", "#include <std", "\n", ShapeImport},
+		{"other language", "ruby", "require 'json", "\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shape := detectShape(tt.language, tt.prefix, tt.suffix)
+			got := ""
+			if shape != nil {
+				got = shape.Shape
+			}
+			if got != tt.shape {
+				t.Errorf("expected shape %q, got %q", tt.shape, got)
+			}
+		})
+	}
+
+	// configured rules replace the defaults
+	saved := config.Wrapper.Shape
+	defer func() { config.Wrapper.Shape = saved }()
+	config.Wrapper.Shape.Rules = []config.ShapeRule{{Shape: ShapeImport, Languages: []string{"ruby"}, LinePrefix: `^require\s+'[^']*$`}}
+	if shape := detectShape("ruby", "require 'json", "\n"); shape == nil || shape.Shape != ShapeImport {
+		t.Errorf("expected the configured rule to match, got %+v", shape)
+	}
+	if shape := detectShape("go", "import \"net/ht", "\n"); shape != nil {
+		t.Errorf("expected the default rules replaced, got %+v", shape)
+	}
+}
+
+// to test how a micro-completion shapes the request to the model
+// go test ./pkg/completions/ -v -run Test_ShapeRequest
+func Test_ShapeRequest(t *testing.T) {
+	_, restore := setupContextServer(`{"data":{"list":[]}}`)
+	defer restore()
+	config.Wrapper.Shape = config.ShapeConfig{MaxTokens: 16}
+	var semantic int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&semantic, 1)
+		w.Write([]byte(`{"data":{"list":[]}}`))
+	}))
+	defer server.Close()
+	config.Context.Semantic.Url = server.URL
+
+	handler := NewCompletionHandler(&scriptedLLM{cfg: config.ModelConfig{ModelName: "scripted", MaxOutput: 200}})
+	shaped := func(prefix, suffix string) (*CompletionInput, []string, int) {
+		in := newContextInput(false)
+		in.LanguageID = "go"
+		in.Prompts.Prefix = prefix
+		in.Prompts.Suffix = suffix
+		if _, rsp := preprocess(in); rsp != nil {
+			t.Fatalf("unexpected rejection: %s", rsp.Error)
+		}
+		para := handler.Adapt(in)
+		return in, para.Stop, para.MaxTokens
+	}
+
+	in, stop, maxTokens := shaped("func main() {\n\tuserCou", " := len(users)\n}\n")
+	if in.Shape == nil || in.Shape.Shape != ShapeIdentifier || maxTokens != 16 {
+		t.Fatalf("expected identifier shaping, got %+v with max tokens %d", in.Shape, maxTokens)
+	}
+	if !strings.Contains(strings.Join(stop, "|"), " |\t|\n") {
+		t.Errorf("expected whitespace stop words, got %q", stop)
+	}
+	if n := atomic.LoadInt32(&semantic); n != 0 {
+		t.Errorf("expected no semantic search, got %d", n)
+	}
+	out := &CompletionResponse{}
+	in.AttachVerbose(out)
+	if out.Verbose == nil || out.Verbose.Input["shape"] != in.Shape {
+		t.Error("expected the shape in verbose")
+	}
+
+	in, stop, maxTokens = shaped("package main\n\nimport \"net/ht", "\n\nfunc main() {}\n")
+	if in.Shape == nil || in.Shape.Shape != ShapeImport || maxTokens != 16 || stop[len(stop)-1] != "\n" {
+		t.Errorf("expected import shaping, got %+v with stop %q", in.Shape, stop)
+	}
+
+	// ordinary completions keep the semantic search and the model limits
+	in, _, maxTokens = shaped("func main() {\n\tfor i := 0; i < n; i++ {\n", "\t}\n}\n")
+	if in.Shape != nil || maxTokens != 200 || atomic.LoadInt32(&semantic) != 1 {
+		t.Errorf("expected no shaping, got %+v with max tokens %d and %d semantic searches", in.Shape, maxTokens, semantic)
+	}
+}
@@ -0,0 +1,545 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 代码风格的取值
+const (
+	IndentTab     = "tab"
+	IndentSpace   = "space"
+	QuoteSingle   = "single"
+	QuoteDouble   = "double"
+	BraceSameLine = "same-line"
+	BraceNextLine = "next-line"
+)
+
+// 代码风格的统计项
+const (
+	styleIndent = "indent"
+	styleWidth  = "width"
+	styleQuote  = "quote"
+	styleBrace  = "brace"
+)
+
+const (
+	styleMaxPrefixLines = 200 // 统计前缀末尾的行数
+	styleMaxSuffixLines = 100 // 统计后缀开头的行数
+	styleMinEvidence    = 3   // 一个统计项至少有这么多处证据，本文件才参与投票
+)
+
+// 空格缩进常见的宽度，其他的缩进增量(如对齐续行)不计入
+var styleIndentWidths = map[int]bool{2: true, 3: true, 4: true, 8: true}
+
+// 这些关键字开头的行以"{"结尾时为同行大括号
+var sameLineBracePattern = regexp.MustCompile(`(\)|\belse|\btry|\bdo|\bfinally)\s*\{$|^(export\s+)?(public\s+|private\s+|abstract\s+|static\s+)*(class|struct|interface|enum|namespace|func|fn|function|impl)\b.*\{$`)
+
+/**
+ * 一个文件中各代码风格的统计
+ * @description
+ * - key为统计项，value为各取值出现的次数
+ * - 空格缩进的宽度以"4"这样的字符串作为取值
+ */
+type styleCounts map[string]map[string]float64
+
+func (sc styleCounts) add(item, value string, n float64) {
+	if sc[item] == nil {
+		sc[item] = make(map[string]float64)
+	}
+	sc[item][value] += n
+}
+
+/**
+ * 统计代码片段的风格
+ * @param {string} language - 语言
+ * @param {string} prefix - 光标前的内容，只统计末尾的styleMaxPrefixLines行
+ * @param {string} suffix - 光标后的内容，只统计开头的styleMaxSuffixLines行
+ * @returns {styleCounts} 返回各统计项的计数
+ * @description
+ * - 缩进：以tab开头的行和以至少2个空格开头的行，块注释的续行(" *")不计入
+ * - 缩进宽度：相邻非空行之间增加的空格数
+ * - 引号：单/双引号等价的语言(LanguageProfile.QuoteStyle)中的字符串，内容包含另一种引号的不计入
+ * - 大括号：缩进无语义的语言中，语句末尾的"{"和单独一行的"{"
+ */
+func countStyle(language, prefix, suffix string) styleCounts {
+	lines := strings.Split(prefix, "\n")
+	if len(lines) > styleMaxPrefixLines {
+		lines = lines[len(lines)-styleMaxPrefixLines:]
+	}
+	after := strings.Split(suffix, "\n")
+	if len(after) > styleMaxSuffixLines {
+		after = after[:styleMaxSuffixLines]
+	}
+	// 光标所在行由前缀的最后一行和后缀的第一行组成
+	lines[len(lines)-1] += after[0]
+	lines = append(lines, after[1:]...)
+
+	counts := make(styleCounts)
+	p := profileOf(language)
+	prevSpaces := -1
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		body := strings.TrimLeft(line, " \t")
+		if body == "" {
+			continue
+		}
+		indent := line[:len(line)-len(body)]
+		switch {
+		case strings.HasPrefix(body, "*"):
+		case strings.HasPrefix(indent, "\t"):
+			counts.add(styleIndent, IndentTab, 1)
+			prevSpaces = -1
+		case len(indent) >= 2 && !strings.Contains(indent, "\t"):
+			counts.add(styleIndent, IndentSpace, 1)
+			if delta := len(indent) - prevSpaces; prevSpaces >= 0 && styleIndentWidths[delta] {
+				counts.add(styleWidth, strconv.Itoa(delta), 1)
+			}
+			prevSpaces = len(indent)
+		case indent == "":
+			prevSpaces = 0
+		}
+		if !p.IndentSignificant && strings.HasSuffix(body, "{") {
+			trimmed := strings.TrimSpace(body)
+			if trimmed == "{" {
+				counts.add(styleBrace, BraceNextLine, 1)
+			} else if sameLineBracePattern.MatchString(trimmed) {
+				counts.add(styleBrace, BraceSameLine, 1)
+			}
+		}
+	}
+	if p.QuoteStyle {
+		text := strings.Join(lines, "\n")
+		scanStringLiterals(text, func(start, end int) {
+			quote, content := text[start], text[start+1:end-1]
+			switch {
+			case quote == '\'' && !strings.Contains(content, "\""):
+				counts.add(styleQuote, QuoteSingle, 1)
+			case quote == '"' && !strings.Contains(content, "'"):
+				counts.add(styleQuote, QuoteDouble, 1)
+			}
+		})
+	}
+	return counts
+}
+
+/**
+ * 扫描js/ts代码中的单引号和双引号字符串
+ * @param {string} text - 代码
+ * @param {func(int, int)} fn - 对每个字符串回调，参数为起止位置(含引号)
+ * @description
+ * - 跳过行注释、块注释和模板字符串(反引号)
+ * - 字符串中的转义字符跳过；到行尾还未闭合的字符串不回调
+ */
+func scanStringLiterals(text string, fn func(start, end int)) {
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; {
+		case strings.HasPrefix(text[i:], "//"):
+			if j := strings.IndexByte(text[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				return
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			j := strings.Index(text[i+2:], "*/")
+			if j < 0 {
+				return
+			}
+			i += j + 3
+		case ch == '`':
+			j := i + 1
+			for ; j < len(text) && text[j] != '`'; j++ {
+				if text[j] == '\\' {
+					j++
+				}
+			}
+			i = j
+		case ch == '\'' || ch == '"':
+			j := i + 1
+			for ; j < len(text) && text[j] != ch && text[j] != '\n'; j++ {
+				if text[j] == '\\' {
+					j++
+				}
+			}
+			if j < len(text) && text[j] == ch {
+				fn(i, j+1)
+			}
+			i = j
+		}
+	}
+}
+
+// 一个客户端在一种语言上的风格档案，计数按观察次数衰减
+type styleTally struct {
+	samples float64
+	votes   styleCounts
+}
+
+// 一个客户端的风格档案
+type clientStyle struct {
+	mutex     sync.Mutex
+	languages map[string]*styleTally
+}
+
+/**
+ * 按客户端学习的代码风格
+ * @description
+ * - 每个请求的前缀和后缀作为一次观察，各统计项按本文件中各取值的占比投票(合计为1)，
+ *   混合风格的文件投出分散的票，不会形成偏好
+ * - 每次观察前历史票数按wrapper.style.decay衰减，近期的请求权重更高
+ * - 档案保存在有界存储中，按LRU和TTL淘汰
+ */
+type StyleTracker struct {
+	cfg     *config.StyleConfig
+	clients *store.Store[string, *clientStyle]
+}
+
+/**
+ * 创建代码风格学习器
+ * @param {*config.StyleConfig} cfg - 配置wrapper.style
+ * @returns {*StyleTracker} 返回没有档案的学习器
+ */
+func NewStyleTracker(cfg *config.StyleConfig) *StyleTracker {
+	return &StyleTracker{
+		cfg: cfg,
+		clients: store.New(store.Options[string, *clientStyle]{
+			Name:       "client_styles",
+			MaxEntries: cfg.MaxClients,
+			TTL:        cfg.TTL,
+			Metadata:   true, // 只有各风格取值的票数
+		}),
+	}
+}
+
+var (
+	stylesOnce sync.Once
+	styles     *StyleTracker
+)
+
+// 全局的代码风格学习器，首次使用时按配置创建
+func Styles() *StyleTracker {
+	stylesOnce.Do(func() {
+		styles = NewStyleTracker(&config.Wrapper.Style)
+	})
+	return styles
+}
+
+/**
+ * 观察一次请求的代码风格，返回采用的风格
+ * @param {string} clientID - 客户端ID，为空时只使用本文件的风格
+ * @param {string} language - 语言
+ * @param {string} prefix - 光标前的内容
+ * @param {string} suffix - 光标后的内容
+ * @returns {*model.StyleProfile} 返回采用的风格，没有任何明确偏好时返回nil
+ * @description
+ * - 是否学习风格由调用方按请求的开关判断，见feature_flag.Style
+ * - 各统计项优先使用本文件明确的风格，否则使用客户端档案
+ * - 空格缩进的宽度同样优先使用本文件的统计
+ */
+func (t *StyleTracker) Observe(clientID, language, prefix, suffix string) *model.StyleProfile {
+	language = profileOf(language).ID
+	counts := countStyle(language, prefix, suffix)
+	var tally *styleTally
+	if clientID != "" {
+		tally = t.record(clientID, language, counts)
+	}
+	return t.resolve(language, counts, tally)
+}
+
+// 将本文件的统计投票到客户端档案，返回档案的快照
+func (t *StyleTracker) record(clientID, language string, counts styleCounts) *styleTally {
+	cs, ok := t.clients.Get(clientID)
+	if !ok {
+		cs = &clientStyle{languages: make(map[string]*styleTally)}
+	}
+	cs.mutex.Lock()
+	tally := cs.languages[language]
+	if tally == nil {
+		tally = &styleTally{votes: make(styleCounts)}
+		cs.languages[language] = tally
+	}
+	tally.samples *= t.cfg.Decay
+	for _, values := range tally.votes {
+		for value := range values {
+			values[value] *= t.cfg.Decay
+		}
+	}
+	for item, values := range counts {
+		total := sumCounts(values)
+		if total < styleMinEvidence {
+			continue
+		}
+		for value, n := range values {
+			tally.votes.add(item, value, n/total)
+		}
+	}
+	tally.samples++
+	snapshot := tally.clone()
+	cs.mutex.Unlock()
+	t.clients.Put(clientID, cs)
+	return snapshot
+}
+
+func (tally *styleTally) clone() *styleTally {
+	c := &styleTally{samples: tally.samples, votes: make(styleCounts, len(tally.votes))}
+	for item, values := range tally.votes {
+		for value, n := range values {
+			c.votes.add(item, value, n)
+		}
+	}
+	return c
+}
+
+func sumCounts(values map[string]float64) float64 {
+	total := 0.0
+	for _, n := range values {
+		total += n
+	}
+	return total
+}
+
+// 占比最高的取值和占比，取值按字符串排序保证结果确定
+func dominant(values map[string]float64) (string, float64, float64) {
+	keys := make([]string, 0, len(values))
+	for value := range values {
+		keys = append(keys, value)
+	}
+	sort.Strings(keys)
+	best, total := "", 0.0
+	for _, value := range keys {
+		total += values[value]
+		if best == "" || values[value] > values[best] {
+			best = value
+		}
+	}
+	if total == 0 {
+		return "", 0, 0
+	}
+	return best, values[best], values[best] / total
+}
+
+// 按本文件和客户端档案决定一个统计项的风格
+func (t *StyleTracker) prefer(item string, counts styleCounts, tally *styleTally) *model.StylePreference {
+	if value, n, share := dominant(counts[item]); n >= styleMinEvidence && share >= t.cfg.Confidence {
+		return &model.StylePreference{Value: value, Confidence: share, Source: model.StyleSourceFile}
+	}
+	if tally == nil {
+		return nil
+	}
+	if value, n, share := dominant(tally.votes[item]); n >= t.cfg.MinSamples && share >= t.cfg.Confidence {
+		return &model.StylePreference{Value: value, Confidence: share, Source: model.StyleSourceClient}
+	}
+	return nil
+}
+
+func (t *StyleTracker) resolve(language string, counts styleCounts, tally *styleTally) *model.StyleProfile {
+	sp := &model.StyleProfile{Language: language}
+	if tally != nil {
+		sp.Samples = tally.samples
+	}
+	sp.Indent = t.prefer(styleIndent, counts, tally)
+	sp.Quote = t.prefer(styleQuote, counts, tally)
+	sp.Brace = t.prefer(styleBrace, counts, tally)
+	if sp.Indent != nil && sp.Indent.Value == IndentSpace {
+		if width := t.prefer(styleWidth, counts, tally); width != nil {
+			sp.Indent.Width, _ = strconv.Atoi(width.Value)
+		}
+	}
+	if sp.Indent == nil && sp.Quote == nil && sp.Brace == nil {
+		return nil
+	}
+	return sp
+}
+
+/**
+ * 查询客户端的风格档案
+ * @param {string} clientID - 客户端ID
+ * @returns {map[string]*model.StyleProfile} 返回各语言按档案采用的风格(不含本文件)，没有档案时返回nil
+ */
+func (t *StyleTracker) Profile(clientID string) map[string]*model.StyleProfile {
+	cs, ok := t.clients.Get(clientID)
+	if !ok {
+		return nil
+	}
+	cs.mutex.Lock()
+	tallies := make(map[string]*styleTally, len(cs.languages))
+	for language, tally := range cs.languages {
+		tallies[language] = tally.clone()
+	}
+	cs.mutex.Unlock()
+	profiles := make(map[string]*model.StyleProfile, len(tallies))
+	for language, tally := range tallies {
+		sp := t.resolve(language, nil, tally)
+		if sp == nil {
+			sp = &model.StyleProfile{Language: language, Samples: tally.samples}
+		}
+		profiles[language] = sp
+	}
+	return profiles
+}
+
+// 清除客户端的风格档案，返回档案是否存在
+func (t *StyleTracker) Reset(clientID string) bool {
+	return t.clients.Delete(clientID)
+}
+
+/**
+ * 观察请求的代码风格
+ * @description
+ * - 请求的开关feature_flag.Style关闭时不学习
+ * - 生成/压缩文件不代表客户端的风格，不参与学习
+ * - 重放的请求只使用本文件的风格，不写入客户端档案
+ * - 采用的风格记录到in.Style，适配模型参数时传给后置处理器
+ */
+func (in *CompletionInput) observeStyle(c *CompletionContext) {
+	if !c.Enabled(feature_flag.Style) || in.generated() {
+		return
+	}
+	clientID := in.ClientID
+	if in.internal() {
+		clientID = ""
+	}
+	in.Style = Styles().Observe(clientID, in.EffectiveLanguage(), in.Processed.Prefix, in.Processed.Suffix)
+}
+
+/**
+ * 缩进风格规范化裁剪处理器
+ * @description
+ * - 推断出缩进风格(PrunerContext.Style.Indent)时，将补全第二行起的行首缩进转换为该风格
+ * - 转换为空格时每个tab替换为推断的宽度(未推断出时为4)个空格
+ * - 转换为tab时按补全自身的空格缩进宽度换算，余下的空格保留
+ * - 首行接在光标后，由首行缩进裁剪处理器处理
+ * @example
+ * ctx := &PrunerContext{
+ *     Style:          &model.StyleProfile{Indent: &model.StylePreference{Value: IndentTab}},
+ *     CompletionCode: "if ok {\n    return\n}",
+ * }
+ * modified := (&IndentStyleCutter{}).Process(ctx)
+ * // ctx.CompletionCode = "if ok {\n\treturn\n}"，modified = true
+ */
+type IndentStyleCutter struct{ Cutter }
+
+func (p *IndentStyleCutter) Process(ctx *PrunerContext) bool {
+	if ctx.Style == nil || ctx.Style.Indent == nil {
+		return false
+	}
+	lines := strings.Split(ctx.CompletionCode, "\n")
+	if len(lines) < 2 {
+		return false
+	}
+	pref := ctx.Style.Indent
+	unit := 0
+	if pref.Value == IndentTab {
+		unit = completionIndentWidth(lines)
+	}
+	modified := false
+	for i := 1; i < len(lines); i++ {
+		line := lines[i]
+		body := strings.TrimLeft(line, " \t")
+		if body == "" {
+			continue
+		}
+		indent := line[:len(line)-len(body)]
+		converted := indent
+		switch {
+		case pref.Value == IndentSpace && strings.Contains(indent, "\t"):
+			width := pref.Width
+			if width == 0 {
+				width = 4
+			}
+			converted = strings.ReplaceAll(indent, "\t", strings.Repeat(" ", width))
+		case pref.Value == IndentTab && unit > 0 && strings.TrimLeft(indent, " ") == "":
+			converted = strings.Repeat("\t", len(indent)/unit) + strings.Repeat(" ", len(indent)%unit)
+		}
+		if converted != indent {
+			lines[i] = converted + body
+			modified = true
+		}
+	}
+	if modified {
+		ctx.CompletionCode = strings.Join(lines, "\n")
+	}
+	return modified
+}
+
+func (p *IndentStyleCutter) Name() string {
+	return CutIndentStyle
+}
+
+// 补全中空格缩进的宽度，取相邻行缩进增量中最常见的，没有时返回0
+func completionIndentWidth(lines []string) int {
+	counts := make(styleCounts)
+	prev := 0
+	for _, line := range lines {
+		body := strings.TrimLeft(line, " ")
+		if strings.TrimSpace(body) == "" || strings.HasPrefix(body, "\t") {
+			continue
+		}
+		spaces := len(line) - len(body)
+		if delta := spaces - prev; styleIndentWidths[delta] {
+			counts.add(styleWidth, strconv.Itoa(delta), 1)
+		}
+		prev = spaces
+	}
+	value, _, _ := dominant(counts[styleWidth])
+	width, _ := strconv.Atoi(value)
+	return width
+}
+
+/**
+ * 引号风格规范化裁剪处理器
+ * @description
+ * - 单/双引号等价的语言(LanguageProfile.QuoteStyle)推断出引号风格时，将补全中另一种引号的字符串转换为该风格
+ * - 只转换不含转义字符、不含目标引号的字符串；注释和模板字符串中的内容不转换
+ * - 光标行前缀中未闭合的字符串不转换
+ * @example
+ * ctx := &PrunerContext{
+ *     Language:       "typescript",
+ *     Style:          &model.StyleProfile{Quote: &model.StylePreference{Value: QuoteSingle}},
+ *     Prefix:         "const a = ",
+ *     CompletionCode: `"x" + "it's"`,
+ * }
+ * modified := (&QuoteStyleCutter{}).Process(ctx)
+ * // ctx.CompletionCode = `'x' + "it's"`，modified = true
+ */
+type QuoteStyleCutter struct{ Cutter }
+
+func (p *QuoteStyleCutter) Process(ctx *PrunerContext) bool {
+	if ctx.Style == nil || ctx.Style.Quote == nil || !profileOf(ctx.Language).QuoteStyle {
+		return false
+	}
+	from, to := byte('\''), byte('"')
+	if ctx.Style.Quote.Value == QuoteSingle {
+		from, to = to, from
+	}
+	// 连同光标行的前缀一起扫描，前缀中未闭合的字符串延续到补全中
+	linePrefix := ctx.Prefix[strings.LastIndex(ctx.Prefix, "\n")+1:]
+	text := []byte(linePrefix + ctx.CompletionCode)
+	modified := false
+	scanStringLiterals(string(text), func(start, end int) {
+		if start < len(linePrefix) || text[start] != from {
+			return
+		}
+		content := string(text[start+1 : end-1])
+		if strings.ContainsAny(content, "\\"+string(to)) {
+			return
+		}
+		text[start], text[end-1] = to, to
+		modified = true
+	})
+	if modified {
+		ctx.CompletionCode = string(text[len(linePrefix):])
+	}
+	return modified
+}
+
+func (p *QuoteStyleCutter) Name() string {
+	return CutQuoteStyle
+}
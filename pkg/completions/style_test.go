@@ -0,0 +1,136 @@
+package completions
+
+import (
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+func newStyleConfig() *config.StyleConfig {
+	return &config.StyleConfig{MaxClients: 10, Decay: 0.9, MinSamples: 3, Confidence: 0.7}
+}
+
+// 生成一段指定缩进和引号风格的ts代码
+func styledCode(indent, quote string, blocks int) string {
+	var sb strings.Builder
+	for i := 0; i < blocks; i++ {
+		sb.WriteString("function f() {\n")
+		sb.WriteString(indent + "const a = " + quote + "x" + quote + ";\n")
+		sb.WriteString(indent + "if (a) {\n")
+		sb.WriteString(indent + indent + "return " + quote + "y" + quote + ";\n")
+		sb.WriteString(indent + "}\n}\n")
+	}
+	return sb.String()
+}
+
+// to test the profile of a client converging over a sequence of prefixes
+// go test ./pkg/completions/ -v -run Test_StyleConvergence
+func Test_StyleConvergence(t *testing.T) {
+	tracker := NewStyleTracker(newStyleConfig())
+	file := styledCode("  ", "'", 3)
+	var sp *model.StyleProfile
+	// 历史票数按0.9衰减，4次观察后累计权重才超过minSamples
+	for i := 0; i < 5; i++ {
+		sp = tracker.Observe("c1", "ts", file, "")
+	}
+	if sp == nil || sp.Indent == nil || sp.Indent.Value != IndentSpace || sp.Indent.Width != 2 || sp.Indent.Source != model.StyleSourceFile {
+		t.Fatalf("expected 2-space indent from the file, got %+v", sp)
+	}
+	if sp.Quote == nil || sp.Quote.Value != QuoteSingle || sp.Brace == nil || sp.Brace.Value != BraceSameLine {
+		t.Fatalf("expected single quotes and same-line braces, got %+v", sp)
+	}
+	// 新文件没有足够的内容时使用客户端档案
+	sp = tracker.Observe("c1", "typescript", "const b = ", "")
+	if sp == nil || sp.Indent == nil || sp.Indent.Source != model.StyleSourceClient || sp.Indent.Width != 2 || sp.Quote.Value != QuoteSingle {
+		t.Fatalf("expected the client profile applied to a new file, got %+v", sp)
+	}
+	if sp := tracker.Observe("c2", "typescript", "const b = ", ""); sp != nil {
+		t.Errorf("expected no style for a client without history, got %+v", sp)
+	}
+
+	// 切换风格后，近期的请求逐渐占多数
+	tabs := styledCode("\t", "\"", 3)
+	switched := 0
+	for i := 1; i <= 20 && switched == 0; i++ {
+		tracker.Observe("c1", "typescript", tabs, "")
+		if sp := tracker.Profile("c1")["typescript"]; sp.Indent != nil && sp.Indent.Value == IndentTab {
+			switched = i
+		}
+	}
+	if switched == 0 || switched > 15 {
+		t.Errorf("expected the profile to follow the recent style, switched after %d requests", switched)
+	}
+	if !tracker.Reset("c1") || tracker.Profile("c1") != nil {
+		t.Error("expected the profile reset")
+	}
+}
+
+// to test that mixed files do not form a preference
+// go test ./pkg/completions/ -v -run Test_StyleMixedFile
+func Test_StyleMixedFile(t *testing.T) {
+	tracker := NewStyleTracker(newStyleConfig())
+	mixed := styledCode("    ", "'", 2) + styledCode("\t", "\"", 2)
+	var sp *model.StyleProfile
+	for i := 0; i < 10; i++ {
+		sp = tracker.Observe("c1", "javascript", mixed, "")
+	}
+	if sp != nil && (sp.Indent != nil || sp.Quote != nil) {
+		t.Errorf("expected no indent or quote preference for a mixed file, got %+v", sp)
+	}
+	// 偶尔出现的其他风格不改变偏好
+	tracker.Observe("c2", "go", "func a() {\n\treturn\n}\nfunc b() {\n\treturn\n}\nfunc c() {\n\tx := `'`\n}\n", "")
+	if sp := tracker.Observe("c2", "go", "func a() {\n  b()\n\treturn\n\tc()\n\td()\n}", ""); sp == nil || sp.Indent.Value != IndentTab {
+		t.Errorf("expected the dominant tab indent, got %+v", sp)
+	}
+}
+
+// to test the indentation and quote normalization cutters
+// go test ./pkg/completions/ -v -run Test_StyleCutters
+func Test_StyleCutters(t *testing.T) {
+	tab := &model.StyleProfile{Indent: &model.StylePreference{Value: IndentTab}}
+	space := &model.StyleProfile{Indent: &model.StylePreference{Value: IndentSpace, Width: 2}}
+	single := &model.StyleProfile{Quote: &model.StylePreference{Value: QuoteSingle}}
+	cases := []struct {
+		cutter   Pruner
+		ctx      PrunerContext
+		expected string
+	}{
+		{&IndentStyleCutter{}, PrunerContext{Style: tab, CompletionCode: "if ok {\n    if a {\n        b()\n    }\n}"},
+			"if ok {\n\tif a {\n\t\tb()\n\t}\n}"},
+		{&IndentStyleCutter{}, PrunerContext{Style: space, CompletionCode: "x {\n\ty\n\t\tz"}, "x {\n  y\n    z"},
+		{&IndentStyleCutter{}, PrunerContext{CompletionCode: "x {\n\ty"}, "x {\n\ty"},
+		{&QuoteStyleCutter{}, PrunerContext{Language: "ts", Style: single, Prefix: "const a = ",
+			CompletionCode: `"x" + "it's" + "a\"b" + ` + "`\"t\"`" + ` // "c"`},
+			`'x' + "it's" + "a\"b" + ` + "`\"t\"`" + ` // "c"`},
+		// 光标在字符串中
+		{&QuoteStyleCutter{}, PrunerContext{Language: "javascript", Style: single, Prefix: `f("ab`,
+			CompletionCode: `c", "d")`}, `c", 'd')`},
+		{&QuoteStyleCutter{}, PrunerContext{Language: "go", Style: single, CompletionCode: `"x"`}, `"x"`},
+	}
+	for i, tc := range cases {
+		ctx := tc.ctx
+		modified := tc.cutter.Process(&ctx)
+		if ctx.CompletionCode != tc.expected || modified != (tc.expected != tc.ctx.CompletionCode) {
+			t.Errorf("case %d: expected %q, got %q", i, tc.expected, ctx.CompletionCode)
+		}
+	}
+
+	// 学习到的风格经过后置处理器链应用到补全
+	tracker := NewStyleTracker(newStyleConfig())
+	for i := 0; i < 5; i++ {
+		tracker.Observe("c1", "typescript", styledCode("\t", "'", 3), "")
+	}
+	ctx := &PrunerContext{
+		Language:       "typescript",
+		Style:          tracker.Observe("c1", "typescript", "let s = ", ""),
+		Prefix:         "let s = ",
+		CompletionCode: "\"a\";\nif (s) {\n    s = \"b\";\n}",
+	}
+	chain := NewDefaultPrunerChain()
+	chain.Process(ctx)
+	if ctx.CompletionCode != "'a';\nif (s) {\n\ts = 'b';\n}" {
+		t.Errorf("expected the learned style applied, got %q", ctx.CompletionCode)
+	}
+}
@@ -0,0 +1,106 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"sort"
+	"strings"
+)
+
+// 请求的Extra中覆盖后缀策略的字段，用于对比实验
+const (
+	ExtraSuffixPolicy = "suffix_policy" // 后缀策略(full/first_k_lines/none)
+	ExtraSuffixLines  = "suffix_lines"  // first_k_lines策略发送的后缀行数
+)
+
+/**
+ * 确定请求生效的后缀策略
+ * @param {*config.ModelConfig} cfg - 模型配置，按语言配置的策略见config.ModelConfig.Languages
+ * @param {string} language - 请求的语言
+ * @param {map[string]interface{}} extra - 请求的Extra，可以用suffix_policy/suffix_lines覆盖配置的策略
+ * @returns {config.ModelLanguageConfig} 返回生效的后缀策略，没有配置时为full
+ * @description
+ * - 语言的配置优先，其次是"*"的配置；配置的key可以是语言的别名
+ * - 与请求的语言完全相同的key优先，多个别名都匹配时按key的字典序取第一个，结果不随map的遍历顺序变化
+ * - Extra中的策略不合法时忽略，使用配置的策略
+ * @example
+ * cfg := &config.ModelConfig{Languages: map[string]config.ModelLanguageConfig{"py": {SuffixPolicy: "none"}}}
+ * policy := resolveSuffixPolicy(cfg, "python", map[string]interface{}{"suffix_policy": "first_k_lines", "suffix_lines": 3.0})
+ * // policy = {SuffixPolicy: "first_k_lines", SuffixLines: 3}
+ */
+func resolveSuffixPolicy(cfg *config.ModelConfig, language string, extra map[string]interface{}) config.ModelLanguageConfig {
+	policy, ok := cfg.Languages[language]
+	if language == "*" {
+		ok = false
+	}
+	if !ok {
+		id := profileOf(language).ID
+		keys := make([]string, 0, len(cfg.Languages))
+		for key := range cfg.Languages {
+			if key != "*" && profileOf(key).ID == id {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			policy, ok = cfg.Languages[keys[0]], true
+		}
+	}
+	if !ok {
+		policy = cfg.Languages["*"]
+	}
+	if name, ok := extra[ExtraSuffixPolicy].(string); ok {
+		override := config.ModelLanguageConfig{SuffixPolicy: name}
+		if lines, ok := extra[ExtraSuffixLines].(float64); ok {
+			override.SuffixLines = int(lines)
+		}
+		if override.Validate() == nil {
+			policy = override
+		}
+	}
+	if policy.SuffixPolicy == "" {
+		policy.SuffixPolicy = config.SuffixFull
+	}
+	return policy
+}
+
+/**
+ * 按后缀策略裁剪后缀
+ * @param {config.ModelLanguageConfig} policy - 后缀策略
+ * @param {string} suffix - 后缀
+ * @param {int} keep - 至少保留的字节数(光标所在调用的参数列表)，见PromptOptions.SuffixKeep
+ * @returns {string} 返回发送给模型的后缀
+ * @description
+ * - first_k_lines保留前K行(含光标所在行的剩余内容)，参数列表超出K行时保留到参数列表所在的行
+ * - none不发送后缀，参数列表也不保留
+ * @example
+ * applySuffixPolicy(config.ModelLanguageConfig{SuffixPolicy: "first_k_lines", SuffixLines: 1}, ")\nreturn x\n", 0)
+ * // ")\n"
+ */
+func applySuffixPolicy(policy config.ModelLanguageConfig, suffix string, keep int) string {
+	switch policy.SuffixPolicy {
+	case config.SuffixNone:
+		return ""
+	case config.SuffixFirstLines:
+		end := 0
+		for i := 0; i < policy.SuffixLines || end < keep; i++ {
+			next := strings.IndexByte(suffix[end:], '\n')
+			if next < 0 {
+				return suffix
+			}
+			end += next + 1
+		}
+		return suffix[:end]
+	}
+	return suffix
+}
+
+// 没有经过Adapt的请求(V2、OpenAI接口)还没有后缀策略，按模型的语言配置裁剪后缀，V1的请求在截断时已处理
+func (h *CompletionHandler) applyPreparedSuffixPolicy(para *model.CompletionParameter) {
+	if para.SuffixPolicy != "" {
+		return
+	}
+	policy := resolveSuffixPolicy(h.cfg, para.Language, nil)
+	para.Suffix = applySuffixPolicy(policy, para.Suffix, 0)
+	para.SuffixPolicy, para.SuffixLines = policy.SuffixPolicy, policy.SuffixLines
+}
@@ -0,0 +1,80 @@
+package completions
+
+import (
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test assembling the prompt by the suffix policy and reallocating the freed suffix budget
+// go test ./pkg/completions/ -v -run Test_SuffixPolicy
+func Test_SuffixPolicy(t *testing.T) {
+	const (
+		prefix  = "abcdefghij\nklmnopqrst\n"
+		context = "0123456789"
+		suffix  = "s1\ns2\ns3\n"
+	)
+	cfg := config.ModelConfig{MaxPrefix: 20, MaxSuffix: 15}
+	h := NewCompletionHandler(newByteTokenizerLLM(t, cfg))
+	cases := []struct {
+		policy      config.ModelLanguageConfig
+		prefix      string
+		context     string
+		suffix      string
+		reallocated int
+	}{
+		// 前缀和上下文超出预算，上下文整个丢弃
+		{config.ModelLanguageConfig{SuffixPolicy: config.SuffixFull}, "klmnopqrst\n", "", suffix, 0},
+		// 只发送第一行，后缀剩余的12个token让给上下文
+		{config.ModelLanguageConfig{SuffixPolicy: config.SuffixFirstLines, SuffixLines: 1}, prefix, "123456789", "s1\n", 12},
+		{config.ModelLanguageConfig{SuffixPolicy: config.SuffixNone}, prefix, context, "", 15},
+	}
+	for _, c := range cases {
+		ppt := PromptOptions{Prefix: prefix, Suffix: suffix, CodeContext: context, SuffixPolicy: c.policy}
+		budget := newBudgetReport(&ppt)
+		tokens, _ := h.truncatePrompt(&cfg, &ppt, "", budget, "")
+		if ppt.Prefix != c.prefix || ppt.CodeContext != c.context || ppt.Suffix != c.suffix {
+			t.Errorf("%s: unexpected prompt %q %q %q", c.policy.SuffixPolicy, ppt.CodeContext, ppt.Prefix, ppt.Suffix)
+		}
+		if budget.SuffixPolicy != c.policy.SuffixPolicy || budget.ReallocatedTokens != c.reallocated || budget.PromptTokens != tokens {
+			t.Errorf("%s: unexpected budget %+v, %d tokens", c.policy.SuffixPolicy, budget, tokens)
+		}
+	}
+
+	// 光标所在调用的参数列表超出K行时保留到参数列表所在的行
+	firstLine := config.ModelLanguageConfig{SuffixPolicy: config.SuffixFirstLines, SuffixLines: 1}
+	if got := applySuffixPolicy(firstLine, "a,\n  b)\nreturn\n", len("a,\n  b)")); got != "a,\n  b)\n" {
+		t.Errorf("expected the argument list kept, got %q", got)
+	}
+
+	// 按语言(含别名)和"*"配置，请求的Extra覆盖配置
+	cfg.Languages = map[string]config.ModelLanguageConfig{"py": firstLine, "*": {SuffixPolicy: config.SuffixNone}}
+	if p := resolveSuffixPolicy(&cfg, "python", nil); p != firstLine {
+		t.Errorf("expected the python policy, got %+v", p)
+	}
+	if p := resolveSuffixPolicy(&cfg, "go", nil); p.SuffixPolicy != config.SuffixNone {
+		t.Errorf("expected the wildcard policy, got %+v", p)
+	}
+	extra := map[string]interface{}{ExtraSuffixPolicy: config.SuffixFirstLines, ExtraSuffixLines: 3.0}
+	if p := resolveSuffixPolicy(&cfg, "go", extra); p.SuffixPolicy != config.SuffixFirstLines || p.SuffixLines != 3 {
+		t.Errorf("expected the request override, got %+v", p)
+	}
+	if p := resolveSuffixPolicy(&cfg, "python", map[string]interface{}{ExtraSuffixPolicy: config.SuffixFirstLines}); p != firstLine {
+		t.Errorf("expected an invalid override ignored, got %+v", p)
+	}
+	if p := resolveSuffixPolicy(&config.ModelConfig{}, "go", nil); p.SuffixPolicy != config.SuffixFull {
+		t.Errorf("expected full by default, got %+v", p)
+	}
+
+	// 多个别名都匹配时，完全相同的key优先，其余按字典序，结果固定
+	cfg.Languages = map[string]config.ModelLanguageConfig{"py": firstLine, "python": {SuffixPolicy: config.SuffixNone},
+		"Python": {SuffixPolicy: config.SuffixFull}}
+	for i := 0; i < 20; i++ {
+		if p := resolveSuffixPolicy(&cfg, "python", nil); p.SuffixPolicy != config.SuffixNone {
+			t.Fatalf("expected the exact key to win, got %+v", p)
+		}
+		if p := resolveSuffixPolicy(&cfg, "PY", nil); p.SuffixPolicy != config.SuffixFull {
+			t.Fatalf("expected the first alias in order to win, got %+v", p)
+		}
+	}
+}
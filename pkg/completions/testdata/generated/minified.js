@@ -0,0 +1 @@
+!function(){"use strict";function a0(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b0=a0([{id:0}],null),c0=b0[0]||void 0;function a1(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b1=a1([{id:1}],null),c1=b1[1]||void 0;function a2(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b2=a2([{id:2}],null),c2=b2[2]||void 0;function a3(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b3=a3([{id:3}],null),c3=b3[3]||void 0;function a4(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b4=a4([{id:4}],null),c4=b4[4]||void 0;function a5(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b5=a5([{id:5}],null),c5=b5[5]||void 0;function a6(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b6=a6([{id:6}],null),c6=b6[6]||void 0;function a7(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b7=a7([{id:7}],null),c7=b7[7]||void 0;function a8(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b8=a8([{id:8}],null),c8=b8[8]||void 0;function a9(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b9=a9([{id:9}],null),c9=b9[9]||void 0;function a10(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b10=a10([{id:10}],null),c10=b10[10]||void 0;function a11(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b11=a11([{id:11}],null),c11=b11[11]||void 0;function a12(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b12=a12([{id:12}],null),c12=b12[12]||void 0;function a13(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b13=a13([{id:13}],null),c13=b13[13]||void 0;function a14(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b14=a14([{id:14}],null),c14=b14[14]||void 0;function a15(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b15=a15([{id:15}],null),c15=b15[15]||void 0;function a16(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b16=a16([{id:16}],null),c16=b16[16]||void 0;function a17(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b17=a17([{id:17}],null),c17=b17[17]||void 0;function a18(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b18=a18([{id:18}],null),c18=b18[18]||void 0;function a19(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b19=a19([{id:19}],null),c19=b19[19]||void 0;function a20(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b20=a20([{id:20}],null),c20=b20[20]||void 0;function a21(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b21=a21([{id:21}],null),c21=b21[21]||void 0;function a22(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b22=a22([{id:22}],null),c22=b22[22]||void 0;function a23(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b23=a23([{id:23}],null),c23=b23[23]||void 0;function a24(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b24=a24([{id:24}],null),c24=b24[24]||void 0;function a25(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b25=a25([{id:25}],null),c25=b25[25]||void 0;function a26(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b26=a26([{id:26}],null),c26=b26[26]||void 0;function a27(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b27=a27([{id:27}],null),c27=b27[27]||void 0;function a28(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b28=a28([{id:28}],null),c28=b28[28]||void 0;function a29(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b29=a29([{id:29}],null),c29=b29[29]||void 0;function a30(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b30=a30([{id:30}],null),c30=b30[30]||void 0;function a31(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b31=a31([{id:31}],null),c31=b31[31]||void 0;function a32(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b32=a32([{id:32}],null),c32=b32[32]||void 0;function a33(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b33=a33([{id:33}],null),c33=b33[33]||void 0;function a34(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b34=a34([{id:34}],null),c34=b34[34]||void 0;function a35(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b35=a35([{id:35}],null),c35=b35[35]||void 0;function a36(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b36=a36([{id:36}],null),c36=b36[36]||void 0;function a37(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b37=a37([{id:37}],null),c37=b37[37]||void 0;function a38(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b38=a38([{id:38}],null),c38=b38[38]||void 0;function a39(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b39=a39([{id:39}],null),c39=b39[39]||void 0;function a40(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b40=a40([{id:40}],null),c40=b40[40]||void 0;function a41(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b41=a41([{id:41}],null),c41=b41[41]||void 0;function a42(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b42=a42([{id:42}],null),c42=b42[42]||void 0;function a43(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b43=a43([{id:43}],null),c43=b43[43]||void 0;function a44(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b44=a44([{id:44}],null),c44=b44[44]||void 0;function a45(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b45=a45([{id:45}],null),c45=b45[45]||void 0;function a46(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b46=a46([{id:46}],null),c46=b46[46]||void 0;function a47(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b47=a47([{id:47}],null),c47=b47[47]||void 0;function a48(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b48=a48([{id:48}],null),c48=b48[48]||void 0;function a49(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b49=a49([{id:49}],null),c49=b49[49]||void 0;function a50(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b50=a50([{id:50}],null),c50=b50[50]||void 0;function a51(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b51=a51([{id:51}],null),c51=b51[51]||void 0;function a52(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b52=a52([{id:52}],null),c52=b52[52]||void 0;function a53(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b53=a53([{id:53}],null),c53=b53[53]||void 0;function a54(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b54=a54([{id:54}],null),c54=b54[54]||void 0;function a55(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b55=a55([{id:55}],null),c55=b55[55]||void 0;function a56(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b56=a56([{id:56}],null),c56=b56[56]||void 0;function a57(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b57=a57([{id:57}],null),c57=b57[57]||void 0;function a58(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b58=a58([{id:58}],null),c58=b58[58]||void 0;function a59(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b59=a59([{id:59}],null),c59=b59[59]||void 0;function a60(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b60=a60([{id:60}],null),c60=b60[60]||void 0;function a61(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b61=a61([{id:61}],null),c61=b61[61]||void 0;function a62(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b62=a62([{id:62}],null),c62=b62[62]||void 0;function a63(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b63=a63([{id:63}],null),c63=b63[63]||void 0;function a64(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b64=a64([{id:64}],null),c64=b64[64]||void 0;function a65(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b65=a65([{id:65}],null),c65=b65[65]||void 0;function a66(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b66=a66([{id:66}],null),c66=b66[66]||void 0;function a67(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b67=a67([{id:67}],null),c67=b67[67]||void 0;function a68(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b68=a68([{id:68}],null),c68=b68[68]||void 0;function a69(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b69=a69([{id:69}],null),c69=b69[69]||void 0;function a70(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b70=a70([{id:70}],null),c70=b70[70]||void 0;function a71(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b71=a71([{id:71}],null),c71=b71[71]||void 0;function a72(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b72=a72([{id:72}],null),c72=b72[72]||void 0;function a73(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b73=a73([{id:73}],null),c73=b73[73]||void 0;function a74(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b74=a74([{id:74}],null),c74=b74[74]||void 0;function a75(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b75=a75([{id:75}],null),c75=b75[75]||void 0;function a76(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b76=a76([{id:76}],null),c76=b76[76]||void 0;function a77(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b77=a77([{id:77}],null),c77=b77[77]||void 0;function a78(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b78=a78([{id:78}],null),c78=b78[78]||void 0;function a79(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b79=a79([{id:79}],null),c79=b79[79]||void 0;function a80(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b80=a80([{id:80}],null),c80=b80[80]||void 0;function a81(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b81=a81([{id:81}],null),c81=b81[81]||void 0;function a82(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b82=a82([{id:82}],null),c82=b82[82]||void 0;function a83(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b83=a83([{id:83}],null),c83=b83[83]||void 0;function a84(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b84=a84([{id:84}],null),c84=b84[84]||void 0;function a85(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b85=a85([{id:85}],null),c85=b85[85]||void 0;function a86(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b86=a86([{id:86}],null),c86=b86[86]||void 0;function a87(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b87=a87([{id:87}],null),c87=b87[87]||void 0;function a88(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b88=a88([{id:88}],null),c88=b88[88]||void 0;function a89(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b89=a89([{id:89}],null),c89=b89[89]||void 0;function a90(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b90=a90([{id:90}],null),c90=b90[90]||void 0;function a91(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b91=a91([{id:91}],null),c91=b91[91]||void 0;function a92(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b92=a92([{id:92}],null),c92=b92[92]||void 0;function a93(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b93=a93([{id:93}],null),c93=b93[93]||void 0;function a94(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b94=a94([{id:94}],null),c94=b94[94]||void 0;function a95(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b95=a95([{id:95}],null),c95=b95[95]||void 0;function a96(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b96=a96([{id:96}],null),c96=b96[96]||void 0;function a97(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b97=a97([{id:97}],null),c97=b97[97]||void 0;function a98(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b98=a98([{id:98}],null),c98=b98[98]||void 0;function a99(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b99=a99([{id:99}],null),c99=b99[99]||void 0;function a100(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b100=a100([{id:100}],null),c100=b100[100]||void 0;function a101(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b101=a101([{id:101}],null),c101=b101[101]||void 0;function a102(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b102=a102([{id:102}],null),c102=b102[102]||void 0;function a103(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b103=a103([{id:103}],null),c103=b103[103]||void 0;function a104(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b104=a104([{id:104}],null),c104=b104[104]||void 0;function a105(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b105=a105([{id:105}],null),c105=b105[105]||void 0;function a106(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b106=a106([{id:106}],null),c106=b106[106]||void 0;function a107(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b107=a107([{id:107}],null),c107=b107[107]||void 0;function a108(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b108=a108([{id:108}],null),c108=b108[108]||void 0;function a109(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b109=a109([{id:109}],null),c109=b109[109]||void 0;function a110(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b110=a110([{id:110}],null),c110=b110[110]||void 0;function a111(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b111=a111([{id:111}],null),c111=b111[111]||void 0;function a112(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b112=a112([{id:112}],null),c112=b112[112]||void 0;function a113(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b113=a113([{id:113}],null),c113=b113[113]||void 0;function a114(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b114=a114([{id:114}],null),c114=b114[114]||void 0;function a115(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b115=a115([{id:115}],null),c115=b115[115]||void 0;function a116(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b116=a116([{id:116}],null),c116=b116[116]||void 0;function a117(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b117=a117([{id:117}],null),c117=b117[117]||void 0;function a118(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b118=a118([{id:118}],null),c118=b118[118]||void 0;function a119(e,t){var r=e.length,o=t||{};for(var i=0;i<r;i++)o[e[i].id]=e[i];return o}var b119=a119([{id:119}],null),c119=b119[119]||void 0;var last=
\ No newline at end of file
@@ -0,0 +1,15 @@
+import { formatDate, formatCurrency } from './format';
+
+export function renderInvoiceSummary(invoice, customer, options = { currency: 'USD', locale: 'en-US', showTax: true, showDiscount: false }) {
+  const lines = invoice.items.map((item) => `${item.description.padEnd(40, ' ')} ${formatCurrency(item.amount * item.quantity, options.currency, options.locale)}`);
+  const header = `Invoice #${invoice.number} issued on ${formatDate(invoice.issuedAt, options.locale)} for ${customer.name} <${customer.email}>, due ${formatDate(invoice.dueAt, options.locale)}`;
+  const total = invoice.items.reduce((sum, item) => sum + item.amount * item.quantity, 0);
+  const tax = options.showTax ? `Tax (${(invoice.taxRate * 100).toFixed(1)}%): ${formatCurrency(total * invoice.taxRate, options.currency, options.locale)}` : '';
+  const discount = options.showDiscount && invoice.discount ? `Discount: -${formatCurrency(invoice.discount, options.currency, options.locale)}` : '';
+  return [header, ...lines, tax, discount, `Total: ${formatCurrency(total + total * invoice.taxRate - (invoice.discount || 0), options.currency, options.locale)}`].filter(Boolean).join('\n');
+}
+
+export function summarizeCustomerHistory(customer, invoices) {
+  const paid = invoices.filter((invoice) => invoice.status === 'paid' && invoice.customerId === customer.id && invoice.paidAt !== undefined);
+  const overdue = invoices.filter((invoice) => invoice.status !== 'paid' && invoice.customerId === customer.id && new Date(invoice.dueAt) < new Date());
+  return `${customer.name} has ${paid.length} paid invoices and ${overdue.length} overdue invoices totalling ${
@@ -0,0 +1,140 @@
+package completions
+
+import (
+	"code-completion/pkg/feature_flag"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// 补全所在文件的类型，用于按文件类型统计
+const (
+	FileKindTest   = "test"
+	FileKindSource = "source"
+)
+
+// 测试文件名去掉扩展名后可能带有的前后缀，按顺序只去掉第一个命中的
+var (
+	testNamePrefixes = []string{"test_"}
+	testNameSuffixes = []string{"_unittest", "_test", "_spec", ".test", ".spec", "Tests", "Test", "Spec"}
+)
+
+/**
+ * 测试文件的检测结果
+ * @description
+ * - Pattern: 命中的测试文件模式，见LanguageProfile.TestPatterns
+ * - Source: 推断的被测源文件(项目内的相对路径)，推断不出时为空
+ * - SourceBytes: 被测源文件的定义检索贡献的上下文字节数
+ */
+type TestFileDecision struct {
+	Pattern     string `json:"pattern"`
+	Source      string `json:"source,omitempty"`
+	SourceBytes int    `json:"sourceBytes,omitempty"`
+}
+
+/**
+ * 判断光标所在的文件是否为测试文件
+ * @param {string} language - 语言标识
+ * @param {string} filePath - 文件在项目内的路径，兼容Windows的分隔符
+ * @returns {*TestFileDecision} 返回检测结果，不是测试文件时返回nil
+ * @description
+ * - 按语言配置的测试文件模式匹配，见matchTestPattern
+ * - 命中后推断被测源文件，见sourceUnderTest
+ * @example
+ * d := detectTestFile("go", "pkg/util/strings_test.go")
+ * // d.Pattern = "*_test.go", d.Source = "pkg/util/strings.go"
+ */
+func detectTestFile(language, filePath string) *TestFileDecision {
+	if filePath == "" {
+		return nil
+	}
+	filePath = strings.ReplaceAll(filePath, "\\", "/")
+	for _, pattern := range profileOf(language).TestPatterns {
+		if matchTestPattern(pattern, filePath) {
+			return &TestFileDecision{Pattern: pattern, Source: sourceUnderTest(filePath)}
+		}
+	}
+	return nil
+}
+
+// 匹配测试文件模式：以/结尾的模式匹配路径中连续的目录，不含/的模式匹配文件名，其它模式匹配整个路径
+func matchTestPattern(pattern, filePath string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(filePath, pattern) || strings.Contains(filePath, "/"+pattern)
+	}
+	name := filePath
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(filePath)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+/**
+ * 推断测试文件对应的被测源文件
+ * @param {string} filePath - 测试文件在项目内的路径，分隔符为/
+ * @returns {string} 返回被测源文件的路径，推断不出时返回空
+ * @description
+ * - 文件名去掉测试前缀(test_)或后缀(_test/.spec/Test等)，扩展名不变
+ * - 去掉__tests__、tests、test目录，src/test/映射到src/main/
+ * - 结果与原路径相同时返回空
+ * @example
+ * sourceUnderTest("src/__tests__/util.test.ts")  // "src/util.ts"
+ * sourceUnderTest("tests/test_parser.py")        // "parser.py"
+ */
+func sourceUnderTest(filePath string) string {
+	dir, name := path.Split(filePath)
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for _, prefix := range testNamePrefixes {
+		if strings.HasPrefix(stem, prefix) && len(stem) > len(prefix) {
+			stem = stem[len(prefix):]
+			break
+		}
+	}
+	for _, suffix := range testNameSuffixes {
+		if strings.HasSuffix(stem, suffix) && len(stem) > len(suffix) {
+			stem = stem[:len(stem)-len(suffix)]
+			break
+		}
+	}
+
+	var segments []string
+	parts := strings.Split(strings.TrimSuffix(dir, "/"), "/")
+	for i, segment := range parts {
+		if segment == "test" && i > 0 && parts[i-1] == "src" {
+			segments = append(segments, "main")
+			continue
+		}
+		if segment == "__tests__" || segment == "tests" || segment == "test" || segment == "" {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	source := path.Join(append(segments, stem+ext)...)
+	if source == filePath {
+		return ""
+	}
+	return source
+}
+
+// 识别测试文件，测试文件按config.TestFileConfig调整阈值、停用词、输出长度和上下文
+func (in *CompletionInput) detectTestFile(c *CompletionContext) {
+	if !c.Enabled(feature_flag.TestFile) {
+		return
+	}
+	in.TestFile = detectTestFile(in.EffectiveLanguage(), in.Processed.FileProjectPath)
+	if in.TestFile != nil {
+		c.Log().Debug("Test file detected", zap.String("pattern", in.TestFile.Pattern),
+			zap.String("source", in.TestFile.Source))
+	}
+}
+
+// 补全所在文件的类型(test/source)
+func (in *CompletionInput) FileKind() string {
+	if in.TestFile != nil {
+		return FileKindTest
+	}
+	return FileKindSource
+}
@@ -0,0 +1,83 @@
+package completions
+
+import (
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test the mapping from test files to the source under test
+// go test ./pkg/completions/ -v -run Test_SourceUnderTest
+func Test_SourceUnderTest(t *testing.T) {
+	cases := map[string]string{
+		"pkg/util/strings_test.go":                      "pkg/util/strings.go",
+		"tests/test_parser.py":                          "parser.py",
+		"app/models/user_test.py":                       "app/models/user.py",
+		"src/utils/date.spec.ts":                        "src/utils/date.ts",
+		"src/components/__tests__/Button.test.tsx":      "src/components/Button.tsx",
+		"src/__tests__/nested/__tests__/format.test.js": "src/nested/format.js",
+		"src/test/java/com/acme/OrderServiceTest.java":  "src/main/java/com/acme/OrderService.java",
+		"spec/models/user_spec.rb":                      "spec/models/user.rb",
+		"pkg/util/strings.go":                           "",
+		"conftest.py":                                   "",
+	}
+	for file, expected := range cases {
+		if got := sourceUnderTest(file); got != expected {
+			t.Errorf("%s: expected %q, got %q", file, expected, got)
+		}
+	}
+}
+
+// to test test file detection with the language patterns
+// go test ./pkg/completions/ -v -run Test_DetectTestFile
+func Test_DetectTestFile(t *testing.T) {
+	cases := []struct {
+		language, file, pattern string
+	}{
+		{"go", "pkg/util/strings_test.go", "*_test.go"},
+		{"go", "pkg/util/strings.go", ""},
+		{"python", "tests\\test_parser.py", "test_*.py"},
+		{"python", "conftest.py", "conftest.py"},
+		{"typescript", "src/utils/date.spec.ts", "*.spec.*"},
+		{"typescriptreact", "src/__tests__/App.tsx", "__tests__/"},
+		{"java", "src/test/java/com/acme/Fixtures.java", "src/test/"},
+		{"rust", "tests/integration.rs", "tests/"},
+		{"rust", "src/tests_util.rs", ""},
+		{"markdown", "docs/test_plan.md", ""},
+	}
+	for _, tc := range cases {
+		d := detectTestFile(tc.language, tc.file)
+		if tc.pattern == "" {
+			if d != nil {
+				t.Errorf("%s: expected no test file, got %+v", tc.file, d)
+			}
+			continue
+		}
+		if d == nil || d.Pattern != tc.pattern {
+			t.Errorf("%s: expected pattern %q, got %+v", tc.file, tc.pattern, d)
+		}
+	}
+}
+
+// to test the blank line stop words of the test profile
+// go test ./pkg/completions/ -v -run Test_TestFileProfile
+func Test_TestFileProfile(t *testing.T) {
+	saved := config.Wrapper.TestFile
+	defer func() { config.Wrapper.TestFile = saved }()
+	config.Wrapper.TestFile = config.TestFileConfig{}
+
+	h := &CompletionHandler{}
+	in := &CompletionInput{TestFile: &TestFileDecision{Pattern: "*_test.go"}}
+	for _, stop := range h.prepareStopWords(in) {
+		if stop == "\n\n" {
+			t.Errorf("expected no blank line stop for test files")
+		}
+	}
+	config.Wrapper.TestFile.BlankLineStop = true
+	if stops := h.prepareStopWords(in); !contains(stops, "\n\n") {
+		t.Errorf("expected the blank line stop when configured, got %q", stops)
+	}
+	if stops := h.prepareStopWords(&CompletionInput{}); !contains(stops, "\n\n") {
+		t.Errorf("expected the blank line stop for source files, got %q", stops)
+	}
+}
@@ -0,0 +1,277 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/store"
+	"code-completion/pkg/tokenizers"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 前缀分词的方式，作为指标completion_token_cache_total的result标签
+const (
+	TokenCacheFull         = "full"         // 没有可用的缓存，完整分词
+	TokenCacheIncremental  = "incremental"  // 使用缓存，只对变化的分块分词
+	TokenCacheInconsistent = "inconsistent" // 缓存与完整分词不一致，丢弃缓存并完整分词
+)
+
+// 分块的标识，内容的哈希加长度
+type chunkKey struct {
+	hash uint64
+	size int
+}
+
+func chunkKeyOf(chunk string) chunkKey {
+	h := fnv.New64a()
+	h.Write([]byte(chunk))
+	return chunkKey{hash: h.Sum64(), size: len(chunk)}
+}
+
+/**
+ * 将前缀切分为分块
+ * @param {string} prefix - 前缀
+ * @returns {[]string} 返回分块，拼接起来等于prefix
+ * @description
+ * - 在以非空白字符开头的行之前切分，缩进的行和空行归入上一个分块(通常是一个顶层的函数或语句)
+ * - 常见tokenizer的预分词规则不会跨越"换行+非空白字符"的边界，各分块的token数之和等于完整分词的token数
+ */
+func splitPrefixChunks(prefix string) []string {
+	var chunks []string
+	start := 0
+	for i := 1; i < len(prefix); i++ {
+		if prefix[i-1] != '\n' {
+			continue
+		}
+		switch prefix[i] {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			continue
+		}
+		chunks = append(chunks, prefix[start:i])
+		start = i
+	}
+	return append(chunks, prefix[start:])
+}
+
+// 一个文件的分块token数缓存
+type tokenCacheEntry struct {
+	mutex      sync.Mutex
+	tokenizer  *tokenizers.Tokenizer
+	model      string
+	overhead   int              // 每次分词附加的特殊token数
+	counts     map[chunkKey]int // 上次请求各分块的token数(不含特殊token)
+	uses       int
+	unsuitable bool // 分块的token数之和与完整分词不一致，该文件不使用缓存
+}
+
+/**
+ * 前缀分词的结果
+ * @description
+ * - count: 前缀的token数，与完整分词的结果相同
+ * - tail: 返回前缀最后keep个token，keep不超过count
+ */
+type prefixTokens struct {
+	count int
+	tail  func(keep int) []int
+}
+
+// 完整分词
+func encodePrefix(tokenizer *tokenizers.Tokenizer, prefix string) *prefixTokens {
+	tokens := tokenizer.Encode(prefix)
+	return &prefixTokens{
+		count: len(tokens),
+		tail: func(keep int) []int {
+			return tokens[len(tokens)-keep:]
+		},
+	}
+}
+
+/**
+ * 前缀的增量分词缓存
+ * @description
+ * - 同一文件相邻请求的前缀大部分相同，按分块缓存token数，只对变化的分块分词
+ * - 截断前缀时只对保留的末尾分块分词，结果与完整分词相同
+ * - 缓存按(客户端, 文件)保存在有界存储中
+ */
+type PrefixTokenCache struct {
+	cfg      *config.TokenCacheConfig
+	entries  *store.Store[string, *tokenCacheEntry]
+	building sync.Map // 正在后台建立缓存的key
+	wg       sync.WaitGroup
+}
+
+/**
+ * 创建前缀的增量分词缓存
+ * @param {*config.TokenCacheConfig} cfg - 配置wrapper.tokenCache
+ * @returns {*PrefixTokenCache} 返回空的缓存
+ */
+func NewPrefixTokenCache(cfg *config.TokenCacheConfig) *PrefixTokenCache {
+	return &PrefixTokenCache{
+		cfg: cfg,
+		entries: store.New(store.Options[string, *tokenCacheEntry]{
+			Name:       "prefix_tokens",
+			MaxEntries: cfg.MaxFiles,
+			TTL:        cfg.TTL,
+			Metadata:   true, // 只有分块的哈希和token数
+		}),
+	}
+}
+
+var (
+	prefixCacheOnce sync.Once
+	prefixCache     *PrefixTokenCache
+)
+
+// 全局的前缀增量分词缓存，首次使用时按配置创建
+func PrefixCache() *PrefixTokenCache {
+	prefixCacheOnce.Do(func() {
+		prefixCache = NewPrefixTokenCache(&config.Wrapper.TokenCache)
+	})
+	return prefixCache
+}
+
+// 缓存的key，缺少客户端ID或文件路径时为空，不使用缓存
+func prefixCacheKey(clientID, filePath string) string {
+	if clientID == "" || filePath == "" {
+		return ""
+	}
+	return clientID + "\x00" + filePath
+}
+
+/**
+ * 对前缀分词
+ * @param {*tokenizers.Tokenizer} tokenizer - 模型的tokenizer
+ * @param {string} modelName - 模型名称，模型或tokenizer变化时重建缓存
+ * @param {string} key - 缓存的key，为空时完整分词
+ * @param {string} prefix - 前缀
+ * @returns {*prefixTokens} 返回前缀的token数和取末尾token的方法
+ * @description
+ * - 没有可用的缓存时完整分词，并在后台建立缓存
+ * - 有缓存时只对新出现的分块分词，由各分块的token数得到前缀的token数
+ * - 每VerifyEvery次使用缓存时完整分词核对；截断时末尾分块的分词结果与缓存不一致时同样丢弃缓存，改用完整分词
+ */
+func (c *PrefixTokenCache) Tokenize(tokenizer *tokenizers.Tokenizer, modelName, key, prefix string) *prefixTokens {
+	if c.cfg.Disabled || key == "" {
+		return encodePrefix(tokenizer, prefix)
+	}
+	entry, ok := c.entries.Get(key)
+	if !ok || entry.tokenizer != tokenizer || entry.model != modelName {
+		full := encodePrefix(tokenizer, prefix)
+		c.build(key, tokenizer, modelName, prefix, full.count)
+		metrics.IncrementTokenCache(TokenCacheFull)
+		return full
+	}
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if entry.unsuitable {
+		metrics.IncrementTokenCache(TokenCacheFull)
+		return encodePrefix(tokenizer, prefix)
+	}
+	chunks := splitPrefixChunks(prefix)
+	counts := make([]int, len(chunks))
+	latest := make(map[chunkKey]int, len(chunks))
+	total := entry.overhead
+	for i, chunk := range chunks {
+		k := chunkKeyOf(chunk)
+		n, ok := entry.counts[k]
+		if !ok {
+			n = tokenizer.GetTokenCount(chunk) - entry.overhead
+		}
+		counts[i] = n
+		latest[k] = n
+		total += n
+	}
+	// 只保留本次请求的分块，缓存的大小不超过前缀的分块数
+	entry.counts = latest
+	entry.uses++
+	c.entries.Put(key, entry)
+	if c.cfg.VerifyEvery > 0 && entry.uses%c.cfg.VerifyEvery == 0 {
+		if full := encodePrefix(tokenizer, prefix); full.count != total {
+			c.discard(key, "verify", full.count, total)
+			return full
+		}
+	}
+	metrics.IncrementTokenCache(TokenCacheIncremental)
+	return &prefixTokens{
+		count: total,
+		tail: func(keep int) []int {
+			return c.tail(key, tokenizer, prefix, chunks, counts, entry.overhead, keep)
+		},
+	}
+}
+
+// 只对末尾的分块分词，取最后keep个token
+func (c *PrefixTokenCache) tail(key string, tokenizer *tokenizers.Tokenizer, prefix string, chunks []string, counts []int, overhead, keep int) []int {
+	start, cached := len(chunks), 0
+	for start > 0 && cached < keep {
+		start--
+		cached += counts[start]
+	}
+	tokens := tokenizer.Encode(strings.Join(chunks[start:], ""))
+	if len(tokens) != cached+overhead || len(tokens) < keep {
+		c.discard(key, "tail", len(tokens), cached+overhead)
+		tokens = tokenizer.Encode(prefix)
+	}
+	return tokens[len(tokens)-keep:]
+}
+
+// 丢弃不一致的缓存
+func (c *PrefixTokenCache) discard(key, stage string, expected, cached int) {
+	c.entries.Delete(key)
+	metrics.IncrementTokenCache(TokenCacheInconsistent)
+	zap.L().Warn("Prefix token cache is inconsistent with the full tokenization, discarded",
+		zap.String("stage", stage),
+		zap.Int("expected", expected),
+		zap.Int("cached", cached))
+}
+
+/**
+ * 在后台建立一个文件的缓存
+ * @param {string} key - 缓存的key
+ * @param {*tokenizers.Tokenizer} tokenizer - 模型的tokenizer
+ * @param {string} modelName - 模型名称
+ * @param {string} prefix - 前缀
+ * @param {int} fullCount - 完整分词的token数，用于核对各分块的token数之和
+ * @description
+ * - 同一key同时只有一个后台任务
+ * - 各分块的token数之和与完整分词不一致时，记录该文件不适合使用缓存，直到缓存过期
+ */
+func (c *PrefixTokenCache) build(key string, tokenizer *tokenizers.Tokenizer, modelName, prefix string, fullCount int) {
+	if _, loaded := c.building.LoadOrStore(key, true); loaded {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.building.Delete(key)
+		entry := &tokenCacheEntry{
+			tokenizer: tokenizer,
+			model:     modelName,
+			overhead:  len(tokenizer.Encode("")),
+			counts:    make(map[chunkKey]int),
+		}
+		total := entry.overhead
+		for _, chunk := range splitPrefixChunks(prefix) {
+			n := tokenizer.GetTokenCount(chunk) - entry.overhead
+			entry.counts[chunkKeyOf(chunk)] = n
+			total += n
+		}
+		if total != fullCount {
+			entry.unsuitable = true
+			entry.counts = nil
+			zap.L().Debug("Prefix chunks are not token additive, token cache disabled for the file",
+				zap.String("model", modelName),
+				zap.Int("full", fullCount),
+				zap.Int("chunks", total))
+		}
+		c.entries.Put(key, entry)
+	}()
+}
+
+// 等待后台建立缓存的任务完成
+func (c *PrefixTokenCache) Wait() {
+	c.wg.Wait()
+}
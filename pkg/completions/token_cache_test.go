@@ -0,0 +1,146 @@
+package completions
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/tokenizers"
+)
+
+// 生成一个lines行的前缀，光标在最后一行的末尾
+func longPrefix(lines int) string {
+	var sb strings.Builder
+	for i := 0; i < lines; i++ {
+		switch i % 5 {
+		case 0:
+			fmt.Fprintf(&sb, "function f%d(a, b) {\n", i)
+		case 4:
+			sb.WriteString("}\n\n")
+		default:
+			fmt.Fprintf(&sb, "    const v%d = a + b * %d;\n", i, i)
+		}
+	}
+	return sb.String() + "const x = f0("
+}
+
+// to test that truncation with the incremental token cache matches the full tokenization
+// go test ./pkg/completions/ -v -run Test_PrefixTokenCache
+func Test_PrefixTokenCache(t *testing.T) {
+	prefix := longPrefix(200)
+	edits := []string{
+		prefix,
+		prefix + "1",
+		strings.Replace(prefix, "const v52 =", "let v52 =", 1),
+		strings.Replace(prefix, "function f100(a, b) {\n", "", 1),
+		"\n" + prefix + ", 2)",
+		prefix[:len(prefix)/2],
+	}
+	for _, maxPrefix := range []int{100000, 300, 40} {
+		cfg := config.ModelConfig{ModelName: "cache", MaxPrefix: maxPrefix, MaxSuffix: 10}
+		h := NewCompletionHandler(newByteTokenizerLLM(t, cfg))
+		key := fmt.Sprintf("c1\x00file-%d.js", maxPrefix)
+		for i, edit := range edits {
+			cached := PromptOptions{Prefix: edit, Suffix: ")\n", CodeContext: "ctx"}
+			full := cached
+			cachedBudget, fullBudget := newBudgetReport(&cached), newBudgetReport(&full)
+			h.truncatePrompt(&cfg, &cached, "", cachedBudget, key)
+			h.truncatePrompt(&cfg, &full, "", fullBudget, "")
+			PrefixCache().Wait()
+			if !reflect.DeepEqual(cached, full) {
+				t.Fatalf("max %d edit %d: expected %+v, got %+v", maxPrefix, i, full, cached)
+			}
+			if !reflect.DeepEqual(cachedBudget.Sections, fullBudget.Sections) || cachedBudget.PromptTokens != fullBudget.PromptTokens {
+				t.Errorf("max %d edit %d: budget differs", maxPrefix, i)
+			}
+		}
+		entry, ok := PrefixCache().entries.Get(key)
+		if !ok || entry.unsuitable || entry.uses != len(edits)-1 {
+			t.Errorf("max %d: expected the cache used after the first request, got %+v", maxPrefix, entry)
+		}
+	}
+}
+
+// to test falling back to the full tokenization on inconsistencies and invalidation
+// go test ./pkg/completions/ -v -run Test_PrefixTokenCacheFallback
+func Test_PrefixTokenCacheFallback(t *testing.T) {
+	tokenizer, err := tokenizers.NewTokenizer("testdata/tokenizer/tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewPrefixTokenCache(&config.TokenCacheConfig{MaxFiles: 10, VerifyEvery: 3})
+	prefix := longPrefix(50)
+	c.Tokenize(tokenizer, "m1", "k", prefix)
+	c.Wait()
+
+	// 缓存的token数被篡改，截断时末尾分块的分词结果不一致
+	entry, _ := c.entries.Get("k")
+	for k := range entry.counts {
+		entry.counts[k]++
+	}
+	p := c.Tokenize(tokenizer, "m1", "k", prefix)
+	if tail := p.tail(30); !reflect.DeepEqual(tail, tokenizer.Encode(prefix)[len(prefix)-30:]) {
+		t.Errorf("expected the full tokenization after an inconsistency, got %v", tail)
+	}
+	if _, ok := c.entries.Get("k"); ok {
+		t.Error("expected the inconsistent cache discarded")
+	}
+
+	// 定期核对发现不一致
+	c.Tokenize(tokenizer, "m1", "k", prefix)
+	c.Wait()
+	entry, _ = c.entries.Get("k")
+	for k := range entry.counts {
+		entry.counts[k]++
+	}
+	for i := 0; i < 3; i++ {
+		p = c.Tokenize(tokenizer, "m1", "k", prefix)
+	}
+	if p.count != len(prefix) {
+		t.Errorf("expected the verified count %d, got %d", len(prefix), p.count)
+	}
+	if _, ok := c.entries.Get("k"); ok {
+		t.Error("expected the cache discarded by the verification")
+	}
+
+	// 模型变化时重建
+	c.Tokenize(tokenizer, "m1", "k", prefix)
+	c.Wait()
+	c.Tokenize(tokenizer, "m2", "k", prefix)
+	c.Wait()
+	if entry, _ := c.entries.Get("k"); entry.model != "m2" || entry.uses != 0 {
+		t.Errorf("expected the cache rebuilt for the new model, got %+v", entry)
+	}
+
+	if chunks := splitPrefixChunks("a {\n  b\n\n}\nc\n d"); !reflect.DeepEqual(chunks, []string{"a {\n  b\n\n", "}\n", "c\n d"}) {
+		t.Errorf("unexpected chunks %q", chunks)
+	}
+}
+
+// 5千行的前缀，每次请求修改一行
+func benchmarkPrefixTokenize(b *testing.B, key string) {
+	tokenizer, err := tokenizers.NewTokenizer("testdata/tokenizer/tokenizer.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := NewPrefixTokenCache(&config.TokenCacheConfig{MaxFiles: 10, VerifyEvery: 1000000})
+	prefix := longPrefix(5000)
+	c.Tokenize(tokenizer, "m1", key, prefix)
+	c.Wait()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		edited := strings.Replace(prefix, "const v2502 =", fmt.Sprintf("const v%d =", i), 1)
+		c.Tokenize(tokenizer, "m1", key, edited)
+	}
+}
+
+// go test ./pkg/completions/ -bench Benchmark_PrefixTokenize -run ^$
+func Benchmark_PrefixTokenizeFull(b *testing.B) {
+	benchmarkPrefixTokenize(b, "")
+}
+
+func Benchmark_PrefixTokenizeIncremental(b *testing.B) {
+	benchmarkPrefixTokenize(b, "k")
+}
@@ -0,0 +1,113 @@
+package completions
+
+import (
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+/**
+ * 模型返回多个候选时的token用量统计
+ * @param {*CompletionContext} c - 补全上下文，用量记录在c.Perf中
+ * @param {*completionAttempt} a - 模型调用及后置处理的结果
+ * @description
+ * - 上游的usage.completion_tokens是所有候选合计的用量，记录在GeneratedTokens中(计费用量)
+ * - CompletionTokens改为返回给用户的补全内容(后置处理后)的token数
+ * - 上游合计用量和每个候选的token数记录在Verbose中
+ * - 有tokenizer时用tokenizer计算，否则按字符数分摊上游合计用量
+ * - 只返回一个候选时不调用，保持原有的统计口径
+ * - 只统计返回的这次调用，重试时被取代的调用的用量由accountEarlier计入
+ */
+func (h *CompletionHandler) accountChoices(c *CompletionContext, a *completionAttempt) {
+	usage := a.rsp.Usage
+	texts := make([]string, 0, len(a.rsp.Choices)+1)
+	for _, choice := range a.rsp.Choices {
+		texts = append(texts, choice.Text)
+	}
+	counts := h.countChoiceTokens(append(texts, a.text), usage.CompletionTokens)
+	delivered := counts[len(counts)-1]
+	counts = counts[:len(counts)-1]
+
+	c.Perf.GeneratedTokens = usage.CompletionTokens
+	c.Perf.CompletionTokens = delivered
+	if a.verbose != nil {
+		a.verbose.Usage = &usage
+		a.verbose.ChoiceTokens = counts
+	}
+	c.Log().Debug("Account multi-choice usage", zap.Int("choices", len(a.rsp.Choices)),
+		zap.Int("generated", usage.CompletionTokens), zap.Int("delivered", delivered),
+		zap.Ints("choiceTokens", counts))
+}
+
+/**
+ * 计算各段文本的token数
+ * @param {[]string} texts - 要计算的文本，前面是各个候选，最后一个是返回的补全内容
+ * @param {int} total - 上游报告的所有候选合计的token数
+ * @returns {[]int} 返回每段文本的token数
+ * @description
+ * - 有tokenizer时直接计算
+ * - 没有tokenizer时，按各候选的字符数占比分摊total，返回内容按同样的比例估算
+ */
+func (h *CompletionHandler) countChoiceTokens(texts []string, total int) []int {
+	counts := make([]int, len(texts))
+	if tokenizer := h.llm.Tokenizer(); tokenizer != nil {
+		for i, text := range texts {
+			counts[i] = tokenizer.GetTokenCount(text)
+		}
+		return counts
+	}
+	runes := 0
+	for _, text := range texts[:len(texts)-1] {
+		runes += utf8.RuneCountInString(text)
+	}
+	if runes == 0 {
+		return counts
+	}
+	for i, text := range texts {
+		counts[i] = total * utf8.RuneCountInString(text) / runes
+	}
+	return counts
+}
+
+// 模型调用已计费的用量
+type billedUsage struct {
+	prompt     int
+	completion int
+}
+
+func (u billedUsage) add(other billedUsage) billedUsage {
+	return billedUsage{prompt: u.prompt + other.prompt, completion: u.completion + other.completion}
+}
+
+// 本次调用及被它取代的此前调用的用量合计
+func (a *completionAttempt) billed() billedUsage {
+	u := a.earlier
+	if a.rsp != nil {
+		u.prompt += a.rsp.Usage.PromptTokens
+		u.completion += a.rsp.Usage.CompletionTokens
+	}
+	return u
+}
+
+/**
+ * 把被取代的模型调用的用量计入计费用量
+ * @param {*CompletionPerformance} perf - 本次请求的用量统计
+ * @param {billedUsage} earlier - 被取代的调用的用量合计
+ * @description
+ * - 上下文长度超限重试、后置处理丢弃后重试时，此前的调用也已计费
+ * - PromptTokens加上此前调用的提示词token数，GeneratedTokens为各次调用(各候选)合计生成的token数
+ * - CompletionTokens仍是返回给用户的补全内容的token数
+ */
+func accountEarlier(perf *CompletionPerformance, earlier billedUsage) {
+	if earlier.prompt == 0 && earlier.completion == 0 {
+		return
+	}
+	perf.PromptTokens += earlier.prompt
+	if earlier.completion > 0 {
+		generated := perf.GeneratedTokens
+		if generated == 0 {
+			generated = perf.CompletionTokens
+		}
+		perf.GeneratedTokens = generated + earlier.completion
+	}
+}
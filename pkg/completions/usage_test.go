@@ -0,0 +1,136 @@
+package completions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// multiChoiceLLM returns several choices with the usage of all of them combined
+type multiChoiceLLM struct {
+	cfg     config.ModelConfig
+	choices []string
+	usage   model.CompletionUsage
+}
+
+func (m *multiChoiceLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	rsp := &model.CompletionResponse{Usage: m.usage}
+	for i, text := range m.choices {
+		rsp.Choices = append(rsp.Choices, model.CompletionChoice{Text: text, Index: i})
+	}
+	return rsp, &model.CompletionVerbose{Id: "multi"}, model.StatusSuccess, nil
+}
+
+func (m *multiChoiceLLM) Config() *config.ModelConfig {
+	return &m.cfg
+}
+
+func (m *multiChoiceLLM) Tokenizer() *tokenizers.Tokenizer {
+	return nil
+}
+
+// tokenObservations returns the count and sum observed by the completion_tokens histogram
+func tokenObservations(t *testing.T, modelName, tokenType string) (uint64, float64) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "completion_tokens" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["model"] == modelName && labels["type"] == tokenType {
+				return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	return 0, 0
+}
+
+func callMultiChoice(modelName string, usage model.CompletionUsage, choices ...string) *CompletionResponse {
+	llm := &multiChoiceLLM{cfg: config.ModelConfig{ModelName: modelName}, choices: choices, usage: usage}
+	c := NewCompletionContext(context.Background(), &CompletionPerformance{ReceiveTime: time.Now()})
+	para := &model.CompletionParameter{
+		CompletionID: "multi",
+		Model:        modelName,
+		Language:     "javascript",
+		Prefix:       "function main() {\n",
+		Stop:         []string{"\n\n"},
+	}
+	return NewCompletionHandler(llm).CallLLM(c, para)
+}
+
+// to test the token accounting of responses with several choices
+// go test ./pkg/completions/ -v -run Test_MultiChoiceUsage
+func Test_MultiChoiceUsage(t *testing.T) {
+	defer setupPruneRetry(config.PruneRetryConfig{})()
+
+	// 12, 12 and 16 characters share the 80 generated tokens, the returned text is cut to 10 characters
+	usage := model.CompletionUsage{PromptTokens: 50, CompletionTokens: 80, TotalTokens: 130}
+	rsp := callMultiChoice("multi-choice", usage, "return 42;\n\n", "return 420;\n", "return x + y + z")
+	if rsp.Status != model.StatusSuccess || rsp.Choices[0].Text != "return 42;" {
+		t.Fatalf("unexpected response %s %q", rsp.Status, rsp.Choices[0].Text)
+	}
+	if rsp.Usage.CompletionTokens != 20 || rsp.Usage.GeneratedTokens != 80 || rsp.Usage.TotalTokens != 70 {
+		t.Errorf("unexpected usage %+v", rsp.Usage)
+	}
+	if rsp.Verbose.Usage == nil || *rsp.Verbose.Usage != usage {
+		t.Errorf("expected the upstream usage in verbose, got %+v", rsp.Verbose.Usage)
+	}
+	if got := rsp.Verbose.ChoiceTokens; len(got) != 3 || got[0] != 24 || got[1] != 24 || got[2] != 32 {
+		t.Errorf("unexpected per-choice tokens %v", got)
+	}
+	if count, sum := tokenObservations(t, "multi-choice", "output"); count != 1 || sum != 20 {
+		t.Errorf("expected one delivered observation of 20, got %d %v", count, sum)
+	}
+	if count, sum := tokenObservations(t, "multi-choice", "billed"); count != 1 || sum != 80 {
+		t.Errorf("expected one billed observation of 80, got %d %v", count, sum)
+	}
+
+	// a single choice keeps the upstream usage as is
+	usage = model.CompletionUsage{PromptTokens: 50, CompletionTokens: 6, TotalTokens: 56}
+	rsp = callMultiChoice("single-choice", usage, "return 42;\n\n")
+	if rsp.Usage.CompletionTokens != 6 || rsp.Usage.GeneratedTokens != 0 || rsp.Usage.TotalTokens != 56 || rsp.Verbose.Usage != nil {
+		t.Errorf("unexpected single choice usage %+v", rsp.Usage)
+	}
+	if count, sum := tokenObservations(t, "single-choice", "output"); count != 1 || sum != 6 {
+		t.Errorf("expected one output observation of 6, got %d %v", count, sum)
+	}
+	if count, sum := tokenObservations(t, "single-choice", "billed"); count != 1 || sum != 6 {
+		t.Errorf("expected one billed observation of 6, got %d %v", count, sum)
+	}
+}
+
+// to test the usage of the attempts replaced by a retry is billed on top of the returned one
+// go test ./pkg/completions/ -v -run Test_EarlierAttemptUsage
+func Test_EarlierAttemptUsage(t *testing.T) {
+	// all choices of both multi-choice attempts are billed, the delivered tokens stay those of the returned text
+	perf := &CompletionPerformance{PromptTokens: 50, CompletionTokens: 20, GeneratedTokens: 80}
+	accountEarlier(perf, billedUsage{prompt: 50, completion: 80})
+	if perf.PromptTokens != 100 || perf.GeneratedTokens != 160 || perf.CompletionTokens != 20 {
+		t.Errorf("unexpected multi-choice usage %+v", perf)
+	}
+	// a single choice retried bills both generations
+	perf = &CompletionPerformance{PromptTokens: 50, CompletionTokens: 6}
+	accountEarlier(perf, billedUsage{prompt: 50, completion: 8})
+	if perf.PromptTokens != 100 || perf.GeneratedTokens != 14 || perf.CompletionTokens != 6 {
+		t.Errorf("unexpected retried usage %+v", perf)
+	}
+	// without an earlier attempt the single choice semantics are kept
+	perf = &CompletionPerformance{PromptTokens: 50, CompletionTokens: 6}
+	accountEarlier(perf, billedUsage{})
+	if perf.PromptTokens != 50 || perf.GeneratedTokens != 0 {
+		t.Errorf("unexpected single attempt usage %+v", perf)
+	}
+}
@@ -1,10 +1,12 @@
 package completions
 
 import (
-	"os"
+	"code-completion/pkg/config"
+	"math"
 	"regexp"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // STR_PREFIX_CONFIG 字符串前缀配置
@@ -205,13 +207,15 @@ func checkContextIncludeText(text, prefix, suffix string) bool {
 		return true
 	}
 
+	// 比较首个字符而不是首个字节，多字节字符的首字节会与其他字符相同
+	firstChar, _ := utf8.DecodeRuneInString(text)
 	if len(text) <= 3 && strings.TrimSpace(suffix) != "" &&
-		strings.HasPrefix(strings.TrimSpace(suffix), string(text[0])) {
+		strings.HasPrefix(strings.TrimSpace(suffix), string(firstChar)) {
 		return true
 	}
 
 	if containsOnlyNonAlpha(text) && strings.TrimSpace(suffix) != "" &&
-		strings.HasPrefix(strings.TrimSpace(suffix), string(text[0])) {
+		strings.HasPrefix(strings.TrimSpace(suffix), string(firstChar)) {
 		return true
 	}
 
@@ -314,6 +318,7 @@ func cutSuffixOverlap(text, prefix, suffix string, cutLine int, ignoreOverlapLen
 // cutPrefixOverlap 去除「补全内容」与prefix的后缀重叠部分
 /**
  * Remove overlapping content between completion text and prefix suffix
+ * @param {string} language - Programming language, decides whether indentation is significant
  * @param {string} text - The completion text to be processed
  * @param {string} prefix - The prefix text before cursor position
  * @param {string} suffix - The suffix text after cursor position (not used in this function)
@@ -324,15 +329,18 @@ func cutSuffixOverlap(text, prefix, suffix string, cutLine int, ignoreOverlapLen
  * - It uses a sliding window approach to compare lines from the end of prefix with lines from the beginning of completion text
  * - If significant overlap is detected (3+ consecutive lines or 60%+ match ratio), the entire completion is discarded
  * - For short completion texts (<3 lines), it uses a simpler check via judgePrefixFullLineRepetitive
+ * - Lines are compared after normalizeLine; for indentation-significant languages (python, yaml, ...)
+ *   the cursor line prefix is joined to the first completion line, so that lines with different
+ *   indentation are not considered the same
  * @example
  * // If prefix ends with "function test() {" and completion starts with "function test() {",
  * // the completion will be considered overlapping and removed
- * processedText := cutPrefixOverlap(completion, prefix, suffix, 5)
+ * processedText := cutPrefixOverlap("javascript", completion, prefix, suffix, 5)
  */
-func cutPrefixOverlap(text, prefix, suffix string, cutLine int) string {
+func cutPrefixOverlap(language, text, prefix, suffix string, cutLine int) string {
 	// Remove leading/trailing whitespace from completion text
-	stripText := strings.TrimSpace(text)
-	if len(stripText) == 0 {
+	stripText := trimBlock(language, text)
+	if len(strings.TrimSpace(stripText)) == 0 {
 		return text
 	}
 
@@ -341,15 +349,23 @@ func cutPrefixOverlap(text, prefix, suffix string, cutLine int) string {
 	// For short completion texts (<3 lines), use a simpler check
 	if len(splitText) < 3 {
 		// Check if completion text is completely repetitive with prefix's last line
-		if judgePrefixFullLineRepetitive(text, prefix) {
+		if judgePrefixFullLineRepetitive(language, text, prefix) {
 			return ""
 		}
 		return text
 	}
 
 	// Clean and split prefix into lines
-	prefix = strings.TrimSpace(prefix)
-	splitPrefix := strings.Split(prefix, "\n")
+	var splitPrefix []string
+	if indentSignificant(language) {
+		// The first completion line continues the cursor line, which carries its indentation
+		prefixLines := strings.Split(prefix, "\n")
+		splitText = strings.Split(trimBlock(language, prefixLines[len(prefixLines)-1]+text), "\n")
+		splitPrefix = strings.Split(trimBlock(language, strings.Join(prefixLines[:len(prefixLines)-1], "\n")), "\n")
+	} else {
+		prefix = strings.TrimSpace(prefix)
+		splitPrefix = strings.Split(prefix, "\n")
+	}
 
 	// Determine the maximum number of lines to compare
 	matchLine := min(len(splitPrefix), len(splitText))
@@ -385,7 +401,7 @@ func cutPrefixOverlap(text, prefix, suffix string, cutLine int) string {
 
 		// Compare each line in the windows
 		for j := 0; j < len(curMatchTextList) && j < len(patternTextList); j++ {
-			if strings.TrimSpace(curMatchTextList[j]) == strings.TrimSpace(patternTextList[j]) {
+			if normalizeLine(language, curMatchTextList[j]) == normalizeLine(language, patternTextList[j]) {
 				matchCount++
 				// If we find 3 consecutive matching lines, consider it significant overlap
 				if matchCount == 3 && continueFlag {
@@ -408,6 +424,7 @@ func cutPrefixOverlap(text, prefix, suffix string, cutLine int) string {
 
 /**
  * Check if completion content completely repeats with prefix's last line
+ * @param {string} language - Programming language, decides whether indentation is significant
  * @param {string} completionText - Completion text to check for repetition
  * @param {string} prefix - Prefix text ending to compare with
  * @returns {bool} Returns true if completion repeats prefix ending, false otherwise
@@ -416,13 +433,13 @@ func cutPrefixOverlap(text, prefix, suffix string, cutLine int) string {
  * - Combines prefix last line with completion text for comparison
  * - Filters out empty lines from both texts
  * - Returns false if completion has more lines than prefix
- * - Compares completion lines with corresponding prefix ending lines
+ * - Compares completion lines with corresponding prefix ending lines after normalizeLine
  * @example
- * if judgePrefixFullLineRepetitive("text", "prefix text") {
+ * if judgePrefixFullLineRepetitive("go", "text", "prefix text") {
  *     // Completion completely repeats prefix ending
  * }
  */
-func judgePrefixFullLineRepetitive(completionText, prefix string) bool {
+func judgePrefixFullLineRepetitive(language, completionText, prefix string) bool {
 	if len(prefix) == 0 || len(completionText) == 0 {
 		return false
 	}
@@ -458,7 +475,7 @@ func judgePrefixFullLineRepetitive(completionText, prefix string) bool {
 	}
 
 	for i := 0; i < len(nonEmptyCompletionText); i++ {
-		if nonEmptyCompletionText[i] != nonEmptyPrefixText[i] {
+		if normalizeLine(language, nonEmptyCompletionText[i]) != normalizeLine(language, nonEmptyPrefixText[i]) {
 			return false
 		}
 	}
@@ -469,28 +486,29 @@ func judgePrefixFullLineRepetitive(completionText, prefix string) bool {
 /**
  * Remove repetitive content from completion text
  * @param {string} text - Completion text to process
+ * @param {int} minLines - Minimum number of lines to process (RepetitionMinLines, 3 by default)
+ * @param {float64} ratio - Ratio threshold for repetition detection (RepetitionRatio, 0.15 by default)
  * @returns {string} Returns text with repetitive content removed
  * @description
  * - Returns original text if length is 0
- * - Only processes texts with 3 or more lines
- * - Uses internal ratio threshold of 0.15 for repetition detection
+ * - Only processes texts with minLines or more lines
  * - Delegates to doCutRepetitiveText for actual processing
  * @example
- * processed := cutRepetitiveText("abc\nabc\nabc\ndef")
+ * processed := cutRepetitiveText("abc\nabc\nabc\ndef", 3, 0.15)
  * // processed will remove repetitive "abc" lines
  */
-func cutRepetitiveText(text string) string {
+func cutRepetitiveText(text string, minLines int, ratio float64) string {
 	if len(text) == 0 {
 		return text
 	}
 
-	// 行数超过3才触发去重
+	// 行数不少于minLines才触发去重
 	lineCount := len(strings.Split(strings.TrimSpace(text), "\n"))
-	if lineCount < 3 {
+	if lineCount < minLines {
 		return text
 	}
 
-	return doCutRepetitiveText(text, 0.15)
+	return doCutRepetitiveText(text, ratio)
 }
 
 /**
@@ -602,23 +620,25 @@ func longestCommonSubstring(a, b string) string {
 
 /**
  * Check for extreme repetition patterns in code
+ * @param {string} language - Programming language, decides whether indentation is significant
  * @param {string} code - Code content to check for extreme repetition
+ * @param {*config.PruneParams} p - Thresholds with defaults resolved (ExtremeMinLines, ExtremeSimilarity, ExtremeCount)
  * @returns {bool, string, int} Returns (hasExtremeRepetition, repeatedPattern, repetitionCount)
  * @description
  * - Returns (false, "", 0) for empty code or insufficient lines
- * - Filters out empty lines before analysis
- * - Requires at least 5 non-empty lines for analysis
+ * - Filters out empty lines before analysis, and normalizes the others by normalizeLine
+ * - Requires at least ExtremeMinLines (5) non-empty lines for analysis
  * - Finds longest common substring between consecutive lines
- * - Checks if LCS length is significant (> 5 chars and >= half line length)
+ * - Checks if LCS length is significant (> 5 chars and >= ExtremeSimilarity (half) of the line length)
  * - Counts occurrences with same position in subsequent lines
- * - Returns true if repetition count > 8 or > half of total lines
+ * - Returns true if repetition count > ExtremeCount (8) or > half of total lines
  * @example
- * hasRepetition, pattern, count := isExtremeRepetition("line1\nline1\nline1")
+ * hasRepetition, pattern, count := isExtremeRepetition("go", "line1\nline1\nline1", &defaultPruneParams)
  * if hasRepetition {
  *     fmt.Printf("Pattern '%s' repeated %d times", pattern, count)
  * }
  */
-func isExtremeRepetition(code string) (bool, string, int) {
+func isExtremeRepetition(language, code string, p *config.PruneParams) (bool, string, int) {
 	if len(code) == 0 {
 		return false, "", 0
 	}
@@ -627,13 +647,12 @@ func isExtremeRepetition(code string) (bool, string, int) {
 	lines := strings.Split(code, "\n")
 	var nonEmptyLines []string
 	for _, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine != "" {
-			nonEmptyLines = append(nonEmptyLines, trimmedLine)
+		if strings.TrimSpace(line) != "" {
+			nonEmptyLines = append(nonEmptyLines, normalizeLine(language, line))
 		}
 	}
 
-	if len(nonEmptyLines) < 5 {
+	if len(nonEmptyLines) < p.ExtremeMinLines {
 		return false, "", 0
 	}
 
@@ -642,8 +661,9 @@ func isExtremeRepetition(code string) (bool, string, int) {
 	for i := 0; i < n-1; i++ {
 		lcs := longestCommonSubstring(nonEmptyLines[i], nonEmptyLines[i+1])
 
-		// 如果最长公共子串长度大于5且不小于行数的一半，则进行匹配过程
-		if len(lcs) > 5 && len(lcs) >= len(nonEmptyLines[i])/2 {
+		// 如果最长公共子串长度大于5且不小于行长的ExtremeSimilarity(默认一半)，则进行匹配过程
+		// 保留缩进的语言中，公共的缩进不计入长度
+		if len(strings.TrimSpace(lcs)) > 5 && float64(len(lcs)) >= math.Floor(p.ExtremeSimilarity*float64(len(nonEmptyLines[i]))) {
 			// 查找lcs在第一个字符串中的位置
 			firstLineLcsIndex := strings.Index(nonEmptyLines[i], lcs)
 			if firstLineLcsIndex == -1 {
@@ -660,8 +680,8 @@ func isExtremeRepetition(code string) (bool, string, int) {
 				}
 			}
 
-			// 如果重复次数超过8或超过总行数的一半，则认为存在极端重复
-			if count > 8 || count > n/2 {
+			// 如果重复次数超过ExtremeCount或超过总行数的一半，则认为存在极端重复
+			if count > p.ExtremeCount || count > n/2 {
 				return true, lcs, count
 			}
 		}
@@ -689,15 +709,10 @@ func min(a, b int) int {
 	return b
 }
 
-// IsPythonText 判断是否为Python文本
+// IsPythonText 判断是否为Python文本，特征文本见配置wrapper.prune.pythonTextRules
 func IsPythonText(text string) bool {
-	pythonTextRules := os.Getenv("PYTHON_TEXT_RULES")
-	if pythonTextRules == "" {
-		pythonTextRules = "return self.name"
-	}
-	rules := strings.Split(pythonTextRules, ",")
-	for _, rule := range rules {
-		if strings.Contains(text, rule) {
+	for _, rule := range pythonTextRules() {
+		if rule != "" && strings.Contains(text, rule) {
 			return true
 		}
 	}
@@ -712,34 +727,10 @@ var (
 	multilineCssPropertyPattern = regexp.MustCompile(`^\s*[a-zA-Z-]+\s*:\s*[^;]+;\s*$`)
 )
 
-// FrontLanguageEnum 前端语言枚举
-type FrontLanguageEnum string
-
-const (
-	FrontLanguageVue  FrontLanguageEnum = "vue"
-	FrontLanguageHTML FrontLanguageEnum = "html"
-	FrontLanguageTS   FrontLanguageEnum = "typescript"
-	FrontLanguageCSS  FrontLanguageEnum = "css"
-)
-
-// GetValues 获取前端语言枚举值
-func (e FrontLanguageEnum) GetValues() []FrontLanguageEnum {
-	return []FrontLanguageEnum{FrontLanguageVue, FrontLanguageHTML, FrontLanguageTS, FrontLanguageCSS}
-}
-
 // JudgeCss 判断文本是否为css样式
 func JudgeCss(language string, text string, ratio float64) bool {
-	// 检查语言是否为前端语言
-	isFrontLanguage := false
-	frontLanguages := FrontLanguageEnum("").GetValues()
-	for _, lang := range frontLanguages {
-		if strings.ToLower(language) == string(lang) {
-			isFrontLanguage = true
-			break
-		}
-	}
-
-	if !isFrontLanguage {
+	// 检查语言是否为前端语言(LanguageProfile.FrontEnd)
+	if !profileOf(language).FrontEnd {
 		return false
 	}
 
@@ -789,23 +780,24 @@ func IncludeCss(line string) bool {
 	return false
 }
 
-// IsValidBrackets 用于判断text字符串中括号是否完整
+// IsValidBrackets 用于判断text字符串中括号是否完整，嵌套超过扫描上限(见config.ScanConfig)时视为不完整
 func IsValidBrackets(text string) bool {
-	stack := make([]rune, 0)
-	mapping := map[rune]rune{
-		')': '(',
-		'}': '{',
-		']': '[',
-	}
-
-	for _, char := range text {
-		if closer, ok := mapping[char]; ok {
-			if len(stack) == 0 || stack[len(stack)-1] != closer {
-				return false
+	_, _, depth := scanLimits()
+	stack := make([]byte, 0, 16)
+	for i := 0; i < len(text); i++ {
+		for _, pair := range bracketPairs {
+			switch text[i] {
+			case pair[0]:
+				if len(stack) >= depth {
+					return false
+				}
+				stack = append(stack, pair[0])
+			case pair[1]:
+				if len(stack) == 0 || stack[len(stack)-1] != pair[0] {
+					return false
+				}
+				stack = stack[:len(stack)-1]
 			}
-			stack = stack[:len(stack)-1]
-		} else if strings.ContainsRune("({[", char) {
-			stack = append(stack, char)
 		}
 	}
 
@@ -844,24 +836,15 @@ func GetBoundarySymbols() []string {
 	return []string{"{", "(", "[", "\"", "'", ":", "<", ";", ",", ">", ".", "`"}
 }
 
-// CountPairedSymbols 统计成对出现的符号
+// CountPairedSymbols 统计成对出现的符号(各左括号的个数)
 func CountPairedSymbols(text string) map[string]int {
 	symbolsMap := make(map[string]int)
-	rightSymbols := GetRightPairedSymbols()
-
-	for _, char := range text {
-		charStr := string(char)
-		// 忽略孤立的右括号
-		if expectedLeft, ok := rightSymbols[charStr]; ok {
-			if symbolsMap[expectedLeft] == 0 {
-				continue
+	for i := 0; i < len(text); i++ {
+		for _, pair := range bracketPairs {
+			if text[i] == pair[0] {
+				symbolsMap[string(pair[0])]++
 			}
 		}
-
-		leftSymbols := GetLeftPairedSymbols()
-		if _, ok := leftSymbols[charStr]; ok {
-			symbolsMap[charStr]++
-		}
 	}
 
 	return symbolsMap
@@ -902,83 +885,14 @@ func RemoveStrings(codeLine string) string {
 	return string(result)
 }
 
-// IsCursorInParentheses 判断光标是否在括号内
+// IsCursorInParentheses 判断光标是否在括号内，见ScanCursor
 func IsCursorInParentheses(prefix, suffix string) bool {
-	findParenthesis := func(text string, symbol string, isReverse bool) int {
-		count := 0
-		leftSymbols := GetLeftPairedSymbols()
-
-		if isReverse {
-			runes := []rune(text)
-			for i := len(runes) - 1; i >= 0; i-- {
-				if string(runes[i]) == leftSymbols[symbol] {
-					count++
-				}
-				if string(runes[i]) == symbol {
-					if count <= 0 {
-						return len(runes) - 1 - i
-					}
-					count--
-				}
-			}
-		} else {
-			for i, char := range text {
-				if string(char) == leftSymbols[symbol] {
-					count++
-				}
-				if string(char) == symbol {
-					if count <= 0 {
-						return i
-					}
-					count--
-				}
-			}
-		}
-		return -1
-	}
-
-	leftSymbols := GetLeftPairedSymbols()
-	for leftSymbol, rightSymbol := range leftSymbols {
-		leftParenthesisIndex := findParenthesis(prefix, leftSymbol, true)
-		rightParenthesisIndex := findParenthesis(suffix, rightSymbol, false)
-		if leftParenthesisIndex != -1 && rightParenthesisIndex != -1 {
-			return true
-		}
-	}
-
-	return false
+	s := ScanCursor(prefix, suffix)
+	return s.InParentheses()
 }
 
-// IsCursorInString 判断光标是否在字符串内
+// IsCursorInString 判断光标是否在字符串内，见ScanCursor
 func IsCursorInString(cursorPrefix string) bool {
-	quotesSymbols := GetQuotesSymbols()
-	quotesCount := make(map[string]int)
-
-	for k := range quotesSymbols {
-		quotesCount[k] = 0
-	}
-
-	i := 0
-	runes := []rune(cursorPrefix)
-	for i < len(runes) {
-		if runes[i] == '\\' {
-			i += 2
-			continue
-		}
-
-		charStr := string(runes[i])
-		if _, ok := quotesSymbols[charStr]; ok {
-			quotesCount[charStr]++
-		}
-		i++
-	}
-
-	// 如果引号数量为奇数，则光标在字符串内
-	for _, count := range quotesCount {
-		if count%2 == 1 {
-			return true
-		}
-	}
-
-	return false
+	s := ScanCursor(cursorPrefix, "")
+	return s.InString()
 }
@@ -0,0 +1,63 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"strings"
+	"unicode"
+)
+
+// 语言的缩进是否有语义，配置了prune.indentLanguages时以配置的列表为准，否则见LanguageProfile
+func indentSignificant(language string) bool {
+	languages := config.Wrapper.Prune.IndentLanguages
+	if len(languages) == 0 {
+		return profileOf(language).IndentSignificant
+	}
+	language = strings.ToLower(language)
+	for _, l := range languages {
+		if strings.ToLower(l) == language {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 按语言规范化一行代码，用于比较两行是否相同
+ * @param {string} language - 编程语言
+ * @param {string} line - 一行代码
+ * @returns {string} 返回规范化后的行
+ * @description
+ * - 去掉行尾空白，行内的连续空白合并为一个空格
+ * - 缩进有语义的语言(python/yaml/makefile等)保留行首缩进，其他语言去掉行首缩进
+ * @example
+ * normalizeLine("go", "\tx :=  1 ")       // "x := 1"
+ * normalizeLine("python", "    x =  1 ")  // "    x = 1"
+ */
+func normalizeLine(language, line string) string {
+	body := strings.TrimSpace(line)
+	indent := ""
+	if indentSignificant(language) {
+		indent = line[:len(line)-len(strings.TrimLeftFunc(line, unicode.IsSpace))]
+	}
+	return indent + strings.Join(strings.Fields(body), " ")
+}
+
+/**
+ * 按语言去掉代码块首尾的空白
+ * @description
+ * - 缩进有语义的语言只去掉开头的空行，保留第一行的缩进
+ * - 其他语言等同于strings.TrimSpace
+ */
+func trimBlock(language, text string) string {
+	if !indentSignificant(language) {
+		return strings.TrimSpace(text)
+	}
+	text = strings.TrimRightFunc(text, unicode.IsSpace)
+	for {
+		idx := strings.Index(text, "\n")
+		if idx < 0 || strings.TrimSpace(text[:idx]) != "" {
+			return text
+		}
+		text = text[idx+1:]
+	}
+}
@@ -0,0 +1,56 @@
+package completions
+
+import (
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test that overlap and repetition checks keep indentation for indentation-significant languages
+// go test ./pkg/completions/ -v -run Test_IndentSensitiveCutters
+func Test_IndentSensitiveCutters(t *testing.T) {
+	saved := config.Wrapper.Prune.IndentLanguages
+	defer func() { config.Wrapper.Prune.IndentLanguages = saved }()
+	config.Wrapper.Prune.IndentLanguages = nil
+
+	// the completed lines repeat the prefix lines at a shallower indentation
+	prefix := "def process(items):\n    for item in items:\n        validate(item)\n        save(item)\n        log(item)\n    "
+	completion := "validate(item)\n    save(item)\n    log(item)\n"
+	if got := cutPrefixOverlap("python", completion, prefix, "", 3); got != completion {
+		t.Errorf("python completion with another indentation should not be cut, got %q", got)
+	}
+	if got := cutPrefixOverlap("go", completion, prefix, "", 3); got != "" {
+		t.Errorf("go completion repeating prefix lines should be cut, got %q", got)
+	}
+	// the same indentation is still an overlap in python
+	same := "    validate(item)\n        save(item)\n        log(item)\n"
+	if got := cutPrefixOverlap("python", same, prefix, "", 3); got != "" {
+		t.Errorf("python completion repeating prefix lines should be cut, got %q", got)
+	}
+
+	short := "log(item)\n"
+	if judgePrefixFullLineRepetitive("python", short, prefix) {
+		t.Error("python line with another indentation should not be repetitive")
+	}
+	if !judgePrefixFullLineRepetitive("go", short, "\t\tlog(item)\n\t") {
+		t.Error("go line differing in indentation should be repetitive")
+	}
+
+	// indentation alone is not a repeated pattern
+	block := strings.Repeat("        a = compute_first()\n        b = other_value()\n", 4)
+	if flag, lcs, _ := isExtremeRepetition("python", block, &defaultPruneParams); flag {
+		t.Errorf("python block should not be extreme repetition, got %q", lcs)
+	}
+	repeated := strings.Repeat("    result.append(value)\n", 10)
+	for _, language := range []string{"python", "go"} {
+		if flag, _, _ := isExtremeRepetition(language, repeated, &defaultPruneParams); !flag {
+			t.Errorf("%s repeated lines should be extreme repetition", language)
+		}
+	}
+
+	config.Wrapper.Prune.IndentLanguages = []string{"Go"}
+	if indentSignificant("python") || !indentSignificant("go") {
+		t.Error("configured languages should replace the defaults")
+	}
+}
@@ -1,22 +1,20 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 type ModelConfig struct {
-	Provider       string        `json:"provider" yaml:"provider"`             // 模型供应商，代表着具体的模型接口/类型
+	Provider       string        `json:"provider" yaml:"provider"`             // 模型供应商，代表着具体的模型接口/类型(openai/deepseek/ollama/llamacpp)
 	ModelTitle     string        `json:"modelTitle" yaml:"modelTitle"`         // 模型来源的唯一标识
 	ModelName      string        `json:"modelName" yaml:"modelName"`           // 真实的模型名称
 	CompletionsUrl string        `json:"completionsUrl" yaml:"completionsUrl"` // 补全地址
 	Tags           []string      `json:"tags" yaml:"tags"`                     // 模型标签，用户可以根据标签选择补全模型
-	Authorization  string        `json:"authorization" yaml:"authorization"`   // 认证信息
+	Authorization  string        `json:"authorization" yaml:"authorization"`   // 认证信息，可以用file:<路径>或env:<变量名>引用外部来源，轮换时不需要重启
 	Timeout        time.Duration `json:"timeout" yaml:"timeout"`               // 超时时间ms
 	MaxPrefix      int           `json:"maxPrefix" yaml:"maxPrefix"`           // 最大模型上下文长度:前缀
 	MaxSuffix      int           `json:"maxSuffix" yaml:"maxSuffix"`           // 最大模型上下文长度:后缀
@@ -30,6 +28,260 @@ type ModelConfig struct {
 	MaxConcurrent  int           `json:"maxConcurrent" yaml:"maxConcurrent"`   // 每种模型的最大并发数，防止模型过载
 	DisablePrune   bool          `json:"disablePrune" yaml:"disablePrune"`     // 禁止后期修剪
 	CustomPruners  []string      `json:"customPruners" yaml:"customPruners"`   // 自定义的后期修剪工具
+	PromptPreamble string        `json:"promptPreamble" yaml:"promptPreamble"` // 提示词前言模板，渲染后以注释形式置于上下文之前
+	RateLimit      float64       `json:"rateLimit" yaml:"rateLimit"`           // 发往模型后端的每秒最大请求数，0表示不限制
+	RateBurst      int           `json:"rateBurst" yaml:"rateBurst"`           // 限流令牌桶的容量，允许的瞬时突发请求数
+	AuthMode       string        `json:"authMode" yaml:"authMode"`             // 调用模型后端的认证方式(server/passthrough/both-fallback)，为空表示server
+	// 代码上下文和前缀之间的分隔符，不配置时为"\n"，可以配置为空或注释围栏；上下文为空时不拼接
+	ContextSeparator *string `json:"contextSeparator,omitempty" yaml:"contextSeparator,omitempty"`
+	// 从模型输出中提取代码块(去掉代码围栏和之前的说明文字)，用于指令模型，FIM模型不需要开启
+	ExtractResponse bool `json:"extractResponse" yaml:"extractResponse"`
+	// 截断提示词时在截断处插入一行注释标记(如"// ... earlier code omitted ...")，提示模型缺少上下文；部分模型对标记反应不好，默认关闭
+	TruncationMarkers bool `json:"truncationMarkers" yaml:"truncationMarkers"`
+	// 引用外部来源的认证信息的定时重新读取间隔，不配置时为1分钟；文件另有变更通知，立即重新读取
+	CredentialRefresh time.Duration `json:"credentialRefresh" yaml:"credentialRefresh"`
+	// 按语言覆盖的提示词组装策略，key为语言id或别名，"*"对没有单独配置的语言生效
+	Languages map[string]ModelLanguageConfig `json:"languages,omitempty" yaml:"languages,omitempty"`
+	// 按观测到的延迟自动调整模型池的最大并发数，默认关闭
+	Autotune ConcurrencyTuning `json:"autotune" yaml:"autotune"`
+}
+
+/**
+ * 模型池最大并发数的自动调整(AIMD)
+ * @description
+ * - 每Interval按最近Window内(上次调整之后)调用模型的耗时计算p90
+ * - p90超过TargetLatency时按Backoff成倍降低并发数；低于目标且并发槽位用满时每次增加Increase
+ * - 并发数限制在[MinConcurrent, MaxConcurrent]，不配置时为[1, 配置的maxConcurrent的2倍]，不超过streamController.maxPoolConcurrent
+ * - 样本少于MinSamples时保持不变；启动预热期间不调整
+ * - 调整通过运行时调整模型池并发数的机制生效，记录在/api/stats的resizes中，操作方为autotune
+ * @example
+ * autotune:
+ *   enabled: true
+ *   targetLatency: 800ms
+ *   minConcurrent: 2
+ *   maxConcurrent: 32
+ *   interval: 30s
+ */
+type ConcurrencyTuning struct {
+	Enabled       bool          `json:"enabled" yaml:"enabled"`             // 是否自动调整
+	TargetLatency time.Duration `json:"targetLatency" yaml:"targetLatency"` // 调用模型耗时的p90目标
+	MinConcurrent int           `json:"minConcurrent" yaml:"minConcurrent"` // 并发数下限
+	MaxConcurrent int           `json:"maxConcurrent" yaml:"maxConcurrent"` // 并发数上限
+	Interval      time.Duration `json:"interval" yaml:"interval"`           // 调整的间隔，不配置时为30秒
+	Window        time.Duration `json:"window" yaml:"window"`               // 样本的滑动窗口，不配置时为5个调整间隔
+	MinSamples    int           `json:"minSamples" yaml:"minSamples"`       // 调整需要的最少样本数，不配置时为20
+	Increase      int           `json:"increase" yaml:"increase"`           // 每次增加的并发数，不配置时为1
+	Backoff       float64       `json:"backoff" yaml:"backoff"`             // 降低时乘以的系数(0-1)，不配置时为0.7
+}
+
+// 后缀策略：截断后发送给模型的后缀
+const (
+	SuffixFull       = "full"          // 发送截断后的完整后缀
+	SuffixFirstLines = "first_k_lines" // 只发送后缀的前SuffixLines行
+	SuffixNone       = "none"          // 不发送后缀
+)
+
+/**
+ * 模型在某种语言上的提示词组装策略
+ * @description
+ * - SuffixPolicy: 后缀策略(full/first_k_lines/none)，为空表示full
+ * - SuffixLines: first_k_lines策略发送的后缀行数，必须大于0
+ * - 不发送的后缀占用的预算让给前缀和上下文
+ * @example
+ * languages:
+ *   python: { suffixPolicy: first_k_lines, suffixLines: 5 }
+ *   "*": { suffixPolicy: none }
+ */
+type ModelLanguageConfig struct {
+	SuffixPolicy string `json:"suffixPolicy" yaml:"suffixPolicy"`
+	SuffixLines  int    `json:"suffixLines" yaml:"suffixLines"`
+}
+
+// 校验后缀策略
+func (l ModelLanguageConfig) Validate() error {
+	switch l.SuffixPolicy {
+	case "", SuffixFull, SuffixNone:
+	case SuffixFirstLines:
+		if l.SuffixLines <= 0 {
+			return fmt.Errorf("suffixPolicy '%s' requires suffixLines > 0", l.SuffixPolicy)
+		}
+	default:
+		return fmt.Errorf("unknown suffixPolicy '%s'", l.SuffixPolicy)
+	}
+	return nil
+}
+
+// 认证信息引用外部来源的前缀
+const (
+	CredentialFile = "file:" // 从文件读取，去掉首尾空白
+	CredentialEnv  = "env:"  // 从环境变量读取
+)
+
+/**
+ * 认证信息的来源
+ * @returns {string, string} 返回来源(file:/env:，直接配置时为空)和引用的文件路径或变量名
+ * @example
+ * c := ModelConfig{Authorization: "file:/run/secrets/model-token"}
+ * kind, ref := c.CredentialSource()
+ * // kind = "file:", ref = "/run/secrets/model-token"
+ */
+func (c *ModelConfig) CredentialSource() (string, string) {
+	for _, kind := range []string{CredentialFile, CredentialEnv} {
+		if ref, ok := strings.CutPrefix(c.Authorization, kind); ok {
+			return kind, strings.TrimSpace(ref)
+		}
+	}
+	return "", c.Authorization
+}
+
+// 代码上下文和前缀之间的分隔符，不配置时为"\n"
+func (c *ModelConfig) GetContextSeparator() string {
+	if c.ContextSeparator == nil {
+		return "\n"
+	}
+	return *c.ContextSeparator
+}
+
+// 调用模型后端的认证方式
+const (
+	AuthModeServer       = "server"        // 使用配置的authorization
+	AuthModePassthrough  = "passthrough"   // 转发用户请求的Authorization头，用户没有提供时拒绝请求
+	AuthModeBothFallback = "both-fallback" // 优先使用用户的Authorization头，模型后端返回401时改用配置的authorization
+)
+
+/**
+ * 检查模型配置中各供应商必需的字段
+ * @returns {error} 缺少必需字段时返回错误
+ * @description
+ * - 所有供应商都需要completionsUrl
+ * - openai/deepseek/ollama需要modelName；llama.cpp服务端只加载一个模型，不需要
+ * - fimMode需要fimBegin/fimHole/fimEnd，llama.cpp的/infill接口由服务端组装FIM提示词，不需要
+ * - authMode只能是server/passthrough/both-fallback，转发用户的认证信息只支持openai兼容的供应商
+ * - 认证信息引用外部来源时需要给出文件路径或变量名，只支持openai兼容的供应商
+ * - 按语言配置的后缀策略只能是full/first_k_lines/none
+ * - 开启并发数自动调整时需要targetLatency，并发数上下限和降低系数需要有效
+ */
+func (c *ModelConfig) Validate() error {
+	if c.CompletionsUrl == "" {
+		return fmt.Errorf("model '%s' (provider '%s'): completionsUrl is required", c.ModelTitle, c.Provider)
+	}
+	if c.Provider != "llamacpp" && c.ModelName == "" {
+		return fmt.Errorf("model '%s' (provider '%s'): modelName is required", c.ModelTitle, c.Provider)
+	}
+	infill := c.Provider == "llamacpp" && strings.HasSuffix(strings.TrimRight(c.CompletionsUrl, "/"), "/infill")
+	if c.FimMode && !infill && (c.FimBegin == "" || c.FimHole == "" || c.FimEnd == "") {
+		return fmt.Errorf("model '%s' (provider '%s'): fimMode requires fimBegin, fimHole and fimEnd", c.ModelTitle, c.Provider)
+	}
+	switch c.AuthMode {
+	case "", AuthModeServer:
+	case AuthModePassthrough, AuthModeBothFallback:
+		if c.Provider == "ollama" || c.Provider == "llamacpp" {
+			return fmt.Errorf("model '%s' (provider '%s'): authMode '%s' requires an openai compatible provider", c.ModelTitle, c.Provider, c.AuthMode)
+		}
+	default:
+		return fmt.Errorf("model '%s' (provider '%s'): unknown authMode '%s'", c.ModelTitle, c.Provider, c.AuthMode)
+	}
+	if kind, ref := c.CredentialSource(); kind != "" {
+		if ref == "" {
+			return fmt.Errorf("model '%s' (provider '%s'): authorization '%s' requires a file path or variable name", c.ModelTitle, c.Provider, kind)
+		}
+		if c.Provider == "ollama" || c.Provider == "llamacpp" {
+			return fmt.Errorf("model '%s' (provider '%s'): authorization '%s' requires an openai compatible provider", c.ModelTitle, c.Provider, kind)
+		}
+	}
+	if t := c.Autotune; t.Enabled {
+		switch {
+		case t.TargetLatency <= 0:
+			return fmt.Errorf("model '%s' (provider '%s'): autotune requires targetLatency", c.ModelTitle, c.Provider)
+		case t.MinConcurrent < 0 || (t.MaxConcurrent > 0 && t.MaxConcurrent < max(t.MinConcurrent, 1)):
+			return fmt.Errorf("model '%s' (provider '%s'): autotune bounds [%d, %d] are invalid", c.ModelTitle, c.Provider, t.MinConcurrent, t.MaxConcurrent)
+		case t.Backoff < 0 || t.Backoff >= 1:
+			return fmt.Errorf("model '%s' (provider '%s'): autotune backoff %g must be in (0, 1)", c.ModelTitle, c.Provider, t.Backoff)
+		}
+	}
+	for language, l := range c.Languages {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("model '%s' (provider '%s'): language '%s': %v", c.ModelTitle, c.Provider, language, err)
+		}
+	}
+	return nil
+}
+
+// 逻辑模型的名称，即modelName；llama.cpp没有配置modelName时为"default"
+func (c *ModelConfig) LogicalName() string {
+	if c.ModelName == "" {
+		return "default"
+	}
+	return c.ModelName
+}
+
+// 同一个逻辑模型的副本之间可以不同的配置项(yaml字段名)
+var replicaFields = map[string]bool{
+	"modelTitle":        true,
+	"completionsUrl":    true,
+	"authorization":     true,
+	"authMode":          true,
+	"credentialRefresh": true,
+	"timeout":           true,
+	"maxConcurrent":     true,
+	"rateLimit":         true,
+	"rateBurst":         true,
+	"autotune":          true,
+}
+
+/**
+ * 检查同一个逻辑模型的各副本的配置
+ * @param {[]ModelConfig} models - 所有模型配置
+ * @returns {error} 副本的配置不一致或地址重复时返回错误
+ * @description
+ * - modelName相同的多个配置是同一个逻辑模型的多个副本(如部署在不同地址的实例)，
+ *   每个副本一个模型池，请求在各副本中选择最空闲的模型池；指标和按模型的统计按modelName汇总
+ * - 副本之间只能在地址、认证、超时、并发数、限流和并发数自动调整上不同，
+ *   决定提示词组装、补全处理和路由(标签)的配置必须一致
+ * - 同一个逻辑模型的副本不能使用相同的地址
+ * @example
+ * models:
+ *   - modelName: deepseek-coder
+ *     completionsUrl: http://10.0.0.1:8000/v1/completions
+ *   - modelName: deepseek-coder
+ *     completionsUrl: http://10.0.0.2:8000/v1/completions
+ */
+func ValidateReplicas(models []ModelConfig) error {
+	first := make(map[string]int)
+	urls := make(map[string]bool)
+	for i := range models {
+		c := &models[i]
+		name := c.LogicalName()
+		if urls[name+"\x00"+c.CompletionsUrl] {
+			return fmt.Errorf("model '%s': replicas share completionsUrl '%s'", name, c.CompletionsUrl)
+		}
+		urls[name+"\x00"+c.CompletionsUrl] = true
+		j, ok := first[name]
+		if !ok {
+			first[name] = i
+			continue
+		}
+		if field := replicaDiff(&models[j], c); field != "" {
+			return fmt.Errorf("model '%s': replicas models[%d] and models[%d] differ in '%s'", name, j, i, field)
+		}
+	}
+	return nil
+}
+
+// 两个副本第一个不允许不同却不一致的配置项，一致时返回空串
+func replicaDiff(a, b *ModelConfig) string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if replicaFields[name] {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			return name
+		}
+	}
+	return ""
 }
 
 /**
@@ -99,6 +351,9 @@ type DefinitionConfig struct {
  * - 包含定义查询、语义查询和关系链查询的配置
  * - 设置单个请求的超时时间
  * - 设置整个上下文获取过程的总超时时间
+ * - 设置检索结果的校验规则
+ * - Ignore: 检索片段所在文件的忽略规则(path.Match的通配符)，匹配文件路径或其任意结尾部分，如"*.pb.go"、"vendor/*"；
+ *   命中的片段不进入提示词，开启截断标记时在片段来源中记录命中的规则；无效的规则不生效并记录一次日志
  * - 用于控制代码补全时获取相关代码上下文的行为
  * @example
  * {
@@ -119,15 +374,76 @@ type DefinitionConfig struct {
  *     "includeContent": true
  *   },
  *   "requestTimeout": "5s",
- *   "totalTimeout": "15s"
+ *   "totalTimeout": "15s",
+ *   "validation": {
+ *     "maxItemBytes": 65536,
+ *     "maxInvalidFraction": 0.5
+ *   },
+ *   "ignore": ["*.pb.go", "vendor/*"]
  * }
  */
 type ContextConfig struct {
-	Definition     DefinitionConfig `json:"definition" yaml:"definition"`         // 定义查询配置
-	Semantic       SemanticConfig   `json:"semantic" yaml:"semantic"`             // 语义相关性查询配置
-	Relation       RelationConfig   `json:"relation" yaml:"relation"`             // 关系链查询配置
-	RequestTimeout time.Duration    `json:"requestTimeout" yaml:"requestTimeout"` // 单个请求超时时间
-	TotalTimeout   time.Duration    `json:"totalTimeout" yaml:"totalTimeout"`     // 上下文获取总超时时间
+	Definition     DefinitionConfig        `json:"definition" yaml:"definition"`         // 定义查询配置
+	Semantic       SemanticConfig          `json:"semantic" yaml:"semantic"`             // 语义相关性查询配置
+	Relation       RelationConfig          `json:"relation" yaml:"relation"`             // 关系链查询配置
+	RequestTimeout time.Duration           `json:"requestTimeout" yaml:"requestTimeout"` // 单个请求超时时间
+	TotalTimeout   time.Duration           `json:"totalTimeout" yaml:"totalTimeout"`     // 上下文获取总超时时间
+	Validation     ContextValidationConfig `json:"validation" yaml:"validation"`         // 检索结果校验配置
+	HealthTTL      time.Duration           `json:"healthTTL" yaml:"healthTTL"`           // 检索服务状态(响应的context_status)的缓存时间，默认1分钟
+	Sanitize       ContextSanitizeConfig   `json:"sanitize" yaml:"sanitize"`             // 检索上下文的提示词注入防护
+	Ignore         []string                `json:"ignore" yaml:"ignore"`                 // 检索片段所在文件的忽略规则
+}
+
+/**
+ * 检索上下文的提示词注入防护配置
+ * @description
+ * - 代码库索引中的文件任何人都可以提交，检索到的片段可能含有操纵模型的文字(如要求模型生成不安全代码的注释)
+ * - Patterns: 按顺序匹配每个检索片段，命中的文字替换为[filtered]，按名称计数；没有配置时使用DefaultSanitizePatterns
+ * - Disabled: 关闭按正则清洗，上下文的标注和比例上限仍然生效
+ * - MaxPromptFraction: 检索上下文最多占用前缀预算(前缀和上下文共用)的比例，超过时从开头截断；为0时默认0.5，为1时不限制
+ * - 检索上下文在提示词中总是用注释围栏标注开始和结束，模型可以区分它与用户的文件，围栏的token数计入前缀预算
+ * @example
+ * {
+ *   "disabled": false,
+ *   "maxPromptFraction": 0.5,
+ *   "patterns": [{"name": "ignore_previous", "pattern": "(?i)ignore\\s+(all\\s+)?previous\\s+instructions"}]
+ * }
+ */
+type ContextSanitizeConfig struct {
+	Disabled          bool              `json:"disabled" yaml:"disabled"`                   // 是否关闭按正则清洗
+	MaxPromptFraction float64           `json:"maxPromptFraction" yaml:"maxPromptFraction"` // 检索上下文占前缀预算的比例上限
+	Patterns          []SanitizePattern `json:"patterns" yaml:"patterns"`                   // 清洗的正则，按名称计数
+}
+
+// 清洗检索上下文的一条正则，Name用作日志和指标的pattern标签
+type SanitizePattern struct {
+	Name    string `json:"name" yaml:"name"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+}
+
+// 没有配置context.sanitize.patterns时使用的清洗正则
+var DefaultSanitizePatterns = []SanitizePattern{
+	{Name: "ignore_previous", Pattern: `(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+)?(the\s+)?(previous|prior|above|earlier|preceding)\s+(instructions?|prompts?|rules?|directions?)`},
+	{Name: "role_assignment", Pattern: `(?i)\byou\s+are\s+(now\s+)?(a|an|the)?\s*(ai|assistant|language\s+model|llm|chatbot|code\s+model|completion\s+model)\b`},
+	{Name: "role_block", Pattern: `(?im)^[\s/#*;>-]*#{1,6}\s*(system|assistant|user)(\s+prompt)?\s*:?\s*$|^[\s/#*;>-]*` + "```" + `(system|assistant)\b|<\|im_(start|end)\|>|\[/?INST\]|<</?SYS>>`},
+	{Name: "model_directive", Pattern: `(?i)\b(note|instruction|message)\s+(to|for)\s+(the\s+)?(ai|assistant|model|llm|copilot)\b`},
+}
+
+/**
+ * 检索结果校验配置
+ * @description
+ * - 检索服务的每条结果须有非空且不超过MaxItemBytes的内容，以及合理的文件路径，不合格的结果被丢弃并计数
+ * - 一个检索的结果中不合格的比例超过MaxInvalidFraction时，整个检索结果作废(通常是检索服务的响应格式变了)
+ * - MaxInvalidFraction为1时不作废；没有配置时分别默认为64KB和0.5
+ * @example
+ * {
+ *   "maxItemBytes": 65536,
+ *   "maxInvalidFraction": 0.5
+ * }
+ */
+type ContextValidationConfig struct {
+	MaxItemBytes       int     `json:"maxItemBytes" yaml:"maxItemBytes"`             // 单条结果内容的最大字节数
+	MaxInvalidFraction float64 `json:"maxInvalidFraction" yaml:"maxInvalidFraction"` // 不合格结果的最大比例，超过时作废整个检索结果
 }
 
 /**
@@ -144,8 +460,67 @@ type ContextConfig struct {
  * }
  */
 type ScoreFilterConfig struct {
-	Disabled  bool    `json:"disabled" yaml:"disabled"`   // 是否禁用隐藏分过滤
-	Threshold float64 `json:"threshold" yaml:"threshold"` // 接受补全的最低分数阈值
+	Disabled   bool                  `json:"disabled" yaml:"disabled"`     // 是否禁用隐藏分过滤
+	Threshold  float64               `json:"threshold" yaml:"threshold"`   // 接受补全的最低分数阈值
+	AutoTune   ScoreTuneConfig       `json:"autoTune" yaml:"autoTune"`     // 按语言自动调整阈值
+	Experiment ScoreExperimentConfig `json:"experiment" yaml:"experiment"` // 隐藏分权重的A/B实验
+}
+
+/**
+ * 隐藏分权重A/B实验配置
+ * @description
+ * - 每个变体是一套完整的隐藏分权重，File为权重文件(格式同hidden-scores.json)，为空时使用内置权重
+ * - 客户端按hash(Name, clientID)对各变体Traffic之和取模分桶，分组只由配置决定，重启后不变
+ * - 阈值仍使用threshold及自动调整的结果，各变体只有权重不同
+ * - 只配置一个变体时所有客户端使用该变体；不配置时使用hidden-scores.json，不做实验
+ * - 变体的名称记录在补全的Verbose中，并作为采纳比例和隐藏分指标的variant标签
+ * @example
+ * {
+ *   "name": "weights-2024q3",
+ *   "variants": [
+ *     {"name": "control", "traffic": 50},
+ *     {"name": "retrained", "traffic": 50, "file": "hidden-scores-retrained.json"}
+ *   ]
+ * }
+ */
+type ScoreExperimentConfig struct {
+	Name     string               `json:"name" yaml:"name"`         // 实验名称，参与分桶的哈希，更换名称即重新分组
+	Variants []ScoreVariantConfig `json:"variants" yaml:"variants"` // 参与实验的权重变体
+}
+
+// 隐藏分权重变体
+type ScoreVariantConfig struct {
+	Name    string `json:"name" yaml:"name"`       // 变体名称
+	Traffic int    `json:"traffic" yaml:"traffic"` // 流量份额
+	File    string `json:"file" yaml:"file"`       // 权重文件，为空时使用内置权重
+}
+
+/**
+ * 隐藏分阈值自动调整配置
+ * @description
+ * - 根据滑动窗口内各语言补全的采纳率，调整各语言的隐藏分阈值
+ * - 目标是让展示给用户的补全的采纳率不低于TargetPrecision
+ * - 每个调整周期阈值的变化幅度不超过MaxStep
+ * - Enabled为false时(默认)，所有语言使用全局阈值
+ * @example
+ * {
+ *   "enabled": true,
+ *   "interval": "5m",
+ *   "window": "1h",
+ *   "targetPrecision": 0.3,
+ *   "maxStep": 0.05,
+ *   "minSamples": 100
+ * }
+ */
+type ScoreTuneConfig struct {
+	Enabled         bool          `json:"enabled" yaml:"enabled"`                 // 是否启用自动调整(总开关)
+	Interval        time.Duration `json:"interval" yaml:"interval"`               // 调整周期
+	Window          time.Duration `json:"window" yaml:"window"`                   // 统计采纳率的滑动窗口
+	TargetPrecision float64       `json:"targetPrecision" yaml:"targetPrecision"` // 目标采纳率
+	MaxStep         float64       `json:"maxStep" yaml:"maxStep"`                 // 每周期阈值最大变化幅度
+	MinSamples      int           `json:"minSamples" yaml:"minSamples"`           // 每种语言参与调整的最少样本数
+	MinThreshold    float64       `json:"minThreshold" yaml:"minThreshold"`       // 阈值下限
+	MaxThreshold    float64       `json:"maxThreshold" yaml:"maxThreshold"`       // 阈值上限
 }
 
 /**
@@ -158,7 +533,6 @@ type ScoreFilterConfig struct {
  * @example
  * {
  *   "disabled": false,
- *   "threshold": 0.5,
  *   "strPattern": "import +.*|from +.*|from +.* import *.*",
  *   "treePattern": "\\(comment.*|\\(string.*|\\(set \\(string.*|\\(dictionary.*|\\(integer.*|\\(list.*|\\(tuple.*",
  *   "minPromptLine": 5,
@@ -178,16 +552,153 @@ type SyntaxFilterConfig struct {
  * @description
  * - 控制是否启用后期修剪功能
  * - 配置使用的修剪工具列表
+ * - 配置客户端可以通过prune_mode请求的修剪模式(full/light/off)，不在列表中的模式按full处理
  * - 用于对补全结果进行后处理，提高质量
  * @example
  * {
  *   "disabled": false,
- *   "pruners": ["deduplication", "formatting", "validation"]
+ *   "pruners": ["deduplication", "formatting", "validation"],
+ *   "allowedModes": ["full", "light"]
  * }
  */
 type PruneConfig struct {
-	Disabled bool     `json:"disabled" yaml:"disabled"` // 是否禁用后期修剪
-	Pruners  []string `json:"pruners" yaml:"pruners"`   // 自定义的后期修剪工具列表
+	Disabled          bool                 `json:"disabled" yaml:"disabled"`                   // 是否禁用后期修剪
+	Pruners           []string             `json:"pruners" yaml:"pruners"`                     // 自定义的后期修剪工具列表
+	AllowedModes      []string             `json:"allowedModes" yaml:"allowedModes"`           // 允许客户端请求的修剪模式
+	IndentLanguages   []string             `json:"indentLanguages" yaml:"indentLanguages"`     // 缩进有语义的语言，比较行时保留行首缩进，为空时使用各语言配置的indentSignificant
+	SyntaxParseBudget int                  `json:"syntaxParseBudget" yaml:"syntaxParseBudget"` // 裁剪语法错误时最多分析的次数，超出时不裁剪
+	Recovery          SyntaxRecoveryConfig `json:"recovery" yaml:"recovery"`                   // 语法错误丢弃前恢复开头的有效区域
+	Retry             PruneRetryConfig     `json:"retry" yaml:"retry"`                         // 补全被整体丢弃后的重试配置
+	PythonTextRules   []string             `json:"pythonTextRules" yaml:"pythonTextRules"`     // 判断非python语言的补全为python代码的特征文本，为空时取环境变量PYTHON_TEXT_RULES(逗号分隔)
+	FirstLineIndent   bool                 `json:"firstLineIndent" yaml:"firstLineIndent"`     // 是否开启cut-first_line_indent，默认关闭，关闭时该处理器不修改补全
+	Params            PruneParams          `json:"params" yaml:"params"`                       // 重复和重叠处理器的阈值
+}
+
+/**
+ * 语法错误丢弃器的区域恢复
+ * @description
+ * - 补全整体语法检查失败时，按行二分查找开头最长的语法正确的区域，保留该区域而不是整体丢弃
+ * - 查找最多分析syntaxParseBudget次，请求取消时停止，找不到时仍整体丢弃
+ * - 少于MinLines行的补全不恢复，末尾的错误由cut-syntax_error处理
+ * - 为0时使用默认值(4行)
+ * @example
+ * {
+ *   "enabled": true,
+ *   "minLines": 4
+ * }
+ */
+type SyntaxRecoveryConfig struct {
+	Enabled  bool `json:"enabled" yaml:"enabled"`   // 是否开启区域恢复
+	MinLines int  `json:"minLines" yaml:"minLines"` // 恢复要求的最少行数
+}
+
+/**
+ * 重复和重叠处理器的阈值，可以用code-completion optimize在录制的补全上搜索
+ * @description
+ * - RepetitionMinLines/RepetitionRatio: cut-repetitive_text只处理不少于该行数的补全，重复部分的比例达到该值时裁剪
+ * - ExtremeMinLines/ExtremeCount/ExtremeSimilarity: discard-extreme_repetition只检测不少于该行数的补全，
+ *   相邻两行的公共子串不短于行长的ExtremeSimilarity时视为近似重复，重复超过ExtremeCount次(或超过行数的一半)时丢弃
+ * - OverlapCutLine: cut-prefix_overlap和cut-suffix_overlap比较的行数
+ * - IgnoreOverlapLen: 与后缀重叠的内容不超过该长度时不裁剪，避免截掉有效的结束符
+ * - 为0时使用默认值
+ * @example
+ * {
+ *   "repetitionMinLines": 3,
+ *   "repetitionRatio": 0.15,
+ *   "extremeMinLines": 5,
+ *   "extremeCount": 8,
+ *   "extremeSimilarity": 0.5,
+ *   "overlapCutLine": 3,
+ *   "ignoreOverlapLen": 8
+ * }
+ */
+type PruneParams struct {
+	RepetitionMinLines int     `json:"repetitionMinLines" yaml:"repetitionMinLines"` // 重复文本裁剪要求的最少行数
+	RepetitionRatio    float64 `json:"repetitionRatio" yaml:"repetitionRatio"`       // 重复部分占补全的比例
+	ExtremeMinLines    int     `json:"extremeMinLines" yaml:"extremeMinLines"`       // 极端重复检测要求的最少非空行数
+	ExtremeCount       int     `json:"extremeCount" yaml:"extremeCount"`             // 极端重复的次数
+	ExtremeSimilarity  float64 `json:"extremeSimilarity" yaml:"extremeSimilarity"`   // 近似重复的两行公共子串占行长的比例
+	OverlapCutLine     int     `json:"overlapCutLine" yaml:"overlapCutLine"`         // 比较重叠的行数
+	IgnoreOverlapLen   int     `json:"ignoreOverlapLen" yaml:"ignoreOverlapLen"`     // 不裁剪的后缀重叠长度
+}
+
+/**
+ * 补全被后置处理整体丢弃后的重试配置
+ * @description
+ * - 后置处理丢弃了整个补全时，调高温度后用已构建好的提示词再调用一次模型
+ * - 最多重试一次，且补全请求剩余时间不少于MinRemaining
+ * - 默认只对手动触发(MANUAL)的请求重试，AllowAuto为true时自动触发也重试
+ * @example
+ * {
+ *   "enabled": true,
+ *   "allowAuto": false,
+ *   "minRemaining": "800ms",
+ *   "temperatureStep": 0.3
+ * }
+ */
+type PruneRetryConfig struct {
+	Enabled         bool          `json:"enabled" yaml:"enabled"`                 // 是否启用重试
+	AllowAuto       bool          `json:"allowAuto" yaml:"allowAuto"`             // 自动触发的请求是否也重试
+	MinRemaining    time.Duration `json:"minRemaining" yaml:"minRemaining"`       // 重试要求的最少剩余时间
+	TemperatureStep float64       `json:"temperatureStep" yaml:"temperatureStep"` // 重试时温度的增量
+}
+
+/**
+ * 超大辅助字段缩减配置
+ * @description
+ * - import_content超过MaxImportBytes时，只保留引用了光标附近标识符的导入语句
+ * - 客户端提供的code_context超过MaxContextBytes时，只保留排名靠前的片段
+ * - 光标附近指光标前后各NearCursorLines行
+ * - 上限为负数时不缩减该字段
+ * @example
+ * {
+ *   "maxImportBytes": 65536,
+ *   "maxContextBytes": 65536,
+ *   "nearCursorLines": 20
+ * }
+ */
+type ReduceConfig struct {
+	MaxImportBytes  int `json:"maxImportBytes" yaml:"maxImportBytes"`   // import_content的字节上限
+	MaxContextBytes int `json:"maxContextBytes" yaml:"maxContextBytes"` // 客户端code_context的字节上限
+	NearCursorLines int `json:"nearCursorLines" yaml:"nearCursorLines"` // 光标前后参与匹配的行数
+}
+
+/**
+ * 括号和引号扫描的上限，防止超长或嵌套过深的提示词拖慢请求
+ * @description
+ * - 判断光标是否在括号、字符串中时只扫描前缀末尾MaxPrefixBytes和后缀开头MaxSuffixBytes字节，
+ *   前缀从扫描范围内的第一个完整行开始
+ * - 未闭合的括号超过MaxDepth层时提前结束扫描，返回保守的结果(不在括号、字符串中，括号不完整)
+ * - 为0时使用默认值
+ * @example
+ * {
+ *   "maxPrefixBytes": 16384,
+ *   "maxSuffixBytes": 16384,
+ *   "maxDepth": 256
+ * }
+ */
+type ScanConfig struct {
+	MaxPrefixBytes int `json:"maxPrefixBytes" yaml:"maxPrefixBytes"` // 扫描前缀末尾的字节数
+	MaxSuffixBytes int `json:"maxSuffixBytes" yaml:"maxSuffixBytes"` // 扫描后缀开头的字节数
+	MaxDepth       int `json:"maxDepth" yaml:"maxDepth"`             // 未闭合括号的最大层数
+}
+
+/**
+ * 极小请求的快速路径
+ * @description
+ * - 极小请求：单行补全(请求的停用词含"\n")、不获取代码库上下文(请求关闭或缺少获取所需的字段，且没有自带上下文)、
+ *   不在单文件组件中、前后缀合计不超过MaxPromptBytes字节(为0时为4096，约1k token)
+ * - 快速路径不调用上下文客户端，后置处理跳过只对多行补全起作用的处理器和语法检查，请求verbose时才生成调试信息
+ * - 耗时另计入completion_duration_milliseconds的fast_total和fast_overhead阶段
+ * @example
+ * {
+ *   "enabled": true,
+ *   "maxPromptBytes": 4096
+ * }
+ */
+type FastPathConfig struct {
+	Enabled        bool `json:"enabled" yaml:"enabled"`               // 开启极小请求的快速路径
+	MaxPromptBytes int  `json:"maxPromptBytes" yaml:"maxPromptBytes"` // 前后缀合计的字节上限
 }
 
 /**
@@ -206,7 +717,6 @@ type PruneConfig struct {
  *   },
  *   "syntax": {
  *     "disabled": false,
- *     "threshold": 0.5,
  *     "strPattern": "import +.*|from +.*|from +.* import *.*",
  *     "treePattern": "\\(comment.*|\\(string.*|\\(set \\(string.*|\\(dictionary.*|\\(integer.*|\\(list.*|\\(tuple.*",
  *     "minPromptLine": 5,
@@ -214,7 +724,10 @@ type PruneConfig struct {
  *   },
  *   "prune": {
  *     "disabled": false,
- *     "pruners": ["deduplication", "formatting", "validation"]
+ *     "pruners": ["deduplication", "formatting", "validation"],
+ *     "retry": {
+ *       "enabled": false
+ *     }
  *   },
  *   "tokenizer": {
  *     "path": "/path/to/tokenizer"
@@ -222,57 +735,1333 @@ type PruneConfig struct {
  * }
  */
 type WrapperConfig struct {
-	Score  ScoreFilterConfig  `json:"score" yaml:"score"`   // 隐藏分过滤器配置
-	Syntax SyntaxFilterConfig `json:"syntax" yaml:"syntax"` // 语法过滤器配置
-	Prune  PruneConfig        `json:"prune" yaml:"prune"`   // 后期修剪配置
+	Score       ScoreFilterConfig           `json:"score" yaml:"score"`             // 隐藏分过滤器配置
+	Syntax      SyntaxFilterConfig          `json:"syntax" yaml:"syntax"`           // 语法过滤器配置
+	Prune       PruneConfig                 `json:"prune" yaml:"prune"`             // 后期修剪配置
+	Reduce      ReduceConfig                `json:"reduce" yaml:"reduce"`           // 超大辅助字段缩减配置
+	Shape       ShapeConfig                 `json:"shape" yaml:"shape"`             // 微补全请求整形配置
+	Generated   GeneratedFilterConfig       `json:"generated" yaml:"generated"`     // 生成/压缩文件过滤器配置
+	Closer      CloserConfig                `json:"closer" yaml:"closer"`           // 本地补全闭合符号配置
+	Confidence  ConfidenceConfig            `json:"confidence" yaml:"confidence"`   // 补全置信度的信号权重
+	Blank       BlankPromptConfig           `json:"blank" yaml:"blank"`             // 空白提示词的处理
+	Style       StyleConfig                 `json:"style" yaml:"style"`             // 按客户端学习代码风格的配置
+	TokenCache  TokenCacheConfig            `json:"tokenCache" yaml:"tokenCache"`   // 前缀的增量分词缓存配置
+	Calibration TokenCalibrationConfig      `json:"calibration" yaml:"calibration"` // 本地token数与上游token数的校准配置
+	Fingerprint FingerprintConfig           `json:"fingerprint" yaml:"fingerprint"` // 用于跨系统关联日志的提示词指纹
+	TestFile    TestFileConfig              `json:"testFile" yaml:"testFile"`       // 测试文件的补全配置
+	Empty       EmptyResultConfig           `json:"empty" yaml:"empty"`             // 空补全结果的重试建议和负结果缓存
+	Acceptance  AcceptanceConfig            `json:"acceptance" yaml:"acceptance"`   // 部分采纳的统计配置
+	Literal     LiteralConfig               `json:"literal" yaml:"literal"`         // 光标在字符串中时的补全配置
+	Arguments   ArgumentsConfig             `json:"arguments" yaml:"arguments"`     // 光标在调用的参数列表中时的补全配置
+	Scan        ScanConfig                  `json:"scan" yaml:"scan"`               // 括号和引号扫描的上限
+	FastPath    FastPathConfig              `json:"fastPath" yaml:"fastPath"`       // 极小请求的快速路径
+	Imports     ImportsConfig               `json:"imports" yaml:"imports"`         // 补全引用了未导入的包时建议的导入语句
+	Progressive ProgressiveConfig           `json:"progressive" yaml:"progressive"` // 渐进式代码上下文：先不等待上下文补全，之后附近位置的请求使用后台获取的上下文
+	Languages   map[string]LanguageOverride `json:"languages" yaml:"languages"`     // 各语言的配置，按字段覆盖内置的语言配置
+	Fallback    LanguageFallbackConfig      `json:"fallback" yaml:"fallback"`       // 没有语言配置的语言回退到的语言族
 }
 
-type StreamControllerConfig struct {
-	MaintainInterval  time.Duration `json:"maintainInterval" yaml:"maintainInterval"`   // 定时维护的间隔
-	CleanOlderThan    time.Duration `json:"cleanOlderThan" yaml:"cleanOlderThan"`       // 清理过期客户端的最大间隔
-	CompletionTimeout time.Duration `json:"completionTimeout" yaml:"completionTimeout"` // 一个补全请求的最大超时
-	QueueTimeout      time.Duration `json:"queueTimeout" yaml:"queueTimeout"`           // 排队超时
+/**
+ * 一种语言的配置覆盖，为空的字段使用内置的语言配置
+ * @description
+ * - key为语言标识，可以是内置语言的别名；不是内置语言时新增该语言
+ * - 启动时合并到内置的语言配置并校验，校验失败时不启动
+ * - Aliases追加到内置的别名，ShapeRules非空时替换内置的微补全规则(规则的languages字段不生效)
+ * @example
+ * {
+ *   "starlark": {
+ *     "aliases": ["bzl", "bazel"],
+ *     "lineComment": "#",
+ *     "indentSignificant": true,
+ *     "allowPythonText": true
+ *   }
+ * }
+ */
+type LanguageOverride struct {
+	Aliases            []string          `json:"aliases" yaml:"aliases"`                       // 语言标识的别名
+	LineComment        *string           `json:"lineComment" yaml:"lineComment"`               // 行注释的起始符号
+	LineCommentEnd     *string           `json:"lineCommentEnd" yaml:"lineCommentEnd"`         // 行注释的结束符号(如html的-->)
+	BlockComment       []string          `json:"blockComment" yaml:"blockComment"`             // 块注释的起止符号，只有块注释的语言(如css)使用
+	IndentSignificant  *bool             `json:"indentSignificant" yaml:"indentSignificant"`   // 缩进是否有语义
+	AllowPythonText    *bool             `json:"allowPythonText" yaml:"allowPythonText"`       // 补全像python代码时是否保留
+	FrontEnd           *bool             `json:"frontEnd" yaml:"frontEnd"`                     // 是否为补全中可能混入css的前端语言
+	Terminator         *string           `json:"terminator" yaml:"terminator"`                 // 语句结束符
+	OptionalTerminator *bool             `json:"optionalTerminator" yaml:"optionalTerminator"` // 语句结束符是否可省略
+	Quotes             *string           `json:"quotes" yaml:"quotes"`                         // 字符串的引号
+	QuoteStyle         *bool             `json:"quoteStyle" yaml:"quoteStyle"`                 // 单引号和双引号字符串是否等价
+	ShapeRules         []ShapeRule       `json:"shapeRules" yaml:"shapeRules"`                 // 微补全识别规则
+	TestPatterns       []string          `json:"testPatterns" yaml:"testPatterns"`             // 测试文件的路径模式
+	Family             *string           `json:"family" yaml:"family"`                         // 回退的语言族，语言没有隐藏分权重和语法支持时设置
+	LineWidth          *string           `json:"lineWidth" yaml:"lineWidth"`                   // 补全行超过插件的最大渲染宽度时的处理(wrap/truncate/ignore)
+	Punctuation        []PunctuationRule `json:"punctuation" yaml:"punctuation"`               // 补全末尾分号/逗号的规范化规则，非空时替换内置的规则
 }
 
-type SoftwareConfig struct {
-	Models           []ModelConfig          `json:"models" yaml:"models"`                     // AI模型配置列表
-	Context          ContextConfig          `json:"context" yaml:"context"`                   // 上下文获取配置
-	Wrapper          WrapperConfig          `json:"wrapper" yaml:"wrapper"`                   // 补全前后处理配置
-	StreamController StreamControllerConfig `json:"streamController" yaml:"streamController"` // 全局流控配置
+/**
+ * 补全末尾标点的规范化规则
+ * @description
+ * - Mark: 标点，";"或","
+ * - Position: 补全末尾所在的语法位置，statement为行尾的语句结束处，element为闭合括号之前的最后一个元素，为空表示任意位置
+ * - Action: strip去掉末尾的标点，require缺少时追加，keep保持原样
+ * - 按顺序先匹配的规则生效
+ * @example
+ * {"mark": ",", "position": "element", "action": "strip"}
+ */
+type PunctuationRule struct {
+	Mark     string `json:"mark" yaml:"mark"`
+	Position string `json:"position" yaml:"position"`
+	Action   string `json:"action" yaml:"action"`
 }
 
-var Config = &SoftwareConfig{}
-var Context *ContextConfig = &Config.Context
-var Wrapper *WrapperConfig = &Config.Wrapper
+/**
+ * 没有语言配置的语言的回退
+ * @description
+ * - 插件上报的语言没有语言配置(如terraform、proto)，或语言配置指定了family时，按语言族使用一组一致的简化功能：
+ *   运行哪些后置处理器、追加哪些停用词、是否做括号匹配检查、隐藏分使用哪种语言的权重
+ * - 语言族: c-like、indent-based、markup、plain，定义见completions.languageFamilies
+ * - 先按文件扩展名判断(Extensions覆盖内置的扩展名映射)，再按前后缀的内容判断，都判断不出时使用Default
+ * - Default为空时使用plain；Disabled为true时不回退，各处理沿用没有语言配置时的默认行为
+ * - 回退的决定记录在Verbose和指标completion_language_fallbacks_total中
+ * @example
+ * {
+ *   "disabled": false,
+ *   "default": "plain",
+ *   "extensions": {".tf": "c-like", ".nim": "indent-based"}
+ * }
+ */
+type LanguageFallbackConfig struct {
+	Disabled   bool              `json:"disabled" yaml:"disabled"`     // 是否关闭语言回退
+	Default    string            `json:"default" yaml:"default"`       // 判断不出语言族时使用的语言族
+	Extensions map[string]string `json:"extensions" yaml:"extensions"` // 文件扩展名(含.)到语言族的映射
+}
 
-func resetDefValues(c *SoftwareConfig) {
-	if c.StreamController.QueueTimeout == 0 {
-		c.StreamController.QueueTimeout = 200 * time.Millisecond
-	}
-	if c.StreamController.CompletionTimeout == 0 {
-		c.StreamController.CompletionTimeout = 2500 * time.Millisecond
-	}
-	if c.StreamController.CleanOlderThan == 0 {
-		c.StreamController.CleanOlderThan = 1 * time.Hour
-	}
+/**
+ * 测试文件的补全配置
+ * @description
+ * - 按各语言的测试文件路径模式(wrapper.languages.<语言>.testPatterns，有内置的默认值)识别测试文件
+ * - 测试文件样板代码多，补全使用test档案：
+ *   最大输出token数乘以MaxOutputScale，后缀为空时不在空行处停止(BlankLineStop为false时)，
+ *   隐藏分阈值按"<语言>/test"单独自动调整，基准阈值加上ThresholdOffset(负数为放宽)
+ * - 未关闭SourceContext时，按命名约定推断被测的源文件，检索其中的定义作为优先的上下文
+ * @example
+ * {
+ *   "disabled": false,
+ *   "maxOutputScale": 1.5,
+ *   "blankLineStop": false,
+ *   "thresholdOffset": -0.1,
+ *   "disableSourceContext": false
+ * }
+ */
+type TestFileConfig struct {
+	Disabled             bool     `json:"disabled" yaml:"disabled"`                         // 是否关闭测试文件识别
+	MaxOutputScale       float64  `json:"maxOutputScale" yaml:"maxOutputScale"`             // 最大输出token数的倍数
+	BlankLineStop        bool     `json:"blankLineStop" yaml:"blankLineStop"`               // 后缀为空时是否仍在空行处停止
+	ThresholdOffset      *float64 `json:"thresholdOffset" yaml:"thresholdOffset"`           // 隐藏分基准阈值的调整，未配置时为-0.1
+	DisableSourceContext bool     `json:"disableSourceContext" yaml:"disableSourceContext"` // 是否不检索被测源文件的定义
 }
 
-func init() {
-	// 读取配置文件
-	configFile, err := os.ReadFile("config.yaml")
-	if err != nil {
-		fmt.Printf("读取配置文件失败: %v\n", err)
-		return
-	}
-	configFileStr := strings.ReplaceAll(string(configFile), "\r\n", "\n")
+/**
+ * 部分采纳的统计配置
+ * @description
+ * - 插件可以在下一个请求的hide_scores中告知上一次补全被采纳的字符数和行数(部分采纳)，
+ *   按previous_completion_id指定的补全(不提供时为返回给该客户端的上一次补全)计算采纳比例，超过补全长度的视为无效并忽略
+ * - 采纳比例不小于PartialThreshold时，隐藏分的previous_label视为1，否则为0；
+ *   只有previous_label的请求保持原有的含义
+ * - 最多记录MaxClients个等待反馈的补全和MaxClients个客户端最近一次返回的补全，超过TTL未收到反馈的被清除
+ * - PartialThreshold为0时使用默认值0.5，MaxClients为0时使用默认值10000，TTL为0时使用默认值10m
+ * @example
+ * {
+ *   "disabled": false,
+ *   "partialThreshold": 0.5,
+ *   "maxClients": 10000,
+ *   "ttl": "10m"
+ * }
+ */
+type AcceptanceConfig struct {
+	Disabled         bool          `json:"disabled" yaml:"disabled"`                 // 是否关闭部分采纳的统计
+	PartialThreshold float64       `json:"partialThreshold" yaml:"partialThreshold"` // 视为采纳的最小采纳比例
+	MaxClients       int           `json:"maxClients" yaml:"maxClients"`             // 最多记录的等待反馈的补全数和客户端数
+	TTL              time.Duration `json:"ttl" yaml:"ttl"`                           // 返回的补全等待反馈的时长
+}
 
-	// 解析 YAML 配置
-	err = yaml.Unmarshal([]byte(configFileStr), Config)
-	if err != nil {
-		fmt.Printf("解析配置文件失败: %v\n", err)
-		panic(err)
-	}
+/**
+ * 光标在字符串字面量中时的补全配置
+ * @description
+ * - 光标在字符串(含go原始字符串、python三引号字符串和f-string、js模板字符串)中时，
+ *   追加闭合引号和换行作为停用词，补全停留在字符串内，并跳过无法解析字符串片段的语法错误处理器
+ * - 光标在模板字符串${}或f-string{}的插值中时，按表达式补全，以"}"为停用词
+ * @example
+ * {
+ *   "disabled": false
+ * }
+ */
+type LiteralConfig struct {
+	Disabled bool `json:"disabled" yaml:"disabled"` // 是否关闭字符串内的补全约束
+}
+
+/**
+ * 光标在调用(或结构体字面量)的参数列表中时的补全配置
+ * @description
+ * - 截断后缀时保留到参数列表的闭合括号为止，放不下时不带后缀
+ * - 补全在结束参数列表的闭合括号、(光标后紧接着","时)当前参数之后的","，以及第一个与已有参数同名的参数之前裁剪，
+ *   见cut-duplicate_argument
+ * @example
+ * {
+ *   "disabled": false
+ * }
+ */
+type ArgumentsConfig struct {
+	Disabled bool `json:"disabled" yaml:"disabled"` // 是否关闭参数列表的识别和裁剪
+}
+
+/**
+ * 补全引用了未导入的包时，在响应的suggested_imports中建议导入语句，不修改补全内容
+ * @description
+ * - 只识别已知的名称：内置各语言常用的标准库，Symbols按语言(key为语言标识)补充或覆盖，值为空时移除内置的名称
+ * - 值的含义按语言：go为导入路径；python为名称的完整路径(如json、os.path、collections.defaultdict)；
+ *   js/ts为模块名，名称与模块名相同时按命名空间导入，否则按具名导入
+ * - 请求的disable_imports为true时跳过
+ * @example
+ * {
+ *   "disabled": false,
+ *   "symbols": {"python": {"np": "numpy"}, "go": {"yaml": "gopkg.in/yaml.v3"}}
+ * }
+ */
+type ImportsConfig struct {
+	Disabled bool                         `json:"disabled" yaml:"disabled"` // 是否关闭导入建议
+	Symbols  map[string]map[string]string `json:"symbols" yaml:"symbols"`   // 各语言补充的名称和导入的模块
+}
+
+/**
+ * 渐进式代码上下文
+ * @description
+ * - 开启后，光标区域的第一次请求不等待代码上下文，在后台获取，获取的结果缓存后，之后附近位置的请求直接使用
+ * - 光标区域: 同一客户端、同一文件内，光标所在行按RegionLines行分组，同一区域同时只有一个后台获取
+ * - 附近: 与缓存的上下文获取时的光标行相距不超过NearbyLines行
+ * - FetchTimeout: 后台获取的总超时，代替context.totalTimeout，后台获取不占用补全的时延
+ * - 缓存最多MaxEntries条，保留TTL
+ * - RegionLines为0时使用默认值40，NearbyLines为0时使用默认值20，FetchTimeout为0时使用默认值2s，
+ *   MaxEntries为0时使用默认值10000，TTL为0时使用默认值5m
+ * @example
+ * {
+ *   "enabled": true,
+ *   "regionLines": 40,
+ *   "nearbyLines": 20,
+ *   "fetchTimeout": "2s",
+ *   "maxEntries": 10000,
+ *   "ttl": "5m"
+ * }
+ */
+type ProgressiveConfig struct {
+	Enabled      bool          `json:"enabled" yaml:"enabled"`           // 是否开启渐进式代码上下文
+	RegionLines  int           `json:"regionLines" yaml:"regionLines"`   // 光标区域的行数
+	NearbyLines  int           `json:"nearbyLines" yaml:"nearbyLines"`   // 使用缓存的上下文时，与其光标行的最大距离
+	FetchTimeout time.Duration `json:"fetchTimeout" yaml:"fetchTimeout"` // 后台获取上下文的总超时
+	MaxEntries   int           `json:"maxEntries" yaml:"maxEntries"`     // 缓存的上下文的最大条数
+	TTL          time.Duration `json:"ttl" yaml:"ttl"`                   // 缓存的上下文的有效期
+}
+
+/**
+ * 空补全结果的处理
+ * @description
+ * - 补全为空或被过滤器拒绝时，按原因在响应中给出retry_advice(no_retry/retry_after_edit/retry_with_manual)，
+ *   Advice按原因覆盖内置的建议，原因见completions.EmptyReason*
+ * - 模型调用后结果为空的，按(客户端, 项目, 文件, 文件哈希, 光标行)记录MemoTTL，期间相同的自动触发请求直接返回空补全，不调用模型
+ * - MemoTTL为0时使用默认值10s，MemoMaxEntries为0时使用默认值10000
+ * - 请求提供了document_version或file_hash时，缓存的结果还需文件哈希相同，或文档版本相差不超过VersionDrift才命中，
+ *   否则视为过期(如修改了光标上方的代码)；文件保存通知(/api/files/changed)清除客户端缓存的结果
+ * @example
+ * {
+ *   "disabled": false,
+ *   "memoTTL": "10s",
+ *   "memoMaxEntries": 10000,
+ *   "advice": {"syntax_error": "retry_with_manual"}
+ * }
+ */
+type EmptyResultConfig struct {
+	Disabled       bool              `json:"disabled" yaml:"disabled"`             // 是否关闭重试建议和负结果缓存
+	MemoTTL        time.Duration     `json:"memoTTL" yaml:"memoTTL"`               // 负结果缓存的有效期
+	MemoMaxEntries int               `json:"memoMaxEntries" yaml:"memoMaxEntries"` // 负结果缓存的最大条数
+	VersionDrift   int64             `json:"versionDrift" yaml:"versionDrift"`     // 文档版本相差不超过该值时缓存的结果仍有效，0表示版本必须相同
+	Advice         map[string]string `json:"advice" yaml:"advice"`                 // 按原因覆盖的重试建议
+}
+
+/**
+ * 空白提示词的处理
+ * @description
+ * - 前缀和后缀中的非空白字符数少于MinChars时为空白提示词(如刚新建的文件)，不调用模型，直接返回空补全
+ * - 手动触发(MANUAL)且请求携带的代码上下文和导入内容中的非空白字符数不少于MinContextChars时，只用上下文补全
+ * - 为0时使用默认值1，即只处理全是空白的提示词
+ * @example
+ * {
+ *   "minChars": 1,
+ *   "minContextChars": 1
+ * }
+ */
+type BlankPromptConfig struct {
+	MinChars        int `json:"minChars" yaml:"minChars"`               // 前缀和后缀的最少非空白字符数
+	MinContextChars int `json:"minContextChars" yaml:"minContextChars"` // 手动触发只用上下文补全时，上下文的最少非空白字符数
+}
+
+/**
+ * 按客户端学习代码风格(缩进、引号、大括号位置)的配置
+ * @description
+ * - 每个请求从前缀和后缀中统计本文件的风格，按语言累加到该客户端的风格档案，历史计数每次按Decay衰减
+ * - 某个风格的占比不低于Confidence且累计权重不低于MinSamples时才采用，混合风格的文件不会形成偏好
+ * - 本文件的风格明确时优先于客户端档案
+ * - 采用的风格用于缩进和引号规范化修剪器，并在verbose中返回
+ * - 最多保存MaxClients个客户端的档案，超过TTL未更新的档案被清除
+ * @example
+ * {
+ *   "disabled": false,
+ *   "maxClients": 10000,
+ *   "ttl": "24h",
+ *   "decay": 0.9,
+ *   "minSamples": 3,
+ *   "confidence": 0.7
+ * }
+ */
+type StyleConfig struct {
+	Disabled   bool          `json:"disabled" yaml:"disabled"`     // 是否关闭风格学习
+	MaxClients int           `json:"maxClients" yaml:"maxClients"` // 最多保存的客户端档案数
+	TTL        time.Duration `json:"ttl" yaml:"ttl"`               // 档案的过期时间
+	Decay      float64       `json:"decay" yaml:"decay"`           // 每次观察时历史计数的衰减系数
+	MinSamples float64       `json:"minSamples" yaml:"minSamples"` // 采用风格要求的最少累计权重
+	Confidence float64       `json:"confidence" yaml:"confidence"` // 采用风格要求的最低占比
+}
+
+/**
+ * 前缀的增量分词缓存配置
+ * @description
+ * - 按(客户端, 文件)缓存前缀各分块的token数，同一文件的后续请求只对变化的分块分词
+ * - 文件第一次出现时完整分词，并在后台建立缓存；分块的token数之和与完整分词不一致时，该文件不使用缓存
+ * - 每VerifyEvery次使用缓存时完整分词一次核对，不一致时丢弃缓存
+ * - 最多缓存MaxFiles个文件，超过TTL未使用的缓存被清除；模型或tokenizer变化时重建
+ * @example
+ * {
+ *   "disabled": false,
+ *   "maxFiles": 2000,
+ *   "ttl": "10m",
+ *   "verifyEvery": 100
+ * }
+ */
+type TokenCacheConfig struct {
+	Disabled    bool          `json:"disabled" yaml:"disabled"`       // 是否关闭增量分词缓存
+	MaxFiles    int           `json:"maxFiles" yaml:"maxFiles"`       // 最多缓存的文件数
+	TTL         time.Duration `json:"ttl" yaml:"ttl"`                 // 缓存的过期时间
+	VerifyEvery int           `json:"verifyEvery" yaml:"verifyEvery"` // 每多少次使用缓存时完整分词核对一次
+}
+
+/**
+ * 本地token数与上游token数的校准配置
+ * @description
+ * - 按模型统计上游报告的提示词token数与本地计算的token数之比的滑动平均，作为截断提示词时预算的安全系数
+ * - 样本数不足MinSamples时系数为1.0；系数限制在[MinFactor, MaxFactor]内
+ * - 上游报告提示词超过上下文长度时，系数立即乘以Tighten，截止时间允许时按新的预算截断后重试一次
+ * @example
+ * {
+ *   "disabled": false,
+ *   "minSamples": 20,
+ *   "window": 200,
+ *   "minFactor": 0.8,
+ *   "maxFactor": 1.5,
+ *   "tighten": 1.1
+ * }
+ */
+type TokenCalibrationConfig struct {
+	Disabled   bool    `json:"disabled" yaml:"disabled"`     // 是否关闭校准，关闭时系数固定为1.0
+	MinSamples int     `json:"minSamples" yaml:"minSamples"` // 采用学习到的系数要求的最少样本数
+	Window     int     `json:"window" yaml:"window"`         // 滑动平均的窗口(样本数)，新样本的权重为2/(window+1)
+	MinFactor  float64 `json:"minFactor" yaml:"minFactor"`   // 系数的下限，为0时不限制
+	MaxFactor  float64 `json:"maxFactor" yaml:"maxFactor"`   // 系数的上限，为0时不限制
+	Tighten    float64 `json:"tighten" yaml:"tighten"`       // 上下文超长时系数乘以的倍数，不大于1时为1.1
+}
+
+/**
+ * 提示词指纹配置
+ * @description
+ * - 指纹是加盐的截断哈希，由规范化的前缀末尾几行、后缀开头几行和文件路径计算，同一编辑状态的指纹相同
+ * - 指纹随代码库检索请求发送(请求头X-Prompt-Fingerprint)，记录在日志、错误日志和指标的exemplar中，并在响应中返回
+ * - Salt为空时每次启动随机生成，指纹在重启后和各实例间不同；各部署应配置不同的盐，使指纹不能跨部署比对
+ * - Salt不出现在启动时打印的配置中
+ * - 关闭时不计算指纹，代码的任何派生值都不会离开请求
+ * @example
+ * {
+ *   "disabled": false,
+ *   "salt": "change-me",
+ *   "prefixLines": 3,
+ *   "suffixLines": 2,
+ *   "length": 16
+ * }
+ */
+type FingerprintConfig struct {
+	Disabled    bool   `json:"disabled" yaml:"disabled"`       // 是否关闭提示词指纹
+	Salt        string `json:"-" yaml:"salt"`                  // 计算指纹的盐，不随配置打印
+	PrefixLines int    `json:"prefixLines" yaml:"prefixLines"` // 参与计算的前缀末尾的行数(规范化后的非空行)
+	SuffixLines int    `json:"suffixLines" yaml:"suffixLines"` // 参与计算的后缀开头的行数(规范化后的非空行)
+	Length      int    `json:"length" yaml:"length"`           // 指纹的长度(十六进制字符数)，最多64
+}
+
+/**
+ * 本地补全闭合符号配置
+ * @description
+ * - 自动触发(AUTO)时，光标在一行基本完整的语句末尾，只缺右括号/引号的，在预处理阶段直接补全闭合符号，
+ *   必要时追加语言的语句结束符，不调用模型，返回的模型名为local-closer
+ * - 默认关闭
+ * @example
+ * {
+ *   "enabled": true
+ * }
+ */
+type CloserConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"` // 是否启用本地补全闭合符号
+}
+
+/**
+ * 补全置信度的信号权重
+ * @description
+ * - 置信度是各信号(取值0-1)的加权平均，请求中没有的信号不参与计算
+ * - 置信度是请求之间比较的相对值，不是校准过的概率，插件据此淡化显示低置信度的补全
+ * - 权重全为0时使用默认权重
+ * @example
+ * {
+ *   "hideScore": 0.35,
+ *   "pruners": 0.2,
+ *   "finishReason": 0.2,
+ *   "length": 0.1,
+ *   "syntaxTrim": 0.15
+ * }
+ */
+type ConfidenceConfig struct {
+	HideScore    float64 `json:"hideScore" yaml:"hideScore"`       // 隐藏分，只在自动触发且计算了隐藏分时存在
+	Pruners      float64 `json:"pruners" yaml:"pruners"`           // 后置处理器命中数越多越低
+	FinishReason float64 `json:"finishReason" yaml:"finishReason"` // 模型主动结束为1，达到最大输出为0
+	Length       float64 `json:"length" yaml:"length"`             // 补全token数占最大输出的比例越高越低
+	SyntaxTrim   float64 `json:"syntaxTrim" yaml:"syntaxTrim"`     // 语法错误裁剪生效时为0
+}
+
+/**
+ * 生成/压缩文件过滤器配置
+ * @description
+ * - 光标所在的文件是生成的代码(如protoc生成的.pb.go)或压缩过的代码(如bundle.min.js)时，补全没有意义且耗时
+ * - 判断依据：文件名匹配FilePatterns；前缀开头匹配HeaderPatterns；
+ *   前缀不少于MinSampleBytes时，平均行长超过MaxAvgLineLength，或空白字符比例低于MinWhitespaceRatio
+ * - Action为flag(默认)时只在verbose和日志中标记，照常补全；为reject时拒绝补全(GENERATED_FILE)；
+ *   为degrade时继续补全，但不获取代码上下文、前后缀只保留光标附近DegradeBytes字节，并且只补全一行
+ * - 平均行长和空白比例按unicode字符计算
+ * @example
+ * {
+ *   "disabled": false,
+ *   "action": "reject",
+ *   "maxAvgLineLength": 300,
+ *   "minWhitespaceRatio": 0.05,
+ *   "minSampleBytes": 1024,
+ *   "degradeBytes": 1024,
+ *   "headerPatterns": ["(?i)code generated .* do not edit"],
+ *   "filePatterns": [".min.js", "_pb.go"]
+ * }
+ */
+type GeneratedFilterConfig struct {
+	Disabled           bool     `json:"disabled" yaml:"disabled"`                     // 是否禁用生成文件检测
+	Action             string   `json:"action" yaml:"action"`                         // 检测到生成文件时的处理方式(flag/reject/degrade)
+	MaxAvgLineLength   int      `json:"maxAvgLineLength" yaml:"maxAvgLineLength"`     // 平均行长上限
+	MinWhitespaceRatio float64  `json:"minWhitespaceRatio" yaml:"minWhitespaceRatio"` // 空白字符比例下限
+	MinSampleBytes     int      `json:"minSampleBytes" yaml:"minSampleBytes"`         // 按行长和空白比例判断所需的最少前缀字节数
+	DegradeBytes       int      `json:"degradeBytes" yaml:"degradeBytes"`             // degrade时前后缀各保留的字节数
+	HeaderPatterns     []string `json:"headerPatterns" yaml:"headerPatterns"`         // 文件头的正则，匹配前缀开头的内容
+	FilePatterns       []string `json:"filePatterns" yaml:"filePatterns"`             // 文件名后缀
+}
+
+/**
+ * 微补全请求整形配置
+ * @description
+ * - 光标行前缀(及光标行后缀)匹配某个规则时，请求按微补全处理：
+ *   identifier只补全光标处的标识符，import只补全导入路径
+ * - 微补全强制单行，加入更激进的停用词，输出token数不超过MaxTokens，且不做语义检索
+ * - Rules为空时使用内置的默认规则(go/python/typescript/javascript/c/cpp)
+ * @example
+ * {
+ *   "disabled": false,
+ *   "maxTokens": 16,
+ *   "rules": [{"shape": "import", "languages": ["go"], "linePrefix": "^import\\s+\"[^\"]*$"}]
+ * }
+ */
+type ShapeConfig struct {
+	Disabled  bool        `json:"disabled" yaml:"disabled"`   // 是否禁用请求整形
+	MaxTokens int         `json:"maxTokens" yaml:"maxTokens"` // 微补全的最大输出token数
+	Rules     []ShapeRule `json:"rules" yaml:"rules"`         // 识别规则，为空时使用默认规则
+}
+
+// 微补全的识别规则
+type ShapeRule struct {
+	Shape      string   `json:"shape" yaml:"shape"`           // 微补全类型(identifier/import)
+	Languages  []string `json:"languages" yaml:"languages"`   // 适用的语言，为空表示所有语言
+	LinePrefix string   `json:"linePrefix" yaml:"linePrefix"` // 光标行前缀需匹配的正则
+	LineSuffix string   `json:"lineSuffix" yaml:"lineSuffix"` // 光标行后缀需匹配的正则，为空表示不限制
+}
+
+type StreamControllerConfig struct {
+	MaintainInterval   time.Duration        `json:"maintainInterval" yaml:"maintainInterval"`     // 定时维护的间隔
+	CleanOlderThan     time.Duration        `json:"cleanOlderThan" yaml:"cleanOlderThan"`         // 清理过期客户端的最大间隔
+	CompletionTimeout  time.Duration        `json:"completionTimeout" yaml:"completionTimeout"`   // 一个补全请求的最大超时
+	QueueTimeout       time.Duration        `json:"queueTimeout" yaml:"queueTimeout"`             // 排队超时
+	SmallPromptTokens  int                  `json:"smallPromptTokens" yaml:"smallPromptTokens"`   // 估算token数不超过该值的请求为小请求
+	LargePromptTokens  int                  `json:"largePromptTokens" yaml:"largePromptTokens"`   // 估算token数不低于该值的请求为大请求
+	SmallReservedRatio float64              `json:"smallReservedRatio" yaml:"smallReservedRatio"` // 每个模型池预留给小请求的并发槽位比例
+	PriorityAging      time.Duration        `json:"priorityAging" yaml:"priorityAging"`           // 排队超过该时长的请求不再让位给小请求
+	MaxPoolConcurrent  int                  `json:"maxPoolConcurrent" yaml:"maxPoolConcurrent"`   // 运行时调整模型池并发数的上限
+	DedupWindow        time.Duration        `json:"dedupWindow" yaml:"dedupWindow"`               // 相同completion_id的请求在该时长内重放已有结果，不再重复处理
+	ErrorJournalSize   int                  `json:"errorJournalSize" yaml:"errorJournalSize"`     // 错误日志保留的最近失败补全数
+	Anomaly            AnomalyConfig        `json:"anomaly" yaml:"anomaly"`                       // 补全质量异常检测和安全模式
+	Diagnostics        DiagnosticsConfig    `json:"diagnostics" yaml:"diagnostics"`               // 崩溃诊断快照
+	Streams            StreamsConfig        `json:"streams" yaml:"streams"`                       // SSE流式补全的连接管理
+	Samples            SamplesConfig        `json:"samples" yaml:"samples"`                       // 用于离线质量评审的补全样本
+	Warmup             WarmupConfig         `json:"warmup" yaml:"warmup"`                         // 启动后的预热
+	Fairness           FairnessConfig       `json:"fairness" yaml:"fairness"`                     // 模型池饱和时租户和客户端之间的公平调度
+	Probe              ProbeConfig          `json:"probe" yaml:"probe"`                           // 定时自测探针
+	Usage              UsageConfig          `json:"usage" yaml:"usage"`                           // 按租户和客户端累计的token用量
+	Tail               TailConfig           `json:"tail" yaml:"tail"`                             // 实时跟踪单个客户端的补全活动
+	StrictDeadline     StrictDeadlineConfig `json:"strictDeadline" yaml:"strictDeadline"`         // 请求指定strict_deadline_ms时的严格截止时间模式
+}
+
+/**
+ * 检查流控配置，加载配置文件并补齐默认值后调用
+ * @returns {error} 小请求的上限不小于大请求的下限，或跟踪流的时长不大于0时返回错误
+ * @description
+ * - smallPromptTokens必须小于largePromptTokens，否则同一个请求既是小请求又是大请求，分级调度失效
+ * - tail的heartbeat和maxDuration必须大于0(未配置时已补齐默认值)，否则打开跟踪流时创建定时器失败
+ */
+func (c *StreamControllerConfig) Validate() error {
+	if c.SmallPromptTokens >= c.LargePromptTokens {
+		return fmt.Errorf("streamController: smallPromptTokens %d must be less than largePromptTokens %d",
+			c.SmallPromptTokens, c.LargePromptTokens)
+	}
+	if c.Tail.Heartbeat <= 0 || c.Tail.MaxDuration <= 0 {
+		return fmt.Errorf("streamController.tail: heartbeat %s and maxDuration %s must be positive",
+			c.Tail.Heartbeat, c.Tail.MaxDuration)
+	}
+	return nil
+}
+
+/**
+ * 严格截止时间模式，请求指定strict_deadline_ms时保证在截止时间内返回
+ * @description
+ * - 从服务解析完请求起计时，截止时间减去SafetyMargin时看门狗触发：取消模型调用、跳过剩余的后置处理器和verbose的组装，
+ *   立即返回此刻已得到的最好结果(可能为空)，响应的deadline字段说明被打断的阶段和跳过的阶段
+ * - SafetyMargin留给序列化和写出响应，为0时默认50ms
+ * - Disabled时忽略请求的strict_deadline_ms，按普通请求处理
+ * @example
+ * strictDeadline:
+ *   disabled: false
+ *   safetyMargin: 50ms
+ */
+type StrictDeadlineConfig struct {
+	Disabled     bool          `json:"disabled" yaml:"disabled"`         // 是否忽略请求的strict_deadline_ms
+	SafetyMargin time.Duration `json:"safetyMargin" yaml:"safetyMargin"` // 看门狗在截止时间之前多久触发
+}
+
+/**
+ * 模型池饱和时租户和客户端之间的公平调度
+ * @description
+ * - 租户按请求的凭据(Authorization: Bearer <token>)确定，见TenantConfig.Tokens；凭据不属于任何租户时归入default租户
+ * - 不信任客户端自己填写的请求头
+ * - 同一规模分级的等待请求中，各租户按权重加权公平调度，租户内的客户端轮流调度
+ * - 没有等待请求的租户不占用份额，其份额由其它租户按权重分享
+ * - 权重未配置或不大于0时使用DefaultWeight，DefaultWeight不大于0时为1
+ * - 未启用时所有请求都属于default租户，按规模和到达顺序调度
+ * @example
+ * fairness:
+ *   enabled: true
+ *   defaultWeight: 1
+ *   tenants:
+ *     team-a:
+ *       weight: 2
+ *       tokens: [team-a-key]
+ *     team-b:
+ *       weight: 1
+ *       tokens: [team-b-key]
+ */
+type FairnessConfig struct {
+	Enabled       bool                    `json:"enabled" yaml:"enabled"`             // 是否启用公平调度
+	DefaultWeight float64                 `json:"defaultWeight" yaml:"defaultWeight"` // 未配置权重的租户(含default)的权重
+	Tenants       map[string]TenantConfig `json:"tenants" yaml:"tenants"`             // 各租户的配置
+}
+
+// 租户的配置
+type TenantConfig struct {
+	Weight float64  `json:"weight" yaml:"weight"` // 租户的调度权重，饱和时租户分到的调度份额与权重成正比
+	Tokens []string `json:"-" yaml:"tokens"`      // 属于该租户的访问令牌，请求的Authorization头为Bearer <token>时归入该租户，不随配置打印
+}
+
+/**
+ * 启动后的预热，缓存、分词器和代码上下文缓存都是冷的，先降低容量再逐步恢复
+ * @description
+ * - 预热期间模型池的最大并发数按配置值的比例降低，从StartFactor线性增加到1
+ * - Duration后，或成功完成Successes个补全后(0表示不按补全数)结束预热，恢复配置的并发数
+ * - 预热期间AUTO触发的补全隐藏分阈值提高ThresholdBoost，MANUAL触发不受影响
+ * - 每隔Step调整一次并发数；健康检查仍返回ok，/api/stats的warmup中可以看到预热状态
+ * @example
+ * warmup:
+ *   enabled: true
+ *   duration: 60s
+ *   successes: 500
+ *   startFactor: 0.5
+ *   thresholdBoost: 0.05
+ *   step: 5s
+ */
+type WarmupConfig struct {
+	Enabled        bool          `json:"enabled" yaml:"enabled"`               // 是否启用预热
+	Duration       time.Duration `json:"duration" yaml:"duration"`             // 预热的时长
+	Successes      int           `json:"successes" yaml:"successes"`           // 成功完成该数量的补全后提前结束
+	StartFactor    float64       `json:"startFactor" yaml:"startFactor"`       // 开始时并发数占配置值的比例(0-1]
+	ThresholdBoost float64       `json:"thresholdBoost" yaml:"thresholdBoost"` // 预热期间AUTO触发的隐藏分阈值提高的幅度
+	Step           time.Duration `json:"step" yaml:"step"`                     // 调整并发数的间隔
+}
+
+/**
+ * 定时自测探针: 定时向自己的每个模型发送一个内置的补全请求，跟踪端到端延迟的漂移
+ * @description
+ * - 探针走完整的预处理、模型调用和后置处理，按最低优先级(batch)调度
+ * - 探针请求不读写负结果缓存、采纳反馈和风格档案，不计入补全请求的耗时和状态指标、错误日志、样本、异常检测和预热
+ * - 模型池饱和(没有空闲的并发槽位或已有排队请求)时跳过该模型
+ * - 结果计入completion_probes_total和completion_probe_duration_milliseconds，每个模型最近History次结果在/api/stats的probes中查看
+ * @example
+ * probe:
+ *   enabled: true
+ *   interval: 60s
+ *   history: 20
+ */
+type ProbeConfig struct {
+	Enabled  bool          `json:"enabled" yaml:"enabled"`   // 是否启用探针
+	Interval time.Duration `json:"interval" yaml:"interval"` // 探测的间隔
+	History  int           `json:"history" yaml:"history"`   // 每个模型保留的最近探测结果数
+}
+
+/**
+ * 按租户和客户端累计的token用量，用于按业务单元分摊费用
+ * @description
+ * - 与响应中的usage同一来源：提示词token为上游报告的用量(失败时为估算值)，补全token为计费用量(多候选时为合计生成的用量)
+ * - 按接收请求时的服务时区(环境变量TZ)的日期归入每天，跨午夜完成的请求计入接收时的日期
+ * - 每FlushInterval写入Path，启动时从Path恢复，保留RetentionDays天；Path为空时只在内存中累计，重启后丢失
+ * - 租户按请求的凭据确定(见fairness.tenants.tokens)，凭据不属于任何租户时为default
+ * - 每天最多MaxTenants个租户，每个租户最多MaxClients个客户端，超出的计入"_other"
+ * - 通过/api/usage?tenant=X&from=2025-01-01&to=2025-01-31查询每天的合计
+ * - 自测探针和重放的请求不计入
+ * @example
+ * usage:
+ *   enabled: true
+ *   path: /data/usage.json
+ *   flushInterval: 60s
+ *   retentionDays: 400
+ */
+type UsageConfig struct {
+	Enabled       bool          `json:"enabled" yaml:"enabled"`             // 是否累计用量
+	Path          string        `json:"path" yaml:"path"`                   // 持久化的文件路径
+	FlushInterval time.Duration `json:"flushInterval" yaml:"flushInterval"` // 写入文件的间隔
+	RetentionDays int           `json:"retentionDays" yaml:"retentionDays"` // 保留的天数
+	MaxTenants    int           `json:"maxTenants" yaml:"maxTenants"`       // 每天最多记录的租户数
+	MaxClients    int           `json:"maxClients" yaml:"maxClients"`       // 每天每个租户最多记录的客户端数
+}
+
+/**
+ * 实时跟踪单个客户端的补全活动，用于远程协助时观察用户的请求
+ * @description
+ * - 通过/api/clients/:client/tail打开SSE流，输出该客户端请求各阶段的事件(收到、拒绝、排队、调用模型、后置处理、返回)
+ * - 每个客户端同时只能有一个跟踪流
+ * - 流最长持续MaxDuration，没有事件时每Heartbeat发送心跳
+ * - 事件默认不含代码，AllowText开启且请求带include_text=true时才附带补全内容
+ * - 每个流最多缓冲Buffer个事件，读取跟不上时丢弃新的事件
+ * @example
+ * tail:
+ *   maxDuration: 30m
+ *   heartbeat: 15s
+ *   buffer: 256
+ *   allowText: false
+ */
+type TailConfig struct {
+	MaxDuration time.Duration `json:"maxDuration" yaml:"maxDuration"` // 一个跟踪流的最长持续时间
+	Heartbeat   time.Duration `json:"heartbeat" yaml:"heartbeat"`     // 心跳间隔
+	Buffer      int           `json:"buffer" yaml:"buffer"`           // 每个流缓冲的事件数
+	AllowText   bool          `json:"allowText" yaml:"allowText"`     // 是否允许事件附带补全内容
+}
+
+/**
+ * 用于离线质量评审的补全样本
+ * @description
+ * - 只采样Clients中同意采样的客户端，白名单为空时不采样
+ * - Rate: 默认采样率(0-1)，如0.001即0.1%；ModelRates按模型覆盖
+ * - 补全返回后按completion_id的哈希决定是否采样，同一completion_id的结果总是相同，可以复现
+ * - LanguageCap: 每种语言在保留期内最多保留的样本数，LanguageCaps按语言覆盖，0表示不限制
+ * - 样本单独保存，最多MaxEntries条，保留Retention，与错误日志互不影响
+ * - 保存了样本的补全可以通过/api/debug/replay/{completion_id}重放，没有保存样本的补全无法重放
+ * @example
+ * samples:
+ *   enabled: true
+ *   rate: 0.001
+ *   modelRates:
+ *     deepseek-coder: 0.01
+ *   languageCap: 200
+ *   languageCaps:
+ *     python: 500
+ *   clients: ["client-a"]
+ *   maxEntries: 2000
+ *   retention: 72h
+ */
+type SamplesConfig struct {
+	Enabled      bool               `json:"enabled" yaml:"enabled"`           // 是否采样
+	Rate         float64            `json:"rate" yaml:"rate"`                 // 默认采样率
+	ModelRates   map[string]float64 `json:"modelRates" yaml:"modelRates"`     // 各模型的采样率
+	LanguageCap  int                `json:"languageCap" yaml:"languageCap"`   // 每种语言保留的样本数上限
+	LanguageCaps map[string]int     `json:"languageCaps" yaml:"languageCaps"` // 各语言保留的样本数上限
+	Clients      []string           `json:"clients" yaml:"clients"`           // 同意采样的客户端ID
+	MaxEntries   int                `json:"maxEntries" yaml:"maxEntries"`     // 保留的样本总数
+	Retention    time.Duration      `json:"retention" yaml:"retention"`       // 样本的保留时长
+}
+
+// 模型的采样率
+func (c *SamplesConfig) RateOf(model string) float64 {
+	if rate, ok := c.ModelRates[model]; ok {
+		return rate
+	}
+	return c.Rate
+}
+
+// 语言保留的样本数上限，0表示不限制
+func (c *SamplesConfig) CapOf(language string) int {
+	if limit, ok := c.LanguageCaps[language]; ok {
+		return limit
+	}
+	return c.LanguageCap
+}
+
+/**
+ * SSE流式补全的连接管理
+ * @description
+ * - MaxPerClient: 每个客户端同时打开的流数上限，超过时最早打开的流以superseded事件结束
+ * - Heartbeat: 没有数据时发送SSE注释心跳的间隔，避免代理因连接空闲断开(如60秒无数据)
+ * - MaxDuration: 一个流的最长持续时间，与补全超时无关，到达后以expired事件结束
+ * @example
+ * streams:
+ *   maxPerClient: 2
+ *   heartbeat: 15s
+ *   maxDuration: 120s
+ */
+type StreamsConfig struct {
+	MaxPerClient int           `json:"maxPerClient" yaml:"maxPerClient"` // 每个客户端同时打开的流数上限
+	Heartbeat    time.Duration `json:"heartbeat" yaml:"heartbeat"`       // 心跳间隔
+	MaxDuration  time.Duration `json:"maxDuration" yaml:"maxDuration"`   // 一个流的最长持续时间
+}
+
+/**
+ * 崩溃诊断快照的配置
+ * @description
+ * - 收到SIGQUIT/SIGABRT或panic到达顶层时，同步输出一份JSON快照到stderr(不写文件)
+ * - 快照包含流控统计、各模型池正在处理的请求摘要(不含代码)、内存存储大小、协程数和内存统计
+ * - MaxBytes: 快照的最大字节数，超过时依次省略请求摘要和其它明细，为0时使用默认值
+ * @example
+ * diagnostics:
+ *   disabled: false
+ *   maxBytes: 65536
+ */
+type DiagnosticsConfig struct {
+	Disabled bool `json:"disabled" yaml:"disabled"` // 是否关闭崩溃时的诊断快照(管理接口不受影响)
+	MaxBytes int  `json:"maxBytes" yaml:"maxBytes"` // 快照的最大字节数
+}
+
+// 安全模式的动作
+const (
+	SafeActionRaiseThreshold = "raise-threshold" // 提高该模型的隐藏分阈值
+	SafeActionFallback       = "fallback"        // 将请求切换到后备模型(模型名称或标签)
+	SafeActionRejectAuto     = "reject-auto"     // 拒绝该模型自动触发的请求
+)
+
+/**
+ * 补全质量异常检测配置
+ * @description
+ * - 在定时维护协程中每隔Window按模型池统计该窗口内各质量信号的比率：空结果、后置处理整体丢弃、语法错误丢弃、模型错误
+ * - 某信号的比率超过其基线的RateMultiple倍且不低于MinRate时，该模型池进入安全模式，同一模型的其他副本不受影响
+ * - 基线是正常窗口内比率的指数滑动平均，异常和安全模式期间不更新基线
+ * - 连续RecoverWindows个窗口所有信号都不超过基线的RecoverMultiple倍(或低于MinRate)时退出安全模式
+ * - 请求数少于MinSamples的窗口不参与判断，也不计入恢复
+ * - fallback和reject-auto时仍有ProbeRatio比例的请求发给该模型池，用于观察是否恢复，此时最少请求数按ProbeRatio等比降低
+ * @example
+ * anomaly:
+ *   enabled: true
+ *   action: fallback
+ *   fallbackModel: stable
+ */
+type AnomalyConfig struct {
+	Enabled         bool          `json:"enabled" yaml:"enabled"`                 // 是否启用异常检测
+	Window          time.Duration `json:"window" yaml:"window"`                   // 统计质量信号的窗口
+	Action          string        `json:"action" yaml:"action"`                   // 安全模式的动作(raise-threshold/fallback/reject-auto)
+	MinSamples      int           `json:"minSamples" yaml:"minSamples"`           // 一个窗口内参与判断的最少请求数
+	RateMultiple    float64       `json:"rateMultiple" yaml:"rateMultiple"`       // 比率超过基线的该倍数时进入安全模式
+	RecoverMultiple float64       `json:"recoverMultiple" yaml:"recoverMultiple"` // 比率不超过基线的该倍数时视为恢复，应小于rateMultiple
+	MinRate         float64       `json:"minRate" yaml:"minRate"`                 // 低于该比率不视为异常，避免基线接近0时误报
+	BaselineWeight  float64       `json:"baselineWeight" yaml:"baselineWeight"`   // 每个正常的窗口并入基线的权重(0-1)
+	RecoverWindows  int           `json:"recoverWindows" yaml:"recoverWindows"`   // 连续多少个恢复的窗口后退出安全模式
+	ThresholdBoost  float64       `json:"thresholdBoost" yaml:"thresholdBoost"`   // raise-threshold时隐藏分阈值提高的幅度
+	FallbackModel   string        `json:"fallbackModel" yaml:"fallbackModel"`     // fallback时切换到的模型名称或标签
+	ProbeRatio      float64       `json:"probeRatio" yaml:"probeRatio"`           // fallback和reject-auto时仍发给该模型的请求比例
+}
+
+/**
+ * 检查异常检测配置
+ * @returns {error} 动作未知，或fallback缺少后备模型时返回错误
+ */
+func (c *AnomalyConfig) Validate() error {
+	switch c.Action {
+	case SafeActionRaiseThreshold, SafeActionRejectAuto:
+	case SafeActionFallback:
+		if c.FallbackModel == "" {
+			return fmt.Errorf("anomaly: action '%s' requires fallbackModel", c.Action)
+		}
+	default:
+		return fmt.Errorf("anomaly: unknown action '%s'", c.Action)
+	}
+	if c.RecoverMultiple > c.RateMultiple {
+		return fmt.Errorf("anomaly: recoverMultiple %.2f must not exceed rateMultiple %.2f", c.RecoverMultiple, c.RateMultiple)
+	}
+	return nil
+}
+
+/**
+ * 补全请求体的解析限制
+ * @description
+ * - MaxDepth: 请求体JSON的最大嵌套深度
+ * - MaxExtraKeys: extra字段的最多键数
+ * - MaxExtraValueBytes: extra字段中每个值序列化后的最大字节数
+ * - DisallowUnknownFields: 请求体中有未知字段时拒绝请求，用于发现发送无用字段的客户端，默认关闭以兼容旧插件
+ * - MaxBodyBytes: 请求体的最大字节数，压缩的请求体按压缩后的大小计算
+ * - MaxDecompressedBytes: 压缩的请求体(Content-Encoding为gzip/deflate)解压后的最大字节数，防止解压炸弹
+ * - 为0的限制使用默认值
+ * @example
+ * {
+ *   "maxDepth": 32,
+ *   "maxExtraKeys": 64,
+ *   "maxExtraValueBytes": 16384,
+ *   "disallowUnknownFields": false,
+ *   "maxBodyBytes": 4194304,
+ *   "maxDecompressedBytes": 8388608
+ * }
+ */
+type BindingConfig struct {
+	MaxDepth              int  `json:"maxDepth" yaml:"maxDepth"`                           // 最大嵌套深度
+	MaxExtraKeys          int  `json:"maxExtraKeys" yaml:"maxExtraKeys"`                   // extra的最多键数
+	MaxExtraValueBytes    int  `json:"maxExtraValueBytes" yaml:"maxExtraValueBytes"`       // extra中每个值的最大字节数
+	DisallowUnknownFields bool `json:"disallowUnknownFields" yaml:"disallowUnknownFields"` // 是否拒绝未知字段
+	MaxBodyBytes          int  `json:"maxBodyBytes" yaml:"maxBodyBytes"`                   // 请求体的最大字节数
+	MaxDecompressedBytes  int  `json:"maxDecompressedBytes" yaml:"maxDecompressedBytes"`   // 解压后的最大字节数
+}
+
+/**
+ * 插件配置预检接口(POST /api/preflight)的配置
+ * @description
+ * - Rate/Burst: 每个客户端地址每秒可发起的预检次数和突发上限，预检会调用模型和各检索服务
+ * - Timeout: 每项检查(模型调用、每个检索服务)的超时
+ * - 为0的项使用默认值
+ * @example
+ * {
+ *   "disabled": false,
+ *   "rate": 0.2,
+ *   "burst": 3,
+ *   "timeout": "5s"
+ * }
+ */
+type PreflightConfig struct {
+	Disabled bool          `json:"disabled" yaml:"disabled"` // 是否关闭预检接口
+	Rate     float64       `json:"rate" yaml:"rate"`         // 每个客户端地址每秒的预检次数
+	Burst    int           `json:"burst" yaml:"burst"`       // 每个客户端地址的突发上限
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"`   // 每项检查的超时
+}
+
+/**
+ * 监控指标配置
+ * @description
+ * - 部分指标已改为规范的名称(见/api/metrics-mapping)，弃用期内新旧名称同时输出
+ * - LegacyDisabled: 停止输出旧名称的指标，看板迁移到新名称后打开
+ * @example
+ * {
+ *   "legacyDisabled": false
+ * }
+ */
+type MetricsConfig struct {
+	LegacyDisabled bool `json:"legacyDisabled" yaml:"legacyDisabled"` // 是否停止输出旧名称的指标
+}
+
+/**
+ * 管理和调试接口的服务端超时
+ * @description
+ * - 超时后返回503，处理函数之后的写入被丢弃，按路由计入completion_route_timeouts_total
+ * - Admin: 统计、明细、阈值、模型池、日志级别等管理接口
+ * - Debug: 错误日志、补全样本、诊断快照等需要管理令牌的查询接口，数据量较大
+ * - 补全和预检接口自己控制截止时间，不受这里的超时影响
+ * - 为0时使用默认值，Disabled为true时不限制
+ * @example
+ * {
+ *   "disabled": false,
+ *   "admin": "5s",
+ *   "debug": "30s"
+ * }
+ */
+type TimeoutsConfig struct {
+	Disabled bool          `json:"disabled" yaml:"disabled"` // 是否关闭接口超时
+	Admin    time.Duration `json:"admin" yaml:"admin"`       // 管理接口的超时
+	Debug    time.Duration `json:"debug" yaml:"debug"`       // 调试查询接口的超时
+}
+
+/**
+ * 旧版Python服务响应格式的兼容模式
+ * @description
+ * - 请求带format=legacy参数时，按旧版服务的字段名、状态取值和HTTP状态码返回响应，供仍解析旧格式的内部工具迁移使用
+ * - 兼容模式已弃用，响应头带Deprecation和Sunset(停用日期)，用量见指标completion_legacy_responses_total
+ * - Sunset为停用日期(YYYY-MM-DD)，Disabled为true时忽略format参数，按标准格式返回
+ * @example
+ * {
+ *   "disabled": false,
+ *   "sunset": "2027-06-30"
+ * }
+ */
+// 兼容模式默认的停用日期
+const DefaultLegacySunset = "2027-06-30"
+
+type LegacyFormatConfig struct {
+	Disabled bool   `json:"disabled" yaml:"disabled"` // 是否关闭兼容模式
+	Sunset   string `json:"sunset" yaml:"sunset"`     // 兼容模式的停用日期
+}
+
+/**
+ * 功能开关的来源
+ * @description
+ * - Provider: static按本地配置(如wrapper.fastPath.enabled)开关，对所有客户端相同；http定时拉取Url的JSON文档，支持按比例灰度和按客户端、租户定向
+ * - Url: http的开关文档地址，带ETag时用If-None-Match请求，未变化时不重新解析
+ * - Interval: 拉取间隔，Timeout: 每次拉取的超时
+ * - 拉取失败或文档无效时保持上一次的状态，还没有拉取成功时按本地配置；文档中没有的开关也按本地配置
+ * - 为0的项使用默认值，Provider为空时为static
+ * @example
+ * {
+ *   "provider": "http",
+ *   "url": "http://flags.example.com/code-completion.json",
+ *   "interval": "30s",
+ *   "timeout": "5s"
+ * }
+ */
+type FeatureFlagsConfig struct {
+	Provider string        `json:"provider" yaml:"provider"` // 开关来源(static/http)
+	Url      string        `json:"url" yaml:"url"`           // http的开关文档地址
+	Interval time.Duration `json:"interval" yaml:"interval"` // 拉取间隔
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"`   // 每次拉取的超时
+}
+
+// 功能开关的来源
+const (
+	FeatureFlagsStatic = "static" // 本地配置
+	FeatureFlagsHTTP   = "http"   // 定时拉取的开关文档
+)
+
+/**
+ * 隐私模式
+ * @description
+ * - Enabled: 开启后所有长期存在的存储(风格档案、负结果缓存、去重、错误日志、样本、渐进式上下文等)
+ *   只保存哈希、长度和结构化的元数据，源码及由源码得到的文本不会在请求结束后留在内存中
+ * - 依赖保存文本的功能相应失效：去重窗口内的重复请求重新处理，渐进式上下文的缓存不命中，
+ *   样本只保存摘要且不能重放
+ * - 启动时确定，默认关闭
+ * @example
+ * {
+ *   "enabled": true
+ * }
+ */
+type PrivacyConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"` // 是否开启隐私模式
+}
+
+// 管理接口配置
+type AdminConfig struct {
+	Token string `json:"-" yaml:"token"` // 管理接口的认证令牌(Authorization: Bearer <token>)，为空时管理接口不可用
+}
+
+type SoftwareConfig struct {
+	ConfigVersion    int                    `json:"configVersion" yaml:"configVersion"`       // 配置文件结构版本
+	Models           []ModelConfig          `json:"models" yaml:"models"`                     // AI模型配置列表
+	Context          ContextConfig          `json:"context" yaml:"context"`                   // 上下文获取配置
+	Wrapper          WrapperConfig          `json:"wrapper" yaml:"wrapper"`                   // 补全前后处理配置
+	StreamController StreamControllerConfig `json:"streamController" yaml:"streamController"` // 全局流控配置
+	Admin            AdminConfig            `json:"admin" yaml:"admin"`                       // 管理接口配置
+	Binding          BindingConfig          `json:"binding" yaml:"binding"`                   // 补全请求体的解析限制
+	Preflight        PreflightConfig        `json:"preflight" yaml:"preflight"`               // 插件配置预检接口
+	Metrics          MetricsConfig          `json:"metrics" yaml:"metrics"`                   // 监控指标配置
+	Timeouts         TimeoutsConfig         `json:"timeouts" yaml:"timeouts"`                 // 管理和调试接口的超时
+	LegacyFormat     LegacyFormatConfig     `json:"legacyFormat" yaml:"legacyFormat"`         // 旧版Python服务响应格式的兼容模式
+	FeatureFlags     FeatureFlagsConfig     `json:"featureFlags" yaml:"featureFlags"`         // 功能开关的来源
+	Privacy          PrivacyConfig          `json:"privacy" yaml:"privacy"`                   // 隐私模式，长期存储不保存源码文本
+}
+
+var Config = &SoftwareConfig{}
+var Context *ContextConfig = &Config.Context
+var Wrapper *WrapperConfig = &Config.Wrapper
+
+func resetDefValues(c *SoftwareConfig) {
+	if c.Context.Validation.MaxItemBytes == 0 {
+		c.Context.Validation.MaxItemBytes = 64 * 1024
+	}
+	if c.Context.Validation.MaxInvalidFraction == 0 {
+		c.Context.Validation.MaxInvalidFraction = 0.5
+	}
+	if c.Context.HealthTTL == 0 {
+		c.Context.HealthTTL = time.Minute
+	}
+	if c.Context.Sanitize.MaxPromptFraction == 0 {
+		c.Context.Sanitize.MaxPromptFraction = 0.5
+	}
+	if c.Context.Sanitize.Patterns == nil {
+		c.Context.Sanitize.Patterns = DefaultSanitizePatterns
+	}
+	if c.StreamController.QueueTimeout == 0 {
+		c.StreamController.QueueTimeout = 200 * time.Millisecond
+	}
+	if c.StreamController.CompletionTimeout == 0 {
+		c.StreamController.CompletionTimeout = 2500 * time.Millisecond
+	}
+	if c.StreamController.CleanOlderThan == 0 {
+		c.StreamController.CleanOlderThan = 1 * time.Hour
+	}
+	if c.LegacyFormat.Sunset == "" {
+		c.LegacyFormat.Sunset = DefaultLegacySunset
+	}
+	if c.Binding.MaxDepth == 0 {
+		c.Binding.MaxDepth = 32
+	}
+	if c.Binding.MaxExtraKeys == 0 {
+		c.Binding.MaxExtraKeys = 64
+	}
+	if c.Binding.MaxExtraValueBytes == 0 {
+		c.Binding.MaxExtraValueBytes = 16 * 1024
+	}
+	if c.Binding.MaxBodyBytes == 0 {
+		c.Binding.MaxBodyBytes = 4 * 1024 * 1024
+	}
+	if c.Binding.MaxDecompressedBytes == 0 {
+		c.Binding.MaxDecompressedBytes = 8 * 1024 * 1024
+	}
+	if c.Preflight.Rate == 0 {
+		c.Preflight.Rate = 0.2
+	}
+	if c.Preflight.Burst == 0 {
+		c.Preflight.Burst = 3
+	}
+	if c.Preflight.Timeout == 0 {
+		c.Preflight.Timeout = 5 * time.Second
+	}
+	if c.Timeouts.Admin == 0 {
+		c.Timeouts.Admin = 5 * time.Second
+	}
+	if c.Timeouts.Debug == 0 {
+		c.Timeouts.Debug = 30 * time.Second
+	}
+	if c.StreamController.DedupWindow == 0 {
+		c.StreamController.DedupWindow = 30 * time.Second
+	}
+	if c.StreamController.ErrorJournalSize == 0 {
+		c.StreamController.ErrorJournalSize = 500
+	}
+	if c.StreamController.Diagnostics.MaxBytes == 0 {
+		c.StreamController.Diagnostics.MaxBytes = 64 * 1024
+	}
+	if c.StreamController.Streams.MaxPerClient == 0 {
+		c.StreamController.Streams.MaxPerClient = 2
+	}
+	if c.StreamController.Streams.Heartbeat == 0 {
+		c.StreamController.Streams.Heartbeat = 15 * time.Second
+	}
+	if c.StreamController.Streams.MaxDuration == 0 {
+		c.StreamController.Streams.MaxDuration = 120 * time.Second
+	}
+	if c.StreamController.Samples.Rate == 0 {
+		c.StreamController.Samples.Rate = 0.001
+	}
+	if c.StreamController.Samples.MaxEntries == 0 {
+		c.StreamController.Samples.MaxEntries = 2000
+	}
+	if c.StreamController.Samples.Retention == 0 {
+		c.StreamController.Samples.Retention = 72 * time.Hour
+	}
+	warmup := &c.StreamController.Warmup
+	if warmup.Duration == 0 {
+		warmup.Duration = 60 * time.Second
+	}
+	if warmup.StartFactor == 0 {
+		warmup.StartFactor = 0.5
+	}
+	if warmup.ThresholdBoost == 0 {
+		warmup.ThresholdBoost = 0.05
+	}
+	if warmup.Step == 0 {
+		warmup.Step = 5 * time.Second
+	}
+	if c.StreamController.Probe.Interval == 0 {
+		c.StreamController.Probe.Interval = 60 * time.Second
+	}
+	if c.StreamController.Probe.History == 0 {
+		c.StreamController.Probe.History = 20
+	}
+	usage := &c.StreamController.Usage
+	if usage.FlushInterval == 0 {
+		usage.FlushInterval = 60 * time.Second
+	}
+	if usage.RetentionDays == 0 {
+		usage.RetentionDays = 400
+	}
+	if usage.MaxTenants == 0 {
+		usage.MaxTenants = 1000
+	}
+	if usage.MaxClients == 0 {
+		usage.MaxClients = 10000
+	}
+	tail := &c.StreamController.Tail
+	if tail.MaxDuration == 0 {
+		tail.MaxDuration = 30 * time.Minute
+	}
+	if tail.Heartbeat == 0 {
+		tail.Heartbeat = 15 * time.Second
+	}
+	if tail.Buffer == 0 {
+		tail.Buffer = 256
+	}
+	if c.StreamController.StrictDeadline.SafetyMargin == 0 {
+		c.StreamController.StrictDeadline.SafetyMargin = 50 * time.Millisecond
+	}
+	if c.StreamController.Fairness.DefaultWeight == 0 {
+		c.StreamController.Fairness.DefaultWeight = 1
+	}
+	if c.Wrapper.Prune.AllowedModes == nil {
+		c.Wrapper.Prune.AllowedModes = []string{"full"}
+	}
+	if weights := &c.Wrapper.Confidence; *weights == (ConfidenceConfig{}) {
+		*weights = ConfidenceConfig{HideScore: 0.35, Pruners: 0.2, FinishReason: 0.2, Length: 0.1, SyntaxTrim: 0.15}
+	}
+	if len(c.Wrapper.Prune.PythonTextRules) == 0 {
+		// 兼容之前通过环境变量配置的部署，只在加载配置时读取一次
+		if rules := os.Getenv("PYTHON_TEXT_RULES"); rules != "" {
+			c.Wrapper.Prune.PythonTextRules = strings.Split(rules, ",")
+		} else {
+			c.Wrapper.Prune.PythonTextRules = []string{"return self.name"}
+		}
+	}
+	if c.Wrapper.Prune.SyntaxParseBudget == 0 {
+		c.Wrapper.Prune.SyntaxParseBudget = 64
+	}
+	retry := &c.Wrapper.Prune.Retry
+	if retry.MinRemaining == 0 {
+		retry.MinRemaining = 800 * time.Millisecond
+	}
+	if retry.TemperatureStep == 0 {
+		retry.TemperatureStep = 0.3
+	}
+	reduce := &c.Wrapper.Reduce
+	if reduce.MaxImportBytes == 0 {
+		reduce.MaxImportBytes = 64 * 1024
+	}
+	if reduce.MaxContextBytes == 0 {
+		reduce.MaxContextBytes = 64 * 1024
+	}
+	if reduce.NearCursorLines == 0 {
+		reduce.NearCursorLines = 20
+	}
+	if c.Wrapper.Blank.MinChars == 0 {
+		c.Wrapper.Blank.MinChars = 1
+	}
+	if c.Wrapper.Blank.MinContextChars == 0 {
+		c.Wrapper.Blank.MinContextChars = 1
+	}
+	tokenCache := &c.Wrapper.TokenCache
+	if tokenCache.MaxFiles == 0 {
+		tokenCache.MaxFiles = 2000
+	}
+	if tokenCache.TTL == 0 {
+		tokenCache.TTL = 10 * time.Minute
+	}
+	if tokenCache.VerifyEvery == 0 {
+		tokenCache.VerifyEvery = 100
+	}
+	calibration := &c.Wrapper.Calibration
+	if calibration.MinSamples == 0 {
+		calibration.MinSamples = 20
+	}
+	if calibration.Window == 0 {
+		calibration.Window = 200
+	}
+	if calibration.MinFactor == 0 {
+		calibration.MinFactor = 0.8
+	}
+	if calibration.MaxFactor == 0 {
+		calibration.MaxFactor = 1.5
+	}
+	if calibration.Tighten == 0 {
+		calibration.Tighten = 1.1
+	}
+	if c.Wrapper.Fallback.Default == "" {
+		c.Wrapper.Fallback.Default = "plain"
+	}
+	fingerprint := &c.Wrapper.Fingerprint
+	if fingerprint.PrefixLines == 0 {
+		fingerprint.PrefixLines = 3
+	}
+	if fingerprint.SuffixLines == 0 {
+		fingerprint.SuffixLines = 2
+	}
+	if fingerprint.Length == 0 {
+		fingerprint.Length = 16
+	}
+	style := &c.Wrapper.Style
+	if style.MaxClients == 0 {
+		style.MaxClients = 10000
+	}
+	if style.TTL == 0 {
+		style.TTL = 24 * time.Hour
+	}
+	if style.Decay == 0 {
+		style.Decay = 0.9
+	}
+	if style.MinSamples == 0 {
+		style.MinSamples = 3
+	}
+	if style.Confidence == 0 {
+		style.Confidence = 0.7
+	}
+	if c.Wrapper.Shape.MaxTokens == 0 {
+		c.Wrapper.Shape.MaxTokens = 16
+	}
+	testFile := &c.Wrapper.TestFile
+	if testFile.MaxOutputScale == 0 {
+		testFile.MaxOutputScale = 1.5
+	}
+	if testFile.ThresholdOffset == nil {
+		offset := -0.1
+		testFile.ThresholdOffset = &offset
+	}
+	empty := &c.Wrapper.Empty
+	if empty.MemoTTL == 0 {
+		empty.MemoTTL = 10 * time.Second
+	}
+	if empty.MemoMaxEntries == 0 {
+		empty.MemoMaxEntries = 10000
+	}
+	progressive := &c.Wrapper.Progressive
+	if progressive.RegionLines == 0 {
+		progressive.RegionLines = 40
+	}
+	if progressive.NearbyLines == 0 {
+		progressive.NearbyLines = 20
+	}
+	if progressive.FetchTimeout == 0 {
+		progressive.FetchTimeout = 2 * time.Second
+	}
+	if progressive.MaxEntries == 0 {
+		progressive.MaxEntries = 10000
+	}
+	if progressive.TTL == 0 {
+		progressive.TTL = 5 * time.Minute
+	}
+	acceptance := &c.Wrapper.Acceptance
+	if acceptance.PartialThreshold == 0 {
+		acceptance.PartialThreshold = 0.5
+	}
+	if acceptance.MaxClients == 0 {
+		acceptance.MaxClients = 10000
+	}
+	if acceptance.TTL == 0 {
+		acceptance.TTL = 10 * time.Minute
+	}
+	generated := &c.Wrapper.Generated
+	if generated.Action == "" {
+		generated.Action = "flag"
+	}
+	if generated.MaxAvgLineLength == 0 {
+		generated.MaxAvgLineLength = 300
+	}
+	if generated.MinWhitespaceRatio == 0 {
+		generated.MinWhitespaceRatio = 0.05
+	}
+	if generated.MinSampleBytes == 0 {
+		generated.MinSampleBytes = 1024
+	}
+	if generated.DegradeBytes == 0 {
+		generated.DegradeBytes = 1024
+	}
+	if generated.HeaderPatterns == nil {
+		generated.HeaderPatterns = []string{`(?i)code generated .*do not edit`, `@generated\b`, `(?i)auto-?generated.*do not (edit|modify)`}
+	}
+	if generated.FilePatterns == nil {
+		generated.FilePatterns = []string{".min.js", ".min.css", ".bundle.js", "_pb.go", ".pb.go", "_pb2.py", ".pb.h", ".pb.cc", ".g.dart"}
+	}
+	tune := &c.Wrapper.Score.AutoTune
+	if tune.Interval == 0 {
+		tune.Interval = 5 * time.Minute
+	}
+	if tune.Window == 0 {
+		tune.Window = 1 * time.Hour
+	}
+	if tune.TargetPrecision == 0 {
+		tune.TargetPrecision = 0.3
+	}
+	if tune.MaxStep == 0 {
+		tune.MaxStep = 0.05
+	}
+	if tune.MinSamples == 0 {
+		tune.MinSamples = 100
+	}
+	if tune.MinThreshold == 0 {
+		tune.MinThreshold = 0.05
+	}
+	if tune.MaxThreshold == 0 {
+		tune.MaxThreshold = 0.95
+	}
+	if c.StreamController.SmallPromptTokens == 0 {
+		c.StreamController.SmallPromptTokens = 512
+	}
+	if c.StreamController.LargePromptTokens == 0 {
+		c.StreamController.LargePromptTokens = 2048
+	}
+	if c.StreamController.PriorityAging == 0 {
+		c.StreamController.PriorityAging = c.StreamController.QueueTimeout
+	}
+	if c.StreamController.MaxPoolConcurrent == 0 {
+		c.StreamController.MaxPoolConcurrent = 256
+	}
+	anomaly := &c.StreamController.Anomaly
+	if anomaly.Window == 0 {
+		anomaly.Window = 1 * time.Minute
+	}
+	if anomaly.Action == "" {
+		anomaly.Action = SafeActionRaiseThreshold
+	}
+	if anomaly.MinSamples == 0 {
+		anomaly.MinSamples = 50
+	}
+	if anomaly.RateMultiple == 0 {
+		anomaly.RateMultiple = 3
+	}
+	if anomaly.RecoverMultiple == 0 {
+		anomaly.RecoverMultiple = 1.5
+	}
+	if anomaly.MinRate == 0 {
+		anomaly.MinRate = 0.1
+	}
+	if anomaly.BaselineWeight == 0 {
+		anomaly.BaselineWeight = 0.2
+	}
+	if anomaly.RecoverWindows == 0 {
+		anomaly.RecoverWindows = 3
+	}
+	if anomaly.ThresholdBoost == 0 {
+		anomaly.ThresholdBoost = 0.2
+	}
+	if anomaly.ProbeRatio == 0 {
+		anomaly.ProbeRatio = 0.1
+	}
+}
+
+// 补齐配置的默认值，用于没有配置文件时直接启动服务(如进程内压测)；已有的值不变
+func ApplyDefaults() {
 	resetDefValues(Config)
-	data, _ := json.MarshalIndent(Config, "", "  ")
-	fmt.Printf("配置文件加载成功:\n%s\n", string(data))
 }
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func loadFixture(t *testing.T, name string) (*SoftwareConfig, []string, error) {
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("read %s failed: %v", name, err)
+	}
+	var c SoftwareConfig
+	notices, err := LoadConfig(data, &c)
+	return &c, notices, err
+}
+
+// to test migration of the legacy context fields
+// go test ./pkg/config/ -v -run Test_MigrateLegacyConfig
+func Test_MigrateLegacyConfig(t *testing.T) {
+	c, notices, err := loadFixture(t, "config_v1_legacy.yaml")
+	if err != nil {
+		t.Fatalf("load legacy config failed: %v", err)
+	}
+	if len(notices) != 4 {
+		t.Errorf("expected 4 migration notices, got %v", notices)
+	}
+	if c.ConfigVersion != CurrentConfigVersion {
+		t.Errorf("expected configVersion %d, got %d", CurrentConfigVersion, c.ConfigVersion)
+	}
+	if !c.Context.Definition.Disabled || c.Context.Semantic.Disabled || !c.Context.Relation.Disabled {
+		t.Errorf("unexpected disabled flags %+v", c.Context)
+	}
+	if c.Context.Semantic.TopK != 5 {
+		t.Errorf("expected semantic settings kept, got %+v", c.Context.Semantic)
+	}
+	if c.Context.TotalTimeout != 500*time.Millisecond {
+		t.Errorf("expected totalTimeout 500ms, got %v", c.Context.TotalTimeout)
+	}
+}
+
+// to test unversioned configs, where the current field wins over the legacy one
+// go test ./pkg/config/ -v -run Test_MigrateUnversionedConfig
+func Test_MigrateUnversionedConfig(t *testing.T) {
+	c, notices, err := loadFixture(t, "config_v1_unversioned.yaml")
+	if err != nil {
+		t.Fatalf("load unversioned config failed: %v", err)
+	}
+	if len(notices) != 1 || !strings.Contains(notices[0], "ignored") {
+		t.Errorf("expected 1 ignored notice, got %v", notices)
+	}
+	if c.Context.TotalTimeout != 600*time.Millisecond {
+		t.Errorf("expected totalTimeout 600ms, got %v", c.Context.TotalTimeout)
+	}
+	if len(c.Models) != 1 || c.Models[0].Timeout != time.Second {
+		t.Errorf("unexpected models %+v", c.Models)
+	}
+}
+
+// to test the errors of unknown fields and unsupported versions
+// go test ./pkg/config/ -v -run Test_LoadConfigErrors
+func Test_LoadConfigErrors(t *testing.T) {
+	if _, _, err := loadFixture(t, "config_unknown_field.yaml"); err == nil || !strings.Contains(err.Error(), "threshold") {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+	if _, _, err := loadFixture(t, "config_future.yaml"); err == nil || !strings.Contains(err.Error(), "unsupported configVersion 99") {
+		t.Errorf("expected unsupported version error, got %v", err)
+	}
+}
+
+// 提取部署模板中的配置文件内容，部署变量替换为合法的值
+func templateConfig(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read template failed: %v", err)
+	}
+	var configMap struct {
+		Data map[string]string `yaml:"data"`
+	}
+	// 模板的第一个文档是ConfigMap
+	doc := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n---\n")[0]
+	if err := yaml.Unmarshal([]byte(doc), &configMap); err != nil {
+		t.Fatalf("parse template failed: %v", err)
+	}
+	return []byte(regexp.MustCompile(`\$\{\{[^}]*\}\}`).ReplaceAllString(configMap.Data["config.yaml"], "1"))
+}
+
+// to test that the deployed config template passes strict decoding and round-trips
+// go test ./pkg/config/ -v -run Test_TemplateRoundTrip
+func Test_TemplateRoundTrip(t *testing.T) {
+	var c SoftwareConfig
+	notices, err := LoadConfig(templateConfig(t, "../../code-completion.template.yaml"), &c)
+	if err != nil {
+		t.Fatalf("template failed strict decoding: %v", err)
+	}
+	if len(notices) != 0 {
+		t.Errorf("expected no migration for the template, got %v", notices)
+	}
+
+	out, err := yaml.Marshal(&c)
+	if err != nil {
+		t.Fatalf("marshal config failed: %v", err)
+	}
+	var again SoftwareConfig
+	if _, err := LoadConfig(out, &again); err != nil {
+		t.Fatalf("round-trip failed strict decoding: %v", err)
+	}
+	if !reflect.DeepEqual(c, again) {
+		t.Errorf("round-trip mismatch:\n%+v\n%+v", c, again)
+	}
+}
+
+// to test that configs built from the unversioned template of earlier releases still load
+// go test ./pkg/config/ -v -run Test_LegacyTemplate
+func Test_LegacyTemplate(t *testing.T) {
+	var c SoftwareConfig
+	notices, err := LoadConfig(templateConfig(t, "testdata/code-completion.v1.template.yaml"), &c)
+	if err != nil {
+		t.Fatalf("legacy template failed to load: %v", err)
+	}
+	if len(notices) != 1 || !strings.Contains(notices[0], "wrapper.syntax.threshold") {
+		t.Errorf("expected 1 removed threshold notice, got %v", notices)
+	}
+	if c.ConfigVersion != CurrentConfigVersion || c.Wrapper.Syntax.Disabled {
+		t.Errorf("unexpected legacy config %+v", c.Wrapper.Syntax)
+	}
+}
+
+// to test the provider specific required fields of the model config
+// go test ./pkg/config/ -v -run Test_ValidateModel
+func Test_ValidateModel(t *testing.T) {
+	cases := []struct {
+		cfg ModelConfig
+		err string
+	}{
+		{ModelConfig{Provider: "openai", ModelName: "m", CompletionsUrl: "http://host/v1/completions"}, ""},
+		{ModelConfig{Provider: "deepseek", CompletionsUrl: "http://host/v1/completions"}, "modelName is required"},
+		{ModelConfig{Provider: "ollama", ModelName: "qwen2.5-coder"}, "completionsUrl is required"},
+		{ModelConfig{Provider: "ollama", CompletionsUrl: "http://host:11434/api/generate"}, "modelName is required"},
+		{ModelConfig{Provider: "llamacpp", CompletionsUrl: "http://host:8080/infill", FimMode: true}, ""},
+		{ModelConfig{Provider: "llamacpp", CompletionsUrl: "http://host:8080/completion", FimMode: true}, "fimMode requires"},
+		{ModelConfig{Provider: "deepseek", ModelName: "m", CompletionsUrl: "http://host/v1/completions", AuthMode: AuthModeBothFallback}, ""},
+		{ModelConfig{Provider: "ollama", ModelName: "m", CompletionsUrl: "http://host:11434/api/generate", AuthMode: AuthModePassthrough}, "requires an openai compatible provider"},
+		{ModelConfig{Provider: "openai", ModelName: "m", CompletionsUrl: "http://host/v1/completions", AuthMode: "user"}, "unknown authMode"},
+	}
+	for i, c := range cases {
+		err := c.cfg.Validate()
+		if (c.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("case %d: expected error %q, got %v", i, c.err, err)
+		}
+	}
+}
+
+// to test the replicas sharing a modelName agree on everything but the per-endpoint settings
+// go test ./pkg/config/ -v -run Test_ValidateReplicas
+func Test_ValidateReplicas(t *testing.T) {
+	replica := func(url string) ModelConfig {
+		return ModelConfig{Provider: "openai", ModelName: "coder", CompletionsUrl: url, MaxPrefix: 512, Tags: []string{"fast"}}
+	}
+	a, b := replica("http://10.0.0.1/v1/completions"), replica("http://10.0.0.2/v1/completions")
+	b.MaxConcurrent, b.Timeout, b.Authorization = 16, time.Second, "env:REPLICA_KEY"
+	if err := ValidateReplicas([]ModelConfig{a, b, {Provider: "llamacpp", CompletionsUrl: a.CompletionsUrl}}); err != nil {
+		t.Errorf("expected valid replicas, got %v", err)
+	}
+
+	c := replica("http://10.0.0.3/v1/completions")
+	c.MaxPrefix = 1024
+	d := replica("http://10.0.0.4/v1/completions")
+	d.Tags = []string{"slow"}
+	cases := []struct {
+		models []ModelConfig
+		err    string
+	}{
+		{[]ModelConfig{a, b, c}, "models[0] and models[2] differ in 'maxPrefix'"},
+		{[]ModelConfig{a, d}, "differ in 'tags'"},
+		{[]ModelConfig{a, replica(a.CompletionsUrl)}, "replicas share completionsUrl"},
+	}
+	for i, c := range cases {
+		if err := ValidateReplicas(c.models); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("case %d: expected error %q, got %v", i, c.err, err)
+		}
+	}
+}
+
+// to test the action and multiples of the anomaly detection config
+// go test ./pkg/config/ -v -run Test_ValidateAnomaly
+func Test_ValidateAnomaly(t *testing.T) {
+	cases := []struct {
+		cfg AnomalyConfig
+		err string
+	}{
+		{AnomalyConfig{Action: SafeActionRaiseThreshold, RateMultiple: 3, RecoverMultiple: 1.5}, ""},
+		{AnomalyConfig{Action: SafeActionFallback, FallbackModel: "stable", RateMultiple: 3, RecoverMultiple: 1.5}, ""},
+		{AnomalyConfig{Action: SafeActionFallback, RateMultiple: 3, RecoverMultiple: 1.5}, "requires fallbackModel"},
+		{AnomalyConfig{Action: "shutdown", RateMultiple: 3, RecoverMultiple: 1.5}, "unknown action"},
+		{AnomalyConfig{Action: SafeActionRejectAuto, RateMultiple: 2, RecoverMultiple: 3}, "must not exceed"},
+	}
+	for i, c := range cases {
+		err := c.cfg.Validate()
+		if (c.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("case %d: expected error %q, got %v", i, c.err, err)
+		}
+	}
+}
+
+// to test the stream controller config rejecting timers that cannot tick
+// go test ./pkg/config/ -v -run Test_ValidateStreamController
+func Test_ValidateStreamController(t *testing.T) {
+	cases := []struct {
+		update func(c *StreamControllerConfig)
+		err    string
+	}{
+		{func(c *StreamControllerConfig) {}, ""},
+		{func(c *StreamControllerConfig) { c.SmallPromptTokens = c.LargePromptTokens }, "smallPromptTokens"},
+		{func(c *StreamControllerConfig) { c.Tail.Heartbeat = -time.Second }, "streamController.tail"},
+		{func(c *StreamControllerConfig) { c.Tail.MaxDuration = -time.Second }, "streamController.tail"},
+	}
+	for i, c := range cases {
+		var cfg SoftwareConfig
+		resetDefValues(&cfg)
+		c.update(&cfg.StreamController)
+		err := cfg.StreamController.Validate()
+		if (c.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("case %d: expected error %q, got %v", i, c.err, err)
+		}
+	}
+}
+
+// to test the precedence of --config, CC_CONFIG and the default paths
+// go test ./pkg/config/ -v -run Test_ConfigPaths
+func Test_ConfigPaths(t *testing.T) {
+	saved, dev := *Config, DevMode
+	defer func() { *Config, DevMode = saved, dev }()
+	dir := t.TempDir()
+	flagPath, envPath := filepath.Join(dir, "flag.yaml"), filepath.Join(dir, "env.yaml")
+	os.WriteFile(flagPath, []byte("configVersion: 2\nadmin:\n  token: flag\n"), 0644)
+	os.WriteFile(envPath, []byte("configVersion: 2\nadmin:\n  token: env\n"), 0644)
+
+	t.Setenv(EnvConfigPath, envPath)
+	if paths, origin := ConfigPaths(flagPath); len(paths) != 1 || paths[0] != flagPath || origin != "--config" {
+		t.Errorf("expected --config to take precedence, got %v from %s", paths, origin)
+	}
+	if source, err := Load(LoadOptions{Path: flagPath}); err != nil || source != flagPath || Config.Admin.Token != "flag" {
+		t.Errorf("expected the config loaded from --config, got %s %q %v", source, Config.Admin.Token, err)
+	}
+	if source, err := Load(LoadOptions{}); err != nil || source != envPath || Config.Admin.Token != "env" {
+		t.Errorf("expected the config loaded from %s, got %s %q %v", EnvConfigPath, source, Config.Admin.Token, err)
+	}
+	// 默认值已补齐
+	if Config.StreamController.QueueTimeout == 0 {
+		t.Error("expected the default values applied")
+	}
+	// 补齐默认值后校验，无效的配置不加载
+	invalidPath := filepath.Join(dir, "invalid.yaml")
+	os.WriteFile(invalidPath, []byte("configVersion: 2\nstreamController:\n  smallPromptTokens: 4096\n"), 0644)
+	if _, err := Load(LoadOptions{Path: invalidPath}); err == nil || !strings.Contains(err.Error(), "smallPromptTokens") {
+		t.Errorf("expected the small/large prompt tokens rejected, got %v", err)
+	}
+
+	// 明确指定的路径不存在时不回退
+	missing := filepath.Join(dir, "missing.yaml")
+	t.Setenv(EnvConfigPath, missing)
+	_, err := Load(LoadOptions{})
+	if !errors.Is(err, ErrConfigNotFound) || !strings.Contains(err.Error(), missing) || !strings.Contains(err.Error(), EnvConfigPath) {
+		t.Errorf("expected a not found error naming %s, got %v", missing, err)
+	}
+	if Config.Admin.Token != "env" {
+		t.Error("expected the config unchanged after a failed load")
+	}
+	t.Setenv(EnvConfigPath, "")
+	paths, origin := ConfigPaths("")
+	if origin != "default" || paths[0] != "config.yaml" {
+		t.Errorf("expected the default paths, got %v from %s", paths, origin)
+	}
+	_, err = Load(LoadOptions{})
+	if !errors.Is(err, ErrConfigNotFound) || !strings.Contains(err.Error(), "--dev") {
+		t.Errorf("expected the searched paths and hints in the error, got %v", err)
+	}
+}
+
+// to test loading the embedded development config
+// go test ./pkg/config/ -v -run Test_LoadDev
+func Test_LoadDev(t *testing.T) {
+	saved, dev := *Config, DevMode
+	defer func() { *Config, DevMode = saved, dev }()
+
+	t.Setenv(EnvDevModelURL, "http://127.0.0.1:9000/v1/completions")
+	if _, err := Load(LoadOptions{Dev: true, DevModelURL: "http://localhost:8001/v1/completions"}); err != nil {
+		t.Fatalf("load dev config failed: %v", err)
+	}
+	if !DevMode || len(Config.Models) != 1 || Config.Models[0].CompletionsUrl != "http://localhost:8001/v1/completions" {
+		t.Errorf("expected one mock model at the flag url, got %+v", Config.Models)
+	}
+	if err := Config.Models[0].Validate(); err != nil {
+		t.Errorf("expected a valid mock model, got %v", err)
+	}
+	if !Config.Context.Definition.Disabled || !Config.Context.Semantic.Disabled || !Config.Context.Relation.Disabled {
+		t.Errorf("expected the context services disabled, got %+v", Config.Context)
+	}
+	if _, err := Load(LoadOptions{Dev: true}); err != nil || Config.Models[0].CompletionsUrl != "http://127.0.0.1:9000/v1/completions" {
+		t.Errorf("expected the url from %s, got %s %v", EnvDevModelURL, Config.Models[0].CompletionsUrl, err)
+	}
+	if _, err := Load(LoadOptions{Dev: true, Path: "config.yaml"}); err == nil {
+		t.Error("expected an error for --dev with --config")
+	}
+}
+
+// to test that secrets are left out of the config printed at startup
+// go test ./pkg/config/ -v -run Test_SecretsNotPrinted
+func Test_SecretsNotPrinted(t *testing.T) {
+	var c SoftwareConfig
+	c.Admin.Token = "admin-secret"
+	c.Wrapper.Fingerprint.Salt = "salt-secret"
+	c.StreamController.Fairness.Tenants = map[string]TenantConfig{"team-a": {Weight: 2, Tokens: []string{"tenant-secret"}}}
+	data, err := json.MarshalIndent(&c, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal config failed: %v", err)
+	}
+	for _, secret := range []string{"admin-secret", "salt-secret", "tenant-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected %q left out of the printed config", secret)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 指定配置文件路径的环境变量，优先级低于--config
+const EnvConfigPath = "CC_CONFIG"
+
+// 指定开发模式模拟模型地址的环境变量，优先级低于--dev-model-url
+const EnvDevModelURL = "CC_DEV_MODEL_URL"
+
+// 没有找到配置文件，用errors.Is判断
+var ErrConfigNotFound = errors.New("config file not found")
+
+// 本地开发用的最小配置，只在指定--dev时使用
+//
+//go:embed dev.yaml
+var devConfig []byte
+
+// 是否使用内置的开发配置启动
+var DevMode bool
+
+/**
+ * 加载配置的选项
+ * @description
+ * - Path: 命令行--config指定的配置文件路径
+ * - Dev: 命令行--dev，使用内置的开发配置，不读取配置文件
+ * - DevModelURL: 命令行--dev-model-url，开发配置中模拟模型的补全地址
+ */
+type LoadOptions struct {
+	Path        string
+	Dev         bool
+	DevModelURL string
+}
+
+/**
+ * 确定要查找的配置文件
+ * @param {string} flagPath - 命令行--config指定的路径
+ * @returns {[]string, string} 返回依次查找的路径，以及路径的来源(--config/CC_CONFIG/default)
+ * @description
+ * - 优先级: --config > 环境变量CC_CONFIG > 默认路径
+ * - 明确指定的路径只查找该路径，不再回退到默认路径
+ * - 默认路径为工作目录和可执行文件所在目录下的config.yaml
+ * @example
+ * paths, origin := ConfigPaths("")
+ * // paths = ["config.yaml", "/app/config.yaml"], origin = "default"
+ */
+func ConfigPaths(flagPath string) ([]string, string) {
+	if flagPath != "" {
+		return []string{flagPath}, "--config"
+	}
+	if env := strings.TrimSpace(os.Getenv(EnvConfigPath)); env != "" {
+		return []string{env}, EnvConfigPath
+	}
+	paths := []string{"config.yaml"}
+	if exe, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(exe), "config.yaml")
+		if abs, err := filepath.Abs(paths[0]); err != nil || abs != path {
+			paths = append(paths, path)
+		}
+	}
+	return paths, "default"
+}
+
+/**
+ * 加载配置，启动时在初始化其它模块之前调用
+ * @param {LoadOptions} opts - 加载选项
+ * @returns {string, error} 返回配置的来源(文件路径或内置开发配置)，以及错误
+ * @description
+ * - 按ConfigPaths的顺序读取第一个存在的配置文件，旧版本的配置先迁移到当前版本
+ * - 都不存在时返回包装ErrConfigNotFound的错误，列出查找过的路径和解决办法
+ * - Dev为true时使用内置的开发配置: 一个模拟模型，关闭上下文检索，较宽松的超时，不能与--config同时使用
+ * - 解析失败时配置不变
+ * @example
+ * source, err := Load(LoadOptions{Path: "/etc/code-completion/config.yaml"})
+ */
+func Load(opts LoadOptions) (string, error) {
+	var data []byte
+	var source string
+	if opts.Dev {
+		if opts.Path != "" {
+			return "", fmt.Errorf("--dev uses the embedded development config and cannot be combined with --config")
+		}
+		if env := os.Getenv(EnvConfigPath); env != "" {
+			fmt.Printf("开发模式忽略环境变量%s=%s\n", EnvConfigPath, env)
+		}
+		data, source = devConfig, "embedded dev config"
+	} else {
+		paths, origin := ConfigPaths(opts.Path)
+		for _, path := range paths {
+			content, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return "", fmt.Errorf("read config file %s: %w", path, err)
+			}
+			data, source = content, path
+			break
+		}
+		if data == nil {
+			return "", notFoundError(paths, origin)
+		}
+	}
+
+	// 解析 YAML 配置，旧版本的配置先迁移到当前版本
+	var c SoftwareConfig
+	notices, err := LoadConfig(data, &c)
+	printNotices(notices)
+	if err != nil {
+		return source, fmt.Errorf("parse config %s: %w", source, err)
+	}
+	if opts.Dev {
+		url := opts.DevModelURL
+		if url == "" {
+			url = os.Getenv(EnvDevModelURL)
+		}
+		if url != "" {
+			c.Models[0].CompletionsUrl = url
+		}
+	}
+	resetDefValues(&c)
+	if err := c.StreamController.Validate(); err != nil {
+		return source, fmt.Errorf("invalid config %s: %w", source, err)
+	}
+	*Config = c
+	DevMode = opts.Dev
+	data, _ = json.MarshalIndent(Config, "", "  ")
+	fmt.Printf("配置文件加载成功(%s):\n%s\n", source, string(data))
+	if opts.Dev {
+		fmt.Printf("开发模式: 模拟模型地址%s，管理令牌'%s'，不要用于部署\n", Config.Models[0].CompletionsUrl, Config.Admin.Token)
+	}
+	return source, nil
+}
+
+// 没有找到配置文件的错误，列出查找过的路径
+func notFoundError(paths []string, origin string) error {
+	var b strings.Builder
+	if origin == "default" {
+		b.WriteString("searched:")
+	} else {
+		fmt.Fprintf(&b, "specified by %s:", origin)
+	}
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil && abs != path {
+			path = fmt.Sprintf("%s (%s)", path, abs)
+		}
+		fmt.Fprintf(&b, "\n  %s", path)
+	}
+	fmt.Fprintf(&b, "\nspecify the config file with --config <path> or the %s environment variable, "+
+		"or start with --dev to use the embedded development config with a mock model", EnvConfigPath)
+	return fmt.Errorf("%w, %s", ErrConfigNotFound, b.String())
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 当前配置文件的结构版本
+const CurrentConfigVersion = 2
+
+// 配置迁移函数：把文档从版本N迁移到版本N+1，返回每个迁移字段的提示信息
+type migration func(doc map[string]interface{}) []string
+
+// 各版本到下一版本的迁移，key为迁移前的版本
+var migrations = map[int]migration{
+	1: migrateV1ToV2,
+}
+
+/**
+ * 加载配置文件内容
+ * @param {[]byte} data - 配置文件内容(YAML)
+ * @param {*SoftwareConfig} c - 解析结果
+ * @returns {[]string, error} 返回迁移过程中的提示信息，以及错误
+ * @description
+ * - 缺少configVersion的配置文件视为版本1
+ * - 依次执行迁移，直到当前版本，每个迁移的字段都有一条提示
+ * - 迁移后严格解析，出现未知字段时报错，而不是静默忽略
+ * - 不支持的版本直接报错
+ * @example
+ * notices, err := LoadConfig(data, Config)
+ */
+func LoadConfig(data []byte, c *SoftwareConfig) ([]string, error) {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	version := 1
+	if v, ok := doc["configVersion"]; ok {
+		n, ok := v.(int)
+		if !ok {
+			return nil, fmt.Errorf("invalid configVersion '%v', expected an integer", v)
+		}
+		version = n
+	}
+	if version < 1 || version > CurrentConfigVersion {
+		return nil, fmt.Errorf("unsupported configVersion %d, supported versions are 1 to %d", version, CurrentConfigVersion)
+	}
+	var notices []string
+	for ; version < CurrentConfigVersion; version++ {
+		for _, notice := range migrations[version](doc) {
+			notices = append(notices, fmt.Sprintf("configVersion %d->%d: %s", version, version+1, notice))
+		}
+	}
+	doc["configVersion"] = CurrentConfigVersion
+
+	migrated, err := yaml.Marshal(doc)
+	if err != nil {
+		return notices, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(migrated))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return notices, err
+	}
+	return notices, nil
+}
+
+/**
+ * 版本1到版本2的迁移
+ * @description
+ * - context.disEnableDefinitionSearch -> context.definition.disabled
+ * - context.disEnableSemanticSearch -> context.semantic.disabled
+ * - context.disEnableRelationSearch -> context.relation.disabled
+ * - context.contextCostTime -> context.totalTimeout，整数按毫秒处理
+ * - wrapper.syntax.threshold 已废弃，直接删除
+ */
+func migrateV1ToV2(doc map[string]interface{}) []string {
+	var notices []string
+	if wrapper, ok := doc["wrapper"].(map[string]interface{}); ok {
+		if syntax, ok := wrapper["syntax"].(map[string]interface{}); ok {
+			if _, ok := syntax["threshold"]; ok {
+				delete(syntax, "threshold")
+				notices = append(notices, "'wrapper.syntax.threshold' is removed, it is no longer used")
+			}
+		}
+	}
+	ctx, ok := doc["context"].(map[string]interface{})
+	if !ok {
+		return notices
+	}
+	move := func(oldKey, section, newKey string, convert func(interface{}) interface{}) {
+		v, ok := ctx[oldKey]
+		if !ok {
+			return
+		}
+		delete(ctx, oldKey)
+		target := ctx
+		newPath := "context." + newKey
+		if section != "" {
+			sub, ok := ctx[section].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				ctx[section] = sub
+			}
+			target = sub
+			newPath = "context." + section + "." + newKey
+		}
+		if _, exists := target[newKey]; exists {
+			notices = append(notices, fmt.Sprintf("'context.%s' is ignored, '%s' is already set", oldKey, newPath))
+			return
+		}
+		if convert != nil {
+			v = convert(v)
+		}
+		target[newKey] = v
+		notices = append(notices, fmt.Sprintf("'context.%s' is migrated to '%s' = %v", oldKey, newPath, v))
+	}
+	move("disEnableDefinitionSearch", "definition", "disabled", nil)
+	move("disEnableSemanticSearch", "semantic", "disabled", nil)
+	move("disEnableRelationSearch", "relation", "disabled", nil)
+	move("contextCostTime", "", "totalTimeout", millisecondsToDuration)
+	return notices
+}
+
+// 旧版本的时长字段是毫秒数
+func millisecondsToDuration(v interface{}) interface{} {
+	if n, ok := v.(int); ok {
+		return fmt.Sprintf("%dms", n)
+	}
+	return v
+}
+
+// 打印迁移提示信息
+func printNotices(notices []string) {
+	if len(notices) == 0 {
+		return
+	}
+	fmt.Printf("配置文件已迁移到版本%d，请更新配置文件:\n  %s\n", CurrentConfigVersion, strings.Join(notices, "\n  "))
+}
@@ -0,0 +1,258 @@
+package feature_flag
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"sync/atomic"
+
+	"code-completion/pkg/config"
+)
+
+// 功能开关名称，本地配置见staticFlags
+const (
+	FastPath       = "fastPath"       // 极小请求的快速路径
+	Closer         = "closer"         // 自动触发时在本地补全闭合符号
+	Literal        = "literal"        // 光标在字符串中时的补全约束
+	Arguments      = "arguments"      // 光标在参数列表中时的识别和裁剪
+	PruneRetry     = "pruneRetry"     // 补全被整体丢弃后调高温度重试
+	SyntaxRecovery = "syntaxRecovery" // 语法错误丢弃前恢复开头的有效区域
+	Progressive    = "progressive"    // 渐进式代码上下文，不等待检索
+	TestFile       = "testFile"       // 识别测试文件，按测试文件调整阈值和上下文
+	Imports        = "imports"        // 补全引用未导入的包时建议导入语句
+	Acceptance     = "acceptance"     // 跟踪返回的补全，处理下一次请求的采纳反馈
+	EmptyAdvice    = "emptyAdvice"    // 空补全的重试建议和负结果缓存
+	Shape          = "shape"          // 识别微补全，收紧停用词和输出长度
+	Generated      = "generated"      // 识别生成/压缩文件
+	Style          = "style"          // 按客户端学习代码风格
+	StrictDeadline = "strictDeadline" // 请求指定截止时长时按严格截止时间处理
+	Fingerprint    = "fingerprint"    // 计算提示词指纹，用于跨系统关联日志
+	AutoTune       = "autoTune"       // 按采纳率自动调整各语言的隐藏分阈值
+)
+
+// 定向规则匹配的属性
+const (
+	AttributeClient = "client" // 客户端ID
+	AttributeTenant = "tenant" // 租户标识，按请求的凭据确定，见streamController.fairness.tenants
+)
+
+// 各开关在本地配置中的取值，static来源和http来源的缺省值
+var staticFlags = map[string]func() bool{
+	FastPath:       func() bool { return config.Wrapper.FastPath.Enabled },
+	Closer:         func() bool { return config.Wrapper.Closer.Enabled },
+	Literal:        func() bool { return !config.Wrapper.Literal.Disabled },
+	Arguments:      func() bool { return !config.Wrapper.Arguments.Disabled },
+	PruneRetry:     func() bool { return config.Wrapper.Prune.Retry.Enabled },
+	SyntaxRecovery: func() bool { return config.Wrapper.Prune.Recovery.Enabled },
+	Progressive:    func() bool { return config.Wrapper.Progressive.Enabled },
+	TestFile:       func() bool { return !config.Wrapper.TestFile.Disabled },
+	Imports:        func() bool { return !config.Wrapper.Imports.Disabled },
+	Acceptance:     func() bool { return !config.Wrapper.Acceptance.Disabled },
+	EmptyAdvice:    func() bool { return !config.Wrapper.Empty.Disabled },
+	Shape:          func() bool { return !config.Wrapper.Shape.Disabled },
+	Generated:      func() bool { return !config.Wrapper.Generated.Disabled },
+	Style:          func() bool { return !config.Wrapper.Style.Disabled },
+	StrictDeadline: func() bool { return !config.Config.StreamController.StrictDeadline.Disabled },
+	Fingerprint:    func() bool { return !config.Wrapper.Fingerprint.Disabled },
+	AutoTune:       func() bool { return config.Wrapper.Score.AutoTune.Enabled },
+}
+
+// 所有开关的名称，按名称排序
+func Names() []string {
+	names := make([]string, 0, len(staticFlags))
+	for name := range staticFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/**
+ * 定向规则
+ * @description
+ * - Attribute: 匹配的属性(client/tenant)
+ * - Values: 属性取这些值之一时命中
+ * - Enabled: 命中时开关的状态
+ */
+type Rule struct {
+	Attribute string   `json:"attribute"`
+	Values    []string `json:"values"`
+	Enabled   bool     `json:"enabled"`
+}
+
+/**
+ * 一个开关的状态
+ * @description
+ * - Rules: 按顺序匹配，命中的第一条规则决定开关状态
+ * - Rollout: 没有命中规则时开启的客户端百分比(0-100)，按开关名称和客户端ID的哈希分桶，同一客户端的结果稳定
+ * @example
+ * {
+ *   "rollout": 20,
+ *   "rules": [
+ *     {"attribute": "tenant", "values": ["beta"], "enabled": true},
+ *     {"attribute": "client", "values": ["c-legacy"], "enabled": false}
+ *   ]
+ * }
+ */
+type Flag struct {
+	Rollout int    `json:"rollout"`
+	Rules   []Rule `json:"rules,omitempty"`
+}
+
+// 全开或全关的开关
+func constantFlag(enabled bool) Flag {
+	if enabled {
+		return Flag{Rollout: 100}
+	}
+	return Flag{}
+}
+
+// 开关是否对至少一部分客户端开启：灰度比例大于0，或者有开启的定向规则
+func (f *Flag) AnyEnabled() bool {
+	if f.Rollout > 0 {
+		return true
+	}
+	for _, r := range f.Rules {
+		if r.Enabled && len(r.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查开关的比例和规则的属性
+func (f *Flag) validate() error {
+	if f.Rollout < 0 || f.Rollout > 100 {
+		return fmt.Errorf("rollout %d out of range [0, 100]", f.Rollout)
+	}
+	for _, r := range f.Rules {
+		if r.Attribute != AttributeClient && r.Attribute != AttributeTenant {
+			return fmt.Errorf("unknown rule attribute '%s'", r.Attribute)
+		}
+	}
+	return nil
+}
+
+// 按定向规则和灰度比例计算开关对客户端的状态
+func (f *Flag) evaluate(name, clientID, tenantID string) bool {
+	for _, r := range f.Rules {
+		value := clientID
+		if r.Attribute == AttributeTenant {
+			value = tenantID
+		}
+		if value != "" && slices.Contains(r.Values, value) {
+			return r.Enabled
+		}
+	}
+	switch {
+	case f.Rollout >= 100:
+		return true
+	case f.Rollout <= 0:
+		return false
+	}
+	return bucket(name, clientID) < f.Rollout
+}
+
+// 客户端在开关上的分桶(0-99)，不同开关的分桶互相独立
+func bucket(name, clientID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(clientID))
+	return int(h.Sum32() % 100)
+}
+
+/**
+ * 开关来源的当前状态，用于/api/config
+ * @description
+ * - Flags: 各开关生效的状态，来源中没有的开关为本地配置的取值
+ * - http来源记录开关文档的地址、ETag、最近一次拉取成功的时间，以及最近一次拉取失败的原因(此时使用上一次的状态)
+ */
+type Snapshot struct {
+	Provider  string          `json:"provider"`
+	Url       string          `json:"url,omitempty"`
+	ETag      string          `json:"etag,omitempty"`
+	FetchedAt string          `json:"fetchedAt,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Flags     map[string]Flag `json:"flags"`
+}
+
+// Provider 功能开关的来源
+type Provider interface {
+	// 开关对客户端(及其租户)是否开启
+	IsEnabled(flag, clientID, tenantID string) bool
+	// 当前各开关的状态
+	Snapshot() Snapshot
+}
+
+// StaticProvider 按本地配置开关，对所有客户端相同
+type StaticProvider struct{}
+
+func (StaticProvider) IsEnabled(flag, clientID, tenantID string) bool {
+	value, ok := staticFlags[flag]
+	return ok && value()
+}
+
+func (StaticProvider) Snapshot() Snapshot {
+	s := Snapshot{Provider: config.FeatureFlagsStatic, Flags: make(map[string]Flag, len(staticFlags))}
+	for name, value := range staticFlags {
+		s.Flags[name] = constantFlag(value())
+	}
+	return s
+}
+
+// 当前使用的开关来源，未初始化时按本地配置
+var current atomic.Pointer[Provider]
+
+// 当前使用的开关来源
+func Current() Provider {
+	if p := current.Load(); p != nil {
+		return *p
+	}
+	return StaticProvider{}
+}
+
+// 替换开关来源，nil恢复为本地配置，用于测试
+func Use(p Provider) {
+	if p == nil {
+		current.Store(nil)
+		return
+	}
+	current.Store(&p)
+}
+
+/**
+ * 按配置创建开关来源，启动时调用
+ * @param {*config.FeatureFlagsConfig} cfg - 配置featureFlags
+ * @returns {error} 来源未知或http缺少地址时返回错误
+ * @description
+ * - static(默认)按本地配置开关
+ * - http立即拉取一次开关文档，之后在后台定时拉取；首次拉取失败不影响启动，在拉取成功之前按本地配置
+ * - 替换来源前停止之前的http来源
+ */
+func Init(cfg *config.FeatureFlagsConfig) error {
+	switch cfg.Provider {
+	case "", config.FeatureFlagsStatic:
+		Stop()
+		Use(nil)
+	case config.FeatureFlagsHTTP:
+		if cfg.Url == "" {
+			return fmt.Errorf("featureFlags: provider '%s' requires url", cfg.Provider)
+		}
+		p := NewHTTPProvider(cfg)
+		p.Start()
+		Stop()
+		Use(p)
+	default:
+		return fmt.Errorf("featureFlags: unknown provider '%s'", cfg.Provider)
+	}
+	return nil
+}
+
+// 停止当前来源的后台拉取，服务关闭时调用
+func Stop() {
+	if p, ok := Current().(interface{ Stop() }); ok {
+		p.Stop()
+	}
+}
@@ -0,0 +1,201 @@
+package feature_flag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test the targeting rules and the percentage rollout
+// go test ./pkg/feature_flag/ -v -run Test_FlagEvaluate
+func Test_FlagEvaluate(t *testing.T) {
+	f := Flag{Rollout: 0, Rules: []Rule{
+		{Attribute: AttributeClient, Values: []string{"c-off"}, Enabled: false},
+		{Attribute: AttributeTenant, Values: []string{"beta"}, Enabled: true},
+		{Attribute: AttributeClient, Values: []string{"c-on"}, Enabled: true},
+	}}
+	cases := []struct {
+		client, tenant string
+		want           bool
+	}{
+		{"c-1", "beta", true},
+		{"c-on", "", true},
+		{"c-off", "beta", false}, // 命中的第一条规则决定结果
+		{"c-1", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		if got := f.evaluate(FastPath, c.client, c.tenant); got != c.want {
+			t.Errorf("client %q tenant %q: expected %v, got %v", c.client, c.tenant, c.want, got)
+		}
+	}
+
+	// 灰度比例按客户端分桶，同一客户端的结果稳定，比例增大时已开启的客户端保持开启
+	half, most := Flag{Rollout: 50}, Flag{Rollout: 90}
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		client := fmt.Sprintf("client-%d", i)
+		on := half.evaluate(Closer, client, "")
+		if on != half.evaluate(Closer, client, "") {
+			t.Fatalf("%s: expected a stable result", client)
+		}
+		if on && !most.evaluate(Closer, client, "") {
+			t.Fatalf("%s: expected to stay enabled when the rollout grows", client)
+		}
+		if on {
+			enabled++
+		}
+	}
+	if enabled < 400 || enabled > 600 {
+		t.Errorf("expected about half of the clients enabled, got %d/1000", enabled)
+	}
+	if err := (&Flag{Rollout: 120}).validate(); err == nil {
+		t.Error("expected an out of range rollout rejected")
+	}
+	if err := (&Flag{Rules: []Rule{{Attribute: "language"}}}).validate(); err == nil {
+		t.Error("expected an unknown attribute rejected")
+	}
+}
+
+// 可切换状态码、文档内容和ETag的开关文档服务
+type flagServer struct {
+	*httptest.Server
+	status atomic.Int32 // 非0时返回该状态码
+	body   atomic.Value // 文档内容
+	etag   atomic.Value
+	hits   atomic.Int32
+	cached atomic.Int32 // 返回304的次数
+}
+
+func newFlagServer(body string) *flagServer {
+	s := &flagServer{}
+	s.body.Store(body)
+	s.etag.Store(`"v1"`)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		if status := s.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		etag := s.etag.Load().(string)
+		if r.Header.Get("If-None-Match") == etag {
+			s.cached.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(s.body.Load().(string)))
+	}))
+	return s
+}
+
+// to test the polling provider: etag, fallback to the static config and the last known state on failures
+// go test ./pkg/feature_flag/ -v -run Test_HTTPProvider
+func Test_HTTPProvider(t *testing.T) {
+	saved := config.Wrapper.Closer
+	defer func() { config.Wrapper.Closer = saved }()
+	config.Wrapper.Closer.Enabled = true
+
+	server := newFlagServer(`{"flags":{"fastPath":{"rollout":0,"rules":[{"attribute":"tenant","values":["beta"],"enabled":true}]}}}`)
+	defer server.Close()
+	server.status.Store(http.StatusServiceUnavailable)
+	p := NewHTTPProvider(&config.FeatureFlagsConfig{Provider: config.FeatureFlagsHTTP, Url: server.URL})
+
+	// 还没有拉取成功时按本地配置
+	if err := p.Refresh(context.Background()); err == nil {
+		t.Fatal("expected the failed fetch reported")
+	}
+	if !p.IsEnabled(Closer, "c1", "") || p.IsEnabled(FastPath, "c1", "beta") != config.Wrapper.FastPath.Enabled {
+		t.Fatal("expected the static config before the first successful fetch")
+	}
+
+	server.status.Store(0)
+	if err := p.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsEnabled(FastPath, "c1", "beta") || p.IsEnabled(FastPath, "c1", "alpha") {
+		t.Error("expected the tenant targeting of the document")
+	}
+	if !p.IsEnabled(Closer, "c1", "") {
+		t.Error("expected flags missing from the document to follow the static config")
+	}
+	if s := p.Snapshot(); s.Provider != config.FeatureFlagsHTTP || s.ETag != `"v1"` || s.FetchedAt == "" || s.Error != "" ||
+		len(s.Flags[FastPath].Rules) != 1 || s.Flags[Closer].Rollout != 100 {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+
+	// 文档未变化时服务返回304，保持当前状态
+	if err := p.Refresh(context.Background()); err != nil || server.cached.Load() != 1 || !p.IsEnabled(FastPath, "c1", "beta") {
+		t.Errorf("expected the cached document kept, got %v after %d not-modified responses", err, server.cached.Load())
+	}
+
+	// 拉取失败或文档无效时保持上一次的状态
+	server.status.Store(http.StatusInternalServerError)
+	if err := p.Refresh(context.Background()); err == nil || !p.IsEnabled(FastPath, "c1", "beta") {
+		t.Error("expected the last known state kept on a failed fetch")
+	}
+	if s := p.Snapshot(); s.Error == "" {
+		t.Error("expected the failure visible in the snapshot")
+	}
+	server.status.Store(0)
+	server.etag.Store(`"v2"`)
+	server.body.Store(`{"flags":{"fastPath":{"rollout":200}}}`)
+	if err := p.Refresh(context.Background()); err == nil || !p.IsEnabled(FastPath, "c1", "beta") {
+		t.Error("expected the last known state kept on an invalid document")
+	}
+	server.body.Store(`{"flags":{"fastPath":{"rollout":100}}}`)
+	if err := p.Refresh(context.Background()); err != nil || !p.IsEnabled(FastPath, "c1", "alpha") || p.Snapshot().ETag != `"v2"` {
+		t.Errorf("expected the new document applied, got %v", err)
+	}
+
+	// 未知的开关忽略，不出现在快照中
+	server.etag.Store(`"v3"`)
+	server.body.Store(`{"flags":{"fastPath":{"rollout":100},"fastpath":{"rollout":0}}}`)
+	if err := p.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Snapshot().Flags["fastpath"]; ok || !p.IsEnabled(FastPath, "c1", "alpha") {
+		t.Errorf("expected the unknown flag ignored, got %+v", p.Snapshot().Flags)
+	}
+	p.Stop()
+	p.Stop()
+}
+
+// 记录求值次数的开关来源
+type countingProvider struct {
+	StaticProvider
+	calls int
+}
+
+func (p *countingProvider) IsEnabled(flag, clientID, tenantID string) bool {
+	p.calls++
+	return clientID == "c1"
+}
+
+// to test flag evaluations are cached per request
+// go test ./pkg/feature_flag/ -v -run Test_RequestEvaluator
+func Test_RequestEvaluator(t *testing.T) {
+	p := &countingProvider{}
+	Use(p)
+	defer Use(nil)
+
+	ctx := WithContext(context.Background(), ForRequest("c1", ""))
+	for i := 0; i < 3; i++ {
+		if !FromContext(ctx).Enabled(FastPath) {
+			t.Fatal("expected the flag enabled for c1")
+		}
+	}
+	FromContext(ctx).Enabled(Closer)
+	if p.calls != 2 {
+		t.Errorf("expected one evaluation per flag, got %d", p.calls)
+	}
+	// 没有请求的开关时直接向当前来源求值
+	if FromContext(context.Background()).Enabled(FastPath) {
+		t.Error("expected the flag disabled without a client")
+	}
+}
@@ -0,0 +1,207 @@
+package feature_flag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"code-completion/pkg/config"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPollInterval = 30 * time.Second // 没有配置featureFlags.interval时的拉取间隔
+	defaultPollTimeout  = 5 * time.Second  // 没有配置featureFlags.timeout时每次拉取的超时
+	maxDocumentBytes    = 1 << 20          // 开关文档的大小上限
+)
+
+/**
+ * 开关文档
+ * @example
+ * {
+ *   "flags": {
+ *     "fastPath": {"rollout": 50},
+ *     "closer": {"rollout": 0, "rules": [{"attribute": "tenant", "values": ["beta"], "enabled": true}]}
+ *   }
+ * }
+ */
+type document struct {
+	Flags map[string]Flag `json:"flags"`
+}
+
+/**
+ * HTTPProvider 定时拉取开关文档的开关来源
+ * @description
+ * - 带上一次响应的ETag请求(If-None-Match)，304时保持当前状态
+ * - 拉取失败、响应不是200/304或文档无效时保持上一次的状态(fail-static)，记录失败原因
+ * - 还没有拉取成功，或文档中没有的开关，按本地配置
+ * - 文档中的未知开关(不在Names中)记录警告后忽略
+ * - 单次拉取panic时记录调用栈，视为拉取失败，后台拉取继续
+ */
+type HTTPProvider struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mutex     sync.RWMutex
+	flags     map[string]Flag // 最近一次拉取成功的开关，为nil表示还没有成功过
+	etag      string
+	fetchedAt time.Time
+	lastErr   error
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewHTTPProvider(cfg *config.FeatureFlagsConfig) *HTTPProvider {
+	interval, timeout := cfg.Interval, cfg.Timeout
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultPollTimeout
+	}
+	return &HTTPProvider{url: cfg.Url, interval: interval, client: &http.Client{Timeout: timeout},
+		stop: make(chan struct{})}
+}
+
+// 立即拉取一次开关文档，之后在后台定时拉取，直到Stop
+func (p *HTTPProvider) Start() {
+	p.safeRefresh()
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.safeRefresh()
+			}
+		}
+	}()
+	zap.L().Info("Start feature flag polling", zap.String("url", p.url), zap.Duration("interval", p.interval))
+}
+
+// 停止后台拉取，保持最后的状态，可以重复调用
+func (p *HTTPProvider) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// 拉取一次开关文档，panic时记录调用栈并作为本次拉取的错误
+func (p *HTTPProvider) safeRefresh() {
+	defer func() {
+		if r := recover(); r != nil {
+			zap.L().Error("Feature flag polling panic", zap.Any("panic", r), zap.ByteString("stack", debug.Stack()))
+			p.mutex.Lock()
+			p.lastErr = fmt.Errorf("panic: %v", r)
+			p.mutex.Unlock()
+		}
+	}()
+	p.Refresh(context.Background())
+}
+
+/**
+ * 拉取一次开关文档
+ * @param {context.Context} ctx - 控制本次拉取的上下文
+ * @returns {error} 拉取失败或文档无效时返回错误，此时保持上一次的状态
+ */
+func (p *HTTPProvider) Refresh(ctx context.Context) error {
+	flags, etag, err := p.fetch(ctx)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.lastErr = err
+	if err != nil {
+		zap.L().Warn("Fetch feature flags failed, keep the last known state", zap.String("url", p.url), zap.Error(err))
+		return err
+	}
+	if flags != nil {
+		p.flags, p.etag = flags, etag
+	}
+	p.fetchedAt = time.Now()
+	return nil
+}
+
+// 请求开关文档，未变化(304)时返回nil
+func (p *HTTPProvider) fetch(ctx context.Context) (map[string]Flag, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	p.mutex.RLock()
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	p.mutex.RUnlock()
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rsp.Body.Close()
+	switch rsp.StatusCode {
+	case http.StatusNotModified:
+		return nil, "", nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("unexpected status %d", rsp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, maxDocumentBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > maxDocumentBytes {
+		return nil, "", fmt.Errorf("document exceeds %d bytes", maxDocumentBytes)
+	}
+	var doc document
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, "", fmt.Errorf("invalid document: %w", err)
+	}
+	if doc.Flags == nil {
+		doc.Flags = map[string]Flag{}
+	}
+	for name, f := range doc.Flags {
+		if _, ok := staticFlags[name]; !ok {
+			zap.L().Warn("Ignore unknown feature flag", zap.String("url", p.url), zap.String("flag", name))
+			delete(doc.Flags, name)
+			continue
+		}
+		if err := f.validate(); err != nil {
+			return nil, "", fmt.Errorf("flag %s: %w", name, err)
+		}
+	}
+	return doc.Flags, rsp.Header.Get("ETag"), nil
+}
+
+func (p *HTTPProvider) IsEnabled(flag, clientID, tenantID string) bool {
+	p.mutex.RLock()
+	f, ok := p.flags[flag]
+	p.mutex.RUnlock()
+	if !ok {
+		return StaticProvider{}.IsEnabled(flag, clientID, tenantID)
+	}
+	return f.evaluate(flag, clientID, tenantID)
+}
+
+func (p *HTTPProvider) Snapshot() Snapshot {
+	s := StaticProvider{}.Snapshot()
+	s.Provider, s.Url = config.FeatureFlagsHTTP, p.url
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for name, f := range p.flags {
+		s.Flags[name] = f
+	}
+	s.ETag = p.etag
+	if !p.fetchedAt.IsZero() {
+		s.FetchedAt = p.fetchedAt.Format(time.RFC3339)
+	}
+	if p.lastErr != nil {
+		s.Error = p.lastErr.Error()
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package feature_flag
+
+import (
+	"context"
+	"sync"
+)
+
+/**
+ * Evaluator 一个请求的功能开关
+ * @description
+ * - 同一开关在请求内只向来源求值一次，请求处理过程中来源更新不会让前后的判断不一致
+ * - 为nil时直接向当前来源求值(客户端和租户为空)，用于没有请求信息的调用
+ */
+type Evaluator struct {
+	provider Provider
+	clientID string
+	tenantID string
+
+	mutex sync.Mutex
+	cache map[string]bool
+}
+
+// 为客户端(及其租户)的一个请求创建开关，使用当前的开关来源
+func ForRequest(clientID, tenantID string) *Evaluator {
+	return &Evaluator{provider: Current(), clientID: clientID, tenantID: tenantID}
+}
+
+// 开关是否开启
+func (e *Evaluator) Enabled(flag string) bool {
+	if e == nil {
+		return Current().IsEnabled(flag, "", "")
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if enabled, ok := e.cache[flag]; ok {
+		return enabled
+	}
+	if e.cache == nil {
+		e.cache = make(map[string]bool)
+	}
+	enabled := e.provider.IsEnabled(flag, e.clientID, e.tenantID)
+	e.cache[flag] = enabled
+	return enabled
+}
+
+type contextKey struct{}
+
+// 将请求的开关放入ctx，之后的处理阶段(包括模型池中的调用)从ctx中取出
+func WithContext(ctx context.Context, e *Evaluator) context.Context {
+	return context.WithValue(ctx, contextKey{}, e)
+}
+
+// ctx中请求的开关，没有时返回nil
+func FromContext(ctx context.Context) *Evaluator {
+	if ctx == nil {
+		return nil
+	}
+	e, _ := ctx.Value(contextKey{}).(*Evaluator)
+	return e
+}
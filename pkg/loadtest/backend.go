@@ -0,0 +1,167 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/stream_controller"
+	"code-completion/pkg/tokenizers"
+	"code-completion/server"
+
+	"go.uber.org/zap"
+)
+
+// 模拟模型后端的模型名称
+const fakeModelName = "loadtest-fake"
+
+/**
+ * 模拟的OpenAI兼容模型后端
+ * @description
+ * - 等待latency后返回提示词所属模板的补全内容，请求取消时立即返回
+ * - 按模板前缀最后一个占位符之后的文本识别模板，识别不出时返回第一个模板的补全
+ * - 响应使用真实的model.CompletionResponse，与模型客户端共用格式
+ */
+type fakeBackend struct {
+	latency   time.Duration
+	templates []Template
+	markers   []string
+}
+
+func newFakeBackend(latency time.Duration, corpus []Template) *fakeBackend {
+	b := &fakeBackend{latency: latency, templates: corpus}
+	for _, t := range corpus {
+		marker := t.Prefix
+		if i := strings.LastIndex(marker, "}}"); i >= 0 {
+			marker = marker[i+2:]
+		}
+		b.markers = append(b.markers, marker)
+	}
+	return b
+}
+
+// 提示词所属模板的补全内容
+func (b *fakeBackend) completion(prompt string) string {
+	for i, marker := range b.markers {
+		if marker != "" && strings.Contains(prompt, marker) {
+			return b.templates[i].Completion
+		}
+	}
+	return b.templates[0].Completion
+}
+
+func (b *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	select {
+	case <-time.After(b.latency):
+	case <-r.Context().Done():
+		return
+	}
+	text := b.completion(req.Prompt)
+	rsp := model.CompletionResponse{
+		ID:      "fake",
+		Object:  "text_completion",
+		Created: int(time.Now().Unix()),
+		Model:   fakeModelName,
+		Choices: []model.CompletionChoice{{Text: text, FinishReason: "stop"}},
+		Usage: model.CompletionUsage{
+			PromptTokens:     len(req.Prompt) / 4,
+			CompletionTokens: len(text) / 4,
+			TotalTokens:      (len(req.Prompt) + len(text)) / 4,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&rsp)
+}
+
+// 进程内启动的补全服务和模拟模型后端
+type inProcess struct {
+	backend    *http.Server
+	server     *http.Server
+	controller *stream_controller.StreamController
+	url        string
+}
+
+// 在本机的随机端口上启动HTTP服务
+func listen(handler http.Handler) (*http.Server, string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	srv := &http.Server{Handler: handler}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Error("Load test listener stopped", zap.Error(err))
+		}
+	}()
+	return srv, "http://" + ln.Addr().String(), nil
+}
+
+/**
+ * 在进程内启动补全服务，模型替换为模拟后端
+ * @param {*Options} opts - 压测选项，使用其中的模拟模型时延和并发数
+ * @param {[]Template} corpus - 提示词模板，模拟后端按模板返回补全
+ * @returns {*inProcess} 返回服务，压测结束后调用close关闭
+ * @returns {error} 无法监听端口时返回错误
+ * @description
+ * - 没有配置文件时使用各配置的默认值
+ * - 模型配置沿用配置文件中的第一个模型(提示词长度、FIM标记、并发数等)，没有时使用默认值；地址改为模拟后端
+ * - tokenizer无法加载时不按token截断提示词
+ * - 会替换全局的模型配置和流控管理器，只能在独立的进程(压测命令或测试)中使用
+ */
+func startInProcess(opts *Options, corpus []Template) (*inProcess, error) {
+	backend, backendURL, err := listen(newFakeBackend(opts.ModelLatency, corpus))
+	if err != nil {
+		return nil, err
+	}
+	config.ApplyDefaults()
+	cfg := config.ModelConfig{MaxPrefix: 2048, MaxSuffix: 512, MaxOutput: 64}
+	if len(config.Config.Models) > 0 {
+		cfg = config.Config.Models[0]
+	}
+	cfg.Provider, cfg.ModelTitle, cfg.ModelName = "openai", fakeModelName, fakeModelName
+	cfg.CompletionsUrl = backendURL + "/v1/completions"
+	cfg.Authorization, cfg.AuthMode, cfg.ExtractResponse = "", "", false
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = opts.Concurrency
+	}
+	config.Config.Models = []config.ModelConfig{cfg}
+	token, err := tokenizers.Acquire(cfg.TokenizerPath, cfg.ModelTitle)
+	if err != nil {
+		zap.L().Warn("Tokenizer unavailable, prompts are not truncated by tokens", zap.Error(err))
+		token = nil
+	}
+	model.Use([]model.LLM{model.NewOpenAIModel(&config.Config.Models[0], token)})
+
+	sc := stream_controller.NewStreamController(codebase_context.NewContextClient())
+	sc.Init()
+	stream_controller.Controller = sc
+	srv, url, err := listen(server.SetupRouter(server.BuildInfo{Version: "loadtest"}))
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return &inProcess{backend: backend, server: srv, controller: sc, url: url}, nil
+}
+
+// 关闭进程内的服务，返回后不再读取配置
+func (p *inProcess) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.server.Shutdown(ctx)
+	p.backend.Shutdown(ctx)
+	p.controller.Stop()
+}
@@ -0,0 +1,60 @@
+package loadtest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//go:embed corpus.json
+var builtinCorpus []byte
+
+/**
+ * 压测请求的提示词模板
+ * @description
+ * - 文件路径、前缀和后缀中的{{name}}/{{Name}}在生成请求时替换为随机的标识符，使各请求的提示词不同
+ * - Completion是模拟模型后端对该模板返回的补全内容，不替换占位符
+ */
+type Template struct {
+	Language   string `json:"language"`
+	File       string `json:"file"`
+	Prefix     string `json:"prefix"`
+	Suffix     string `json:"suffix"`
+	Completion string `json:"completion"`
+}
+
+// 替换模板中的标识符占位符
+func (t *Template) render(text, name string) string {
+	return strings.NewReplacer("{{name}}", name, "{{Name}}", strings.ToUpper(name[:1])+name[1:]).Replace(text)
+}
+
+/**
+ * 加载提示词模板
+ * @param {string} path - 模板文件(JSON数组)的路径，为空时使用内置的模板
+ * @returns {[]Template} 返回模板列表
+ * @returns {error} 文件无法读取、格式错误或没有模板时返回错误
+ */
+func LoadCorpus(path string) ([]Template, error) {
+	data := builtinCorpus
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var templates []Template
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("invalid corpus '%s': %w", path, err)
+	}
+	for i, t := range templates {
+		if t.Language == "" || t.Prefix == "" {
+			return nil, fmt.Errorf("invalid corpus '%s': template %d requires language and prefix", path, i)
+		}
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("invalid corpus '%s': no templates", path)
+	}
+	return templates, nil
+}
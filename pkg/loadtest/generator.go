@@ -0,0 +1,169 @@
+package loadtest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code-completion/pkg/completions"
+)
+
+// 模板占位符替换的标识符
+var identifiers = []string{"user", "order", "invoice", "session", "report", "cache", "payment", "token", "profile", "metric"}
+
+// Mix 按权重混合的取值，如语言或触发方式
+type Mix map[string]int
+
+/**
+ * 解析混合比例
+ * @param {string} text - 逗号分隔的"取值=权重"，如"go=3,python=1"；省略权重时为1
+ * @returns {Mix} 返回各取值的权重，text为空时返回nil
+ * @returns {error} 权重不是正整数时返回错误
+ */
+func ParseMix(text string) (Mix, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	mix := Mix{}
+	for _, item := range strings.Split(text, ",") {
+		name, weight, found := strings.Cut(strings.TrimSpace(item), "=")
+		w := 1
+		if found {
+			var err error
+			if w, err = strconv.Atoi(weight); err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight of '%s': %s", name, weight)
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid mix '%s'", text)
+		}
+		mix[name] += w
+	}
+	return mix, nil
+}
+
+// 按权重抽样，取值按名称排序，保证相同种子的抽样结果相同
+type weighted struct {
+	names []string
+	total int
+	mix   Mix
+}
+
+func newWeighted(mix Mix) weighted {
+	w := weighted{mix: mix}
+	for name, weight := range mix {
+		w.names = append(w.names, name)
+		w.total += weight
+	}
+	sort.Strings(w.names)
+	return w
+}
+
+func (w weighted) pick(rng *rand.Rand) string {
+	n := rng.Intn(w.total)
+	for _, name := range w.names {
+		if n < w.mix[name] {
+			return name
+		}
+		n -= w.mix[name]
+	}
+	return w.names[len(w.names)-1]
+}
+
+/**
+ * 压测请求的生成器，并发安全
+ * @description
+ * - 生成的请求是真实的completions.CompletionRequest，请求格式变化时压测代码无法编译
+ * - 相同种子和选项生成的请求序列相同，与并发的调度无关(请求内容只取决于序号)
+ * - 客户端从Clients个中随机选择，每个客户端的client_sequence递增
+ */
+type Generator struct {
+	mutex      sync.Mutex
+	rng        *rand.Rand
+	seed       int64
+	seq        int
+	clients    int
+	sequences  map[string]int64
+	languages  weighted
+	triggers   weighted
+	templates  map[string][]Template
+	useContext bool
+}
+
+/**
+ * 创建压测请求的生成器
+ * @param {*Options} opts - 压测选项，使用其中的种子、客户端数、语言和触发方式的混合比例
+ * @param {[]Template} corpus - 提示词模板
+ * @returns {*Generator} 返回生成器
+ * @returns {error} 混合比例中的语言没有模板时返回错误
+ * @description
+ * - 没有指定语言比例时，模板中的各语言等比例出现
+ * - 没有指定触发方式比例时，AUTO与MANUAL为9:1
+ */
+func NewGenerator(opts *Options, corpus []Template) (*Generator, error) {
+	g := &Generator{
+		rng:        rand.New(rand.NewSource(opts.Seed)),
+		seed:       opts.Seed,
+		clients:    max(opts.Clients, 1),
+		sequences:  make(map[string]int64),
+		templates:  make(map[string][]Template),
+		useContext: opts.UseContext,
+	}
+	for _, t := range corpus {
+		g.templates[t.Language] = append(g.templates[t.Language], t)
+	}
+	languages := opts.Languages
+	if len(languages) == 0 {
+		languages = Mix{}
+		for language := range g.templates {
+			languages[language] = 1
+		}
+	}
+	for language := range languages {
+		if len(g.templates[language]) == 0 {
+			return nil, fmt.Errorf("no template of language '%s' in the corpus", language)
+		}
+	}
+	triggers := opts.Triggers
+	if len(triggers) == 0 {
+		triggers = Mix{"AUTO": 9, "MANUAL": 1}
+	}
+	g.languages, g.triggers = newWeighted(languages), newWeighted(triggers)
+	return g, nil
+}
+
+// 生成下一个补全请求
+func (g *Generator) Next() *completions.CompletionRequest {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.seq++
+	templates := g.templates[g.languages.pick(g.rng)]
+	t := templates[g.rng.Intn(len(templates))]
+	name := identifiers[g.rng.Intn(len(identifiers))]
+	clientID := fmt.Sprintf("load-client-%d", g.rng.Intn(g.clients))
+	g.sequences[clientID]++
+	prefix, suffix := t.render(t.Prefix, name), t.render(t.Suffix, name)
+	return &completions.CompletionRequest{
+		LanguageID:     t.Language,
+		ClientID:       clientID,
+		CompletionID:   fmt.Sprintf("load-%d-%d", g.seed, g.seq),
+		TriggerMode:    g.triggers.pick(g.rng),
+		ClientSequence: g.sequences[clientID],
+		DisableContext: !g.useContext,
+		Prompts: &completions.PromptOptions{
+			Prefix:          prefix,
+			Suffix:          suffix,
+			ProjectPath:     "/workspace/" + name,
+			FileProjectPath: t.render(t.File, name),
+		},
+		HideScores: &completions.HiddenScoreOptions{
+			IsWhitespaceAfterCursor: strings.HasPrefix(suffix, "\n"),
+			DocumentLength:          len(prefix) + len(suffix),
+			PromptEndPos:            len(prefix),
+			PreviousLabel:           g.rng.Intn(2),
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package loadtest
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// to test the same seed generates the same request sequence with the configured mixes
+// go test ./pkg/loadtest/ -v -run Test_GeneratorSeed
+func Test_GeneratorSeed(t *testing.T) {
+	corpus, err := LoadCorpus("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Clients: 5, Seed: 42, Languages: Mix{"go": 1, "python": 1}, Triggers: Mix{"MANUAL": 1}}
+	first, _ := NewGenerator(&opts, corpus)
+	second, _ := NewGenerator(&opts, corpus)
+	for i := 0; i < 50; i++ {
+		a, b := first.Next(), second.Next()
+		if !reflect.DeepEqual(a, b) {
+			t.Fatalf("request %d differs with the same seed: %+v %+v", i, a, b)
+		}
+		if a.LanguageID != "go" && a.LanguageID != "python" || a.TriggerMode != "MANUAL" {
+			t.Errorf("unexpected request outside the mix: %s %s", a.LanguageID, a.TriggerMode)
+		}
+		if strings.Contains(a.Prompts.Prefix, "{{") {
+			t.Errorf("placeholder not rendered: %q", a.Prompts.Prefix)
+		}
+	}
+	if _, err := NewGenerator(&Options{Clients: 1, Languages: Mix{"cobol": 1}}, corpus); err == nil {
+		t.Error("expected an error for a language without templates")
+	}
+	if mix, err := ParseMix("go=3, python"); err != nil || mix["go"] != 3 || mix["python"] != 1 {
+		t.Errorf("unexpected mix %v %v", mix, err)
+	}
+}
+
+// to test the smoke mode runs the in-process server against the fake model and reports the latencies
+// go test ./pkg/loadtest/ -v -run Test_LoadSmoke
+func Test_LoadSmoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	saved := *config.Config
+	defer func() { *config.Config = saved }()
+
+	report, err := Run(context.Background(), SmokeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || report.Statuses["success"] == 0 {
+		t.Fatalf("expected successful requests, got %d %v", report.Requests, report.Statuses)
+	}
+	if report.Statuses[StatusTransportError] != 0 {
+		t.Errorf("unexpected transport errors %v", report.Statuses)
+	}
+	if p := report.Percentile(PhaseLLM, 100); p < 5*time.Millisecond {
+		t.Errorf("expected the model latency in the llm phase, got %s", p)
+	}
+	var out strings.Builder
+	report.Print(&out)
+	if !strings.Contains(out.String(), "p99") || report.Resources == nil {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,122 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 报告中统计耗时的阶段
+const (
+	PhaseClient  = "client"  // 客户端测得的请求耗时
+	PhaseQueue   = "queue"   // 服务端排队耗时(usage.queue_duration)
+	PhaseContext = "context" // 获取代码库上下文的耗时(usage.context_duration)
+	PhaseLLM     = "llm"     // 调用模型的耗时(usage.llm_duration)
+	PhaseServer  = "server"  // 服务端总耗时(usage.total_duration)
+)
+
+// 请求失败(连接错误、超时)的状态
+const StatusTransportError = "transport_error"
+
+// 报告中各阶段的顺序
+var phases = []string{PhaseClient, PhaseQueue, PhaseContext, PhaseLLM, PhaseServer}
+
+// 内存和协程数的增长，只在进程内压测时统计
+type ResourceGrowth struct {
+	HeapBefore       uint64
+	HeapAfter        uint64
+	GoroutinesBefore int
+	GoroutinesAfter  int
+}
+
+/**
+ * 压测报告
+ * @description
+ * - Statuses: 按补全状态统计的请求数，另有transport_error和http_<状态码>
+ * - 各阶段的耗时只统计有该阶段数据的请求，空补全(204)只有客户端耗时
+ */
+type Report struct {
+	Target    string
+	Requests  int
+	Elapsed   time.Duration
+	Statuses  map[string]int
+	Resources *ResourceGrowth
+
+	mutex     sync.Mutex
+	latencies map[string][]time.Duration
+}
+
+func newReport(target string) *Report {
+	return &Report{Target: target, Statuses: map[string]int{}, latencies: map[string][]time.Duration{}}
+}
+
+func (r *Report) add(s sample) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Requests++
+	r.Statuses[s.status]++
+	for phase, d := range s.phases {
+		r.latencies[phase] = append(r.latencies[phase], d)
+	}
+}
+
+/**
+ * 计算阶段耗时的百分位
+ * @param {string} phase - 阶段，见Phase*常量
+ * @param {float64} p - 百分位(0-100)
+ * @returns {time.Duration} 返回耗时，没有数据时返回0
+ */
+func (r *Report) Percentile(phase string, p float64) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	values := append([]time.Duration(nil), r.latencies[phase]...)
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(a, b int) bool { return values[a] < values[b] })
+	idx := int(p / 100 * float64(len(values)-1))
+	return values[min(max(idx, 0), len(values)-1)]
+}
+
+// 输出压测报告
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Target: %s\n", r.Target)
+	rate := 0.0
+	if r.Elapsed > 0 {
+		rate = float64(r.Requests) / r.Elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "Requests: %d in %s (%.1f req/s)\n", r.Requests, r.Elapsed.Round(time.Millisecond), rate)
+
+	fmt.Fprintf(w, "\n%-10s %8s %10s %10s %10s %10s\n", "phase", "count", "p50", "p90", "p99", "max")
+	for _, phase := range phases {
+		r.mutex.Lock()
+		count := len(r.latencies[phase])
+		r.mutex.Unlock()
+		if count == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%-10s %8d %10s %10s %10s %10s\n", phase, count,
+			r.Percentile(phase, 50), r.Percentile(phase, 90), r.Percentile(phase, 99), r.Percentile(phase, 100))
+	}
+
+	statuses := make([]string, 0, len(r.Statuses))
+	for status := range r.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	fmt.Fprintf(w, "\n%-20s %8s\n", "status", "count")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%-20s %8d\n", status, r.Statuses[status])
+	}
+
+	if g := r.Resources; g != nil {
+		fmt.Fprintf(w, "\nHeap: %.1fMB -> %.1fMB (%+.1fMB)\n", mb(g.HeapBefore), mb(g.HeapAfter), mb(g.HeapAfter)-mb(g.HeapBefore))
+		fmt.Fprintf(w, "Goroutines: %d -> %d (%+d)\n", g.GoroutinesBefore, g.GoroutinesAfter, g.GoroutinesAfter-g.GoroutinesBefore)
+	}
+}
+
+func mb(bytes uint64) float64 {
+	return float64(bytes) / (1 << 20)
+}
@@ -0,0 +1,225 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"code-completion/pkg/completions"
+)
+
+// 压测请求的补全接口
+const completionsPath = "/code-completion/api/v1/completions"
+
+/**
+ * 压测选项
+ * @description
+ * - Target为空时在进程内启动补全服务和模拟模型后端，否则压测该地址的服务
+ * - Rate为0时不限制请求速率，各并发连续发送请求
+ * - 内存和协程数的增长只在进程内压测时统计
+ */
+type Options struct {
+	Target       string        // 压测的服务地址，如http://127.0.0.1:8080
+	Duration     time.Duration // 压测时长
+	Concurrency  int           // 并发数
+	Rate         float64       // 每秒最大请求数
+	Clients      int           // 模拟的客户端数
+	Languages    Mix           // 语言的混合比例
+	Triggers     Mix           // 触发方式(AUTO/MANUAL)的混合比例
+	Seed         int64         // 随机数种子，相同种子生成相同的请求序列
+	Corpus       string        // 提示词模板文件，为空时使用内置模板
+	ModelLatency time.Duration // 模拟模型后端的时延，只用于进程内压测
+	UseContext   bool          // 是否获取代码库上下文，默认不获取(需要可用的检索服务)
+	Timeout      time.Duration // 单个请求的超时时间
+}
+
+// 冒烟模式的选项：进程内、短时间、低并发，用于测试
+func SmokeOptions() Options {
+	return Options{
+		Duration:     300 * time.Millisecond,
+		Concurrency:  2,
+		Clients:      3,
+		Seed:         1,
+		ModelLatency: 5 * time.Millisecond,
+		Timeout:      5 * time.Second,
+	}
+}
+
+// 校验选项
+func (o *Options) validate() error {
+	switch {
+	case o.Duration <= 0:
+		return fmt.Errorf("duration must be positive")
+	case o.Concurrency <= 0:
+		return fmt.Errorf("concurrency must be positive")
+	case o.Clients <= 0:
+		return fmt.Errorf("clients must be positive")
+	case o.Rate < 0:
+		return fmt.Errorf("rate must not be negative")
+	}
+	return nil
+}
+
+// 一次请求的结果
+type sample struct {
+	status string
+	phases map[string]time.Duration
+}
+
+/**
+ * 运行压测
+ * @param {context.Context} ctx - 取消时停止压测，进行中的请求被中止
+ * @param {Options} opts - 压测选项
+ * @returns {*Report} 返回压测报告
+ * @returns {error} 选项无效、模板无法加载或进程内服务无法启动时返回错误
+ * @description
+ * - 到达时长后不再发出新请求，等待进行中的请求完成
+ * - 请求失败(连接错误、超时)按transport_error计数，非补全格式的响应按http_<状态码>计数
+ */
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	corpus, err := LoadCorpus(opts.Corpus)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := NewGenerator(&opts, corpus)
+	if err != nil {
+		return nil, err
+	}
+	target := strings.TrimRight(opts.Target, "/")
+	var env *inProcess
+	if target == "" {
+		if env, err = startInProcess(&opts, corpus); err != nil {
+			return nil, err
+		}
+		defer env.close()
+		target = env.url
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+	report := newReport(target)
+	var before resources
+	if env != nil {
+		before = snapshotResources()
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	tokens := pace(runCtx, opts.Rate)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if tokens != nil {
+					if _, ok := <-tokens; !ok {
+						return
+					}
+				} else if runCtx.Err() != nil {
+					return
+				}
+				report.add(send(ctx, client, target, gen.Next()))
+			}
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	if env != nil {
+		client.CloseIdleConnections()
+		after := snapshotResources()
+		report.Resources = &ResourceGrowth{
+			HeapBefore: before.heap, HeapAfter: after.heap,
+			GoroutinesBefore: before.goroutines, GoroutinesAfter: after.goroutines,
+		}
+	}
+	return report, nil
+}
+
+// 按速率发放请求令牌，ctx结束时关闭；rate为0时不限制，返回nil
+func pace(ctx context.Context, rate float64) <-chan struct{} {
+	if rate <= 0 {
+		return nil
+	}
+	tokens := make(chan struct{})
+	go func() {
+		defer close(tokens)
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			default: // 所有并发都忙，丢弃该令牌
+			}
+		}
+	}()
+	return tokens
+}
+
+// 发送一个补全请求，记录状态和各阶段的耗时
+func send(ctx context.Context, client *http.Client, target string, req *completions.CompletionRequest) sample {
+	body, _ := json.Marshal(req)
+	start := time.Now()
+	s := sample{status: StatusTransportError, phases: map[string]time.Duration{}}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target+completionsPath, bytes.NewReader(body))
+	if err != nil {
+		return s
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	rsp, err := client.Do(httpReq)
+	if err != nil {
+		return s
+	}
+	defer rsp.Body.Close()
+	data, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return s
+	}
+	s.phases[PhaseClient] = time.Since(start)
+	if rsp.StatusCode == http.StatusNoContent {
+		s.status = "empty"
+		return s
+	}
+	var out completions.CompletionResponse
+	if err := json.Unmarshal(data, &out); err != nil || out.Status == "" {
+		s.status = fmt.Sprintf("http_%d", rsp.StatusCode)
+		return s
+	}
+	s.status = string(out.Status)
+	s.phases[PhaseQueue] = time.Duration(out.Usage.QueueDuration) * time.Millisecond
+	s.phases[PhaseContext] = time.Duration(out.Usage.ContextDuration) * time.Millisecond
+	s.phases[PhaseLLM] = time.Duration(out.Usage.LLMDuration) * time.Millisecond
+	s.phases[PhaseServer] = time.Duration(out.Usage.TotalDuration) * time.Millisecond
+	return s
+}
+
+// 进程的内存和协程数
+type resources struct {
+	heap       uint64
+	goroutines int
+}
+
+// GC后统计堆内存和协程数
+func snapshotResources() resources {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return resources{heap: m.HeapAlloc, goroutines: runtime.NumGoroutine()}
+}
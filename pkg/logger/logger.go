@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"context"
 	"time"
 
 	"go.uber.org/zap"
@@ -107,3 +108,20 @@ func Panic(msg string, fields ...zap.Field) {
 func With(fields ...zap.Field) *zap.Logger {
 	return Logger.With(fields...)
 }
+
+type loggerKey struct{}
+
+// WithContext 将请求级 logger 放入上下文，供下游组件使用
+func WithContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext 获取上下文中的请求级 logger，没有时返回全局 logger
+func FromContext(ctx context.Context) *zap.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok && l != nil {
+			return l
+		}
+	}
+	return zap.L()
+}
@@ -0,0 +1,278 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//
+//	指标改名的兼容层: 改名的指标总是以新名称输出，弃用期内同时以旧名称输出
+//	新名称使用规范的单位后缀(_milliseconds/_total)，状态等标签的取值统一为小写下划线格式
+//	Record*/Increment*只调用renamed*的方法，由这里决定输出哪些序列
+//
+
+/**
+ * 一个改名的指标，供看板迁移参考
+ * @description
+ * - Old/New: 旧名称和新名称
+ * - Type: 指标类型(counter/gauge/histogram)
+ * - Labels: 标签，新旧指标相同
+ * - Normalized: 新指标中取值被规范化的标签，如status的modelError变为model_error
+ */
+type MetricRename struct {
+	Old        string   `json:"old"`
+	New        string   `json:"new"`
+	Type       string   `json:"type"`
+	Labels     []string `json:"labels"`
+	Normalized []string `json:"normalized,omitempty"`
+}
+
+var (
+	renames          []MetricRename         // 所有改名的指标，按定义顺序
+	legacyCollectors []prometheus.Collector // 旧名称的指标
+	legacyEnabled    atomic.Bool            // 是否同时输出旧名称的指标
+	legacyGeneration atomic.Uint64          // 切换旧名称输出的次数，缓存的序列在切换后失效
+	legacyMutex      sync.Mutex             // 串行化旧名称指标的注册和注销
+)
+
+// 默认处于弃用期，同时输出新旧两套指标
+func init() {
+	legacyEnabled.Store(true)
+	for _, c := range legacyCollectors {
+		prometheus.MustRegister(c)
+	}
+}
+
+// 改名的Counter
+type renamedCounter struct {
+	current   *prometheus.CounterVec
+	legacy    *prometheus.CounterVec
+	normalize []bool // 新指标中需要规范化取值的标签
+}
+
+// 改名的Gauge
+type renamedGauge struct {
+	current   *prometheus.GaugeVec
+	legacy    *prometheus.GaugeVec
+	normalize []bool
+}
+
+// 改名的Histogram
+type renamedHistogram struct {
+	current   *prometheus.HistogramVec
+	legacy    *prometheus.HistogramVec
+	normalize []bool
+}
+
+/**
+ * 定义改名的指标，记录新旧名称的对应关系
+ * @param {string} typ - 指标类型
+ * @param {string} oldName - 旧名称
+ * @param {string} newName - 新名称
+ * @param {[]string} labels - 标签
+ * @param {[]string} normalized - 新指标中取值需要规范化的标签
+ * @returns {[]bool} 返回每个标签是否需要规范化
+ */
+func defineRename(typ, oldName, newName string, labels, normalized []string) []bool {
+	renames = append(renames, MetricRename{Old: oldName, New: newName, Type: typ,
+		Labels: labels, Normalized: normalized})
+	flags := make([]bool, len(labels))
+	for i, label := range labels {
+		for _, n := range normalized {
+			flags[i] = flags[i] || n == label
+		}
+	}
+	return flags
+}
+
+// 定义改名的Counter，opts.Name为新名称
+func newRenamedCounter(opts prometheus.CounterOpts, oldName string, labels []string, normalized ...string) *renamedCounter {
+	m := &renamedCounter{normalize: defineRename("counter", oldName, opts.Name, labels, normalized)}
+	m.current = promauto.NewCounterVec(opts, labels)
+	opts.Name, opts.Help = oldName, deprecatedHelp(opts.Name, opts.Help)
+	m.legacy = prometheus.NewCounterVec(opts, labels)
+	legacyCollectors = append(legacyCollectors, m.legacy)
+	return m
+}
+
+// 定义改名的Gauge，opts.Name为新名称
+func newRenamedGauge(opts prometheus.GaugeOpts, oldName string, labels []string, normalized ...string) *renamedGauge {
+	m := &renamedGauge{normalize: defineRename("gauge", oldName, opts.Name, labels, normalized)}
+	m.current = promauto.NewGaugeVec(opts, labels)
+	opts.Name, opts.Help = oldName, deprecatedHelp(opts.Name, opts.Help)
+	m.legacy = prometheus.NewGaugeVec(opts, labels)
+	legacyCollectors = append(legacyCollectors, m.legacy)
+	return m
+}
+
+// 定义改名的Histogram，opts.Name为新名称
+func newRenamedHistogram(opts prometheus.HistogramOpts, oldName string, labels []string, normalized ...string) *renamedHistogram {
+	m := &renamedHistogram{normalize: defineRename("histogram", oldName, opts.Name, labels, normalized)}
+	m.current = promauto.NewHistogramVec(opts, labels)
+	opts.Name, opts.Help = oldName, deprecatedHelp(opts.Name, opts.Help)
+	m.legacy = prometheus.NewHistogramVec(opts, labels)
+	legacyCollectors = append(legacyCollectors, m.legacy)
+	return m
+}
+
+// 旧名称指标的说明，指向新名称
+func deprecatedHelp(newName, help string) string {
+	return "Deprecated, use " + newName + " instead. " + help
+}
+
+func (m *renamedCounter) inc(labels ...string) {
+	m.current.WithLabelValues(normalizeLabels(m.normalize, labels)...).Inc()
+	if legacyEnabled.Load() {
+		m.legacy.WithLabelValues(labels...).Inc()
+	}
+}
+
+func (m *renamedGauge) set(value float64, labels ...string) {
+	m.current.WithLabelValues(normalizeLabels(m.normalize, labels)...).Set(value)
+	if legacyEnabled.Load() {
+		m.legacy.WithLabelValues(labels...).Set(value)
+	}
+}
+
+func (m *renamedHistogram) observe(value float64, labels ...string) {
+	m.current.WithLabelValues(normalizeLabels(m.normalize, labels)...).Observe(value)
+	if legacyEnabled.Load() {
+		m.legacy.WithLabelValues(labels...).Observe(value)
+	}
+}
+
+/**
+ * 改名的Histogram中一组标签取值的序列，查找一次后可以多次记录
+ * @description
+ * - legacy在不输出旧名称时为nil
+ * - generation为查找时的legacyGeneration，切换旧名称输出后需要重新查找
+ */
+type histogramSeries struct {
+	current    prometheus.Observer
+	legacy     prometheus.Observer
+	generation uint64
+}
+
+// 查找一组标签取值的序列
+func (m *renamedHistogram) series(labels ...string) histogramSeries {
+	s := histogramSeries{generation: legacyGeneration.Load()}
+	s.current = m.current.WithLabelValues(normalizeLabels(m.normalize, labels)...)
+	if legacyEnabled.Load() {
+		s.legacy = m.legacy.WithLabelValues(labels...)
+	}
+	return s
+}
+
+func (s histogramSeries) observe(value float64) {
+	s.current.Observe(value)
+	if s.legacy != nil {
+		s.legacy.Observe(value)
+	}
+}
+
+// 记录观测值并附带exemplar，只有新名称的序列记录exemplar
+func (s histogramSeries) observeWithExemplar(value float64, exemplar prometheus.Labels) {
+	if eo, ok := s.current.(prometheus.ExemplarObserver); ok {
+		eo.ObserveWithExemplar(value, exemplar)
+	} else {
+		s.current.Observe(value)
+	}
+	if s.legacy != nil {
+		s.legacy.Observe(value)
+	}
+}
+
+// 规范化需要规范化的标签取值，返回新的切片
+func normalizeLabels(normalize []bool, labels []string) []string {
+	values := make([]string, len(labels))
+	for i, v := range labels {
+		if i < len(normalize) && normalize[i] {
+			v = NormalizeLabelValue(v)
+		}
+		values[i] = v
+	}
+	return values
+}
+
+/**
+ * 把标签取值规范化为小写下划线格式
+ * @param {string} value - 原始取值
+ * @returns {string} 返回规范化后的取值
+ * @example
+ * NormalizeLabelValue("modelError")      // "model_error"
+ * NormalizeLabelValue("HTTPError")       // "http_error"
+ * NormalizeLabelValue("raise-threshold") // "raise_threshold"
+ */
+func NormalizeLabelValue(value string) string {
+	var sb strings.Builder
+	runes := []rune(value)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			// 小写或数字后的大写、以及连续大写中最后一个(如HTTPError的E)开始一个新单词
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) &&
+				runes[i-1] != '_' && runes[i-1] != '-' {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+		case r == '-' || r == ' ' || r == '.':
+			sb.WriteByte('_')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+/**
+ * 设置是否同时输出旧名称的指标
+ * @param {bool} enabled - true为弃用期内的双输出，false只输出新名称
+ * @returns {[]string} 返回仍在输出的旧名称，关闭时为空
+ * @description
+ * - 关闭时从默认注册表注销旧名称的指标并清空已有序列，/metrics不再出现旧名称
+ * - 重新打开时旧名称的序列从0开始
+ */
+func SetLegacyNames(enabled bool) []string {
+	legacyMutex.Lock()
+	defer legacyMutex.Unlock()
+
+	if legacyEnabled.Swap(enabled) != enabled {
+		legacyGeneration.Add(1)
+		for _, c := range legacyCollectors {
+			if enabled {
+				prometheus.MustRegister(c)
+			} else {
+				prometheus.Unregister(c)
+				c.(interface{ Reset() }).Reset()
+			}
+		}
+	}
+	if !enabled {
+		return nil
+	}
+	return DeprecatedNames()
+}
+
+// 所有弃用的旧名称
+func DeprecatedNames() []string {
+	names := make([]string, 0, len(renames))
+	for _, r := range renames {
+		names = append(names, r.Old)
+	}
+	return names
+}
+
+// 新旧指标名称的对应关系
+func Renames() []MetricRename {
+	return append([]MetricRename(nil), renames...)
+}
+
+// 当前是否同时输出旧名称的指标
+func LegacyNamesEnabled() bool {
+	return legacyEnabled.Load()
+}
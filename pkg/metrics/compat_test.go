@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 从默认注册表读取各指标的序列标签，key为指标名称
+func gatherLabels(t *testing.T) map[string][]map[string]string {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := make(map[string][]map[string]string)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			series[f.GetName()] = append(series[f.GetName()], labels)
+		}
+	}
+	return series
+}
+
+// 是否有标签name取值为value的序列
+func hasLabelValue(series []map[string]string, name, value string) bool {
+	for _, labels := range series {
+		if labels[name] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// to test renamed metrics are emitted under both names in dual mode and only under the new names when legacy names are disabled
+// go test ./pkg/metrics/ -v -run Test_LegacyNames
+func Test_LegacyNames(t *testing.T) {
+	defer SetLegacyNames(true)
+
+	if deprecated := SetLegacyNames(true); len(deprecated) != len(Renames()) {
+		t.Fatalf("expected %d deprecated names, got %v", len(Renames()), deprecated)
+	}
+	IncrementCompletionRequests("dual-model", "modelError")
+	RecordCompletionDuration("dual-model", "modelError", 1, 2, 3, 6)
+	UpdateCompletionConcurrent(3)
+	UpdateCompletionConcurrentByModel("dual-model", 3)
+	RecordContextFetchDuration("found", 20)
+	IncrementFileKind("source", "success")
+	families := gatherLabels(t)
+	for _, r := range Renames() {
+		if _, ok := families[r.Old]; !ok {
+			t.Errorf("dual mode: legacy metric %s not emitted", r.Old)
+		}
+		if _, ok := families[r.New]; !ok {
+			t.Errorf("dual mode: metric %s not emitted", r.New)
+		}
+	}
+	if !hasLabelValue(families["completion_requests_total"], "status", "modelError") {
+		t.Errorf("dual mode: legacy series should keep the raw status")
+	}
+	if !hasLabelValue(families["completion_responses_total"], "status", "model_error") {
+		t.Errorf("dual mode: new series should use the normalized status")
+	}
+	if !hasLabelValue(families["completion_duration_milliseconds"], "status", "model_error") {
+		t.Errorf("dual mode: completion_duration_milliseconds not emitted")
+	}
+
+	if deprecated := SetLegacyNames(false); len(deprecated) != 0 {
+		t.Fatalf("expected no deprecated names when disabled, got %v", deprecated)
+	}
+	IncrementCompletionRequests("new-model", "reqError")
+	IncrementFileKind("test", "reqError")
+	UpdateCompletionConcurrent(2)
+	families = gatherLabels(t)
+	for _, r := range Renames() {
+		if _, ok := families[r.Old]; ok {
+			t.Errorf("legacy disabled: %s should not be emitted", r.Old)
+		}
+	}
+	if !hasLabelValue(families["completion_responses_total"], "status", "req_error") {
+		t.Errorf("legacy disabled: new series not emitted")
+	}
+	if !hasLabelValue(families["completion_file_kind_responses_total"], "status", "req_error") {
+		t.Errorf("legacy disabled: completion_file_kind_responses_total not emitted")
+	}
+	if _, ok := families["completion_concurrent_requests"]; !ok {
+		t.Errorf("legacy disabled: completion_concurrent_requests not emitted")
+	}
+}
+
+// to test label values are normalized to lower snake case
+// go test ./pkg/metrics/ -v -run Test_NormalizeLabelValue
+func Test_NormalizeLabelValue(t *testing.T) {
+	cases := map[string]string{
+		"success":         "success",
+		"modelError":      "model_error",
+		"HTTPError":       "http_error",
+		"raise-threshold": "raise_threshold",
+		"unknown_field":   "unknown_field",
+	}
+	for in, want := range cases {
+		if got := NormalizeLabelValue(in); got != want {
+			t.Errorf("NormalizeLabelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
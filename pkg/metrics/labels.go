@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//
+//	标签取值的白名单: model和status的取值来自请求和模型后端，未知的取值统一记为other，避免序列数无限增长
+//
+
+// 不在白名单中的标签取值
+const LabelOther = "other"
+
+// model标签最多的取值数，配置的模型在启动时登记，之后出现的新名称在上限内先到先得
+const MaxModelLabels = 32
+
+// 补全状态，与model.CompletionStatus一致
+var knownStatuses = map[string]bool{
+	"success":      true,
+	"reqError":     true,
+	"serverError":  true,
+	"modelError":   true,
+	"empty":        true,
+	"rejected":     true,
+	"timeout":      true,
+	"canceled":     true,
+	"busy":         true,
+	"unauthorized": true,
+
+	"deliveredFailed": true,
+}
+
+var (
+	modelLabels sync.Map     // 已接受的模型名称 -> struct{}
+	modelCount  atomic.Int32 // 已接受的模型名称数
+)
+
+// 回退到语言族的language标签最多的取值数，先到先得
+const MaxFallbackLanguageLabels = 64
+
+var (
+	fallbackLanguages     sync.Map     // 已接受的回退语言 -> struct{}
+	fallbackLanguageCount atomic.Int32 // 已接受的回退语言数
+)
+
+// 登记配置的模型名称，登记的名称优先占用model标签的取值
+func RegisterModels(models ...string) {
+	for _, model := range models {
+		modelLabel(model)
+	}
+}
+
+// model标签的取值，超过MaxModelLabels的新名称记为other
+func modelLabel(model string) string {
+	if _, ok := modelLabels.Load(model); ok {
+		return model
+	}
+	if modelCount.Add(1) > MaxModelLabels {
+		modelCount.Add(-1)
+		return LabelOther
+	}
+	if _, loaded := modelLabels.LoadOrStore(model, struct{}{}); loaded {
+		modelCount.Add(-1)
+	}
+	return model
+}
+
+// 回退语言的language标签的取值，超过MaxFallbackLanguageLabels的新取值记为other
+func fallbackLanguageLabel(language string) string {
+	if _, ok := fallbackLanguages.Load(language); ok {
+		return language
+	}
+	if fallbackLanguageCount.Add(1) > MaxFallbackLanguageLabels {
+		fallbackLanguageCount.Add(-1)
+		return LabelOther
+	}
+	if _, loaded := fallbackLanguages.LoadOrStore(language, struct{}{}); loaded {
+		fallbackLanguageCount.Add(-1)
+	}
+	return language
+}
+
+// status标签的取值，不是补全状态的记为other
+func statusLabel(status string) string {
+	if knownStatuses[status] {
+		return status
+	}
+	return LabelOther
+}
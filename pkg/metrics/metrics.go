@@ -2,7 +2,9 @@ package metrics
 
 import (
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -10,13 +12,13 @@ import (
 )
 
 var (
-	completionDurations = promauto.NewHistogramVec(
+	completionDurations = newRenamedHistogram(
 		prometheus.HistogramOpts{
-			Name:    "completion_durations",
+			Name:    "completion_duration_milliseconds",
 			Help:    "Duration of each phase of completion requests in milliseconds",
 			Buckets: []float64{50, 100, 150, 200, 300, 400, 500, 600, 800, 1000, 1200, 1500, 2000, 2500, 3000, 5000},
 		},
-		[]string{"model", "status", "phase"},
+		"completion_durations", []string{"model", "status", "phase"}, "status",
 	)
 
 	// Token数量分布指标 (Histogram)
@@ -29,34 +31,541 @@ var (
 		[]string{"model", "type"},
 	)
 
-	// 请求总数指标 (Counter)
-	completionRequestsTotal = promauto.NewCounterVec(
+	// 请求总数指标，按补全状态统计 (Counter)
+	completionRequestsTotal = newRenamedCounter(
 		prometheus.CounterOpts{
-			Name: "completion_requests_total",
-			Help: "Total number of completion requests",
+			Name: "completion_responses_total",
+			Help: "Total number of completion responses by model and status, counted after the response is written; responses not delivered to the client are counted as deliveredFailed",
 		},
-		[]string{"model", "status"},
+		"completion_requests_total", []string{"model", "status"}, "status",
 	)
 
 	// 瞬时值指标：当前各模型池并发的连接总数
-	completionConcurrent = promauto.NewGauge(
+	completionConcurrent = newRenamedGauge(
 		prometheus.GaugeOpts{
-			Name: "completion_concurrent",
+			Name: "completion_concurrent_requests",
 			Help: "Current total number of concurrent connections across all model pools",
 		},
+		"completion_concurrent", []string{},
 	)
 
 	// 瞬时值指标：各模型池并发的连接数（带model标签）
-	completionConcurrentByModel = promauto.NewGaugeVec(
+	completionConcurrentByModel = newRenamedGauge(
 		prometheus.GaugeOpts{
-			Name: "completion_concurrent_by_model",
+			Name: "completion_model_concurrent_requests",
 			Help: "Current number of concurrent connections per model pool",
 		},
+		"completion_concurrent_by_model", []string{"model"},
+	)
+
+	// 瞬时值指标：各语言当前生效的隐藏分阈值
+	completionScoreThreshold = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_score_threshold",
+			Help: "Current hidden score threshold per language",
+		},
+		[]string{"language"},
+	)
+
+	// 补全被后置处理整体丢弃后的重试次数 (Counter)
+	completionPruneRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_prune_retries_total",
+			Help: "Total number of model retries after pruning discarded the whole completion",
+		},
+		[]string{"model"},
+	)
+
+	// 重试后得到有效补全的次数 (Counter)
+	completionPruneRescues = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_prune_rescues_total",
+			Help: "Total number of prune retries that produced a non-empty completion",
+		},
+		[]string{"model"},
+	)
+
+	// 按请求的和实际生效的修剪模式统计补全次数 (Counter)
+	completionPruneModes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_prune_mode_total",
+			Help: "Total number of completions by requested and effective prune mode",
+		},
+		[]string{"model", "requested", "effective"},
+	)
+
+	// 没有送达客户端的补全，status为补全原本的状态，这些补全在completion_responses_total中计为deliveredFailed (Counter)
+	completionDeliveryFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_delivery_failures_total",
+			Help: "Total number of completions whose response could not be written because the client connection was gone, by model and original status; counted in completion_responses_total as deliveredFailed",
+		},
+		[]string{"model", "status"},
+	)
+
+	// 按路由统计补全请求，outcome为served(实际处理)/attached(等待同一completion_id的在途请求)/replayed(重放已有结果)/redelivered(重放没有送达的结果) (Counter)
+	completionRouteRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_route_requests_total",
+			Help: "Total number of completion requests by route and deduplication outcome",
+		},
+		[]string{"route", "outcome"},
+	)
+
+	// 返回给插件的补全置信度分布，置信度是相对值，不是校准过的概率 (Histogram)
+	completionConfidence = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "completion_confidence",
+			Help:    "Distribution of the confidence of returned completions by language",
+			Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0},
+		},
+		[]string{"language"},
+	)
+
+	// 补全的采纳比例分布，部分采纳时为采纳的字符(或行)占补全的比例 (Histogram)
+	completionAcceptance = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "completion_acceptance_fraction",
+			Help:    "Distribution of the accepted fraction of served completions by model, language and hidden score variant",
+			Buckets: []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0},
+		},
+		[]string{"model", "language", "variant"},
+	)
+
+	// 隐藏分的分布，按隐藏分权重的实验变体 (Histogram)
+	completionHiddenScore = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "completion_hidden_score",
+			Help:    "Distribution of the hidden score of completion requests by weight variant",
+			Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0},
+		},
+		[]string{"variant"},
+	)
+
+	// 无效的部分采纳反馈，reason为无效的原因 (Counter)
+	completionAcceptanceInvalid = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_acceptance_invalid_total",
+			Help: "Total number of ignored partial acceptance feedback by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// 在预处理阶段直接补全闭合符号(不调用模型)的次数 (Counter)
+	completionLocalClosers = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_local_closer_total",
+			Help: "Total number of completions served locally by closing unbalanced delimiters",
+		},
+		[]string{"language"},
+	)
+
+	// 有语法错误的补全按开头区域恢复的结果 (Counter)，outcome: recovered(保留了开头的区域)/discarded(整体丢弃)
+	completionSyntaxRecovery = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_syntax_recovery_total",
+			Help: "Total number of completions with syntax errors partially recovered or fully discarded",
+		},
+		[]string{"language", "outcome"},
+	)
+
+	// 检索请求按结果得到的检索服务状态 (Counter)，state: ok/not_indexed/unauthorized/timeout/error
+	completionContextProviderStates = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_context_provider_state_total",
+			Help: "Total number of codebase context searches by provider and the provider state derived from the response",
+		},
+		[]string{"provider", "state"},
+	)
+
+	// 计数器指标：严格截止时间模式的请求，outcome为met/hit，hit时phase为看门狗触发时所处的阶段
+	completionStrictDeadline = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_strict_deadline_total",
+			Help: "Total number of strict deadline completions by outcome (met/hit) and the phase interrupted by the watchdog",
+		},
+		[]string{"outcome", "phase"},
+	)
+
+	// 计数器指标：检索到的片段中被过滤的提示词注入内容，pattern为配置的规则名称
+	completionContextSanitized = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_context_sanitized_total",
+			Help: "Total number of retrieved context snippets neutralized by the prompt injection filter, by provider and pattern",
+		},
+		[]string{"provider", "pattern"},
+	)
+
+	// 瞬时值指标：各模型出站限流令牌桶的可用令牌数
+	completionRateTokens = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_rate_tokens",
+			Help: "Current number of available tokens in the outbound rate limiter per model",
+		},
+		[]string{"model"},
+	)
+
+	// 因出站限流而快速失败的请求数 (Counter)
+	completionThrottled = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_throttled_total",
+			Help: "Total number of completion requests failed fast by the outbound rate limiter",
+		},
+		[]string{"model"},
+	)
+
+	// 瞬时值指标：各模型的token数校准系数(上游报告的提示词token数/本地计算的token数)
+	completionTokenCalibration = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_token_calibration_factor",
+			Help: "Current factor between the upstream reported and the locally counted prompt tokens per model",
+		},
+		[]string{"model"},
+	)
+
+	// 上游报告提示词超过上下文长度的请求数 (Counter)，retried: 是否收紧预算后重试
+	completionContextLengthErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_context_length_errors_total",
+			Help: "Total number of completions rejected upstream for exceeding the context length",
+		},
+		[]string{"model", "retried"},
+	)
+
+	// 被取消或超时的补全请求数 (Counter)，cause: 取消的原因，见completions.CancelCause
+	completionCancellations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_cancellations_total",
+			Help: "Total number of canceled or timed out completions by model and cause; already counted in completion_responses_total as canceled or timeout",
+		},
+		[]string{"model", "cause"},
+	)
+
+	// 插件告知作废的补全数 (Counter)，state: canceled(在途，已取消)/discarded(已完成，丢弃结果)/unknown(没有该请求)
+	completionInvalidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_invalidations_total",
+			Help: "Total number of completions invalidated by the plugin after the cursor moved, by state of the completion",
+		},
+		[]string{"state"},
+	)
+
+	// 回退到语言族的补全请求数 (Counter)，用于发现值得单独支持的语言，source: profile/invalid_id/extension/content/default
+	completionLanguageFallbacks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_language_fallbacks_total",
+			Help: "Total number of completion requests whose language fell back to a language family",
+		},
+		[]string{"language", "family", "source"},
+	)
+
+	// 按旧版Python服务格式返回的补全数 (Counter)，用于确认兼容模式的用量归零后下线，status为标准的补全状态
+	completionLegacyResponses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_legacy_responses_total",
+			Help: "Total number of completion responses returned in the deprecated legacy format",
+		},
+		[]string{"model", "status"},
+	)
+
+	// 瞬时值指标：各内存存储的条目数
+	storeEntries = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_store_entries",
+			Help: "Current number of entries in each in-memory store",
+		},
+		[]string{"store"},
+	)
+
+	// 瞬时值指标：各内存存储估算的字节数
+	storeBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_store_bytes",
+			Help: "Estimated size in bytes of each in-memory store",
+		},
+		[]string{"store"},
+	)
+
+	// 内存存储淘汰条目的次数 (Counter)
+	storeEvictions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_store_evictions_total",
+			Help: "Total number of entries evicted from each in-memory store by reason",
+		},
+		[]string{"store", "reason"},
+	)
+
+	// 内存存储因内容过期而作废条目的次数，与淘汰分开统计 (Counter)
+	storeInvalidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_store_invalidations_total",
+			Help: "Total number of entries invalidated in each in-memory store because the source changed",
+		},
+		[]string{"store", "reason"},
+	)
+
+	// 丢弃的不合格检索结果条数 (Counter)
+	completionContextInvalidItems = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_context_invalid_items_total",
+			Help: "Total number of codebase search items dropped by validation, by provider and reason",
+		},
+		[]string{"provider", "reason"},
+	)
+
+	// 因不合格结果过多而作废的检索结果次数 (Counter)
+	completionContextRejectedResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_context_rejected_results_total",
+			Help: "Total number of codebase search results discarded because too many items were invalid, by provider",
+		},
+		[]string{"provider"},
+	)
+
+	// 代码上下文获取结果的次数 (Counter)
+	completionContextFetches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_context_fetches_total",
+			Help: "Total number of codebase context fetches by outcome (found/empty/skipped/provided)",
+		},
+		[]string{"outcome"},
+	)
+
+	// 代码上下文获取耗时分布，outcome为empty的耗时即浪费的时延 (Histogram)
+	completionContextDurations = newRenamedHistogram(
+		prometheus.HistogramOpts{
+			Name:    "completion_context_fetch_duration_milliseconds",
+			Help:    "Duration of codebase context fetches in milliseconds by outcome",
+			Buckets: []float64{10, 25, 50, 100, 150, 200, 300, 500, 800, 1000},
+		},
+		"completion_context_fetch_durations", []string{"outcome"},
+	)
+
+	// 成功补全的总耗时，mode为代码上下文的使用方式(used/skipped_pending/cache_hit)，用于比较渐进式上下文的时延 (Histogram)
+	completionContextModeDurations = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "completion_context_mode_duration_milliseconds",
+			Help:    "Total duration of successful completions in milliseconds by how the codebase context was used",
+			Buckets: []float64{50, 100, 150, 200, 300, 400, 500, 600, 800, 1000, 1500, 2000},
+		},
+		[]string{"mode"},
+	)
+
+	// 返回的补全被采纳的比例，mode为代码上下文的使用方式，用于比较渐进式上下文的采纳率 (Histogram)
+	completionContextModeAcceptance = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "completion_context_mode_acceptance_fraction",
+			Help:    "Distribution of the accepted fraction of served completions by how the codebase context was used",
+			Buckets: []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0},
+		},
+		[]string{"mode"},
+	)
+
+	// 按文件类型统计的补全请求，kind为test/source，status为补全状态 (Counter)
+	completionFileKinds = newRenamedCounter(
+		prometheus.CounterOpts{
+			Name: "completion_file_kind_responses_total",
+			Help: "Total number of completion requests by file kind (test/source) and status",
+		},
+		"completion_file_kind_total", []string{"kind", "status"}, "status",
+	)
+
+	// 空补全和被过滤器拒绝的补全，按原因、重试建议，以及是否命中负结果缓存统计 (Counter)
+	completionEmptyResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_empty_results_total",
+			Help: "Total number of empty or filtered completions by reason, retry advice and whether served from the negative-result memo",
+		},
+		[]string{"reason", "advice", "memoized"},
+	)
+
+	// 瞬时值指标：各模型是否处于安全模式(1/0)，用于告警
+	completionSafeMode = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_safe_mode",
+			Help: "Whether the model is in safe mode after a completion quality anomaly (1) or not (0)",
+		},
 		[]string{"model"},
 	)
 
-	// 互斥锁，确保线程安全
-	metricsMutex sync.Mutex
+	// 补全质量异常使模型进入安全模式的次数，signal为触发的质量信号 (Counter)
+	completionAnomalies = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_anomalies_total",
+			Help: "Total number of completion quality anomalies that put the model into safe mode by signal",
+		},
+		[]string{"model", "signal"},
+	)
+
+	// 补全请求体解析失败的次数，class为错误类别(syntax/type/unknown_field/depth/extra_keys/extra_size等) (Counter)
+	completionBindingFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_binding_failures_total",
+			Help: "Total number of completion request bodies rejected by the JSON binding by route and error class",
+		},
+		[]string{"route", "class"},
+	)
+
+	// 截断提示词时前缀的分词方式，result为full/incremental/inconsistent (Counter)
+	completionTokenCache = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_token_cache_total",
+			Help: "Total number of prefix tokenizations by whether the incremental token cache was used",
+		},
+		[]string{"result"},
+	)
+
+	// 瞬时值指标：当前打开的SSE流数
+	completionOpenStreams = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "completion_open_streams",
+			Help: "Current number of open completion streams",
+		},
+	)
+
+	// 打开流时该客户端已打开的流数(含新流)分布，不以客户端作为标签 (Histogram)
+	completionClientStreams = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "completion_client_streams",
+			Help:    "Distribution of the number of open streams per client when a stream is opened",
+			Buckets: []float64{1, 2, 3, 4, 5, 8, 10},
+		},
+	)
+
+	// 发送的SSE心跳次数 (Counter)
+	completionStreamHeartbeats = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "completion_stream_heartbeats_total",
+			Help: "Total number of heartbeat comments sent on completion streams",
+		},
+	)
+
+	// 流的结束次数，reason为completed/superseded/shutdown/expired/canceled (Counter)
+	completionStreamEnds = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_stream_ends_total",
+			Help: "Total number of completion streams ended by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// 补全样本的采样结果，outcome为sampled/skipped_consent/skipped_cap (Counter)
+	completionSamples = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_samples_total",
+			Help: "Total number of completions selected for quality review sampling by outcome",
+		},
+		[]string{"model", "outcome"},
+	)
+
+	// 瞬时值指标：启动预热的容量比例，1表示已恢复全部容量 (Gauge)
+	completionWarmupFactor = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "completion_warmup_factor",
+			Help: "Fraction of the configured pool concurrency available during the start-up warm-up ramp, 1 when warmed up",
+		},
+	)
+
+	// 管理和调试接口的超时次数，route为路由模式(如/api/details) (Counter)
+	completionRouteTimeouts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_route_timeouts_total",
+			Help: "Total number of admin and debug requests that exceeded the server-side route timeout",
+		},
+		[]string{"route"},
+	)
+
+	// 补全请求体的压缩方式，encoding为identity/gzip/deflate/unsupported (Counter)
+	completionRequestEncodings = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_request_encodings_total",
+			Help: "Total number of completion requests by request body content encoding",
+		},
+		[]string{"encoding"},
+	)
+
+	// 压缩的补全请求体解压后与压缩前的大小之比，sum/count为平均压缩比 (Histogram)
+	completionRequestCompressionRatio = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "completion_request_compression_ratio",
+			Help:    "Ratio of decompressed to compressed size of compressed completion request bodies",
+			Buckets: []float64{1, 1.5, 2, 3, 4, 5, 6, 8, 10, 15, 20},
+		},
+		[]string{"encoding"},
+	)
+
+	// 运维重放的补全，outcome为reproduced/changed/not_captured/failed (Counter)
+	completionReplays = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_replays_total",
+			Help: "Total number of operator-triggered replays of captured completions by outcome",
+		},
+		[]string{"model", "outcome"},
+	)
+
+	// 按租户统计模型池调度的请求数
+	completionTenantDispatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_tenant_dispatches_total",
+			Help: "Total number of requests dispatched from the model pool wait queues by tenant",
+		},
+		[]string{"model", "tenant"},
+	)
+
+	// 定时自测探针，outcome为补全状态或saturated(模型池饱和，跳过) (Counter)
+	completionProbes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_probes_total",
+			Help: "Total number of self-benchmark probes by outcome, saturated means the probe was skipped",
+		},
+		[]string{"model", "outcome"},
+	)
+
+	// 定时自测探针的端到端耗时 (Histogram)
+	completionProbeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "completion_probe_duration_milliseconds",
+			Help:    "End-to-end duration of self-benchmark probes in milliseconds",
+			Buckets: []float64{50, 100, 150, 200, 300, 400, 500, 600, 800, 1000, 1200, 1500, 2000, 2500, 3000, 5000},
+		},
+		[]string{"model", "status"},
+	)
+
+	// 模型认证信息的轮换，source为读取的原因(watch/poll/rejected) (Counter)
+	completionCredentialRotations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_credential_rotations_total",
+			Help: "Total number of model credentials replaced after re-reading the external source",
+		},
+		[]string{"model", "source"},
+	)
+
+	// 模型后端拒绝认证信息(401/403)后重新读取并重试，outcome为重试结果或不重试的原因 (Counter)
+	completionAuthRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_auth_retries_total",
+			Help: "Total number of requests rejected by the model for authorization, by the outcome of the retry",
+		},
+		[]string{"model", "outcome"},
+	)
+
+	// 瞬时值指标：模型池的最大并发数，kind为effective(当前生效)/configured(配置文件) (Gauge)
+	completionPoolConcurrency = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_pool_concurrency",
+			Help: "Maximum concurrency of the model pools, effective after runtime adjustments or as configured",
+		},
+		[]string{"model", "kind"},
+	)
+
+	// 并发数自动调整的决定，action为increase/decrease/hold (Counter)
+	completionAutotuneDecisions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_autotune_decisions_total",
+			Help: "Total number of concurrency auto-tuning decisions per model by action",
+		},
+		[]string{"model", "action"},
+	)
 )
 
 // 定义token类型
@@ -64,53 +573,399 @@ type TokenType string
 
 const (
 	TokenTypeInput  TokenType = "input"
-	TokenTypeOutput TokenType = "output"
+	TokenTypeOutput TokenType = "output" // 返回给用户的补全内容的token数
+	TokenTypeBilled TokenType = "billed" // 模型生成的全部token数，多候选时为所有候选之和
 )
 
-// 记录补全各阶段耗时
+// 模型池饱和而跳过的自测探针的outcome标签
+const ProbeSaturated = "saturated"
+
+// 各阶段耗时的phase标签，顺序与RecordCompletionDuration的参数一致
+var durationPhases = [...]string{"queue", "context", "llm", "total"}
+
+// 一组(model, status)的各阶段耗时序列
+type durationSeries [len(durationPhases)]histogramSeries
+
+type durationKey struct {
+	model, status string
+}
+
+// (model, status) -> *durationSeries，标签取值经过白名单，条目数有上限
+var durationCache sync.Map
+
+// 查找(model, status)的各阶段耗时序列，切换旧名称输出后重新查找
+func durationSeriesOf(model, status string) *durationSeries {
+	key := durationKey{model, status}
+	if cached, ok := durationCache.Load(key); ok {
+		if series := cached.(*durationSeries); series[0].generation == legacyGeneration.Load() {
+			return series
+		}
+	}
+	series := &durationSeries{}
+	for i, phase := range durationPhases {
+		series[i] = completionDurations.series(model, status, phase)
+	}
+	durationCache.Store(key, series)
+	return series
+}
+
+// 记录补全各阶段耗时，每个(model, status)的序列只查找一次
 func RecordCompletionDuration(model string, status string, queue, context, llm, total int64) {
-	metricsMutex.Lock()
-	defer metricsMutex.Unlock()
+	RecordCompletionDurationWithFingerprint(model, status, queue, context, llm, total, "")
+}
 
-	completionDurations.WithLabelValues(model, status, "queue").Observe(float64(queue))
-	completionDurations.WithLabelValues(model, status, "context").Observe(float64(context))
-	completionDurations.WithLabelValues(model, status, "llm").Observe(float64(llm))
-	completionDurations.WithLabelValues(model, status, "total").Observe(float64(total))
+// 记录补全各阶段耗时，fingerprint不为空时作为total阶段的exemplar，用于从指标关联到日志
+func RecordCompletionDurationWithFingerprint(model string, status string, queue, context, llm, total int64, fingerprint string) {
+	series := durationSeriesOf(modelLabel(model), statusLabel(status))
+	for i, duration := range [...]int64{queue, context, llm, total} {
+		if fingerprint != "" && durationPhases[i] == "total" {
+			series[i].observeWithExemplar(float64(duration), prometheus.Labels{"fingerprint": fingerprint})
+			continue
+		}
+		series[i].observe(float64(duration))
+	}
+}
+
+// 记录快速路径请求的耗时，phase为fast_total(总耗时)和fast_overhead(总耗时减去排队和模型调用，即服务自身的开销)
+func RecordFastPathDuration(model string, status string, total, overhead int64) {
+	model, status = modelLabel(model), statusLabel(status)
+	completionDurations.series(model, status, "fast_total").observe(float64(total))
+	completionDurations.series(model, status, "fast_overhead").observe(float64(overhead))
 }
 
 // 记录每次请求的输入和输出token数分布
 func RecordCompletionTokens(model string, tokenType TokenType, tokenCount int) {
-	metricsMutex.Lock()
-	defer metricsMutex.Unlock()
+	completionTokens.WithLabelValues(modelLabel(model), string(tokenType)).Observe(float64(tokenCount))
+}
 
-	completionTokens.WithLabelValues(model, string(tokenType)).Observe(float64(tokenCount))
+// 记录没有送达客户端的补全，status为补全原本的状态
+func IncrementDeliveryFailures(model, status string) {
+	completionDeliveryFailures.WithLabelValues(modelLabel(model), statusLabel(status)).Inc()
 }
 
-// 记录请求总数，用于计算QPS和错误率
+// 记录写给客户端的补全响应数，用于计算QPS和错误率，没有送达的补全status为deliveredFailed
 func IncrementCompletionRequests(model string, status string) {
-	metricsMutex.Lock()
-	defer metricsMutex.Unlock()
-
-	completionRequestsTotal.WithLabelValues(model, status).Inc()
+	completionRequestsTotal.inc(modelLabel(model), statusLabel(status))
 }
 
 // 更新当前各模型池并发的连接总数
 func UpdateCompletionConcurrent(count int) {
-	metricsMutex.Lock()
-	defer metricsMutex.Unlock()
-
-	completionConcurrent.Set(float64(count))
+	completionConcurrent.set(float64(count))
 }
 
 // 更新指定模型池的并发连接数
 func UpdateCompletionConcurrentByModel(model string, count int) {
-	metricsMutex.Lock()
-	defer metricsMutex.Unlock()
+	completionConcurrentByModel.set(float64(count), modelLabel(model))
+}
+
+// 更新指定语言当前生效的隐藏分阈值
+func UpdateScoreThreshold(language string, threshold float64) {
+	completionScoreThreshold.WithLabelValues(language).Set(threshold)
+}
+
+// 删除指定语言的隐藏分阈值指标(恢复为全局阈值时)
+func DeleteScoreThreshold(language string) {
+	completionScoreThreshold.DeleteLabelValues(language)
+}
+
+// 记录后置处理丢弃补全后的重试，rescued表示重试得到了有效补全
+func IncrementPruneRetries(model string, rescued bool) {
+	completionPruneRetries.WithLabelValues(modelLabel(model)).Inc()
+	if rescued {
+		completionPruneRescues.WithLabelValues(modelLabel(model)).Inc()
+	}
+}
+
+// 记录补全使用的修剪模式，requested为客户端请求的模式，effective为实际生效的模式
+func IncrementPruneMode(model, requested, effective string) {
+	completionPruneModes.WithLabelValues(modelLabel(model), requested, effective).Inc()
+}
+
+// 记录补全请求的路由及去重结果
+func IncrementRouteRequests(route, outcome string) {
+	completionRouteRequests.WithLabelValues(route, outcome).Inc()
+}
+
+// 记录返回的补全的置信度
+func ObserveConfidence(language string, confidence float64) {
+	completionConfidence.WithLabelValues(language).Observe(confidence)
+}
+
+// 记录上一次补全的采纳比例，variant为计算隐藏分使用的权重变体
+func ObserveAcceptance(model, language, variant string, fraction float64) {
+	completionAcceptance.WithLabelValues(modelLabel(model), language, variant).Observe(fraction)
+}
+
+// 记录请求的隐藏分，variant为计算使用的权重变体
+func ObserveHiddenScore(variant string, score float64) {
+	completionHiddenScore.WithLabelValues(variant).Observe(score)
+}
+
+// 记录被忽略的无效部分采纳反馈
+func IncrementAcceptanceInvalid(reason string) {
+	completionAcceptanceInvalid.WithLabelValues(reason).Inc()
+}
+
+// 记录在本地补全闭合符号、没有调用模型的补全
+func IncrementLocalCloser(language string) {
+	completionLocalClosers.WithLabelValues(language).Inc()
+}
+
+// 记录一次检索请求得到的检索服务状态
+func IncrementContextProviderState(provider, state string) {
+	completionContextProviderStates.WithLabelValues(provider, state).Inc()
+}
+
+// 记录一次严格截止时间模式的请求，在截止时间内正常完成时phase为空
+func IncrementStrictDeadline(outcome, phase string) {
+	completionStrictDeadline.WithLabelValues(outcome, phase).Inc()
+}
+
+// 记录一个检索片段命中了一条注入过滤规则
+func IncrementContextSanitized(provider, pattern string) {
+	completionContextSanitized.WithLabelValues(provider, pattern).Inc()
+}
+
+// 记录一次语法错误补全的恢复结果
+func IncrementSyntaxRecovery(language, outcome string) {
+	completionSyntaxRecovery.WithLabelValues(language, outcome).Inc()
+}
+
+// 更新指定模型出站限流令牌桶的可用令牌数
+func UpdateRateTokens(model string, tokens float64) {
+	completionRateTokens.WithLabelValues(modelLabel(model)).Set(tokens)
+}
+
+// 记录因出站限流而快速失败的请求
+func IncrementThrottled(model string) {
+	completionThrottled.WithLabelValues(modelLabel(model)).Inc()
+}
+
+// 更新指定模型的token数校准系数
+func UpdateTokenCalibration(model string, factor float64) {
+	completionTokenCalibration.WithLabelValues(modelLabel(model)).Set(factor)
+}
+
+// 记录上游报告提示词超过上下文长度的请求
+func IncrementContextLengthErrors(model string, retried bool) {
+	completionContextLengthErrors.WithLabelValues(modelLabel(model), strconv.FormatBool(retried)).Inc()
+}
+
+// 记录插件告知作废的补全
+func IncrementInvalidations(state string) {
+	completionInvalidations.WithLabelValues(state).Inc()
+}
+
+// 记录回退到语言族的补全请求，language超过上限的新取值记为other
+func IncrementLanguageFallbacks(language, family, source string) {
+	completionLanguageFallbacks.WithLabelValues(fallbackLanguageLabel(language), family, source).Inc()
+}
+
+// 记录按旧版格式返回的补全
+func IncrementLegacyResponses(model string, status string) {
+	completionLegacyResponses.WithLabelValues(modelLabel(model), statusLabel(status)).Inc()
+}
+
+// 记录被取消或超时的补全请求，cause取值固定，不需要白名单
+func IncrementCancellations(model string, cause string) {
+	completionCancellations.WithLabelValues(modelLabel(model), cause).Inc()
+}
+
+// 更新内存存储的条目数和估算的字节数
+func UpdateStoreSize(store string, entries, bytes int) {
+	storeEntries.WithLabelValues(store).Set(float64(entries))
+	storeBytes.WithLabelValues(store).Set(float64(bytes))
+	size, _ := storeSizes.LoadOrStore(store, &storeSize{})
+	size.(*storeSize).entries.Store(int64(entries))
+	size.(*storeSize).bytes.Store(int64(bytes))
+}
+
+// 各内存存储最近一次上报的大小，诊断快照直接读取，不需要从注册表收集
+type storeSize struct {
+	entries atomic.Int64
+	bytes   atomic.Int64
+}
+
+var storeSizes sync.Map // 存储名称 -> *storeSize
+
+// 内存存储的条目数和估算的字节数
+type StoreSize struct {
+	Entries int64 `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// 各内存存储最近一次上报的大小，只读取原子变量，崩溃诊断时也可以安全调用
+func StoreSizes() map[string]StoreSize {
+	sizes := make(map[string]StoreSize)
+	storeSizes.Range(func(key, value any) bool {
+		size := value.(*storeSize)
+		sizes[key.(string)] = StoreSize{Entries: size.entries.Load(), Bytes: size.bytes.Load()}
+		return true
+	})
+	return sizes
+}
+
+// 记录内存存储淘汰的条目，reason为淘汰原因(capacity/expired)
+func IncrementStoreEvictions(store, reason string) {
+	storeEvictions.WithLabelValues(store, reason).Inc()
+}
+
+// 记录内存存储作废的条目，reason为作废原因(stale/file_changed)
+func IncrementStoreInvalidations(store, reason string, count int) {
+	storeInvalidations.WithLabelValues(store, reason).Add(float64(count))
+}
+
+// 记录代码上下文的获取结果
+func IncrementContextFetches(outcome string) {
+	completionContextFetches.WithLabelValues(outcome).Inc()
+}
+
+// 记录丢弃的不合格检索结果，reason为不合格的原因
+func IncrementContextInvalidItems(provider, reason string) {
+	completionContextInvalidItems.WithLabelValues(provider, reason).Inc()
+}
+
+// 记录因不合格结果过多而作废的检索结果
+func IncrementContextRejectedResults(provider string) {
+	completionContextRejectedResults.WithLabelValues(provider).Inc()
+}
+
+// 记录成功补全的总耗时，按代码上下文的使用方式
+func ObserveContextModeDuration(mode string, duration int64) {
+	completionContextModeDurations.WithLabelValues(mode).Observe(float64(duration))
+}
+
+// 记录返回的补全被采纳的比例，按代码上下文的使用方式
+func ObserveContextModeAcceptance(mode string, fraction float64) {
+	completionContextModeAcceptance.WithLabelValues(mode).Observe(fraction)
+}
+
+// 记录补全所在文件的类型(test/source)和补全状态
+func IncrementFileKind(kind, status string) {
+	completionFileKinds.inc(kind, statusLabel(status))
+}
+
+// 记录空补全的原因和重试建议，memoized为是否命中负结果缓存
+func IncrementEmptyResults(reason, advice string, memoized bool) {
+	completionEmptyResults.WithLabelValues(reason, advice, strconv.FormatBool(memoized)).Inc()
+}
+
+// 记录实际发起的代码上下文获取的耗时
+func RecordContextFetchDuration(outcome string, duration int64) {
+	completionContextDurations.observe(float64(duration), outcome)
+}
+
+// 更新模型是否处于安全模式
+func UpdateSafeMode(model string, safe bool) {
+	value := 0.0
+	if safe {
+		value = 1
+	}
+	completionSafeMode.WithLabelValues(modelLabel(model)).Set(value)
+}
+
+// 记录使模型进入安全模式的质量异常
+func IncrementAnomalies(model, signal string) {
+	completionAnomalies.WithLabelValues(modelLabel(model), signal).Inc()
+}
+
+// 记录解析失败的补全请求体
+func IncrementBindingFailures(route, class string) {
+	completionBindingFailures.WithLabelValues(route, class).Inc()
+}
+
+// 记录前缀的分词方式
+func IncrementTokenCache(result string) {
+	completionTokenCache.WithLabelValues(result).Inc()
+}
+
+// 更新当前打开的流数，clientStreams为打开新流时该客户端的流数，为0时表示关闭流
+func UpdateOpenStreams(open, clientStreams int) {
+	completionOpenStreams.Set(float64(open))
+	if clientStreams > 0 {
+		completionClientStreams.Observe(float64(clientStreams))
+	}
+}
+
+// 记录发送的流心跳
+func IncrementStreamHeartbeats() {
+	completionStreamHeartbeats.Inc()
+}
+
+// 记录流的结束原因
+func IncrementStreamEnds(reason string) {
+	completionStreamEnds.WithLabelValues(reason).Inc()
+}
+
+// 记录补全样本的采样结果
+func IncrementSamples(model string, outcome string) {
+	completionSamples.WithLabelValues(modelLabel(model), outcome).Inc()
+}
+
+// 更新启动预热的容量比例
+func UpdateWarmupFactor(factor float64) {
+	completionWarmupFactor.Set(factor)
+}
+
+// 记录超时的管理和调试接口请求
+func IncrementRouteTimeouts(route string) {
+	completionRouteTimeouts.WithLabelValues(route).Inc()
+}
+
+// 记录补全请求体的压缩方式
+func IncrementRequestEncodings(encoding string) {
+	completionRequestEncodings.WithLabelValues(encoding).Inc()
+}
+
+// 记录压缩的补全请求体的压缩比
+func ObserveCompressionRatio(encoding string, ratio float64) {
+	completionRequestCompressionRatio.WithLabelValues(encoding).Observe(ratio)
+}
+
+// 记录运维重放的补全
+func IncrementReplays(model string, outcome string) {
+	completionReplays.WithLabelValues(modelLabel(model), outcome).Inc()
+}
+
+// 记录模型池调度的租户请求，租户只取配置中的名称和default
+func IncrementTenantDispatches(model string, tenant string) {
+	completionTenantDispatches.WithLabelValues(modelLabel(model), tenant).Inc()
+}
+
+// 记录一次自测探针的状态和端到端耗时(毫秒)
+func ObserveProbe(model string, status string, duration int64) {
+	completionProbes.WithLabelValues(modelLabel(model), statusLabel(status)).Inc()
+	completionProbeDuration.WithLabelValues(modelLabel(model), statusLabel(status)).Observe(float64(duration))
+}
+
+// 记录模型池饱和而跳过的自测探针
+func IncrementProbeSkips(model string) {
+	completionProbes.WithLabelValues(modelLabel(model), ProbeSaturated).Inc()
+}
+
+// 记录一次模型认证信息的轮换
+func IncrementCredentialRotations(model string, source string) {
+	completionCredentialRotations.WithLabelValues(modelLabel(model), source).Inc()
+}
+
+// 记录一次模型后端拒绝认证信息后的处理
+func IncrementAuthRetries(model string, outcome string) {
+	completionAuthRetries.WithLabelValues(modelLabel(model), outcome).Inc()
+}
+
+// 更新模型池生效的和配置的最大并发数
+func UpdatePoolConcurrency(model string, effective int, configured int) {
+	completionPoolConcurrency.WithLabelValues(modelLabel(model), "effective").Set(float64(effective))
+	completionPoolConcurrency.WithLabelValues(modelLabel(model), "configured").Set(float64(configured))
+}
 
-	completionConcurrentByModel.WithLabelValues(model).Set(float64(count))
+// 记录一次并发数自动调整的决定
+func IncrementAutotuneDecisions(model string, action string) {
+	completionAutotuneDecisions.WithLabelValues(modelLabel(model), action).Inc()
 }
 
-// 返回Prometheus指标数据的HTTP处理器
+// 返回Prometheus指标数据的HTTP处理器，协商为OpenMetrics格式时输出exemplar
 func GetMetricsHandler() http.Handler {
-	return promhttp.Handler()
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
 }
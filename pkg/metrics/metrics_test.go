@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// 各指标中某个标签的不同取值
+func labelValues(t *testing.T, metric, label string) map[string]bool {
+	values := make(map[string]bool)
+	for _, labels := range gatherLabels(t)[metric] {
+		values[labels[label]] = true
+	}
+	return values
+}
+
+// to test garbage model and status values are mapped to other so the series count stays capped
+// go test ./pkg/metrics/ -v -run Test_LabelCardinality
+func Test_LabelCardinality(t *testing.T) {
+	RegisterModels("configured-model")
+	for i := 0; i < 500; i++ {
+		model := fmt.Sprintf("garbage-model-%d", i)
+		status := fmt.Sprintf("garbage-status-%d", i)
+		IncrementCompletionRequests(model, status)
+		RecordCompletionDuration(model, status, 1, 2, 3, 6)
+		RecordCompletionTokens(model, TokenTypeInput, 10)
+	}
+	RecordCompletionDuration("configured-model", "modelError", 1, 2, 3, 6)
+
+	for _, metric := range []string{"completion_responses_total", "completion_duration_milliseconds", "completion_tokens"} {
+		models := labelValues(t, metric, "model")
+		if len(models) > MaxModelLabels+1 {
+			t.Errorf("%s: expected at most %d model values, got %d", metric, MaxModelLabels+1, len(models))
+		}
+		if !models[LabelOther] {
+			t.Errorf("%s: expected unknown models recorded as %s", metric, LabelOther)
+		}
+	}
+	models := labelValues(t, "completion_duration_milliseconds", "model")
+	if !models["configured-model"] {
+		t.Errorf("registered model should keep its own label")
+	}
+	for _, metric := range []string{"completion_responses_total", "completion_duration_milliseconds"} {
+		for status := range labelValues(t, metric, "status") {
+			if status != LabelOther && !knownStatuses[status] && !knownStatuses[denormalize(status)] {
+				t.Errorf("%s: unexpected status value %q", metric, status)
+			}
+		}
+	}
+}
+
+// 规范化后的状态对应的原始状态
+func denormalize(status string) string {
+	for known := range knownStatuses {
+		if NormalizeLabelValue(known) == status {
+			return known
+		}
+	}
+	return status
+}
+
+// 旧的实现：全局互斥锁，每个阶段单独查找序列
+func recordWithMutex(mutex *sync.Mutex, model, status string, queue, context, llm, total int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	completionDurations.observe(float64(queue), model, status, "queue")
+	completionDurations.observe(float64(context), model, status, "context")
+	completionDurations.observe(float64(llm), model, status, "llm")
+	completionDurations.observe(float64(total), model, status, "total")
+	completionRequestsTotal.inc(model, status)
+}
+
+// 64个协程并发记录一次补全的指标，共b.N次
+func runConcurrently(b *testing.B, record func()) {
+	const goroutines = 64
+	b.ReportAllocs()
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		n := b.N / goroutines
+		if g < b.N%goroutines {
+			n++
+		}
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				record()
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+// go test ./pkg/metrics/ -bench Benchmark_Record -benchmem -run ^$
+func Benchmark_RecordWithMutex(b *testing.B) {
+	var mutex sync.Mutex
+	runConcurrently(b, func() {
+		recordWithMutex(&mutex, "bench-model", "success", 10, 20, 300, 330)
+	})
+}
+
+func Benchmark_RecordLockFree(b *testing.B) {
+	RegisterModels("bench-model")
+	runConcurrently(b, func() {
+		RecordCompletionDuration("bench-model", "success", 10, 20, 300, 330)
+		IncrementCompletionRequests("bench-model", "success")
+	})
+}
@@ -0,0 +1,154 @@
+package model
+
+import (
+	"bytes"
+	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// 本地部署后端(ollama/llama.cpp)返回的错误
+type backendError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *backendError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Invalid StatusCode(%d)", e.StatusCode)
+	}
+	return fmt.Sprintf("Invalid StatusCode(%d): %s", e.StatusCode, e.Message)
+}
+
+// 上游报告提示词超过模型的上下文长度，截断的预算偏大(本地与上游的token数不一致)
+var ErrContextLength = errors.New("context length exceeded")
+
+// 各后端表示提示词超长的错误信息(OpenAI/vLLM/ollama/llama.cpp)
+var contextLengthMarkers = []string{"context_length_exceeded", "context length", "context size", "exceed_context_size"}
+
+// 后端的错误响应是否表示提示词超过上下文长度
+func contextLengthExceeded(body []byte) bool {
+	lower := strings.ToLower(string(body))
+	for _, marker := range contextLengthMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求失败的原因对应的补全状态
+func requestStatus(err error) CompletionStatus {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return StatusCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return StatusTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return StatusTimeout
+	}
+	return StatusServerError
+}
+
+// 后端返回的HTTP状态码对应的补全状态，过载或加载模型中视为繁忙
+func responseStatus(statusCode int) CompletionStatus {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return StatusBusy
+	}
+	return StatusModelError
+}
+
+/**
+ * 以非流式方式向本地部署的后端发送补全请求
+ * @param {context.Context} ctx - 请求上下文，取消时中止请求
+ * @param {*config.ModelConfig} cfg - 模型配置，提供地址、认证信息和超时时间
+ * @param {interface{}} data - 请求体
+ * @param {func([]byte) string} errorMessage - 从后端的错误响应中提取错误信息
+ * @param {*CompletionParameter} p - 补全参数，决定是否记录后端的原始响应，见recordOutput
+ * @param {*CompletionVerbose} verbose - 记录后端的原始响应
+ * @returns {[]byte, CompletionStatus, error} 返回响应体，失败时返回补全状态和错误
+ * @description
+ * - 超时和取消的处理与OpenAIModel一致：超时时间为cfg.Timeout，ctx取消时返回canceled
+ * - 非2xx响应返回backendError，429/503为busy，其他为modelError
+ * - 发送前等待ctx中的出站限流，拿不到令牌时返回busy和ErrRateLimited，见WithRateLimit
+ */
+func postBackend(ctx context.Context, cfg *config.ModelConfig, data interface{},
+	errorMessage func([]byte) string, p *CompletionParameter, verbose *CompletionVerbose) ([]byte, CompletionStatus, error) {
+	if !waitRateLimit(ctx) {
+		return nil, StatusBusy, ErrRateLimited
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, StatusServerError, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.CompletionsUrl, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, StatusReqError, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Authorization != "" {
+		req.Header.Set("Authorization", cfg.Authorization)
+	}
+
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		status := requestStatus(err)
+		logger.FromContext(ctx).Debug("Model request failed", zap.String("url", cfg.CompletionsUrl),
+			zap.String("provider", cfg.Provider), zap.String("status", string(status)), zap.Error(err))
+		return nil, status, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestStatus(err), err
+	}
+	recordOutput(p, body, verbose)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.FromContext(ctx).Warn("Model returned non-200 status", zap.String("url", cfg.CompletionsUrl),
+			zap.String("provider", cfg.Provider), zap.Int("statusCode", resp.StatusCode), zap.String("resp", string(body)))
+		err := error(&backendError{StatusCode: resp.StatusCode, Message: errorMessage(body)})
+		if contextLengthExceeded(body) {
+			err = fmt.Errorf("%w: %w", ErrContextLength, err)
+		}
+		return nil, responseStatus(resp.StatusCode), err
+	}
+	return body, StatusSuccess, nil
+}
+
+// 在Verbose中记录模型的原始响应，快速路径不请求verbose时跳过，省去解析为map的开销
+func recordOutput(p *CompletionParameter, body []byte, verbose *CompletionVerbose) {
+	if p.FastPath && !p.Verbose {
+		return
+	}
+	json.Unmarshal(body, &verbose.Output)
+}
+
+// 构造只有一个候选的OpenAI格式响应，后端没有返回用量时用量为0
+func singleChoiceResponse(model, text, finishReason string, promptTokens, completionTokens int) *CompletionResponse {
+	return &CompletionResponse{
+		Object: "text_completion",
+		Model:  model,
+		Choices: []CompletionChoice{
+			{Text: text, FinishReason: finishReason},
+		},
+		Usage: CompletionUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}
+}
@@ -0,0 +1,205 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+)
+
+// backendFixture emulates a backend endpoint, records the last request body and replies with a fixed response
+type backendFixture struct {
+	server  *httptest.Server
+	mutex   sync.Mutex // 超时的请求的处理函数可能与下一个请求同时运行
+	request map[string]interface{}
+}
+
+// 最后一次请求的请求体
+func (f *backendFixture) last() map[string]interface{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.request
+}
+
+func newBackendFixture(t *testing.T, path string, statusCode int, body string, delay time.Duration) *backendFixture {
+	f := &backendFixture{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "404 page not found")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var request map[string]interface{}
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("invalid request body %q", data)
+		}
+		f.mutex.Lock()
+		f.request = request
+		f.mutex.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func newBackendParameter() *CompletionParameter {
+	return &CompletionParameter{
+		MaxTokens:   64,
+		Temperature: 0.2,
+		Stop:        []string{"\n\n"},
+		Prefix:      "def add(a, b):\n    ",
+		Suffix:      "\n\nprint(add(1, 2))\n",
+		CodeContext: "# math helpers",
+	}
+}
+
+// to test the ollama backend against the shapes of /api/generate
+// go test ./pkg/model/ -v -run Test_OllamaModel
+func Test_OllamaModel(t *testing.T) {
+	ok := `{"model":"qwen2.5-coder:1.5b","created_at":"2024-10-01T08:00:00.000000Z","response":"return a + b","done":true,"done_reason":"stop","context":[1,2,3],"total_duration":212000000,"load_duration":2000000,"prompt_eval_count":21,"prompt_eval_duration":50000000,"eval_count":5,"eval_duration":150000000}`
+	f := newBackendFixture(t, "/api/generate", http.StatusOK, ok, 0)
+	cfg := &config.ModelConfig{Provider: "ollama", ModelName: "qwen2.5-coder:1.5b", CompletionsUrl: f.server.URL + "/api/generate", MaxOutput: 32, Timeout: time.Second}
+	m := NewOllamaModel(cfg, nil)
+
+	rsp, verbose, status, err := m.Completions(context.Background(), newBackendParameter())
+	if status != StatusSuccess || err != nil {
+		t.Fatalf("unexpected status %s: %v", status, err)
+	}
+	if rsp.Choices[0].Text != "return a + b" || rsp.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected choice %+v", rsp.Choices[0])
+	}
+	if rsp.Usage.PromptTokens != 21 || rsp.Usage.CompletionTokens != 5 || rsp.Usage.TotalTokens != 26 {
+		t.Errorf("unexpected usage %+v", rsp.Usage)
+	}
+	if verbose.Output["done_reason"] != "stop" {
+		t.Errorf("expected the raw response in verbose, got %v", verbose.Output)
+	}
+	options, _ := f.last()["options"].(map[string]interface{})
+	if f.last()["suffix"] != "\n\nprint(add(1, 2))\n" || f.last()["raw"] != nil || f.last()["stream"] != false ||
+		options["num_predict"] != float64(32) || f.last()["prompt"] != "# math helpers\ndef add(a, b):\n    " {
+		t.Errorf("unexpected request %v", f.last())
+	}
+
+	// fim mode builds the prompt with the configured marks and bypasses the model template
+	cfg.FimMode, cfg.FimBegin, cfg.FimHole, cfg.FimEnd = true, "<PRE>", "<SUF>", "<MID>"
+	if _, _, status, _ := m.Completions(context.Background(), newBackendParameter()); status != StatusSuccess {
+		t.Fatalf("unexpected status %s", status)
+	}
+	if f.last()["raw"] != true || f.last()["suffix"] != nil || !strings.HasPrefix(f.last()["prompt"].(string), "<PRE># math helpers") {
+		t.Errorf("unexpected fim request %v", f.last())
+	}
+
+	errCases := []struct {
+		code   int
+		body   string
+		status CompletionStatus
+		msg    string
+	}{
+		{http.StatusNotFound, `{"error":"model \"qwen2.5-coder:1.5b\" not found, try pulling it first"}`, StatusModelError, "not found, try pulling it first"},
+		{http.StatusBadRequest, `{"error":"invalid options: num_predict"}`, StatusModelError, "invalid options"},
+		{http.StatusServiceUnavailable, `{"error":"server busy, please try again.  maximum pending requests exceeded"}`, StatusBusy, "server busy"},
+	}
+	for _, c := range errCases {
+		f := newBackendFixture(t, "/api/generate", c.code, c.body, 0)
+		cfg := &config.ModelConfig{Provider: "ollama", ModelName: "qwen2.5-coder:1.5b", CompletionsUrl: f.server.URL + "/api/generate", Timeout: time.Second}
+		_, _, status, err := NewOllamaModel(cfg, nil).Completions(context.Background(), newBackendParameter())
+		if status != c.status || err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%d: unexpected status %s: %v", c.code, status, err)
+		}
+	}
+}
+
+// to test the llama.cpp backend against the shapes of /infill and /completion
+// go test ./pkg/model/ -v -run Test_LlamaCppModel
+func Test_LlamaCppModel(t *testing.T) {
+	ok := `{"index":0,"content":"return a + b","tokens":[],"id_slot":0,"stop":true,"model":"qwen2.5-coder-1.5b-q8_0.gguf","tokens_predicted":32,"tokens_evaluated":40,"generation_settings":{"n_predict":32},"prompt":"","has_new_line":false,"truncated":false,"stop_type":"limit","stopping_word":"","tokens_cached":39,"timings":{"prompt_n":40,"predicted_n":32}}`
+	f := newBackendFixture(t, "/infill", http.StatusOK, ok, 0)
+	cfg := &config.ModelConfig{Provider: "llamacpp", CompletionsUrl: f.server.URL + "/infill", MaxOutput: 32, Timeout: time.Second}
+	m := NewLlamaCppModel(cfg, nil)
+
+	rsp, _, status, err := m.Completions(context.Background(), newBackendParameter())
+	if status != StatusSuccess || err != nil {
+		t.Fatalf("unexpected status %s: %v", status, err)
+	}
+	if rsp.Choices[0].Text != "return a + b" || rsp.Choices[0].FinishReason != "length" || rsp.Model != "qwen2.5-coder-1.5b-q8_0.gguf" {
+		t.Errorf("unexpected response %+v", rsp)
+	}
+	if rsp.Usage.PromptTokens != 40 || rsp.Usage.CompletionTokens != 32 {
+		t.Errorf("unexpected usage %+v", rsp.Usage)
+	}
+	extra, _ := f.last()["input_extra"].([]interface{})
+	if f.last()["input_prefix"] != "def add(a, b):\n    " || f.last()["input_suffix"] != "\n\nprint(add(1, 2))\n" ||
+		len(extra) != 1 || f.last()["prompt"] != nil || f.last()["n_predict"] != float64(32) {
+		t.Errorf("unexpected infill request %v", f.last())
+	}
+
+	// /completion has no native fim fields, the marks come from the config
+	f = newBackendFixture(t, "/completion", http.StatusOK, `{"content":"return a + b","stop":true,"stop_type":"eos","tokens_predicted":5,"tokens_evaluated":40}`, 0)
+	cfg = &config.ModelConfig{Provider: "llamacpp", ModelName: "local", CompletionsUrl: f.server.URL + "/completion", MaxOutput: 32, Timeout: time.Second,
+		FimMode: true, FimBegin: "<PRE>", FimHole: "<SUF>", FimEnd: "<MID>"}
+	rsp, _, status, _ = NewLlamaCppModel(cfg, nil).Completions(context.Background(), newBackendParameter())
+	if status != StatusSuccess || rsp.Choices[0].FinishReason != "stop" || rsp.Model != "local" {
+		t.Errorf("unexpected response %s %+v", status, rsp)
+	}
+	if f.last()["prompt"] != "<PRE># math helpers\ndef add(a, b):\n    <SUF>\n\nprint(add(1, 2))\n<MID>" || f.last()["input_prefix"] != nil {
+		t.Errorf("unexpected completion request %v", f.last())
+	}
+
+	errCases := []struct {
+		code   int
+		body   string
+		status CompletionStatus
+		msg    string
+	}{
+		{http.StatusBadRequest, `{"error":{"code":400,"message":"the request exceeds the available context size","type":"exceed_context_size_error"}}`, StatusModelError, "exceed_context_size_error: the request exceeds"},
+		{http.StatusServiceUnavailable, `{"error":{"code":503,"message":"Loading model","type":"unavailable_error"}}`, StatusBusy, "Loading model"},
+		{http.StatusInternalServerError, `{"error":{"code":500,"message":"llama_decode failed","type":"server_error"}}`, StatusModelError, "llama_decode failed"},
+	}
+	for _, c := range errCases {
+		f := newBackendFixture(t, "/infill", c.code, c.body, 0)
+		cfg := &config.ModelConfig{Provider: "llamacpp", CompletionsUrl: f.server.URL + "/infill", Timeout: time.Second}
+		_, _, status, err := NewLlamaCppModel(cfg, nil).Completions(context.Background(), newBackendParameter())
+		if status != c.status || err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%d: unexpected status %s: %v", c.code, status, err)
+		}
+	}
+}
+
+// to test timeout and cancellation of the local backends
+// go test ./pkg/model/ -v -run Test_BackendTimeout
+func Test_BackendTimeout(t *testing.T) {
+	f := newBackendFixture(t, "/api/generate", http.StatusOK, `{"response":"x","done":true}`, time.Second)
+	cfg := &config.ModelConfig{Provider: "ollama", ModelName: "m", CompletionsUrl: f.server.URL + "/api/generate", Timeout: 20 * time.Millisecond}
+	m := NewOllamaModel(cfg, nil)
+	if _, _, status, _ := m.Completions(context.Background(), newBackendParameter()); status != StatusTimeout {
+		t.Errorf("expected timeout by the model timeout, got %s", status)
+	}
+
+	cfg.Timeout = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, status, _ := m.Completions(ctx, newBackendParameter()); status != StatusTimeout {
+		t.Errorf("expected timeout by the request deadline, got %s", status)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, _, status, _ := m.Completions(ctx, newBackendParameter()); status != StatusCanceled {
+		t.Errorf("expected canceled, got %s", status)
+	}
+}
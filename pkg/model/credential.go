@@ -0,0 +1,210 @@
+package model
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+//
+//	模型认证信息的轮换: 认证信息引用文件或环境变量时，变更后不需要重启，正在处理的请求不受影响
+//
+
+// 重新读取认证信息的原因，见completion_credential_rotations_total的source标签
+const (
+	CredentialWatch    = "watch"    // 文件变更通知
+	CredentialPoll     = "poll"     // 定时重新读取
+	CredentialRejected = "rejected" // 模型后端拒绝了当前的认证信息
+)
+
+// 模型后端拒绝认证信息后的处理，见completion_auth_retries_total的outcome标签
+const (
+	AuthRetrySuccess   = "success"   // 用新的认证信息重试成功
+	AuthRetryRejected  = "rejected"  // 重试仍被拒绝
+	AuthRetryFailed    = "failed"    // 重试因其它原因失败
+	AuthRetryUnchanged = "unchanged" // 重新读取后认证信息没有变化，不重试
+	AuthRetryDeadline  = "deadline"  // 剩余时间不够再请求一次，不重试
+)
+
+// 没有配置credentialRefresh时定时重新读取的间隔
+const defaultCredentialRefresh = time.Minute
+
+/**
+ * 可轮换的认证信息
+ * @description
+ * - 当前值保存在原子指针中，每个请求发送时读取，替换时不需要加锁
+ * - 直接配置的认证信息不会变化；引用文件或环境变量时由watch和模型后端的拒绝触发重新读取
+ * - 日志只记录来源和引用的路径/变量名，不记录认证信息本身
+ */
+type credential struct {
+	mutex    sync.Mutex // 串行化重新读取，避免并发的读取互相覆盖
+	model    string
+	kind     string // config.CredentialFile/config.CredentialEnv，直接配置时为空
+	ref      string // 文件路径或环境变量名
+	value    atomic.Pointer[string]
+	stop     chan struct{} // 关闭时跟踪协程退出，见close
+	stopOnce sync.Once
+}
+
+// 创建认证信息，引用外部来源时立即读取一次，失败时认证信息为空，等待之后的重新读取
+func newCredential(c *config.ModelConfig) *credential {
+	kind, ref := c.CredentialSource()
+	cred := &credential{model: c.ModelTitle, kind: kind, ref: ref, stop: make(chan struct{})}
+	value := c.Authorization
+	if kind != "" {
+		var err error
+		if value, err = cred.read(); err != nil {
+			zap.L().Error("Read model credential failed", zap.String("model", c.ModelTitle),
+				zap.String("source", kind), zap.String("ref", ref), zap.Error(err))
+		}
+	}
+	cred.value.Store(&value)
+	return cred
+}
+
+// 当前的认证信息
+func (c *credential) Get() string {
+	return *c.value.Load()
+}
+
+// 是否引用外部来源，可以轮换
+func (c *credential) external() bool {
+	return c.kind != ""
+}
+
+// 从外部来源读取认证信息，去掉首尾空白
+func (c *credential) read() (string, error) {
+	switch c.kind {
+	case config.CredentialFile:
+		data, err := os.ReadFile(c.ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case config.CredentialEnv:
+		value, ok := os.LookupEnv(c.ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", c.ref)
+		}
+		return strings.TrimSpace(value), nil
+	}
+	return c.Get(), nil
+}
+
+/**
+ * 重新读取认证信息
+ * @param {string} reason - 重新读取的原因，见Credential*
+ * @returns {bool} 认证信息有变化并已替换时返回true
+ * @description
+ * - 读取失败或读到空值(如文件正在被改写)时保留当前的认证信息
+ * - 替换时记录日志和completion_credential_rotations_total
+ */
+func (c *credential) refresh(reason string) bool {
+	if !c.external() {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, err := c.read()
+	if err == nil && value == "" {
+		err = fmt.Errorf("empty credential")
+	}
+	if err != nil {
+		zap.L().Warn("Re-read model credential failed, keep the current one", zap.String("model", c.model),
+			zap.String("source", c.kind), zap.String("ref", c.ref), zap.String("reason", reason), zap.Error(err))
+		return false
+	}
+	if value == c.Get() {
+		return false
+	}
+	c.value.Store(&value)
+	zap.L().Info("Model credential rotated", zap.String("model", c.model),
+		zap.String("source", c.kind), zap.String("ref", c.ref), zap.String("reason", reason))
+	metrics.IncrementCredentialRotations(c.model, reason)
+	return true
+}
+
+/**
+ * 在后台跟踪外部来源的变化，直接配置的认证信息不处理
+ * @param {time.Duration} interval - 定时重新读取的间隔，不大于0时为defaultCredentialRefresh
+ * @description
+ * - 文件: 监听所在目录的变更通知，能处理改名替换(如Kubernetes Secret的符号链接切换)；定时读取作为兜底
+ * - 环境变量: 只能定时重新读取(如由外部工具注入到进程环境中)
+ * - 监听失败时只定时重新读取
+ * - close后跟踪协程退出并关闭监听
+ */
+func (c *credential) watch(interval time.Duration) {
+	if !c.external() {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultCredentialRefresh
+	}
+	var watcher *fsnotify.Watcher
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	if c.kind == config.CredentialFile {
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err == nil {
+			if err = watcher.Add(filepath.Dir(c.ref)); err != nil {
+				watcher.Close()
+			}
+		}
+		if err != nil {
+			watcher = nil
+			zap.L().Warn("Watch credential file failed, fall back to polling", zap.String("model", c.model),
+				zap.String("ref", c.ref), zap.Duration("interval", interval), zap.Error(err))
+		} else {
+			events, errors = watcher.Events, watcher.Errors
+		}
+	}
+	go func() {
+		if watcher != nil {
+			defer watcher.Close()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			// 已关闭时不再处理同时到达的变更通知
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+			select {
+			case <-c.stop:
+				return
+			case _, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				// 目录中的任何变更都重新读取，内容没有变化时不替换
+				c.refresh(CredentialWatch)
+			case err, ok := <-errors:
+				if !ok {
+					errors = nil
+					continue
+				}
+				zap.L().Warn("Watch credential file error", zap.String("model", c.model), zap.Error(err))
+			case <-ticker.C:
+				c.refresh(CredentialPoll)
+			}
+		}
+	}()
+}
+
+// 停止跟踪外部来源的变化，可以重复调用
+func (c *credential) close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+)
+
+// newRotatingModel creates an openai model whose authorization references an external source
+func newRotatingModel(url, authorization string) *OpenAIModel {
+	return NewOpenAIModel(&config.ModelConfig{
+		Provider:          "openai",
+		ModelTitle:        "rotating",
+		ModelName:         "m",
+		CompletionsUrl:    url + "/v1/completions",
+		Authorization:     authorization,
+		Timeout:           time.Second,
+		MaxOutput:         64,
+		CredentialRefresh: time.Hour,
+	}, nil).(*OpenAIModel)
+}
+
+// to test rotating the credential from a watched token file and retrying once after upstream rejects the old token
+// go test ./pkg/model/ -v -run Test_CredentialRotation
+func Test_CredentialRotation(t *testing.T) {
+	// 请求之间令牌文件被替换，变更通知后使用新的令牌
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("Bearer token-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server, received := newAuthBackend(t, "Bearer token-1", "Bearer token-2")
+	m := newRotatingModel(server.URL, config.CredentialFile+path)
+	if _, _, status, err := m.Completions(context.Background(), newBackendParameter()); status != StatusSuccess {
+		t.Fatalf("expected the first token accepted, got %s %v", status, err)
+	}
+	if err := os.WriteFile(path, []byte("Bearer token-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); m.cred.Get() != "Bearer token-2"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the token file change noticed, still %q", m.cred.Get())
+		}
+	}
+	if _, _, status, err := m.Completions(context.Background(), newBackendParameter()); status != StatusSuccess {
+		t.Fatalf("expected the rotated token accepted, got %s %v", status, err)
+	}
+	if strings.Join(*received, ",") != "Bearer token-1,Bearer token-2" {
+		t.Errorf("expected the rotated token sent after the change, got %v", *received)
+	}
+
+	// 模型后端拒绝旧的令牌时立即重新读取，用新的令牌重试一次
+	t.Setenv("CC_TEST_MODEL_TOKEN", "Bearer env-old")
+	server, received = newAuthBackend(t, "Bearer env-new")
+	m = newRotatingModel(server.URL, config.CredentialEnv+"CC_TEST_MODEL_TOKEN")
+	t.Setenv("CC_TEST_MODEL_TOKEN", "Bearer env-new")
+	_, verbose, status, err := m.Completions(context.Background(), newBackendParameter())
+	if status != StatusSuccess || verbose.KeySource != KeySourceServer {
+		t.Errorf("expected the retry with the new token succeeded, got %s %v", status, err)
+	}
+	if strings.Join(*received, ",") != "Bearer env-old,Bearer env-new" {
+		t.Errorf("expected one retry with the new token, got %v", *received)
+	}
+
+	// 重新读取后没有变化时不重试
+	server, received = newAuthBackend(t)
+	m = newRotatingModel(server.URL, config.CredentialEnv+"CC_TEST_MODEL_TOKEN")
+	if _, _, status, _ := m.Completions(context.Background(), newBackendParameter()); status != StatusModelError || len(*received) != 1 {
+		t.Errorf("expected no retry with an unchanged token, got %s %v", status, *received)
+	}
+}
+
+// to test closing the model stopping the credential watch
+// go test ./pkg/model/ -v -run Test_CredentialClose
+func Test_CredentialClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("Bearer token-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m := newRotatingModel("http://127.0.0.1:0", config.CredentialFile+path)
+	m.Close()
+	m.Close()
+	if err := os.WriteFile(path, []byte("Bearer token-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if token := m.cred.Get(); token != "Bearer token-1" {
+		t.Errorf("expected no rotation after close, got %q", token)
+	}
+}
@@ -1,6 +1,6 @@
 package model
 
-//	前置模块处理完毕后给到模型进行调用的参数信息
+// 前置模块处理完毕后给到模型进行调用的参数信息
 type CompletionParameter struct {
 	CompletionID string   `json:"completionID"` // 补全请求ID，用于唯一标识一次补全请求
 	ClientID     string   `json:"clientID"`     // 用户ID，唯一标识发起补全请求的用户
@@ -13,26 +13,234 @@ type CompletionParameter struct {
 	Suffix       string   `json:"suffix"`       // 后缀
 	CodeContext  string   `json:"context"`      // 上下文
 	Verbose      bool     `json:"verbose"`      // 是否需要更详细的回复，帮助调试
+	TriggerMode  string   `json:"triggerMode"`  // 触发方式(AUTO/MANUAL/CONTINUE)
+	Block        string   `json:"block"`        // 单文件组件中光标所在的区块(script/template/style)，为空表示整个文件
+	PruneMode    string   `json:"pruneMode"`    // 请求的修剪模式(full/light/off)，为空表示full
+	// 严格截止时间(毫秒)，到时立即返回已得到的最好结果(可能为空)，为0按普通请求处理，见completions.StrictDeadline
+	StrictDeadlineMs int `json:"strictDeadlineMs,omitempty"`
+
+	Budget    *BudgetReport `json:"-"` // 请求verbose时记录提示词预算，调用模型后附加到Verbose
+	HideScore *float64      `json:"-"` // 隐藏分，只在自动触发且计算了隐藏分时存在，用于计算置信度
+	Style     *StyleProfile `json:"-"` // 推断的代码风格，用于缩进和引号规范化修剪器，并附加到Verbose
+	Literal   string        `json:"-"` // 光标所在字符串字面量的种类，为空表示不在字符串中，见completions.StringLiteral
+	Arguments *ArgumentList `json:"-"` // 光标所在调用的参数列表，为nil表示不在调用中，用于裁剪补全中重复的参数
+	// 没有语言配置的语言回退到的语言族，为空表示不回退，full模式使用语言族的后置处理器
+	LanguageFamily string `json:"-"`
+	// 截断后本地计算的提示词token数，为0表示没有分词(没有tokenizer)，用于校准本地与上游的token数
+	PromptTokens int `json:"-"`
+	// 截断提示词时使用的token数校准系数，为0表示没有截断
+	TokenFactor float64 `json:"-"`
+	// 用户请求的Authorization头，认证方式为passthrough/both-fallback时转发给模型后端，不记录日志
+	Authorization string `json:"-"`
+	// 请求所属的租户，按请求的凭据确定，用于模型池的公平调度和用量统计，为空表示default租户
+	Tenant string `json:"-"`
+	// 插件的最大渲染宽度(列数)，为0表示不限制，超宽的补全行按语言折行或截断
+	MaxLineWidth int `json:"-"`
+	// 截断提示词时生效的后缀策略(full/first_k_lines/none)及first_k_lines的行数，附加到Verbose
+	SuffixPolicy string `json:"-"`
+	SuffixLines  int    `json:"-"`
+	// 极小请求走快速路径，不请求verbose时不在Verbose中记录模型的原始响应
+	FastPath bool `json:"-"`
+	// 截断后代码库上下文各片段的来源，与Budget.Snippets相同，上下文长度超限重试时按重新截断的结果更新
+	Provenance []ContextSnippet `json:"-"`
 }
 
 type CompletionVerbose struct {
-	Id     string                 `json:"id"`
-	Input  map[string]interface{} `json:"input"`
-	Output map[string]interface{} `json:"output,omitempty"`
+	Id           string                 `json:"id"`
+	Input        map[string]interface{} `json:"input"`
+	Request      interface{}            `json:"request,omitempty"` // 发往模型后端的请求体，OpenAI接口为类型化的结构，不再复制到Input
+	Output       map[string]interface{} `json:"output,omitempty"`
+	Attempts     []CompletionAttempt    `json:"attempts,omitempty"`     // 后置处理丢弃补全后重试时，记录每次尝试
+	PruneMode    string                 `json:"pruneMode,omitempty"`    // 实际生效的修剪模式
+	Usage        *CompletionUsage       `json:"usage,omitempty"`        // 模型返回多个候选时，上游报告的所有候选合计的用量
+	ChoiceTokens []int                  `json:"choiceTokens,omitempty"` // 模型返回多个候选时，每个候选的补全token数
+	Budget       *BudgetReport          `json:"budget,omitempty"`       // 请求verbose时，提示词各部分的token预算和各阶段耗时
+	Confidence   *ConfidenceReport      `json:"confidence,omitempty"`   // 置信度的各信号取值和权重，用于调整权重
+	AuthMode     string                 `json:"authMode,omitempty"`     // 调用模型后端的认证方式
+	KeySource    string                 `json:"keySource,omitempty"`    // 实际使用的认证信息来源(server/user/server-fallback)，不记录认证信息本身
+	Style        *StyleProfile          `json:"style,omitempty"`        // 推断的代码风格
+	Extraction   *ResponseExtraction    `json:"extraction,omitempty"`   // 从模型输出中提取代码块时，去掉的内容
+	TokenFactor  float64                `json:"tokenFactor,omitempty"`  // 截断提示词时使用的token数校准系数
+	SuffixPolicy string                 `json:"suffixPolicy,omitempty"` // 截断提示词时生效的后缀策略
+	SuffixLines  int                    `json:"suffixLines,omitempty"`  // first_k_lines策略发送的后缀行数
+}
+
+// 调用模型后端时认证信息的来源
+const (
+	KeySourceServer         = "server"          // 配置的authorization
+	KeySourceUser           = "user"            // 用户请求的Authorization头
+	KeySourceServerFallback = "server-fallback" // 用户的认证信息被模型后端拒绝(401)后，改用配置的authorization
+)
+
+// 补全置信度的计算过程，Signals只包含本次请求存在的信号
+type ConfidenceReport struct {
+	Signals map[string]float64 `json:"signals"`
+	Weights map[string]float64 `json:"weights"`
+	Score   float64            `json:"score"`
+}
+
+// 提示词的一个组成部分在服务端的用量
+type BudgetSection struct {
+	Bytes         int `json:"bytes"`                   // 收到的字节数
+	Tokens        int `json:"tokens"`                  // 截断前的token数
+	Kept          int `json:"kept"`                    // 截断后保留的token数，不含截断标记
+	RemovedTokens int `json:"removedTokens,omitempty"` // 截掉的token数
+	RemovedLines  int `json:"removedLines,omitempty"`  // 截掉的行数，只截掉一部分的行不计入
+	MarkerTokens  int `json:"markerTokens,omitempty"`  // 截断处插入的标记的token数，计入promptTokens
+}
+
+// 各阶段耗时(微秒)
+type BudgetLatency struct {
+	ContextUs  int64 `json:"contextUs"`  // 获取代码库上下文
+	TokenizeUs int64 `json:"tokenizeUs"` // 截断提示词时的分词
+	QueueUs    int64 `json:"queueUs"`    // 排队
+	ModelUs    int64 `json:"modelUs"`    // 调用模型，不含后置处理
+	PruneUs    int64 `json:"pruneUs"`    // 后置处理(含重试时的两次)
+	TotalUs    int64 `json:"totalUs"`    // 从收到请求到返回响应
+}
+
+/**
+ * 提示词预算报告，帮助插件调整发送的前缀/后缀长度
+ * @description
+ * - Sections: prefix/suffix/import_content/client_context/codebase_context各部分收到的字节数，截断前后的token数
+ * - import_content只用于检索，不发给模型，不计token
+ * - Providers: 各代码库检索(去重后)贡献的token数，按字节占比从codebase_context截断前的token数中分摊
+ * - 只使用截断提示词时已经计算的token数，不额外分词；没有tokenizer的模型token数都为0
+ * - suffix的token数按后缀策略裁剪之后计算，策略不发送的后缀让出的预算记录在ReallocatedTokens
+ */
+type BudgetReport struct {
+	Sections          map[string]*BudgetSection `json:"sections"`
+	Providers         map[string]int            `json:"providers,omitempty"`
+	PreambleTokens    int                       `json:"preambleTokens,omitempty"`    // 提示词前言的token数
+	SeparatorTokens   int                       `json:"separatorTokens,omitempty"`   // 上下文与前缀之间分隔符的token数
+	FenceTokens       int                       `json:"fenceTokens,omitempty"`       // 标注检索上下文的围栏的token数
+	PromptTokens      int                       `json:"promptTokens"`                // 最终提示词的token数
+	ModelWindow       int                       `json:"modelWindow"`                 // 模型的输入窗口(MaxPrefix+MaxSuffix)
+	SuffixPolicy      string                    `json:"suffixPolicy,omitempty"`      // 生效的后缀策略
+	ReallocatedTokens int                       `json:"reallocatedTokens,omitempty"` // 后缀策略让给前缀和上下文的token数
+	Snippets          []ContextSnippet          `json:"snippets,omitempty"`          // 代码库上下文各片段的来源，按在上下文中的顺序
+	Latency           BudgetLatency             `json:"latency"`
+}
+
+// 上下文片段没有进入提示词的原因
+const (
+	SnippetDedup    = "dedup"    // 与之前的检索返回的片段内容相同
+	SnippetBudget   = "budget"   // 超出模型的输入窗口被整个截掉
+	SnippetInvalid  = "invalid"  // 检索结果不合格(内容为空、超长或文件路径不合理)，Detail为不合格的原因
+	SnippetRejected = "rejected" // 同一检索中不合格的结果太多，整个检索结果作废
+	SnippetIgnored  = "ignored"  // 片段所在的文件命中context.ignore的忽略规则，Detail为命中的规则
+)
+
+/**
+ * 代码库上下文中一个片段的来源，用于排查不好的补全
+ * @description
+ * - Provider/FilePath/Score: 返回该片段的检索、片段所在的文件和检索给出的相关性分数
+ * - Offset/Bytes: 片段(含文件路径行)在加上注释后的上下文中的位置和字节数，被去重的片段为0
+ * - Tokens/Kept: 截断前后的token数，按字节占比从上下文的token数换算；只截掉开头一部分时Kept小于Tokens
+ * - Sanitized: 片段内容命中的注入过滤规则名称，命中的内容已替换为[filtered]
+ * - Dropped: 没有进入提示词的原因，见Snippet*，开启截断标记时才报告没有进入提示词的片段
+ * - Detail: 不合格的原因(见codebase_context.Invalid*)或命中的忽略规则
+ * - DuplicateOf: 被去重时，保留了相同内容的检索
+ * - KeptBytes/Tail: 截断后保留的字节数，以及片段之后到最终上下文(含围栏)末尾的字节数，用于上下文长度超限重试时重新计算Kept
+ */
+type ContextSnippet struct {
+	Provider    string   `json:"provider"`
+	FilePath    string   `json:"filePath"`
+	Score       float64  `json:"score,omitempty"`
+	Offset      int      `json:"-"`
+	Bytes       int      `json:"bytes"`
+	Tokens      int      `json:"tokens"`
+	Kept        int      `json:"kept"`
+	Dropped     string   `json:"dropped,omitempty"`
+	Detail      string   `json:"detail,omitempty"`
+	DuplicateOf string   `json:"duplicateOf,omitempty"`
+	Sanitized   []string `json:"sanitized,omitempty"`
+	KeptBytes   int      `json:"-"`
+	Tail        int      `json:"-"`
+}
+
+// 代码风格的来源
+const (
+	StyleSourceFile   = "file"   // 本文件的前缀和后缀
+	StyleSourceClient = "client" // 该客户端历史请求累计的风格档案
+)
+
+/**
+ * 一项代码风格偏好
+ * @description
+ * - Value: 缩进为tab/space，引号为single/double，大括号为same-line/next-line
+ * - Width: 只用于缩进，空格缩进的宽度，未推断出时为0
+ * - Confidence: Value在统计中的占比
+ */
+type StylePreference struct {
+	Value      string  `json:"value"`
+	Width      int     `json:"width,omitempty"`
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source"`
+}
+
+// 推断的代码风格，没有明确偏好的项为nil
+type StyleProfile struct {
+	Language string           `json:"language"`
+	Samples  float64          `json:"samples"` // 客户端档案中该语言的累计权重(已衰减)
+	Indent   *StylePreference `json:"indent,omitempty"`
+	Quote    *StylePreference `json:"quote,omitempty"`
+	Brace    *StylePreference `json:"brace,omitempty"`
+}
+
+/**
+ * 光标所在调用(或结构体字面量)的参数列表，见completions.detectArguments
+ * @description
+ * - Open/Close: 参数列表的括号
+ * - Assign: 命名参数的赋值符号，为空表示只有位置参数
+ * - Names: 前缀和后缀中已有的命名参数(或字段)名
+ * - End: 后缀中到闭合括号为止的字节数，截断后缀时不在参数列表中截断
+ * - AtStart: 光标在一个参数的开头
+ * - Continues: 光标后紧接着","，补全只应完成当前参数
+ */
+type ArgumentList struct {
+	Open      string   `json:"open"`
+	Close     string   `json:"close"`
+	Assign    string   `json:"assign,omitempty"`
+	Names     []string `json:"names,omitempty"`
+	End       int      `json:"end"`
+	AtStart   bool     `json:"atStart,omitempty"`
+	Continues bool     `json:"continues,omitempty"`
+}
+
+// 一次模型调用及其后置处理的记录
+type CompletionAttempt struct {
+	Temperature float32                `json:"temperature"`      // 本次调用的温度
+	Text        string                 `json:"text"`             // 模型输出的补全内容
+	Pruned      string                 `json:"pruned"`           // 后置处理后的补全内容
+	Hits        []string               `json:"hits,omitempty"`   // 命中的后置处理器
+	Output      map[string]interface{} `json:"output,omitempty"` // 模型的原始响应
+}
+
+// 从指令模型的输出中提取代码块的记录
+type ResponseExtraction struct {
+	Fences   int    `json:"fences"`             // 模型输出中的代码块数
+	Chosen   int    `json:"chosen"`             // 选中的代码块序号(从0开始)
+	Language string `json:"language,omitempty"` // 选中的代码块的语言标记
+	Leading  string `json:"leading,omitempty"`  // 去掉的代码块之前的内容(如说明文字)
+	Trailing string `json:"trailing,omitempty"` // 去掉的代码块之后的内容
 }
 
 type CompletionStatus string
 
 const (
-	StatusSuccess     CompletionStatus = "success"     //补全成功
-	StatusReqError    CompletionStatus = "reqError"    //请求存在错误
-	StatusServerError CompletionStatus = "serverError" //服务端错误
-	StatusModelError  CompletionStatus = "modelError"  //模型响应错误
-	StatusEmpty       CompletionStatus = "empty"       //补全结果为空
-	StatusRejected    CompletionStatus = "rejected"    //根据规则拒绝补全
-	StatusTimeout     CompletionStatus = "timeout"     //补全请求超时
-	StatusCanceled    CompletionStatus = "canceled"    //用户取消
-	StatusBusy        CompletionStatus = "busy"        //服务端繁忙
+	StatusSuccess      CompletionStatus = "success"      //补全成功
+	StatusReqError     CompletionStatus = "reqError"     //请求存在错误
+	StatusServerError  CompletionStatus = "serverError"  //服务端错误
+	StatusModelError   CompletionStatus = "modelError"   //模型响应错误
+	StatusEmpty        CompletionStatus = "empty"        //补全结果为空
+	StatusRejected     CompletionStatus = "rejected"     //根据规则拒绝补全
+	StatusTimeout      CompletionStatus = "timeout"      //补全请求超时
+	StatusCanceled     CompletionStatus = "canceled"     //用户取消
+	StatusBusy         CompletionStatus = "busy"         //服务端繁忙
+	StatusUnauthorized CompletionStatus = "unauthorized" //缺少或被模型后端拒绝的用户认证信息
+
+	StatusDeliveredFailed CompletionStatus = "deliveredFailed" //补全已完成，但写响应时客户端连接已断开
 )
 
 //	OpenAI v1/completions协议的请求和响应结构定义
@@ -50,6 +258,11 @@ type CompletionRequest struct {
 	Stream           bool     `json:"stream,omitempty"`
 	Echo             bool     `json:"echo,omitempty"`
 	Suffix           string   `json:"suffix,omitempty"`
+	// 严格截止时间(毫秒)，不是OpenAI的标准参数，见CompletionParameter.StrictDeadlineMs
+	StrictDeadlineMs int `json:"strict_deadline_ms,omitempty"`
+
+	Authorization string `json:"-"` // 用户请求的Authorization头，见CompletionParameter.Authorization
+	Tenant        string `json:"-"` // 请求所属的租户，见CompletionParameter.Tenant
 }
 
 type CompletionChoice struct {
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/tokenizers"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// llama.cpp server /completion、/infill 的响应(非流式)
+type llamaCppResponse struct {
+	Model           string `json:"model"`
+	Content         string `json:"content"`
+	StopType        string `json:"stop_type"`     // eos/word/limit/none
+	StoppedLimit    bool   `json:"stopped_limit"` // 旧版本没有stop_type
+	TokensPredicted int    `json:"tokens_predicted"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+}
+
+/**
+ * llama.cpp server后端，provider为llamacpp
+ * @description
+ * - completionsUrl指向 /infill 时，使用原生的input_prefix/input_suffix/input_extra字段，由服务端组装FIM提示词
+ * - 指向 /completion 时与OpenAIModel一致：FIM模式下使用配置的FIM标记组装提示词，否则只发送上下文和前缀
+ * - 服务端只加载一个模型，不需要modelName
+ */
+type LlamaCppModel struct {
+	cfg       *config.ModelConfig
+	tokenizer *tokenizers.Tokenizer
+	infill    bool
+}
+
+func NewLlamaCppModel(c *config.ModelConfig, t *tokenizers.Tokenizer) LLM {
+	return &LlamaCppModel{
+		cfg:       c,
+		tokenizer: t,
+		infill:    isInfillUrl(c.CompletionsUrl),
+	}
+}
+
+func (m *LlamaCppModel) Config() *config.ModelConfig {
+	return m.cfg
+}
+
+func (m *LlamaCppModel) Tokenizer() *tokenizers.Tokenizer {
+	return m.tokenizer
+}
+
+// 是否为llama.cpp的 /infill 接口
+func isInfillUrl(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(strings.TrimRight(u.Path, "/"), "/infill")
+}
+
+// llama.cpp的错误响应为 {"error": {"code": 400, "message": "...", "type": "..."}}
+func llamaCppErrorMessage(body []byte) string {
+	var rsp struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &rsp) != nil || rsp.Error.Message == "" {
+		return strings.TrimSpace(string(body))
+	}
+	if rsp.Error.Type != "" {
+		return rsp.Error.Type + ": " + rsp.Error.Message
+	}
+	return rsp.Error.Message
+}
+
+func (m *LlamaCppModel) Completions(ctx context.Context, p *CompletionParameter) (*CompletionResponse, *CompletionVerbose, CompletionStatus, error) {
+	data := map[string]interface{}{
+		"n_predict":    min(p.MaxTokens, m.cfg.MaxOutput),
+		"temperature":  p.Temperature,
+		"stop":         p.Stop,
+		"stream":       false,
+		"cache_prompt": true,
+	}
+	switch {
+	case m.infill:
+		data["input_prefix"] = p.Prefix
+		data["input_suffix"] = p.Suffix
+		if p.CodeContext != "" {
+			data["input_extra"] = []map[string]string{{"filename": "context", "text": p.CodeContext}}
+		}
+	default:
+		data["prompt"] = BuildPrompt(m.cfg, p)
+	}
+	var verbose CompletionVerbose
+	verbose.Id = m.cfg.ModelTitle
+	verbose.Input = data
+
+	body, status, err := postBackend(ctx, m.cfg, data, llamaCppErrorMessage, p, &verbose)
+	if err != nil {
+		return nil, &verbose, status, err
+	}
+	var rsp llamaCppResponse
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return nil, &verbose, StatusServerError, err
+	}
+	finishReason := "stop"
+	if rsp.StopType == "limit" || rsp.StoppedLimit {
+		finishReason = "length"
+	}
+	model := rsp.Model
+	if model == "" {
+		model = m.cfg.ModelName
+	}
+	return singleChoiceResponse(model, rsp.Content, finishReason, rsp.TokensEvaluated, rsp.TokensPredicted),
+		&verbose, StatusSuccess, nil
+}
@@ -4,6 +4,7 @@ import (
 	"code-completion/pkg/config"
 	"code-completion/pkg/tokenizers"
 	"context"
+	"errors"
 )
 
 type LLM interface {
@@ -11,3 +12,27 @@ type LLM interface {
 	Config() *config.ModelConfig
 	Tokenizer() *tokenizers.Tokenizer
 }
+
+// 持有后台资源(如认证信息的跟踪协程)的模型，结束流控制器时释放，见StreamController.Stop
+type Closer interface {
+	Close()
+}
+
+// 截止时间前拿不到出站限流的令牌，请求没有发往模型后端
+var ErrRateLimited = errors.New("model rate limit exceeded")
+
+type rateLimitKey struct{}
+
+// 将模型池的出站限流放入ctx，每次向模型后端发送请求(包括重试和认证回退的重发)前调用wait，返回false时不发送
+func WithRateLimit(ctx context.Context, wait func(ctx context.Context) bool) context.Context {
+	return context.WithValue(ctx, rateLimitKey{}, wait)
+}
+
+// 发送请求前等待ctx中的出站限流，没有限流时直接返回true
+func waitRateLimit(ctx context.Context) bool {
+	if ctx == nil {
+		return true
+	}
+	wait, _ := ctx.Value(rateLimitKey{}).(func(ctx context.Context) bool)
+	return wait == nil || wait(ctx)
+}
@@ -9,10 +9,11 @@ import (
 	"go.uber.org/zap"
 )
 
+// 模型实例，下标与config.Config.Models一致，配置无效未初始化的模型为nil
+// 选择模型(含同一个逻辑模型的多个副本)由stream_controller的模型池负责，这里只按下标提供实例
 type OpenAIModelManager struct {
 	models []LLM
 	mutex  sync.Mutex
-	index  int
 }
 
 type NewLLM func(*config.ModelConfig, *tokenizers.Tokenizer) LLM
@@ -20,27 +21,11 @@ type NewLLM func(*config.ModelConfig, *tokenizers.Tokenizer) LLM
 var modelDefs = map[string]NewLLM{
 	"openai":   NewOpenAIModel,
 	"deepseek": NewOpenAIModel,
+	"ollama":   NewOllamaModel,
+	"llamacpp": NewLlamaCppModel,
 }
 
-func GetAutoModel() LLM {
-	manager.mutex.Lock()
-	defer manager.mutex.Unlock()
-	modelLen := len(manager.models)
-	if modelLen == 0 {
-		panic(manager)
-	}
-	// 采用轮转法选择模型进行响应
-	var model LLM
-	if manager.index < modelLen {
-		model = manager.models[manager.index]
-		manager.index++
-	} else {
-		manager.index = 1
-		model = manager.models[0]
-	}
-	return model
-}
-
+// 获取config.Config.Models中下标为idx的模型实例，该配置无效未初始化时返回nil
 func GetModel(idx int) LLM {
 	manager.mutex.Lock()
 	defer manager.mutex.Unlock()
@@ -53,10 +38,39 @@ func GetModel(idx int) LLM {
 
 var manager = &OpenAIModelManager{}
 
+// 直接使用给定的模型实例，顺序与config.Config.Models一致，用于内置的压测模式(模型后端为模拟服务)
+func Use(models []LLM) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.models = models
+}
+
+/**
+ * 按配置初始化模型实例
+ * @param {[]config.ModelConfig} cfgModels - 模型配置
+ * @returns {error} 同一个逻辑模型的副本配置不一致，或没有可用的模型时返回错误
+ * @description
+ * - 配置无效的模型跳过，其它模型的下标不变，见GetModel
+ * - modelName相同的配置是同一个逻辑模型的副本，见config.ValidateReplicas
+ */
 func Init(cfgModels []config.ModelConfig) error {
-	models := make([]LLM, 0)
-	for _, c := range cfgModels {
-		token, err := tokenizers.NewTokenizer(c.TokenizerPath)
+	if err := config.ValidateReplicas(cfgModels); err != nil {
+		return err
+	}
+	models := make([]LLM, len(cfgModels))
+	available := 0
+	for i, c := range cfgModels {
+		if err := c.Validate(); err != nil {
+			zap.L().Error("invalid model config", zap.Error(err))
+			continue
+		}
+		// 相同路径的tokenizer只加载一次，由各模型共享
+		token, err := tokenizers.Acquire(c.TokenizerPath, c.ModelTitle)
+		if err != nil && config.DevMode {
+			// 开发模式下没有tokenizer文件时也能启动，不按token截断提示词
+			zap.L().Warn("Tokenizer unavailable in dev mode, prompts are not truncated by tokens", zap.Error(err))
+			token, err = nil, nil
+		}
 		if err != nil {
 			zap.L().Error("init tokenizer error", zap.String("tokenizerPath", c.TokenizerPath), zap.Error(err))
 			continue
@@ -65,12 +79,17 @@ func Init(cfgModels []config.ModelConfig) error {
 		if !exists {
 			newLLM = NewOpenAIModel
 		}
-		models = append(models, newLLM(&c, token))
+		models[i] = newLLM(&c, token)
+		available++
 	}
-	if len(models) == 0 {
+	if available == 0 {
 		zap.L().Fatal("No models available")
 		return fmt.Errorf("no models available")
 	}
+	for _, s := range tokenizers.Stats().Tokenizers {
+		zap.L().Info("tokenizer loaded", zap.String("path", s.Path),
+			zap.Int64("memoryBytes", s.MemoryBytes), zap.Strings("models", s.Models))
+	}
 	manager.models = models
 	return nil
 }
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test starting with the embedded development config
+// go test ./pkg/model/ -v -run Test_InitDevMode
+func Test_InitDevMode(t *testing.T) {
+	saved, dev := *config.Config, config.DevMode
+	defer func() { *config.Config, config.DevMode = saved, dev }()
+	defer Use(manager.models)
+
+	server, _ := newAuthBackend(t, "")
+	if _, err := config.Load(config.LoadOptions{Dev: true, DevModelURL: server.URL + "/v1/completions"}); err != nil {
+		t.Fatalf("load dev config failed: %v", err)
+	}
+	if err := Init(config.Config.Models); err != nil {
+		t.Fatalf("init dev model failed: %v", err)
+	}
+	_, _, status, err := GetModel(0).Completions(context.Background(), newBackendParameter())
+	if status != StatusSuccess {
+		t.Errorf("expected a completion from the mock model, got %s (%v)", status, err)
+	}
+}
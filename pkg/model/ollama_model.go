@@ -0,0 +1,93 @@
+package model
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/tokenizers"
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Ollama /api/generate 的响应(非流式)
+type ollamaResponse struct {
+	Model           string `json:"model"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
+/**
+ * Ollama后端，provider为ollama，completionsUrl指向 /api/generate
+ * @description
+ * - 非FIM模式下，后缀通过原生的suffix字段传递，由模型模板组装FIM提示词
+ * - FIM模式下，使用配置的FIM标记组装提示词，并设置raw跳过模型模板
+ * - 温度、最大输出、停止符通过options传递
+ */
+type OllamaModel struct {
+	cfg       *config.ModelConfig
+	tokenizer *tokenizers.Tokenizer
+}
+
+func NewOllamaModel(c *config.ModelConfig, t *tokenizers.Tokenizer) LLM {
+	return &OllamaModel{
+		cfg:       c,
+		tokenizer: t,
+	}
+}
+
+func (m *OllamaModel) Config() *config.ModelConfig {
+	return m.cfg
+}
+
+func (m *OllamaModel) Tokenizer() *tokenizers.Tokenizer {
+	return m.tokenizer
+}
+
+// Ollama的错误响应为 {"error": "..."}
+func ollamaErrorMessage(body []byte) string {
+	var rsp struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &rsp) != nil {
+		return strings.TrimSpace(string(body))
+	}
+	return rsp.Error
+}
+
+func (m *OllamaModel) Completions(ctx context.Context, p *CompletionParameter) (*CompletionResponse, *CompletionVerbose, CompletionStatus, error) {
+	data := map[string]interface{}{
+		"model":  m.cfg.ModelName,
+		"stream": false,
+		"options": map[string]interface{}{
+			"temperature": p.Temperature,
+			"num_predict": min(p.MaxTokens, m.cfg.MaxOutput),
+			"stop":        p.Stop,
+		},
+	}
+	data["prompt"] = BuildPrompt(m.cfg, p)
+	if m.cfg.FimMode {
+		data["raw"] = true
+	} else if p.Suffix != "" {
+		data["suffix"] = p.Suffix
+	}
+	var verbose CompletionVerbose
+	verbose.Id = m.cfg.ModelTitle
+	verbose.Input = data
+
+	body, status, err := postBackend(ctx, m.cfg, data, ollamaErrorMessage, p, &verbose)
+	if err != nil {
+		return nil, &verbose, status, err
+	}
+	var rsp ollamaResponse
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return nil, &verbose, StatusServerError, err
+	}
+	finishReason := rsp.DoneReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	return singleChoiceResponse(rsp.Model, rsp.Response, finishReason, rsp.PromptEvalCount, rsp.EvalCount),
+		&verbose, StatusSuccess, nil
+}
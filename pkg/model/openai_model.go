@@ -1,29 +1,41 @@
 package model
 
 import (
-	"bytes"
 	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
 	"code-completion/pkg/tokenizers"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 type OpenAIModel struct {
 	cfg       *config.ModelConfig
 	tokenizer *tokenizers.Tokenizer
+	cred      *credential // 配置的认证信息，引用外部来源时可以轮换
 }
 
 func NewOpenAIModel(c *config.ModelConfig, t *tokenizers.Tokenizer) LLM {
+	cred := newCredential(c)
+	cred.watch(c.CredentialRefresh)
 	return &OpenAIModel{
 		cfg:       c,
 		tokenizer: t,
+		cred:      cred,
 	}
 }
 
+// 停止跟踪认证信息的变化，见Closer
+func (m *OpenAIModel) Close() {
+	m.cred.close()
+}
+
 func (m *OpenAIModel) Config() *config.ModelConfig {
 	return m.cfg
 }
@@ -32,72 +44,166 @@ func (m *OpenAIModel) Tokenizer() *tokenizers.Tokenizer {
 	return m.tokenizer
 }
 
-/**
- * 获取加了FIM标记的prompt文本
- * @param {string} prefix - 代码前缀文本
- * @param {string} suffix - 代码后缀文本
- * @param {string} codeContext - 代码上下文文本
- * @param {*config.ModelConfig} cfg - 模型配置，包含FIM相关标记
- * @returns {string} 返回添加了FIM标记的完整prompt文本
- * @description
- * - 按照FIM(Fill In the Middle)格式组装prompt
- * - 使用配置中的FIM标记：FimBegin、FimHole、FimEnd
- * - 格式为：FimBegin + codeContext + "\n" + prefix + FimHole + suffix + FimEnd
- * - 用于支持FIM模式的代码补全
- * @example
- * cfg := &config.ModelConfig{
- *     FimBegin: "<fim-prefix>",
- *     FimHole: "<fim-suffix>",
- *     FimEnd: "<fim-middle>",
- * }
- * prompt := handler.getFimPrompt("function test", "}", "context", cfg)
- * // prompt = "<fim-prefix>context\nfunction test<fim-suffix>}<fim-middle>"
- */
-func (m *OpenAIModel) getFimPrompt(prefix, suffix, codeContext string, cfg *config.ModelConfig) string {
-	return cfg.FimBegin + codeContext + "\n" + prefix + cfg.FimHole + suffix + cfg.FimEnd
-}
-
 func (m *OpenAIModel) Completions(ctx context.Context, p *CompletionParameter) (*CompletionResponse, *CompletionVerbose, CompletionStatus, error) {
-	var prefix string
-	if m.cfg.FimMode {
-		prefix = m.getFimPrompt(p.Prefix, p.Suffix, p.CodeContext, m.cfg)
-	} else {
-		if p.CodeContext != "" {
-			prefix = strings.Join([]string{p.CodeContext, p.Prefix}, "\n")
-		} else {
-			prefix = p.Prefix
-		}
-	}
-	maxTokens := min(p.MaxTokens, m.cfg.MaxOutput)
-	data := map[string]interface{}{
-		"model":       m.cfg.ModelName,
-		"prompt":      prefix,
-		"stop":        p.Stop,
-		"temperature": p.Temperature,
-		"max_tokens":  maxTokens,
-		"stream":      false,
+	req := &openAIRequest{
+		MaxTokens: min(p.MaxTokens, m.cfg.MaxOutput),
+		Model:     m.cfg.ModelName,
+		Prompt:    writePrompt(m.cfg, p),
+		openAIOptions: openAIOptions{
+			Stop:        p.Stop,
+			Temperature: p.Temperature,
+		},
 	}
 	if !m.cfg.FimMode && p.Suffix != "" {
-		data["suffix"] = p.Suffix
+		req.Suffix = p.Suffix
 	}
 	var verbose CompletionVerbose
 	verbose.Id = m.cfg.ModelTitle
-	verbose.Input = data
-	// 将data转换为JSON
-	jsonData, err := json.Marshal(data)
+	verbose.Request = req
+	jsonData, err := encodeRequestBody(req)
 	if err != nil {
 		return nil, &verbose, StatusServerError, err
 	}
+	defer jsonData.release()
 
-	// 创建HTTP请求
-	req, err := http.NewRequestWithContext(ctx, "POST", m.cfg.CompletionsUrl, bytes.NewBuffer(jsonData))
+	authorization, err := m.authorization(p, &verbose)
+	if err != nil {
+		return nil, &verbose, StatusUnauthorized, err
+	}
+	start := time.Now()
+	body, statusCode, status, err := m.send(ctx, jsonData, authorization)
+	if statusCode == http.StatusUnauthorized && verbose.KeySource == KeySourceUser && m.cfg.AuthMode == config.AuthModeBothFallback {
+		logger.FromContext(ctx).Info("User authorization rejected by model, fallback to server authorization",
+			zap.String("url", m.cfg.CompletionsUrl))
+		verbose.KeySource = KeySourceServerFallback
+		authorization = m.cred.Get()
+		start = time.Now()
+		body, statusCode, status, err = m.send(ctx, jsonData, authorization)
+	}
+	if authRejected(statusCode) && verbose.KeySource != KeySourceUser && m.cred.external() {
+		body, statusCode, status, err = m.retryRejected(ctx, jsonData, authorization, statusCode, time.Since(start), body)
+	}
 	if err != nil {
-		return nil, &verbose, StatusReqError, err
+		return nil, &verbose, status, err
+	}
+	recordOutput(p, body, &verbose)
+	if statusCode < 200 || statusCode >= 300 {
+		logger.FromContext(ctx).Warn("Model returned non-200 status", zap.String("url", m.cfg.CompletionsUrl),
+			zap.Int("statusCode", statusCode), zap.String("resp", string(body)))
+		if statusCode == http.StatusUnauthorized && verbose.KeySource == KeySourceUser {
+			return nil, &verbose, StatusUnauthorized, fmt.Errorf("user authorization rejected by model, StatusCode(%d)", statusCode)
+		}
+		if contextLengthExceeded(body) {
+			return nil, &verbose, StatusModelError, fmt.Errorf("%w: Invalid StatusCode(%d)", ErrContextLength, statusCode)
+		}
+		return nil, &verbose, StatusModelError, fmt.Errorf("Invalid StatusCode(%d)", statusCode)
+	}
+	var rsp CompletionResponse
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return nil, &verbose, StatusServerError, err
+	}
+	return &rsp, &verbose, StatusSuccess, nil
+}
+
+/**
+ * 选择调用模型后端的认证信息
+ * @param {*CompletionParameter} p - 补全参数，包含用户请求的Authorization头
+ * @param {*CompletionVerbose} verbose - 记录认证方式和认证信息的来源，不记录认证信息本身
+ * @returns {string} 返回发往模型后端的Authorization头
+ * @returns {error} passthrough模式下用户没有提供认证信息时返回错误
+ * @description
+ * - server: 使用配置的authorization
+ * - passthrough: 只使用用户的Authorization头
+ * - both-fallback: 用户提供时优先使用，否则使用配置的authorization
+ */
+func (m *OpenAIModel) authorization(p *CompletionParameter, verbose *CompletionVerbose) (string, error) {
+	mode := m.cfg.AuthMode
+	if mode == "" {
+		mode = config.AuthModeServer
+	}
+	verbose.AuthMode = mode
+	switch {
+	case mode == config.AuthModeServer:
+	case p.Authorization != "":
+		verbose.KeySource = KeySourceUser
+		return p.Authorization, nil
+	case mode == config.AuthModePassthrough:
+		return "", fmt.Errorf("missing user authorization for model '%s'", m.cfg.ModelTitle)
+	}
+	verbose.KeySource = KeySourceServer
+	return m.cred.Get(), nil
+}
+
+// 模型后端是否拒绝了认证信息
+func authRejected(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+/**
+ * 模型后端拒绝配置的认证信息后重新读取，必要时用新的认证信息重试一次
+ * @param {context.Context} ctx - 请求上下文
+ * @param {*requestBody} jsonData - 请求体
+ * @param {string} rejected - 被拒绝的认证信息
+ * @param {int} statusCode - 被拒绝时的HTTP状态码(401/403)
+ * @param {time.Duration} elapsed - 被拒绝的请求的耗时
+ * @param {[]byte} body - 被拒绝时的响应内容
+ * @returns {[]byte, int, CompletionStatus, error} 返回重试的结果，不重试时返回被拒绝时的结果
+ * @description
+ * - 认证信息可能刚刚过期而变更通知还没有处理，立即重新读取外部来源
+ * - 当前的认证信息与被拒绝的相同时不重试(其它请求或变更通知可能已经替换过)
+ * - 请求的剩余时间少于被拒绝的请求的耗时时不重试
+ * - 记录日志和completion_auth_retries_total，不记录认证信息本身
+ */
+func (m *OpenAIModel) retryRejected(ctx context.Context, jsonData *requestBody, rejected string, statusCode int,
+	elapsed time.Duration, body []byte) ([]byte, int, CompletionStatus, error) {
+	m.cred.refresh(CredentialRejected)
+	outcome := AuthRetryUnchanged
+	authorization := m.cred.Get()
+	if authorization != rejected {
+		outcome = AuthRetryDeadline
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) >= elapsed {
+			outcome = ""
+		}
+	}
+	status := StatusSuccess
+	var err error
+	if outcome == "" {
+		body, statusCode, status, err = m.send(ctx, jsonData, authorization)
+		switch {
+		case err == nil && authRejected(statusCode):
+			outcome = AuthRetryRejected
+		case err == nil && statusCode >= 200 && statusCode < 300:
+			outcome = AuthRetrySuccess
+		default:
+			outcome = AuthRetryFailed
+		}
+	}
+	logger.FromContext(ctx).Warn("Server authorization rejected by model", zap.String("model", m.cfg.ModelTitle),
+		zap.String("url", m.cfg.CompletionsUrl), zap.String("outcome", outcome), zap.Int("statusCode", statusCode))
+	metrics.IncrementAuthRetries(m.cfg.ModelTitle, outcome)
+	return body, statusCode, status, err
+}
+
+// 发送补全请求，返回响应内容和HTTP状态码；请求体的读取器由Transport关闭
+// 每次发送(包括认证回退和认证刷新后的重发)都先等待ctx中的出站限流，见WithRateLimit
+func (m *OpenAIModel) send(ctx context.Context, jsonData *requestBody, authorization string) ([]byte, int, CompletionStatus, error) {
+	if !waitRateLimit(ctx) {
+		return nil, 0, StatusBusy, ErrRateLimited
+	}
+	reader := jsonData.reader()
+	req, err := http.NewRequestWithContext(ctx, "POST", m.cfg.CompletionsUrl, reader)
+	if err != nil {
+		reader.Close()
+		return nil, 0, StatusReqError, err
+	}
+	req.ContentLength = int64(jsonData.Len())
+	req.GetBody = func() (io.ReadCloser, error) {
+		return jsonData.reader(), nil
 	}
 
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", m.cfg.Authorization)
+	req.Header.Set("Authorization", authorization)
 
 	// 发送请求
 	client := &http.Client{
@@ -112,20 +218,14 @@ func (m *OpenAIModel) Completions(ctx context.Context, p *CompletionParameter) (
 		case context.DeadlineExceeded:
 			status = StatusTimeout
 		}
-		return nil, &verbose, status, err
+		logger.FromContext(ctx).Debug("Model request failed", zap.String("url", m.cfg.CompletionsUrl),
+			zap.String("status", string(status)), zap.Error(err))
+		return nil, 0, status, err
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, &verbose, StatusServerError, err
+		return nil, resp.StatusCode, StatusServerError, err
 	}
-	json.Unmarshal(body, &verbose.Output)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, &verbose, StatusModelError, fmt.Errorf("Invalid StatusCode(%d)", resp.StatusCode)
-	}
-	var rsp CompletionResponse
-	if err := json.Unmarshal(body, &rsp); err != nil {
-		return nil, &verbose, StatusServerError, err
-	}
-	return &rsp, &verbose, StatusSuccess, nil
+	return body, resp.StatusCode, StatusSuccess, nil
 }
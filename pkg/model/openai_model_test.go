@@ -0,0 +1,139 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+)
+
+const (
+	serverKey = "Bearer server-secret"
+	userKey   = "Bearer user-secret"
+)
+
+// newAuthBackend emulates an openai compatible backend accepting only the given keys, and records the received keys
+func newAuthBackend(t *testing.T, accepted ...string) (*httptest.Server, *[]string) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Authorization")
+		received = append(received, key)
+		w.Header().Set("Content-Type", "application/json")
+		for _, k := range accepted {
+			if key == k {
+				io.WriteString(w, `{"model":"m","choices":[{"text":"return a + b","index":0,"finish_reason":"stop"}]}`)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"message":"invalid api key"}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+// to test the server, passthrough and both-fallback authorization modes
+// go test ./pkg/model/ -v -run Test_OpenAIAuthMode
+func Test_OpenAIAuthMode(t *testing.T) {
+	cases := []struct {
+		name      string
+		mode      string
+		userKey   string
+		accepted  []string
+		status    CompletionStatus
+		keySource string
+		received  []string
+	}{
+		{"server ignores the user key", "", userKey, []string{serverKey}, StatusSuccess, KeySourceServer, []string{serverKey}},
+		{"passthrough forwards the user key", config.AuthModePassthrough, userKey, []string{userKey}, StatusSuccess, KeySourceUser, []string{userKey}},
+		{"passthrough without user key", config.AuthModePassthrough, "", []string{serverKey}, StatusUnauthorized, "", nil},
+		{"passthrough with rejected user key", config.AuthModePassthrough, userKey, []string{serverKey}, StatusUnauthorized, KeySourceUser, []string{userKey}},
+		{"fallback after upstream 401", config.AuthModeBothFallback, userKey, []string{serverKey}, StatusSuccess, KeySourceServerFallback, []string{userKey, serverKey}},
+		{"fallback without user key", config.AuthModeBothFallback, "", []string{serverKey}, StatusSuccess, KeySourceServer, []string{serverKey}},
+	}
+	for _, c := range cases {
+		server, received := newAuthBackend(t, c.accepted...)
+		m := NewOpenAIModel(&config.ModelConfig{
+			Provider:       "openai",
+			ModelTitle:     "auth",
+			ModelName:      "m",
+			CompletionsUrl: server.URL + "/v1/completions",
+			Authorization:  serverKey,
+			AuthMode:       c.mode,
+			Timeout:        time.Second,
+			MaxOutput:      64,
+		}, nil)
+		p := newBackendParameter()
+		p.Authorization = c.userKey
+
+		_, verbose, status, err := m.Completions(context.Background(), p)
+		if status != c.status {
+			t.Errorf("%s: expected status %s, got %s (%v)", c.name, c.status, status, err)
+		}
+		if c.keySource != "" && verbose.KeySource != c.keySource {
+			t.Errorf("%s: expected key source %s, got %s", c.name, c.keySource, verbose.KeySource)
+		}
+		if strings.Join(*received, ",") != strings.Join(c.received, ",") {
+			t.Errorf("%s: expected keys %v sent upstream, got %v", c.name, c.received, *received)
+		}
+		data, _ := json.Marshal(verbose)
+		if strings.Contains(string(data), "secret") {
+			t.Errorf("%s: verbose leaks the key material: %s", c.name, data)
+		}
+		if err != nil && strings.Contains(err.Error(), "secret") {
+			t.Errorf("%s: error leaks the key material: %v", c.name, err)
+		}
+	}
+}
+
+// to test every send to the backend, including the both-fallback resend, waiting for the rate limit
+// go test ./pkg/model/ -v -run Test_OpenAIRateLimit
+func Test_OpenAIRateLimit(t *testing.T) {
+	server, received := newAuthBackend(t, serverKey)
+	m := NewOpenAIModel(&config.ModelConfig{
+		Provider:       "openai",
+		ModelTitle:     "limited",
+		ModelName:      "m",
+		CompletionsUrl: server.URL + "/v1/completions",
+		Authorization:  serverKey,
+		AuthMode:       config.AuthModeBothFallback,
+		Timeout:        time.Second,
+		MaxOutput:      64,
+	}, nil)
+	p := newBackendParameter()
+	p.Authorization = userKey
+
+	waits := 0
+	ctx := WithRateLimit(context.Background(), func(context.Context) bool {
+		waits++
+		return true
+	})
+	if _, _, status, err := m.Completions(ctx, p); status != StatusSuccess {
+		t.Fatalf("expected success, got %s (%v)", status, err)
+	}
+	if waits != 2 {
+		t.Errorf("expected the rejected send and the resend to wait for tokens, got %d waits", waits)
+	}
+
+	// 重发拿不到令牌时不发送
+	*received = nil
+	tokens := 1
+	ctx = WithRateLimit(context.Background(), func(context.Context) bool {
+		tokens--
+		return tokens >= 0
+	})
+	_, _, status, err := m.Completions(ctx, p)
+	if status != StatusBusy || !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected the resend throttled, got %s (%v)", status, err)
+	}
+	if len(*received) != 1 {
+		t.Errorf("expected only the first send upstream, got %v", *received)
+	}
+}
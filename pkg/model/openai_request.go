@@ -0,0 +1,226 @@
+package model
+
+import (
+	"bytes"
+	"code-completion/pkg/config"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+/**
+ * 发往OpenAI兼容接口的补全请求体
+ * @description
+ * - 字段按JSON名称排序，序列化结果与按map序列化(键按字母排序)的请求体逐字节相同
+ * - Prompt是预先序列化的JSON字符串，见writePrompt；其余字段见openAIOptions
+ * - 同时作为Verbose.Request，记录实际发给模型的内容
+ */
+type openAIRequest struct {
+	MaxTokens int            `json:"max_tokens"`
+	Model     string         `json:"model"`
+	Prompt    promptEnvelope `json:"prompt"`
+	openAIOptions
+}
+
+// 请求体中排在prompt之后的字段，Seed、TopP为nil时不发送
+type openAIOptions struct {
+	Seed        *int64   `json:"seed,omitempty"`
+	Stop        []string `json:"stop"`
+	Stream      bool     `json:"stream"`
+	Suffix      string   `json:"suffix,omitempty"`
+	Temperature float32  `json:"temperature"`
+	TopP        *float32 `json:"top_p,omitempty"`
+}
+
+// 预先序列化的提示词(带引号的JSON字符串)，序列化请求体和Verbose时原样输出
+type promptEnvelope []byte
+
+func (e promptEnvelope) MarshalJSON() ([]byte, error) {
+	return e, nil
+}
+
+/**
+ * 把提示词的各部分直接转义写成一个JSON字符串，不拼接中间字符串
+ * @param {*config.ModelConfig} cfg - 模型配置，包含FIM标记和上下文分隔符
+ * @param {*CompletionParameter} p - 补全参数，包含前缀、后缀和代码上下文
+ * @returns {promptEnvelope} 返回与json.Marshal(BuildPrompt(cfg, p))相同的内容
+ * @description
+ * - 各部分见promptParts，每部分只拷贝一次
+ * - 转义规则与encoding/json相同(转义HTML字符和U+2028/U+2029)
+ * - 各部分分别转义，跨部分的不完整UTF-8字符按无效字节处理
+ */
+func writePrompt(cfg *config.ModelConfig, p *CompletionParameter) promptEnvelope {
+	parts := promptParts(cfg, p)
+	size := 2
+	for _, part := range parts {
+		size += escapedLen(part)
+	}
+	dst := make([]byte, 0, size)
+	dst = append(dst, '"')
+	for _, part := range parts {
+		dst = appendEscaped(dst, part)
+	}
+	return append(dst, '"')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// ASCII字符转义后的长度，1表示不需要转义
+var escapedWidth = func() (width [utf8.RuneSelf]byte) {
+	for b := range width {
+		switch {
+		case b == '"' || b == '\\' || b == '\b' || b == '\f' || b == '\n' || b == '\r' || b == '\t':
+			width[b] = 2
+		case b < 0x20 || b == '<' || b == '>' || b == '&':
+			width[b] = 6
+		default:
+			width[b] = 1
+		}
+	}
+	return
+}()
+
+// 字符串转义后的长度，用于一次分配足够的空间；非ASCII字节按原样计算，U+2028/U+2029和无效的UTF-8很少出现，不足时再扩容
+func escapedLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; b < utf8.RuneSelf {
+			n += int(escapedWidth[b])
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// 按encoding/json的规则转义字符串(不含引号)，含无效UTF-8的字符串交给encoding/json处理
+func appendEscaped(dst []byte, s string) []byte {
+	mark, start := len(dst), 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if escapedWidth[b] == 1 {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			quoted, _ := json.Marshal(s)
+			return append(dst[:mark], quoted[1:len(quoted)-1]...)
+		}
+		if c == '\u2028' || c == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[c&0xF])
+			start = i + size
+		}
+		i += size
+	}
+	return append(dst, s[start:]...)
+}
+
+// 请求体的缓冲区池，超过maxPooledBody的缓冲区不放回
+var requestBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+const maxPooledBody = 256 * 1024
+
+/**
+ * 复用缓冲区的请求体
+ * @description
+ * - 每次发送通过reader取得一个读取器，所有读取器关闭且release后缓冲区放回池中
+ * - http.Transport可能在RoundTrip返回后才读完并关闭请求体，因此不能在发送返回后直接放回
+ */
+type requestBody struct {
+	buf  *bytes.Buffer
+	refs atomic.Int32
+}
+
+/**
+ * 序列化请求体到复用的缓冲区
+ * @param {*openAIRequest} req - 请求体
+ * @returns {*requestBody} 返回请求体，用完后调用release
+ * @returns {error} 序列化失败时返回错误
+ * @description
+ * - prompt之前的字段直接写入，预先序列化的提示词原样拷贝，不经过encoding/json的校验和压缩
+ * - prompt之后的字段用json.Encoder写入，把它的"{"改为","接在提示词之后
+ */
+func encodeRequestBody(req *openAIRequest) (*requestBody, error) {
+	body := &requestBody{buf: requestBuffers.Get().(*bytes.Buffer)}
+	body.buf.Reset()
+	body.buf.Grow(len(req.Prompt) + len(req.Suffix) + 256)
+	body.refs.Store(1)
+
+	head := body.buf.AvailableBuffer()
+	head = append(head, `{"max_tokens":`...)
+	head = strconv.AppendInt(head, int64(req.MaxTokens), 10)
+	head = append(head, `,"model":"`...)
+	head = appendEscaped(head, req.Model)
+	head = append(head, `","prompt":`...)
+	body.buf.Write(head)
+	body.buf.Write(req.Prompt)
+
+	tail := body.buf.Len()
+	if err := json.NewEncoder(body.buf).Encode(&req.openAIOptions); err != nil {
+		body.release()
+		return nil, err
+	}
+	body.buf.Bytes()[tail] = ','
+	// Encoder在末尾追加了换行，去掉以与json.Marshal相同
+	body.buf.Truncate(body.buf.Len() - 1)
+	return body, nil
+}
+
+func (b *requestBody) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *requestBody) Len() int {
+	return b.buf.Len()
+}
+
+func (b *requestBody) reader() io.ReadCloser {
+	b.refs.Add(1)
+	return &bodyReader{Reader: bytes.NewReader(b.buf.Bytes()), body: b}
+}
+
+func (b *requestBody) release() {
+	if b.refs.Add(-1) == 0 && b.buf.Cap() <= maxPooledBody {
+		requestBuffers.Put(b.buf)
+	}
+}
+
+// 请求体的读取器，关闭时释放对缓冲区的引用(只释放一次)
+type bodyReader struct {
+	*bytes.Reader
+	body *requestBody
+	once sync.Once
+}
+
+func (r *bodyReader) Close() error {
+	r.once.Do(r.body.release)
+	return nil
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+)
+
+// 原来按map序列化请求体的方式，用于核对逐字节相同
+func mapRequestBody(cfg *config.ModelConfig, p *CompletionParameter) ([]byte, error) {
+	data := map[string]interface{}{
+		"model":       cfg.ModelName,
+		"prompt":      BuildPrompt(cfg, p),
+		"stop":        p.Stop,
+		"temperature": p.Temperature,
+		"max_tokens":  min(p.MaxTokens, cfg.MaxOutput),
+		"stream":      false,
+	}
+	if !cfg.FimMode && p.Suffix != "" {
+		data["suffix"] = p.Suffix
+	}
+	return json.Marshal(data)
+}
+
+// 与Completions相同的方式构造并序列化请求体
+func typedRequestBody(cfg *config.ModelConfig, p *CompletionParameter) (*requestBody, error) {
+	req := &openAIRequest{
+		MaxTokens: min(p.MaxTokens, cfg.MaxOutput),
+		Model:     cfg.ModelName,
+		Prompt:    writePrompt(cfg, p),
+		openAIOptions: openAIOptions{
+			Stop:        p.Stop,
+			Temperature: p.Temperature,
+		},
+	}
+	if !cfg.FimMode && p.Suffix != "" {
+		req.Suffix = p.Suffix
+	}
+	return encodeRequestBody(req)
+}
+
+// 约6KB的提示词
+func newLargeParameter() *CompletionParameter {
+	p := newBackendParameter()
+	p.CodeContext = strings.Repeat("# helper: if a < b && b > c { return \"x\" }\n", 60)
+	p.Prefix = strings.Repeat("def add(a, b):\n\treturn a + b\n", 120)
+	return p
+}
+
+// to test the typed request body is byte identical to the map based body for escaping edge cases
+// go test ./pkg/model/ -v -run Test_OpenAIRequestBody
+func Test_OpenAIRequestBody(t *testing.T) {
+	fim := config.ModelConfig{ModelName: "m", MaxOutput: 32, FimMode: true, FimBegin: "<|fim_begin|>", FimHole: "<|fim_hole|>", FimEnd: "<|fim_end|>"}
+	plain := config.ModelConfig{ModelName: "m", MaxOutput: 128}
+	texts := []string{
+		"def add(a, b):\n    ",
+		"if a < b && b > c {\r\n\t\"x\\y\"\b\f\x01\x7f}",
+		"中文注释     emoji 😀",
+		"invalid \xff\xfe utf-8 \xe4\xb8",
+		"",
+	}
+	for _, cfg := range []config.ModelConfig{fim, plain} {
+		for _, text := range texts {
+			p := newBackendParameter()
+			p.Prefix, p.Suffix, p.CodeContext = text, text, text
+			want, err := mapRequestBody(&cfg, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := typedRequestBody(&cfg, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got.Bytes()) != string(want) {
+				t.Errorf("fim=%v %q: body differs\nwant %s\ngot  %s", cfg.FimMode, text, want, got.Bytes())
+			}
+			got.release()
+		}
+	}
+	p := newBackendParameter()
+	p.Stop = nil
+	want, _ := mapRequestBody(&plain, p)
+	if got, _ := typedRequestBody(&plain, p); string(got.Bytes()) != string(want) {
+		t.Errorf("nil stop: want %s, got %s", want, got.Bytes())
+	}
+}
+
+// to test the backend receives the same bytes with a content length, and the verbose request keeps the prompt
+// go test ./pkg/model/ -v -run Test_OpenAIRequestWire
+func Test_OpenAIRequestWire(t *testing.T) {
+	var received []byte
+	var contentLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		contentLength = r.ContentLength
+		io.WriteString(w, `{"model":"m","choices":[{"text":"return a + b","index":0,"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+	cfg := &config.ModelConfig{Provider: "openai", ModelTitle: "wire", ModelName: "m", CompletionsUrl: server.URL,
+		Timeout: time.Second, MaxOutput: 64}
+	p := newLargeParameter()
+
+	_, verbose, status, err := NewOpenAIModel(cfg, nil).Completions(context.Background(), p)
+	if status != StatusSuccess {
+		t.Fatalf("expected success, got %s (%v)", status, err)
+	}
+	want, _ := mapRequestBody(cfg, p)
+	if string(received) != string(want) || contentLength != int64(len(want)) {
+		t.Errorf("backend received %d bytes (content length %d), want %d identical bytes", len(received), contentLength, len(want))
+	}
+	out, _ := json.Marshal(verbose)
+	var decoded struct {
+		Request struct {
+			Prompt string `json:"prompt"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(out, &decoded); err != nil || decoded.Request.Prompt != BuildPrompt(cfg, p) {
+		t.Errorf("verbose request should keep the prompt, got %v", err)
+	}
+}
+
+// go test ./pkg/model/ -bench Benchmark_OpenAIRequestBody -benchmem -run ^$
+func Benchmark_OpenAIRequestBodyMap(b *testing.B) {
+	cfg := &config.ModelConfig{ModelName: "m", MaxOutput: 64, FimMode: true, FimBegin: "<B>", FimHole: "<H>", FimEnd: "<E>"}
+	p := newLargeParameter()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := mapRequestBody(cfg, p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_OpenAIRequestBodyTyped(b *testing.B) {
+	cfg := &config.ModelConfig{ModelName: "m", MaxOutput: 64, FimMode: true, FimBegin: "<B>", FimHole: "<H>", FimEnd: "<E>"}
+	p := newLargeParameter()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		body, err := typedRequestBody(cfg, p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		body.release()
+	}
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"code-completion/pkg/config"
+	"strings"
+)
+
+/**
+ * 拼接代码上下文和前缀
+ * @param {*config.ModelConfig} cfg - 模型配置，包含上下文分隔符
+ * @param {string} codeContext - 代码上下文
+ * @param {string} prefix - 代码前缀
+ * @returns {string} 返回codeContext + 分隔符 + prefix，上下文为空时只返回prefix
+ * @description
+ * - 分隔符见config.ModelConfig.GetContextSeparator，默认为"\n"
+ * - 上下文为空时不拼接分隔符，避免在提示词开头多出空行
+ */
+func JoinContext(cfg *config.ModelConfig, codeContext, prefix string) string {
+	if codeContext == "" {
+		return prefix
+	}
+	return codeContext + cfg.GetContextSeparator() + prefix
+}
+
+/**
+ * 组装发给模型的提示词
+ * @param {*config.ModelConfig} cfg - 模型配置，包含FIM标记和上下文分隔符
+ * @param {*CompletionParameter} p - 补全参数，包含前缀、后缀和代码上下文
+ * @returns {string} 返回最终发给模型的提示词
+ * @description
+ * - FIM模式：FimBegin + JoinContext(上下文, 前缀) + FimHole + suffix + FimEnd
+ * - 非FIM模式：JoinContext(上下文, 前缀)，后缀由各供应商通过suffix参数单独传递
+ * - 各部分见promptParts，一次性分配并拷贝，不产生中间字符串
+ * - 最终的提示词记录在Verbose中(OpenAI接口为Verbose.Request，其它为Verbose.Input)，便于核对
+ * @example
+ * cfg := &config.ModelConfig{FimMode: true, FimBegin: "<B>", FimHole: "<H>", FimEnd: "<E>"}
+ * prompt := BuildPrompt(cfg, &CompletionParameter{Prefix: "a", Suffix: "b", CodeContext: "ctx"})
+ * // prompt = "<B>ctx\na<H>b<E>"
+ */
+func BuildPrompt(cfg *config.ModelConfig, p *CompletionParameter) string {
+	parts := promptParts(cfg, p)
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	var sb strings.Builder
+	sb.Grow(size)
+	for _, part := range parts {
+		sb.WriteString(part)
+	}
+	return sb.String()
+}
+
+// 按顺序拼接即为提示词的各部分，空的部分也包含在内
+func promptParts(cfg *config.ModelConfig, p *CompletionParameter) []string {
+	separator := ""
+	if p.CodeContext != "" {
+		separator = cfg.GetContextSeparator()
+	}
+	if !cfg.FimMode {
+		return []string{p.CodeContext, separator, p.Prefix}
+	}
+	return []string{cfg.FimBegin, p.CodeContext, separator, p.Prefix, cfg.FimHole, p.Suffix, cfg.FimEnd}
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"code-completion/pkg/config"
+)
+
+// to test the byte exact prompt assembled for each context and separator
+// go test ./pkg/model/ -v -run Test_BuildPrompt
+func Test_BuildPrompt(t *testing.T) {
+	fence := "\n# ---- end of context ----\n"
+	empty := ""
+	fim := config.ModelConfig{FimMode: true, FimBegin: "<B>", FimHole: "<H>", FimEnd: "<E>"}
+	custom := fim
+	custom.ContextSeparator = &fence
+	none := config.ModelConfig{ContextSeparator: &empty}
+	cases := []struct {
+		cfg      config.ModelConfig
+		context  string
+		expected string
+	}{
+		{fim, "", "<B>def f():<H>\n    pass<E>"},
+		{fim, "# a.py\nx = 1", "<B># a.py\nx = 1\ndef f():<H>\n    pass<E>"},
+		{custom, "# a.py\nx = 1", "<B># a.py\nx = 1\n# ---- end of context ----\ndef f():<H>\n    pass<E>"},
+		{custom, "", "<B>def f():<H>\n    pass<E>"},
+		{config.ModelConfig{}, "", "def f():"},
+		{config.ModelConfig{}, "# a.py", "# a.py\ndef f():"},
+		{none, "# a.py\n", "# a.py\ndef f():"},
+	}
+	for i, c := range cases {
+		p := &CompletionParameter{Prefix: "def f():", Suffix: "\n    pass", CodeContext: c.context}
+		if got := BuildPrompt(&c.cfg, p); got != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package optimize
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/model"
+)
+
+// 每行记录的最大字节数，样本中提示词各部分最多64KB
+const maxRecordBytes = 1 << 20
+
+/**
+ * 一条录制的补全及用户的反馈
+ * @description
+ * - 即补全样本(/api/samples，见stream_controller.Sample)，采样的补全收到下一次请求的采纳反馈后，样本的accepted关联了反馈的结果
+ * - Prompt是截断后发送给模型的提示词，Raw是模型输出的补全内容，按生产的处理器链和录制的请求信息重新修剪Raw
+ */
+type Record struct {
+	CompletionID string `json:"completionId"`
+	Language     string `json:"language"`
+	Prompt       struct {
+		Prefix string `json:"prefix"`
+		Suffix string `json:"suffix"`
+	} `json:"prompt"`
+	Raw       string              `json:"raw"`
+	Family    string              `json:"family,omitempty"`
+	Block     string              `json:"block,omitempty"`
+	Style     *model.StyleProfile `json:"style,omitempty"`
+	Literal   string              `json:"literal,omitempty"`
+	Arguments *model.ArgumentList `json:"arguments,omitempty"`
+	Accepted  *bool               `json:"accepted,omitempty"`
+	Redacted  bool                `json:"redacted,omitempty"`
+}
+
+// 修剪录制的补全使用的请求参数
+func (r *Record) parameter() *model.CompletionParameter {
+	return &model.CompletionParameter{
+		Language:       r.Language,
+		LanguageFamily: r.Family,
+		Block:          r.Block,
+		Prefix:         r.Prompt.Prefix,
+		Suffix:         r.Prompt.Suffix,
+		Style:          r.Style,
+		Literal:        r.Literal,
+		Arguments:      r.Arguments,
+		PruneMode:      completions.PruneFull,
+	}
+}
+
+/**
+ * 加载录制的补全
+ * @param {string} path - 记录文件的路径，每行一个补全样本(JSON Lines)，空行忽略
+ * @returns {[]Record} 返回有采纳反馈的记录
+ * @returns {error} 文件无法读取、记录格式错误，或没有采纳和没有拒绝的记录时返回错误
+ * @description
+ * - 没有采纳反馈(accepted为空)的样本和隐私模式下只有摘要的样本跳过
+ * - 评分需要同时有采纳和拒绝的补全
+ * @example
+ * curl -H "Authorization: Bearer $TOKEN" 'http://host/api/samples?limit=10000' | jq -c '.data[]' > samples.jsonl
+ */
+func LoadCorpus(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	accepted := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordBytes)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(text), &r); err != nil {
+			return nil, fmt.Errorf("invalid corpus '%s' line %d: %w", path, line, err)
+		}
+		if r.Accepted == nil || r.Redacted {
+			continue
+		}
+		if r.Language == "" || r.Raw == "" {
+			return nil, fmt.Errorf("invalid corpus '%s' line %d: record requires language and raw", path, line)
+		}
+		if *r.Accepted {
+			accepted++
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid corpus '%s': %w", path, err)
+	}
+	if accepted == 0 || accepted == len(records) {
+		return nil, fmt.Errorf("invalid corpus '%s': requires both accepted and rejected records, got %d of %d accepted",
+			path, accepted, len(records))
+	}
+	return records, nil
+}
@@ -0,0 +1,94 @@
+package optimize
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 合成的录制补全：采纳的补全以后缀首行(6个字符)结尾，重新生成了行尾，不应被裁剪；
+// 拒绝的补全重复了后缀首行(17个字符)，应被裁剪。只有ignoreOverlapLen=8能同时满足
+func writeCorpus(t *testing.T) string {
+	var buf bytes.Buffer
+	for i := 0; i < 4; i++ {
+		for _, r := range []struct {
+			suffix   string
+			accepted bool
+		}{{"y = 2;", true}, {"total = add(1, 2)", false}} {
+			var rec Record
+			rec.Language = "javascript"
+			rec.Prompt.Prefix = "let x = 0;\n"
+			rec.Prompt.Suffix = r.suffix
+			rec.Raw = "x = 1;\n" + r.suffix
+			accepted := r.accepted
+			rec.Accepted = &accepted
+			data, _ := json.Marshal(rec)
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+	}
+	// 没有采纳反馈的样本跳过
+	buf.WriteString(`{"language":"javascript","prompt":{"prefix":"let x = 0;\n","suffix":"y = 2;"},"raw":"x = 1;\n"}` + "\n")
+	path := filepath.Join(t.TempDir(), "corpus.jsonl")
+	os.WriteFile(path, buf.Bytes(), 0644)
+	return path
+}
+
+func writeSpace(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "space.json")
+	os.WriteFile(path, []byte(content), 0644)
+	return path
+}
+
+// to test the search finds the known optimum on a synthetic corpus and ranks the parameter sensitivities
+// go test ./pkg/optimize/ -v -run Test_OptimizeSynthetic
+func Test_OptimizeSynthetic(t *testing.T) {
+	records, err := LoadCorpus(writeCorpus(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 8 {
+		t.Fatalf("expected the samples without feedback skipped, got %d records", len(records))
+	}
+	space, err := LoadSpace(writeSpace(t, `{"params": {"ignoreOverlapLen": [4, 8, 20], "repetitionRatio": [0.1, 0.15]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Run(records, space, Options{Workers: 2})
+	if len(report.Results) != 6 {
+		t.Fatalf("expected 6 candidates, got %d", len(report.Results))
+	}
+	best := report.Best()
+	if best.Candidate.Params.IgnoreOverlapLen != 8 || best.Score != 1 {
+		t.Errorf("expected ignoreOverlapLen=8 with score 1, got %s with %v", best.Candidate.String(), best.Score)
+	}
+	if s := report.Sensitivities; s[0].Param != "ignoreOverlapLen" || s[0].Best != 8 || s[0].Spread != 1 || s[1].Spread != 0 {
+		t.Errorf("unexpected sensitivities %+v", s)
+	}
+	var out strings.Builder
+	report.Print(&out)
+	if !strings.Contains(out.String(), "ignoreOverlapLen=8 repetitionRatio=0.1") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+
+	// 随机搜索：相同的种子抽取相同的组合
+	space.Search, space.Samples, space.Seed = SearchRandom, 3, 7
+	a, b := space.Candidates(space.Base), space.Candidates(space.Base)
+	if len(a) != 3 || a[0].String() != b[0].String() || a[2].String() != b[2].String() {
+		t.Errorf("unexpected random candidates %v %v", a, b)
+	}
+
+	for _, content := range []string{
+		`{"params": {"maxLines": [1]}}`,
+		`{"params": {"ignoreOverlapLen": [8.5]}}`,
+		`{"search": "random", "params": {"ignoreOverlapLen": [8]}}`,
+		`{"params": {}}`,
+	} {
+		if _, err := LoadSpace(writeSpace(t, content)); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
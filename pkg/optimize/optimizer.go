@@ -0,0 +1,101 @@
+package optimize
+
+import (
+	"runtime"
+	"sync"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+)
+
+/**
+ * 搜索选项
+ * @description
+ * - Workers: 并发评估候选配置的协程数，为0时使用GOMAXPROCS
+ * - Top: 报告中列出的最优配置数，为0时列出5个
+ */
+type Options struct {
+	Workers int
+	Top     int
+}
+
+/**
+ * 一个候选配置的评估结果
+ * @description
+ * - Kept: 采纳的补全中未被修改的比例，越高越好
+ * - Leaked: 拒绝的补全中未被修改(未被修剪或丢弃)的比例，越低越好
+ * - Score: Kept - Leaked
+ */
+type Result struct {
+	Candidate Candidate
+	Kept      float64
+	Leaked    float64
+	Score     float64
+}
+
+/**
+ * 在录制的补全上搜索重复和重叠处理器的阈值
+ * @param {[]Record} records - 录制的补全，见LoadCorpus
+ * @param {*Space} space - 参数空间，见LoadSpace
+ * @param {Options} opts - 搜索选项
+ * @returns {*Report} 返回按得分排序的结果和各参数的敏感度
+ * @description
+ * - 每个候选配置用生产的处理器链(completions.PruneOffline)重新修剪所有补全
+ * - 基准值使用当前配置(wrapper.prune.params)，未加载配置时使用默认值
+ */
+func Run(records []Record, space *Space, opts Options) *Report {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	candidates := space.Candidates(config.Wrapper.Prune.Params)
+	results := make([]Result, len(candidates))
+
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				results[idx] = evaluate(records, candidates[idx])
+			}
+		}()
+	}
+	for idx := range candidates {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+	return newReport(space, results, opts.Top)
+}
+
+// 用候选配置修剪所有补全并评分
+func evaluate(records []Record, c Candidate) Result {
+	accepted, kept, rejected, leaked := 0, 0, 0, 0
+	for i := range records {
+		r := &records[i]
+		res := completions.PruneOffline(&c.Params, r.parameter(), r.Raw)
+		untouched := !res.Discarded && res.Code == r.Raw
+		if *r.Accepted {
+			accepted++
+			if untouched {
+				kept++
+			}
+		} else {
+			rejected++
+			if untouched {
+				leaked++
+			}
+		}
+	}
+	res := Result{Candidate: c}
+	if accepted > 0 {
+		res.Kept = float64(kept) / float64(accepted)
+	}
+	if rejected > 0 {
+		res.Leaked = float64(leaked) / float64(rejected)
+	}
+	res.Score = res.Kept - res.Leaked
+	return res
+}
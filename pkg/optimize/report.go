@@ -0,0 +1,104 @@
+package optimize
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// 报告中默认列出的最优配置数
+const defaultTop = 5
+
+/**
+ * 一个参数的敏感度
+ * @description
+ * - Means: 该参数取各值时所有候选配置的平均得分，按参数空间中取值的顺序
+ * - Spread: 平均得分的最大值与最小值之差，越大说明该参数对结果影响越大
+ * - Best: 平均得分最高的取值
+ */
+type Sensitivity struct {
+	Param  string
+	Values []float64
+	Means  []float64
+	Spread float64
+	Best   float64
+}
+
+/**
+ * 搜索报告
+ * @description
+ * - Results: 按得分从高到低排序，得分相同时保持候选配置的顺序
+ * - Sensitivities: 按Spread从大到小排序
+ */
+type Report struct {
+	Search        string
+	Results       []Result
+	Sensitivities []Sensitivity
+	Top           int
+}
+
+func newReport(space *Space, results []Result, top int) *Report {
+	if top <= 0 {
+		top = defaultTop
+	}
+	sort.SliceStable(results, func(a, b int) bool { return results[a].Score > results[b].Score })
+	r := &Report{Search: space.Search, Results: results, Top: top}
+
+	for _, name := range space.names() {
+		values := space.Params[name]
+		s := Sensitivity{Param: name, Values: values, Means: make([]float64, len(values))}
+		for i, v := range values {
+			sum, n := 0.0, 0
+			for _, res := range results {
+				if res.Candidate.Values[name] == v {
+					sum += res.Score
+					n++
+				}
+			}
+			if n > 0 {
+				s.Means[i] = sum / float64(n)
+			}
+		}
+		lo, hi := s.Means[0], s.Means[0]
+		s.Best = values[0]
+		for i, mean := range s.Means {
+			lo = min(lo, mean)
+			if mean > hi {
+				hi, s.Best = mean, values[i]
+			}
+		}
+		s.Spread = hi - lo
+		r.Sensitivities = append(r.Sensitivities, s)
+	}
+	sort.SliceStable(r.Sensitivities, func(a, b int) bool { return r.Sensitivities[a].Spread > r.Sensitivities[b].Spread })
+	return r
+}
+
+// 得分最高的结果
+func (r *Report) Best() Result {
+	return r.Results[0]
+}
+
+// 输出搜索报告
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Search: %s, %d candidates\n", r.Search, len(r.Results))
+
+	fmt.Fprintf(w, "\n%-6s %8s %8s %8s  %s\n", "rank", "score", "kept", "leaked", "params")
+	for i, res := range r.Results[:min(r.Top, len(r.Results))] {
+		fmt.Fprintf(w, "%-6d %8.3f %8.3f %8.3f  %s\n", i+1, res.Score, res.Kept, res.Leaked, res.Candidate.String())
+	}
+
+	fmt.Fprintf(w, "\n%-20s %8s %8s  %s\n", "param", "spread", "best", "mean score by value")
+	for _, s := range r.Sensitivities {
+		fmt.Fprintf(w, "%-20s %8.3f %8s ", s.Param, s.Spread, formatValue(s.Best))
+		for i, v := range s.Values {
+			fmt.Fprintf(w, " %s=%.3f", formatValue(v), s.Means[i])
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
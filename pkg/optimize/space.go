@@ -0,0 +1,211 @@
+package optimize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+)
+
+// 搜索方式
+const (
+	SearchGrid   = "grid"   // 所有取值的组合
+	SearchRandom = "random" // 随机抽取Samples个组合
+)
+
+/**
+ * 参数空间，从JSON文件加载
+ * @description
+ * - Params: 参数名为config.PruneParams的JSON字段名，值为候选取值；没有列出的参数使用基准值
+ * - Base: 基准值，为空的字段使用配置wrapper.prune.params，再使用默认值
+ * - Search: grid(默认)或random，random抽取Samples个不重复的组合，Seed相同时结果相同
+ * @example
+ * {
+ *   "search": "grid",
+ *   "params": {
+ *     "ignoreOverlapLen": [4, 8, 12],
+ *     "repetitionRatio": [0.1, 0.15, 0.2]
+ *   }
+ * }
+ */
+type Space struct {
+	Search  string               `json:"search"`
+	Samples int                  `json:"samples"`
+	Seed    int64                `json:"seed"`
+	Base    config.PruneParams   `json:"base"`
+	Params  map[string][]float64 `json:"params"`
+}
+
+// 一个候选配置，Values为参数空间中各参数的取值
+type Candidate struct {
+	Values map[string]float64
+	Params config.PruneParams
+}
+
+/**
+ * 加载参数空间
+ * @param {string} path - 参数空间文件(JSON)的路径
+ * @returns {*Space} 返回校验过的参数空间
+ * @returns {error} 文件无法读取、格式错误、参数名未知、取值不能用于该参数或搜索方式无效时返回错误
+ */
+func LoadSpace(path string) (*Space, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Space
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid space '%s': %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid space '%s': %w", path, err)
+	}
+	return &s, nil
+}
+
+func (s *Space) validate() error {
+	switch s.Search {
+	case "":
+		s.Search = SearchGrid
+	case SearchGrid:
+	case SearchRandom:
+		if s.Samples <= 0 {
+			return fmt.Errorf("random search requires samples")
+		}
+	default:
+		return fmt.Errorf("unknown search '%s'", s.Search)
+	}
+	if len(s.Params) == 0 {
+		return fmt.Errorf("no params")
+	}
+	for name, values := range s.Params {
+		if len(values) == 0 {
+			return fmt.Errorf("param '%s' has no values", name)
+		}
+		for _, v := range values {
+			if _, err := s.apply(config.PruneParams{}, map[string]float64{name: v}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 参数名按字母顺序排列，使候选配置的顺序确定
+func (s *Space) names() []string {
+	names := make([]string, 0, len(s.Params))
+	for name := range s.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/**
+ * 将参数的取值写入阈值
+ * @param {config.PruneParams} base - 基准阈值
+ * @param {map[string]float64} values - 参数名(JSON字段名)到取值
+ * @returns {config.PruneParams} 返回写入后的阈值
+ * @returns {error} 参数名未知或取值不能用于该参数(如整数参数取小数)时返回错误
+ */
+func (s *Space) apply(base config.PruneParams, values map[string]float64) (config.PruneParams, error) {
+	fields := map[string]json.RawMessage{}
+	data, _ := json.Marshal(base)
+	json.Unmarshal(data, &fields)
+	for name, v := range values {
+		if _, ok := fields[name]; !ok {
+			return base, fmt.Errorf("unknown param '%s'", name)
+		}
+		if v <= 0 {
+			return base, fmt.Errorf("param '%s' requires positive values, got %v", name, v)
+		}
+		fields[name] = json.RawMessage(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	data, _ = json.Marshal(fields)
+	var p config.PruneParams
+	if err := json.Unmarshal(data, &p); err != nil {
+		return base, fmt.Errorf("invalid values %v: %w", values, err)
+	}
+	return p, nil
+}
+
+// 用src中不为0的字段覆盖dst
+func overlay(dst, src config.PruneParams) config.PruneParams {
+	fields := map[string]json.RawMessage{}
+	data, _ := json.Marshal(dst)
+	json.Unmarshal(data, &fields)
+	overrides := map[string]json.RawMessage{}
+	data, _ = json.Marshal(src)
+	json.Unmarshal(data, &overrides)
+	for name, v := range overrides {
+		if string(v) != "0" {
+			fields[name] = v
+		}
+	}
+	data, _ = json.Marshal(fields)
+	var p config.PruneParams
+	json.Unmarshal(data, &p)
+	return p
+}
+
+/**
+ * 生成候选配置
+ * @param {config.PruneParams} configured - 配置的阈值，基准值中为0的字段使用它，再使用默认值
+ * @returns {[]Candidate} 返回候选配置
+ * @description
+ * - grid按参数名的字母顺序生成所有组合；random抽取不重复的组合，组合总数不超过Samples时等同grid
+ */
+func (s *Space) Candidates(configured config.PruneParams) []Candidate {
+	base := overlay(configured, s.Base)
+	names := s.names()
+	total := 1
+	for _, name := range names {
+		total *= len(s.Params[name])
+	}
+	indexes := make([]int, total)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	if s.Search == SearchRandom && s.Samples < total {
+		r := rand.New(rand.NewSource(s.Seed))
+		r.Shuffle(total, func(i, j int) { indexes[i], indexes[j] = indexes[j], indexes[i] })
+		indexes = indexes[:s.Samples]
+		sort.Ints(indexes)
+	}
+
+	candidates := make([]Candidate, 0, len(indexes))
+	for _, index := range indexes {
+		values := make(map[string]float64, len(names))
+		for i := len(names) - 1; i >= 0; i-- {
+			choices := s.Params[names[i]]
+			values[names[i]] = choices[index%len(choices)]
+			index /= len(choices)
+		}
+		params, _ := s.apply(base, values)
+		candidates = append(candidates, Candidate{Values: values, Params: completions.ResolvePruneParams(params)})
+	}
+	return candidates
+}
+
+// 候选配置的简短描述，如ignoreOverlapLen=8 repetitionRatio=0.15
+func (c *Candidate) String() string {
+	names := make([]string, 0, len(c.Values))
+	for name := range c.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + formatValue(c.Values[name])
+	}
+	return strings.Join(parts, " ")
+}
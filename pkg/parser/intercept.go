@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 裁剪语法错误时默认最多分析的次数
+const DefaultParseBudget = 64
+
+/**
+ * 支持增量分析的分析器
+ * @description
+ * - 裁剪语法错误时前缀不变，只有补全内容的末尾被截短
+ * - 分析器可以缓存同一前缀的分析结果，只分析变化的部分(如tree-sitter通过edit复用上次的语法树)
+ */
+type IncrementalParser interface {
+	// 检查prefix+rest的语法，与上次检查的prefix相同时复用其分析结果
+	IsCodeSyntaxAfter(prefix, rest string) bool
+}
+
+// 是否为标识符的字符
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+/**
+ * 获取从后向前尝试的截断位置
+ * @param {string} code - 补全内容
+ * @param {int} maxCut - 最多截掉的字节数
+ * @returns {[]int} 返回截断后保留的字节数，从大到小
+ * @description
+ * - 只在字符边界截断，不会截断UTF-8多字节字符
+ * - 跳过标识符中间和连续空白中间的位置，只保留标识符结尾、分隔符前后等有意义的位置
+ * - 与逐字节截断相同，截掉的字节数小于maxCut
+ * @example
+ * cutPositions("foo(bar)", 8)
+ * // [8, 7, 4, 3]
+ */
+func cutPositions(code string, maxCut int) []int {
+	var positions []int
+	for pos := len(code); pos >= 0 && pos > len(code)-maxCut; pos-- {
+		if pos < len(code) && !utf8.RuneStart(code[pos]) {
+			continue
+		}
+		if pos == len(code) || pos == 0 {
+			positions = append(positions, pos)
+			continue
+		}
+		before, _ := utf8.DecodeLastRuneInString(code[:pos])
+		after, _ := utf8.DecodeRuneInString(code[pos:])
+		if isIdentRune(before) && isIdentRune(after) {
+			continue
+		}
+		if unicode.IsSpace(before) && unicode.IsSpace(after) {
+			continue
+		}
+		positions = append(positions, pos)
+	}
+	return positions
+}
+
+/**
+ * 从后向前截断补全内容，直到与前后缀一起语法正确
+ * @param {context.Context} ctx - 请求上下文，取消后不再分析
+ * @param {Parser} p - 分析器，实现IncrementalParser时只分析变化的部分
+ * @param {string} choicesText - 补全内容
+ * @param {string} prefix - 代码前缀
+ * @param {string} suffix - 代码后缀
+ * @param {int} budget - 最多分析的次数，不大于0时使用DefaultParseBudget
+ * @returns {string} 返回截断后的补全内容；找不到、超出分析次数或请求取消时返回原补全内容
+ * @description
+ * - 最多截掉最后一个非空行的长度
+ * - 截断位置见cutPositions
+ */
+func interceptSyntaxError(ctx context.Context, p Parser, choicesText, prefix, suffix string, budget int) string {
+	if choicesText == "" {
+		return choicesText
+	}
+	if budget <= 0 {
+		budget = DefaultParseBudget
+	}
+	check := syntaxCheck(p, prefix, suffix)
+
+	maxCut := lastKLineStrLen(choicesText, 1)
+	parses := 0
+	for _, pos := range cutPositions(choicesText, maxCut) {
+		cutCode := choicesText[:pos]
+		if strings.TrimSpace(cutCode) == "" {
+			break
+		}
+		if parses >= budget || ctx.Err() != nil {
+			return choicesText
+		}
+		parses++
+		if check(cutCode) {
+			return strings.TrimRight(cutCode, "\n\r\t ")
+		}
+	}
+	return choicesText
+}
+
+// 检查前后缀之间的一段代码的语法，分析器支持时增量分析
+func syntaxCheck(p Parser, prefix, suffix string) func(code string) bool {
+	if inc, ok := p.(IncrementalParser); ok {
+		return func(code string) bool {
+			return inc.IsCodeSyntaxAfter(prefix, code+suffix)
+		}
+	}
+	return func(code string) bool {
+		return p.IsCodeSyntax(prefix + code + suffix)
+	}
+}
+
+/**
+ * 按行二分查找补全开头最长的语法正确的区域
+ * @param {context.Context} ctx - 请求上下文，取消后不再分析
+ * @param {Parser} p - 分析器，实现IncrementalParser时只分析变化的部分
+ * @param {string} choicesText - 补全内容，调用方已确认整体有语法错误
+ * @param {string} prefix - 代码前缀
+ * @param {string} suffix - 代码后缀
+ * @param {int} budget - 最多分析的次数，不大于0时使用DefaultParseBudget
+ * @returns {int} 返回区域的行数，没有语法正确的区域时返回0
+ * @description
+ * - 假设开头的区域出现语法错误后，更长的区域也有错误(如中间某行有错误时恢复停在该行之前)
+ * - 只包含空白的区域不分析，视为正确；调用方应丢弃只包含空白的区域
+ * - 超出分析次数或请求取消时，返回已确认语法正确的区域
+ * @example
+ * n := LeadingValidLines(ctx, p, "a();\nb();\nc(", "", "", 0)
+ * // n = 2
+ */
+func LeadingValidLines(ctx context.Context, p Parser, choicesText, prefix, suffix string, budget int) int {
+	if budget <= 0 {
+		budget = DefaultParseBudget
+	}
+	check := syntaxCheck(p, prefix, suffix)
+	lines := strings.Split(choicesText, "\n")
+	// lines[:lo]语法正确，lines[:hi]有错误
+	lo, hi := 0, len(lines)
+	for parses := 0; hi-lo > 1; {
+		mid := (lo + hi) / 2
+		region := strings.Join(lines[:mid], "\n")
+		if strings.TrimSpace(region) == "" {
+			lo = mid
+			continue
+		}
+		if parses >= budget || ctx.Err() != nil {
+			break
+		}
+		parses++
+		if check(region) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
@@ -0,0 +1,162 @@
+package parser
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// countingParser counts the parses and the scanned bytes
+type countingParser struct {
+	*SimpleParser
+	parses  int
+	scanned int
+}
+
+func (p *countingParser) IsCodeSyntax(code string) bool {
+	p.parses++
+	p.scanned += len(code)
+	return p.SimpleParser.IsCodeSyntax(code)
+}
+
+func (p *countingParser) IsCodeSyntaxAfter(prefix, rest string) bool {
+	p.parses++
+	p.scanned += len(rest)
+	if !p.cached || prefix != p.prefix {
+		p.scanned += len(prefix)
+	}
+	return p.SimpleParser.IsCodeSyntaxAfter(prefix, rest)
+}
+
+// interceptBytewise is the former trimming loop: one byte at a time, the whole code parsed every time
+func interceptBytewise(p Parser, choicesText, prefix, suffix string) string {
+	cutCode := choicesText
+	maxCutCount := lastKLineStrLen(cutCode, 1)
+	for i := 0; i < maxCutCount; i++ {
+		if p.IsCodeSyntax(prefix+cutCode+suffix) && strings.TrimSpace(cutCode) != "" {
+			return strings.TrimRight(cutCode, "\n\r\t ")
+		}
+		if len(cutCode) == 0 {
+			break
+		}
+		cutCode = cutCode[:len(cutCode)-1]
+	}
+	return choicesText
+}
+
+// a completion whose 120 character last line has a stray parenthesis near its start
+const (
+	longLinePrefix = "function orderTotal(items, destination) {\n  const subtotal = 0;\n  return "
+	longLineValid  = "items.reduce((sum, item) => sum + item.price * item.quantity, 0)"
+	longLineText   = longLineValid + ") + shippingCostFor(destination) + handlingFees + taxes;"
+	longLineSuffix = "\n}\n"
+)
+
+// to test that the trimmed code passes the syntax check
+// go test ./pkg/parser/ -v -run Test_InterceptSyntaxErrorCode
+func Test_InterceptSyntaxErrorCode(t *testing.T) {
+	if n := len(longLineText); n != 120 {
+		t.Fatalf("expected a 120 character line, got %d", n)
+	}
+	cases := []struct {
+		language string
+		text     string
+		prefix   string
+		suffix   string
+		expected string
+	}{
+		{"javascript", longLineText, longLinePrefix, longLineSuffix, longLineValid},
+		{"go", "fmt.Println(\"价格合计：\", total))", "func main() {\n\t", "\n}", "fmt.Println(\"价格合计：\", total)"},
+		{"typescript", "const names = users.map((u) => u.name)];", "", "", "const names = users.map((u) => u.name)"},
+		{"go", "return nil", "func f() error {\n\t", "\n}", "return nil"},
+	}
+	for _, c := range cases {
+		p := NewSimpleParser(c.language)
+		got := p.InterceptSyntaxErrorCode(context.Background(), c.text, c.prefix, c.suffix, 0)
+		if got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.language, c.expected, got)
+		}
+		if !utf8.ValidString(got) || !p.IsCodeSyntax(c.prefix+got+c.suffix) {
+			t.Errorf("%s: trimmed code %q is not valid", c.language, got)
+		}
+	}
+
+	p := NewSimpleParser("javascript")
+	if got := p.InterceptSyntaxErrorCode(context.Background(), longLineText, longLinePrefix, longLineSuffix, 3); got != longLineText {
+		t.Errorf("expected the untrimmed text when the parse budget is exceeded, got %q", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := p.InterceptSyntaxErrorCode(ctx, longLineText, longLinePrefix, longLineSuffix, 0); got != longLineText {
+		t.Errorf("expected the untrimmed text when canceled, got %q", got)
+	}
+}
+
+// to test that cut positions are rune boundaries outside identifiers
+// go test ./pkg/parser/ -v -run Test_CutPositions
+func Test_CutPositions(t *testing.T) {
+	got := cutPositions("foo(bar)", 8)
+	expected := []int{8, 7, 4, 3}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+
+	code := "x := \"金额：\" + 价格   )"
+	for _, pos := range cutPositions(code, len(code)) {
+		if !utf8.ValidString(code[:pos]) {
+			t.Errorf("position %d splits a rune", pos)
+		}
+	}
+}
+
+// to test that the incremental check agrees with the full check
+// go test ./pkg/parser/ -v -run Test_IsCodeSyntaxAfter
+func Test_IsCodeSyntaxAfter(t *testing.T) {
+	p := NewSimpleParser("go").(*SimpleParser)
+	cases := [][2]string{
+		{"func f() {", "}"},
+		{"func f() {", "}}"},
+		{"func f() {", "("},
+		{"}", "{"},
+		{"", ""},
+		{"func f() {", "return g(x)\n}"},
+	}
+	for _, c := range cases {
+		if got, expected := p.IsCodeSyntaxAfter(c[0], c[1]), p.IsCodeSyntax(c[0]+c[1]); got != expected {
+			t.Errorf("%q + %q: expected %v, got %v", c[0], c[1], expected, got)
+		}
+	}
+}
+
+func benchmarkIntercept(b *testing.B, intercept func(p Parser) string) {
+	var parses, scanned int
+	for i := 0; i < b.N; i++ {
+		p := &countingParser{SimpleParser: NewSimpleParser("javascript").(*SimpleParser)}
+		if got := intercept(p); got != longLineValid {
+			b.Fatalf("unexpected result %q", got)
+		}
+		parses += p.parses
+		scanned += p.scanned
+	}
+	b.ReportMetric(float64(parses)/float64(b.N), "parses/op")
+	b.ReportMetric(float64(scanned)/float64(b.N), "scanned-bytes/op")
+}
+
+// go test ./pkg/parser/ -bench Benchmark_Intercept -run ^$
+func Benchmark_InterceptBytewise(b *testing.B) {
+	benchmarkIntercept(b, func(p Parser) string {
+		return interceptBytewise(p, longLineText, longLinePrefix, longLineSuffix)
+	})
+}
+
+func Benchmark_InterceptTokenwise(b *testing.B) {
+	benchmarkIntercept(b, func(p Parser) string {
+		return interceptSyntaxError(context.Background(), p, longLineText, longLinePrefix, longLineSuffix, 0)
+	})
+}
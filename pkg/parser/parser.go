@@ -1,7 +1,9 @@
 package parser
 
+import "context"
+
 type Parser interface {
 	IsCodeSyntax(code string) bool
-	InterceptSyntaxErrorCode(choicesText, prefix, suffix string) string
+	InterceptSyntaxErrorCode(ctx context.Context, choicesText, prefix, suffix string, budget int) string
 	ExtractAccurateBlockPrefixSuffix(prefix, suffix string) (string, string)
 }
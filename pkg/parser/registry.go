@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//
+//	分析器注册表: 按语言缓存已初始化的分析器，供后置处理器在请求之间复用
+//
+
+// 各语言分析器的构造函数，没有注册的语言使用SimpleParser(没有语法文件时的简化实现)
+var (
+	factories     = map[string]func() Parser{}
+	factoriesLock sync.RWMutex
+)
+
+// 缓存分析器池的语言数上限，语言标识来自请求，超出后新的语言不再缓存
+const maxPools = 256
+
+// 各语言的分析器池，language -> *sync.Pool，poolCount为缓存的池数
+var (
+	pools     sync.Map
+	poolCount atomic.Int32
+)
+
+/**
+ * 注册语言的分析器构造函数
+ * @param {string} language - 编程语言标识符，不区分大小写
+ * @param {func() Parser} factory - 分析器构造函数，如加载了语法文件的分析器
+ * @description
+ * - 注册后丢弃该语言已缓存的分析器，之后取出的都是新构造函数创建的分析器
+ * - 一般在初始化时调用
+ */
+func Register(language string, factory func() Parser) {
+	lang := strings.ToLower(language)
+	factoriesLock.Lock()
+	factories[lang] = factory
+	factoriesLock.Unlock()
+	if _, ok := pools.LoadAndDelete(lang); ok {
+		poolCount.Add(-1)
+	}
+}
+
+// 创建语言的分析器，没有注册构造函数时使用SimpleParser
+func newParser(lang string) Parser {
+	factoriesLock.RLock()
+	factory, ok := factories[lang]
+	factoriesLock.RUnlock()
+	if ok {
+		return factory()
+	}
+	return NewSimpleParser(lang)
+}
+
+/**
+ * 取出语言的分析器
+ * @param {string} language - 编程语言标识符，不区分大小写
+ * @returns {Parser} 返回分析器，用完后通过Release放回
+ * @description
+ * - 分析器不能被多个协程同时使用(如tree-sitter的parser)，每个语言一个池，取出期间由调用者独占
+ * - 池中没有空闲的分析器时创建新的
+ * - 缓存的语言数有上限(maxPools)，超出后新语言的分析器不复用，放回后丢弃
+ * @example
+ * p := parser.Acquire("python")
+ * defer parser.Release("python", p)
+ * valid := p.IsCodeSyntax("print('Hello World')")
+ */
+func Acquire(language string) Parser {
+	return languagePool(strings.ToLower(language)).Get().(Parser)
+}
+
+/**
+ * 放回取出的分析器
+ * @param {string} language - 取出时使用的编程语言标识符
+ * @param {Parser} p - 取出的分析器，放回后调用者不能再使用
+ */
+func Release(language string, p Parser) {
+	if p == nil {
+		return
+	}
+	languagePool(strings.ToLower(language)).Put(p)
+}
+
+// 获取语言的分析器池，不存在时创建；缓存的语言数达到上限时返回不缓存的池，每次取出都创建新的分析器
+func languagePool(lang string) *sync.Pool {
+	if pool, ok := pools.Load(lang); ok {
+		return pool.(*sync.Pool)
+	}
+	pool := &sync.Pool{
+		New: func() any { return newParser(lang) },
+	}
+	if poolCount.Load() >= maxPools {
+		return pool
+	}
+	actual, loaded := pools.LoadOrStore(lang, pool)
+	if !loaded {
+		poolCount.Add(1)
+	}
+	return actual.(*sync.Pool)
+}
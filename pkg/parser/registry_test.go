@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// exclusiveParser fails the test when two goroutines use it at the same time
+type exclusiveParser struct {
+	Parser
+	inUse int32
+	t     *testing.T
+}
+
+func (p *exclusiveParser) IsCodeSyntax(code string) bool {
+	if !atomic.CompareAndSwapInt32(&p.inUse, 0, 1) {
+		p.t.Error("parser used by two goroutines at the same time")
+	}
+	defer atomic.StoreInt32(&p.inUse, 0)
+	return p.Parser.IsCodeSyntax(code)
+}
+
+// to test that checked out parsers are never shared, run with -race
+// go test ./pkg/parser/ -race -v -run Test_RegistryConcurrent
+func Test_RegistryConcurrent(t *testing.T) {
+	var created int32
+	Register("exclusive", func() Parser {
+		atomic.AddInt32(&created, 1)
+		return &exclusiveParser{Parser: NewSimpleParser("go"), t: t}
+	})
+	defer Register("exclusive", func() Parser { return NewSimpleParser("exclusive") })
+
+	languages := []string{"exclusive", "Python", "go", "javascript", "rust"}
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				lang := languages[(g+i)%len(languages)]
+				p := Acquire(lang)
+				if lang == "exclusive" {
+					if _, ok := p.(*exclusiveParser); !ok {
+						t.Errorf("expected the registered parser, got %T", p)
+					}
+				} else if sp := p.(*SimpleParser); sp.language != "python" && sp.language != lang {
+					t.Errorf("expected a %s parser, got %s", lang, sp.language)
+				}
+				p.IsCodeSyntax(fmt.Sprintf("func f%d() {}\n", i))
+				Release(lang, p)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&created); n == 0 || n > 32*200 {
+		t.Errorf("unexpected number of created parsers %d", n)
+	}
+}
+
+// to test that an unregistered language gets the simple parser
+// go test ./pkg/parser/ -v -run Test_RegistryFallback
+func Test_RegistryFallback(t *testing.T) {
+	p := Acquire("Go")
+	defer Release("Go", p)
+	if _, ok := p.(*SimpleParser); !ok {
+		t.Fatalf("expected SimpleParser, got %T", p)
+	}
+	if p.IsCodeSyntax("func main() {") {
+		t.Error("expected unbalanced braces to be a syntax error")
+	}
+}
+
+// go test ./pkg/parser/ -bench Benchmark_ -benchmem -run ^$
+func Benchmark_NewParserPerRequest(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := NewSimpleParser("go")
+		p.IsCodeSyntax("func main() {}")
+	}
+}
+
+func Benchmark_AcquireParser(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := Acquire("go")
+		p.IsCodeSyntax("func main() {}")
+		Release("go", p)
+	}
+}
+
+// to test that languages taken from requests cannot grow the pool cache without bound
+// go test ./pkg/parser/ -v -run Test_RegistryBounded
+func Test_RegistryBounded(t *testing.T) {
+	for i := 0; i < maxPools*2; i++ {
+		lang := fmt.Sprintf("bounded-%d", i)
+		p := Acquire(lang)
+		if p == nil {
+			t.Fatalf("expected a parser for %s", lang)
+		}
+		Release(lang, p)
+	}
+	cached := 0
+	pools.Range(func(_, _ any) bool {
+		cached++
+		return true
+	})
+	if cached > maxPools || int(poolCount.Load()) != cached {
+		t.Errorf("expected at most %d cached pools, got %d (counted %d)", maxPools, cached, poolCount.Load())
+	}
+}
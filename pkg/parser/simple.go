@@ -1,11 +1,18 @@
 package parser
 
 import (
+	"context"
 	"strings"
 )
 
 type SimpleParser struct {
 	language string
+
+	// 上次增量分析的前缀及其括号计数，见IsCodeSyntaxAfter
+	cached      bool
+	prefix      string
+	prefixState bracketState
+	prefixOK    bool
 }
 
 /**
@@ -32,7 +39,7 @@ func NewSimpleParser(language string) Parser {
  * @returns {boolean} 返回代码语法是否正确，true表示语法正确，false表示语法错误
  * @description
  * - 基于编程语言类型执行基本的语法检查
- * - 支持Python、JavaScript/TypeScript、Go语言的语法验证
+ * - 支持Python、JavaScript/TypeScript、Go语言，以及回退到c-like语言族的语言的语法验证
  * - 对于不支持的语言默认返回true
  * - 实现Parser接口的IsCodeSyntax方法
  * @example
@@ -46,50 +53,60 @@ func (t *SimpleParser) IsCodeSyntax(code string) bool {
 		return t.checkPythonSyntax(code)
 	case "javascript", "typescript":
 		return t.checkJavaScriptSyntax(code)
-	case "go":
+	case "go", "c-like":
 		return t.checkGoSyntax(code)
 	default:
 		return true // 对于不支持的语言，默认返回true
 	}
 }
 
+/**
+ * 增量检查代码语法（简化实现）
+ * @param {string} prefix - 代码前缀，与上次检查的前缀相同时复用其括号计数
+ * @param {string} rest - 前缀之后的代码
+ * @returns {boolean} 返回prefix+rest的语法是否正确，与IsCodeSyntax(prefix+rest)的结果相同
+ * @description
+ * - JavaScript/TypeScript、Go、c-like语言族只检查括号匹配，前缀的括号计数缓存后只扫描rest
+ * - 其他语言分析完整的代码
+ * - 实现IncrementalParser接口
+ */
+func (t *SimpleParser) IsCodeSyntaxAfter(prefix, rest string) bool {
+	switch strings.ToLower(t.language) {
+	case "javascript", "typescript", "go", "c-like":
+		if !t.cached || prefix != t.prefix {
+			t.cached, t.prefix = true, prefix
+			t.prefixState, t.prefixOK = scanBrackets(bracketState{}, prefix)
+		}
+		if !t.prefixOK {
+			return false
+		}
+		state, ok := scanBrackets(t.prefixState, rest)
+		return ok && state.balanced()
+	default:
+		return t.IsCodeSyntax(prefix + rest)
+	}
+}
+
 /**
  * 拦截语法错误代码（简化实现）
+ * @param {context.Context} ctx - 请求上下文，取消后停止裁剪
  * @param {string} choicesText - 候选文本内容，需要从中提取有效代码
  * @param {string} prefix - 代码前缀，用于语法检查的上下文
  * @param {string} suffix - 代码后缀，用于语法检查的上下文
+ * @param {int} budget - 最多分析的次数，不大于0时使用DefaultParseBudget
  * @returns {string} 返回经过语法检查和修正的代码片段
  * @description
  * - 通过逐步截断候选文本来找到语法正确的代码片段
- * - 从后向前逐个字符删除，直到找到语法正确的代码
- * - 使用前缀和后缀进行完整的语法检查
- * - 如果无法找到有效代码，返回原始候选文本
+ * - 从后向前在标识符结尾、分隔符前后等位置截断，不会截断UTF-8多字节字符
+ * - 前缀的分析结果在多次检查间复用，只分析补全内容和后缀
+ * - 如果无法找到有效代码、超出分析次数或请求取消，返回原始候选文本
  * @example
  * parser := NewSimpleParser("python")
- * result := parser.InterceptSyntaxErrorCode("print('Hello')", "def main():\n", "\nmain()")
+ * result := parser.InterceptSyntaxErrorCode(ctx, "print('Hello')", "def main():\n", "\nmain()", 0)
  * // result = "print('Hello')"
  */
-func (t *SimpleParser) InterceptSyntaxErrorCode(choicesText, prefix, suffix string) string {
-	if choicesText == "" {
-		return choicesText
-	}
-
-	cutCode := choicesText
-	maxCutCount := t.GetLastKLineStrLen(cutCode, 1)
-
-	for i := 0; i < maxCutCount; i++ {
-		if t.IsCodeSyntax(prefix+cutCode+suffix) && strings.TrimSpace(cutCode) != "" {
-			return strings.TrimRight(cutCode, "\n\r\t ")
-		}
-
-		if len(cutCode) > 0 {
-			cutCode = cutCode[:len(cutCode)-1]
-		} else {
-			break
-		}
-	}
-
-	return choicesText
+func (t *SimpleParser) InterceptSyntaxErrorCode(ctx context.Context, choicesText, prefix, suffix string, budget int) string {
+	return interceptSyntaxError(ctx, t, choicesText, prefix, suffix, budget)
 }
 
 /**
@@ -249,6 +266,11 @@ func (t *SimpleParser) FindSecondLevelNearestNodeByLineNum(code string, lineNum
  * // length = 10 (line2 + line3 + 换行符)
  */
 func (t *SimpleParser) GetLastKLineStrLen(code string, k int) int {
+	return lastKLineStrLen(code, k)
+}
+
+// 代码最后k个非空行的长度，见GetLastKLineStrLen
+func lastKLineStrLen(code string, k int) int {
 	lines := strings.Split(code, "\n")
 	var lastKLines []string
 
@@ -339,37 +361,8 @@ func (t *SimpleParser) checkPythonSyntax(code string) bool {
  * // isValid = true
  */
 func (t *SimpleParser) checkJavaScriptSyntax(code string) bool {
-	bracketCount := 0
-	parenCount := 0
-	bracketSquareCount := 0
-
-	for _, char := range code {
-		switch char {
-		case '{':
-			bracketCount++
-		case '}':
-			bracketCount--
-			if bracketCount < 0 {
-				return false
-			}
-		case '(':
-			parenCount++
-		case ')':
-			parenCount--
-			if parenCount < 0 {
-				return false
-			}
-		case '[':
-			bracketSquareCount++
-		case ']':
-			bracketSquareCount--
-			if bracketSquareCount < 0 {
-				return false
-			}
-		}
-	}
-
-	return bracketCount == 0 && parenCount == 0 && bracketSquareCount == 0
+	state, ok := scanBrackets(bracketState{}, code)
+	return ok && state.balanced()
 }
 
 /**
@@ -387,37 +380,54 @@ func (t *SimpleParser) checkJavaScriptSyntax(code string) bool {
  * // isValid = true
  */
 func (t *SimpleParser) checkGoSyntax(code string) bool {
-	bracketCount := 0
-	parenCount := 0
-	bracketSquareCount := 0
+	state, ok := scanBrackets(bracketState{}, code)
+	return ok && state.balanced()
+}
+
+// 大括号、圆括号、方括号的未闭合数
+type bracketState struct {
+	bracket       int
+	paren         int
+	bracketSquare int
+}
 
+func (s bracketState) balanced() bool {
+	return s.bracket == 0 && s.paren == 0 && s.bracketSquare == 0
+}
+
+/**
+ * 从给定的括号计数开始扫描代码
+ * @param {bracketState} s - 扫描前的括号计数
+ * @param {string} code - 需要扫描的代码
+ * @returns {bracketState, bool} 返回扫描后的括号计数；出现多余的右括号时返回false
+ */
+func scanBrackets(s bracketState, code string) (bracketState, bool) {
 	for _, char := range code {
 		switch char {
 		case '{':
-			bracketCount++
+			s.bracket++
 		case '}':
-			bracketCount--
-			if bracketCount < 0 {
-				return false
+			s.bracket--
+			if s.bracket < 0 {
+				return s, false
 			}
 		case '(':
-			parenCount++
+			s.paren++
 		case ')':
-			parenCount--
-			if parenCount < 0 {
-				return false
+			s.paren--
+			if s.paren < 0 {
+				return s, false
 			}
 		case '[':
-			bracketSquareCount++
+			s.bracketSquare++
 		case ']':
-			bracketSquareCount--
-			if bracketSquareCount < 0 {
-				return false
+			s.bracketSquare--
+			if s.bracketSquare < 0 {
+				return s, false
 			}
 		}
 	}
-
-	return bracketCount == 0 && parenCount == 0 && bracketSquareCount == 0
+	return s, true
 }
 
 /**
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"bytes"
+	"code-completion/pkg/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+//	隐私模式: 开启privacy.enabled后，长期存在的存储只保存哈希、长度和结构化的元数据，
+//	源码及由源码得到的文本(提示词、补全内容、代码上下文)不会在请求结束后留在内存中
+//
+
+/**
+ * 可以去除源码文本的存储条目
+ * @description
+ * - Strip返回只保留哈希、长度和元数据的副本，不能修改原值(原值可能还在请求中使用)
+ * - 隐私模式下Put写入的是Strip的结果，读取方需要识别去除了文本的条目(如作为缓存未命中)
+ * - 条目的值类型必须实现该接口，或在Options.Metadata中声明不含源码文本，否则New时panic
+ */
+type RedactableRecord[V any] interface {
+	Strip() V
+}
+
+// 注册到隐私检查的存储
+type registrant interface {
+	// 遍历存储的所有键和值
+	each(fn func(key, value any) bool)
+}
+
+// 登记的存储及其名称
+type registration struct {
+	name  string
+	store registrant
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      []registration
+)
+
+/**
+ * 登记存储
+ * @description
+ * - 同名的存储(如每个上下文客户端各有一个context_health)都登记，都参与检查
+ * - 登记后存储在进程内一直可以被检查，存储通常随进程创建一次，不会累积
+ */
+func register(name string, r registrant) {
+	registryMutex.Lock()
+	registry = append(registry, registration{name: name, store: r})
+	registryMutex.Unlock()
+}
+
+// 是否开启隐私模式，启动时由配置privacy.enabled确定
+func Private() bool {
+	return config.Config.Privacy.Enabled
+}
+
+/**
+ * 文本的摘要，隐私模式下代替源码文本保存
+ * @param {string} s - 源码文本
+ * @returns {string} 返回sha256的前8字节和字节数，空字符串返回空
+ * @example
+ * store.Digest("x := 1")
+ * // "sha256:27bdb0baa67b88ff/6"
+ */
+func Digest(s string) string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:8]) + "/" + strconv.Itoa(len(s))
+}
+
+/**
+ * 在所有登记的存储中查找文本，用于验证隐私模式
+ * @param {string} text - 要查找的文本，如测试请求中的哨兵字符串
+ * @returns {[]string} 返回键或值中包含该文本的存储名称，按名称排序并去重，都不包含时返回nil
+ * @description
+ * - 用反射遍历键和值，包括指针、结构体的未导出字段、切片、数组、映射和接口，同一指针只遍历一次
+ * - 不遍历通道和函数；遍历的是存储的快照，不改变访问顺序
+ * @example
+ * if found := store.FindText(sentinel); len(found) > 0 {
+ *     t.Errorf("sentinel retained in %v", found)
+ * }
+ */
+func FindText(text string) []string {
+	registryMutex.Lock()
+	stores := slices.Clone(registry)
+	registryMutex.Unlock()
+
+	var found []string
+	for _, r := range stores {
+		if slices.Contains(found, r.name) {
+			continue
+		}
+		f := &textFinder{text: text, visited: make(map[visit]bool)}
+		r.store.each(func(key, value any) bool {
+			if f.find(reflect.ValueOf(key)) || f.find(reflect.ValueOf(value)) {
+				found = append(found, r.name)
+				return false
+			}
+			return true
+		})
+	}
+	sort.Strings(found)
+	return found
+}
+
+// 已遍历的指针，同一地址的不同类型(如结构体和它的第一个字段)分别遍历
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+type textFinder struct {
+	text    string
+	visited map[visit]bool
+}
+
+// 值中是否包含文本
+func (f *textFinder) find(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.Contains(v.String(), f.text)
+	case reflect.Pointer, reflect.Map:
+		if v.IsNil() {
+			return false
+		}
+		key := visit{v.Pointer(), v.Type()}
+		if f.visited[key] {
+			return false
+		}
+		f.visited[key] = true
+		if v.Kind() == reflect.Pointer {
+			return f.find(v.Elem())
+		}
+		for iter := v.MapRange(); iter.Next(); {
+			if f.find(iter.Key()) || f.find(iter.Value()) {
+				return true
+			}
+		}
+	case reflect.Interface:
+		return !v.IsNil() && f.find(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f.find(v.Field(i)) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return bytes.Contains(v.Bytes(), []byte(f.text))
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if f.find(v.Index(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
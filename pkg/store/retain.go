@@ -0,0 +1,49 @@
+package store
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+//
+//	长期保存的字符串: Go的子串与原字符串共用底层数组，从请求中截取的短字符串(如光标行)
+//	保存到长期存在的存储中时，会使整个请求的提示词(可达数MB)无法被回收
+//	由请求体解析得到的字段(如client_id)本身就是独立分配的字符串，不需要复制
+//
+
+/**
+ * 复制字符串用于长期保存，超过长度上限时截断
+ * @param {string} s - 从请求中得到的字符串，可能是更大字符串的子串
+ * @param {int} maxBytes - 保存的最大字节数，不大于0时不截断
+ * @returns {string} 返回不与s共用底层数组的副本，截断时保留开头，不截断多字节字符
+ * @example
+ * cursorLine := prefix[strings.LastIndex(prefix, "\n")+1:]
+ * entry.line = store.Retain(cursorLine, 200)
+ */
+func Retain(s string, maxBytes int) string {
+	if maxBytes > 0 && len(s) > maxBytes {
+		end := maxBytes
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		s = s[:end]
+	}
+	return strings.Clone(s)
+}
+
+/**
+ * 复制字符串用于长期保存，超过长度上限时保留末尾
+ * @param {string} s - 从请求中得到的字符串，如前缀
+ * @param {int} maxBytes - 保存的最大字节数，不大于0时不截断
+ * @returns {string} 返回不与s共用底层数组的副本，截断时保留末尾，不截断多字节字符
+ */
+func RetainTail(s string, maxBytes int) string {
+	if maxBytes > 0 && len(s) > maxBytes {
+		start := len(s) - maxBytes
+		for start < len(s) && !utf8.RuneStart(s[start]) {
+			start++
+		}
+		s = s[start:]
+	}
+	return strings.Clone(s)
+}
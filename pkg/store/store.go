@@ -0,0 +1,298 @@
+package store
+
+import (
+	"code-completion/pkg/metrics"
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//
+//	有界内存存储: 缓存、历史记录等内存数据共用的并发安全的有界映射
+//
+
+// 条目被淘汰的原因
+type EvictReason string
+
+const (
+	EvictCapacity EvictReason = "capacity" // 超过最大条目数或最大字节数，淘汰最久未访问的条目
+	EvictExpired  EvictReason = "expired"  // 超过存活时间
+)
+
+/**
+ * 有界内存存储的配置
+ * @description
+ * - Name: 存储名称，作为指标的store标签，为空时不上报指标
+ * - MaxEntries: 最大条目数，超过时按LRU淘汰，0表示不限制
+ * - MaxBytes: 估算的最大字节数，超过时按LRU淘汰，0表示不限制，需要设置Sizer
+ * - TTL: 条目自最后一次写入后的存活时间，0表示不过期；读取不刷新存活时间
+ * - Sizer: 估算条目占用的字节数，为nil时字节数按0计算
+ * - OnEvict: 条目被淘汰时的回调，在释放锁之后调用，可以在回调中访问存储
+ * - Clock: 时钟，为nil时使用time.Now，测试时注入以确定性地触发过期
+ * - Metadata: 声明条目只含哈希、长度、标识等元数据，不含源码文本；为false时值类型必须实现RedactableRecord
+ */
+type Options[K comparable, V any] struct {
+	Name       string
+	MaxEntries int
+	MaxBytes   int
+	TTL        time.Duration
+	Sizer      func(key K, value V) int
+	OnEvict    func(key K, value V, reason EvictReason)
+	Clock      func() time.Time
+	Metadata   bool
+}
+
+// 存储中的一个条目
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	size    int
+	written time.Time
+}
+
+// 被淘汰的条目，释放锁后再调用OnEvict
+type eviction[K comparable, V any] struct {
+	entry  *entry[K, V]
+	reason EvictReason
+}
+
+// 并发安全的有界映射，支持LRU和TTL淘汰
+type Store[K comparable, V any] struct {
+	opts  Options[K, V]
+	strip func(V) V // 隐私模式下写入前去除源码文本，Metadata的存储为nil
+	mutex sync.Mutex
+	items map[K]*list.Element
+	order *list.List // 按访问时间排序，队首为最近访问的条目
+	bytes int
+}
+
+/**
+ * 创建有界内存存储
+ * @param {Options[K, V]} opts - 存储配置
+ * @returns {*Store[K, V]} 返回空的存储
+ * @description
+ * - 存储按名称登记到隐私检查，见FindText
+ * - 值类型没有实现RedactableRecord，也没有声明Metadata时panic，保证隐私模式覆盖所有存储
+ * @example
+ * s := store.New(store.Options[string, *Client]{
+ *     Name: "clients",
+ *     TTL:  time.Hour,
+ * })
+ */
+func New[K comparable, V any](opts Options[K, V]) *Store[K, V] {
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	s := &Store[K, V]{
+		opts:  opts,
+		items: make(map[K]*list.Element),
+		order: list.New(),
+	}
+	var zero V
+	if _, ok := any(zero).(RedactableRecord[V]); ok {
+		s.strip = func(value V) V { return any(value).(RedactableRecord[V]).Strip() }
+	} else if !opts.Metadata {
+		panic(fmt.Sprintf("store %q: %T must implement store.RedactableRecord or set Options.Metadata", opts.Name, zero))
+	}
+	register(opts.Name, s)
+	s.report(nil)
+	return s
+}
+
+/**
+ * 读取条目
+ * @param {K} key - 条目的键
+ * @returns {V, bool} 返回条目的值和是否存在
+ * @description
+ * - 读取的条目成为最近访问的条目
+ * - 已过期的条目视为不存在，并被淘汰
+ */
+func (s *Store[K, V]) Get(key K) (V, bool) {
+	var zero V
+	s.mutex.Lock()
+	elem, ok := s.items[key]
+	if !ok {
+		s.mutex.Unlock()
+		return zero, false
+	}
+	e := elem.Value.(*entry[K, V])
+	if s.expired(e, s.opts.Clock()) {
+		s.remove(elem)
+		evicted := []eviction[K, V]{{entry: e, reason: EvictExpired}}
+		s.report(evicted)
+		s.mutex.Unlock()
+		s.notify(evicted)
+		return zero, false
+	}
+	s.order.MoveToFront(elem)
+	// Put会在锁内改写同一个条目，必须在解锁前取值
+	value := e.value
+	s.mutex.Unlock()
+	return value, true
+}
+
+/**
+ * 写入条目
+ * @param {K} key - 条目的键
+ * @param {V} value - 条目的值，替换已有的值
+ * @description
+ * - 写入的条目成为最近访问的条目，并重新开始计算存活时间
+ * - 超过最大条目数或最大字节数时，淘汰最久未访问的条目
+ * - 单个条目超过最大字节数时也保留，保证刚写入的条目可以读到
+ * - 隐私模式下写入value.Strip()的结果
+ */
+func (s *Store[K, V]) Put(key K, value V) {
+	if s.strip != nil && Private() {
+		value = s.strip(value)
+	}
+	size := 0
+	if s.opts.Sizer != nil {
+		size = s.opts.Sizer(key, value)
+	}
+	s.mutex.Lock()
+	now := s.opts.Clock()
+	if elem, ok := s.items[key]; ok {
+		e := elem.Value.(*entry[K, V])
+		s.bytes += size - e.size
+		e.value, e.size, e.written = value, size, now
+		s.order.MoveToFront(elem)
+	} else {
+		s.items[key] = s.order.PushFront(&entry[K, V]{key: key, value: value, size: size, written: now})
+		s.bytes += size
+	}
+	var evicted []eviction[K, V]
+	for s.order.Len() > 1 && s.overflow() {
+		back := s.order.Back()
+		s.remove(back)
+		evicted = append(evicted, eviction[K, V]{entry: back.Value.(*entry[K, V]), reason: EvictCapacity})
+	}
+	s.report(evicted)
+	s.mutex.Unlock()
+	s.notify(evicted)
+}
+
+/**
+ * 删除条目，不视为淘汰，不调用OnEvict
+ * @param {K} key - 条目的键
+ * @returns {bool} 返回条目是否存在
+ */
+func (s *Store[K, V]) Delete(key K) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return false
+	}
+	s.remove(elem)
+	s.report(nil)
+	return true
+}
+
+/**
+ * 淘汰所有已过期的条目，由定时维护任务调用
+ * @returns {int} 返回淘汰的条目数
+ */
+func (s *Store[K, V]) Expire() int {
+	if s.opts.TTL <= 0 {
+		return 0
+	}
+	s.mutex.Lock()
+	now := s.opts.Clock()
+	var evicted []eviction[K, V]
+	for elem := s.order.Front(); elem != nil; {
+		next := elem.Next()
+		if e := elem.Value.(*entry[K, V]); s.expired(e, now) {
+			s.remove(elem)
+			evicted = append(evicted, eviction[K, V]{entry: e, reason: EvictExpired})
+		}
+		elem = next
+	}
+	s.report(evicted)
+	s.mutex.Unlock()
+	s.notify(evicted)
+	return len(evicted)
+}
+
+/**
+ * 遍历存储的快照，用于管理接口展示
+ * @param {func(K, V) bool} fn - 对每个条目调用，返回false时停止遍历
+ * @description
+ * - 按最近访问到最久未访问的顺序遍历
+ * - 遍历的是调用时的快照，不持有锁，fn中可以访问存储
+ * - 遍历不改变访问顺序，也不淘汰过期条目
+ */
+func (s *Store[K, V]) Range(fn func(key K, value V) bool) {
+	s.mutex.Lock()
+	snapshot := make([]*entry[K, V], 0, s.order.Len())
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		e := *elem.Value.(*entry[K, V])
+		snapshot = append(snapshot, &e)
+	}
+	s.mutex.Unlock()
+	for _, e := range snapshot {
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// 遍历所有键和值，用于隐私检查
+func (s *Store[K, V]) each(fn func(key, value any) bool) {
+	s.Range(func(key K, value V) bool {
+		return fn(key, value)
+	})
+}
+
+// 条目数
+func (s *Store[K, V]) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.order.Len()
+}
+
+// 估算的字节数
+func (s *Store[K, V]) Bytes() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bytes
+}
+
+// 是否超过最大条目数或最大字节数，调用者需持有锁
+func (s *Store[K, V]) overflow() bool {
+	return (s.opts.MaxEntries > 0 && s.order.Len() > s.opts.MaxEntries) ||
+		(s.opts.MaxBytes > 0 && s.bytes > s.opts.MaxBytes)
+}
+
+// 条目是否已过期
+func (s *Store[K, V]) expired(e *entry[K, V], now time.Time) bool {
+	return s.opts.TTL > 0 && now.Sub(e.written) > s.opts.TTL
+}
+
+// 移除条目，调用者需持有锁
+func (s *Store[K, V]) remove(elem *list.Element) {
+	e := s.order.Remove(elem).(*entry[K, V])
+	delete(s.items, e.key)
+	s.bytes -= e.size
+}
+
+// 上报条目数、字节数和淘汰次数，调用者需持有锁
+func (s *Store[K, V]) report(evicted []eviction[K, V]) {
+	if s.opts.Name == "" {
+		return
+	}
+	metrics.UpdateStoreSize(s.opts.Name, s.order.Len(), s.bytes)
+	for _, ev := range evicted {
+		metrics.IncrementStoreEvictions(s.opts.Name, string(ev.reason))
+	}
+}
+
+// 调用淘汰回调，调用者不能持有锁
+func (s *Store[K, V]) notify(evicted []eviction[K, V]) {
+	if s.opts.OnEvict == nil {
+		return
+	}
+	for _, ev := range evicted {
+		s.opts.OnEvict(ev.entry.key, ev.entry.value, ev.reason)
+	}
+}
@@ -0,0 +1,354 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"code-completion/pkg/config"
+)
+
+// fakeClock is a clock advanced by tests
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type evicted struct {
+	key    string
+	reason EvictReason
+}
+
+func newTestStore(opts Options[string, int]) (*Store[string, int], *fakeClock, *[]evicted) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var evictions []evicted
+	opts.Clock = clock.Now
+	opts.Metadata = true
+	opts.OnEvict = func(key string, _ int, reason EvictReason) {
+		evictions = append(evictions, evicted{key, reason})
+	}
+	return New(opts), clock, &evictions
+}
+
+// to test LRU eviction by entry count and by bytes
+// go test ./pkg/store/ -v -run Test_StoreLRU
+func Test_StoreLRU(t *testing.T) {
+	s, _, evictions := newTestStore(Options[string, int]{MaxEntries: 2})
+	s.Put("a", 1)
+	s.Put("b", 2)
+	s.Get("a")
+	s.Put("c", 3)
+	if _, ok := s.Get("b"); ok {
+		t.Error("expected the least recently used entry evicted")
+	}
+	if v, ok := s.Get("a"); !ok || v != 1 {
+		t.Errorf("expected a kept, got %d %v", v, ok)
+	}
+	if len(*evictions) != 1 || (*evictions)[0] != (evicted{"b", EvictCapacity}) {
+		t.Errorf("unexpected evictions %v", *evictions)
+	}
+
+	s, _, _ = newTestStore(Options[string, int]{
+		MaxBytes: 10,
+		Sizer:    func(_ string, v int) int { return v },
+	})
+	s.Put("a", 4)
+	s.Put("b", 4)
+	s.Put("a", 7)
+	if s.Len() != 1 || s.Bytes() != 7 {
+		t.Errorf("expected b evicted after a grew, got %d entries %d bytes", s.Len(), s.Bytes())
+	}
+	// a single oversized entry is kept
+	s.Put("c", 20)
+	if v, ok := s.Get("c"); !ok || v != 20 || s.Len() != 1 {
+		t.Errorf("expected the oversized entry kept alone, got %d entries", s.Len())
+	}
+	if !s.Delete("c") || s.Delete("c") || s.Bytes() != 0 {
+		t.Errorf("unexpected delete result, %d bytes left", s.Bytes())
+	}
+}
+
+// to test TTL eviction driven by the injected clock
+// go test ./pkg/store/ -v -run Test_StoreTTL
+func Test_StoreTTL(t *testing.T) {
+	s, clock, evictions := newTestStore(Options[string, int]{TTL: time.Minute})
+	s.Put("a", 1)
+	s.Put("b", 2)
+	clock.Advance(40 * time.Second)
+	s.Put("b", 3)
+	s.Get("a") // reading does not extend the TTL
+	clock.Advance(30 * time.Second)
+
+	if n := s.Expire(); n != 1 {
+		t.Fatalf("expected 1 expired entry, got %d", n)
+	}
+	if len(*evictions) != 1 || (*evictions)[0] != (evicted{"a", EvictExpired}) {
+		t.Errorf("unexpected evictions %v", *evictions)
+	}
+	clock.Advance(time.Minute)
+	if _, ok := s.Get("b"); ok {
+		t.Error("expected an expired entry to be missed before cleanup")
+	}
+	if s.Len() != 0 || len(*evictions) != 2 {
+		t.Errorf("expected the expired entry evicted on read, got %d entries", s.Len())
+	}
+}
+
+// to test the snapshot taken by Range
+// go test ./pkg/store/ -v -run Test_StoreRange
+func Test_StoreRange(t *testing.T) {
+	s, _, _ := newTestStore(Options[string, int]{})
+	for i := 0; i < 3; i++ {
+		s.Put(fmt.Sprint(i), i)
+	}
+	var keys []string
+	s.Range(func(key string, _ int) bool {
+		keys = append(keys, key)
+		s.Delete(key) // the store can be modified while ranging
+		return true
+	})
+	if fmt.Sprint(keys) != "[2 1 0]" || s.Len() != 0 {
+		t.Errorf("expected most recent first, got %v with %d left", keys, s.Len())
+	}
+}
+
+// to test concurrent puts, gets and evictions, run with -race
+// go test ./pkg/store/ -race -v -run Test_StoreConcurrent
+func Test_StoreConcurrent(t *testing.T) {
+	var mutex sync.Mutex
+	evictions := 0
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s := New(Options[string, int]{
+		Name:       "test",
+		MaxEntries: 64,
+		MaxBytes:   512,
+		TTL:        time.Second,
+		Sizer:      func(key string, _ int) int { return len(key) },
+		Clock:      clock.Now,
+		Metadata:   true,
+		OnEvict: func(string, int, EvictReason) {
+			mutex.Lock()
+			evictions++
+			mutex.Unlock()
+		},
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprint(r.Intn(200))
+				switch r.Intn(10) {
+				case 0:
+					s.Delete(key)
+				case 1:
+					clock.Advance(10 * time.Millisecond)
+					s.Expire()
+				case 2:
+					s.Range(func(string, int) bool { return r.Intn(4) != 0 })
+				case 3, 4, 5:
+					s.Put(key, i)
+				default:
+					s.Get(key)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	if s.Len() > 64 || s.Bytes() > 512 {
+		t.Errorf("store exceeded its bounds: %d entries %d bytes", s.Len(), s.Bytes())
+	}
+	bytes := 0
+	s.Range(func(key string, _ int) bool {
+		bytes += len(key)
+		return true
+	})
+	if bytes != s.Bytes() {
+		t.Errorf("expected %d bytes accounted, got %d", bytes, s.Bytes())
+	}
+	if evictions == 0 {
+		t.Error("expected evictions")
+	}
+}
+
+// to test the store against a plain map model with random operations
+// go test ./pkg/store/ -fuzz Fuzz_Store
+func Fuzz_Store(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	f.Add([]byte{3, 3, 3, 130, 67, 200, 1, 1, 90})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		s, clock, _ := newTestStore(Options[string, int]{MaxEntries: 4, TTL: 3 * time.Second})
+		model := map[string]time.Time{}
+		var order []string // least recently used first
+		touch := func(key string) {
+			for i, k := range order {
+				if k == key {
+					order = append(order[:i], order[i+1:]...)
+					break
+				}
+			}
+			order = append(order, key)
+		}
+		for _, op := range ops {
+			key := fmt.Sprint(op % 8)
+			now := clock.Now()
+			switch op >> 6 {
+			case 0:
+				s.Put(key, int(op))
+				model[key] = now
+				touch(key)
+				if len(order) > 4 {
+					delete(model, order[0])
+					order = order[1:]
+				}
+			case 1:
+				_, ok := s.Get(key)
+				written, exists := model[key]
+				if exists && now.Sub(written) > 3*time.Second {
+					delete(model, key)
+					touch(key)
+					order = order[:len(order)-1]
+					exists = false
+				} else if exists {
+					touch(key)
+				}
+				if ok != exists {
+					t.Fatalf("get %s: expected %v, got %v", key, exists, ok)
+				}
+			case 2:
+				clock.Advance(time.Second)
+			default:
+				s.Delete(key)
+				if _, exists := model[key]; exists {
+					delete(model, key)
+					touch(key)
+					order = order[:len(order)-1]
+				}
+			}
+			if s.Len() != len(model) {
+				t.Fatalf("expected %d entries, got %d", len(model), s.Len())
+			}
+		}
+	})
+}
+
+// go test ./pkg/store/ -bench Benchmark_StoreGet -run ^$
+func Benchmark_StoreGet(b *testing.B) {
+	s := New(Options[string, int]{MaxEntries: 1024, TTL: time.Hour, Metadata: true})
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+		s.Put(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+// to test retained strings are copies truncated at rune boundaries
+// go test ./pkg/store/ -v -run Test_Retain
+func Test_Retain(t *testing.T) {
+	big := "前缀" + string(make([]byte, 1<<10)) + "结尾"
+	if got := Retain(big, 4); got != "前" {
+		t.Errorf("expected the head cut at a rune boundary, got %q", got)
+	}
+	if got := RetainTail(big, 4); got != "尾" {
+		t.Errorf("expected the tail cut at a rune boundary, got %q", got)
+	}
+	if got := Retain(big[:6], 0); got != "前缀" || unsafe.StringData(got) == unsafe.StringData(big) {
+		t.Errorf("expected an unshared copy, got %q", got)
+	}
+}
+
+// snippet is a record holding code text behind a pointer and an unexported field
+type snippet struct {
+	path string
+	code *string
+}
+
+func (s snippet) Strip() snippet {
+	return snippet{path: s.path}
+}
+
+// to test privacy mode stores stripped records, FindText walks unexported fields, and unclassified stores panic
+// go test ./pkg/store/ -v -run Test_StorePrivacy
+func Test_StorePrivacy(t *testing.T) {
+	saved := config.Config.Privacy
+	defer func() { config.Config.Privacy = saved }()
+	code := "secret := 42"
+
+	for _, private := range []bool{false, true} {
+		config.Config.Privacy.Enabled = private
+		s := New(Options[string, snippet]{Name: "test_snippets"})
+		s.Put("a", snippet{path: "main.go", code: &code})
+		if v, _ := s.Get("a"); v.path != "main.go" || (v.code == nil) != private {
+			t.Errorf("privacy %v: unexpected record %+v", private, v)
+		}
+		if found := FindText("secret"); private && len(found) > 0 || !private && !slices.Equal(found, []string{"test_snippets"}) {
+			t.Errorf("privacy %v: unexpected stores with the text %v", private, found)
+		}
+		// 登记的存储一直参与检查，清除后不影响之后的检查
+		s.Delete("a")
+	}
+
+	// 之后创建的同名存储不会替换之前的存储
+	config.Config.Privacy.Enabled = false
+	first := New(Options[string, snippet]{Name: "test_snippets"})
+	first.Put("a", snippet{path: "main.go", code: &code})
+	New(Options[string, snippet]{Name: "test_snippets"})
+	if found := FindText("secret"); !slices.Equal(found, []string{"test_snippets"}) {
+		t.Errorf("expected the earlier store of the same name checked, got %v", found)
+	}
+	first.Delete("a")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a store of plain strings without Metadata to panic")
+		}
+	}()
+	New(Options[string, string]{Name: "unclassified"})
+}
+
+// to benchmark Put with privacy mode off and on
+// go test ./pkg/store/ -bench Benchmark_StorePutPrivacy -run XXX
+func Benchmark_StorePutPrivacy(b *testing.B) {
+	saved := config.Config.Privacy
+	defer func() { config.Config.Privacy = saved }()
+	code := "secret := 42"
+	for _, private := range []bool{false, true} {
+		b.Run(fmt.Sprintf("privacy=%v", private), func(b *testing.B) {
+			config.Config.Privacy.Enabled = private
+			s := New(Options[string, snippet]{Name: "bench_snippets", MaxEntries: 1024})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.Put(strconv.Itoa(i&1023), snippet{path: "main.go", code: &code})
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 补全质量信号，见qualitySignalsOf
+const (
+	SignalEmpty         = "empty"          // 补全结果为空(含被后置处理丢弃)
+	SignalDiscard       = "discard"        // 模型给出了补全内容，但被后置处理整体丢弃
+	SignalSyntaxDiscard = "syntax_discard" // 命中语法错误丢弃
+	SignalModelError    = "model_error"    // 模型响应错误
+)
+
+var qualitySignals = []string{SignalEmpty, SignalDiscard, SignalSyntaxDiscard, SignalModelError}
+
+/**
+ * 模型池的安全模式状态，通过/api/stats和/healthz?deep=true查看
+ * @description
+ * - 按模型池统计，同一模型的多个副本各自进入和退出安全模式
+ * - Rates: 最近一个参与判断的窗口内各信号的比率
+ * - Baseline: 各信号的基线，还没有参与判断的窗口时为空
+ * - Trigger: 触发安全模式的信号
+ * - Recovered: 安全模式中连续恢复的窗口数
+ */
+type SafeModeState struct {
+	Model     string             `json:"model"`
+	Instance  string             `json:"instance"`
+	SafeMode  bool               `json:"safeMode"`
+	Action    string             `json:"action,omitempty"`
+	Trigger   string             `json:"trigger,omitempty"`
+	Since     *time.Time         `json:"since,omitempty"`
+	Recovered int                `json:"recovered"`
+	Rates     map[string]float64 `json:"rates"`
+	Baseline  map[string]float64 `json:"baseline"`
+}
+
+// 一个模型池的质量统计和安全模式状态
+type modelQuality struct {
+	model     string             // 模型名称，阈值提高和安全模式指标按模型生效
+	requests  int                // 当前窗口内的请求数
+	counts    map[string]int     // 当前窗口内各信号的次数
+	rates     map[string]float64 // 最近一个参与判断的窗口内各信号的比率
+	baseline  map[string]float64 // 各信号的基线，nil表示还没有参与判断的窗口
+	safe      bool
+	action    string // 进入安全模式时的动作，退出时据此撤销
+	trigger   string
+	since     time.Time
+	recovered int
+	probes    uint64 // 安全模式中收到的请求数，用于按比例放行探测请求
+}
+
+/**
+ * 补全质量异常检测
+ * @description
+ * - 按模型池(ModelPool.instance)记录已调度执行的补全的质量信号，排队前被拒绝的请求不计入
+ * - 在定时维护协程中每隔配置的窗口判断一次，见evaluate
+ * - 进入和退出安全模式时打印日志，更新completion_safe_mode和completion_anomalies_total指标
+ */
+type anomalyDetector struct {
+	mutex  sync.Mutex
+	cfg    *config.AnomalyConfig
+	models map[string]*modelQuality // 模型池标识 -> 质量统计
+}
+
+func newAnomalyDetector(cfg *config.AnomalyConfig) *anomalyDetector {
+	return &anomalyDetector{
+		cfg:    cfg,
+		models: make(map[string]*modelQuality),
+	}
+}
+
+func (d *anomalyDetector) enabled() bool {
+	return d != nil && d.cfg.Enabled
+}
+
+// 获取模型池的统计，不存在时创建
+func (d *anomalyDetector) quality(instance, modelName string) *modelQuality {
+	q, ok := d.models[instance]
+	if !ok {
+		q = &modelQuality{model: modelName, counts: make(map[string]int)}
+		d.models[instance] = q
+	}
+	return q
+}
+
+// 补全响应命中的质量信号
+func qualitySignalsOf(rsp *completions.CompletionResponse) []string {
+	var signals []string
+	if rsp.Status == model.StatusEmpty {
+		signals = append(signals, SignalEmpty)
+	}
+	if rsp.Discarded {
+		signals = append(signals, SignalDiscard)
+	}
+	for _, hit := range rsp.Hits {
+		if hit == completions.DiscardSyntaxError {
+			signals = append(signals, SignalSyntaxDiscard)
+			break
+		}
+	}
+	if rsp.Status == model.StatusModelError {
+		signals = append(signals, SignalModelError)
+	}
+	return signals
+}
+
+/**
+ * 记录一次已调度到模型池执行的补全
+ * @param {*ModelPool} pool - 执行请求的模型池
+ * @param {*completions.CompletionResponse} rsp - 补全响应
+ */
+func (d *anomalyDetector) record(pool *ModelPool, rsp *completions.CompletionResponse) {
+	if !d.enabled() || rsp == nil || pool == nil {
+		return
+	}
+	signals := qualitySignalsOf(rsp)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	q := d.quality(pool.instance, pool.cfg.ModelName)
+	q.requests++
+	for _, s := range signals {
+		q.counts[s]++
+	}
+}
+
+/**
+ * 结束当前窗口，判断各模型池是否进入或退出安全模式
+ * @param {time.Time} now - 判断的时间，记录为进入安全模式的时间
+ * @description
+ * - 请求数少于MinSamples的窗口丢弃，不影响基线和恢复计数
+ * - fallback和reject-auto的安全模式中只有部分请求发给该模型池，最少请求数按ProbeRatio等比降低，见minSamples
+ * - 第一个参与判断的窗口作为初始基线
+ * - 不在安全模式时，任一信号的比率不低于MinRate且超过基线的RateMultiple倍则进入安全模式，
+ *   否则以BaselineWeight的权重并入基线
+ * - 安全模式中，所有信号都低于MinRate或不超过基线的RecoverMultiple倍的窗口计为恢复，
+ *   其他窗口清零恢复计数；连续RecoverWindows个恢复的窗口后退出安全模式
+ */
+func (d *anomalyDetector) evaluate(now time.Time) {
+	if !d.enabled() {
+		return
+	}
+	cfg := d.cfg
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for instance, q := range d.models {
+		requests, counts := q.requests, q.counts
+		q.requests, q.counts = 0, make(map[string]int)
+		if requests == 0 || requests < d.minSamples(q) {
+			continue
+		}
+		rates := make(map[string]float64, len(qualitySignals))
+		for _, s := range qualitySignals {
+			rates[s] = float64(counts[s]) / float64(requests)
+		}
+		q.rates = rates
+		if q.baseline == nil {
+			q.baseline = rates
+			continue
+		}
+		if !q.safe {
+			if trigger := d.anomalousSignal(q); trigger != "" {
+				d.enter(instance, q, trigger, now)
+				continue
+			}
+			w := cfg.BaselineWeight
+			for _, s := range qualitySignals {
+				q.baseline[s] = q.baseline[s]*(1-w) + rates[s]*w
+			}
+			continue
+		}
+		if d.recoveredWindow(q) {
+			q.recovered++
+		} else {
+			q.recovered = 0
+		}
+		if q.recovered >= cfg.RecoverWindows {
+			d.exit(instance, q, now)
+		}
+	}
+}
+
+// 窗口参与判断的最少请求数，安全模式中只放行探测请求时按探测比例降低，至少为1
+func (d *anomalyDetector) minSamples(q *modelQuality) int {
+	if !q.safe || q.action == config.SafeActionRaiseThreshold || d.cfg.ProbeRatio >= 1 {
+		return d.cfg.MinSamples
+	}
+	n := int(math.Ceil(float64(d.cfg.MinSamples) * d.cfg.ProbeRatio))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// 返回比率异常的信号，没有时返回空
+func (d *anomalyDetector) anomalousSignal(q *modelQuality) string {
+	for _, s := range qualitySignals {
+		if q.rates[s] >= d.cfg.MinRate && q.rates[s] > q.baseline[s]*d.cfg.RateMultiple {
+			return s
+		}
+	}
+	return ""
+}
+
+// 所有信号都已恢复
+func (d *anomalyDetector) recoveredWindow(q *modelQuality) bool {
+	for _, s := range qualitySignals {
+		if q.rates[s] >= d.cfg.MinRate && q.rates[s] > q.baseline[s]*d.cfg.RecoverMultiple {
+			return false
+		}
+	}
+	return true
+}
+
+// 该模型是否还有以指定动作处于安全模式的模型池，action为空时不限动作
+func (d *anomalyDetector) modelInSafeMode(modelName, action string) bool {
+	for _, q := range d.models {
+		if q.model == modelName && q.safe && (action == "" || q.action == action) {
+			return true
+		}
+	}
+	return false
+}
+
+// 进入安全模式
+func (d *anomalyDetector) enter(instance string, q *modelQuality, trigger string, now time.Time) {
+	q.safe = true
+	q.action = d.cfg.Action
+	q.trigger = trigger
+	q.since = now
+	q.recovered = 0
+	q.probes = 0
+	if q.action == config.SafeActionRaiseThreshold {
+		completions.SetThresholdBoost(q.model, d.cfg.ThresholdBoost)
+	}
+	metrics.UpdateSafeMode(q.model, true)
+	metrics.IncrementAnomalies(q.model, trigger)
+	zap.L().Error("COMPLETION QUALITY ANOMALY: model entered safe mode",
+		zap.String("model", q.model),
+		zap.String("instance", instance),
+		zap.String("signal", trigger),
+		zap.Float64("rate", q.rates[trigger]),
+		zap.Float64("baseline", q.baseline[trigger]),
+		zap.String("action", q.action),
+		zap.Any("rates", q.rates))
+}
+
+// 退出安全模式，撤销进入时的动作；阈值提高和指标按模型生效，该模型的其他模型池仍在安全模式时保留
+func (d *anomalyDetector) exit(instance string, q *modelQuality, now time.Time) {
+	action := q.action
+	q.safe = false
+	q.action = ""
+	q.recovered = 0
+	if action == config.SafeActionRaiseThreshold && !d.modelInSafeMode(q.model, action) {
+		completions.SetThresholdBoost(q.model, 0)
+	}
+	metrics.UpdateSafeMode(q.model, d.modelInSafeMode(q.model, ""))
+	zap.L().Warn("Model recovered and left safe mode",
+		zap.String("model", q.model),
+		zap.String("instance", instance),
+		zap.String("signal", q.trigger),
+		zap.Duration("duration", now.Sub(q.since)),
+		zap.Any("rates", q.rates))
+	q.trigger = ""
+}
+
+/**
+ * 判断安全模式对请求的处理
+ * @param {string} instance - 选中的模型池标识
+ * @param {string} triggerMode - 请求的触发方式
+ * @returns {bool} 是否拒绝请求
+ * @returns {string} 需要切换到的后备模型名称或标签，为空表示不切换
+ * @description
+ * - 不在安全模式或动作为raise-threshold时正常处理(阈值在隐藏分过滤中提高)
+ * - 按ProbeRatio的比例放行探测请求，使模型恢复后能够退出安全模式
+ * - reject-auto只拒绝自动触发的请求
+ */
+func (d *anomalyDetector) admit(instance, triggerMode string) (bool, string) {
+	if !d.enabled() {
+		return false, ""
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	q, ok := d.models[instance]
+	if !ok || !q.safe || q.action == config.SafeActionRaiseThreshold {
+		return false, ""
+	}
+	q.probes++
+	if every := probeEvery(d.cfg.ProbeRatio); every > 0 && q.probes%every == 0 {
+		return false, ""
+	}
+	switch q.action {
+	case config.SafeActionRejectAuto:
+		return strings.ToUpper(triggerMode) == "AUTO", ""
+	case config.SafeActionFallback:
+		return false, d.cfg.FallbackModel
+	}
+	return false, ""
+}
+
+// 每多少个请求放行一个探测请求，0表示不放行
+func probeEvery(ratio float64) uint64 {
+	if ratio <= 0 {
+		return 0
+	}
+	if ratio >= 1 {
+		return 1
+	}
+	return uint64(math.Round(1 / ratio))
+}
+
+// 模型池是否处于会改变请求路由的安全模式(fallback或reject-auto)
+func (d *anomalyDetector) diverting(instance string) bool {
+	if !d.enabled() {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	q, ok := d.models[instance]
+	return ok && q.safe && q.action != config.SafeActionRaiseThreshold
+}
+
+// 各模型池的安全模式状态，按模型池标识排序
+func (d *anomalyDetector) states() []SafeModeState {
+	states := []SafeModeState{}
+	if d == nil {
+		return states
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for instance, q := range d.models {
+		state := SafeModeState{
+			Model:     q.model,
+			Instance:  instance,
+			SafeMode:  q.safe,
+			Action:    q.action,
+			Trigger:   q.trigger,
+			Recovered: q.recovered,
+			Rates:     copyRates(q.rates),
+			Baseline:  copyRates(q.baseline),
+		}
+		if q.safe {
+			since := q.since
+			state.Since = &since
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Instance < states[j].Instance
+	})
+	return states
+}
+
+func copyRates(rates map[string]float64) map[string]float64 {
+	out := make(map[string]float64, len(rates))
+	for s, r := range rates {
+		out[s] = r
+	}
+	return out
+}
+
+// 安全模式拒绝请求的错误
+func safeModeError(modelName string) error {
+	return fmt.Errorf("model %s is in safe mode, auto completion rejected", modelName)
+}
@@ -0,0 +1,176 @@
+package stream_controller
+
+import (
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+func newAnomalyConfig(action string) *config.AnomalyConfig {
+	return &config.AnomalyConfig{
+		Enabled:         true,
+		Action:          action,
+		MinSamples:      20,
+		RateMultiple:    3,
+		RecoverMultiple: 1.5,
+		MinRate:         0.1,
+		BaselineWeight:  0.2,
+		RecoverWindows:  3,
+		ThresholdBoost:  0.2,
+		FallbackModel:   "stable",
+		ProbeRatio:      0.25,
+	}
+}
+
+func newAnomalyPool(instance, modelName string) *ModelPool {
+	return &ModelPool{instance: instance, cfg: &config.ModelConfig{ModelName: modelName}}
+}
+
+var anomalyPool = newAnomalyPool("m1#0", "m1")
+
+// 喂入一个窗口的请求，其中empty个为空结果、discard个被后置处理整体丢弃(语法错误)，再结束窗口
+func feedWindow(d *anomalyDetector, now time.Time, requests, empty, discard int) {
+	feedPool(d, anomalyPool, requests, empty, discard)
+	d.evaluate(now)
+}
+
+// 向模型池喂入请求，不结束窗口
+func feedPool(d *anomalyDetector, pool *ModelPool, requests, empty, discard int) {
+	for i := 0; i < requests; i++ {
+		rsp := &completions.CompletionResponse{Status: model.StatusSuccess}
+		switch {
+		case i < discard:
+			rsp.Status = model.StatusEmpty
+			rsp.Discarded = true
+			rsp.Hits = []string{completions.DiscardSyntaxError}
+		case i < discard+empty:
+			rsp.Status = model.StatusEmpty
+		}
+		d.record(pool, rsp)
+	}
+}
+
+// to test entering and leaving safe mode with hysteresis on synthetic rate series
+// go test ./pkg/stream_controller/ -v -run Test_AnomalySafeMode
+func Test_AnomalySafeMode(t *testing.T) {
+	d := newAnomalyDetector(newAnomalyConfig(config.SafeActionRaiseThreshold))
+	now := time.Now()
+	safe := func() bool { return d.states()[0].SafeMode }
+
+	// 基线：空结果5%，偶尔的波动不触发
+	for _, empty := range []int{5, 5, 8, 5} {
+		now = now.Add(time.Minute)
+		feedWindow(d, now, 100, empty, 0)
+		if safe() {
+			t.Fatalf("expected no safe mode for %d%% empty", empty)
+		}
+	}
+	// 样本不足的窗口不参与判断
+	feedWindow(d, now.Add(time.Minute), 10, 10, 0)
+	if safe() {
+		t.Fatal("expected windows below minSamples ignored")
+	}
+	// 坏的模型上线：丢弃率升高
+	now = now.Add(time.Minute)
+	feedWindow(d, now, 100, 5, 40)
+	state := d.states()[0]
+	if !state.SafeMode || state.Trigger != SignalEmpty || state.Action != config.SafeActionRaiseThreshold {
+		t.Fatalf("expected safe mode triggered, got %+v", state)
+	}
+	if completions.ThresholdBoost("m1") != 0.2 {
+		t.Errorf("expected the hidden score threshold raised, got %v", completions.ThresholdBoost("m1"))
+	}
+	baseline := state.Baseline[SignalEmpty]
+
+	// 回落到进入阈值以下但高于恢复阈值时不退出，且清零恢复计数
+	for _, series := range [][2]int{{5, 0}, {5, 0}, {12, 0}, {5, 0}, {5, 0}} {
+		now = now.Add(time.Minute)
+		feedWindow(d, now, 100, series[0], series[1])
+		if !safe() {
+			t.Fatalf("expected safe mode kept until %d recovered windows", d.cfg.RecoverWindows)
+		}
+	}
+	if d.states()[0].Recovered != 2 {
+		t.Errorf("expected the recovered count reset by a relapse, got %d", d.states()[0].Recovered)
+	}
+	now = now.Add(time.Minute)
+	feedWindow(d, now, 100, 5, 0)
+	state = d.states()[0]
+	if state.SafeMode || state.Since != nil || completions.ThresholdBoost("m1") != 0 {
+		t.Errorf("expected safe mode left after sustained recovery, got %+v", state)
+	}
+	if state.Baseline[SignalEmpty] != baseline {
+		t.Errorf("expected the baseline frozen during safe mode, got %v", state.Baseline[SignalEmpty])
+	}
+}
+
+// to test the routing of requests to a model in safe mode
+// go test ./pkg/stream_controller/ -v -run Test_AnomalyAdmit
+func Test_AnomalyAdmit(t *testing.T) {
+	enterSafeMode := func(d *anomalyDetector) {
+		now := time.Now()
+		feedWindow(d, now, 100, 0, 0)
+		feedWindow(d, now.Add(time.Minute), 100, 0, 50)
+	}
+	d := newAnomalyDetector(newAnomalyConfig(config.SafeActionRejectAuto))
+	enterSafeMode(d)
+	rejected := 0
+	for i := 0; i < 8; i++ {
+		if reject, _ := d.admit("m1#0", "auto"); reject {
+			rejected++
+		}
+	}
+	if rejected != 6 {
+		t.Errorf("expected 1 of 4 auto requests probing the model, got %d rejected", rejected)
+	}
+	if reject, _ := d.admit("m1#0", "MANUAL"); reject {
+		t.Error("expected manual requests served")
+	}
+	if reject, fallback := d.admit("m2#0", "AUTO"); reject || fallback != "" {
+		t.Error("expected models not in safe mode served")
+	}
+
+	d = newAnomalyDetector(newAnomalyConfig(config.SafeActionFallback))
+	enterSafeMode(d)
+	if _, fallback := d.admit("m1#0", "MANUAL"); fallback != "stable" {
+		t.Errorf("expected the fallback model, got %q", fallback)
+	}
+
+	disabled := newAnomalyConfig(config.SafeActionRejectAuto)
+	disabled.Enabled = false
+	d = newAnomalyDetector(disabled)
+	enterSafeMode(d)
+	if reject, _ := d.admit("m1#0", "AUTO"); reject || len(d.states()) != 0 {
+		t.Error("expected nothing recorded when disabled")
+	}
+}
+
+// to test that only the probed traffic of a pool in safe mode is enough to leave it, and pools of a model are judged separately
+// go test ./pkg/stream_controller/ -v -run Test_AnomalySafeModeExit
+func Test_AnomalySafeModeExit(t *testing.T) {
+	d := newAnomalyDetector(newAnomalyConfig(config.SafeActionFallback))
+	replica := newAnomalyPool("m1#1", "m1")
+	now := time.Now()
+	feedPool(d, replica, 100, 0, 0)
+	feedWindow(d, now, 100, 0, 0)
+	feedPool(d, replica, 100, 0, 0)
+	feedWindow(d, now.Add(time.Minute), 100, 0, 50)
+	states := d.states()
+	if len(states) != 2 || !states[0].SafeMode || states[1].SafeMode {
+		t.Fatalf("expected only the bad pool in safe mode, got %+v", states)
+	}
+	if _, fallback := d.admit("m1#1", "AUTO"); fallback != "" {
+		t.Error("expected the healthy replica served")
+	}
+	// 安全模式中只有ProbeRatio比例的请求发给该模型池，少于MinSamples也参与判断
+	for i := 0; i < d.cfg.RecoverWindows; i++ {
+		now = now.Add(time.Minute)
+		feedWindow(d, now, 5, 0, 0)
+	}
+	if d.states()[0].SafeMode {
+		t.Errorf("expected safe mode left on the probed traffic, got %+v", d.states()[0])
+	}
+}
@@ -0,0 +1,290 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+//	并发数自动调整: 跟踪模型池的在途并发数与调用模型耗时的关系，按AIMD规则在上下限内调整模型池的最大并发数
+//
+
+// 自动调整的默认值，见config.ConcurrencyTuning
+const (
+	defaultTuneInterval   = 30 * time.Second
+	defaultTuneWindows    = 5 // 滑动窗口为调整间隔的倍数
+	defaultTuneMinSamples = 20
+	defaultTuneIncrease   = 1
+	defaultTuneBackoff    = 0.7
+)
+
+// 自动调整的动作
+const (
+	TuneIncrease = "increase" // p90低于目标且并发槽位用满，增加并发数
+	TuneDecrease = "decrease" // p90超过目标，成倍降低并发数
+	TuneHold     = "hold"     // 保持不变
+)
+
+// 自动调整的操作方，记录在模型池调整的审计记录中
+const TuneOperator = "autotune"
+
+/**
+ * 一次自动调整的决定，通过/api/stats查看
+ * @description
+ * - From/To: 调整前后的最大并发数，保持不变时相同
+ * - P90Ms: 参与判断的样本的调用模型耗时p90(毫秒)
+ * - Peak: 样本中的最大在途并发数，用于判断并发槽位是否用满
+ */
+type TuneDecision struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	From    int       `json:"from"`
+	To      int       `json:"to"`
+	Reason  string    `json:"reason"`
+	P90Ms   int64     `json:"p90Ms"`
+	Samples int       `json:"samples"`
+	Peak    int       `json:"peak"`
+}
+
+// 一次调用模型的耗时及开始调用时的在途并发数(含该请求)
+type latencySample struct {
+	at       time.Time
+	inflight int
+	latency  time.Duration
+}
+
+// 模型池的并发数自动调整器
+type concurrencyTuner struct {
+	mutex   sync.Mutex
+	cfg     config.ConcurrencyTuning // 补全了默认值的配置
+	samples []latencySample
+	since   time.Time // 上次调整的时间，之前的样本不再参与判断
+	last    *TuneDecision
+}
+
+/**
+ * 创建模型池的并发数自动调整器
+ * @param {*config.ModelConfig} cfg - 模型配置，Autotune未开启时返回nil
+ * @returns {*concurrencyTuner} 返回补全了默认值的调整器
+ * @description
+ * - 上限不配置时为配置的maxConcurrent的2倍，都不超过streamController.maxPoolConcurrent
+ */
+func newConcurrencyTuner(cfg *config.ModelConfig) *concurrencyTuner {
+	if !cfg.Autotune.Enabled {
+		return nil
+	}
+	t := cfg.Autotune
+	if t.Interval <= 0 {
+		t.Interval = defaultTuneInterval
+	}
+	if t.Window <= 0 {
+		t.Window = defaultTuneWindows * t.Interval
+	}
+	if t.MinSamples <= 0 {
+		t.MinSamples = defaultTuneMinSamples
+	}
+	if t.Increase <= 0 {
+		t.Increase = defaultTuneIncrease
+	}
+	if t.Backoff <= 0 || t.Backoff >= 1 {
+		t.Backoff = defaultTuneBackoff
+	}
+	t.MinConcurrent = max(t.MinConcurrent, 1)
+	if t.MaxConcurrent <= 0 {
+		t.MaxConcurrent = max(cfg.MaxConcurrent*2, t.MinConcurrent)
+	}
+	if limit := config.Config.StreamController.MaxPoolConcurrent; limit > 0 {
+		t.MaxConcurrent = min(t.MaxConcurrent, limit)
+		t.MinConcurrent = min(t.MinConcurrent, t.MaxConcurrent)
+	}
+	return &concurrencyTuner{cfg: t}
+}
+
+// 记录一次调用模型的耗时，客户端取消和没有调用模型的请求不计入；同时去掉过期的样本，暂停调整(如启动预热)期间样本也不会无限增长
+func (t *concurrencyTuner) observe(now time.Time, inflight int, rsp *completions.CompletionResponse) {
+	if t == nil || rsp == nil || rsp.Usage.LLMDuration <= 0 || rsp.Status == model.StatusCanceled {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.samples = append(t.samples, latencySample{at: now, inflight: inflight,
+		latency: time.Duration(rsp.Usage.LLMDuration) * time.Millisecond})
+	t.expire(now)
+}
+
+// 去掉滑动窗口之外和上次调整之前的样本，调用者需持有t.mutex
+func (t *concurrencyTuner) expire(now time.Time) {
+	from := now.Add(-t.cfg.Window)
+	if t.since.After(from) {
+		from = t.since
+	}
+	i := sort.Search(len(t.samples), func(i int) bool { return !t.samples[i].at.Before(from) })
+	t.samples = append(t.samples[:0], t.samples[i:]...)
+}
+
+/**
+ * 按最近的样本决定模型池的最大并发数
+ * @param {time.Time} now - 当前时间
+ * @param {int} current - 当前的最大并发数
+ * @returns {TuneDecision} 返回决定，To与current不同时调用方按To调整模型池
+ * @description
+ * - 当前值超出上下限(如被手动调整)时先调回上下限内
+ * - 样本少于MinSamples时保持不变
+ * - p90超过目标时降低到current*Backoff(至少降低1)，不低于下限
+ * - p90不超过目标时，样本中的在途并发数达到过current(并发槽位用满)才增加，不超过上限；
+ *   没有用满时增加并发数不会带来更多吞吐
+ * - 调整后清空样本，之后的判断只使用新并发数下的样本
+ */
+func (t *concurrencyTuner) decide(now time.Time, current int) TuneDecision {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.expire(now)
+	d := TuneDecision{Time: now, Action: TuneHold, From: current, To: current, Samples: len(t.samples)}
+	latencies := make([]time.Duration, len(t.samples))
+	for i, s := range t.samples {
+		latencies[i] = s.latency
+		d.Peak = max(d.Peak, s.inflight)
+	}
+	target := t.cfg.TargetLatency
+	switch {
+	case current > t.cfg.MaxConcurrent:
+		d.To, d.Reason = t.cfg.MaxConcurrent, fmt.Sprintf("above the maximum %d", t.cfg.MaxConcurrent)
+	case current < t.cfg.MinConcurrent:
+		d.To, d.Reason = t.cfg.MinConcurrent, fmt.Sprintf("below the minimum %d", t.cfg.MinConcurrent)
+	case len(latencies) < t.cfg.MinSamples:
+		d.Reason = fmt.Sprintf("%d samples, need %d", len(latencies), t.cfg.MinSamples)
+	default:
+		p90 := percentileDuration(latencies, 0.9)
+		d.P90Ms = p90.Milliseconds()
+		switch {
+		case p90 > target:
+			d.To = max(t.cfg.MinConcurrent, min(int(math.Floor(float64(current)*t.cfg.Backoff)), current-1))
+			d.Reason = fmt.Sprintf("p90 %s over the target %s", p90, target)
+		case d.Peak < current:
+			d.Reason = fmt.Sprintf("p90 %s within the target %s, pool not saturated (peak %d)", p90, target, d.Peak)
+		default:
+			d.To = min(t.cfg.MaxConcurrent, current+t.cfg.Increase)
+			d.Reason = fmt.Sprintf("p90 %s within the target %s", p90, target)
+		}
+	}
+	switch {
+	case d.To > current:
+		d.Action = TuneIncrease
+	case d.To < current:
+		d.Action = TuneDecrease
+	}
+	if d.To != current {
+		t.restartLocked(now)
+	}
+	t.last = &d
+	return d
+}
+
+// 并发数被手动调整后重新收集样本，之前的样本属于旧的并发数
+func (t *concurrencyTuner) restart(now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.restartLocked(now)
+}
+
+// 清空样本，之后只使用now之后的样本，调用者需持有t.mutex
+func (t *concurrencyTuner) restartLocked(now time.Time) {
+	t.since = now
+	t.samples = t.samples[:0]
+}
+
+// 最近一次的决定，还没有决定时返回nil
+func (t *concurrencyTuner) lastDecision() *TuneDecision {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.last == nil {
+		return nil
+	}
+	d := *t.last
+	return &d
+}
+
+// 耗时的分位数，latencies会被排序
+func percentileDuration(latencies []time.Duration, q float64) time.Duration {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	i := int(math.Ceil(q*float64(len(latencies)))) - 1
+	return latencies[min(max(i, 0), len(latencies)-1)]
+}
+
+/**
+ * 对一个模型池做一次自动调整
+ * @param {*ModelPool} pool - 开启了自动调整的模型池
+ * @param {time.Time} now - 当前时间
+ * @returns {TuneDecision} 返回决定
+ * @description
+ * - 与手动调整共用resizeMutex，调整记录在审计记录中，操作方为autotune
+ */
+func (m *PoolManager) tunePool(pool *ModelPool, now time.Time) TuneDecision {
+	m.resizeMutex.Lock()
+	defer m.resizeMutex.Unlock()
+
+	pool.mutex.RLock()
+	current := pool.cfg.MaxConcurrent
+	pool.mutex.RUnlock()
+	d := pool.tuner.decide(now, current)
+	metrics.IncrementAutotuneDecisions(pool.cfg.ModelName, d.Action)
+	if d.To == current {
+		zap.L().Debug("Autotune kept pool concurrency", zap.String("model", pool.cfg.ModelName),
+			zap.Int("maxConcurrent", current), zap.String("reason", d.Reason))
+		return d
+	}
+	m.resizePool(pool, d.To)
+	m.resizes = append(m.resizes, PoolResizeRecord{Time: now, Model: pool.cfg.ModelName, Instance: pool.instance, From: current, To: d.To, Operator: TuneOperator})
+	if len(m.resizes) > maxResizeRecords {
+		m.resizes = m.resizes[len(m.resizes)-maxResizeRecords:]
+	}
+	zap.L().Info("Autotune resized pool", zap.String("model", pool.cfg.ModelName),
+		zap.Int("from", current), zap.Int("to", d.To), zap.String("reason", d.Reason))
+	return d
+}
+
+/**
+ * 为开启了自动调整的模型池启动定时调整
+ * @param {func() bool} paused - 返回true时跳过本次调整(如启动预热期间)
+ * @param {<-chan struct{}} done - 关闭时调整协程退出，见StreamController.Stop
+ */
+func (m *PoolManager) runTuners(paused func() bool, done <-chan struct{}) {
+	for _, pool := range m.allPools() {
+		if pool.tuner == nil {
+			continue
+		}
+		go func(pool *ModelPool) {
+			defer DumpOnPanic()
+			ticker := time.NewTicker(pool.tuner.cfg.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					if !paused() {
+						m.tunePool(pool, now)
+					}
+				case <-done:
+					return
+				}
+			}
+		}(pool)
+	}
+}
@@ -0,0 +1,115 @@
+package stream_controller
+
+import (
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 模拟的模型：8个并发以内耗时100ms，之后每多一个并发增加40ms，jitter为±10%的抖动
+func simulatedLatency(concurrency int, jitter float64) time.Duration {
+	latency := 100*time.Millisecond + time.Duration(max(concurrency-8, 0))*40*time.Millisecond
+	return time.Duration(float64(latency) * (1 + jitter))
+}
+
+/**
+ * 模拟自动调整：每个调整间隔内模型池满载，按当前并发数下模拟模型的耗时产生样本
+ * @returns {[]int} 返回每次调整后的并发数
+ */
+func simulateAutotune(t *testing.T, tune config.ConcurrencyTuning, start, rounds int, latency func(int, float64) time.Duration, busy func(int) int) []int {
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "sim", MaxConcurrent: start, Autotune: tune}}
+	m := NewPoolManager()
+	pool := m.initPool("sim", llm, llm.Config())
+	if pool.tuner == nil {
+		t.Fatal("expected the tuner enabled")
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var history []int
+	for round := 0; round < rounds; round++ {
+		current := pool.cfg.MaxConcurrent
+		for i := 0; i < 30; i++ {
+			rsp := &completions.CompletionResponse{Status: model.StatusSuccess}
+			rsp.Usage.LLMDuration = latency(current, float64(i%5-2)/20).Milliseconds()
+			pool.tuner.observe(now.Add(time.Duration(i)*10*time.Millisecond), busy(current), rsp)
+		}
+		now = now.Add(tune.Interval)
+		m.tunePool(pool, now)
+		history = append(history, pool.cfg.MaxConcurrent)
+	}
+	return history
+}
+
+// to test the AIMD tuner converging near the concurrency where the p90 latency reaches the target and respecting the bounds
+// go test ./pkg/stream_controller/ -v -run Test_AutotuneConvergence
+func Test_AutotuneConvergence(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	saturated := func(current int) int { return current }
+	tune := config.ConcurrencyTuning{Enabled: true, TargetLatency: 200 * time.Millisecond, MinConcurrent: 2, MaxConcurrent: 16,
+		Interval: time.Second, MinSamples: 20}
+
+	// p90不超过200ms的最大并发数为10，之后在加性增加和成倍降低之间振荡
+	history := simulateAutotune(t, tune, 2, 60, simulatedLatency, saturated)
+	sum := 0
+	for i, n := range history {
+		if n < tune.MinConcurrent || n > tune.MaxConcurrent {
+			t.Fatalf("round %d: concurrency %d out of bounds", i, n)
+		}
+		if i >= 20 {
+			if n < 7 || n > 11 {
+				t.Errorf("round %d: expected the concurrency near 10, got %d in %v", i, n, history)
+			}
+			sum += n
+		}
+	}
+	if mean := float64(sum) / 40; mean < 8 || mean > 10.5 {
+		t.Errorf("expected the mean concurrency near the optimum, got %.1f", mean)
+	}
+
+	// 上限低于最优点时停在上限
+	bounded := tune
+	bounded.MaxConcurrent = 6
+	if history := simulateAutotune(t, bounded, 2, 20, simulatedLatency, saturated); history[len(history)-1] != 6 {
+		t.Errorf("expected the concurrency held at the maximum, got %v", history)
+	}
+
+	// 模型一直很慢时降到下限为止；启动时超出上限的并发数先调回上限
+	slow := func(int, float64) time.Duration { return time.Second }
+	bounded.MinConcurrent = 4
+	history = simulateAutotune(t, bounded, 12, 10, slow, saturated)
+	if history[0] != 6 || history[len(history)-1] != 4 {
+		t.Errorf("expected the concurrency clamped and backed off to the minimum, got %v", history)
+	}
+
+	// 并发槽位没有用满时不增加
+	idle := func(int) int { return 3 }
+	if history := simulateAutotune(t, tune, 6, 5, simulatedLatency, idle); history[len(history)-1] != 6 {
+		t.Errorf("expected no increase for an unsaturated pool, got %v", history)
+	}
+}
+
+// to test the tuner samples expiring on record while tuning is paused
+// go test ./pkg/stream_controller/ -v -run Test_AutotuneSamplesBounded
+func Test_AutotuneSamplesBounded(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "bounded", MaxConcurrent: 4,
+		Autotune: config.ConcurrencyTuning{Enabled: true, TargetLatency: time.Second, Interval: time.Second, Window: 5 * time.Second}}}
+	m := NewPoolManager()
+	pool := m.initPool("bounded", llm, llm.Config())
+
+	// 每秒10个样本，只保留滑动窗口5秒内的
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 1000; i++ {
+		rsp := &completions.CompletionResponse{Status: model.StatusSuccess}
+		rsp.Usage.LLMDuration = 100
+		pool.tuner.observe(now.Add(time.Duration(i)*100*time.Millisecond), 1, rsp)
+	}
+	pool.tuner.mutex.Lock()
+	n := len(pool.tuner.samples)
+	pool.tuner.mutex.Unlock()
+	if n > 51 {
+		t.Errorf("expected the samples bounded by the window, got %d", n)
+	}
+}
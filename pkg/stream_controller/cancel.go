@@ -0,0 +1,39 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+
+	"go.uber.org/zap"
+)
+
+/**
+ * 服务关闭前排空等待队列
+ * @returns {int} 返回取消的请求数
+ * @description
+ * - 只取消还没有被模型池取出的请求，原因记为drain，正在调用模型的请求继续完成
+ * - 在HTTP服务关闭前调用，排队的请求立即返回，不必等到截止时间
+ */
+func (sc *StreamController) Drain() int {
+	canceled := sc.queues.CancelMatching(completions.CancelDrain, func(req *ClientRequest) bool {
+		return !req.wasDispatched()
+	})
+	zap.L().Info("Drained queued requests", zap.Int("canceled", canceled))
+	return canceled
+}
+
+/**
+ * 通过管理接口取消补全请求
+ * @param {string} completionID - 补全请求ID
+ * @returns {int} 返回取消的请求数，没有该ID的在途请求时返回0
+ * @description
+ * - 排队和正在调用模型的请求都会被取消，原因记为admin
+ * - 不同客户端可能使用相同的completion_id，一并取消
+ */
+func (sc *StreamController) CancelCompletion(completionID string) int {
+	canceled := sc.queues.CancelMatching(completions.CancelAdmin, func(req *ClientRequest) bool {
+		return req.Para.CompletionID == completionID
+	})
+	zap.L().Info("Canceled completion by admin", zap.String("completionID", completionID),
+		zap.Int("canceled", canceled))
+	return canceled
+}
@@ -0,0 +1,110 @@
+package stream_controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+)
+
+// cancelLLM blocks every request until its context ends, reporting the status like the OpenAI client
+type cancelLLM struct {
+	cfg     config.ModelConfig
+	started chan string
+}
+
+func (f *cancelLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	f.started <- p.CompletionID
+	<-ctx.Done()
+	status := model.StatusCanceled
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = model.StatusTimeout
+	}
+	return nil, &model.CompletionVerbose{}, status, ctx.Err()
+}
+
+func (f *cancelLLM) Config() *config.ModelConfig {
+	return &f.cfg
+}
+
+func (f *cancelLLM) Tokenizer() *tokenizers.Tokenizer {
+	return nil
+}
+
+// to test every cancellation path records its own cause, status and metric label
+// go test ./pkg/stream_controller/ -v -run Test_CancelCauses
+func Test_CancelCauses(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := &cancelLLM{
+		cfg:     config.ModelConfig{ModelName: "cancel", MaxConcurrent: 1, MaxOutput: 50, DisablePrune: true},
+		started: make(chan string, 8),
+	}
+	sc := &StreamController{queues: NewQueueManager(), pools: NewPoolManager()}
+	pool := sc.pools.initPool("cancel", llm, llm.Config())
+	waitWorkers(t, pool, 1)
+
+	submit := func(ctx context.Context, clientID, completionID string) chan *completions.CompletionResponse {
+		para := &model.CompletionParameter{CompletionID: completionID, ClientID: clientID, Model: "cancel", Prefix: "x"}
+		req := sc.queues.AddRequest(ctx, para, &completions.CompletionPerformance{ReceiveTime: time.Now()})
+		done := make(chan *completions.CompletionResponse, 1)
+		go func() {
+			rsp := sc.pools.WaitDoRequest(req)
+			sc.queues.RemoveRequest(req)
+			done <- rsp
+		}()
+		return done
+	}
+	expect := func(done chan *completions.CompletionResponse, status model.CompletionStatus, cause completions.CancelCause) {
+		t.Helper()
+		select {
+		case rsp := <-done:
+			if rsp.Status != status || rsp.CancelCause != cause {
+				t.Errorf("expected %s/%s, got %s/%s (%s)", status, cause, rsp.Status, rsp.CancelCause, rsp.Error)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %s/%s, request not finished", status, cause)
+		}
+	}
+
+	// 同一客户端的新请求取代正在执行的请求
+	first := submit(context.Background(), "c1", "first")
+	<-llm.started
+	running := submit(context.Background(), "c1", "running")
+	expect(first, model.StatusCanceled, completions.CancelSuperseded)
+	if id := <-llm.started; id != "running" {
+		t.Fatalf("expected the newer request to run, got %s", id)
+	}
+
+	// 客户端断开、排空、排队超时都发生在排队中，正在执行的请求不受排空影响
+	parent, disconnect := context.WithCancel(context.Background())
+	disconnected := submit(parent, "c2", "disconnected")
+	drained := submit(context.Background(), "c3", "drained")
+	waitQueued(t, pool, 2)
+	disconnect()
+	expect(disconnected, model.StatusCanceled, completions.CancelDisconnected)
+	if n := sc.Drain(); n != 1 {
+		t.Errorf("expected to drain 1 queued request, got %d", n)
+	}
+	expect(drained, model.StatusCanceled, completions.CancelDrain)
+	config.Config.StreamController.CompletionTimeout = 50 * time.Millisecond
+	expect(submit(context.Background(), "c4", "queued"), model.StatusTimeout, completions.CancelDeadlineQueued)
+
+	// 管理接口取消正在执行的请求
+	if n := sc.CancelCompletion("running"); n != 1 {
+		t.Errorf("expected to cancel 1 request, got %d", n)
+	}
+	expect(running, model.StatusCanceled, completions.CancelAdmin)
+	if n := sc.CancelCompletion("running"); n != 0 {
+		t.Errorf("expected nothing left to cancel, got %d", n)
+	}
+
+	// 调用模型时超时
+	late := submit(context.Background(), "c5", "late")
+	<-llm.started
+	expect(late, model.StatusTimeout, completions.CancelDeadlineRunning)
+}
@@ -0,0 +1,276 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 补全请求的去重结果
+const (
+	RouteServed   = "served"   // 实际处理了请求
+	RouteAttached = "attached" // 相同completion_id的请求正在处理，等待其结果
+	RouteReplayed = "replayed" // 相同completion_id的请求已处理完，重放其结果
+	// 相同completion_id的请求已处理完但响应没有送达(客户端连接断开)，重放其结果
+	RouteRedelivered = "redelivered"
+)
+
+// 去重窗口内最多记录的completion_id数
+const maxDedupEntries = 100000
+
+// 补全请求由哪个路由处理
+type RouteResult struct {
+	ServedBy string // 实际处理请求的路由
+	Outcome  string // 去重结果(served/attached/replayed)
+}
+
+// 同一completion_id的一次处理，done关闭后rsp可读
+type dedupEntry struct {
+	done        chan struct{}
+	route       string
+	rsp         *completions.CompletionResponse // 去除了Verbose的响应，见memoResponse
+	undelivered atomic.Bool                     // 响应没有送达客户端，见retain
+	stripped    bool                            // 隐私模式下去除了响应，只用于作废，查找时视为没有记录
+}
+
+// 隐私模式下处理完的记录去除响应，处理中的记录还没有响应，原样保存
+func (e *dedupEntry) Strip() *dedupEntry {
+	if e.rsp == nil {
+		return e
+	}
+	return &dedupEntry{done: e.done, route: e.route, stripped: true}
+}
+
+/**
+ * 按client_id和completion_id对补全请求去重
+ * @description
+ * - 键与请求的路由无关，插件对新旧路由各发一次的请求只处理一次
+ * - 后到的请求在前一个处理中时等待其结果，处理完后在去重窗口内重放其结果
+ * - 只重放成功、空补全和拒绝的结果；取消、超时、繁忙等结果处理完即删除，之后的请求重新处理
+ * - 响应没有送达客户端的结果重新计算去重窗口，客户端重连后的重试直接重放(redelivered)
+ * - 隐私模式下处理完的记录不保存响应，之后的请求重新处理，只有等待中的请求得到结果
+ */
+type completionDedup struct {
+	mutex   sync.Mutex
+	entries *store.Store[string, *dedupEntry]
+}
+
+func newCompletionDedup(window time.Duration) *completionDedup {
+	return &completionDedup{
+		entries: store.New(store.Options[string, *dedupEntry]{
+			Name:       "completion_dedup",
+			MaxEntries: maxDedupEntries,
+			TTL:        window,
+		}),
+	}
+}
+
+// 去重记录保存的响应：浅复制并去除Verbose，Verbose引用请求的提示词和发往模型的请求体，不随记录保存到去重窗口结束
+func memoResponse(rsp *completions.CompletionResponse) *completions.CompletionResponse {
+	if rsp == nil || rsp.Verbose == nil {
+		return rsp
+	}
+	memo := *rsp
+	memo.Verbose = nil
+	return &memo
+}
+
+// 两个响应是否是同一次处理的结果(原响应或它去除了Verbose的副本)
+func sameResult(a, b *completions.CompletionResponse) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a == b || (a.ID == b.ID && a.Usage.ReceiveTime.Equal(b.Usage.ReceiveTime))
+}
+
+/**
+ * 等待或重放已有记录的结果
+ * @param {context.Context} ctx - 请求上下文，等待在途请求时用于取消
+ * @param {string} route - 当前请求的路由
+ * @returns {*completions.CompletionResponse, RouteResult} 返回记录的响应，等待时请求取消返回canceled
+ */
+func (e *dedupEntry) wait(ctx context.Context, route string) (*completions.CompletionResponse, RouteResult) {
+	outcome := RouteReplayed
+	select {
+	case <-e.done:
+	default:
+		outcome = RouteAttached
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			// 等待者没有排队，只会因客户端断开或严格截止时间到时而取消
+			perf := &completions.CompletionPerformance{ReceiveTime: time.Now()}
+			rsp := completions.CancelRequest("", "", perf, model.StatusCanceled, ctx.Err())
+			rsp.CancelCause = completions.CancelDisconnected
+			return rsp, RouteResult{ServedBy: route, Outcome: outcome}
+		}
+	}
+	if outcome == RouteReplayed && e.undelivered.Load() {
+		outcome = RouteRedelivered
+	}
+	return e.rsp, RouteResult{ServedBy: e.route, Outcome: outcome}
+}
+
+/**
+ * 只等待或重放已有记录的结果，没有记录时自行处理，结果不写入去重记录
+ * @description
+ * - 用于严格截止时间模式：处理在截止时间到时被取消、后置处理可能被跳过，结果不能作为同一请求其他副本的结果
+ */
+func (d *completionDedup) join(ctx context.Context, key, route string,
+	process func() *completions.CompletionResponse) (*completions.CompletionResponse, RouteResult) {
+	d.mutex.Lock()
+	e, ok := d.entries.Get(key)
+	d.mutex.Unlock()
+	if ok && !e.stripped {
+		return e.wait(ctx, route)
+	}
+	return process(), RouteResult{ServedBy: route, Outcome: RouteServed}
+}
+
+// 可以重放给后到请求的结果
+func replayable(status model.CompletionStatus) bool {
+	return status == model.StatusSuccess || status == model.StatusEmpty || status == model.StatusRejected
+}
+
+/**
+ * 处理补全请求，相同的请求只处理一次
+ * @param {context.Context} ctx - 请求上下文，等待在途请求时用于取消
+ * @param {string} key - 去重键
+ * @param {string} route - 当前请求的路由
+ * @param {func() *completions.CompletionResponse} process - 实际处理请求的函数
+ * @returns {*completions.CompletionResponse, RouteResult} 返回补全响应，以及由哪个路由处理
+ * @description
+ * - 处理请求的一方得到完整的响应，等待和重放的一方得到去除了Verbose的响应，见memoResponse
+ */
+func (d *completionDedup) do(ctx context.Context, key, route string,
+	process func() *completions.CompletionResponse) (*completions.CompletionResponse, RouteResult) {
+	d.mutex.Lock()
+	if e, ok := d.entries.Get(key); ok && !e.stripped {
+		d.mutex.Unlock()
+		return e.wait(ctx, route)
+	}
+	e := &dedupEntry{done: make(chan struct{}), route: route}
+	d.entries.Put(key, e)
+	d.mutex.Unlock()
+
+	defer close(e.done)
+	rsp := process()
+	e.rsp = memoResponse(rsp)
+	if !replayable(e.rsp.Status) || store.Private() {
+		d.mutex.Lock()
+		if cur, ok := d.entries.Get(key); ok && cur == e {
+			if replayable(e.rsp.Status) {
+				// 写入的是去除了响应的记录，见Strip
+				d.entries.Put(key, e)
+			} else {
+				d.entries.Delete(key)
+			}
+		}
+		d.mutex.Unlock()
+	}
+	return rsp, RouteResult{ServedBy: route, Outcome: RouteServed}
+}
+
+/**
+ * 保留没有送达客户端的补全结果，客户端重连后重试同一completion_id时直接重放，不再调用模型
+ * @param {string} key - 去重键
+ * @param {string} route - 处理请求的路由
+ * @param {*completions.CompletionResponse} rsp - 没有送达的补全响应
+ * @description
+ * - 只保留可以重放的结果(成功、空补全和拒绝)，其他结果重试时重新处理；隐私模式下不保留
+ * - 重新写入记录，去重窗口从此时重新计算；记录已过期或被删除时新建一条已完成的记录
+ * - 新建的记录同样不保存Verbose，见memoResponse
+ */
+func (d *completionDedup) retain(key, route string, rsp *completions.CompletionResponse) {
+	if !replayable(rsp.Status) || store.Private() {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if e, ok := d.entries.Get(key); ok {
+		select {
+		case <-e.done:
+			if sameResult(e.rsp, rsp) {
+				e.undelivered.Store(true)
+				d.entries.Put(key, e)
+				return
+			}
+		default:
+			return // 同一completion_id的重试已在处理中
+		}
+	}
+	e := &dedupEntry{done: make(chan struct{}), route: route, rsp: memoResponse(rsp)}
+	close(e.done)
+	e.undelivered.Store(true)
+	d.entries.Put(key, e)
+}
+
+/**
+ * 作废同一completion_id的处理结果
+ * @param {string} key - 去重键
+ * @returns {bool, bool} 返回是否有该记录，以及记录是否已处理完；处理完的记录被删除，之后的请求重新处理
+ */
+func (d *completionDedup) forget(key string) (found bool, finished bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	e, ok := d.entries.Get(key)
+	if !ok {
+		return false, false
+	}
+	select {
+	case <-e.done:
+		d.entries.Delete(key)
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// 去重键，与路由无关
+func dedupKey(clientID, completionID string) string {
+	return clientID + "\x00" + completionID
+}
+
+/**
+ * 处理V1格式的补全请求，相同client_id和completion_id的请求只处理一次
+ * @param {context.Context} ctx - 请求上下文
+ * @param {string} route - 收到请求的路由，用于指标和响应头
+ * @param {*completions.CompletionInput} input - 补全输入
+ * @returns {*completions.CompletionResponse, RouteResult} 返回补全响应，以及由哪个路由处理
+ * @description
+ * - 新旧路由共用同一去重记录，后到的请求等待或重放先到请求的结果，不重复调用模型
+ * - 按路由和去重结果记录请求数
+ * - 缺少client_id或completion_id的请求不去重，由ProcessCompletionV1拒绝
+ * - 先处理请求中附带的作废列表(invalidate)，见Invalidate
+ * - 严格截止时间模式的请求(ctx中有completions.StrictDeadline)只等待或重放已有的记录，自己处理的结果不共用，见join
+ */
+func (sc *StreamController) ProcessCompletionOnce(ctx context.Context, route string,
+	input *completions.CompletionInput) (*completions.CompletionResponse, RouteResult) {
+	sc.invalidateList(input)
+	process := func() *completions.CompletionResponse {
+		return sc.ProcessCompletionV1(ctx, input)
+	}
+	var rsp *completions.CompletionResponse
+	result := RouteResult{ServedBy: route, Outcome: RouteServed}
+	if sc.dedup == nil || input.ClientID == "" || input.CompletionID == "" {
+		rsp = process()
+	} else {
+		key := dedupKey(input.ClientID, input.CompletionID)
+		if completions.StrictDeadlineFrom(ctx) != nil {
+			rsp, result = sc.dedup.join(ctx, key, route, process)
+		} else {
+			rsp, result = sc.dedup.do(ctx, key, route, process)
+		}
+		// 处理中被作废的请求，结果不再重放
+		if sc.invalidated(input.ClientID, input.CompletionID) {
+			sc.dedup.forget(key)
+		}
+	}
+	metrics.IncrementRouteRequests(route, result.Outcome)
+	return rsp, result
+}
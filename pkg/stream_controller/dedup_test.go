@@ -0,0 +1,94 @@
+package stream_controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/model"
+)
+
+func newDedupInput(clientID, completionID string) *completions.CompletionInput {
+	return &completions.CompletionInput{
+		CompletionRequest: completions.CompletionRequest{
+			ClientID:     clientID,
+			CompletionID: completionID,
+			LanguageID:   "javascript",
+			Prompts: &completions.PromptOptions{
+				Prefix:          "const two = one + ",
+				Suffix:          ";\n",
+				ProjectPath:     "/project",
+				FileProjectPath: "src/main.js",
+			},
+		},
+	}
+}
+
+// to test that the same completion_id posted to the legacy and the new route is processed once
+// go test ./pkg/stream_controller/ -v -run Test_DedupAcrossRoutes
+func Test_DedupAcrossRoutes(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(time.Millisecond)()
+	llm := newFakeLLM(2)
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, dedup: newCompletionDedup(time.Minute)}
+
+	type result struct {
+		rsp   *completions.CompletionResponse
+		route RouteResult
+	}
+	routes := []string{"/api/completions", "/code-completion/api/v1/completions"}
+	done := make(chan result, len(routes))
+	for _, route := range routes {
+		go func(route string) {
+			rsp, served := sc.ProcessCompletionOnce(context.Background(), route, newDedupInput("client-dup", "L-dup"))
+			done <- result{rsp, served}
+		}(route)
+	}
+	<-llm.started
+	select {
+	case id := <-llm.started:
+		t.Fatalf("expected one upstream call, got a second one for %s", id)
+	case <-time.After(30 * time.Millisecond):
+	}
+	llm.release <- struct{}{}
+
+	first, second := <-done, <-done
+	for _, r := range []result{first, second} {
+		if r.rsp.Status != model.StatusSuccess || r.rsp.Choices[0].Text != "ok" {
+			t.Errorf("unexpected response %s %q", r.rsp.Status, r.rsp.Error)
+		}
+	}
+	outcomes := map[string]bool{first.route.Outcome: true, second.route.Outcome: true}
+	if !outcomes[RouteServed] || !outcomes[RouteAttached] {
+		t.Errorf("expected one served and one attached request, got %+v %+v", first.route, second.route)
+	}
+	if first.route.ServedBy != second.route.ServedBy {
+		t.Errorf("expected both served by the same route, got %s and %s", first.route.ServedBy, second.route.ServedBy)
+	}
+	// 只有处理请求的一方得到Verbose，去重记录不保存引用提示词的Verbose
+	for _, r := range []result{first, second} {
+		if (r.route.Outcome == RouteServed) != (r.rsp.Verbose != nil) {
+			t.Errorf("expected the verbose only for the served request, %s got %v", r.route.Outcome, r.rsp.Verbose)
+		}
+	}
+
+	// a late arrival replays the result
+	rsp, served := sc.ProcessCompletionOnce(context.Background(), routes[0], newDedupInput("client-dup", "L-dup"))
+	if served.Outcome != RouteReplayed || !sameResult(rsp, first.rsp) || rsp.Verbose != nil {
+		t.Errorf("expected the result replayed without the verbose, got %+v", served)
+	}
+	if order := llm.getOrder(); len(order) != 1 {
+		t.Errorf("expected one upstream call, got %v", order)
+	}
+
+	// the same completion_id of another client is processed on its own
+	for _, clientID := range []string{"client-dup", "client-other"} {
+		rsp, served = sc.ProcessCompletionOnce(context.Background(), routes[1], newDedupInput(clientID, "S-dup"))
+		if served.Outcome != RouteServed || rsp.Status != model.StatusSuccess {
+			t.Errorf("expected %s to be served, got %+v %s", clientID, served, rsp.Status)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"time"
+)
+
+// 没有送达客户端的补全请求的概要
+type UndeliveredRequest struct {
+	Api          string // 收到请求的接口(v1/v2/openai)
+	Route        string // 收到请求的路由，用于去重记录；为空时不保留(只有v1接口去重)
+	ClientID     string
+	CompletionID string
+	Language     string
+}
+
+/**
+ * 记录没有送达客户端的补全(写响应时客户端连接已断开)
+ * @param {UndeliveredRequest} req - 补全请求的概要
+ * @param {*completions.CompletionResponse} rsp - 没有送达的补全响应
+ * @param {error} err - 写响应的错误
+ * @description
+ * - 按原状态计入completion_delivery_failures_total；completion_responses_total在写响应时已计为deliveredFailed，不再重复计数
+ * - 原状态为成功的补全以deliveredFailed状态、delivery阶段记入错误日志；失败的补全已按原状态记入，不再重复记录
+ * - 写响应的一方已记录日志，这里不再记录
+ * - 客户端没有看到的补全不等待采纳反馈，不计入采纳指标和阈值调整的样本；重放的补全同样不再统计
+ * - 可以重放的结果保留在去重记录中，客户端重连后重试同一completion_id时直接重放，不再调用模型
+ */
+func (sc *StreamController) Undelivered(req UndeliveredRequest, rsp *completions.CompletionResponse, err error) {
+	if sc == nil || rsp == nil {
+		return
+	}
+	metrics.IncrementDeliveryFailures(rsp.Model, string(rsp.Status))
+	if rsp.Status == model.StatusSuccess && sc.errors != nil {
+		perf := rsp.Usage
+		entry := JournalEntry{
+			Time:        time.Now(),
+			Api:         req.Api,
+			Status:      model.StatusDeliveredFailed,
+			Model:       rsp.Model,
+			Language:    req.Language,
+			ClientID:    req.ClientID,
+			Phase:       PhaseDelivery,
+			QueueMs:     perf.QueueDuration,
+			ContextMs:   perf.ContextDuration,
+			LLMMs:       perf.LLMDuration,
+			TotalMs:     perf.TotalDuration,
+			Fingerprint: rsp.Fingerprint,
+		}
+		if err != nil {
+			entry.Error = truncateRunes(err.Error(), maxJournalError)
+		}
+		sc.errors.put(entry)
+	}
+	if req.ClientID != "" && req.CompletionID != "" {
+		completions.ForgetServed(req.ClientID, req.CompletionID)
+	}
+	// 已作废的补全不保留，重试时重新处理
+	if sc.dedup != nil && req.Route != "" && req.ClientID != "" && req.CompletionID != "" &&
+		!sc.invalidated(req.ClientID, req.CompletionID) {
+		sc.dedup.retain(dedupKey(req.ClientID, req.CompletionID), req.Route, rsp)
+	}
+}
@@ -0,0 +1,63 @@
+package stream_controller
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test an undelivered completion is journaled as deliveredFailed in the delivery phase and replayed to the reconnected client without calling the model
+// go test ./pkg/stream_controller/ -v -run Test_UndeliveredRedelivery
+func Test_UndeliveredRedelivery(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(time.Millisecond)()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "instant", MaxConcurrent: 1, MaxOutput: 50}, text: "two"}
+	m := NewPoolManager()
+	m.initPool("instant", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m,
+		dedup: newCompletionDedup(time.Minute), errors: newErrorJournal(10)}
+	const route = "/code-completion/api/v1/completions"
+	lost := errors.New("write: broken pipe")
+
+	rsp, served := sc.ProcessCompletionOnce(context.Background(), route, newDedupInput("client-gone", "L-gone"))
+	if served.Outcome != RouteServed || rsp.Status != model.StatusSuccess {
+		t.Fatalf("unexpected first response %+v %s", served, rsp.Status)
+	}
+	sc.Undelivered(UndeliveredRequest{Api: "v1", Route: served.ServedBy, ClientID: "client-gone",
+		CompletionID: "L-gone", Language: "javascript"}, rsp, lost)
+
+	journal := sc.errors.query(JournalFilter{Status: string(model.StatusDeliveredFailed)})
+	if journal.Total != 1 || journal.Entries[0].Phase != PhaseDelivery || journal.Entries[0].Error != lost.Error() {
+		t.Errorf("expected one deliveredFailed delivery entry, got %+v", journal.Entries)
+	}
+
+	retry, served := sc.ProcessCompletionOnce(context.Background(), route, newDedupInput("client-gone", "L-gone"))
+	if served.Outcome != RouteRedelivered || !sameResult(retry, rsp) || retry.Verbose != nil {
+		t.Errorf("expected the result redelivered, got %+v", served)
+	}
+	if calls := atomic.LoadInt32(&llm.calls); calls != 1 {
+		t.Errorf("expected one upstream call, got %d", calls)
+	}
+
+	// 去重记录已过期时重新保留
+	sc.dedup.entries.Delete("client-gone\x00L-gone")
+	sc.Undelivered(UndeliveredRequest{Api: "v1", Route: route, ClientID: "client-gone", CompletionID: "L-gone"}, retry, lost)
+	if _, served = sc.ProcessCompletionOnce(context.Background(), route, newDedupInput("client-gone", "L-gone")); served.Outcome != RouteRedelivered {
+		t.Errorf("expected the expired result retained, got %+v", served)
+	}
+
+	// 失败的补全已按原状态记录，不再重复记录，也不保留
+	busy := newJournalResponse("instant", model.StatusBusy, "model pool busy", rsp.Usage)
+	sc.Undelivered(UndeliveredRequest{Api: "v1", Route: route, ClientID: "client-gone", CompletionID: "L-busy"}, busy, lost)
+	if total := sc.errors.query(JournalFilter{}).Total; total != 2 {
+		t.Errorf("expected no entry for the failed completion, got %d entries", total)
+	}
+	if _, ok := sc.dedup.entries.Get("client-gone\x00L-busy"); ok {
+		t.Error("expected the busy result not retained")
+	}
+}
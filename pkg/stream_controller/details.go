@@ -0,0 +1,77 @@
+package stream_controller
+
+import (
+	"sort"
+	"time"
+)
+
+//
+//	详细信息: 等待队列和模型池的明细，列表按固定顺序输出，相同状态的两次快照可以直接对比
+//
+
+// 详细信息的字段选择
+const (
+	DetailsSummary = "summary" // 请求只输出摘要(GetSummary)，模型池不输出正在处理的请求
+	DetailsFull    = "full"    // 请求输出明细(GetDetails)，默认
+)
+
+/**
+ * 查询详细信息的条件
+ * @description
+ * - Fields: 字段选择，见DetailsSummary/DetailsFull，为空时按DetailsFull
+ * - Offset/Limit: 等待队列的客户端和请求列表各自的分页，Limit为0表示不限制；模型池数量少，不分页
+ */
+type DetailsFilter struct {
+	Fields string
+	Offset int
+	Limit  int
+}
+
+func (f DetailsFilter) full() bool {
+	return f.Fields != DetailsSummary
+}
+
+// 按分页条件截取已排序的列表，超出范围时返回空列表
+func paginate[T any](items []T, filter DetailsFilter) []T {
+	start := min(filter.Offset, len(items))
+	end := len(items)
+	if filter.Limit > 0 {
+		end = min(start+filter.Limit, end)
+	}
+	return items[start:end]
+}
+
+// 请求的接收时间，没有性能统计时为零值
+func receiveTimeOf(r *ClientRequest) time.Time {
+	if r.Perf == nil {
+		return time.Time{}
+	}
+	return r.Perf.ReceiveTime
+}
+
+// 按接收时间从早到晚排序请求，相同时按客户端和补全ID排序
+func sortRequests(requests []*ClientRequest) {
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if ta, tb := receiveTimeOf(a), receiveTimeOf(b); !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+		if a.Para.ClientID != b.Para.ClientID {
+			return a.Para.ClientID < b.Para.ClientID
+		}
+		return a.Para.CompletionID < b.Para.CompletionID
+	})
+}
+
+// 按字段选择输出请求
+func requestDetails(requests []*ClientRequest, filter DetailsFilter) []map[string]interface{} {
+	details := make([]map[string]interface{}, 0, len(requests))
+	for _, req := range requests {
+		if filter.full() {
+			details = append(details, req.GetDetails())
+		} else {
+			details = append(details, req.GetSummary())
+		}
+	}
+	return details
+}
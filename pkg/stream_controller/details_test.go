@@ -0,0 +1,141 @@
+package stream_controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 创建有3个模型池和5个客户端请求的流控，请求的接收时间与客户端编号相反
+func newDetailsController() *StreamController {
+	m := NewPoolManager()
+	for _, name := range []string{"m2", "m1", "m3"} {
+		llm := &instantLLM{cfg: config.ModelConfig{ModelName: name, MaxConcurrent: 1, MaxOutput: 50}}
+		pool := m.initPool(name, llm, llm.Config())
+		pool.mutex.Lock()
+		for i := 0; i < 3; i++ {
+			id := fmt.Sprintf("%s-%d", name, i)
+			pool.runnings[id] = &ClientRequest{
+				Para: &model.CompletionParameter{CompletionID: id, ClientID: "client-pool"},
+				Perf: &completions.CompletionPerformance{ReceiveTime: time.Unix(int64(100-i), 0)},
+			}
+		}
+		pool.mutex.Unlock()
+	}
+	sc := &StreamController{queues: NewQueueManager(), pools: m}
+	for i := 0; i < 5; i++ {
+		para := &model.CompletionParameter{CompletionID: fmt.Sprintf("C%d", i), ClientID: fmt.Sprintf("client-%d", i)}
+		sc.queues.AddRequest(context.Background(), para, &completions.CompletionPerformance{ReceiveTime: time.Unix(int64(50-i), 0)})
+	}
+	return sc
+}
+
+// 明细中除time和seq以外的内容
+func detailsBody(t *testing.T, details map[string]interface{}) string {
+	body := map[string]interface{}{}
+	for k, v := range details {
+		if k != "time" && k != "seq" {
+			body[k] = v
+		}
+	}
+	out, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// 列表中各项的某个字段
+func fieldsOf(list interface{}, key string) []string {
+	var values []string
+	for _, item := range list.([]map[string]interface{}) {
+		values = append(values, fmt.Sprint(item[key]))
+	}
+	return values
+}
+
+// to test the details are identical across repeated calls with the same state, ordered, and stamped with a sequence
+// go test ./pkg/stream_controller/ -v -run Test_DetailsOrdering
+func Test_DetailsOrdering(t *testing.T) {
+	defer setupSchedulerConfig(0, 0)()
+	sc := newDetailsController()
+
+	first := sc.GetDetails(DetailsFilter{})
+	for i := 0; i < 20; i++ {
+		details := sc.GetDetails(DetailsFilter{})
+		if got, want := detailsBody(t, details), detailsBody(t, first); got != want {
+			t.Fatalf("details changed without state change:\nwant %s\ngot  %s", want, got)
+		}
+		if details["seq"].(uint64) != first["seq"].(uint64)+uint64(i)+1 {
+			t.Errorf("expected seq %d, got %v", first["seq"].(uint64)+uint64(i)+1, details["seq"])
+		}
+	}
+
+	pools := first["pools"].(map[string]interface{})["pools"].([]map[string]interface{})
+	if names := fmt.Sprint(fieldsOf(pools, "name")); names != "[m1 m2 m3]" {
+		t.Errorf("pools should be sorted by model name, got %s", names)
+	}
+	runnings := pools[0]["requests"].(map[string]interface{})["runnings"]
+	if ids := fmt.Sprint(fieldsOf(runnings, "completion_id")); ids != "[m1-2 m1-1 m1-0]" {
+		t.Errorf("running requests should be sorted by receive time, got %s", ids)
+	}
+	queues := first["queues"].(map[string]interface{})
+	clients := queues["clients"].(map[string]interface{})["details"]
+	if ids := fmt.Sprint(fieldsOf(clients, "client_id")); ids != "[client-0 client-1 client-2 client-3 client-4]" {
+		t.Errorf("clients should be sorted by last activity descending, got %s", ids)
+	}
+	requests := queues["requests"].(map[string]interface{})["details"]
+	if ids := fmt.Sprint(fieldsOf(requests, "completion_id")); ids != "[C4 C3 C2 C1 C0]" {
+		t.Errorf("requests should be sorted by receive time, got %s", ids)
+	}
+
+	summary := sc.GetDetails(DetailsFilter{Fields: DetailsSummary})
+	pools = summary["pools"].(map[string]interface{})["pools"].([]map[string]interface{})
+	if _, ok := pools[0]["requests"].(map[string]interface{})["runnings"]; ok {
+		t.Errorf("summary should not list running requests")
+	}
+	requests = summary["queues"].(map[string]interface{})["requests"].(map[string]interface{})["details"]
+	if _, ok := requests.([]map[string]interface{})[0]["prompt"].(map[string]interface{}); ok {
+		t.Errorf("summary should list request summaries only")
+	}
+}
+
+// to test the pagination boundaries of the clients and requests
+// go test ./pkg/stream_controller/ -v -run Test_DetailsPagination
+func Test_DetailsPagination(t *testing.T) {
+	defer setupSchedulerConfig(0, 0)()
+	sc := newDetailsController()
+
+	tests := []struct {
+		offset, limit int
+		clients       string
+		requests      string
+	}{
+		{0, 2, "[client-0 client-1]", "[C4 C3]"},
+		{2, 2, "[client-2 client-3]", "[C2 C1]"},
+		{4, 2, "[client-4]", "[C0]"},
+		{5, 2, "[]", "[]"},
+		{9, 0, "[]", "[]"},
+		{3, 0, "[client-3 client-4]", "[C1 C0]"},
+	}
+	for _, tt := range tests {
+		queues := sc.GetDetails(DetailsFilter{Offset: tt.offset, Limit: tt.limit})["queues"].(map[string]interface{})
+		clients := queues["clients"].(map[string]interface{})
+		requests := queues["requests"].(map[string]interface{})
+		if got := fmt.Sprint(fieldsOf(clients["details"], "client_id")); got != tt.clients {
+			t.Errorf("offset %d limit %d: expected clients %s, got %s", tt.offset, tt.limit, tt.clients, got)
+		}
+		if got := fmt.Sprint(fieldsOf(requests["details"], "completion_id")); got != tt.requests {
+			t.Errorf("offset %d limit %d: expected requests %s, got %s", tt.offset, tt.limit, tt.requests, got)
+		}
+		if clients["total"] != 5 || requests["total"] != 5 {
+			t.Errorf("totals should count all entries, got %v and %v", clients["total"], requests["total"])
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//
+//	崩溃诊断: 进程崩溃前输出正在处理的请求等状态，便于事后分析
+//	构建快照时只读取原子量和已上报的指标值，模型池和队列的锁只尝试获取，获取不到时跳过
+//
+
+// 快照中保留的panic调用栈的最大字节数
+const maxDiagnosticStack = 8 * 1024
+
+// 进程的内存统计
+type MemorySnapshot struct {
+	Alloc       uint64 `json:"alloc"`
+	HeapInuse   uint64 `json:"heapInuse"`
+	HeapObjects uint64 `json:"heapObjects"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"numGC"`
+}
+
+// 等待队列的状态，锁被占用时Busy为true，其它字段无效
+type QueueSnapshot struct {
+	Busy     bool `json:"busy,omitempty"`
+	Requests int  `json:"requests"`
+}
+
+/**
+ * 一个模型池的状态
+ * @description
+ * - Instance: 模型池的标识，见ModelPool.instance
+ * - Busy: 模型池的锁被占用，没有读取并发数(调整并发数时在锁内修改)和正在处理的请求
+ * - Requests: 正在处理的请求摘要(ClientRequest.GetSummary，不含代码)
+ * - Omitted: 因快照大小限制被省略的请求摘要数
+ */
+type PoolSnapshot struct {
+	Name          string                   `json:"name"`
+	Instance      string                   `json:"instance"`
+	MaxConcurrent int                      `json:"maxConcurrent"`
+	Busy          bool                     `json:"busy,omitempty"`
+	Running       int                      `json:"running"`
+	Waiting       int                      `json:"waiting"`
+	Requests      []map[string]interface{} `json:"requests,omitempty"`
+	Omitted       int                      `json:"omitted,omitempty"`
+}
+
+/**
+ * 诊断快照
+ * @description
+ * - Reason: 生成快照的原因，如signal:quit、panic、admin
+ * - Panic/Stack: panic的值和调用栈，只有panic时才有
+ * - Stores: 各内存存储最近一次上报的大小
+ * - Truncated: 超过大小限制，省略了部分明细
+ */
+type DiagnosticSnapshot struct {
+	Time       time.Time                    `json:"time"`
+	Reason     string                       `json:"reason"`
+	Panic      string                       `json:"panic,omitempty"`
+	Stack      string                       `json:"stack,omitempty"`
+	Goroutines int                          `json:"goroutines"`
+	Memory     MemorySnapshot               `json:"memory"`
+	Queue      *QueueSnapshot               `json:"queue,omitempty"`
+	Pools      []PoolSnapshot               `json:"pools"`
+	Stores     map[string]metrics.StoreSize `json:"stores,omitempty"`
+	Truncated  bool                         `json:"truncated,omitempty"`
+}
+
+// 读取模型池的状态，锁被占用时只返回模型池的名称和标识
+func (pool *ModelPool) snapshot() PoolSnapshot {
+	s := PoolSnapshot{Name: pool.cfg.ModelName, Instance: pool.instance}
+	if !pool.mutex.TryRLock() {
+		s.Busy = true
+		return s
+	}
+	defer pool.mutex.RUnlock()
+	s.MaxConcurrent = pool.cfg.MaxConcurrent
+	s.Running = len(pool.runnings)
+	s.Waiting = pool.waits.Len()
+	for _, req := range pool.runnings {
+		s.Requests = append(s.Requests, req.GetSummary())
+	}
+	return s
+}
+
+// 读取等待队列的状态，锁被占用时标记为Busy
+func (m *QueueManager) snapshot() *QueueSnapshot {
+	if !m.mutex.TryRLock() {
+		return &QueueSnapshot{Busy: true}
+	}
+	defer m.mutex.RUnlock()
+	return &QueueSnapshot{Requests: len(m.requests)}
+}
+
+/**
+ * 生成诊断快照
+ * @param {string} reason - 生成快照的原因
+ * @returns {*DiagnosticSnapshot} 返回快照
+ * @description
+ * - sc为nil(流控还没有初始化)时只有进程级的信息
+ * - 不阻塞等待任何锁，可以在崩溃时和其它请求并发调用
+ */
+func (sc *StreamController) Snapshot(reason string) *DiagnosticSnapshot {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s := &DiagnosticSnapshot{
+		Time:       time.Now(),
+		Reason:     reason,
+		Goroutines: runtime.NumGoroutine(),
+		Memory: MemorySnapshot{
+			Alloc:       mem.Alloc,
+			HeapInuse:   mem.HeapInuse,
+			HeapObjects: mem.HeapObjects,
+			Sys:         mem.Sys,
+			NumGC:       mem.NumGC,
+		},
+		Pools:  []PoolSnapshot{},
+		Stores: metrics.StoreSizes(),
+	}
+	if sc == nil {
+		return s
+	}
+	s.Queue = sc.queues.snapshot()
+	for _, pool := range sc.pools.allPools() {
+		s.Pools = append(s.Pools, pool.snapshot())
+	}
+	return s
+}
+
+/**
+ * 按大小限制序列化快照
+ * @param {int} maxBytes - 最大字节数，不大于0时不限制
+ * @returns {[]byte} 返回JSON，不超过maxBytes
+ * @description
+ * - 超过限制时依次省略：请求摘要、调用栈、内存存储大小、模型池明细
+ * - 仍然超过时只保留时间、原因、协程数和内存统计，再超过时只输出{"truncated":true}
+ */
+func (s *DiagnosticSnapshot) Marshal(maxBytes int) []byte {
+	reducers := []func(){
+		func() {
+			for i := range s.Pools {
+				s.Pools[i].Omitted += len(s.Pools[i].Requests)
+				s.Pools[i].Requests = nil
+			}
+		},
+		func() { s.Stack = "" },
+		func() { s.Stores = nil },
+		func() { s.Pools = nil },
+		func() { s.Panic, s.Queue = "", nil },
+	}
+	data, _ := json.Marshal(s)
+	for _, reduce := range reducers {
+		if maxBytes <= 0 || len(data) <= maxBytes {
+			return data
+		}
+		reduce()
+		s.Truncated = true
+		data, _ = json.Marshal(s)
+	}
+	if len(data) > maxBytes {
+		data = []byte(`{"truncated":true}`)
+	}
+	return data
+}
+
+// 正在输出崩溃快照，崩溃过程中再次触发时不重复输出
+var dumping atomic.Bool
+
+/**
+ * 输出崩溃诊断快照
+ * @param {io.Writer} w - 输出位置，崩溃时为stderr
+ * @param {string} reason - 崩溃原因
+ * @param {interface{}} panicValue - panic的值，不是panic时为nil
+ * @returns {bool} 输出了快照时返回true
+ * @description
+ * - 同步输出，一次崩溃只输出一次；生成快照时再次panic则放弃
+ */
+func dumpCrash(w io.Writer, reason string, panicValue interface{}) (ok bool) {
+	cfg := &config.Config.StreamController.Diagnostics
+	if cfg.Disabled || !dumping.CompareAndSwap(false, true) {
+		return false
+	}
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	s := Controller.Snapshot(reason)
+	if panicValue != nil {
+		s.Panic = fmt.Sprint(panicValue)
+		stack := debug.Stack()
+		if len(stack) > maxDiagnosticStack {
+			stack = stack[:maxDiagnosticStack]
+		}
+		s.Stack = string(stack)
+	}
+	w.Write(append(s.Marshal(cfg.MaxBytes), '\n'))
+	return true
+}
+
+/**
+ * panic到达协程顶层时输出崩溃快照，再继续panic
+ * @description
+ * - 在main和后台协程的入口用defer调用
+ * @example
+ * go func() {
+ *     defer stream_controller.DumpOnPanic()
+ *     ...
+ * }()
+ */
+func DumpOnPanic() {
+	if r := recover(); r != nil {
+		dumpCrash(os.Stderr, "panic", r)
+		panic(r)
+	}
+}
+
+/**
+ * 收到SIGQUIT/SIGABRT时输出崩溃快照
+ * @description
+ * - 输出后恢复信号的默认处理并重新发送该信号，进程按原来的方式退出(SIGQUIT仍会打印协程栈)
+ * - OOM时的SIGKILL无法捕获，需要依靠定时维护日志
+ */
+func WatchFatalSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGQUIT, syscall.SIGABRT)
+	go func() {
+		sig := <-ch
+		dumpCrash(os.Stderr, "signal:"+sig.String(), nil)
+		signal.Reset(sig)
+		if p, err := os.FindProcess(os.Getpid()); err != nil || p.Signal(sig) != nil {
+			os.Exit(2)
+		}
+	}()
+}
@@ -0,0 +1,134 @@
+package stream_controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 创建带有n个正在处理请求的流控，请求的提示词中带有不应出现在快照中的代码
+func newDiagnosticController(t *testing.T, n int) (*StreamController, *ModelPool) {
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 1, MaxOutput: 50}}
+	m := NewPoolManager()
+	pool := m.initPool("fake", llm, llm.Config())
+	// 工作协程启动时会短暂持有模型池的锁，等启动完成再取快照
+	waitWorkers(t, pool, 1)
+	pool.mutex.Lock()
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("diag-%d", i)
+		pool.runnings[id] = &ClientRequest{Para: &model.CompletionParameter{
+			CompletionID: id,
+			ClientID:     "client-diag",
+			Prefix:       "const secret = ",
+		}}
+	}
+	pool.mutex.Unlock()
+	return &StreamController{queues: NewQueueManager(), pools: m}, pool
+}
+
+// to test the diagnostic snapshot schema, the exclusion of code and the lock-free read of busy pools
+// go test ./pkg/stream_controller/ -v -run Test_DiagnosticSnapshotSchema
+func Test_DiagnosticSnapshotSchema(t *testing.T) {
+	sc, pool := newDiagnosticController(t, 3)
+	data := sc.Snapshot("admin").Marshal(0)
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid snapshot json: %v", err)
+	}
+	for _, key := range []string{"time", "reason", "goroutines", "memory", "queue", "pools"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("expected %q in snapshot, got %s", key, data)
+		}
+	}
+	var snapshot DiagnosticSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Pools) != 1 || snapshot.Pools[0].Running != 3 || len(snapshot.Pools[0].Requests) != 3 ||
+		snapshot.Pools[0].MaxConcurrent != 1 {
+		t.Errorf("expected 3 running request summaries, got %+v", snapshot.Pools)
+	}
+	if snapshot.Goroutines <= 0 || snapshot.Memory.Sys == 0 || snapshot.Truncated {
+		t.Errorf("unexpected process stats %+v", snapshot)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("expected no code in snapshot, got %s", data)
+	}
+
+	// 模型池的锁被占用时不等待
+	pool.mutex.Lock()
+	busy := sc.Snapshot("admin")
+	pool.mutex.Unlock()
+	if !busy.Pools[0].Busy || busy.Pools[0].Requests != nil || busy.Pools[0].MaxConcurrent != 0 {
+		t.Errorf("expected the locked pool marked busy, got %+v", busy.Pools[0])
+	}
+}
+
+// to test taking snapshots while the pool is resized, run with -race
+// go test ./pkg/stream_controller/ -race -v -run Test_DiagnosticSnapshotResize
+func Test_DiagnosticSnapshotResize(t *testing.T) {
+	sc, pool := newDiagnosticController(t, 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sc.pools.resizeMutex.Lock()
+			sc.pools.resizePool(pool, 1+i%2)
+			sc.pools.resizeMutex.Unlock()
+			runtime.Gosched()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if s := pool.snapshot(); !s.Busy && (s.MaxConcurrent < 1 || s.MaxConcurrent > 2) {
+			t.Fatalf("unexpected max concurrent %d", s.MaxConcurrent)
+		}
+		runtime.Gosched()
+	}
+	<-done
+}
+
+// to test the snapshot size bound and the single crash dump
+// go test ./pkg/stream_controller/ -v -run Test_DiagnosticSnapshotBound
+func Test_DiagnosticSnapshotBound(t *testing.T) {
+	sc, _ := newDiagnosticController(t, 500)
+	for _, maxBytes := range []int{4096, 1024, 200, 18} {
+		data := sc.Snapshot("admin").Marshal(maxBytes)
+		if len(data) > maxBytes {
+			t.Errorf("expected at most %d bytes, got %d", maxBytes, len(data))
+		}
+		var snapshot DiagnosticSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil || !snapshot.Truncated {
+			t.Errorf("expected a valid truncated snapshot within %d bytes, got %s (%v)", maxBytes, data, err)
+		}
+	}
+	var snapshot DiagnosticSnapshot
+	json.Unmarshal(sc.Snapshot("admin").Marshal(4096), &snapshot)
+	if len(snapshot.Pools) != 1 || snapshot.Pools[0].Omitted != 500 || snapshot.Pools[0].Running != 500 {
+		t.Errorf("expected request summaries omitted but counts kept, got %+v", snapshot.Pools)
+	}
+
+	saved, savedController := config.Config.StreamController.Diagnostics, Controller
+	defer func() {
+		config.Config.StreamController.Diagnostics, Controller = saved, savedController
+		dumping.Store(false)
+	}()
+	config.Config.StreamController.Diagnostics.MaxBytes = 2048
+	Controller = sc
+	var out bytes.Buffer
+	if !dumpCrash(&out, "panic", "boom") || out.Len() > 2048+1 {
+		t.Fatalf("expected one bounded crash dump, got %d bytes", out.Len())
+	}
+	if dumpCrash(&out, "panic", "again") {
+		t.Errorf("expected a nested crash not to dump again")
+	}
+	json.Unmarshal(bytes.TrimSpace(out.Bytes()), &snapshot)
+	if snapshot.Reason != "panic" || snapshot.Panic != "boom" {
+		t.Errorf("unexpected crash dump %s", out.String())
+	}
+}
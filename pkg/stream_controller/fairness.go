@@ -0,0 +1,233 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/config"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//
+//	公平调度: 模型池饱和时，按权重在租户之间分配调度份额，租户内的客户端轮流调度
+//
+
+// 没有租户标识或租户未配置时归入的租户
+const DefaultTenant = "default"
+
+// 虚拟时间的记录数达到该值后开始清理
+const fairSweepMin = 64
+
+/**
+ * 按请求的凭据确定租户
+ * @param {http.Header} h - 补全请求的请求头
+ * @returns {string} 返回凭据所属的租户，没有凭据或凭据不属于任何配置的租户时返回空
+ * @description
+ * - 只认Authorization头中的令牌(见config.TenantConfig.Tokens)，客户端自己填写的请求头不能冒充其它租户
+ * - 按租户名称顺序查找，同一令牌配置在多个租户下时取名称最小的租户
+ * - 未启用公平调度时调度仍归入default(见resolveTenant)，token用量按该租户累计(见usageLedger)
+ */
+func TenantOf(h http.Header) string {
+	if h == nil {
+		return ""
+	}
+	token, ok := strings.CutPrefix(h.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	tenants := config.Config.StreamController.Fairness.Tenants
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, want := range tenants[name].Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// 请求调度时所属的租户，未启用、没有租户标识或租户未配置时为default
+func resolveTenant(cfg *config.FairnessConfig, tenant string) string {
+	if !cfg.Enabled || tenant == "" {
+		return DefaultTenant
+	}
+	if _, ok := cfg.Tenants[tenant]; !ok {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+// 租户的调度权重
+func tenantWeight(cfg *config.FairnessConfig, tenant string) float64 {
+	if t, ok := cfg.Tenants[tenant]; ok && t.Weight > 0 {
+		return t.Weight
+	}
+	if cfg.DefaultWeight > 0 {
+		return cfg.DefaultWeight
+	}
+	return 1
+}
+
+/**
+ * 按虚拟时间分配份额(stride调度)
+ * @description
+ * - 每调度一次，对象的虚拟时间增加1/权重，总是调度虚拟时间最小的对象
+ * - vtime为最近一次调度的对象在调度前的虚拟时间，单调不减
+ * - 没有等待请求的对象虚拟时间不增加，重新有请求时按max(自己的虚拟时间, vtime)计，不能积攒份额
+ * - 虚拟时间不超过vtime的记录与没有记录等价，记录数增长后清理
+ */
+type fairShare struct {
+	passes  map[string]float64
+	vtime   float64
+	sweepAt int
+}
+
+func newFairShare() *fairShare {
+	return &fairShare{passes: make(map[string]float64), sweepAt: fairSweepMin}
+}
+
+// 对象当前的虚拟时间
+func (s *fairShare) pass(key string) float64 {
+	return max(s.passes[key], s.vtime)
+}
+
+// 记录对象的一次调度
+func (s *fairShare) charge(key string, weight float64) {
+	p := s.pass(key)
+	s.vtime = p
+	s.passes[key] = p + 1/weight
+	if len(s.passes) < s.sweepAt {
+		return
+	}
+	for k, v := range s.passes {
+		if v <= s.vtime {
+			delete(s.passes, k)
+		}
+	}
+	s.sweepAt = max(fairSweepMin, 2*len(s.passes))
+}
+
+/**
+ * 等待队列的公平调度器，调用者需持有waitQueue.mutex
+ * @description
+ * - 租户之间按权重分配份额，租户内的客户端权重相同，即轮流调度
+ * - 只在有等待请求的租户和客户端之间选择，空闲租户的份额全部由其它租户使用
+ * - 未启用时选择第一个符合条件的请求，与原有的先到先得一致，但仍按租户统计调度数
+ */
+type fairScheduler struct {
+	cfg        *config.FairnessConfig
+	tenants    *fairShare
+	clients    map[string]*fairShare // 各租户内客户端的虚拟时间
+	dispatched map[string]int64      // 各租户已调度的请求数
+}
+
+func newFairScheduler(cfg *config.FairnessConfig) *fairScheduler {
+	return &fairScheduler{
+		cfg:        cfg,
+		tenants:    newFairShare(),
+		clients:    make(map[string]*fairShare),
+		dispatched: make(map[string]int64),
+	}
+}
+
+func (f *fairScheduler) clientShare(tenant string) *fairShare {
+	s, ok := f.clients[tenant]
+	if !ok {
+		s = newFairShare()
+		f.clients[tenant] = s
+	}
+	return s
+}
+
+/**
+ * Select the next request among the eligible ones
+ * @param {[]*ClientRequest} items - Waiting requests in arrival order
+ * @param {func(*ClientRequest) bool} eligible - Whether a request may be taken by the slot
+ * @returns {int} Returns the index of the selected request, -1 if none is eligible
+ * @description
+ * - Picks the tenant with the smallest virtual time, then its client with the smallest virtual time
+ * - Ties are broken by arrival order
+ */
+func (f *fairScheduler) pick(items []*ClientRequest, eligible func(*ClientRequest) bool) int {
+	best := -1
+	if !f.cfg.Enabled {
+		for i, req := range items {
+			if eligible(req) {
+				return i
+			}
+		}
+		return best
+	}
+	tenant := ""
+	for i, req := range items {
+		if eligible(req) && (best < 0 || f.tenants.pass(req.tenant()) < f.tenants.pass(tenant)) {
+			best, tenant = i, req.tenant()
+		}
+	}
+	if best < 0 {
+		return best
+	}
+	clients := f.clientShare(tenant)
+	for i := best + 1; i < len(items); i++ {
+		req := items[i]
+		if eligible(req) && req.tenant() == tenant &&
+			clients.pass(req.Para.ClientID) < clients.pass(items[best].Para.ClientID) {
+			best = i
+		}
+	}
+	return best
+}
+
+// 记录请求被调度，包括等待过久不经公平选择直接调度的请求；探针请求不计入租户统计和公平份额
+func (f *fairScheduler) charge(req *ClientRequest) {
+	if req.probe() {
+		return
+	}
+	tenant := req.tenant()
+	f.dispatched[tenant]++
+	if !f.cfg.Enabled {
+		return
+	}
+	f.tenants.charge(tenant, tenantWeight(f.cfg, tenant))
+	f.clientShare(tenant).charge(req.Para.ClientID, 1)
+}
+
+// 参与调度的租户：配置的租户和default
+func (f *fairScheduler) knownTenants() []string {
+	tenants := []string{DefaultTenant}
+	if !f.cfg.Enabled {
+		return tenants
+	}
+	for tenant := range f.cfg.Tenants {
+		if tenant != DefaultTenant {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+/**
+ * 计算租户的饥饿上界
+ * @param {string} tenant - 租户
+ * @returns {int} 返回租户持续有同一规模的等待请求时，两次调度之间最多调度的其它租户的请求数
+ * @description
+ * - 上界为其它每个租户j的floor(w_j/w)+1之和，w为该租户的权重
+ * - 等待超过priorityAging的请求和更小规模的请求优先调度，不计入该上界
+ * - 租户内有n个客户端有等待请求时，一个客户端最多等待租户的n-1次调度
+ */
+func (f *fairScheduler) starvationBound(tenant string) int {
+	weight := tenantWeight(f.cfg, tenant)
+	bound := 0
+	for _, other := range f.knownTenants() {
+		if other != tenant {
+			bound += int(tenantWeight(f.cfg, other)/weight) + 1
+		}
+	}
+	return bound
+}
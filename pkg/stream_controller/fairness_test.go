@@ -0,0 +1,157 @@
+package stream_controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+func setupFairness(weights map[string]float64) func() {
+	restore := setupSchedulerConfig(0, 0)
+	tenants := make(map[string]config.TenantConfig)
+	for tenant, weight := range weights {
+		tenants[tenant] = config.TenantConfig{Weight: weight}
+	}
+	config.Config.StreamController.Fairness = config.FairnessConfig{
+		Enabled: true,
+		Tenants: tenants,
+	}
+	return restore
+}
+
+/**
+ * 模拟饱和的模型池: 每个客户端始终有一个等待的请求(新请求取代旧请求)，调度一个就补充一个
+ * @returns {map[string]int} 返回各租户的调度数
+ * @returns {map[string]int} 返回各客户端的调度数
+ */
+func simulateSaturated(t *testing.T, clients map[string]int, dispatches int) (map[string]int, map[string]int) {
+	q := newWaitQueue(1000, 0)
+	seq := 0
+	offer := func(tenant, client string) {
+		seq++
+		req := newTestRequest(fmt.Sprintf("%s-%d", client, seq), 20)
+		req.Para.ClientID = client
+		req.Tenant = resolveTenant(&config.Config.StreamController.Fairness, tenant)
+		q.Push(req)
+	}
+	for tenant, n := range clients {
+		for i := 0; i < n; i++ {
+			offer(tenant, fmt.Sprintf("%s/%d", tenant, i))
+		}
+	}
+	tenants := make(map[string]int)
+	perClient := make(map[string]int)
+	// 租户持续有等待请求时，两次调度之间调度的其它租户的请求数
+	gaps := make(map[string]int)
+	for i := 0; i < dispatches; i++ {
+		req := q.Pop(false)
+		req.cancel()
+		tenants[req.Tenant]++
+		perClient[req.Para.ClientID]++
+		for tenant := range clients {
+			gaps[tenant]++
+		}
+		gaps[req.Tenant] = 0
+		for tenant := range clients {
+			if bound := q.fair.starvationBound(tenant); gaps[tenant] > bound {
+				t.Fatalf("tenant %s waited %d dispatches, bound %d", tenant, gaps[tenant], bound)
+			}
+		}
+		offer(req.Tenant, req.Para.ClientID)
+	}
+	return tenants, perClient
+}
+
+// to test the dispatch shares of tenants with skewed load under saturation
+// go test ./pkg/stream_controller/ -v -run Test_FairSchedulingShares
+func Test_FairSchedulingShares(t *testing.T) {
+	defer setupFairness(map[string]float64{"a": 1, "b": 1, "c": 2})()
+
+	// a的客户端数是b的10倍，按等待请求数先到先得时a占大部分
+	const dispatches = 4000
+	tenants, perClient := simulateSaturated(t, map[string]int{"a": 20, "b": 2, "c": 5}, dispatches)
+	for tenant, share := range map[string]float64{"a": 0.25, "b": 0.25, "c": 0.5} {
+		got := float64(tenants[tenant]) / dispatches
+		if math.Abs(got-share) > 0.02 {
+			t.Errorf("tenant %s: expected share %.2f, got %.3f", tenant, share, got)
+		}
+	}
+	// 租户内的客户端轮流调度
+	for i := 1; i < 20; i++ {
+		if d := perClient[fmt.Sprintf("a/%d", i)] - perClient["a/0"]; d < -1 || d > 1 {
+			t.Errorf("expected round-robin within tenant a, got %v", perClient)
+			break
+		}
+	}
+
+	// 未配置的租户归入default
+	if resolveTenant(&config.Config.StreamController.Fairness, "unknown") != DefaultTenant {
+		t.Error("expected an unknown tenant resolved to default")
+	}
+
+	// 租户按凭据确定，不信任客户端填写的租户请求头
+	config.Config.StreamController.Fairness.Tenants["c"] = config.TenantConfig{Weight: 1, Tokens: []string{"c-key"}}
+	h := http.Header{}
+	h.Set("X-Tenant-ID", "a")
+	if tenant := TenantOf(h); tenant != "" {
+		t.Errorf("expected the tenant header ignored, got %q", tenant)
+	}
+	h.Set("Authorization", "Bearer c-key")
+	if tenant := TenantOf(h); tenant != "c" {
+		t.Errorf("expected the tenant from the credential, got %q", tenant)
+	}
+	h.Set("Authorization", "Bearer unknown-key")
+	if tenant := TenantOf(h); tenant != "" {
+		t.Errorf("expected an unknown credential in no tenant, got %q", tenant)
+	}
+}
+
+// to test that an idle tenant's share is used by the others
+// go test ./pkg/stream_controller/ -v -run Test_FairSchedulingIdleTenant
+func Test_FairSchedulingIdleTenant(t *testing.T) {
+	defer setupFairness(map[string]float64{"a": 1, "b": 1, "c": 2})()
+
+	// c空闲，a和b平分全部调度
+	const dispatches = 1000
+	tenants, _ := simulateSaturated(t, map[string]int{"a": 6, "b": 1}, dispatches)
+	if tenants["a"]+tenants["b"] != dispatches || math.Abs(float64(tenants["a"]-tenants["b"])) > 2 {
+		t.Errorf("expected a and b to share all dispatches evenly, got %v", tenants)
+	}
+
+	// 只有a有请求时，a占用模型池的所有并发槽位
+	llm := newFakeLLM(4)
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		req := newTestRequest(fmt.Sprintf("L%d", i), 20)
+		req.Tenant = "a"
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer req.cancel()
+			if rsp := m.WaitDoRequest(req); rsp.Status != model.StatusSuccess {
+				t.Errorf("request %s failed: %s", req.Para.CompletionID, rsp.Status)
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-llm.started:
+		case <-time.After(time.Second):
+			t.Fatalf("expected all 4 slots used by tenant a, %d started", i)
+		}
+	}
+	close(llm.release)
+	wg.Wait()
+	stats := m.GetStats()["pools"].([]map[string]interface{})[0]["tenants"].(map[string]interface{})
+	if a, ok := stats["a"].(map[string]interface{}); !ok || a["dispatched"] != int64(4) || a["starvation_bound"] != 7 {
+		t.Errorf("expected 4 dispatches of tenant a in stats, got %v", stats)
+	}
+}
@@ -0,0 +1,98 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/store"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 作废补全的结果
+const (
+	InvalidateCanceled  = "canceled"  // 请求在途，已取消
+	InvalidateDiscarded = "discarded" // 请求已处理完，结果不再重放，也不用于采纳统计
+	InvalidateUnknown   = "unknown"   // 没有该请求(未收到、已超过去重窗口或客户端ID不符)
+)
+
+// 一次补全请求中最多处理的作废completion_id数，超出的忽略
+const maxPiggybackInvalidations = 16
+
+// 作废记录最多保存的条数
+const maxInvalidations = 100000
+
+// 作废记录，key为去重键，值为作废的结果；保存时长与去重窗口相同
+func newInvalidations(window time.Duration) *store.Store[string, string] {
+	return store.New(store.Options[string, string]{
+		Name:       "completion_invalidations",
+		MaxEntries: maxInvalidations,
+		TTL:        window,
+		Metadata:   true,
+	})
+}
+
+// 补全是否已被作废
+func (sc *StreamController) invalidated(clientID, completionID string) bool {
+	if sc.invalid == nil {
+		return false
+	}
+	_, ok := sc.invalid.Get(dedupKey(clientID, completionID))
+	return ok
+}
+
+/**
+ * 作废光标移动或文档编辑前发出的补全
+ * @param {string} clientID - 客户端ID
+ * @param {string} completionID - 作废的补全的completion_id
+ * @returns {string} 返回作废的结果，见Invalidate*
+ * @description
+ * - 在途的请求通过取消机制取消，原因记为cursor_moved；还在获取上下文的请求在排队时取消
+ * - 已处理完的请求删除去重记录和最近返回的补全记录，其结果的锚点已过时
+ * - 重复作废返回第一次的结果；没有该请求时不保存记录
+ * - 只查找内存中的记录，不调用模型或检索服务
+ */
+func (sc *StreamController) Invalidate(clientID, completionID string) string {
+	if clientID == "" || completionID == "" || sc.invalid == nil {
+		return InvalidateUnknown
+	}
+	key := dedupKey(clientID, completionID)
+	if state, ok := sc.invalid.Get(key); ok {
+		return state
+	}
+	canceled := sc.queues.CancelMatching(completions.CancelCursorMoved, func(req *ClientRequest) bool {
+		return req.Para.ClientID == clientID && req.Para.CompletionID == completionID
+	})
+	found, finished := false, false
+	if sc.dedup != nil {
+		found, finished = sc.dedup.forget(key)
+	}
+	state := InvalidateUnknown
+	switch {
+	case canceled > 0 || (found && !finished):
+		state = InvalidateCanceled
+	case finished:
+		state = InvalidateDiscarded
+		completions.ForgetServed(clientID, completionID)
+	}
+	metrics.IncrementInvalidations(state)
+	if state == InvalidateUnknown {
+		return state
+	}
+	sc.invalid.Put(key, state)
+	zap.L().Debug("Invalidated completion", zap.String("clientID", clientID),
+		zap.String("completionID", completionID), zap.String("state", state))
+	return state
+}
+
+// 处理补全请求中附带的作废列表
+func (sc *StreamController) invalidateList(input *completions.CompletionInput) {
+	for i, completionID := range input.Invalidate {
+		if i >= maxPiggybackInvalidations {
+			break
+		}
+		if completionID != input.CompletionID {
+			sc.Invalidate(input.ClientID, completionID)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package stream_controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+func newInvalidateController(llm model.LLM) *StreamController {
+	m := NewPoolManager()
+	m.initPool(llm.Config().ModelName, llm, llm.Config())
+	return &StreamController{queues: NewQueueManager(), pools: m,
+		dedup: newCompletionDedup(time.Minute), invalid: newInvalidations(time.Minute)}
+}
+
+// to test invalidating an in-flight, an already finished and an unknown completion
+// go test ./pkg/stream_controller/ -v -run Test_InvalidateCompletion
+func Test_InvalidateCompletion(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(time.Millisecond)()
+	const route = "/code-completion/api/v1/completions"
+
+	// 在途的请求被取消，原因为cursor_moved
+	blocking := &cancelLLM{
+		cfg:     config.ModelConfig{ModelName: "cancel", MaxConcurrent: 1, MaxOutput: 50, DisablePrune: true},
+		started: make(chan string, 1),
+	}
+	sc := newInvalidateController(blocking)
+	done := make(chan *completions.CompletionResponse, 1)
+	go func() {
+		rsp, _ := sc.ProcessCompletionOnce(context.Background(), route, newDedupInput("c1", "inflight"))
+		done <- rsp
+	}()
+	<-blocking.started
+	if state := sc.Invalidate("c1", "inflight"); state != InvalidateCanceled {
+		t.Errorf("expected the in-flight completion canceled, got %s", state)
+	}
+	select {
+	case rsp := <-done:
+		if rsp.Status != model.StatusCanceled || rsp.CancelCause != completions.CancelCursorMoved {
+			t.Errorf("expected canceled/cursor_moved, got %s/%s", rsp.Status, rsp.CancelCause)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("in-flight completion not canceled")
+	}
+	if state := sc.Invalidate("c1", "inflight"); state != InvalidateCanceled {
+		t.Errorf("expected the same state when invalidated again, got %s", state)
+	}
+
+	// 已完成的结果不再重放，也不用于采纳统计
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "instant", MaxConcurrent: 1, MaxOutput: 50}, text: "two"}
+	sc = newInvalidateController(llm)
+	for _, id := range []string{"finished", "piggyback"} {
+		if rsp, _ := sc.ProcessCompletionOnce(context.Background(), route, newDedupInput("c2", id)); rsp.Status != model.StatusSuccess {
+			t.Fatalf("unexpected response %s for %s", rsp.Status, id)
+		}
+	}
+	if state := sc.Invalidate("c2", "finished"); state != InvalidateDiscarded {
+		t.Errorf("expected the finished completion discarded, got %s", state)
+	}
+	if _, ok := sc.dedup.entries.Get(dedupKey("c2", "finished")); ok {
+		t.Error("expected the dedup entry of the discarded completion removed")
+	}
+	if state := sc.Invalidate("c2", "finished"); state != InvalidateDiscarded {
+		t.Errorf("expected the same state when invalidated again, got %s", state)
+	}
+	// 最近返回的补全被下一次请求附带作废
+	next := newDedupInput("c2", "next")
+	next.Invalidate = []string{"piggyback"}
+	sc.ProcessCompletionOnce(context.Background(), route, next)
+	if _, ok := sc.dedup.entries.Get(dedupKey("c2", "piggyback")); ok || !sc.invalidated("c2", "piggyback") {
+		t.Error("expected the piggybacked completion discarded")
+	}
+	if completions.ForgetServed("c2", "piggyback") || !completions.ForgetServed("c2", "next") {
+		t.Error("expected only the valid completion tracked for acceptance")
+	}
+	// 作废后重发相同的completion_id不再调用模型
+	calls := llm.calls
+	rsp, result := sc.ProcessCompletionOnce(context.Background(), route, newDedupInput("c2", "finished"))
+	if result.Outcome != RouteServed || rsp.CancelCause != completions.CancelCursorMoved || llm.calls != calls {
+		t.Errorf("expected the invalidated completion canceled again, got %s %s/%s", result.Outcome, rsp.Status, rsp.CancelCause)
+	}
+
+	// 没有该请求
+	for _, key := range [][2]string{{"c2", "never-sent"}, {"other-client", "next"}, {"", "next"}} {
+		if state := sc.Invalidate(key[0], key[1]); state != InvalidateUnknown {
+			t.Errorf("expected %v unknown, got %s", key, state)
+		}
+	}
+	if sc.invalidated("c2", "never-sent") {
+		t.Error("expected no record kept for an unknown completion")
+	}
+}
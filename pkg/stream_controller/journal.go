@@ -0,0 +1,297 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 失败请求所在的阶段，见failedPhase
+const (
+	PhasePreprocess  = "preprocess"  // 没有进入排队：参数校验、选择模型池、预处理
+	PhaseQueue       = "queue"       // 排队中或排队超时，没有调用模型
+	PhaseModel       = "model"       // 调用模型
+	PhasePostprocess = "postprocess" // 模型有输出，被后置处理丢弃
+	PhaseDelivery    = "delivery"    // 补全已完成，写响应时客户端连接已断开
+)
+
+// 错误信息和聚合前缀的最大长度(字符数)
+const (
+	maxJournalError  = 200
+	maxJournalPrefix = 48
+)
+
+// 错误信息中上游返回的HTTP状态码，见model.BackendError
+var upstreamStatusCode = regexp.MustCompile(`StatusCode\((\d{3})\)`)
+
+/**
+ * 错误日志中的一条记录，不记录补全的代码
+ * @description
+ * - PromptSize: 收到的前缀+后缀字节数所在的区间，见promptSizeBucket
+ * - Phase: 失败所在的阶段，见Phase*常量
+ * - StatusCode: 错误信息中上游返回的HTTP状态码，没有时为0
+ * - Redacted: 隐私模式下错误信息只保存摘要(见store.Digest)
+ * - 各耗时单位为毫秒
+ */
+type JournalEntry struct {
+	Time        time.Time              `json:"time"`
+	Api         string                 `json:"api"`
+	Status      model.CompletionStatus `json:"status"`
+	Error       string                 `json:"error"`
+	Model       string                 `json:"model"`
+	Language    string                 `json:"language"`
+	ClientID    string                 `json:"clientId"`
+	PromptSize  string                 `json:"promptSize"`
+	Phase       string                 `json:"phase"`
+	QueueMs     int64                  `json:"queueMs"`
+	ContextMs   int64                  `json:"contextMs"`
+	LLMMs       int64                  `json:"llmMs"`
+	TotalMs     int64                  `json:"totalMs"`
+	Fingerprint string                 `json:"fingerprint,omitempty"` // 提示词指纹，用于与检索服务、插件的日志关联，关闭时为空
+	StatusCode  int                    `json:"statusCode,omitempty"`
+	Redacted    bool                   `json:"redacted,omitempty"`
+}
+
+// 隐私模式下错误信息只保存摘要，上游返回的错误内容(包括前缀)可能包含提示词
+func (e JournalEntry) Strip() JournalEntry {
+	e.Error = store.Digest(e.Error)
+	e.Redacted = true
+	return e
+}
+
+// 用于聚合同类错误的前缀，只有摘要的记录按上游的状态码聚合
+func (e JournalEntry) prefix() string {
+	switch {
+	case !e.Redacted:
+		return errorPrefix(e.Error)
+	case e.StatusCode != 0:
+		return fmt.Sprintf("StatusCode(%d)", e.StatusCode)
+	}
+	return "redacted"
+}
+
+// 查询错误日志的过滤条件，为空的条件不过滤
+type JournalFilter struct {
+	Status string
+	Model  string
+	Since  time.Time
+	Limit  int // 最多返回的记录数，0表示不限制，负数表示只聚合不返回记录
+}
+
+// 错误日志的聚合结果
+type JournalSummary struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"byStatus"`
+	ByPhase  map[string]int `json:"byPhase"`
+	ByPrefix map[string]int `json:"byPrefix"` // 按错误信息前缀聚合，见JournalEntry.prefix
+}
+
+// 错误日志的查询结果
+type JournalResult struct {
+	JournalSummary
+	Entries []JournalEntry `json:"entries"`
+}
+
+// 补全请求的概要，用于记录错误日志
+type journalRequest struct {
+	api         string
+	model       string
+	language    string
+	clientID    string
+	promptBytes int
+	dispatched  bool // 是否已被模型池取出执行
+}
+
+/**
+ * 最近N次失败补全的错误日志
+ * @description
+ * - 基于有界内存存储，键为递增的序号，只写不读，超出容量时淘汰最早的记录
+ * - 成功的补全不记录
+ */
+type errorJournal struct {
+	mutex   sync.Mutex // 保证序号的顺序与写入的顺序一致
+	seq     uint64
+	entries *store.Store[uint64, JournalEntry]
+}
+
+func newErrorJournal(size int) *errorJournal {
+	return &errorJournal{
+		entries: store.New(store.Options[uint64, JournalEntry]{
+			Name:       "error_journal",
+			MaxEntries: size,
+		}),
+	}
+}
+
+/**
+ * 记录失败的补全
+ * @param {journalRequest} req - 补全请求的概要
+ * @param {*completions.CompletionResponse} rsp - 补全响应，成功时不记录
+ */
+func (j *errorJournal) record(req journalRequest, rsp *completions.CompletionResponse) {
+	if j == nil || rsp == nil || rsp.Status == model.StatusSuccess {
+		return
+	}
+	perf := rsp.Usage
+	modelName := rsp.Model
+	if modelName == "" {
+		modelName = req.model
+	}
+	entry := JournalEntry{
+		Time:        time.Now(),
+		Api:         req.api,
+		Status:      rsp.Status,
+		Error:       truncateRunes(rsp.Error, maxJournalError),
+		Model:       modelName,
+		Language:    req.language,
+		ClientID:    req.clientID,
+		PromptSize:  promptSizeBucket(req.promptBytes),
+		Phase:       failedPhase(rsp.Status, &perf, req.dispatched),
+		QueueMs:     perf.QueueDuration,
+		ContextMs:   perf.ContextDuration,
+		LLMMs:       perf.LLMDuration,
+		TotalMs:     perf.TotalDuration,
+		Fingerprint: rsp.Fingerprint,
+	}
+	if m := upstreamStatusCode.FindStringSubmatch(rsp.Error); m != nil {
+		entry.StatusCode, _ = strconv.Atoi(m[1])
+	}
+	j.put(entry)
+}
+
+// 写入一条记录
+func (j *errorJournal) put(entry JournalEntry) {
+	j.mutex.Lock()
+	j.seq++
+	j.entries.Put(j.seq, entry)
+	j.mutex.Unlock()
+}
+
+/**
+ * 查询错误日志
+ * @param {JournalFilter} filter - 过滤条件
+ * @returns {JournalResult} 返回从新到旧的记录，以及符合条件的所有记录的聚合结果
+ */
+func (j *errorJournal) query(filter JournalFilter) JournalResult {
+	result := JournalResult{JournalSummary: newJournalSummary(), Entries: []JournalEntry{}}
+	if j == nil {
+		return result
+	}
+	j.entries.Range(func(_ uint64, e JournalEntry) bool {
+		if filter.Status != "" && string(e.Status) != filter.Status ||
+			filter.Model != "" && e.Model != filter.Model ||
+			e.Time.Before(filter.Since) {
+			return true
+		}
+		result.add(e)
+		if filter.Limit == 0 || len(result.Entries) < filter.Limit {
+			result.Entries = append(result.Entries, e)
+		}
+		return true
+	})
+	return result
+}
+
+// 最近一段时间的聚合结果，用于定时维护的日志
+func (j *errorJournal) summary(since time.Time) JournalSummary {
+	return j.query(JournalFilter{Since: since, Limit: -1}).JournalSummary
+}
+
+func newJournalSummary() JournalSummary {
+	return JournalSummary{
+		ByStatus: map[string]int{},
+		ByPhase:  map[string]int{},
+		ByPrefix: map[string]int{},
+	}
+}
+
+func (s *JournalSummary) add(e JournalEntry) {
+	s.Total++
+	s.ByStatus[string(e.Status)]++
+	s.ByPhase[e.Phase]++
+	s.ByPrefix[e.prefix()]++
+}
+
+// 最多的几个错误信息前缀，用于日志
+func (s JournalSummary) topPrefixes(n int) []string {
+	prefixes := make([]string, 0, len(s.ByPrefix))
+	for prefix := range s.ByPrefix {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(a, b int) bool {
+		if s.ByPrefix[prefixes[a]] != s.ByPrefix[prefixes[b]] {
+			return s.ByPrefix[prefixes[a]] > s.ByPrefix[prefixes[b]]
+		}
+		return prefixes[a] < prefixes[b]
+	})
+	if len(prefixes) > n {
+		prefixes = prefixes[:n]
+	}
+	return prefixes
+}
+
+/**
+ * 推断失败所在的阶段
+ * @description
+ * - 已被模型池取出执行或调用过模型：补全为空时为后置处理阶段，否则为调用模型阶段
+ * - 开始排队(EnqueueTime不为零)但没有被取出：排队阶段
+ * - 其他：预处理阶段
+ */
+func failedPhase(status model.CompletionStatus, perf *completions.CompletionPerformance, dispatched bool) string {
+	switch {
+	case dispatched || perf.LLMDuration > 0:
+		if status == model.StatusEmpty {
+			return PhasePostprocess
+		}
+		return PhaseModel
+	case !perf.EnqueueTime.IsZero():
+		return PhaseQueue
+	}
+	return PhasePreprocess
+}
+
+// 提示词大小区间，避免记录具体的大小
+func promptSizeBucket(bytes int) string {
+	switch {
+	case bytes < 1024:
+		return "<1KB"
+	case bytes < 4*1024:
+		return "1-4KB"
+	case bytes < 16*1024:
+		return "4-16KB"
+	case bytes < 64*1024:
+		return "16-64KB"
+	}
+	return ">=64KB"
+}
+
+/**
+ * 错误信息的前缀，用于聚合同类错误
+ * @description
+ * - 取第一个冒号之前的部分，去掉了请求相关的细节(如上游返回的错误内容)
+ * - 最多maxJournalPrefix个字符
+ * @example
+ * errorPrefix("Invalid StatusCode(503): Loading model") // "Invalid StatusCode(503)"
+ */
+func errorPrefix(msg string) string {
+	if idx := strings.Index(msg, ":"); idx >= 0 {
+		msg = msg[:idx]
+	}
+	return truncateRunes(strings.TrimSpace(msg), maxJournalPrefix)
+}
+
+// 截断到最多n个字符，不截断多字节字符；返回副本，可以长期保存(如上游错误中截取的内容)
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return strings.Clone(s)
+	}
+	return string([]rune(s)[:n])
+}
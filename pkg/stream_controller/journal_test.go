@@ -0,0 +1,132 @@
+package stream_controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+)
+
+func newJournalResponse(modelName string, status model.CompletionStatus, msg string, perf completions.CompletionPerformance) *completions.CompletionResponse {
+	return &completions.CompletionResponse{Model: modelName, Status: status, Error: msg, Usage: perf}
+}
+
+// to test filtering, aggregation and the failed phase of the error journal
+// go test ./pkg/stream_controller/ -v -run Test_ErrorJournalQuery
+func Test_ErrorJournalQuery(t *testing.T) {
+	j := newErrorJournal(10)
+	queued := completions.CompletionPerformance{EnqueueTime: time.Now(), QueueDuration: 200}
+	called := completions.CompletionPerformance{EnqueueTime: time.Now(), LLMDuration: 900}
+	req := journalRequest{api: "v1", language: "go", clientID: "c1", promptBytes: 2048}
+
+	j.record(req, newJournalResponse("m1", model.StatusSuccess, "", called))
+	j.record(req, newJournalResponse("m1", model.StatusBusy, "model pool busy, request rejected", queued))
+	j.record(req, newJournalResponse("m1", model.StatusModelError, "Invalid StatusCode(503): Loading model", called))
+	j.record(req, newJournalResponse("m2", model.StatusModelError, "Invalid StatusCode(503): server busy", called))
+	j.record(req, newJournalResponse("m2", model.StatusEmpty, "empty", called))
+	dispatched := req
+	dispatched.dispatched = true
+	j.record(dispatched, newJournalResponse("m2", model.StatusTimeout, "context deadline exceeded", queued))
+	j.record(journalRequest{api: "v1", model: "m3"}, newJournalResponse("", model.StatusRejected, "missing client id or completion id", completions.CompletionPerformance{}))
+
+	all := j.query(JournalFilter{})
+	if all.Total != 6 || len(all.Entries) != 6 {
+		t.Fatalf("expected 6 failures, got %d", all.Total)
+	}
+	if e := all.Entries[0]; e.Model != "m3" || e.Phase != PhasePreprocess || e.PromptSize != "<1KB" {
+		t.Errorf("expected the newest entry first, got %+v", e)
+	}
+	phases := map[model.CompletionStatus]string{
+		model.StatusBusy:     PhaseQueue,
+		model.StatusEmpty:    PhasePostprocess,
+		model.StatusTimeout:  PhaseModel,
+		model.StatusRejected: PhasePreprocess,
+	}
+	for _, e := range all.Entries {
+		if phase, ok := phases[e.Status]; ok && e.Phase != phase {
+			t.Errorf("expected phase %s for %s, got %s", phase, e.Status, e.Phase)
+		}
+	}
+	if all.ByPrefix["Invalid StatusCode(503)"] != 2 || all.ByStatus[string(model.StatusModelError)] != 2 {
+		t.Errorf("unexpected aggregation %+v", all.JournalSummary)
+	}
+
+	byModel := j.query(JournalFilter{Model: "m2", Status: string(model.StatusModelError)})
+	if byModel.Total != 1 || byModel.Entries[0].Error != "Invalid StatusCode(503): server busy" || byModel.Entries[0].PromptSize != "1-4KB" {
+		t.Errorf("unexpected filtered result %+v", byModel)
+	}
+	if limited := j.query(JournalFilter{Limit: 2}); limited.Total != 6 || len(limited.Entries) != 2 {
+		t.Errorf("expected 2 of 6 entries, got %d of %d", len(limited.Entries), limited.Total)
+	}
+	if later := j.query(JournalFilter{Since: time.Now().Add(time.Minute)}); later.Total != 0 {
+		t.Errorf("expected no entries in the future, got %d", later.Total)
+	}
+	if top := all.topPrefixes(1); len(top) != 1 || top[0] != "Invalid StatusCode(503)" {
+		t.Errorf("unexpected top prefixes %v", top)
+	}
+	if byModel.Entries[0].StatusCode != 503 {
+		t.Errorf("expected the upstream status code parsed, got %d", byModel.Entries[0].StatusCode)
+	}
+}
+
+// to test privacy mode keeps only a digest of the error message and aggregates by the upstream status code
+// go test ./pkg/stream_controller/ -v -run Test_ErrorJournalStrip
+func Test_ErrorJournalStrip(t *testing.T) {
+	upstream := JournalEntry{Status: model.StatusModelError, Error: "Invalid StatusCode(400): bad prompt 'const secret = 1'", StatusCode: 400}.Strip()
+	leading := JournalEntry{Status: model.StatusModelError, Error: "const secret = 1: unexpected token"}.Strip()
+	if upstream.Error != store.Digest("Invalid StatusCode(400): bad prompt 'const secret = 1'") || !upstream.Redacted || upstream.StatusCode != 400 {
+		t.Errorf("unexpected stripped entry %+v", upstream)
+	}
+	if strings.Contains(leading.Error, "secret") {
+		t.Errorf("expected no part of the message kept, got %q", leading.Error)
+	}
+	s := newJournalSummary()
+	s.add(upstream)
+	s.add(leading)
+	if s.ByPrefix["StatusCode(400)"] != 1 || s.ByPrefix["redacted"] != 1 {
+		t.Errorf("unexpected aggregation of stripped entries %v", s.ByPrefix)
+	}
+}
+
+// to test that the journal keeps the last N failures during a burst of concurrent failures
+// go test ./pkg/stream_controller/ -race -v -run Test_ErrorJournalBurst
+func Test_ErrorJournalBurst(t *testing.T) {
+	const size, writers, perWriter = 50, 16, 100
+	j := newErrorJournal(size)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				j.record(journalRequest{api: "v2", clientID: fmt.Sprintf("c%d", w)},
+					newJournalResponse("m", model.StatusTimeout, "context deadline exceeded", completions.CompletionPerformance{}))
+				if i%10 == 0 {
+					j.query(JournalFilter{Status: string(model.StatusTimeout)})
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	result := j.query(JournalFilter{})
+	if result.Total != size || j.entries.Len() != size {
+		t.Fatalf("expected %d entries kept, got %d", size, result.Total)
+	}
+	// the kept entries are the last written ones
+	var oldest uint64 = writers * perWriter
+	j.entries.Range(func(seq uint64, _ JournalEntry) bool {
+		if seq < oldest {
+			oldest = seq
+		}
+		return true
+	})
+	if oldest != writers*perWriter-size+1 {
+		t.Errorf("expected the oldest kept entry %d, got %d", writers*perWriter-size+1, oldest)
+	}
+}
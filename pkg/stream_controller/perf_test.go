@@ -0,0 +1,103 @@
+package stream_controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// setupPerfConfig points the codebase context to a slow server and disables the filters
+func setupPerfConfig(delay time.Duration) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"list":[{"filePath":"util.js","content":"export const one = 1"}]}}`))
+	}))
+	savedContext := *config.Context
+	savedWrapper := *config.Wrapper
+	config.Context.Definition = config.DefinitionConfig{Url: server.URL}
+	config.Context.Semantic = config.SemanticConfig{Url: server.URL, TopK: 5}
+	config.Context.Relation = config.RelationConfig{Url: server.URL, Layer: 1}
+	config.Context.RequestTimeout = time.Second
+	config.Context.TotalTimeout = time.Second
+	config.Wrapper.Score.Disabled = true
+	config.Wrapper.Syntax.Disabled = true
+	return func() {
+		server.Close()
+		*config.Context = savedContext
+		*config.Wrapper = savedWrapper
+	}
+}
+
+// to test every performance field of a full request is measured and consistent
+// go test ./pkg/stream_controller/ -v -run Test_PerformanceAccounting
+func Test_PerformanceAccounting(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(5 * time.Millisecond)()
+	llm := newFakeLLM(1)
+	m := NewPoolManager()
+	pool := m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, context: codebase_context.NewContextClient()}
+
+	// occupy the only slot so the request has to queue
+	busy := newTestRequest("L0", 8)
+	defer busy.cancel()
+	go m.WaitDoRequest(busy)
+	<-llm.started
+
+	done := make(chan *completions.CompletionResponse, 1)
+	go func() {
+		done <- sc.ProcessCompletionV1(context.Background(), &completions.CompletionInput{
+			CompletionRequest: completions.CompletionRequest{
+				ClientID:     "client-perf",
+				CompletionID: "L1",
+				LanguageID:   "javascript",
+				Prompts: &completions.PromptOptions{
+					Prefix:          "const two = one + ",
+					Suffix:          ";\n",
+					ProjectPath:     "/project",
+					FileProjectPath: "src/main.js",
+				},
+			},
+		})
+	}()
+	waitQueued(t, pool, 1)
+	time.Sleep(5 * time.Millisecond)
+	llm.release <- struct{}{}
+	<-llm.started
+	time.Sleep(5 * time.Millisecond)
+	llm.release <- struct{}{}
+
+	rsp := <-done
+	if rsp.Status != model.StatusSuccess {
+		t.Fatalf("request failed: %s %s", rsp.Status, rsp.Error)
+	}
+	perf := rsp.Usage
+	if perf.ReceiveTime.IsZero() || perf.EnqueueTime.IsZero() || perf.EnqueueTime.Before(perf.ReceiveTime) {
+		t.Errorf("unexpected receive/enqueue time: %v %v", perf.ReceiveTime, perf.EnqueueTime)
+	}
+	for name, v := range map[string]int64{
+		"context_duration": perf.ContextDuration,
+		"queue_duration":   perf.QueueDuration,
+		"llm_duration":     perf.LLMDuration,
+		"total_duration":   perf.TotalDuration,
+	} {
+		if v <= 0 {
+			t.Errorf("expected %s measured, got %d", name, v)
+		}
+	}
+	if perf.PromptTokens <= 0 || perf.CompletionTokens <= 0 || perf.TotalTokens != perf.PromptTokens+perf.CompletionTokens {
+		t.Errorf("unexpected tokens: %d + %d = %d", perf.PromptTokens, perf.CompletionTokens, perf.TotalTokens)
+	}
+	if perf.TotalDuration < perf.QueueDuration+perf.ContextDuration+perf.LLMDuration {
+		t.Errorf("expected total >= queue + context + llm, got %d < %d + %d + %d",
+			perf.TotalDuration, perf.QueueDuration, perf.ContextDuration, perf.LLMDuration)
+	}
+}
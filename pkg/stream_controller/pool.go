@@ -3,30 +3,45 @@ package stream_controller
 import (
 	"code-completion/pkg/completions"
 	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
 	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
 // 每个模型建立一个请求池，管理正在调用该模型的补全请求
+// modelName相同的配置是同一个逻辑模型的副本，每个副本一个请求池，见config.ValidateReplicas
 // 模型请求池
 type ModelPool struct {
-	llm      model.LLM
-	cfg      *config.ModelConfig
-	mutex    sync.RWMutex
-	waits    chan *ClientRequest
-	runnings map[string]*ClientRequest
+	llm           model.LLM
+	cfg           *config.ModelConfig
+	instance      string // 模型池的标识，<模型名称>#<副本序号>，副本按配置顺序从0编号
+	mutex         sync.RWMutex
+	waits         *waitQueue
+	runnings      map[string]*ClientRequest
+	reservedSmall int               // 只接纳小请求的并发槽位数
+	limiter       *tokenBucket      // 发往模型后端的限流令牌桶，nil表示不限流
+	configured    int               // 配置文件中的最大并发数，运行时调整不影响该值
+	workers       int               // 正在运行的取请求协程数，缩容后逐步收敛到MaxConcurrent
+	tuner         *concurrencyTuner // 按延迟自动调整并发数，nil表示未开启
 }
 
 // 模型请求池管理器
 type PoolManager struct {
-	pools map[string][]*ModelPool
-	all   []*ModelPool
+	mutex       sync.RWMutex // 保护pools和all，读取使用lookup/allPools
+	pools       map[string][]*ModelPool
+	all         []*ModelPool
+	resizeMutex sync.Mutex         // 串行化模型池并发数的调整
+	resizes     []PoolResizeRecord // 模型池并发数调整的审计记录
+	tails       *activityBus       // 跟踪客户端补全活动的总线，为nil时不发布事件
 }
 
 // 创建模型请求池管理器
@@ -38,12 +53,14 @@ func NewPoolManager() *PoolManager {
 }
 
 func (m *PoolManager) Init() {
-	for i, cfg := range config.Config.Models {
-		modelName := cfg.ModelName
-		if modelName == "" {
-			modelName = "default"
+	for i := range config.Config.Models {
+		// 配置无效的模型没有实例，不建立模型池
+		llm := model.GetModel(i)
+		if llm == nil {
+			continue
 		}
-		m.initPool(modelName, model.GetModel(i), &config.Config.Models[i])
+		cfg := &config.Config.Models[i]
+		m.initPool(cfg.LogicalName(), llm, cfg)
 	}
 	if len(m.all) == 0 {
 		zap.L().Error("Initialize model error, 'models' is missing",
@@ -54,20 +71,33 @@ func (m *PoolManager) Init() {
 
 // initPool 初始化模型请求池
 func (m *PoolManager) initPool(model string, llm model.LLM, cfg *config.ModelConfig) *ModelPool {
+	scCfg := &config.Config.StreamController
 	pool := &ModelPool{
-		cfg:      cfg,
-		llm:      llm,
-		runnings: make(map[string]*ClientRequest),
-		waits:    make(chan *ClientRequest, cfg.MaxConcurrent*2), // 缓冲区设为最大并发数的2倍
+		cfg:           cfg,
+		llm:           llm,
+		runnings:      make(map[string]*ClientRequest),
+		waits:         newWaitQueue(cfg.MaxConcurrent*2, scCfg.PriorityAging), // 队列长度设为最大并发数的2倍
+		reservedSmall: reservedSmallSlots(cfg.MaxConcurrent, scCfg.SmallReservedRatio),
+		limiter:       newTokenBucket(cfg.RateLimit, cfg.RateBurst),
+		configured:    cfg.MaxConcurrent,
+		tuner:         newConcurrencyTuner(cfg),
 	}
+	m.mutex.Lock()
+	pool.instance = fmt.Sprintf("%s#%d", cfg.LogicalName(), len(m.replicasLocked(cfg.ModelName)))
 	m.all = append(m.all, pool)
+	m.mutex.Unlock()
+	// 配置的模型优先占用指标model标签的取值
+	metrics.RegisterModels(model)
+	m.updatePoolConcurrency(cfg.ModelName)
 
-	// 启动MaxConcurrent个协程处理请求
+	// 启动MaxConcurrent个协程处理请求，其中reservedSmall个协程只处理小请求
 	for i := 0; i < cfg.MaxConcurrent; i++ {
-		go m.LoopDoRequest(pool)
+		go m.LoopDoRequest(pool, i < pool.reservedSmall)
 	}
 
 	// 将池添加到对应的模型名下
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	if _, exists := m.pools[model]; !exists {
 		m.pools[model] = make([]*ModelPool, 0)
 	}
@@ -83,7 +113,10 @@ func (m *PoolManager) initPool(model string, llm model.LLM, cfg *config.ModelCon
 
 	zap.L().Info("Initialize model pool",
 		zap.String("model", model),
-		zap.Int("maxConcurrent", cfg.MaxConcurrent))
+		zap.String("instance", pool.instance),
+		zap.Int("maxConcurrent", cfg.MaxConcurrent),
+		zap.Int("reservedSmall", pool.reservedSmall),
+		zap.Float64("rateLimit", cfg.RateLimit))
 	return pool
 }
 
@@ -93,9 +126,11 @@ func (m *PoolManager) initPool(model string, llm model.LLM, cfg *config.ModelCon
 * @returns {ModelPool} Returns the model pool with the lowest load rate
 * @description
 * - Iterates through the provided pools to find the one with the lowest load rate
-* - Load rate is calculated as: active_requests / max_concurrent
+* - Load rate is calculated as: (active_requests + waiting_requests) / max_concurrent
+* - Saturated pools still accept requests into their wait queue, where small
+*   requests are scheduled first; pools whose wait queue is full are skipped
 * - If multiple pools have the same load rate, returns the first one found
-* - If the list is empty, returns nil
+* - If the list is empty or all pools are full, returns nil
 * @example
 * pool := manager.findLowestLoadPool(pools)
  */
@@ -104,7 +139,7 @@ func (m *PoolManager) findIdlestPool(pools []*ModelPool) *ModelPool {
 		return nil
 	}
 
-	lowestLoadRate := float64(1.0)
+	lowestLoadRate := math.MaxFloat64
 	var selectedPool *ModelPool
 	for _, pool := range pools {
 		pool.mutex.RLock()
@@ -112,11 +147,11 @@ func (m *PoolManager) findIdlestPool(pools []*ModelPool) *ModelPool {
 		maxConcurrent := pool.cfg.MaxConcurrent
 		pool.mutex.RUnlock()
 
-		if maxConcurrent <= 0 || activeRequests >= maxConcurrent {
+		if maxConcurrent <= 0 || pool.waits.Full() {
 			continue
 		}
-		// Calculate load rate (0.0 to 1.0, where 0.0 is idle and 1.0 is fully loaded)
-		loadRate := float64(activeRequests) / float64(maxConcurrent)
+		// Calculate load rate (0.0 is idle, above 1.0 means requests are queuing)
+		loadRate := float64(activeRequests+pool.waits.Len()) / float64(maxConcurrent)
 		if loadRate < lowestLoadRate {
 			lowestLoadRate = loadRate
 			selectedPool = pool
@@ -126,73 +161,117 @@ func (m *PoolManager) findIdlestPool(pools []*ModelPool) *ModelPool {
 }
 
 func (m *PoolManager) SelectIdlestPool(modelName string) *ModelPool {
-	var pool *ModelPool
-	pools, exists := m.pools[modelName]
+	pools, exists := m.lookup(modelName)
 	if !exists || len(pools) == 0 {
-		pool = m.findIdlestPool(m.all)
-	} else {
-		pool = m.findIdlestPool(pools)
+		pools = m.allPools()
 	}
-	return pool
+	return m.findIdlestPool(pools)
+}
+
+// 模型名称或标签对应的模型池
+func (m *PoolManager) lookup(name string) ([]*ModelPool, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	pools, ok := m.pools[name]
+	return pools, ok
+}
+
+// 所有模型池，按初始化的顺序，返回的切片不能修改
+func (m *PoolManager) allPools() []*ModelPool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.all
 }
 
 // 等待模型池空闲处理请求
 func (m *PoolManager) WaitDoRequest(req *ClientRequest) *completions.CompletionResponse {
-	pool := m.SelectIdlestPool(req.Para.Model)
+	// 预选的模型池已按其模型适配了请求并经过安全模式判断，不再重新选择
+	pool := req.pool
 	if pool == nil {
-		req.Canceled = true
+		pool = m.SelectIdlestPool(req.Para.Model)
+	}
+	if pool == nil {
+		req.Canceled.Store(true)
 		return completions.CancelRequest(req.Para.CompletionID, req.Para.Model, req.Perf, model.StatusBusy, fmt.Errorf("model pool busy, request rejected"))
 	}
 	req.Para.Model = pool.cfg.ModelName
-	// 尝试将请求发送到ModelPool的waits通道，如果不能立即发送则失败
-	select {
-	case pool.waits <- req: // 成功将请求发送到waits通道
-		// 等待请求处理完成,接收处理结果
-		select {
-		case rsp := <-req.rspChan:
-			return rsp
-		case <-req.ctx.Done():
-			status := model.StatusTimeout
-			if req.ctx.Err() == context.Canceled {
-				status = model.StatusCanceled
-			}
-			req.Canceled = true
-			return completions.CancelRequest(req.Para.CompletionID, req.Para.Model, req.Perf, status, req.ctx.Err())
-		}
-	default: // waits通道已满，无法立即发送请求
-		zap.L().Debug("Model pool busy, failed to send request",
-			zap.String("model", req.Para.Model),
-			zap.String("clientID", req.Para.ClientID),
-			zap.String("completionID", req.Para.CompletionID))
+	// 尝试将请求放入ModelPool的等待队列，如果队列已满则失败
+	if !pool.waits.Push(req) {
+		logger.FromContext(req.ctx).Debug("Model pool busy, failed to send request",
+			zap.String("pool", req.Para.Model))
 		req.Perf.QueueDuration = time.Since(req.Perf.EnqueueTime).Milliseconds()
-		req.Canceled = true
+		req.Canceled.Store(true)
 		return completions.CancelRequest(req.Para.CompletionID, req.Para.Model, req.Perf, model.StatusBusy,
 			fmt.Errorf("model pool busy, request rejected"))
 	}
+	// 等待请求处理完成,接收处理结果
+	select {
+	case rsp := <-req.rspChan:
+		return req.attachCause(rsp)
+	case <-req.ctx.Done():
+		// 状态按发起取消时记录的原因确定
+		cause := req.settle()
+		req.Canceled.Store(true)
+		// 工作协程可能还在处理，取消的响应用自己的副本组装，不与工作协程共用req.Perf
+		perf := *req.Perf
+		// 还在队列中的请求，排队时长记到取消为止
+		if pool.waits.Remove(req) {
+			perf.QueueDuration = time.Since(perf.EnqueueTime).Milliseconds()
+		}
+		rsp := completions.CancelRequest(req.Para.CompletionID, req.Para.Model, &perf, cause.Status(),
+			fmt.Errorf("%w: %s", req.ctx.Err(), cause))
+		return req.attachCause(rsp)
+	}
 }
 
-// LoopDoRequest 循环处理ModelPool的waits通道中的请求
-func (m *PoolManager) LoopDoRequest(pool *ModelPool) {
+// LoopDoRequest 循环处理ModelPool等待队列中的请求，smallOnly为true时只处理小请求
+func (m *PoolManager) LoopDoRequest(pool *ModelPool, smallOnly bool) {
+	defer DumpOnPanic()
+	pool.mutex.Lock()
+	pool.workers++
+	pool.mutex.Unlock()
+	defer func() {
+		pool.mutex.Lock()
+		pool.workers--
+		pool.mutex.Unlock()
+	}()
 	for {
-		// 从waits通道获取请求
-		req := <-pool.waits
-		if req == nil || req.Canceled {
+		// 从等待队列获取请求，返回nil说明模型池已缩容，协程退出
+		req := pool.waits.Pop(smallOnly)
+		if req == nil {
+			return
+		}
+		if req.Canceled.Load() {
 			continue
 		}
+		if !req.probe() {
+			metrics.IncrementTenantDispatches(pool.cfg.ModelName, req.tenant())
+		}
 		rsp := m.doRequest(pool, req)
 		// 将结果发送回请求的响应通道
 		select {
 		case req.rspChan <- rsp:
 		default:
-			zap.L().Error("Failed to send response to client",
-				zap.String("completionID", req.Para.CompletionID))
+			logger.FromContext(req.ctx).Error("Failed to send response to client")
 		}
 	}
 }
 
 // 执行请求，调用补全模型
 func (m *PoolManager) doRequest(pool *ModelPool, req *ClientRequest) *completions.CompletionResponse {
-	req.Perf.QueueDuration = time.Since(req.Perf.EnqueueTime).Milliseconds()
+	atomic.StoreInt32(&req.dispatched, 1)
+	req.served.Store(pool)
+	// 请求取消后等待方立即返回，不等待工作协程结束，工作协程使用自己的副本
+	perf := *req.Perf
+	perf.QueueDuration = time.Since(perf.EnqueueTime).Milliseconds()
+	m.tails.publish(req.Para.ClientID, TailEvent{Stage: TailDispatched, CompletionID: req.Para.CompletionID,
+		Model: pool.cfg.ModelName, QueueMs: perf.QueueDuration})
+
+	// 出站限流：截止时间前拿不到令牌的请求快速失败，不再发往模型；拿到的令牌用于第一次发送
+	if pool.limiter != nil && !m.takeToken(req.ctx, pool) {
+		return completions.CancelRequest(req.Para.CompletionID, req.Para.Model, &perf,
+			model.StatusBusy, model.ErrRateLimited)
+	}
 
 	// 增加活跃请求计数
 	pool.mutex.Lock()
@@ -200,70 +279,257 @@ func (m *PoolManager) doRequest(pool *ModelPool, req *ClientRequest) *completion
 	currentRequests := len(pool.runnings)
 	pool.mutex.Unlock()
 
-	metrics.UpdateCompletionConcurrentByModel(pool.cfg.ModelName, currentRequests)
+	m.updateConcurrentRequests(pool.cfg.ModelName)
 
 	// 使用原有的补全处理器处理请求
 	handler := completions.NewCompletionHandler(pool.llm)
-	c := completions.NewCompletionContext(req.ctx, req.Perf)
+	c := completions.NewCompletionContext(m.withRateLimit(req.ctx, pool, true), &perf)
 	rsp := handler.CallLLM(c, req.Para)
+	if !req.probe() {
+		pool.tuner.observe(time.Now(), currentRequests, rsp)
+	}
+	if m.tails.watching(req.Para.ClientID) {
+		event := responseEvent(TailPruned, rsp)
+		event.CompletionID, event.Hits = req.Para.CompletionID, rsp.Hits
+		m.tails.publish(req.Para.ClientID, event)
+	}
 
 	pool.mutex.Lock()
 	delete(pool.runnings, req.Para.CompletionID)
-	currentRequests = len(pool.runnings)
 	pool.mutex.Unlock()
 
-	metrics.UpdateCompletionConcurrentByModel(pool.cfg.ModelName, currentRequests)
+	m.updateConcurrentRequests(pool.cfg.ModelName)
 
 	return rsp
 }
 
+// 等待模型池的令牌，截止时间前拿不到时记录限流并返回false；调用者需确认pool.limiter不为nil
+func (m *PoolManager) takeToken(ctx context.Context, pool *ModelPool) bool {
+	ok := pool.limiter.Wait(ctx)
+	m.updateRateTokens(pool.cfg.ModelName)
+	if !ok {
+		metrics.IncrementThrottled(pool.cfg.ModelName)
+		logger.FromContext(ctx).Warn("Completion throttled by model rate limit",
+			zap.Float64("rateLimit", pool.cfg.RateLimit))
+	}
+	return ok
+}
+
+/**
+ * 将模型池的出站限流放入请求上下文，之后向模型后端的每次发送都消耗一个令牌
+ * @param {context.Context} ctx - 请求上下文
+ * @param {*ModelPool} pool - 处理请求的模型池，不限流时原样返回ctx
+ * @param {bool} held - 调用方已为第一次发送拿到令牌(见doRequest)，第一次发送不再等待
+ * @returns {context.Context} 返回带出站限流的请求上下文
+ * @description
+ * - 令牌在模型实际发送请求时获取，上下文超长、修剪后的重试，认证回退和认证刷新后的重发都计入限流
+ * - 同一请求的多次发送是串行的
+ */
+func (m *PoolManager) withRateLimit(ctx context.Context, pool *ModelPool, held bool) context.Context {
+	if pool.limiter == nil {
+		return ctx
+	}
+	return model.WithRateLimit(ctx, func(ctx context.Context) bool {
+		if held {
+			held = false
+			return true
+		}
+		return m.takeToken(ctx, pool)
+	})
+}
+
+// 同一个逻辑模型各副本的模型池，按配置顺序
+func (m *PoolManager) replicas(modelName string) []*ModelPool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.replicasLocked(modelName)
+}
+
+// 同replicas，调用者已持有m.mutex
+func (m *PoolManager) replicasLocked(modelName string) []*ModelPool {
+	var pools []*ModelPool
+	for _, pool := range m.all {
+		if pool.cfg.ModelName == modelName {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// 更新模型池并发数的指标，同一个逻辑模型的各副本汇总为一个取值
+func (m *PoolManager) updatePoolConcurrency(modelName string) {
+	effective, configured := 0, 0
+	for _, pool := range m.replicas(modelName) {
+		pool.mutex.RLock()
+		effective += pool.cfg.MaxConcurrent
+		configured += pool.configured
+		pool.mutex.RUnlock()
+	}
+	metrics.UpdatePoolConcurrency(modelName, effective, configured)
+}
+
+// 更新模型正在处理的请求数的指标，汇总各副本
+func (m *PoolManager) updateConcurrentRequests(modelName string) {
+	running := 0
+	for _, pool := range m.replicas(modelName) {
+		pool.mutex.RLock()
+		running += len(pool.runnings)
+		pool.mutex.RUnlock()
+	}
+	metrics.UpdateCompletionConcurrentByModel(modelName, running)
+}
+
+// 更新限流令牌数的指标，汇总开启了限流的各副本
+func (m *PoolManager) updateRateTokens(modelName string) {
+	tokens := 0.0
+	for _, pool := range m.replicas(modelName) {
+		if pool.limiter != nil {
+			tokens += pool.limiter.Level()
+		}
+	}
+	metrics.UpdateRateTokens(modelName, tokens)
+}
+
+// getBucketStats 按请求规模统计模型池的占用情况，调用者需持有pool.mutex读锁
+func (pool *ModelPool) getBucketStats() map[string]interface{} {
+	runnings := make(map[SizeClass]int)
+	for _, req := range pool.runnings {
+		runnings[req.Size]++
+	}
+	waitings := pool.waits.CountBySize()
+	buckets := map[string]interface{}{
+		"reserved_small": pool.reservedSmall,
+	}
+	for _, size := range sizeClasses {
+		buckets[string(size)] = map[string]interface{}{
+			"running": runnings[size],
+			"waiting": waitings[size],
+		}
+	}
+	return buckets
+}
+
 // 获取统计信息
 func (m *PoolManager) GetStats() map[string]interface{} {
 	stats := make(map[string]interface{})
 
-	stats["count"] = len(m.all)
+	all := m.allPools()
+	stats["count"] = len(all)
 	poolDetails := make([]map[string]interface{}, 0)
-	for _, pool := range m.all {
+	for _, pool := range all {
 		pool.mutex.RLock()
 		poolInfo := map[string]interface{}{
-			"name": pool.cfg.ModelName,
-			"tags": pool.cfg.Tags,
+			"name":     pool.cfg.ModelName,
+			"instance": pool.instance,
+			"tags":     pool.cfg.Tags,
 			"requests": map[string]interface{}{
 				"max_concurrent": pool.cfg.MaxConcurrent,
+				"configured":     pool.configured,
+				"workers":        pool.workers,
 				"running":        len(pool.runnings),
-				"waiting":        len(pool.waits),
+				"waiting":        pool.waits.Len(),
+			},
+			"buckets": pool.getBucketStats(),
+			"autotune": map[string]interface{}{
+				"enabled":      pool.tuner != nil,
+				"effective":    pool.cfg.MaxConcurrent,
+				"configured":   pool.configured,
+				"lastDecision": pool.tuner.lastDecision(),
 			},
+			"tenants": pool.waits.TenantStats(),
 		}
 		pool.mutex.RUnlock()
+		if pool.limiter != nil {
+			poolInfo["rate_limit"] = map[string]interface{}{
+				"rate":   pool.cfg.RateLimit,
+				"burst":  pool.cfg.RateBurst,
+				"tokens": pool.limiter.Level(),
+			}
+		}
 		poolDetails = append(poolDetails, poolInfo)
 	}
 	stats["pools"] = poolDetails
+	stats["models"] = m.getModelStats()
+	stats["resizes"] = m.getResizeRecords()
 	return stats
 }
 
-func (m *PoolManager) GetDetails() map[string]interface{} {
+/**
+ * 按逻辑模型汇总各副本模型池的统计
+ * @returns {map[string]interface{}} 返回模型名称到汇总统计的映射
+ * @description
+ * - replicas为副本(模型池)数，其余为各副本之和
+ */
+func (m *PoolManager) getModelStats() map[string]interface{} {
+	models := make(map[string]interface{})
+	for _, pool := range m.allPools() {
+		if _, ok := models[pool.cfg.ModelName]; ok {
+			continue
+		}
+		replicas := m.replicas(pool.cfg.ModelName)
+		maxConcurrent, running, waiting := 0, 0, 0
+		for _, replica := range replicas {
+			replica.mutex.RLock()
+			maxConcurrent += replica.cfg.MaxConcurrent
+			running += len(replica.runnings)
+			waiting += replica.waits.Len()
+			replica.mutex.RUnlock()
+		}
+		models[pool.cfg.ModelName] = map[string]interface{}{
+			"replicas":       len(replicas),
+			"max_concurrent": maxConcurrent,
+			"running":        running,
+			"waiting":        waiting,
+		}
+	}
+	return models
+}
+
+/**
+ * 获取模型池的明细
+ * @param {DetailsFilter} filter - 字段选择，模型池不分页
+ * @returns {map[string]interface{}} 返回各模型池的明细
+ * @description
+ * - 模型池按模型名称排序，同名的模型池保持配置顺序
+ * - 正在处理的请求按接收时间排序，只在DetailsFull时输出
+ */
+func (m *PoolManager) GetDetails(filter DetailsFilter) map[string]interface{} {
 	details := make(map[string]interface{})
 
-	details["count"] = len(m.all)
+	all := m.allPools()
+	details["count"] = len(all)
+	pools := make([]*ModelPool, len(all))
+	copy(pools, all)
+	sort.SliceStable(pools, func(i, j int) bool {
+		return pools[i].cfg.ModelName < pools[j].cfg.ModelName
+	})
 	poolDetails := make([]map[string]interface{}, 0)
-	for _, pool := range m.all {
-		runnings := []map[string]interface{}{}
+	for _, pool := range pools {
 		pool.mutex.RLock()
-		for _, req := range pool.runnings {
-			runnings = append(runnings, req.GetSummary())
+		requests := map[string]interface{}{
+			"max_concurrent": pool.cfg.MaxConcurrent,
+			"running":        len(pool.runnings),
+			"waiting":        pool.waits.Len(),
 		}
-		poolInfo := map[string]interface{}{
-			"name": pool.cfg.ModelName,
-			"tags": pool.cfg.Tags,
-			"requests": map[string]interface{}{
-				"max_concurrent": pool.cfg.MaxConcurrent,
-				"running":        len(pool.runnings),
-				"waiting":        len(pool.waits),
-				"runnings":       runnings,
-			},
+		if filter.full() {
+			runnings := make([]*ClientRequest, 0, len(pool.runnings))
+			for _, req := range pool.runnings {
+				runnings = append(runnings, req)
+			}
+			sortRequests(runnings)
+			summaries := make([]map[string]interface{}, 0, len(runnings))
+			for _, req := range runnings {
+				summaries = append(summaries, req.GetSummary())
+			}
+			requests["runnings"] = summaries
 		}
 		pool.mutex.RUnlock()
-		poolDetails = append(poolDetails, poolInfo)
+		poolDetails = append(poolDetails, map[string]interface{}{
+			"name":     pool.cfg.ModelName,
+			"instance": pool.instance,
+			"tags":     pool.cfg.Tags,
+			"requests": requests,
+		})
 	}
 	details["pools"] = poolDetails
 	return details
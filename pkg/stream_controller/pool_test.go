@@ -0,0 +1,119 @@
+package stream_controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gaugeValue returns the value of a gauge series with the given labels
+func gaugeValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if v, ok := labels[label.GetName()]; ok && v != label.GetValue() {
+					continue next
+				}
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("gauge %s%v not found", name, labels)
+	return 0
+}
+
+// to test two replicas sharing a modelName form one logical model: one pool each, routing across both, aggregated metrics and stats
+// go test ./pkg/stream_controller/ -v -run Test_ModelReplicas
+func Test_ModelReplicas(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	saved := config.Config.Models
+	defer func() { config.Config.Models = saved }()
+	defer model.Use(nil)
+
+	const name = "replica-coder"
+	a, b := newFakeLLM(1), newFakeLLM(2)
+	a.cfg.ModelName, b.cfg.ModelName = name, name
+	a.cfg.CompletionsUrl, b.cfg.CompletionsUrl = "http://10.0.0.1/v1/completions", "http://10.0.0.2/v1/completions"
+	// 第3个配置无效，没有模型实例，其它模型的下标不变
+	config.Config.Models = []config.ModelConfig{a.cfg, {Provider: "openai"}, b.cfg}
+	model.Use([]model.LLM{a, nil, b})
+	m := NewPoolManager()
+	m.Init()
+
+	if len(m.all) != 2 || len(m.pools[name]) != 2 || m.all[0].instance != name+"#0" || m.all[1].instance != name+"#1" {
+		t.Fatalf("expected two replica pools, got %d pools", len(m.all))
+	}
+	if m.all[0].llm != a || m.all[1].llm != b {
+		t.Fatal("expected the pools to keep the config order")
+	}
+	waitWorkers(t, m.all[1], 2)
+
+	// 每个请求进入负载率最低的副本: #0(0/1) -> #1(0/2) -> #1(1/2)
+	var wg sync.WaitGroup
+	started := map[*fakeLLM]int{}
+	for _, id := range []string{"L1", "L2", "L3"} {
+		req := newTestRequest(id, 8)
+		req.Para.Model = name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer req.cancel()
+			if rsp := m.WaitDoRequest(req); rsp.Status != model.StatusSuccess || rsp.Model != name {
+				t.Errorf("request %s failed: %s on %s", id, rsp.Status, rsp.Model)
+			}
+		}()
+		select {
+		case <-a.started:
+			started[a]++
+		case <-b.started:
+			started[b]++
+		case <-time.After(time.Second):
+			t.Fatalf("request %s not started", id)
+		}
+	}
+	if started[a] != 1 || started[b] != 2 {
+		t.Errorf("expected 1 and 2 requests on the replicas, got %d and %d", started[a], started[b])
+	}
+
+	labels := map[string]string{"model": name}
+	if v := gaugeValue(t, "completion_model_concurrent_requests", labels); v != 3 {
+		t.Errorf("expected the running requests summed over the replicas, got %v", v)
+	}
+	labels["kind"] = "configured"
+	if v := gaugeValue(t, "completion_pool_concurrency", labels); v != 3 {
+		t.Errorf("expected the configured concurrency summed over the replicas, got %v", v)
+	}
+	models := m.GetStats()["models"].(map[string]interface{})
+	if s := models[name].(map[string]interface{}); len(models) != 1 || s["replicas"] != 2 || s["running"] != 3 || s["max_concurrent"] != 3 {
+		t.Errorf("unexpected model stats %v", models)
+	}
+
+	records, err := m.ResizePools(name, 4, "test")
+	if err != nil || len(records) != 2 || records[0].Instance != name+"#0" || records[1].Instance != name+"#1" {
+		t.Errorf("expected a resize record per replica, got %+v, %v", records, err)
+	}
+	labels["kind"] = "effective"
+	if v := gaugeValue(t, "completion_pool_concurrency", labels); v != 8 {
+		t.Errorf("expected the effective concurrency summed over the replicas, got %v", v)
+	}
+
+	close(a.release)
+	close(b.release)
+	wg.Wait()
+	if v := gaugeValue(t, "completion_model_concurrent_requests", map[string]string{"model": name}); v != 0 {
+		t.Errorf("expected no running requests, got %v", v)
+	}
+}
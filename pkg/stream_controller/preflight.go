@@ -0,0 +1,344 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 预检项的结果
+const (
+	PreflightPass = "pass" // 正常
+	PreflightWarn = "warn" // 可以补全，但效果可能受影响
+	PreflightFail = "fail" // 配置有误，补全或上下文不可用
+)
+
+// 预检调用模型的客户端ID前缀，后接客户端地址
+const PreflightClientPrefix = "preflight:"
+
+// 预检项的名称
+const (
+	CheckRequest  = "request"
+	CheckFilters  = "filters"
+	CheckModel    = "model"
+	checkProvider = "context." // 加上检索服务的名称，如context.definition
+)
+
+var (
+	ErrPreflightDisabled  = errors.New("preflight is disabled")
+	ErrPreflightThrottled = errors.New("too many preflight requests, retry later")
+)
+
+// 一项预检的结果，Hint为失败或警告时的处理建议
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+	Latency int64  `json:"latencyMs,omitempty"`
+}
+
+// 预检报告，Status为各项中最差的结果，ContextStatus为检索服务的状态(与补全响应的context_status相同)
+type PreflightReport struct {
+	Status        string            `json:"status"`
+	Model         string            `json:"model,omitempty"`
+	Checks        []PreflightCheck  `json:"checks"`
+	ContextStatus map[string]string `json:"context_status,omitempty"`
+}
+
+func (r *PreflightReport) add(check PreflightCheck) {
+	r.Checks = append(r.Checks, check)
+	if check.Status == PreflightFail || (check.Status == PreflightWarn && r.Status == PreflightPass) {
+		r.Status = check.Status
+	}
+}
+
+// 插件配置预检，按客户端地址限流
+type preflight struct {
+	cfg     *config.PreflightConfig
+	limits  *store.Store[string, *tokenBucket]
+	context *codebase_context.ContextClient
+	seq     atomic.Uint64 // 预检调用模型的请求序号，用于生成completion_id
+}
+
+func newPreflight(cfg *config.PreflightConfig, contextClient *codebase_context.ContextClient) *preflight {
+	return &preflight{
+		cfg: cfg,
+		limits: store.New(store.Options[string, *tokenBucket]{
+			Name:       "preflight_limits",
+			MaxEntries: 10000,
+			TTL:        10 * time.Minute,
+			Metadata:   true,
+		}),
+		context: contextClient,
+	}
+}
+
+// 该客户端地址是否还可以发起预检
+func (p *preflight) admit(key string) bool {
+	bucket, ok := p.limits.Get(key)
+	if !ok {
+		bucket = newTokenBucket(p.cfg.Rate, p.cfg.Burst)
+		if bucket == nil {
+			return true
+		}
+	}
+	p.limits.Put(key, bucket)
+	_, ok = bucket.reserve(time.Now(), 0)
+	return ok
+}
+
+/**
+ * 插件配置预检，在诊断模式下走一遍补全流程
+ * @param {context.Context} ctx - 请求上下文
+ * @param {*completions.CompletionInput} input - 与补全请求相同的请求体和请求头
+ * @param {string} remote - 客户端地址，用于限流(client_id由客户端自选，不作为限流的依据)
+ * @returns {*PreflightReport} 返回各项检查的pass/warn/fail结果和处理建议
+ * @returns {error} 预检关闭或被限流时返回ErrPreflightDisabled/ErrPreflightThrottled
+ * @description
+ * - request: 检查client_id、项目路径、语言和提示词
+ * - filters: 用补全拒绝规则链评估样例，不计算隐藏分(依赖上一次补全的反馈)
+ * - model: 选择模型池，调用模型生成1个token，检查模型是否可达、认证是否有效
+ * - context.<检索服务>: 用项目路径分别调用定义、语义、关系检索，结果同时更新检索服务的状态，汇总在context_status中
+ * - 模型调用按最低优先级(batch)经过模型池调度，计入模型池的并发和限流
+ * - 不记录错误日志、安全模式统计和客户端的代码风格，可以重复调用
+ * @example
+ * report, err := Controller.Preflight(ctx, &input, c.ClientIP())
+ */
+func (sc *StreamController) Preflight(ctx context.Context, input *completions.CompletionInput, remote string) (*PreflightReport, error) {
+	p := sc.preflight
+	if p.cfg.Disabled {
+		return nil, ErrPreflightDisabled
+	}
+	if !p.admit(remote) {
+		return nil, ErrPreflightThrottled
+	}
+
+	input.GetPrompts()
+	report := &PreflightReport{Status: PreflightPass}
+	report.add(checkPreflightRequest(input))
+	report.add(checkPreflightFilters(ctx, input))
+	report.add(sc.checkPreflightModel(ctx, input, remote, report))
+	for _, provider := range []string{codebase_context.ProviderDefinition, codebase_context.ProviderSemantic, codebase_context.ProviderRelation} {
+		report.add(p.checkProvider(ctx, input, provider))
+	}
+	report.ContextStatus = p.context.Health(input.ClientID, input.Processed.ProjectPath)
+	return report, nil
+}
+
+// 检查请求中插件应提供的字段
+func checkPreflightRequest(in *completions.CompletionInput) PreflightCheck {
+	check := PreflightCheck{Name: CheckRequest, Status: PreflightPass, Message: "request fields are complete"}
+	switch {
+	case in.ClientID == "":
+		check.Status = PreflightFail
+		check.Message = "missing client_id"
+		check.Hint = "the plugin must send a stable client_id, completions without it are rejected"
+	case in.Processed.ProjectPath == "" || in.Processed.FileProjectPath == "":
+		check.Status = PreflightWarn
+		check.Message = "missing project_path or file_project_path"
+		check.Hint = "codebase context is skipped without the workspace path and the file path relative to it"
+	case strings.TrimSpace(in.Processed.Prefix+in.Processed.Suffix) == "":
+		check.Status = PreflightWarn
+		check.Message = "the sample prefix and suffix are blank"
+		check.Hint = "send a prefix from a real file so the model and context checks are meaningful"
+	default:
+		if _, ok := completions.LookupLanguage(in.LanguageID); !ok {
+			check.Status = PreflightWarn
+			check.Message = fmt.Sprintf("unknown language_id %q", in.LanguageID)
+			check.Hint = "send the editor's language identifier (e.g. go, python, typescript), language-specific filters and cleanup are not applied"
+		}
+	}
+	return check
+}
+
+// 用补全拒绝规则链评估样例
+func checkPreflightFilters(ctx context.Context, in *completions.CompletionInput) PreflightCheck {
+	check := PreflightCheck{Name: CheckFilters, Status: PreflightPass, Message: "the sample passes the filter chain"}
+	// 隐藏分的评估会更新阈值自动调整的反馈，预检不计算
+	sample := *in
+	sample.HideScores = nil
+	c := completions.NewCompletionContext(ctx, &completions.CompletionPerformance{})
+	if err := completions.NewFilterChain(config.Wrapper).Handle(c, &sample); err != nil {
+		check.Status = PreflightWarn
+		check.Message = "the sample would be rejected: " + err.Error()
+		switch completions.RejectCode(err.Error()) {
+		case completions.GeneratedFile:
+			check.Hint = "the sample looks like a generated or minified file, try a hand-written source file"
+		case completions.FeatureNotSupport:
+			check.Hint = "the cursor position is not completed for this language, check language_id and that the prefix ends mid-code"
+		}
+	}
+	return check
+}
+
+// 选择模型池并经过模型池调度调用模型生成1个token
+func (sc *StreamController) checkPreflightModel(ctx context.Context, in *completions.CompletionInput, remote string, report *PreflightReport) PreflightCheck {
+	check := PreflightCheck{Name: CheckModel}
+	pool := sc.pools.SelectIdlestPool(in.Model)
+	if pool == nil {
+		check.Status = PreflightFail
+		check.Message = "no model pool is available"
+		check.Hint = "all model pools are full or no model is configured, retry later or check the server's models config"
+		return check
+	}
+	modelName := pool.cfg.ModelName
+	report.Model = modelName
+	if _, ok := sc.pools.lookup(in.Model); in.Model != "" && !ok {
+		check.Status = PreflightWarn
+		check.Hint = fmt.Sprintf("model %q is not configured on the server, requests are served by %s", in.Model, modelName)
+	}
+	for _, state := range sc.anomaly.states() {
+		if state.Instance == pool.instance && state.SafeMode {
+			check.Status = PreflightWarn
+			check.Hint = fmt.Sprintf("model %s is in safe mode (%s), auto completions may be rejected or degraded", modelName, state.Action)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sc.preflight.cfg.Timeout)
+	defer cancel()
+	para := &model.CompletionParameter{
+		CompletionID:  fmt.Sprintf("preflight-%d", sc.preflight.seq.Add(1)),
+		ClientID:      PreflightClientPrefix + remote,
+		Language:      in.LanguageID,
+		Model:         modelName,
+		MaxTokens:     1,
+		Prefix:        in.Processed.Prefix,
+		Suffix:        in.Processed.Suffix,
+		TriggerMode:   "MANUAL",
+		Authorization: in.Headers.Get("Authorization"),
+	}
+	var perf completions.CompletionPerformance
+	perf.ReceiveTime = time.Now().Local()
+	req := sc.queues.AddRequest(ctx, para, &perf)
+	req.pool = pool
+	req.Size = SizeBatch
+	rsp := sc.pools.WaitDoRequest(req)
+	sc.queues.RemoveRequest(req)
+	check.Latency = time.Since(perf.ReceiveTime).Milliseconds()
+	status := rsp.Status
+	switch status {
+	case model.StatusSuccess, model.StatusEmpty:
+		if check.Status == "" {
+			check.Status = PreflightPass
+		}
+		check.Message = fmt.Sprintf("model %s responded in %dms", modelName, check.Latency)
+		return check
+	case model.StatusUnauthorized:
+		check.Hint = "the model backend rejected the credentials, check the user's Authorization header or the model's authMode"
+	case model.StatusTimeout, model.StatusCanceled:
+		check.Status = PreflightWarn
+		check.Message = fmt.Sprintf("model %s did not respond within %s", modelName, sc.preflight.cfg.Timeout)
+		check.Hint = "the model backend is slow or overloaded, completions may time out"
+		return check
+	case model.StatusBusy:
+		check.Status = PreflightWarn
+		check.Message = fmt.Sprintf("model %s is busy or rate limited", modelName)
+		check.Hint = "the model pool is full or at its configured rate limit, retry later"
+		return check
+	default:
+		check.Hint = "the model backend is unreachable or returned an error, check the model's completionsUrl"
+	}
+	check.Status = PreflightFail
+	check.Message = fmt.Sprintf("model %s returned %s", modelName, status)
+	if rsp.Error != "" {
+		check.Message += ": " + rsp.Error
+	}
+	return check
+}
+
+// 配置中检索服务是否关闭
+func providerDisabled(provider string) bool {
+	switch provider {
+	case codebase_context.ProviderDefinition:
+		return config.Context.Definition.Disabled
+	case codebase_context.ProviderSemantic:
+		return config.Context.Semantic.Disabled
+	case codebase_context.ProviderRelation:
+		return config.Context.Relation.Disabled
+	}
+	return true
+}
+
+// 用项目路径调用一个检索服务
+func (p *preflight) checkProvider(ctx context.Context, in *completions.CompletionInput, provider string) PreflightCheck {
+	check := PreflightCheck{Name: checkProvider + provider, Status: PreflightPass}
+	if providerDisabled(provider) {
+		check.Message = "disabled on the server, not checked"
+		return check
+	}
+	if p.context == nil {
+		check.Status = PreflightFail
+		check.Message = "no codebase context client on the server"
+		check.Hint = "the server was started without codebase context, completions are made without it"
+		return check
+	}
+	projectPath, filePath := in.Processed.ProjectPath, in.Processed.FileProjectPath
+	if in.ClientID == "" || projectPath == "" || filePath == "" {
+		check.Status = PreflightWarn
+		check.Message = "not checked without client_id, project_path and file_project_path"
+		check.Hint = "send the workspace path so codebase context can be retrieved"
+		return check
+	}
+	snippet := in.Processed.Prefix + in.Processed.Suffix
+	if provider == codebase_context.ProviderSemantic {
+		snippet = lastLines(in.Processed.Prefix, 4)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	rsp, err := p.context.Probe(ctx, provider, in.ClientID, projectPath, filepath.Join(projectPath, filePath), snippet, in.Headers)
+	check.Latency = time.Since(start).Milliseconds()
+	var statusErr *codebase_context.StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		check.Status = PreflightFail
+		check.Message = fmt.Sprintf("%s search returned HTTP %d", provider, statusErr.StatusCode)
+		if statusErr.Message != "" {
+			check.Message += ": " + statusErr.Message
+		}
+		switch statusErr.StatusCode {
+		case http.StatusNotFound:
+			check.Hint = "is the workspace indexed? open the project in the IDE and wait for codebase indexing to finish"
+		case http.StatusUnauthorized, http.StatusForbidden:
+			check.Hint = "the codebase service rejected the credentials, the plugin must forward the user's Authorization header"
+		default:
+			check.Hint = "the codebase service failed, check its logs"
+		}
+	case err != nil && ctx.Err() != nil:
+		check.Status = PreflightWarn
+		check.Message = fmt.Sprintf("%s search did not respond within %s", provider, p.cfg.Timeout)
+		check.Hint = "the codebase service is slow, completions will be served without this context"
+	case err != nil:
+		check.Status = PreflightFail
+		check.Message = fmt.Sprintf("%s search is unreachable: %v", provider, err)
+		check.Hint = fmt.Sprintf("check context.%s.url in the server config", provider)
+	case rsp == nil || len(rsp.Data.List) == 0:
+		check.Status = PreflightWarn
+		check.Message = fmt.Sprintf("%s search returned no results", provider)
+		check.Hint = "the workspace may not be indexed yet, or the sample has no symbols to look up"
+	default:
+		check.Message = fmt.Sprintf("%s search returned %d results", provider, len(rsp.Data.List))
+	}
+	return check
+}
+
+// 文本的最后n行
+func lastLines(text string, n int) string {
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,230 @@
+package stream_controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+)
+
+// 返回固定状态的模型
+type statusLLM struct {
+	cfg    config.ModelConfig
+	status model.CompletionStatus
+	calls  int
+}
+
+func (f *statusLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	f.calls++
+	if p.MaxTokens != 1 {
+		return nil, nil, model.StatusReqError, errors.New("expected a 1-token call")
+	}
+	if f.status != model.StatusSuccess {
+		return nil, nil, f.status, errors.New("backend error")
+	}
+	return &model.CompletionResponse{Choices: []model.CompletionChoice{{Text: "x"}}}, &model.CompletionVerbose{}, f.status, nil
+}
+
+func (f *statusLLM) Config() *config.ModelConfig {
+	return &f.cfg
+}
+
+func (f *statusLLM) Tokenizer() *tokenizers.Tokenizer {
+	return nil
+}
+
+// 各检索服务按路径返回配置的状态码，200时返回results条结果
+func setupPreflightConfig(statuses map[string]int, results int) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := statuses[r.URL.Path]; status != 0 && status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		list := make([]string, results)
+		for i := range list {
+			list[i] = `{"filePath":"util.js","content":"export const one = 1"}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"list":[` + strings.Join(list, ",") + `]}}`))
+	}))
+	savedContext, savedWrapper, savedPreflight := *config.Context, *config.Wrapper, config.Config.Preflight
+	config.Context.Definition = config.DefinitionConfig{Url: server.URL + "/definition"}
+	config.Context.Semantic = config.SemanticConfig{Url: server.URL + "/semantic", TopK: 5}
+	config.Context.Relation = config.RelationConfig{Url: server.URL + "/relation", Layer: 1}
+	config.Context.RequestTimeout = time.Second
+	config.Wrapper.Score.Disabled = true
+	config.Wrapper.Syntax.Disabled = true
+	config.Wrapper.Generated = config.GeneratedFilterConfig{Action: "reject", FilePatterns: []string{".min.js"}}
+	config.Config.Preflight = config.PreflightConfig{Rate: 100, Burst: 100, Timeout: time.Second}
+	return func() {
+		server.Close()
+		*config.Context = savedContext
+		*config.Wrapper = savedWrapper
+		config.Config.Preflight = savedPreflight
+	}
+}
+
+func newPreflightController(llm *statusLLM) *StreamController {
+	m := NewPoolManager()
+	if llm != nil {
+		m.initPool(llm.cfg.ModelName, llm, &llm.cfg)
+	}
+	return &StreamController{queues: NewQueueManager(), pools: m, preflight: newPreflight(&config.Config.Preflight, codebase_context.NewContextClient())}
+}
+
+func newPreflightInput(clientID, file string) *completions.CompletionInput {
+	return &completions.CompletionInput{
+		CompletionRequest: completions.CompletionRequest{
+			ClientID:   clientID,
+			LanguageID: "javascript",
+			Prompts: &completions.PromptOptions{
+				Prefix:          "import { one } from './util'\nconst two = one + ",
+				Suffix:          "\n",
+				ProjectPath:     "/project",
+				FileProjectPath: file,
+			},
+		},
+		Headers: http.Header{},
+	}
+}
+
+func checkOf(report *PreflightReport, name string) PreflightCheck {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return PreflightCheck{}
+}
+
+// to test the checklist against fake codebase services failing in different ways
+// go test ./pkg/stream_controller/ -v -run Test_PreflightChecklist
+func Test_PreflightChecklist(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPreflightConfig(map[string]int{"/definition": http.StatusNotFound, "/relation": http.StatusUnauthorized}, 0)()
+	llm := &statusLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 1}, status: model.StatusSuccess}
+	sc := newPreflightController(llm)
+
+	report, err := sc.Preflight(context.Background(), newPreflightInput("c1", "src/app.js"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != PreflightFail || report.Model != "fake" || llm.calls != 1 {
+		t.Fatalf("unexpected report %+v, %d calls", report, llm.calls)
+	}
+	expected := map[string]string{
+		CheckRequest:         PreflightPass,
+		CheckFilters:         PreflightPass,
+		CheckModel:           PreflightPass,
+		"context.definition": PreflightFail,
+		"context.semantic":   PreflightWarn,
+		"context.relation":   PreflightFail,
+	}
+	for name, status := range expected {
+		if check := checkOf(report, name); check.Status != status {
+			t.Errorf("%s: expected %s, got %+v", name, status, check)
+		}
+	}
+	if hint := checkOf(report, "context.definition").Hint; !strings.Contains(hint, "indexed") {
+		t.Errorf("expected the indexing hint for a 404, got %q", hint)
+	}
+	if hint := checkOf(report, "context.relation").Hint; !strings.Contains(hint, "Authorization") {
+		t.Errorf("expected the credentials hint for a 401, got %q", hint)
+	}
+	// 检索服务的状态与补全响应的context_status相同
+	if status := report.ContextStatus; status["definition"] != "not_indexed" || status["semantic"] != "ok" || status["relation"] != "unauthorized" {
+		t.Errorf("unexpected context status %v", status)
+	}
+
+	// 样例会被拒绝规则链拒绝，缺少client_id
+	report, _ = sc.Preflight(context.Background(), newPreflightInput("", "dist/app.min.js"), "10.0.0.1")
+	if check := checkOf(report, CheckFilters); check.Status != PreflightWarn || !strings.Contains(check.Message, string(completions.GeneratedFile)) {
+		t.Errorf("expected the generated file rejection, got %+v", check)
+	}
+	if check := checkOf(report, CheckRequest); check.Status != PreflightFail {
+		t.Errorf("expected missing client_id to fail, got %+v", check)
+	}
+}
+
+// to test the model check for unreachable and misconfigured backends
+// go test ./pkg/stream_controller/ -v -run Test_PreflightModel
+func Test_PreflightModel(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPreflightConfig(nil, 1)()
+	cases := map[model.CompletionStatus]string{
+		model.StatusSuccess:      PreflightPass,
+		model.StatusUnauthorized: PreflightFail,
+		model.StatusModelError:   PreflightFail,
+		model.StatusTimeout:      PreflightWarn,
+	}
+	for status, expected := range cases {
+		llm := &statusLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 1}, status: status}
+		report, _ := newPreflightController(llm).Preflight(context.Background(), newPreflightInput("c1", "src/app.js"), "")
+		if check := checkOf(report, CheckModel); check.Status != expected || (expected != PreflightPass && check.Hint == "") {
+			t.Errorf("%s: expected %s with a hint, got %+v", status, expected, check)
+		}
+		if check := checkOf(report, "context.definition"); check.Status != PreflightPass {
+			t.Errorf("expected the definition search to pass, got %+v", check)
+		}
+	}
+
+	// 没有配置模型；请求的模型不存在时回退到其他模型
+	report, _ := newPreflightController(nil).Preflight(context.Background(), newPreflightInput("c1", "src/app.js"), "")
+	if check := checkOf(report, CheckModel); check.Status != PreflightFail {
+		t.Errorf("expected no model pool to fail, got %+v", check)
+	}
+	llm := &statusLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 1}, status: model.StatusSuccess}
+	input := newPreflightInput("c1", "src/app.js")
+	input.Model = "missing"
+	report, _ = newPreflightController(llm).Preflight(context.Background(), input, "")
+	if check := checkOf(report, CheckModel); check.Status != PreflightWarn || report.Model != "fake" {
+		t.Errorf("expected a warning for the unknown model, got %+v", check)
+	}
+
+	// 检索服务不可达
+	config.Context.Semantic.Url = "http://127.0.0.1:1/semantic"
+	config.Context.Relation.Disabled = true
+	report, _ = newPreflightController(llm).Preflight(context.Background(), newPreflightInput("c1", "src/app.js"), "")
+	if check := checkOf(report, "context.semantic"); check.Status != PreflightFail || !strings.Contains(check.Hint, "context.semantic.url") {
+		t.Errorf("expected the unreachable semantic search to fail, got %+v", check)
+	}
+	if check := checkOf(report, "context.relation"); check.Status != PreflightPass {
+		t.Errorf("expected the disabled relation search to pass, got %+v", check)
+	}
+}
+
+// to test the per-address rate limit of preflight, which a client cannot dodge by changing its client_id
+// go test ./pkg/stream_controller/ -v -run Test_PreflightRateLimit
+func Test_PreflightRateLimit(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPreflightConfig(nil, 1)()
+	config.Config.Preflight.Rate = 0.01
+	config.Config.Preflight.Burst = 2
+	llm := &statusLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 1}, status: model.StatusSuccess}
+	sc := newPreflightController(llm)
+
+	for i := 0; i < 2; i++ {
+		if _, err := sc.Preflight(context.Background(), newPreflightInput("c1", "src/app.js"), "10.0.0.1"); err != nil {
+			t.Fatalf("request %d: unexpected error %v", i, err)
+		}
+	}
+	if _, err := sc.Preflight(context.Background(), newPreflightInput("c2", "src/app.js"), "10.0.0.1"); !errors.Is(err, ErrPreflightThrottled) {
+		t.Errorf("expected the third request throttled whatever its client_id, got %v", err)
+	}
+	if _, err := sc.Preflight(context.Background(), newPreflightInput("c1", "src/app.js"), "10.0.0.2"); err != nil {
+		t.Errorf("expected another address admitted, got %v", err)
+	}
+	config.Config.Preflight.Disabled = true
+	if _, err := sc.Preflight(context.Background(), newPreflightInput("c3", "src/app.js"), ""); !errors.Is(err, ErrPreflightDisabled) {
+		t.Errorf("expected preflight disabled, got %v", err)
+	}
+}
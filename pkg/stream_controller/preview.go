@@ -0,0 +1,73 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/model"
+	"context"
+	"fmt"
+	"time"
+)
+
+/**
+ * 提示词预览的结果
+ * @description
+ * - Status/Error: 预处理拒绝了请求(或直接给出了本地补全)时的状态和原因，此时没有提示词
+ * - Prompt: 最终发给模型的提示词，含前言、检索上下文的围栏和上下文分隔符；非FIM模式的后缀单独传递，见Suffix
+ * - ContextSeparator: 上下文与前缀之间实际使用的分隔符，上下文为空时不拼接，为空
+ * - SuffixPolicy: 生效的后缀策略
+ * - Budget: 提示词预算报告，含前言、分隔符、围栏的token数和上下文各片段的来源(Snippets)
+ */
+type PromptPreview struct {
+	Model            string                 `json:"model"`
+	Status           model.CompletionStatus `json:"status"`
+	Error            string                 `json:"error,omitempty"`
+	Prompt           string                 `json:"prompt"`
+	Suffix           string                 `json:"suffix"`
+	ContextSeparator string                 `json:"contextSeparator,omitempty"`
+	SuffixPolicy     string                 `json:"suffixPolicy,omitempty"`
+	PromptTokens     int                    `json:"promptTokens"`
+	ContextMode      string                 `json:"contextMode,omitempty"`
+	Budget           *model.BudgetReport    `json:"budget,omitempty"`
+}
+
+/**
+ * 按当前的配置组装一次补全请求的提示词，不调用模型
+ * @param {context.Context} ctx - 请求上下文
+ * @param {*completions.CompletionInput} input - 补全请求
+ * @returns {*PromptPreview} 返回组装好的提示词及其预算报告
+ * @returns {error} 指定的模型不存在时返回ErrPoolNotFound
+ * @description
+ * - 走与补全相同的预处理(含获取代码上下文)和截断，按空闲的模型池的配置组装提示词
+ * - 预览请求标记为Preview，不读写负结果缓存、采纳反馈和风格档案，不排队、不计入模型池的统计
+ * - 总是记录预算报告，用于核对前言、分隔符、后缀策略和上下文片段的来源
+ */
+func (sc *StreamController) PreviewPrompt(ctx context.Context, input *completions.CompletionInput) (*PromptPreview, error) {
+	if _, ok := sc.pools.lookup(input.Model); input.Model != "" && !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, input.Model)
+	}
+	pool := sc.pools.SelectIdlestPool(input.Model)
+	input.Model = pool.cfg.ModelName
+	input.Preview, input.Verbose = true, true
+	ctx = logger.WithContext(ctx, completions.NewRequestLogger(input.CompletionID, input.ClientID, input.Model, input.LanguageID))
+	ctx, _ = requestFlags(ctx, input.ClientID, TenantOf(input.Headers))
+
+	c := completions.NewCompletionContext(ctx, &completions.CompletionPerformance{ReceiveTime: time.Now().Local()})
+	c.ContextClient = sc.context
+	preview := &PromptPreview{Model: input.Model, Status: model.StatusSuccess}
+	if rsp := input.Preprocess(c); rsp != nil {
+		preview.Status, preview.Error = rsp.Status, rsp.Error
+		return preview, nil
+	}
+	para := completions.NewCompletionHandler(pool.llm).Adapt(input)
+	preview.Prompt = model.BuildPrompt(pool.cfg, para)
+	preview.Suffix = para.Suffix
+	if para.CodeContext != "" {
+		preview.ContextSeparator = pool.cfg.GetContextSeparator()
+	}
+	preview.SuffixPolicy = para.SuffixPolicy
+	preview.PromptTokens = para.PromptTokens
+	preview.ContextMode = input.ContextMode
+	preview.Budget = para.Budget
+	return preview, nil
+}
@@ -0,0 +1,59 @@
+package stream_controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test the preview assembles the final prompt with preamble, separator, suffix policy and snippet provenance without calling the model
+// go test ./pkg/stream_controller/ -v -run Test_PreviewPrompt
+func Test_PreviewPrompt(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	separator := "\n\n"
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 1, MaxOutput: 50, DisablePrune: true,
+		FimMode: true, FimBegin: "<B>", FimHole: "<H>", FimEnd: "<E>", ContextSeparator: &separator,
+		PromptPreamble: "Path: {{.FilePath}}",
+		Languages:      map[string]config.ModelLanguageConfig{"javascript": {SuffixPolicy: config.SuffixNone}}}, text: "one"}
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, context: codebase_context.NewContextClientWith(&fakeSearchClient{})}
+
+	preview, err := sc.PreviewPrompt(context.Background(), newDedupInput("client-p", "P1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Status != model.StatusSuccess || preview.Model != "fake" {
+		t.Fatalf("unexpected preview %+v", preview)
+	}
+	if !strings.HasPrefix(preview.Prompt, "<B>// Path: src/main.js\n") || !strings.Contains(preview.Prompt, "export const one = 1") ||
+		!strings.HasSuffix(preview.Prompt, separator+"const two = one + <H><E>") {
+		t.Errorf("unexpected prompt %q", preview.Prompt)
+	}
+	if preview.ContextSeparator != separator || preview.SuffixPolicy != config.SuffixNone || preview.Suffix != "" {
+		t.Errorf("unexpected prompt assembly %+v", preview)
+	}
+	if preview.Budget == nil || len(preview.Budget.Snippets) != 1 ||
+		preview.Budget.Snippets[0].Provider != codebase_context.ProviderDefinition || preview.Budget.Snippets[0].FilePath != "util.js" {
+		t.Errorf("expected the definition snippet in the provenance, got %+v", preview.Budget)
+	}
+	if llm.calls != 0 {
+		t.Errorf("preview should not call the model, got %d calls", llm.calls)
+	}
+
+	if _, err := sc.PreviewPrompt(context.Background(), newDedupInput("client-p", "P2")); err != nil {
+		t.Fatal(err)
+	}
+	input := newDedupInput("client-p", "P3")
+	input.Model = "unknown-model"
+	if _, err := sc.PreviewPrompt(context.Background(), input); !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("expected pool not found for unknown model, got %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+//
+//	按提示词规模分级调度: 小请求优先，且可以独占模型池的一部分并发槽位
+//
+
+// 请求规模分级
+type SizeClass string
+
+const (
+	SizeSmall  SizeClass = "small"
+	SizeMedium SizeClass = "medium"
+	SizeLarge  SizeClass = "large"
+	SizeBatch  SizeClass = "batch" // 运维重放等后台请求，排在所有补全请求之后
+)
+
+var sizeClasses = []SizeClass{SizeSmall, SizeMedium, SizeLarge, SizeBatch}
+
+func (s SizeClass) rank() int {
+	switch s {
+	case SizeSmall:
+		return 0
+	case SizeMedium:
+		return 1
+	case SizeLarge:
+		return 2
+	default:
+		return 3
+	}
+}
+
+/**
+ * Estimate prompt tokens of a completion request without invoking the tokenizer
+ * @param {*model.CompletionParameter} para - Completion parameters
+ * @returns {int} Returns the estimated token count (about 4 bytes per token)
+ * @description
+ * - Called at enqueue time, so it must be cheap
+ * - Precision is only needed to separate small/medium/large buckets
+ */
+func estimatePromptTokens(para *model.CompletionParameter) int {
+	return (len(para.Prefix) + len(para.Suffix) + len(para.CodeContext)) / 4
+}
+
+/**
+ * Classify completion request into small/medium/large bucket
+ * @param {*model.CompletionParameter} para - Completion parameters
+ * @param {*config.StreamControllerConfig} cfg - Stream controller configuration with bucket thresholds
+ * @returns {SizeClass} Returns the bucket of the request
+ * @example
+ * size := classifyPrompt(para, &config.Config.StreamController)
+ */
+func classifyPrompt(para *model.CompletionParameter, cfg *config.StreamControllerConfig) SizeClass {
+	tokens := estimatePromptTokens(para)
+	if tokens <= cfg.SmallPromptTokens {
+		return SizeSmall
+	}
+	if tokens >= cfg.LargePromptTokens {
+		return SizeLarge
+	}
+	return SizeMedium
+}
+
+/**
+ * Calculate the number of pool slots reserved for small requests
+ * @param {int} maxConcurrent - Maximum concurrency of the model pool
+ * @param {float64} ratio - Fraction of slots reserved for small requests
+ * @returns {int} Returns reserved slot count, always leaving at least one general slot
+ */
+func reservedSmallSlots(maxConcurrent int, ratio float64) int {
+	if ratio <= 0 || maxConcurrent <= 1 {
+		return 0
+	}
+	reserved := int(float64(maxConcurrent) * ratio)
+	return min(reserved, maxConcurrent-1)
+}
+
+/**
+ * 模型池的等待队列
+ * @description
+ * - 按入队顺序保存等待调度的请求
+ * - 普通槽位优先取规模最小的请求，同规模先到先得
+ * - 队首请求等待超过maxAge后，无论规模都优先调度，防止大请求饿死
+ * - 预留槽位只取小请求
+ * - 同规模的请求由公平调度器在租户和客户端之间选择，见fairScheduler
+ * - 模型池缩容时，标记待退出的取请求协程数，协程在下次取请求时退出
+ */
+type waitQueue struct {
+	mutex           sync.Mutex
+	cond            *sync.Cond
+	items           []*ClientRequest
+	capacity        int
+	maxAge          time.Duration
+	fair            *fairScheduler
+	retiringSmall   int // 待退出的预留槽位协程数
+	retiringGeneral int // 待退出的普通槽位协程数
+}
+
+func newWaitQueue(capacity int, maxAge time.Duration) *waitQueue {
+	q := &waitQueue{
+		items:    make([]*ClientRequest, 0, capacity),
+		capacity: capacity,
+		maxAge:   maxAge,
+		fair:     newFairScheduler(&config.Config.StreamController.Fairness),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Push 请求入队，队列已满时返回false
+func (q *waitQueue) Push(req *ClientRequest) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.items) >= q.capacity {
+		return false
+	}
+	q.items = append(q.items, req)
+	q.cond.Broadcast()
+	return true
+}
+
+// Pop 阻塞等待并取出下一个应被调度的请求，返回nil表示取请求的协程应退出
+func (q *waitQueue) Pop(smallOnly bool) *ClientRequest {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		if retiring := q.retiringLocked(smallOnly); *retiring > 0 {
+			*retiring--
+			return nil
+		}
+		if idx := q.selectLocked(smallOnly); idx >= 0 {
+			req := q.items[idx]
+			// slices.Delete清空移出的尾部元素，底层数组不再引用已取出的请求(及其提示词)
+			q.items = slices.Delete(q.items, idx, idx+1)
+			q.fair.charge(req)
+			return req
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *waitQueue) retiringLocked(smallOnly bool) *int {
+	if smallOnly {
+		return &q.retiringSmall
+	}
+	return &q.retiringGeneral
+}
+
+/**
+ * Resize the number of routines taking requests from the queue
+ * @param {bool} smallOnly - Whether the routines serve the slots reserved for small requests
+ * @param {int} delta - Change of the routine count
+ * @returns {int} Returns the number of routines to start
+ * @description
+ * - Growing first cancels pending retirements, then asks for new routines
+ * - Shrinking marks routines to retire the next time they take a request,
+ *   busy routines finish their in-flight request first
+ */
+func (q *waitQueue) Resize(smallOnly bool, delta int) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	retiring := q.retiringLocked(smallOnly)
+	if delta < 0 {
+		*retiring -= delta
+		q.cond.Broadcast()
+		return 0
+	}
+	cancel := min(delta, *retiring)
+	*retiring -= cancel
+	return delta - cancel
+}
+
+// SetCapacity 调整队列长度，已在队列中的请求不受影响
+func (q *waitQueue) SetCapacity(capacity int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.capacity = capacity
+}
+
+// selectLocked 选择下一个请求的下标，没有合适请求时返回-1
+func (q *waitQueue) selectLocked(smallOnly bool) int {
+	// 先清理已取消的请求
+	items := q.items[:0]
+	for _, req := range q.items {
+		if !req.Canceled.Load() {
+			items = append(items, req)
+		}
+	}
+	q.items = items
+	if len(q.items) == 0 {
+		return -1
+	}
+	if smallOnly {
+		return q.fair.pick(q.items, func(req *ClientRequest) bool { return req.Size == SizeSmall })
+	}
+	// 队首等待过久，按先到先得调度
+	if q.maxAge > 0 && time.Since(q.items[0].Perf.EnqueueTime) >= q.maxAge {
+		return 0
+	}
+	rank := q.items[0].Size.rank()
+	for _, req := range q.items {
+		rank = min(rank, req.Size.rank())
+	}
+	return q.fair.pick(q.items, func(req *ClientRequest) bool { return req.Size.rank() == rank })
+}
+
+// Remove 将请求移出队列(请求被取消或超时)，返回请求是否还在队列中
+func (q *waitQueue) Remove(req *ClientRequest) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i, r := range q.items {
+		if r == req {
+			q.items = slices.Delete(q.items, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Full 队列是否已满
+func (q *waitQueue) Full() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items) >= q.capacity
+}
+
+// Len 等待中的请求数
+func (q *waitQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items)
+}
+
+// TenantStats 按租户统计权重、饥饿上界、等待中和已调度的请求数
+func (q *waitQueue) TenantStats() map[string]interface{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	waitings := make(map[string]int)
+	for _, req := range q.items {
+		waitings[req.tenant()]++
+	}
+	tenants := make(map[string]bool)
+	for tenant := range waitings {
+		tenants[tenant] = true
+	}
+	for tenant := range q.fair.dispatched {
+		tenants[tenant] = true
+	}
+	stats := make(map[string]interface{})
+	for tenant := range tenants {
+		stats[tenant] = map[string]interface{}{
+			"weight":           tenantWeight(q.fair.cfg, tenant),
+			"starvation_bound": q.fair.starvationBound(tenant),
+			"waiting":          waitings[tenant],
+			"dispatched":       q.fair.dispatched[tenant],
+		}
+	}
+	return stats
+}
+
+// CountBySize 按规模统计等待中的请求数
+func (q *waitQueue) CountBySize() map[SizeClass]int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	counts := make(map[SizeClass]int)
+	for _, req := range q.items {
+		counts[req.Size]++
+	}
+	return counts
+}
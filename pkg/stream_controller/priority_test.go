@@ -0,0 +1,206 @@
+package stream_controller
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+)
+
+// fakeLLM records the order of model calls; requests whose ID starts with "L" block until released
+type fakeLLM struct {
+	cfg     config.ModelConfig
+	mutex   sync.Mutex
+	order   []string
+	started chan string
+	release chan struct{}
+}
+
+func newFakeLLM(maxConcurrent int) *fakeLLM {
+	return &fakeLLM{
+		cfg: config.ModelConfig{
+			ModelName:     "fake",
+			MaxConcurrent: maxConcurrent,
+			MaxOutput:     50,
+			DisablePrune:  true,
+		},
+		started: make(chan string, 16),
+		release: make(chan struct{}),
+	}
+}
+
+func (f *fakeLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	f.mutex.Lock()
+	f.order = append(f.order, p.CompletionID)
+	f.mutex.Unlock()
+	f.started <- p.CompletionID
+	if strings.HasPrefix(p.CompletionID, "L") {
+		<-f.release
+	}
+	return &model.CompletionResponse{
+		Choices: []model.CompletionChoice{{Text: "ok"}},
+		Usage:   model.CompletionUsage{PromptTokens: 10, CompletionTokens: 1, TotalTokens: 11},
+	}, &model.CompletionVerbose{}, model.StatusSuccess, nil
+}
+
+func (f *fakeLLM) Config() *config.ModelConfig {
+	return &f.cfg
+}
+
+func (f *fakeLLM) Tokenizer() *tokenizers.Tokenizer {
+	return nil
+}
+
+func (f *fakeLLM) getOrder() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.order...)
+}
+
+func setupSchedulerConfig(ratio float64, aging time.Duration) func() {
+	saved := config.Config.StreamController
+	config.Config.StreamController = config.StreamControllerConfig{
+		CompletionTimeout:  5 * time.Second,
+		SmallPromptTokens:  10,
+		LargePromptTokens:  100,
+		SmallReservedRatio: ratio,
+		PriorityAging:      aging,
+	}
+	return func() {
+		config.Config.StreamController = saved
+	}
+}
+
+func newTestRequest(id string, promptBytes int) *ClientRequest {
+	para := &model.CompletionParameter{
+		CompletionID: id,
+		ClientID:     "client-" + id,
+		Model:        "fake",
+		Prefix:       strings.Repeat("x", promptBytes),
+	}
+	perf := &completions.CompletionPerformance{ReceiveTime: time.Now(), EnqueueTime: time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Config.StreamController.CompletionTimeout)
+	return &ClientRequest{
+		Para:    para,
+		Perf:    perf,
+		Size:    classifyPrompt(para, &config.Config.StreamController),
+		ctx:     ctx,
+		cancel:  cancel,
+		rspChan: make(chan *completions.CompletionResponse, 1),
+	}
+}
+
+func waitQueued(t *testing.T, pool *ModelPool, n int) {
+	deadline := time.Now().Add(time.Second)
+	for pool.waits.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued requests, got %d", n, pool.waits.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// runScheduled submits a blocking large request, then queues the given requests behind it
+func runScheduled(t *testing.T, llm *fakeLLM, pool *ModelPool, m *PoolManager, queued []*ClientRequest, beforeRelease func()) {
+	var wg sync.WaitGroup
+	submit := func(req *ClientRequest) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer req.cancel()
+			if rsp := m.WaitDoRequest(req); rsp.Status != model.StatusSuccess {
+				t.Errorf("request %s failed: %s", req.Para.CompletionID, rsp.Status)
+			}
+		}()
+	}
+	submit(newTestRequest("L0", 1000))
+	if id := <-llm.started; id != "L0" {
+		t.Fatalf("expected L0 to start first, got %s", id)
+	}
+	for i, req := range queued {
+		submit(req)
+		waitQueued(t, pool, i+1)
+	}
+	if beforeRelease != nil {
+		beforeRelease()
+	}
+	close(llm.release)
+	wg.Wait()
+}
+
+// to test small requests overtaking queued large ones
+// go test ./pkg/stream_controller/ -v -run Test_SmallRequestOvertakes
+func Test_SmallRequestOvertakes(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := newFakeLLM(1)
+	m := NewPoolManager()
+	pool := m.initPool("fake", llm, llm.Config())
+
+	runScheduled(t, llm, pool, m, []*ClientRequest{
+		newTestRequest("L1", 1000),
+		newTestRequest("S1", 8),
+	}, nil)
+
+	expected := []string{"L0", "S1", "L1"}
+	if order := llm.getOrder(); strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
+
+// to test that aged large requests are not starved by small ones
+// go test ./pkg/stream_controller/ -v -run Test_AgedRequestNotStarved
+func Test_AgedRequestNotStarved(t *testing.T) {
+	defer setupSchedulerConfig(0, 5*time.Millisecond)()
+	llm := newFakeLLM(1)
+	m := NewPoolManager()
+	pool := m.initPool("fake", llm, llm.Config())
+
+	runScheduled(t, llm, pool, m, []*ClientRequest{
+		newTestRequest("L1", 1000),
+		newTestRequest("S1", 8),
+	}, func() {
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	expected := []string{"L0", "L1", "S1"}
+	if order := llm.getOrder(); strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
+
+// to test the slots reserved for small requests
+// go test ./pkg/stream_controller/ -v -run Test_ReservedSmallSlots
+func Test_ReservedSmallSlots(t *testing.T) {
+	defer setupSchedulerConfig(0.5, 10*time.Second)()
+	llm := newFakeLLM(2)
+	m := NewPoolManager()
+	pool := m.initPool("fake", llm, llm.Config())
+	if pool.reservedSmall != 1 {
+		t.Fatalf("expected 1 reserved slot, got %d", pool.reservedSmall)
+	}
+
+	runScheduled(t, llm, pool, m, []*ClientRequest{
+		newTestRequest("L1", 1000),
+	}, func() {
+		// the reserved slot stays free for small requests while L1 waits
+		small := newTestRequest("S1", 8)
+		defer small.cancel()
+		if rsp := m.WaitDoRequest(small); rsp.Status != model.StatusSuccess {
+			t.Errorf("small request failed: %s", rsp.Status)
+		}
+		if pool.waits.Len() != 1 {
+			t.Errorf("expected L1 still queued, got %d waiting", pool.waits.Len())
+		}
+	})
+
+	expected := []string{"L0", "S1", "L1"}
+	if order := llm.getOrder(); strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
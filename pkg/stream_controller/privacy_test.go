@@ -0,0 +1,177 @@
+package stream_controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"code-completion/pkg/tokenizers"
+)
+
+// privacySentinel marks code that must not outlive the request in privacy mode; each run uses its own,
+// the stores of earlier runs stay registered for FindText
+var privacySeq atomic.Int32
+
+func privacySentinel() string {
+	return fmt.Sprintf("PRIVACY_SENTINEL_7f3a_%d", privacySeq.Add(1))
+}
+
+// echoLLM completes with the sentinel, or fails echoing the prompt like some upstream errors
+type echoLLM struct {
+	cfg       config.ModelConfig
+	sentinel  string
+	tokenizer *tokenizers.Tokenizer
+	calls     int32
+}
+
+func (f *echoLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	atomic.AddInt32(&f.calls, 1)
+	if strings.HasPrefix(p.CompletionID, "fail") {
+		return nil, &model.CompletionVerbose{}, model.StatusModelError, errors.New("upstream rejected prompt: " + p.Prefix)
+	}
+	return &model.CompletionResponse{Choices: []model.CompletionChoice{{Text: f.sentinel + "()"}}}, &model.CompletionVerbose{}, model.StatusSuccess, nil
+}
+
+func (f *echoLLM) Config() *config.ModelConfig {
+	return &f.cfg
+}
+
+func (f *echoLLM) Tokenizer() *tokenizers.Tokenizer {
+	return f.tokenizer
+}
+
+// sentinelSearch answers the codebase context searches with code containing the sentinel
+type sentinelSearch struct {
+	sentinel string
+}
+
+func (s *sentinelSearch) Do(req *http.Request) (*http.Response, error) {
+	body := `{"data":{"list":[{"filePath":"util.js","content":"export const ` + s.sentinel + ` = 1"}]}}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// setupPrivacy switches privacy mode, samples every completion of c1 and turns on the progressive context,
+// the style and the prefix token caches, returns a restore func
+func setupPrivacy(enabled bool) func() {
+	savedPrivacy := config.Config.Privacy
+	savedSamples := config.Config.StreamController.Samples
+	savedWrapper := *config.Wrapper
+	config.Config.Privacy = config.PrivacyConfig{Enabled: enabled}
+	config.Config.StreamController.Samples = config.SamplesConfig{Enabled: true, Rate: 1, Clients: []string{"c1"},
+		MaxEntries: 100, Retention: time.Hour}
+	config.Wrapper.Progressive = config.ProgressiveConfig{Enabled: true, RegionLines: 40, NearbyLines: 20,
+		FetchTimeout: time.Second, MaxEntries: 100, TTL: time.Minute}
+	config.Wrapper.Style.Disabled = false
+	config.Wrapper.TokenCache.Disabled = false
+	return func() {
+		config.Config.Privacy = savedPrivacy
+		config.Config.StreamController.Samples = savedSamples
+		*config.Wrapper = savedWrapper
+	}
+}
+
+// newPrivacyController creates a controller with the stores a completion writes to
+func newPrivacyController(llm model.LLM, sentinel string) *StreamController {
+	sc := newInvalidateController(llm)
+	sc.errors = newErrorJournal(100)
+	sc.samples = newSampleJournal(&config.Config.StreamController.Samples)
+	sc.context = codebase_context.NewContextClientWith(&sentinelSearch{sentinel: sentinel})
+	sc.prefetch = completions.NewPrefetchCache(&config.Wrapper.Progressive)
+	return sc
+}
+
+// newSentinelInput is a request whose prefix contains the sentinel
+func newSentinelInput(completionID, sentinel string) *completions.CompletionInput {
+	input := newDedupInput("c1", completionID)
+	input.Prompts.Prefix = "const " + sentinel + " = 1;\nconst two = one + "
+	return input
+}
+
+// to test that no registered store retains the sentinel after requests in privacy mode, and they do when it is off
+// go test ./pkg/stream_controller/ -v -run Test_PrivacySentinel
+func Test_PrivacySentinel(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(time.Millisecond)()
+	const route = "/code-completion/api/v1/completions"
+
+	tokenizer, err := tokenizers.NewTokenizer("../completions/testdata/tokenizer/tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []bool{false, true} {
+		restore := setupPrivacy(private)
+		sentinel := privacySentinel()
+		llm := &echoLLM{cfg: config.ModelConfig{ModelName: "echo", MaxConcurrent: 1, MaxOutput: 50, MaxPrefix: 1000,
+			MaxSuffix: 500, DisablePrune: true}, sentinel: sentinel, tokenizer: tokenizer}
+		sc := newPrivacyController(llm, sentinel)
+		for _, id := range []string{"ok", "ok", "fail"} {
+			sc.ProcessCompletionOnce(context.Background(), route, newSentinelInput(id, sentinel))
+		}
+		// 后台获取的代码上下文和建立的分词缓存写入后再检查
+		sc.prefetch.Wait()
+		completions.PrefixCache().Wait()
+
+		found := store.FindText(sentinel)
+		if private && len(found) > 0 {
+			t.Errorf("privacy mode: sentinel retained in %v", found)
+		}
+		for _, name := range []string{"completion_dedup", "error_journal", "quality_samples", "prefetched_contexts"} {
+			if !private && !slices.Contains(found, name) {
+				t.Errorf("expected the sentinel in %s with privacy mode off, found in %v", name, found)
+			}
+		}
+		// 隐私模式下去重不重放，重复的请求重新处理
+		if calls := atomic.LoadInt32(&llm.calls); private && calls != 3 || !private && calls != 2 {
+			t.Errorf("privacy %v: expected the model called %d times, got %d", private, map[bool]int{false: 2, true: 3}[private], calls)
+		}
+		// 样本只有摘要，不能重放
+		samples := sc.samples.query(SampleFilter{})
+		if len(samples) != 2 || samples[1].Redacted != private {
+			t.Fatalf("privacy %v: unexpected samples %+v", private, samples)
+		}
+		if _, err := sc.Replay(context.Background(), "ok", "echo"); private && !errors.Is(err, ErrNotCaptured) {
+			t.Errorf("expected a redacted sample not replayable, got %v", err)
+		}
+		if private && samples[1].Raw != store.Digest(sentinel+"()") {
+			t.Errorf("expected the digest of the completion, got %q", samples[1].Raw)
+		}
+		restore()
+	}
+}
+
+// to benchmark a completion with privacy mode off and on, the stripped mode should cost about the same
+// go test ./pkg/stream_controller/ -bench Benchmark_PrivacyMode -run XXX
+func Benchmark_PrivacyMode(b *testing.B) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	const route = "/code-completion/api/v1/completions"
+	for _, private := range []bool{false, true} {
+		b.Run(fmt.Sprintf("privacy=%v", private), func(b *testing.B) {
+			defer setupPrivacy(private)()
+			sentinel := privacySentinel()
+			llm := &echoLLM{cfg: config.ModelConfig{ModelName: "echo", MaxConcurrent: 1, MaxOutput: 50, DisablePrune: true},
+				sentinel: sentinel}
+			sc := newPrivacyController(llm, sentinel)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sc.ProcessCompletionOnce(context.Background(), route, newSentinelInput(fmt.Sprintf("ok-%d", i), sentinel))
+			}
+		})
+	}
+}
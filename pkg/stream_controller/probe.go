@@ -0,0 +1,251 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+//	定时自测探针: 用户流量低时(如夜间)也能发现只在完整流程中出现的延迟退化(分词器、修剪、模型池争用等)
+//
+
+// 探针请求的客户端ID前缀，后接模型名称
+const ProbeClientPrefix = "probe:"
+
+// 探针使用的内置提示词，有代表性的中等长度Go代码，不含用户数据
+const (
+	probeLanguage = "go"
+	probeFilePath = "internal/probe/cache.go"
+	probePrefix   = `package probe
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache is a concurrency-safe cache whose entries expire after ttl
+type Cache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]entry
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// NewCache creates an empty cache
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: make(map[string]entry)}
+}
+
+// Get returns the value of key if it has not expired
+func (c *Cache) Get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+	return e.value, true
+}
+
+// Put stores value under key
+func (c *Cache) Put(key, value string) {
+	`
+	probeSuffix = `
+}
+
+// Len returns the number of entries, including expired ones
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+`
+)
+
+/**
+ * 一次探测的结果，通过/api/stats的probes查看
+ * @description
+ * - Status: 补全状态，模型池饱和而跳过时为saturated
+ * - TotalMs: 端到端耗时，QueueMs/LLMMs为其中排队和调用模型的耗时，跳过时都为0
+ */
+type ProbeResult struct {
+	Time    time.Time `json:"time"`
+	Status  string    `json:"status"`
+	TotalMs int64     `json:"totalMs"`
+	QueueMs int64     `json:"queueMs"`
+	LLMMs   int64     `json:"llmMs"`
+	Error   string    `json:"error,omitempty"`
+}
+
+/**
+ * 定时自测探针
+ * @description
+ * - 每隔Interval向每个模型发送一个内置提示词的补全请求，请求标记为Probe，按最低优先级(batch)调度
+ * - 模型池饱和时跳过该模型，探针不与用户请求争抢并发槽位
+ * - 每个模型保留最近History次结果
+ */
+type prober struct {
+	mutex   sync.Mutex
+	cfg     *config.ProbeConfig
+	sc      *StreamController
+	clock   func() time.Time
+	seq     atomic.Uint64
+	history map[string][]ProbeResult // 各模型最近的探测结果，按时间顺序
+}
+
+// 创建自测探针，clock为nil时使用time.Now
+func newProber(cfg *config.ProbeConfig, sc *StreamController, clock func() time.Time) *prober {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &prober{
+		cfg:     cfg,
+		sc:      sc,
+		clock:   clock,
+		history: make(map[string][]ProbeResult),
+	}
+}
+
+// 探测的模型：各模型池的模型名称，同名的模型池只探测一次
+func (p *prober) models() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, pool := range p.sc.pools.allPools() {
+		if name := pool.cfg.ModelName; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 依次探测每个模型
+func (p *prober) probeAll(ctx context.Context) {
+	for _, name := range p.models() {
+		p.probe(ctx, name)
+	}
+}
+
+/**
+ * 探测一个模型
+ * @param {context.Context} ctx - 请求上下文
+ * @param {string} modelName - 模型名称
+ * @returns {ProbeResult} 返回探测结果
+ * @description
+ * - 模型池饱和时不发送请求，计入completion_probes_total{outcome="saturated"}
+ * - 否则走完整的补全流程，端到端耗时按注入的时钟计算
+ */
+func (p *prober) probe(ctx context.Context, modelName string) ProbeResult {
+	result := ProbeResult{Time: p.clock()}
+	if p.sc.pools.saturated(modelName) {
+		result.Status = metrics.ProbeSaturated
+		metrics.IncrementProbeSkips(modelName)
+		p.record(modelName, result)
+		return result
+	}
+	input := &completions.CompletionInput{
+		CompletionRequest: completions.CompletionRequest{
+			Model:          modelName,
+			LanguageID:     probeLanguage,
+			ClientID:       ProbeClientPrefix + modelName,
+			CompletionID:   fmt.Sprintf("probe-%s-%d", modelName, p.seq.Add(1)),
+			TriggerMode:    "MANUAL",
+			DisableContext: true,
+			Prompts: &completions.PromptOptions{
+				Prefix:          probePrefix,
+				Suffix:          probeSuffix,
+				FileProjectPath: probeFilePath,
+			},
+		},
+		Headers: http.Header{},
+		Probe:   true,
+	}
+	rsp, _ := p.sc.processCompletionV1(ctx, input)
+	result.Status = string(rsp.Status)
+	result.TotalMs = p.clock().Sub(result.Time).Milliseconds()
+	result.QueueMs = rsp.Usage.QueueDuration
+	result.LLMMs = rsp.Usage.LLMDuration
+	result.Error = rsp.Error
+	metrics.ObserveProbe(modelName, result.Status, result.TotalMs)
+	if rsp.Status != model.StatusSuccess {
+		zap.L().Warn("Probe failed", zap.String("model", modelName), zap.String("status", result.Status),
+			zap.String("error", rsp.Error))
+	}
+	p.record(modelName, result)
+	return result
+}
+
+// 保存探测结果，每个模型只保留最近History次
+func (p *prober) record(modelName string, result ProbeResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	results := append(p.history[modelName], result)
+	if n := max(p.cfg.History, 1); len(results) > n {
+		results = append([]ProbeResult(nil), results[len(results)-n:]...)
+	}
+	p.history[modelName] = results
+}
+
+// 定时探测，未启用时不处理，done关闭时退出
+func (p *prober) run(done <-chan struct{}) {
+	if p == nil || !p.cfg.Enabled || p.cfg.Interval <= 0 {
+		return
+	}
+	go func() {
+		defer DumpOnPanic()
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.probeAll(context.Background())
+			case <-done:
+				return
+			}
+		}
+	}()
+	zap.L().Info("Start self-benchmark probe", zap.Duration("interval", p.cfg.Interval))
+}
+
+// 各模型最近的探测结果
+func (p *prober) state() map[string][]ProbeResult {
+	states := make(map[string][]ProbeResult)
+	if p == nil {
+		return states
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for name, results := range p.history {
+		states[name] = append([]ProbeResult(nil), results...)
+	}
+	return states
+}
+
+// 模型的模型池是否饱和：最空闲的模型池也没有空闲的并发槽位，或已有排队的请求
+func (m *PoolManager) saturated(modelName string) bool {
+	pools, ok := m.lookup(modelName)
+	if !ok {
+		return true
+	}
+	pool := m.findIdlestPool(pools)
+	if pool == nil {
+		return true
+	}
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	return len(pool.runnings) >= pool.cfg.MaxConcurrent || pool.waits.Len() > 0
+}
@@ -0,0 +1,115 @@
+package stream_controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricCount returns the count of a counter or histogram series with the given labels
+func metricCount(t *testing.T, name string, labels map[string]string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count uint64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if v, ok := labels[label.GetName()]; ok && v != label.GetValue() {
+					continue next
+				}
+			}
+			if m.GetHistogram() != nil {
+				count += m.GetHistogram().GetSampleCount()
+			} else {
+				count += uint64(m.GetCounter().GetValue())
+			}
+		}
+	}
+	return count
+}
+
+// to test the prober against the fake model with the injected clock, and skipping a saturated pool
+// go test ./pkg/stream_controller/ -v -run Test_Probe
+func Test_Probe(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "probe-fake", MaxConcurrent: 2, MaxOutput: 50, DisablePrune: true}, text: "c.entries[key] = entry{value: value}"}
+	busy := newFakeLLM(1)
+	busy.cfg.ModelName = "probe-busy"
+	m := NewPoolManager()
+	m.initPool("probe-fake", llm, llm.Config())
+	m.initPool("probe-busy", busy, busy.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, context: codebase_context.NewContextClientWith(&fakeSearchClient{})}
+	now := time.Unix(1000, 0)
+	sc.probe = newProber(&config.ProbeConfig{Enabled: true, Interval: time.Minute, History: 3}, sc,
+		func() time.Time {
+			now = now.Add(40 * time.Millisecond)
+			return now
+		})
+
+	// 模型池饱和时跳过，不占用并发槽位
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := newTestRequest("L1", 20)
+		req.Para.Model = "probe-busy"
+		defer req.cancel()
+		m.WaitDoRequest(req)
+	}()
+	<-busy.started
+
+	requests := metricCount(t, "completion_requests_total", map[string]string{"model": "probe-fake"})
+	dispatches := metricCount(t, "completion_tenant_dispatches_total", map[string]string{"model": "probe-fake"})
+	for i := 0; i < 5; i++ {
+		sc.probe.probeAll(context.Background())
+	}
+	state := sc.probe.state()
+	if results := state["probe-fake"]; len(results) != 3 || results[2].Status != string(model.StatusSuccess) || results[2].TotalMs != 40 {
+		t.Errorf("expected the last 3 successful probes of 40ms, got %+v", results)
+	}
+	if results := state["probe-busy"]; len(results) != 3 || results[0].Status != metrics.ProbeSaturated {
+		t.Errorf("expected probes skipped on the saturated pool, got %+v", results)
+	}
+	if order := busy.getOrder(); len(order) != 1 {
+		t.Errorf("expected no probe sent to the saturated pool, got %v", order)
+	}
+	close(busy.release)
+	<-done
+
+	// 探针有自己的指标，不计入补全请求的指标
+	if n := metricCount(t, "completion_probes_total", map[string]string{"model": "probe-fake", "outcome": "success"}); n != 5 {
+		t.Errorf("expected 5 successful probes counted, got %d", n)
+	}
+	if n := metricCount(t, "completion_probe_duration_milliseconds", map[string]string{"model": "probe-fake"}); n != 5 {
+		t.Errorf("expected 5 probe durations observed, got %d", n)
+	}
+	if n := metricCount(t, "completion_probes_total", map[string]string{"model": "probe-busy", "outcome": metrics.ProbeSaturated}); n != 5 {
+		t.Errorf("expected 5 saturated probes counted, got %d", n)
+	}
+	if n := metricCount(t, "completion_requests_total", map[string]string{"model": "probe-fake"}); n != requests {
+		t.Errorf("expected probes excluded from completion_requests_total, got %d more", n-requests)
+	}
+	if n := metricCount(t, "completion_tenant_dispatches_total", map[string]string{"model": "probe-fake"}); n != dispatches {
+		t.Errorf("expected probes excluded from the tenant dispatches, got %d more", n-dispatches)
+	}
+	pools, _ := m.lookup("probe-fake")
+	if stats := pools[0].waits.TenantStats(); len(stats) != 0 {
+		t.Errorf("expected probes excluded from the tenant stats, got %v", stats)
+	}
+	if _, ok := sc.queues.clients.Get(ProbeClientPrefix + "probe-fake"); !ok {
+		t.Error("expected the probes queued under the probe client id")
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"code-completion/pkg/config"
 	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
+	"code-completion/pkg/store"
 	"context"
+	"sort"
 	"sync"
 	"time"
+	"unsafe"
 
 	"go.uber.org/zap"
 )
@@ -18,22 +21,35 @@ import (
 
 // 客户端
 type CompletionClient struct {
-	ClientID   string
-	Latest     *ClientRequest
-	LatestTime time.Time
+	ClientID       string
+	Latest         *ClientRequest
+	LatestTime     time.Time
+	ServerSequence uint64 // 最近返回的响应分配的序号
+	ServedSequence int64  // 已返回的响应中最大的client_sequence
 }
 
 // 等待队列管理器
 type QueueManager struct {
-	clients  map[string]*CompletionClient
+	clients  *store.Store[string, *CompletionClient] // 客户端字段的读写需持有mutex
 	requests map[string]*ClientRequest
 	mutex    sync.RWMutex
 }
 
-// 创建等待队列管理器
+// 创建等待队列管理器，长时间没有活动的客户端由定时维护任务清理
 func NewQueueManager() *QueueManager {
 	return &QueueManager{
-		clients:  make(map[string]*CompletionClient),
+		clients: store.New(store.Options[string, *CompletionClient]{
+			Name:     "clients",
+			TTL:      config.Config.StreamController.CleanOlderThan,
+			Metadata: true, // Latest只在请求处理期间引用请求，见RemoveRequest
+			Sizer: func(clientID string, _ *CompletionClient) int {
+				return len(clientID) + int(unsafe.Sizeof(CompletionClient{}))
+			},
+			OnEvict: func(_ string, client *CompletionClient, reason store.EvictReason) {
+				zap.L().Info("Removed client", zap.String("clientID", client.ClientID),
+					zap.Time("latestTime", client.LatestTime), zap.String("reason", string(reason)))
+			},
+		}),
 		requests: make(map[string]*ClientRequest),
 	}
 }
@@ -42,28 +58,33 @@ func NewQueueManager() *QueueManager {
 func (m *QueueManager) AddRequest(ctx context.Context, para *model.CompletionParameter, perf *completions.CompletionPerformance) *ClientRequest {
 	reqCtx, cancel := context.WithTimeout(ctx, config.Config.StreamController.CompletionTimeout)
 	req := &ClientRequest{
-		Para:     para,
-		Perf:     perf,
-		Canceled: false,
-		ctx:      reqCtx,
-		cancel:   cancel,
-		rspChan:  make(chan *completions.CompletionResponse, 1),
+		Para:    para,
+		Perf:    perf,
+		Size:    classifyPrompt(para, &config.Config.StreamController),
+		Tenant:  resolveTenant(&config.Config.StreamController.Fairness, para.Tenant),
+		ctx:     reqCtx,
+		cancel:  cancel,
+		rspChan: make(chan *completions.CompletionResponse, 1),
 	}
 	req.Perf.EnqueueTime = time.Now().Local()
+	// 截止时间监视：请求上下文结束时立即记录原因，超时按此刻所处的阶段区分
+	req.stopWatch = context.AfterFunc(reqCtx, func() { req.settle() })
 
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	client, exists := m.clients[para.ClientID]
+	client, exists := m.clients.Get(para.ClientID)
 	if !exists {
 		client = &CompletionClient{
 			ClientID: para.ClientID,
 		}
-		m.clients[para.ClientID] = client
 	}
+	// 每次写入重新开始计算客户端的存活时间
+	m.clients.Put(client.ClientID, client)
 	client.LatestTime = req.Perf.ReceiveTime
+	// 同一客户端的新请求抢占还在排队或执行的旧请求
 	if client.Latest != nil {
-		m.cancelRequest(client.Latest)
+		m.cancelRequest(client.Latest, completions.CancelSuperseded)
 		client.Latest = nil
 	}
 	client.Latest = req
@@ -74,13 +95,16 @@ func (m *QueueManager) AddRequest(ctx context.Context, para *model.CompletionPar
 }
 
 func (m *QueueManager) RemoveRequest(req *ClientRequest) {
+	if req.stopWatch != nil {
+		req.stopWatch()
+	}
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	delete(m.requests, req.Para.ClientID+req.Para.CompletionID)
 	metrics.UpdateCompletionConcurrent(len(m.requests))
 
-	queue, exists := m.clients[req.Para.ClientID]
+	queue, exists := m.clients.Get(req.Para.ClientID)
 	if !exists {
 		return
 	}
@@ -89,15 +113,69 @@ func (m *QueueManager) RemoveRequest(req *ClientRequest) {
 	}
 }
 
-// 取消现有请求
-func (m *QueueManager) cancelRequest(req *ClientRequest) {
-	zap.L().Debug("Cancel request",
-		zap.String("clientID", req.Para.ClientID),
-		zap.String("completionID", req.Para.CompletionID))
-	if req.cancel != nil {
-		req.cancel()
+/**
+ * 给返回给客户端的响应分配序号
+ * @param {string} clientID - 客户端ID，为空时不处理
+ * @param {int64} clientSequence - 请求中的client_sequence，0表示插件没有提供
+ * @param {*completions.CompletionResponse} rsp - 即将返回的响应
+ * @description
+ * - server_sequence按响应返回的顺序递增，插件收到的序号不递增时说明响应在传输中乱序
+ * - client_sequence小于该客户端已返回的最大值时标记superseded，插件应丢弃该响应
+ * - 序号保存在客户端记录中，每个客户端只有两个计数，客户端过期清理后从头开始
+ */
+func (m *QueueManager) Sequence(clientID string, clientSequence int64, rsp *completions.CompletionResponse) {
+	if clientID == "" {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	client, exists := m.clients.Get(clientID)
+	if !exists {
+		// 没有进入排队就被拒绝的请求
+		client = &CompletionClient{ClientID: clientID, LatestTime: time.Now().Local()}
+		m.clients.Put(client.ClientID, client)
+	}
+	client.ServerSequence++
+	rsp.ServerSequence = client.ServerSequence
+	rsp.ClientSequence = clientSequence
+	if clientSequence <= 0 {
+		return
+	}
+	if clientSequence < client.ServedSequence {
+		rsp.Superseded = true
+		zap.L().Debug("Superseded completion response", zap.String("clientID", clientID),
+			zap.String("completionID", rsp.ID), zap.Int64("clientSequence", clientSequence),
+			zap.Int64("servedSequence", client.ServedSequence))
+		return
 	}
-	req.Canceled = true
+	client.ServedSequence = clientSequence
+}
+
+// 以指定原因取消现有请求，调用者需持有mutex
+func (m *QueueManager) cancelRequest(req *ClientRequest, cause completions.CancelCause) {
+	req.cancelWith(cause)
+}
+
+/**
+ * 以指定原因取消符合条件的请求
+ * @param {completions.CancelCause} cause - 取消原因
+ * @param {func(*ClientRequest) bool} match - 选择要取消的请求
+ * @returns {int} 返回取消的请求数，已取消的请求不计
+ */
+func (m *QueueManager) CancelMatching(cause completions.CancelCause, match func(*ClientRequest) bool) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	canceled := 0
+	for _, req := range m.requests {
+		if req.Canceled.Load() || !match(req) {
+			continue
+		}
+		m.cancelRequest(req, cause)
+		canceled++
+	}
+	return canceled
 }
 
 // 清理过期的队列
@@ -106,14 +184,7 @@ func (m *QueueManager) Cleanup() {
 	defer m.mutex.Unlock()
 
 	// 清理长时间没有活动的客户端
-	currentTime := time.Now()
-	for _, client := range m.clients {
-		if currentTime.Sub(client.LatestTime) > config.Config.StreamController.CleanOlderThan {
-			delete(m.clients, client.ClientID)
-			zap.L().Info("Removed client", zap.String("clientID", client.ClientID),
-				zap.Time("latestTime", client.LatestTime))
-		}
-	}
+	m.clients.Expire()
 }
 
 // 获取统计信息
@@ -122,58 +193,81 @@ func (m *QueueManager) GetStats() map[string]interface{} {
 	defer m.mutex.RUnlock()
 
 	activatedClient := 0
-	for _, client := range m.clients {
+	totalClient := 0
+	m.clients.Range(func(_ string, client *CompletionClient) bool {
+		totalClient++
 		if client.Latest != nil {
 			activatedClient++
 		}
-	}
+		return true
+	})
 	stats := make(map[string]interface{})
 	stats["requests"] = map[string]interface{}{
 		"total": len(m.requests),
 	}
 	stats["clients"] = map[string]interface{}{
 		"activated": activatedClient,
-		"total":     len(m.clients),
+		"total":     totalClient,
 	}
 
 	return stats
 }
 
-// 获取统计信息
-func (m *QueueManager) GetDetails() map[string]interface{} {
+/**
+ * 获取等待队列的明细
+ * @param {DetailsFilter} filter - 字段选择和分页条件
+ * @returns {map[string]interface{}} 返回客户端和请求的明细
+ * @description
+ * - 客户端按最近活动时间从新到旧排序，相同时按客户端ID排序
+ * - 请求按接收时间从早到晚排序，见sortRequests
+ * - total和activated是分页前的数量
+ */
+func (m *QueueManager) GetDetails(filter DetailsFilter) map[string]interface{} {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
 	activatedClient := 0
-	clients := []map[string]interface{}{}
-	for _, client := range m.clients {
+	all := []*CompletionClient{}
+	m.clients.Range(func(_ string, client *CompletionClient) bool {
 		if client.Latest != nil {
 			activatedClient++
-			clients = append(clients, map[string]interface{}{
-				"client_id":   client.ClientID,
-				"latest":      client.Latest.GetSummary(),
-				"latest_time": client.LatestTime,
-			})
-		} else {
-			clients = append(clients, map[string]interface{}{
-				"client_id":   client.ClientID,
-				"latest_time": client.LatestTime,
-			})
 		}
+		all = append(all, client)
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].LatestTime.Equal(all[j].LatestTime) {
+			return all[i].LatestTime.After(all[j].LatestTime)
+		}
+		return all[i].ClientID < all[j].ClientID
+	})
+	clients := []map[string]interface{}{}
+	for _, client := range paginate(all, filter) {
+		info := map[string]interface{}{
+			"client_id":       client.ClientID,
+			"latest_time":     client.LatestTime,
+			"server_sequence": client.ServerSequence,
+			"served_sequence": client.ServedSequence,
+		}
+		if client.Latest != nil {
+			info["latest"] = client.Latest.GetSummary()
+		}
+		clients = append(clients, info)
 	}
-	requests := []map[string]interface{}{}
+	requests := make([]*ClientRequest, 0, len(m.requests))
 	for _, req := range m.requests {
-		requests = append(requests, req.GetDetails())
+		requests = append(requests, req)
 	}
+	sortRequests(requests)
 
 	return map[string]interface{}{
 		"requests": map[string]interface{}{
 			"total":   len(m.requests),
-			"details": requests,
+			"details": requestDetails(paginate(requests, filter), filter),
 		},
 		"clients": map[string]interface{}{
 			"activated": activatedClient,
-			"total":     len(m.clients),
+			"total":     len(all),
 			"details":   clients,
 		},
 	}
@@ -0,0 +1,98 @@
+package stream_controller
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 检索请求中包含slow的请求延迟返回，started在第一次遇到慢请求时关闭
+type slowSearchClient struct {
+	fakeSearchClient
+	delay   time.Duration
+	started chan struct{}
+}
+
+func (f *slowSearchClient) Do(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+	}
+	if strings.Contains(req.URL.RawQuery+body, "slow") {
+		select {
+		case <-f.started:
+		default:
+			close(f.started)
+		}
+		time.Sleep(f.delay)
+	}
+	return f.fakeSearchClient.Do(req)
+}
+
+// 带client_sequence的请求
+func newSequenceInput(completionID, file string, sequence int64) *completions.CompletionInput {
+	input := newDedupInput("client-seq", completionID)
+	input.Prompts.FileProjectPath = file
+	input.ClientSequence = sequence
+	return input
+}
+
+// to test a response finishing after a newer request of the same client is flagged superseded, and the state resets with the client
+// go test ./pkg/stream_controller/ -v -run Test_ResponseSequence
+func Test_ResponseSequence(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 2, MaxOutput: 50, DisablePrune: true}, text: "ok"}
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	search := &slowSearchClient{delay: 200 * time.Millisecond, started: make(chan struct{})}
+	sc := &StreamController{queues: NewQueueManager(), pools: m, context: codebase_context.NewContextClientWith(search)}
+
+	// 较早的请求在上游检索时变慢，较新的请求先返回
+	earlier := make(chan *completions.CompletionResponse, 1)
+	go func() {
+		earlier <- sc.ProcessCompletionV1(context.Background(), newSequenceInput("S1", "src/slow.js", 1))
+	}()
+	<-search.started
+	newer := sc.ProcessCompletionV1(context.Background(), newSequenceInput("S2", "src/main.js", 2))
+	older := <-earlier
+
+	if older.Status != model.StatusSuccess || newer.Status != model.StatusSuccess {
+		t.Fatalf("expected both completions to succeed, got %s and %s", older.Status, newer.Status)
+	}
+	if newer.Superseded || newer.ClientSequence != 2 || newer.ServerSequence != 1 {
+		t.Errorf("newer response should be served first and kept, got superseded=%v client=%d server=%d",
+			newer.Superseded, newer.ClientSequence, newer.ServerSequence)
+	}
+	if !older.Superseded || older.ClientSequence != 1 || older.ServerSequence != 2 {
+		t.Errorf("older response should be superseded, got superseded=%v client=%d server=%d",
+			older.Superseded, older.ClientSequence, older.ServerSequence)
+	}
+
+	// 按顺序的请求和没有client_sequence的请求不标记
+	next := sc.ProcessCompletionV1(context.Background(), newSequenceInput("S3", "src/main.js", 3))
+	plain := sc.ProcessCompletionV1(context.Background(), newSequenceInput("S4", "src/main.js", 0))
+	if next.Superseded || next.ServerSequence != 3 || plain.Superseded || plain.ServerSequence != 4 {
+		t.Errorf("in-order responses should not be superseded, got %v/%d and %v/%d",
+			next.Superseded, next.ServerSequence, plain.Superseded, plain.ServerSequence)
+	}
+
+	// 客户端过期清理后序号从头开始
+	sc.queues.mutex.Lock()
+	sc.queues.clients.Delete("client-seq")
+	sc.queues.mutex.Unlock()
+	restarted := sc.ProcessCompletionV1(context.Background(), newSequenceInput("S5", "src/main.js", 1))
+	if restarted.Superseded || restarted.ServerSequence != 1 {
+		t.Errorf("sequence state should reset with the client, got superseded=%v server=%d",
+			restarted.Superseded, restarted.ServerSequence)
+	}
+}
@@ -0,0 +1,126 @@
+package stream_controller
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+/**
+ * 令牌桶，限制发往模型后端的请求速率
+ * @description
+ * - 每秒补充rate个令牌，最多积累burst个
+ * - 等待中的请求预约令牌，tokens可能为负，表示已被预约的未来令牌
+ * - 放弃等待的请求归还预约，不消耗令牌
+ */
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64   // 每秒补充的令牌数
+	burst  float64   // 桶容量
+	tokens float64   // 当前令牌数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// 创建令牌桶，rate不大于0时不限流，返回nil
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// 按流逝的时间补充令牌，调用者需持有mutex
+func (b *tokenBucket) advance(now time.Time) {
+	if !now.After(b.last) {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// 预约一个令牌，返回拿到令牌前需等待的时长；需等待的时长超过budget时不预约，返回false
+func (b *tokenBucket) reserve(now time.Time, budget time.Duration) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.advance(now)
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if wait > budget {
+		return 0, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// 归还未使用的预约
+func (b *tokenBucket) cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+/**
+ * Wait for a token before sending a request to the model backend
+ * @param {context.Context} ctx - Request context, its deadline is the budget of waiting
+ * @returns {bool} Returns true if a token is taken, false if the request should fail fast
+ * @description
+ * - Returns false immediately when the token would not be available before the deadline
+ * - A waiter cancelled or expired while waiting gives its reservation back
+ * @example
+ * if !bucket.Wait(req.ctx) {
+ *     // throttled
+ * }
+ */
+func (b *tokenBucket) Wait(ctx context.Context) bool {
+	budget := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		budget = time.Until(deadline)
+	}
+	wait, ok := b.reserve(time.Now(), budget)
+	if !ok {
+		return false
+	}
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		b.cancel()
+		return false
+	}
+}
+
+// 当前桶内可用的令牌数，已被预约的令牌不计入
+func (b *tokenBucket) Level() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.advance(time.Now())
+	if b.tokens < 0 {
+		return 0
+	}
+	return b.tokens
+}
@@ -0,0 +1,167 @@
+package stream_controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test the outbound rate is respected under a burst of cheap requests
+// go test ./pkg/stream_controller/ -v -run Test_RateLimitRespected
+func Test_RateLimitRespected(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := newFakeLLM(10)
+	llm.cfg.RateLimit = 50
+	llm.cfg.RateBurst = 2
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		req := newTestRequest(fmt.Sprintf("S%d", i), 8)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer req.cancel()
+			if rsp := m.WaitDoRequest(req); rsp.Status != model.StatusSuccess {
+				t.Errorf("request %s failed: %s", req.Para.CompletionID, rsp.Status)
+			}
+		}()
+	}
+	wg.Wait()
+	// 2 requests pass with the burst, the other 8 wait 20ms each
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("expected requests to be throttled to 50/s, all done in %v", elapsed)
+	}
+	if n := len(llm.getOrder()); n != 10 {
+		t.Errorf("expected 10 model calls, got %d", n)
+	}
+}
+
+// to test requests fail fast when no token is available before the deadline
+// go test ./pkg/stream_controller/ -v -run Test_RateLimitFailFast
+func Test_RateLimitFailFast(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := newFakeLLM(1)
+	llm.cfg.RateLimit = 1
+	llm.cfg.RateBurst = 1
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+
+	first := newTestRequest("S1", 8)
+	defer first.cancel()
+	if rsp := m.WaitDoRequest(first); rsp.Status != model.StatusSuccess {
+		t.Fatalf("first request failed: %s", rsp.Status)
+	}
+
+	second := newTestRequest("S2", 8)
+	second.cancel()
+	second.ctx, second.cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer second.cancel()
+	start := time.Now()
+	if rsp := m.WaitDoRequest(second); rsp.Status != model.StatusBusy {
+		t.Errorf("expected busy, got %s", rsp.Status)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected fail fast, took %v", elapsed)
+	}
+	if order := llm.getOrder(); len(order) != 1 {
+		t.Errorf("expected throttled request not sent, got calls %v", order)
+	}
+}
+
+// to test that waiters giving up don't consume tokens
+// go test ./pkg/stream_controller/ -v -run Test_ExpiredWaiterKeepsToken
+func Test_ExpiredWaiterKeepsToken(t *testing.T) {
+	b := newTokenBucket(1, 1)
+	if !b.Wait(context.Background()) {
+		t.Fatal("expected the burst token to be taken")
+	}
+
+	// the deadline is earlier than the next token
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if b.Wait(ctx) {
+		t.Error("expected waiter to fail before the deadline")
+	}
+
+	// the waiter is cancelled while waiting for its reservation
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if b.Wait(ctx) {
+		t.Error("expected cancelled waiter to fail")
+	}
+
+	b.mutex.Lock()
+	tokens := b.tokens
+	b.mutex.Unlock()
+	if tokens < 0 {
+		t.Errorf("expected no token consumed by failed waiters, got %.2f", tokens)
+	}
+}
+
+// to test that the resend of a queued request and the OpenAI route both take tokens from the pool's rate limit
+// go test ./pkg/stream_controller/ -v -run Test_RateLimitEverySend
+func Test_RateLimitEverySend(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	var sent atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Add(1)
+		if r.Header.Get("Authorization") != "Bearer server" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"model":"limited","choices":[{"text":"return a + b","index":0,"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+	cfg := &config.ModelConfig{Provider: "openai", ModelTitle: "limited", ModelName: "limited",
+		CompletionsUrl: server.URL, Authorization: "Bearer server", AuthMode: config.AuthModeBothFallback,
+		Timeout: time.Second, MaxOutput: 64, MaxConcurrent: 1, RateLimit: 0.01, RateBurst: 3}
+	m := NewPoolManager()
+	pool := m.initPool("limited", model.NewOpenAIModel(cfg, nil), cfg)
+	sc := &StreamController{queues: NewQueueManager(), pools: m}
+
+	// 用户的认证信息被拒绝后用配置的认证信息重发，两次发送各消耗一个令牌
+	req := newTestRequest("S1", 8)
+	defer req.cancel()
+	req.Para.Model, req.Para.Authorization = "limited", "Bearer user"
+	if rsp := m.WaitDoRequest(req); rsp.Status != model.StatusSuccess {
+		t.Fatalf("queued request failed: %s", rsp.Status)
+	}
+	// OpenAI接口不经过排队，同样消耗令牌
+	openai := func() *completions.CompletionResponse {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return sc.ProcessCompletionOpenAI(ctx, &model.CompletionRequest{Model: "limited", Prompt: "func add(a, b int) int {", MaxTokens: 16})
+	}
+	if rsp := openai(); rsp.Status != model.StatusSuccess {
+		t.Fatalf("openai request failed: %s", rsp.Status)
+	}
+	if n := sent.Load(); n != 3 {
+		t.Errorf("expected 3 sends upstream, got %d", n)
+	}
+	if level := pool.limiter.Level(); level >= 1 {
+		t.Errorf("expected the burst used up, %.2f tokens left", level)
+	}
+
+	if rsp := openai(); rsp.Status != model.StatusBusy {
+		t.Errorf("expected the openai request throttled, got %s", rsp.Status)
+	}
+	if n := sent.Load(); n != 3 {
+		t.Errorf("expected the throttled request not sent, got %d sends", n)
+	}
+}
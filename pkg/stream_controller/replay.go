@@ -0,0 +1,179 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// 重放的结果，用作completion_replays_total的outcome标签
+const (
+	ReplayReproduced  = "reproduced"   // 重放的补全与原补全相同
+	ReplayChanged     = "changed"      // 重放的补全与原补全不同
+	ReplayNotCaptured = "not_captured" // 原补全没有保存样本，无法重放
+	ReplayFailed      = "failed"       // 指定的模型不存在
+)
+
+// 重放请求的客户端ID前缀，重放不会取消该客户端正在进行的补全
+const ReplayClientPrefix = "replay:"
+
+// 原补全没有保存样本(没有开启采样、没有命中采样率、客户端没有同意采样或样本已过期)，或隐私模式下样本只有摘要
+var ErrNotCaptured = errors.New("completion not captured")
+
+// 一次补全的结果，Text为后置处理后返回给客户端的补全内容
+type ReplayOutcome struct {
+	Model   string                 `json:"model"`
+	Status  model.CompletionStatus `json:"status"`
+	Raw     string                 `json:"raw"`
+	Text    string                 `json:"text"`
+	Hits    []string               `json:"hits,omitempty"`
+	TotalMs int64                  `json:"totalMs"`
+}
+
+/**
+ * 原补全与重放的补全的差异
+ * @description
+ * - CommonPrefix: 两次补全内容(Text)相同的前缀字节数，用于定位开始不同的位置
+ * - HitsAdded/HitsRemoved: 只在重放/只在原补全中命中的后置处理器
+ */
+type ReplayDiff struct {
+	StatusChanged bool     `json:"statusChanged"`
+	RawChanged    bool     `json:"rawChanged"`
+	TextChanged   bool     `json:"textChanged"`
+	CommonPrefix  int      `json:"commonPrefix"`
+	HitsAdded     []string `json:"hitsAdded,omitempty"`
+	HitsRemoved   []string `json:"hitsRemoved,omitempty"`
+}
+
+// 重放的结果，原补全和重放的补全并列，附带差异
+type ReplayResult struct {
+	CompletionID string        `json:"completionId"`
+	ReplayID     string        `json:"replayId"`
+	Original     ReplayOutcome `json:"original"`
+	Replay       ReplayOutcome `json:"replay"`
+	Diff         ReplayDiff    `json:"diff"`
+}
+
+/**
+ * 按保存的样本重放一次补全
+ * @param {context.Context} ctx - 请求上下文
+ * @param {string} completionID - 原补全的completion_id
+ * @param {string} modelName - 重放使用的模型，为空时使用原补全的模型
+ * @returns {*ReplayResult} 返回原补全和重放的补全，以及差异
+ * @returns {error} 原补全没有保存样本时返回ErrNotCaptured，模型不存在时返回ErrPoolNotFound
+ * @description
+ * - 由样本中截断后的提示词(含代码上下文)重建请求，不再获取代码上下文
+ * - 重放走完整的预处理、模型调用和后置处理，按最低优先级(batch)调度
+ * - 重放请求标记为Replay，不读写负结果缓存、采纳反馈和风格档案，不计入错误日志、样本、异常检测和预热
+ * - 使用单独的客户端ID，不会取消该客户端正在进行的补全
+ * - 按结果计入completion_replays_total
+ */
+func (sc *StreamController) Replay(ctx context.Context, completionID, modelName string) (*ReplayResult, error) {
+	s, ok := sc.samples.get(completionID)
+	if !ok {
+		metrics.IncrementReplays(modelName, ReplayNotCaptured)
+		return nil, fmt.Errorf("%w: %s", ErrNotCaptured, completionID)
+	}
+	if s.Redacted {
+		metrics.IncrementReplays(modelName, ReplayNotCaptured)
+		return nil, fmt.Errorf("%w: %s (redacted in privacy mode)", ErrNotCaptured, completionID)
+	}
+	if modelName == "" {
+		modelName = s.Model
+	}
+	if _, ok := sc.pools.lookup(modelName); !ok {
+		metrics.IncrementReplays(modelName, ReplayFailed)
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, modelName)
+	}
+	replayID := fmt.Sprintf("%s-replay-%d", completionID, sc.replaySeq.Add(1))
+	input := &completions.CompletionInput{
+		CompletionRequest: completions.CompletionRequest{
+			Model:          modelName,
+			LanguageID:     s.Language,
+			ClientID:       ReplayClientPrefix + s.ClientID,
+			CompletionID:   replayID,
+			TriggerMode:    s.TriggerMode,
+			DisableContext: true,
+			Prompts: &completions.PromptOptions{
+				Prefix:          s.Prompt.Prefix,
+				Suffix:          s.Prompt.Suffix,
+				CodeContext:     s.Prompt.CodeContext,
+				FileProjectPath: s.FilePath,
+			},
+		},
+		Headers: http.Header{},
+		Replay:  true,
+	}
+	flags := feature_flag.ForRequest(input.ClientID, "")
+	rsp, _ := sc.processCompletionV1(feature_flag.WithContext(ctx, flags), input)
+	input.AdviseRetry(flags, rsp)
+
+	result := &ReplayResult{
+		CompletionID: completionID,
+		ReplayID:     replayID,
+		Original: ReplayOutcome{
+			Model:   s.Model,
+			Status:  s.Status,
+			Raw:     s.Raw,
+			Text:    s.Pruned,
+			Hits:    s.Hits,
+			TotalMs: s.TotalMs,
+		},
+		Replay: replayOutcomeOf(input, rsp),
+	}
+	result.Diff = diffReplay(&result.Original, &result.Replay)
+	outcome := ReplayReproduced
+	if result.Diff.StatusChanged || result.Diff.TextChanged {
+		outcome = ReplayChanged
+	}
+	metrics.IncrementReplays(result.Replay.Model, outcome)
+	return result, nil
+}
+
+// 重放的补全结果
+func replayOutcomeOf(input *completions.CompletionInput, rsp *completions.CompletionResponse) ReplayOutcome {
+	o := ReplayOutcome{
+		Model:   rsp.Model,
+		Status:  rsp.Status,
+		Raw:     rsp.Raw,
+		Hits:    rsp.Hits,
+		TotalMs: rsp.Usage.TotalDuration,
+	}
+	if o.Model == "" {
+		o.Model = input.Model
+	}
+	if len(rsp.Choices) > 0 {
+		o.Text = rsp.Choices[0].Text
+	}
+	return o
+}
+
+// 比较原补全和重放的补全
+func diffReplay(original, replay *ReplayOutcome) ReplayDiff {
+	d := ReplayDiff{
+		StatusChanged: original.Status != replay.Status,
+		RawChanged:    original.Raw != replay.Raw,
+		TextChanged:   original.Text != replay.Text,
+	}
+	for d.CommonPrefix < len(original.Text) && d.CommonPrefix < len(replay.Text) &&
+		original.Text[d.CommonPrefix] == replay.Text[d.CommonPrefix] {
+		d.CommonPrefix++
+	}
+	for _, hit := range replay.Hits {
+		if !slices.Contains(original.Hits, hit) {
+			d.HitsAdded = append(d.HitsAdded, hit)
+		}
+	}
+	for _, hit := range original.Hits {
+		if !slices.Contains(replay.Hits, hit) {
+			d.HitsRemoved = append(d.HitsRemoved, hit)
+		}
+	}
+	return d
+}
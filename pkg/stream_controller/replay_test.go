@@ -0,0 +1,75 @@
+package stream_controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// to test a captured completion is replayed against the fake model without touching the stores, and an uncaptured one is reported
+// go test ./pkg/stream_controller/ -v -run Test_Replay
+func Test_Replay(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 2, MaxOutput: 50, DisablePrune: true}, text: "two"}
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, context: codebase_context.NewContextClientWith(&fakeSearchClient{}),
+		samples: newSampleJournal(&config.SamplesConfig{Enabled: true, Rate: 1, Clients: []string{"client-r"},
+			MaxEntries: 100, Retention: time.Hour})}
+
+	// 没有同意采样的客户端不保存提示词，无法重放
+	sc.ProcessCompletionV1(context.Background(), newDedupInput("client-x", "R0"))
+	if _, err := sc.Replay(context.Background(), "R0", ""); !errors.Is(err, ErrNotCaptured) {
+		t.Errorf("expected not captured error, got %v", err)
+	}
+	if _, err := sc.Replay(context.Background(), "missing", ""); !errors.Is(err, ErrNotCaptured) {
+		t.Errorf("expected not captured error for unknown completion, got %v", err)
+	}
+
+	original := sc.ProcessCompletionV1(context.Background(), newDedupInput("client-r", "R1"))
+	if original.Status != model.StatusSuccess {
+		t.Fatalf("expected original completion to succeed, got %s", original.Status)
+	}
+	if _, err := sc.Replay(context.Background(), "R1", "unknown-model"); !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("expected pool not found for unknown model, got %v", err)
+	}
+
+	// 模型的输出变化后重放
+	llm.text = "three"
+	result, err := sc.Replay(context.Background(), "R1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Original.Text != "two" || result.Replay.Text != "three" || result.Replay.Status != model.StatusSuccess {
+		t.Errorf("expected original two and replayed three, got %+v and %+v", result.Original, result.Replay)
+	}
+	if !result.Diff.TextChanged || result.Diff.StatusChanged || result.Diff.CommonPrefix != 1 {
+		t.Errorf("unexpected diff %+v", result.Diff)
+	}
+	if result.ReplayID == "R1" || result.Replay.Model != "fake" {
+		t.Errorf("replay should use its own completion id and the original model, got %+v", result)
+	}
+
+	// 重放不保存样本，不占用原客户端的队列
+	if samples := sc.QuerySamples(SampleFilter{}); len(samples) != 1 || samples[0].CompletionID != "R1" {
+		t.Errorf("replay should not be sampled, got %d samples", len(samples))
+	}
+	if _, ok := sc.queues.clients.Get(ReplayClientPrefix + "client-r"); !ok {
+		t.Errorf("replay should be queued under its own client id")
+	}
+
+	llm.text = "two"
+	result, err = sc.Replay(context.Background(), "R1", "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Diff.TextChanged || result.Diff.RawChanged || len(result.Diff.HitsAdded)+len(result.Diff.HitsRemoved) != 0 {
+		t.Errorf("same model output should reproduce, got %+v", result.Diff)
+	}
+}
@@ -2,19 +2,118 @@ package stream_controller
 
 import (
 	"code-completion/pkg/completions"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
 	"context"
+	"errors"
 	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
 )
 
 // 客户端请求包装器
 type ClientRequest struct {
 	Para     *model.CompletionParameter           // 补全请求参数
 	Perf     *completions.CompletionPerformance   // 性能统计
-	Canceled bool                                 // 请求是否被取消
+	Canceled atomic.Bool                          // 请求是否被取消，排队、调度和取消的协程并发读写
+	Size     SizeClass                            // 请求规模分级，决定调度优先级
+	Tenant   string                               // 调度时所属的租户，见resolveTenant
 	ctx      context.Context                      // 请求关联的协程上下文
 	cancel   context.CancelFunc                   // 可以取消执行请求的协程
 	rspChan  chan *completions.CompletionResponse // 响应通道
+
+	dispatched int32                                   // 是否已被模型池取出执行，在doRequest中设置
+	pool       *ModelPool                              // 预选并经过安全模式判断的模型池，为nil时排队时按模型名称选择最空闲的池
+	served     atomic.Pointer[ModelPool]               // 执行请求的模型池，在doRequest中设置
+	cause      atomic.Pointer[completions.CancelCause] // 请求被取消的原因，只记录先发生的原因
+	stopWatch  func() bool                             // 停止截止时间监视，在RemoveRequest中调用
+}
+
+// 调度时所属的租户，没有设置时为default
+func (r *ClientRequest) tenant() string {
+	if r.Tenant == "" {
+		return DefaultTenant
+	}
+	return r.Tenant
+}
+
+// 是否定时自测探针的请求，探针不计入租户和自动调整并发数的统计
+func (r *ClientRequest) probe() bool {
+	return r.Perf != nil && r.Perf.Probe
+}
+
+// 以指定原因取消请求，已记录原因时保留先发生的原因
+func (r *ClientRequest) cancelWith(cause completions.CancelCause) {
+	if r.cause.CompareAndSwap(nil, &cause) {
+		logger.FromContext(r.ctx).Debug("Cancel request", zap.String("cause", string(cause)))
+	}
+	r.Canceled.Store(true)
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// 已记录的取消原因，没有时返回空
+func (r *ClientRequest) Cause() completions.CancelCause {
+	if cause := r.cause.Load(); cause != nil {
+		return *cause
+	}
+	return ""
+}
+
+/**
+ * 确定请求被取消的原因
+ * @returns {completions.CancelCause} 返回取消原因，请求上下文没有结束时返回空
+ * @description
+ * - 新请求取代、排空、管理接口在取消前已记录原因，直接返回
+ * - 超过截止时间时按此刻是否已被模型池取出，记为deadline_queued或deadline_running
+ * - 其余是上级上下文(HTTP请求)被取消，即客户端断开连接
+ * - 截止时间监视在上下文结束时调用，读取原因前也会调用，结果以先记录的为准
+ */
+func (r *ClientRequest) settle() completions.CancelCause {
+	if cause := r.Cause(); cause != "" {
+		return cause
+	}
+	err := r.ctx.Err()
+	if err == nil {
+		return ""
+	}
+	cause := completions.CancelDisconnected
+	if errors.Is(err, context.DeadlineExceeded) {
+		cause = completions.CancelDeadlineQueued
+		if r.wasDispatched() {
+			cause = completions.CancelDeadlineRunning
+		}
+	}
+	r.cause.CompareAndSwap(nil, &cause)
+	return *r.cause.Load()
+}
+
+// 被取消或超时的响应附带取消原因并计入指标；模型后端自身超时(请求上下文没有结束)时没有原因
+func (r *ClientRequest) attachCause(rsp *completions.CompletionResponse) *completions.CompletionResponse {
+	if rsp.Status != model.StatusCanceled && rsp.Status != model.StatusTimeout {
+		return rsp
+	}
+	if cause := r.settle(); cause != "" {
+		rsp.CancelCause = cause
+		metrics.IncrementCancellations(rsp.Model, string(cause))
+	}
+	return rsp
+}
+
+// 请求是否已被模型池取出执行，请求为nil(没有进入排队)时返回false
+func (r *ClientRequest) wasDispatched() bool {
+	return r != nil && atomic.LoadInt32(&r.dispatched) == 1
+}
+
+// 执行请求的模型池，没有调度执行时为nil
+func (r *ClientRequest) servedBy() *ModelPool {
+	if r == nil {
+		return nil
+	}
+	return r.served.Load()
 }
 
 func (r *ClientRequest) GetDetails() map[string]interface{} {
@@ -43,7 +142,9 @@ func (r *ClientRequest) GetDetails() map[string]interface{} {
 			"line_suffix": lineSuffix,
 		},
 		"performance": r.Perf,
-		"canceled":    r.Canceled,
+		"size":        r.Size,
+		"canceled":    r.Canceled.Load(),
+		"cause":       r.Cause(),
 	}
 }
 
@@ -52,6 +153,6 @@ func (r *ClientRequest) GetSummary() map[string]interface{} {
 		"completion_id": r.Para.CompletionID,
 		"client_id":     r.Para.ClientID,
 		"prompt":        len(r.Para.Prefix) + len(r.Para.Suffix) + len(r.Para.CodeContext),
-		"canceled":      r.Canceled,
+		"canceled":      r.Canceled.Load(),
 	}
 }
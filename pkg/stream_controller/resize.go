@@ -0,0 +1,116 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/config"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 保留的模型池调整审计记录数
+const maxResizeRecords = 100
+
+var ErrPoolNotFound = errors.New("model pool not found")
+
+/**
+ * 模型池并发数调整的审计记录
+ * @description
+ * - Time: 调整时间
+ * - Model: 模型名称
+ * - Instance: 模型池的标识，同一个逻辑模型的各副本分别记录
+ * - From/To: 调整前后的最大并发数
+ * - Operator: 发起调整的一方(如请求来源IP)
+ */
+type PoolResizeRecord struct {
+	Time     time.Time `json:"time"`
+	Model    string    `json:"model"`
+	Instance string    `json:"instance"`
+	From     int       `json:"from"`
+	To       int       `json:"to"`
+	Operator string    `json:"operator"`
+}
+
+/**
+ * Resize the concurrency of all pools serving the model at runtime
+ * @param {string} modelName - Model name of the pools
+ * @param {int} maxConcurrent - New maximum concurrency, in [1, maxPoolConcurrent]
+ * @param {string} operator - Who asked for the change, recorded in the audit trail
+ * @returns {[]PoolResizeRecord, error} Returns a record per resized pool, ErrPoolNotFound if no pool serves the model
+ * @description
+ * - Growing starts new routines taking requests from the wait queue
+ * - Shrinking retires routines as they return, in-flight requests are not interrupted
+ * - MaxConcurrent used by load rate calculations changes under the pool lock
+ * - The auto-tuner of the pool drops its samples, which were taken under the old concurrency
+ * @example
+ * records, err := manager.ResizePools("deepseek-coder", 8, "10.0.0.1")
+ */
+func (m *PoolManager) ResizePools(modelName string, maxConcurrent int, operator string) ([]PoolResizeRecord, error) {
+	limit := config.Config.StreamController.MaxPoolConcurrent
+	if maxConcurrent < 1 || (limit > 0 && maxConcurrent > limit) {
+		return nil, fmt.Errorf("max_concurrent %d out of range [1, %d]", maxConcurrent, limit)
+	}
+	m.resizeMutex.Lock()
+	defer m.resizeMutex.Unlock()
+
+	records := make([]PoolResizeRecord, 0)
+	for _, pool := range m.allPools() {
+		if pool.cfg.ModelName != modelName {
+			continue
+		}
+		from := m.resizePool(pool, maxConcurrent)
+		now := time.Now()
+		pool.tuner.restart(now)
+		record := PoolResizeRecord{
+			Time:     now,
+			Model:    modelName,
+			Instance: pool.instance,
+			From:     from,
+			To:       maxConcurrent,
+			Operator: operator,
+		}
+		records = append(records, record)
+		m.resizes = append(m.resizes, record)
+		zap.L().Info("Resize model pool", zap.String("model", modelName),
+			zap.Int("from", from), zap.Int("to", maxConcurrent),
+			zap.String("operator", operator))
+	}
+	if len(records) == 0 {
+		return nil, ErrPoolNotFound
+	}
+	if len(m.resizes) > maxResizeRecords {
+		m.resizes = m.resizes[len(m.resizes)-maxResizeRecords:]
+	}
+	return records, nil
+}
+
+// resizePool 调整单个模型池的并发数，返回调整前的并发数，调用者需持有resizeMutex
+func (m *PoolManager) resizePool(pool *ModelPool, maxConcurrent int) int {
+	reserved := reservedSmallSlots(maxConcurrent, config.Config.StreamController.SmallReservedRatio)
+
+	pool.mutex.Lock()
+	from := pool.cfg.MaxConcurrent
+	deltaSmall := reserved - pool.reservedSmall
+	deltaGeneral := (maxConcurrent - reserved) - (from - pool.reservedSmall)
+	pool.cfg.MaxConcurrent = maxConcurrent
+	pool.reservedSmall = reserved
+	pool.mutex.Unlock()
+	m.updatePoolConcurrency(pool.cfg.ModelName)
+
+	pool.waits.SetCapacity(maxConcurrent * 2)
+	for i := pool.waits.Resize(true, deltaSmall); i > 0; i-- {
+		go m.LoopDoRequest(pool, true)
+	}
+	for i := pool.waits.Resize(false, deltaGeneral); i > 0; i-- {
+		go m.LoopDoRequest(pool, false)
+	}
+	return from
+}
+
+// 获取模型池调整的审计记录
+func (m *PoolManager) getResizeRecords() []PoolResizeRecord {
+	m.resizeMutex.Lock()
+	defer m.resizeMutex.Unlock()
+	return append([]PoolResizeRecord{}, m.resizes...)
+}
@@ -0,0 +1,147 @@
+package stream_controller
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+func waitWorkers(t *testing.T, pool *ModelPool, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		pool.mutex.RLock()
+		workers := pool.workers
+		pool.mutex.RUnlock()
+		if workers == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d workers, got %d", n, workers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// to test shrinking a busy pool lets in-flight requests finish and converges to the new limit
+// go test ./pkg/stream_controller/ -v -run Test_ShrinkBusyPool
+func Test_ShrinkBusyPool(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := newFakeLLM(4)
+	m := NewPoolManager()
+	pool := m.initPool("fake", llm, llm.Config())
+	waitWorkers(t, pool, 4)
+
+	var wg sync.WaitGroup
+	submit := func(id string) {
+		req := newTestRequest(id, 8)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer req.cancel()
+			if rsp := m.WaitDoRequest(req); rsp.Status != model.StatusSuccess {
+				t.Errorf("request %s failed: %s", id, rsp.Status)
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		submit(fmt.Sprintf("L%d", i))
+	}
+	for i := 0; i < 4; i++ {
+		<-llm.started
+	}
+
+	records, err := m.ResizePools("fake", 1, "test")
+	if err != nil || len(records) != 1 || records[0].From != 4 || records[0].To != 1 {
+		t.Fatalf("unexpected resize result %+v, %v", records, err)
+	}
+	// in-flight requests are not interrupted
+	for i := 0; i < 4; i++ {
+		llm.release <- struct{}{}
+	}
+	wg.Wait()
+	waitWorkers(t, pool, 1)
+
+	// only one request runs at a time after shrinking, the others wait in the queue
+	submit("L4")
+	<-llm.started
+	submit("L5")
+	waitQueued(t, pool, 1)
+	submit("L6")
+	waitQueued(t, pool, 2)
+	for i := 4; i < 7; i++ {
+		if i > 4 {
+			<-llm.started
+		}
+		select {
+		case id := <-llm.started:
+			t.Fatalf("expected one running request, %s started concurrently", id)
+		case <-time.After(20 * time.Millisecond):
+		}
+		llm.release <- struct{}{}
+	}
+	wg.Wait()
+
+	stats := m.GetStats()
+	requests := stats["pools"].([]map[string]interface{})[0]["requests"].(map[string]interface{})
+	if requests["max_concurrent"] != 1 || requests["configured"] != 4 {
+		t.Errorf("expected current 1 and configured 4, got %v", requests)
+	}
+}
+
+// to test growing a pool and the bounds of resizing
+// go test ./pkg/stream_controller/ -v -run Test_GrowPool
+func Test_GrowPool(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := newFakeLLM(2)
+	m := NewPoolManager()
+	pool := m.initPool("fake", llm, llm.Config())
+
+	// shrinking then growing again cancels pending retirements
+	if _, err := m.ResizePools("fake", 1, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ResizePools("fake", 3, "test"); err != nil {
+		t.Fatal(err)
+	}
+	waitWorkers(t, pool, 3)
+
+	if _, err := m.ResizePools("fake", 0, "test"); err == nil {
+		t.Error("expected max_concurrent 0 to be rejected")
+	}
+	if _, err := m.ResizePools("unknown", 2, "test"); err != ErrPoolNotFound {
+		t.Errorf("expected ErrPoolNotFound, got %v", err)
+	}
+	if n := len(m.getResizeRecords()); n != 2 {
+		t.Errorf("expected 2 audit records, got %d", n)
+	}
+}
+
+// to test a manual resize dropping the tuner samples taken under the old concurrency
+// go test ./pkg/stream_controller/ -v -run Test_ResizeRestartsTuner
+func Test_ResizeRestartsTuner(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "tuned", MaxConcurrent: 2,
+		Autotune: config.ConcurrencyTuning{Enabled: true, TargetLatency: time.Second, Interval: time.Minute}}}
+	m := NewPoolManager()
+	pool := m.initPool("tuned", llm, llm.Config())
+
+	rsp := &completions.CompletionResponse{Status: model.StatusSuccess}
+	rsp.Usage.LLMDuration = 100
+	for i := 0; i < 10; i++ {
+		pool.tuner.observe(time.Now(), 2, rsp)
+	}
+	if _, err := m.ResizePools("tuned", 4, "test"); err != nil {
+		t.Fatal(err)
+	}
+	pool.tuner.mutex.Lock()
+	n := len(pool.tuner.samples)
+	pool.tuner.mutex.Unlock()
+	if n != 0 {
+		t.Errorf("expected the tuner samples dropped after the resize, got %d", n)
+	}
+}
@@ -0,0 +1,72 @@
+package stream_controller
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// heapAlloc returns the live heap after forcing garbage collection
+func heapAlloc() int64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapAlloc)
+}
+
+// to test a 2MB prefix is not pinned by the small strings derived from it and kept in long-lived stores
+// go test ./pkg/stream_controller/ -v -run Test_LargePromptRetention
+func Test_LargePromptRetention(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(time.Millisecond)()
+	llm := newFakeLLM(2)
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, dedup: newCompletionDedup(time.Minute),
+		invalid: newInvalidations(time.Minute), errors: newErrorJournal(10),
+		samples: newSampleJournal(&config.SamplesConfig{Enabled: true, Rate: 1, Clients: []string{"client-big"},
+			MaxEntries: 10, Retention: time.Hour})}
+	before := heapAlloc()
+
+	// 与线上一样由请求体解析，之后请求的所有引用都被丢弃
+	func() {
+		body, _ := json.Marshal(map[string]interface{}{
+			"client_id":     "client-big",
+			"completion_id": "cmpl-big",
+			"language_id":   "go",
+			"trigger_mode":  "MANUAL",
+			"verbose":       true, // Verbose引用提示词，去重记录不能保存
+			"prompt_options": map[string]string{
+				"prefix":            strings.Repeat("\tsum += values[i] * weights[i]\n", 2<<20/32) + "\treturn ",
+				"suffix":            "\n}\n",
+				"file_project_path": "pkg/stats/weighted.go",
+			},
+		})
+		input := &completions.CompletionInput{}
+		if err := json.Unmarshal(body, &input.CompletionRequest); err != nil {
+			t.Fatal(err)
+		}
+		rsp, _ := sc.ProcessCompletionOnce(context.Background(), "/api/completions", input)
+		if rsp.Status != model.StatusSuccess {
+			t.Fatalf("expected the completion succeeded, got %s %s", rsp.Status, rsp.Error)
+		}
+	}()
+
+	if retained := heapAlloc() - before; retained > 512<<10 {
+		t.Errorf("expected the prompt released after the request, %d bytes retained", retained)
+	}
+	if e, ok := sc.dedup.entries.Get(dedupKey("client-big", "cmpl-big")); !ok || e.rsp.Verbose != nil {
+		t.Error("expected the dedup memo kept without the verbose")
+	}
+	if samples := sc.samples.query(SampleFilter{}); len(samples) != 1 || !strings.HasSuffix(samples[0].Prompt.Prefix, "\treturn ") {
+		t.Errorf("expected the sample kept with the end of the prefix, got %d samples", len(samples))
+	}
+}
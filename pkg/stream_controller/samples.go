@@ -0,0 +1,307 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 样本中提示词各部分最多保存的字节数，正常截断后的提示词远小于此
+const maxSamplePromptBytes = 64 << 10
+
+// 补全样本的采样结果，用作completion_samples_total的outcome标签
+const (
+	SampleSampled        = "sampled"         // 采样并保存
+	SampleSkippedConsent = "skipped_consent" // 命中采样率，但客户端没有同意采样
+	SampleSkippedCap     = "skipped_cap"     // 命中采样率，但该语言的样本数已达上限
+)
+
+/**
+ * 补全样本中发送给模型的提示词，是截断后的各部分
+ */
+type SamplePrompt struct {
+	Prefix      string `json:"prefix"`
+	Suffix      string `json:"suffix"`
+	CodeContext string `json:"codeContext"`
+}
+
+/**
+ * 用于离线质量评审的一条补全样本
+ * @description
+ * - Raw: 模型输出的补全内容，Pruned: 后置处理后返回给客户端的补全内容
+ * - Hits: 命中的后置处理器，用于分析Raw与Pruned的差异
+ * - Status为补全的最终状态，样本在补全返回后才记录
+ * - TriggerMode和FilePath与提示词一起用于重放补全，见Replay
+ * - Family、Block、Style、Literal、Arguments是后置处理器使用的请求信息，离线评估处理器阈值时按原样修剪Raw，见optimize
+ * - Accepted: 下一次请求反馈的采纳结果，还没有反馈时为nil，见completions.AcceptanceFeedback
+ * - Redacted: 隐私模式下提示词和补全内容只保存摘要(见store.Digest)，不能重放
+ */
+type Sample struct {
+	CompletionID string                 `json:"completionId"`
+	Time         time.Time              `json:"time"`
+	Model        string                 `json:"model"`
+	Language     string                 `json:"language"`
+	ClientID     string                 `json:"clientId"`
+	TriggerMode  string                 `json:"triggerMode,omitempty"`
+	FilePath     string                 `json:"filePath,omitempty"`
+	Status       model.CompletionStatus `json:"status"`
+	Prompt       SamplePrompt           `json:"prompt"`
+	Raw          string                 `json:"raw"`
+	Pruned       string                 `json:"pruned"`
+	Hits         []string               `json:"hits,omitempty"`
+	TotalMs      int64                  `json:"totalMs"`
+	Family       string                 `json:"family,omitempty"`
+	Block        string                 `json:"block,omitempty"`
+	Style        *model.StyleProfile    `json:"style,omitempty"`
+	Literal      string                 `json:"literal,omitempty"`
+	Arguments    *model.ArgumentList    `json:"arguments,omitempty"`
+	Accepted     *bool                  `json:"accepted,omitempty"`
+	Redacted     bool                   `json:"redacted,omitempty"`
+}
+
+// 查询补全样本的过滤条件，为空的条件不过滤
+type SampleFilter struct {
+	Model    string
+	Language string
+	Limit    int // 最多返回的样本数，0表示不限制
+}
+
+/**
+ * 用于离线质量评审的补全样本
+ * @description
+ * - 基于有界内存存储，与错误日志分开保存，有自己的容量和保留时长
+ * - 键为completion_id，同一补全只保存一次
+ * - 按语言统计保留的样本数，样本被淘汰(超出容量或过期)时减少
+ */
+type sampleJournal struct {
+	cfg       *config.SamplesConfig
+	consented map[string]bool
+	mutex     sync.Mutex
+	languages map[string]int // 各语言保留的样本数
+	entries   *store.Store[string, Sample]
+}
+
+func newSampleJournal(cfg *config.SamplesConfig) *sampleJournal {
+	j := &sampleJournal{
+		cfg:       cfg,
+		consented: make(map[string]bool, len(cfg.Clients)),
+		languages: make(map[string]int),
+	}
+	for _, client := range cfg.Clients {
+		j.consented[client] = true
+	}
+	j.entries = store.New(store.Options[string, Sample]{
+		Name:       "quality_samples",
+		MaxEntries: cfg.MaxEntries,
+		TTL:        cfg.Retention,
+		OnEvict: func(_ string, s Sample, _ store.EvictReason) {
+			j.mutex.Lock()
+			j.languages[s.Language]--
+			j.mutex.Unlock()
+		},
+	})
+	return j
+}
+
+/**
+ * completion_id对应的采样值，均匀分布在[0,1)
+ * @description
+ * - fnv64a哈希后再做一次混合(splitmix64的终结步骤)，使相近的completion_id也分布均匀
+ * - 同一completion_id的结果总是相同，采样决定可以复现
+ */
+func sampleFraction(completionID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(completionID))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / float64(1<<53)
+}
+
+// 是否命中模型的采样率，与客户端和语言无关
+func (j *sampleJournal) selected(modelName, completionID string) bool {
+	rate := math.Min(j.cfg.RateOf(modelName), 1)
+	return rate > 0 && completionID != "" && sampleFraction(completionID) < rate
+}
+
+/**
+ * 决定是否采样一个已返回的补全，采样时保存样本
+ * @param {Sample} s - 补全样本
+ * @returns {string} 返回采样结果(Sample*常量)，没有命中采样率时返回空
+ * @description
+ * - 先按completion_id和模型的采样率决定是否命中，没有命中的补全不计数
+ * - 命中后依次检查客户端是否同意采样、该语言保留的样本数是否已达上限
+ * - 每个命中的补全按结果计入completion_samples_total
+ */
+func (j *sampleJournal) record(s Sample) string {
+	if j == nil || !j.cfg.Enabled || !j.selected(s.Model, s.CompletionID) {
+		return ""
+	}
+	outcome := j.admit(s)
+	metrics.IncrementSamples(s.Model, outcome)
+	return outcome
+}
+
+// 检查同意采样和语言上限，通过时保存样本
+func (j *sampleJournal) admit(s Sample) string {
+	if !j.consented[s.ClientID] {
+		return SampleSkippedConsent
+	}
+	if _, ok := j.entries.Get(s.CompletionID); ok {
+		return SampleSampled
+	}
+	j.entries.Expire()
+	j.mutex.Lock()
+	if limit := j.cfg.CapOf(s.Language); limit > 0 && j.languages[s.Language] >= limit {
+		j.mutex.Unlock()
+		return SampleSkippedCap
+	}
+	s = s.retained()
+	j.languages[s.Language]++
+	j.mutex.Unlock()
+	// 超出容量时淘汰的回调会加锁，不能在持有锁时写入
+	j.entries.Put(s.CompletionID, s)
+	return SampleSampled
+}
+
+/**
+ * 复制样本用于长期保存
+ * @returns {Sample} 返回不引用请求提示词的副本
+ * @description
+ * - ID、模型、语言等由请求体解析得到，本身就是独立的字符串，不需要复制
+ * - 截断后的提示词是请求原始提示词的子串，直接保存会使整个原始提示词(可达数MB)无法回收，参数列表中的参数名同样如此
+ * - 提示词各部分和补全内容最多保存maxSamplePromptBytes字节，前缀保留末尾，其它保留开头
+ */
+func (s Sample) retained() Sample {
+	s.Prompt = SamplePrompt{
+		Prefix:      store.RetainTail(s.Prompt.Prefix, maxSamplePromptBytes),
+		Suffix:      store.Retain(s.Prompt.Suffix, maxSamplePromptBytes),
+		CodeContext: store.Retain(s.Prompt.CodeContext, maxSamplePromptBytes),
+	}
+	s.Raw = store.Retain(s.Raw, maxSamplePromptBytes)
+	s.Pruned = store.Retain(s.Pruned, maxSamplePromptBytes)
+	if s.Arguments != nil {
+		args := *s.Arguments
+		args.Names = make([]string, len(s.Arguments.Names))
+		for i, name := range s.Arguments.Names {
+			args.Names[i] = strings.Clone(name)
+		}
+		s.Arguments = &args
+	}
+	return s
+}
+
+// 隐私模式下提示词和补全内容只保存摘要，参数列表中的参数名来自源码，不保存，其他字段不含源码
+func (s Sample) Strip() Sample {
+	s.Prompt = SamplePrompt{
+		Prefix:      store.Digest(s.Prompt.Prefix),
+		Suffix:      store.Digest(s.Prompt.Suffix),
+		CodeContext: store.Digest(s.Prompt.CodeContext),
+	}
+	s.Raw = store.Digest(s.Raw)
+	s.Pruned = store.Digest(s.Pruned)
+	s.Arguments = nil
+	s.Redacted = true
+	return s
+}
+
+/**
+ * 把下一次请求反馈的采纳结果关联到被反馈补全的样本
+ * @param {string} clientID - 反馈的客户端，与样本的客户端不同时不处理
+ * @param {*completions.AcceptanceFeedback} feedback - 采纳反馈，为nil时不处理
+ * @description
+ * - 没有采样的补全没有样本，反馈被忽略
+ */
+func (j *sampleJournal) feedback(clientID string, feedback *completions.AcceptanceFeedback) {
+	if j == nil || feedback == nil {
+		return
+	}
+	s, ok := j.entries.Get(feedback.CompletionID)
+	if !ok || s.ClientID != clientID {
+		return
+	}
+	accepted := feedback.Accepted
+	s.Accepted = &accepted
+	j.entries.Put(feedback.CompletionID, s)
+}
+
+/**
+ * 查询补全样本
+ * @param {SampleFilter} filter - 过滤条件
+ * @returns {[]Sample} 返回从新到旧的样本
+ */
+func (j *sampleJournal) query(filter SampleFilter) []Sample {
+	samples := []Sample{}
+	if j == nil {
+		return samples
+	}
+	j.entries.Expire()
+	j.entries.Range(func(_ string, s Sample) bool {
+		if filter.Model != "" && s.Model != filter.Model ||
+			filter.Language != "" && s.Language != filter.Language {
+			return true
+		}
+		samples = append(samples, s)
+		return filter.Limit == 0 || len(samples) < filter.Limit
+	})
+	return samples
+}
+
+// 查找completion_id对应的样本
+func (j *sampleJournal) get(completionID string) (Sample, bool) {
+	if j == nil {
+		return Sample{}, false
+	}
+	j.entries.Expire()
+	return j.entries.Get(completionID)
+}
+
+// 由补全请求和响应构造样本，提示词取截断后发送给模型的内容
+func newSample(input *completions.CompletionInput, rsp *completions.CompletionResponse) Sample {
+	s := Sample{
+		CompletionID: input.CompletionID,
+		Time:         time.Now(),
+		Model:        rsp.Model,
+		Language:     input.EffectiveLanguage(),
+		ClientID:     input.ClientID,
+		TriggerMode:  input.TriggerMode,
+		FilePath:     input.Processed.FileProjectPath,
+		Status:       rsp.Status,
+		Prompt: SamplePrompt{
+			Prefix:      input.Processed.Prefix,
+			Suffix:      input.Processed.Suffix,
+			CodeContext: input.Processed.CodeContext,
+		},
+		Raw:       rsp.Raw,
+		Hits:      rsp.Hits,
+		TotalMs:   rsp.Usage.TotalDuration,
+		Style:     input.Style,
+		Arguments: input.Arguments,
+	}
+	if s.Model == "" {
+		s.Model = input.Model
+	}
+	if input.Fallback != nil {
+		s.Family = input.Fallback.Family
+	}
+	if input.Region != nil {
+		s.Block = input.Region.Block
+	}
+	if input.Literal != nil {
+		s.Literal = input.Literal.Kind
+	}
+	if len(rsp.Choices) > 0 {
+		s.Pruned = rsp.Choices[0].Text
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+package stream_controller
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+func newTestSample(id, modelName, language, client string) Sample {
+	return Sample{CompletionID: id, Model: modelName, Language: language, ClientID: client,
+		Status: model.StatusSuccess, Raw: "x := 1\ny := 2", Pruned: "x := 1"}
+}
+
+// to test only consenting clients are sampled, and the decision is deterministic per completion_id
+// go test ./pkg/stream_controller/ -v -run Test_SampleConsent
+func Test_SampleConsent(t *testing.T) {
+	j := newSampleJournal(&config.SamplesConfig{Enabled: true, Rate: 1, Clients: []string{"c1"},
+		MaxEntries: 100, Retention: time.Hour})
+
+	outcomes := map[string]int{}
+	for i := 0; i < 20; i++ {
+		client := []string{"c1", "c2"}[i%2]
+		outcomes[j.record(newTestSample(fmt.Sprintf("cmpl-%d", i), "m1", "go", client))]++
+	}
+	if outcomes[SampleSampled] != 10 || outcomes[SampleSkippedConsent] != 10 {
+		t.Errorf("expected 10 sampled and 10 skipped by consent, got %v", outcomes)
+	}
+	for _, s := range j.query(SampleFilter{}) {
+		if s.ClientID != "c1" {
+			t.Errorf("sample of a client without consent stored: %+v", s)
+		}
+	}
+	// 重放的completion_id不会重复保存
+	if outcome := j.record(newTestSample("cmpl-0", "m1", "go", "c1")); outcome != SampleSampled || len(j.query(SampleFilter{})) != 10 {
+		t.Errorf("replayed completion should be sampled once, got %s with %d samples", outcome, len(j.query(SampleFilter{})))
+	}
+
+	disabled := newSampleJournal(&config.SamplesConfig{Rate: 1, Clients: []string{"c1"}, MaxEntries: 100})
+	if outcome := disabled.record(newTestSample("cmpl-0", "m1", "go", "c1")); outcome != "" {
+		t.Errorf("disabled sampling should not record, got %s", outcome)
+	}
+}
+
+// to test the sample rate math over many synthetic completions, per-model rates and per-language caps
+// go test ./pkg/stream_controller/ -v -run Test_SampleRate
+func Test_SampleRate(t *testing.T) {
+	cfg := &config.SamplesConfig{Enabled: true, Rate: 0.001, ModelRates: map[string]float64{"m2": 0.1},
+		LanguageCap: 50, LanguageCaps: map[string]int{"python": 5}, Clients: []string{"c1"},
+		MaxEntries: 100000, Retention: time.Hour}
+	j := newSampleJournal(cfg)
+
+	const n = 200000
+	selected := map[string]int{}
+	for _, m := range []string{"m1", "m2"} {
+		for i := 0; i < n; i++ {
+			if j.selected(m, fmt.Sprintf("%s-%d", m, i)) {
+				selected[m]++
+			}
+		}
+	}
+	// 二项分布的期望和标准差，允许4个标准差的偏差
+	for m, rate := range map[string]float64{"m1": cfg.Rate, "m2": 0.1} {
+		want := n * rate
+		if dev := math.Abs(float64(selected[m]) - want); dev > 4*math.Sqrt(want*(1-rate)) {
+			t.Errorf("model %s: expected about %.0f sampled, got %d", m, want, selected[m])
+		}
+	}
+
+	// 同一completion_id的决定总是相同
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("m2-%d", i)
+		if j.selected("m2", id) != j.selected("m2", id) || j.selected("m2", id) != newSampleJournal(cfg).selected("m2", id) {
+			t.Fatalf("sampling decision of %s is not deterministic", id)
+		}
+	}
+
+	outcomes := map[string]map[string]int{"go": {}, "python": {}}
+	for i := 0; i < 2000; i++ {
+		language := []string{"go", "python"}[i%2]
+		outcomes[language][j.record(newTestSample(fmt.Sprintf("m2-%d", i), "m2", language, "c1"))]++
+	}
+	if outcomes["python"][SampleSampled] != 5 || outcomes["python"][SampleSkippedCap] == 0 {
+		t.Errorf("python should be capped at 5 samples, got %v", outcomes["python"])
+	}
+	if got := len(j.query(SampleFilter{Language: "go"})); got != outcomes["go"][SampleSampled] || got > 50 {
+		t.Errorf("go samples should be capped at 50, got %d stored, outcomes %v", got, outcomes["go"])
+	}
+}
+
+// to test evicted samples free the language cap
+// go test ./pkg/stream_controller/ -v -run Test_SampleEviction
+func Test_SampleEviction(t *testing.T) {
+	j := newSampleJournal(&config.SamplesConfig{Enabled: true, Rate: 1, LanguageCap: 2, Clients: []string{"c1"},
+		MaxEntries: 2, Retention: time.Hour})
+
+	j.record(newTestSample("a", "m1", "go", "c1"))
+	j.record(newTestSample("b", "m1", "go", "c1"))
+	if outcome := j.record(newTestSample("c", "m1", "go", "c1")); outcome != SampleSkippedCap {
+		t.Errorf("expected the go cap reached, got %s", outcome)
+	}
+	// 其它语言的样本淘汰了最早的go样本
+	j.record(newTestSample("d", "m1", "python", "c1"))
+	if outcome := j.record(newTestSample("e", "m1", "go", "c1")); outcome != SampleSampled {
+		t.Errorf("evicted sample should free the go cap, got %s", outcome)
+	}
+	if samples := j.query(SampleFilter{Limit: 1}); len(samples) != 1 || samples[0].CompletionID != "e" {
+		t.Errorf("expected the newest sample first, got %+v", samples)
+	}
+}
+
+// to test the acceptance feedback of the next request is joined to the sample of the served completion
+// go test ./pkg/stream_controller/ -v -run Test_SampleFeedback
+func Test_SampleFeedback(t *testing.T) {
+	j := newSampleJournal(&config.SamplesConfig{Enabled: true, Rate: 1, Clients: []string{"c1", "c2"},
+		MaxEntries: 100, Retention: time.Hour})
+	j.record(newTestSample("a", "m1", "go", "c1"))
+	j.record(newTestSample("b", "m1", "go", "c1"))
+
+	j.feedback("c1", &completions.AcceptanceFeedback{CompletionID: "a", Fraction: 1, Accepted: true})
+	j.feedback("c1", &completions.AcceptanceFeedback{CompletionID: "b", Fraction: 0.2})
+	// 其它客户端的反馈和没有样本的反馈忽略
+	j.feedback("c2", &completions.AcceptanceFeedback{CompletionID: "a"})
+	j.feedback("c1", &completions.AcceptanceFeedback{CompletionID: "missing", Accepted: true})
+	j.feedback("c1", nil)
+
+	if s, _ := j.get("a"); s.Accepted == nil || !*s.Accepted {
+		t.Errorf("expected sample a accepted, got %+v", s.Accepted)
+	}
+	if s, _ := j.get("b"); s.Accepted == nil || *s.Accepted {
+		t.Errorf("expected sample b rejected, got %+v", s.Accepted)
+	}
+	if _, ok := j.get("missing"); ok || len(j.query(SampleFilter{})) != 2 {
+		t.Error("expected feedback without a sample ignored")
+	}
+}
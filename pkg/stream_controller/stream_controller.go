@@ -1,11 +1,19 @@
 package stream_controller
 
 import (
+	"code-completion/pkg/codebase_context"
 	"code-completion/pkg/completions"
 	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
+	"code-completion/pkg/store"
+	"code-completion/pkg/tokenizers"
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -16,19 +24,68 @@ var Controller *StreamController
 
 // 流控管理器,对补全模型的访问做流控，防止补全模型失去响应
 type StreamController struct {
-	queues *QueueManager //请求等待队列管理（在等待调度到模型请求池）
-	pools  *PoolManager  //模型请求池管理（正在调用模型的请求）
+	queues    *QueueManager                   //请求等待队列管理（在等待调度到模型请求池）
+	pools     *PoolManager                    //模型请求池管理（正在调用模型的请求）
+	dedup     *completionDedup                //按completion_id去重，与路由无关
+	invalid   *store.Store[string, string]    //插件告知作废的补全
+	errors    *errorJournal                   //最近失败的补全
+	samples   *sampleJournal                  //用于离线质量评审的补全样本
+	anomaly   *anomalyDetector                //补全质量异常检测和安全模式
+	preflight *preflight                      //插件配置预检
+	warmup    *warmup                         //启动预热
+	probe     *prober                         //定时自测探针
+	context   *codebase_context.ContextClient //代码上下文客户端，所有请求共享
+	prefetch  *completions.PrefetchCache      //渐进式上下文的缓存，所有请求共享
+	usage     *usageLedger                    //按租户和客户端累计的token用量
+	tails     *activityBus                    //按客户端实时跟踪补全活动
+	stop      chan struct{}                   //关闭时维护协程退出
+	done      chan struct{}                   //Stop时关闭，探针等后台协程退出
+	doneOnce  sync.Once                       //只关闭一次done
+	stopped   chan struct{}                   //维护协程退出时关闭
+	abandoned sync.WaitGroup                  //严格截止时间的看门狗触发后仍在运行的处理协程，见runStrict
+
+	detailsSeq atomic.Uint64 //明细快照的序号
+	replaySeq  atomic.Uint64 //重放请求的序号
 }
 
-func NewStreamController() *StreamController {
-	return &StreamController{
-		queues: NewQueueManager(),
-		pools:  NewPoolManager(),
+// 创建流控制器，contextClient为所有请求共享的代码上下文客户端，为nil时不获取代码上下文
+func NewStreamController(contextClient *codebase_context.ContextClient) *StreamController {
+	pools := NewPoolManager()
+	pools.tails = newActivityBus()
+	sc := &StreamController{
+		context:   contextClient,
+		queues:    NewQueueManager(),
+		pools:     pools,
+		dedup:     newCompletionDedup(config.Config.StreamController.DedupWindow),
+		invalid:   newInvalidations(config.Config.StreamController.DedupWindow),
+		errors:    newErrorJournal(config.Config.StreamController.ErrorJournalSize),
+		samples:   newSampleJournal(&config.Config.StreamController.Samples),
+		anomaly:   newAnomalyDetector(&config.Config.StreamController.Anomaly),
+		preflight: newPreflight(&config.Config.Preflight, contextClient),
+		warmup:    newWarmup(&config.Config.StreamController.Warmup, pools, nil),
+		prefetch:  completions.NewPrefetchCache(&config.Wrapper.Progressive),
+		usage:     newUsageLedger(&config.Config.StreamController.Usage, nil),
+		tails:     pools.tails,
+		done:      make(chan struct{}),
 	}
+	sc.probe = newProber(&config.Config.StreamController.Probe, sc, nil)
+	return sc
 }
 
 func (sc *StreamController) Init() {
 	sc.pools.Init()
+	sc.warmup.begin()
+	sc.warmup.run()
+	sc.probe.run(sc.done)
+	sc.usage.run()
+	// 启动预热期间由预热控制并发数，不自动调整
+	sc.pools.runTuners(func() bool { return sc.warmup.state().Warming }, sc.done)
+	if anomaly := &config.Config.StreamController.Anomaly; anomaly.Enabled {
+		if err := anomaly.Validate(); err != nil {
+			zap.L().Error("Invalid anomaly detection config", zap.Error(err))
+			panic(err)
+		}
+	}
 
 	var maintainInterval time.Duration
 	maintainInterval = time.Duration(300) * time.Second // 默认清理间隔（秒）
@@ -41,39 +98,152 @@ func (sc *StreamController) Init() {
 		zap.Duration("maintainInterval", maintainInterval))
 }
 
+// 请求的功能开关，同一请求各处理阶段共用同一次求值的结果；调用方已放入ctx时沿用
+func requestFlags(ctx context.Context, clientID, tenant string) (context.Context, *feature_flag.Evaluator) {
+	if flags := feature_flag.FromContext(ctx); flags != nil {
+		return ctx, flags
+	}
+	flags := feature_flag.ForRequest(clientID, tenant)
+	return feature_flag.WithContext(ctx, flags), flags
+}
+
 /**
- * 处理V1接口版本的补全请求
+ * 处理V1接口版本的补全请求，失败的请求记录到错误日志，按采样率保存补全样本，返回前分配响应序号
  */
 func (sc *StreamController) ProcessCompletionV1(ctx context.Context, input *completions.CompletionInput) *completions.CompletionResponse {
+	sc.tails.publish(input.ClientID, TailEvent{Stage: TailReceived, CompletionID: input.CompletionID,
+		Model: input.Model, Language: input.LanguageID, TriggerMode: input.TriggerMode})
+	ctx, flags := requestFlags(ctx, input.ClientID, TenantOf(input.Headers))
+	rsp, req := sc.processCompletionV1(ctx, input)
+	promptBytes := 0
+	if input.Prompts != nil {
+		promptBytes = len(input.Prompts.Prefix) + len(input.Prompts.Suffix)
+	}
+	summary := journalRequest{
+		api:         "v1",
+		model:       input.Model,
+		language:    input.LanguageID,
+		clientID:    input.ClientID,
+		promptBytes: promptBytes,
+	}
+	sc.finishCompletion(flags, TenantOf(input.Headers), summary, input, req, rsp)
+	return rsp
+}
+
+/**
+ * 补全返回前各接口(V1、V2、OpenAI)共用的处理
+ * @param {*feature_flag.Evaluator} flags - 请求的功能开关
+ * @param {string} tenant - 请求所属的租户
+ * @param {journalRequest} summary - 请求的概要，用于错误日志
+ * @param {*completions.CompletionInput} input - 补全输入，V2和OpenAI接口见NewParameterInput/NewOpenAIInput
+ * @param {*ClientRequest} req - 排队的请求，没有进入排队时为nil
+ * @param {*completions.CompletionResponse} rsp - 补全响应
+ * @description
+ * - 附加提示词指纹，为空补全给出原因和重试建议，建议缺少的导入语句，记录采纳跟踪
+ * - 记录上下文使用方式、文件类型、异常检测、预热、错误日志、样本和用量
+ * - 依赖预处理结果的处理(diff还原、采纳反馈)在V2和OpenAI接口没有数据，自然跳过
+ * - 最后分配响应序号，并发布请求的结束事件
+ */
+func (sc *StreamController) finishCompletion(flags *feature_flag.Evaluator, tenant string, summary journalRequest,
+	input *completions.CompletionInput, req *ClientRequest, rsp *completions.CompletionResponse) {
+	rsp.Fingerprint = input.Fingerprint
+	input.AdviseRetry(flags, rsp)
+	input.SuggestImports(flags, rsp)
+	// 已作废的补全不再用于统计下一次请求的采纳结果
+	if !sc.invalidated(input.ClientID, input.CompletionID) {
+		input.TrackServed(flags, rsp)
+	}
+	input.ObserveContextMode(rsp)
+	metrics.IncrementFileKind(input.FileKind(), string(rsp.Status))
+	summary.dispatched = req.wasDispatched()
+	if summary.dispatched {
+		sc.anomaly.record(req.servedBy(), rsp)
+	}
+	sc.warmup.record(rsp)
+	sc.errors.record(summary, rsp)
+	sc.samples.record(newSample(input, rsp))
+	sc.samples.feedback(input.ClientID, input.Feedback)
+	if !input.Replay {
+		sc.usage.record(tenant, input.ClientID, rsp)
+	}
+	// 采纳跟踪和样本使用还原后的源码，最后才加上diff标记
+	input.RestoreDiff(rsp)
+	sc.queues.Sequence(input.ClientID, input.ClientSequence, rsp)
+	sc.publishReturned(input.ClientID, input.CompletionID, rsp)
+}
+
+// 处理V1接口版本的补全请求，返回响应和排队的请求(没有进入排队时为nil)
+func (sc *StreamController) processCompletionV1(ctx context.Context, input *completions.CompletionInput) (rsp *completions.CompletionResponse, req *ClientRequest) {
+	defer func() {
+		sc.publishRejected(input.ClientID, input.CompletionID, req, rsp)
+	}()
 	var perf completions.CompletionPerformance
 	perf.ReceiveTime = time.Now().Local()
+	//	请求的功能开关，各处理阶段共用同一次求值的结果；调用方已放入ctx时沿用
+	ctx, flags := requestFlags(ctx, input.ClientID, TenantOf(input.Headers))
+	perf.Fingerprint = input.ComputeFingerprint(flags)
+	perf.Probe = input.Probe
 	// 如果无法获取到clientID和completionID，拒掉
 	if input.ClientID == "" || input.CompletionID == "" {
-		return completions.CancelRequest(input.CompletionID, input.Model, &perf, model.StatusRejected, fmt.Errorf("missing client id or completion id"))
+		return completions.CancelRequest(input.CompletionID, input.Model, &perf, model.StatusRejected, fmt.Errorf("missing client id or completion id")), nil
 	}
 	//	预选模型池
 	pool := sc.pools.SelectIdlestPool(input.Model)
 	if pool == nil {
-		return completions.CancelRequest(input.CompletionID, input.Model, &perf, model.StatusBusy, fmt.Errorf("model pool busy, cancel request"))
+		return completions.CancelRequest(input.CompletionID, input.Model, &perf, model.StatusBusy, fmt.Errorf("model pool busy, cancel request")), nil
+	}
+	//	处于安全模式的模型按配置拒绝请求或切换到后备模型
+	pool, err := sc.applySafeMode(pool, input.TriggerMode)
+	if err != nil {
+		return completions.CancelRequest(input.CompletionID, input.Model, &perf, model.StatusRejected, err), nil
 	}
 	input.Model = pool.cfg.ModelName
+	//	请求级logger，该请求各阶段的日志都带有completion_id等字段
+	reqLogger := completions.NewRequestLogger(input.CompletionID, input.ClientID, input.Model, input.LanguageID)
+	if input.Fingerprint != "" {
+		reqLogger = reqLogger.With(zap.String("fingerprint", input.Fingerprint))
+	}
+	ctx = logger.WithContext(ctx, reqLogger)
 
 	//	上下文预处理
 	c := completions.NewCompletionContext(ctx, &perf)
-	rsp := input.Preprocess(c)
+	c.ContextClient = sc.context
+	c.Prefetch = sc.prefetch
+	rsp = input.Preprocess(c)
 	if rsp != nil {
-		return rsp
+		input.AttachContextStatus(sc.context, rsp)
+		return rsp, nil
 	}
 	//	请求数据针对模型进行适应性改造
 	handler := completions.NewCompletionHandler(pool.llm)
 	para := handler.Adapt(input)
+	para.Tenant = TenantOf(input.Headers)
+	deadline := completions.StrictDeadlineFrom(ctx)
+	deadline.Enter(completions.PhaseQueue)
 
 	// 将请求添加到客户端队列，获取包含响应通道的ClientRequest
-	req := sc.queues.AddRequest(ctx, para, &perf)
+	req = sc.queues.AddRequest(ctx, para, &perf)
+	req.pool = pool
 	defer func() {
 		sc.queues.RemoveRequest(req)
 	}()
-	return sc.pools.WaitDoRequest(req)
+	sc.tails.publish(input.ClientID, TailEvent{Stage: TailQueued, CompletionID: input.CompletionID, Model: input.Model})
+	// 获取上下文期间被作废的请求，排队时即取消
+	if sc.invalidated(input.ClientID, input.CompletionID) {
+		req.cancelWith(completions.CancelCursorMoved)
+	}
+	// 重放和探针的请求不与客户端的补全争抢模型
+	if input.Replay || input.Probe {
+		req.Size = SizeBatch
+	}
+	rsp = sc.pools.WaitDoRequest(req)
+	deadline.Enter(completions.PhaseFinalize)
+	// 严格截止时间模式下看门狗已触发时不再组装verbose
+	if !deadline.Expired() {
+		input.AttachVerbose(rsp)
+	}
+	input.AttachContextStatus(sc.context, rsp)
+	return rsp, req
 }
 
 /**
@@ -87,16 +257,126 @@ func (sc *StreamController) ProcessCompletionV1(ctx context.Context, input *comp
  * - Automatically removes request from queue when function completes
  * - Waits for and executes the request through pool manager
  * - Handles V2 version completion requests with simplified flow compared to V1
+ * - Shares the hooks of V1 that do not need preprocessing, see PrepareParameter and finishCompletion
+ * - Records failed requests to the error journal
+ * - With strictDeadlineMs set, returns the best result so far at the deadline like V1, see ProcessCompletionStrict
  */
 func (sc *StreamController) ProcessCompletionV2(ctx context.Context, para *model.CompletionParameter) *completions.CompletionResponse {
+	ctx, flags := requestFlags(ctx, para.ClientID, para.Tenant)
+	if !strictEnabled(flags, para.StrictDeadlineMs) {
+		return sc.processCompletionV2(ctx, para)
+	}
+	margin := config.Config.StreamController.StrictDeadline.SafetyMargin
+	deadline := completions.NewStrictDeadlineFor(para.StrictDeadlineMs, para.CompletionID, para.Model, strictReceiveTime(ctx), margin)
+	rsp, _ := sc.runStrict(ctx, deadline, RouteResult{}, func(ctx context.Context) (*completions.CompletionResponse, RouteResult) {
+		return sc.processCompletionV2(ctx, para), RouteResult{}
+	})
+	return rsp
+}
+
+func (sc *StreamController) processCompletionV2(ctx context.Context, para *model.CompletionParameter) *completions.CompletionResponse {
 	var perf completions.CompletionPerformance
 	perf.ReceiveTime = time.Now().Local()
+	ctx = logger.WithContext(ctx, completions.NewRequestLogger(para.CompletionID, para.ClientID, para.Model, para.Language))
+	ctx, flags := requestFlags(ctx, para.ClientID, para.Tenant)
+	sc.tails.publish(para.ClientID, TailEvent{Stage: TailReceived, CompletionID: para.CompletionID,
+		Model: para.Model, Language: para.Language, TriggerMode: para.TriggerMode})
+	// 记录请求的概要，模型池会改写para.Model
+	summary := journalRequest{
+		api:         "v2",
+		model:       para.Model,
+		language:    para.Language,
+		clientID:    para.ClientID,
+		promptBytes: len(para.Prefix) + len(para.Suffix),
+	}
+	input := completions.NewParameterInput(para)
+	var selected *ModelPool
+	if pool := sc.pools.SelectIdlestPool(para.Model); pool != nil {
+		var err error
+		selected, err = sc.applySafeMode(pool, para.TriggerMode)
+		if err != nil {
+			rsp := completions.CancelRequest(para.CompletionID, para.Model, &perf, model.StatusRejected, err)
+			sc.publishRejected(para.ClientID, para.CompletionID, nil, rsp)
+			sc.finishCompletion(flags, para.Tenant, summary, input, nil, rsp)
+			return rsp
+		}
+		if selected != pool {
+			para.Model = selected.cfg.ModelName
+			input.Model = para.Model
+		}
+	}
+	// 与V1共用调用模型前的处理(指纹、语言回退、缩减超大的上下文)
+	input.PrepareParameter(completions.NewCompletionContext(ctx, &perf), para)
 
 	req := sc.queues.AddRequest(ctx, para, &perf)
+	req.pool = selected
 	defer func() {
 		sc.queues.RemoveRequest(req)
 	}()
-	return sc.pools.WaitDoRequest(req)
+	sc.tails.publish(para.ClientID, TailEvent{Stage: TailQueued, CompletionID: para.CompletionID, Model: para.Model})
+	rsp := sc.pools.WaitDoRequest(req)
+	sc.publishRejected(para.ClientID, para.CompletionID, req, rsp)
+	input.AttachVerbose(rsp)
+	input.AttachContextStatus(sc.context, rsp)
+	sc.finishCompletion(flags, para.Tenant, summary, input, req, rsp)
+	return rsp
+}
+
+// 请求没有调用模型就结束时，立即向跟踪该客户端的流发布rejected事件，不等到返回前的处理结束
+func (sc *StreamController) publishRejected(client, completionID string, req *ClientRequest, rsp *completions.CompletionResponse) {
+	if req.wasDispatched() || !sc.tails.watching(client) {
+		return
+	}
+	event := responseEvent(TailRejected, rsp)
+	event.CompletionID, event.Text = completionID, ""
+	sc.tails.publish(client, event)
+}
+
+// 向跟踪该客户端的流发布请求的结束事件，rejected事件已在请求结束时发布，见publishRejected
+func (sc *StreamController) publishReturned(client, completionID string, rsp *completions.CompletionResponse) {
+	if !sc.tails.watching(client) {
+		return
+	}
+	event := responseEvent(TailReturned, rsp)
+	event.CompletionID = completionID
+	event.TotalMs = time.Since(rsp.Usage.ReceiveTime).Milliseconds()
+	sc.tails.publish(client, event)
+}
+
+/**
+ * 对处于安全模式的模型池按配置的动作处理请求
+ * @param {*ModelPool} pool - 选中的模型池
+ * @param {string} triggerMode - 请求的触发方式
+ * @returns {*ModelPool} 实际使用的模型池，切换到后备模型时为后备模型最空闲的池
+ * @returns {error} 请求被拒绝时返回错误
+ * @description
+ * - 安全模式按模型池判断，后备模型中同样处于安全模式的池不参与选择
+ * - 后备模型不存在或都已满时仍使用选中的模型池
+ */
+func (sc *StreamController) applySafeMode(pool *ModelPool, triggerMode string) (*ModelPool, error) {
+	reject, fallback := sc.anomaly.admit(pool.instance, triggerMode)
+	if reject {
+		return nil, safeModeError(pool.cfg.ModelName)
+	}
+	if fallback == "" {
+		return pool, nil
+	}
+	pools, ok := sc.pools.lookup(fallback)
+	if !ok {
+		zap.L().Warn("Safe mode fallback model not found", zap.String("model", pool.cfg.ModelName),
+			zap.String("instance", pool.instance), zap.String("fallback", fallback))
+		return pool, nil
+	}
+	healthy := make([]*ModelPool, 0, len(pools))
+	for _, p := range pools {
+		if p != pool && !sc.anomaly.diverting(p.instance) {
+			healthy = append(healthy, p)
+		}
+	}
+	if selected := sc.pools.findIdlestPool(healthy); selected != nil {
+		return selected, nil
+	}
+	return pool, nil
 }
 
 /**
@@ -112,18 +392,50 @@ func (sc *StreamController) ProcessCompletionV2(ctx context.Context, para *model
  * - Creates completion context with performance tracking
  * - Directly handles the OpenAI format completion without queue management
  * - Designed for OpenAI API compatible request processing
+ * - Shares the post-completion hooks of V1, see finishCompletion; the hooks keyed by client are skipped
+ * - Records failed requests to the error journal
+ * - Records token usage under the request's tenant like the other routes, the client is unknown
+ * - With strict_deadline_ms set, returns the best result so far at the deadline like V1, see ProcessCompletionStrict
  */
 func (sc *StreamController) ProcessCompletionOpenAI(ctx context.Context, r *model.CompletionRequest) *completions.CompletionResponse {
+	ctx, flags := requestFlags(ctx, "", r.Tenant)
+	if !strictEnabled(flags, r.StrictDeadlineMs) {
+		return sc.processCompletionOpenAI(ctx, r)
+	}
+	margin := config.Config.StreamController.StrictDeadline.SafetyMargin
+	deadline := completions.NewStrictDeadlineFor(r.StrictDeadlineMs, "", r.Model, strictReceiveTime(ctx), margin)
+	rsp, _ := sc.runStrict(ctx, deadline, RouteResult{}, func(ctx context.Context) (*completions.CompletionResponse, RouteResult) {
+		return sc.processCompletionOpenAI(ctx, r), RouteResult{}
+	})
+	return rsp
+}
+
+func (sc *StreamController) processCompletionOpenAI(ctx context.Context, r *model.CompletionRequest) *completions.CompletionResponse {
 	var perf completions.CompletionPerformance
 	perf.ReceiveTime = time.Now().Local()
+	summary := journalRequest{
+		api:         "openai",
+		model:       r.Model,
+		promptBytes: len(r.Prompt) + len(r.Suffix),
+	}
+
+	ctx, flags := requestFlags(ctx, "", r.Tenant)
+	input := completions.NewOpenAIInput(r)
+	perf.Fingerprint = input.ComputeFingerprint(flags)
 
-	pool := sc.pools.findIdlestPool(sc.pools.all)
+	var rsp *completions.CompletionResponse
+	pool := sc.pools.findIdlestPool(sc.pools.allPools())
 	if pool == nil {
-		return completions.CancelRequest("", r.Model, &perf, model.StatusBusy, fmt.Errorf("model pool busy, cancel request"))
+		rsp = completions.CancelRequest("", r.Model, &perf, model.StatusBusy, fmt.Errorf("model pool busy, cancel request"))
+	} else {
+		// 不经过排队，每次发往模型时再获取限流的令牌
+		handler := completions.NewCompletionHandler(pool.llm)
+		c := completions.NewCompletionContext(sc.pools.withRateLimit(ctx, pool, false), &perf)
+		rsp = handler.HandleCompletionOpenAI(c, r)
 	}
-	handler := completions.NewCompletionHandler(pool.llm)
-	c := completions.NewCompletionContext(ctx, &perf)
-	return handler.HandleCompletionOpenAI(c, r)
+	input.AttachVerbose(rsp)
+	sc.finishCompletion(flags, r.Tenant, summary, input, nil, rsp)
+	return rsp
 }
 
 /**
@@ -133,35 +445,128 @@ func (sc *StreamController) ProcessCompletionOpenAI(ctx context.Context, r *mode
  * - Creates a ticker with specified interval for periodic execution
  * - Runs cleanup operations on queues to remove stale requests
  * - Logs maintenance statistics and controller status
+ * - Evaluates the completion quality anomaly detector every configured window when enabled
  * - Operates in background goroutine without blocking main thread
  * - Automatically stops ticker when goroutine exits
+ * - Exits when Stop is called
  * - Logs the start of maintenance routine with configured interval
  */
 func (sc *StreamController) StartMaintainRoutine(interval time.Duration) {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	sc.stop, sc.stopped = stop, stopped
 	go func() {
+		defer DumpOnPanic()
+		defer close(stopped)
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			sc.queues.Cleanup()
-			zap.L().Info("StreamController maintain", zap.Any("stats", sc.GetStats()))
+		// 补全质量异常检测按自己的窗口判断，未启用时不触发
+		var anomalyC <-chan time.Time
+		if anomaly := &config.Config.StreamController.Anomaly; anomaly.Enabled {
+			anomalyTicker := time.NewTicker(anomaly.Window)
+			defer anomalyTicker.Stop()
+			anomalyC = anomalyTicker.C
+		}
+
+		for {
+			select {
+			case now := <-ticker.C:
+				sc.queues.Cleanup()
+				errors := sc.errors.summary(now.Add(-interval))
+				zap.L().Info("StreamController maintain", zap.Any("stats", sc.GetStats()),
+					zap.Int("errors", errors.Total), zap.Any("errorsByStatus", errors.ByStatus),
+					zap.Strings("topErrors", errors.topPrefixes(3)))
+			case now := <-anomalyC:
+				sc.anomaly.evaluate(now)
+			case <-stop:
+				return
+			}
 		}
 	}()
 
 	zap.L().Info("Start maintain routine", zap.Duration("interval", interval))
 }
 
+// 停止维护协程并等待退出，通知探针等后台协程退出并释放模型的后台资源，同时等待看门狗触发后仍在运行的处理协程，用于在进程内结束流控制器后恢复配置(压测、测试)
+func (sc *StreamController) Stop() {
+	defer sc.abandoned.Wait()
+	sc.doneOnce.Do(sc.release)
+	if sc.stop == nil {
+		return
+	}
+	close(sc.stop)
+	<-sc.stopped
+	sc.stop = nil
+}
+
+// 通知探针、自动调整等后台协程退出，停止隐藏分阈值的定时调整，停止模型跟踪认证信息的变化
+func (sc *StreamController) release() {
+	if sc.done != nil {
+		close(sc.done)
+	}
+	completions.Tuner.Stop()
+	if sc.pools == nil {
+		return
+	}
+	for _, pool := range sc.pools.allPools() {
+		if closer, ok := pool.llm.(model.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
 // 获取流控统计信息
 func (sc *StreamController) GetStats() map[string]interface{} {
 	stats := make(map[string]interface{})
 	stats["queues"] = sc.queues.GetStats()
 	stats["pools"] = sc.pools.GetStats()
+	stats["thresholds"] = completions.Tuner.GetStats()
+	stats["tokenizers"] = tokenizers.Stats()
+	stats["tokenCalibration"] = completions.Calibration.GetStats()
+	stats["safeMode"] = sc.anomaly.states()
+	stats["warmup"] = sc.warmup.state()
+	stats["probes"] = sc.probe.state()
 	return stats
 }
 
-func (sc *StreamController) GetDetails() map[string]interface{} {
+// 各模型池的安全模式状态
+func (sc *StreamController) SafeModes() []SafeModeState {
+	return sc.anomaly.states()
+}
+
+// 查询最近失败的补全
+func (sc *StreamController) QueryErrors(filter JournalFilter) JournalResult {
+	return sc.errors.query(filter)
+}
+
+// 查询用于离线质量评审的补全样本
+func (sc *StreamController) QuerySamples(filter SampleFilter) []Sample {
+	return sc.samples.query(filter)
+}
+
+// 运行时调整模型池的最大并发数
+func (sc *StreamController) ResizePools(modelName string, maxConcurrent int, operator string) ([]PoolResizeRecord, error) {
+	return sc.pools.ResizePools(modelName, maxConcurrent, operator)
+}
+
+/**
+ * 获取等待队列和模型池的明细
+ * @param {DetailsFilter} filter - 字段选择和分页条件
+ * @returns {map[string]interface{}} 返回明细，以及快照时间和序号
+ * @description
+ * - seq在进程内每次获取递增，与time一起用于判断快照是否过时
+ */
+func (sc *StreamController) GetDetails(filter DetailsFilter) map[string]interface{} {
+	if filter.Fields == "" {
+		filter.Fields = DetailsFull
+	}
 	details := make(map[string]interface{})
-	details["queues"] = sc.queues.GetDetails()
-	details["pools"] = sc.pools.GetDetails()
+	details["seq"] = sc.detailsSeq.Add(1)
+	details["time"] = time.Now()
+	details["fields"] = filter.Fields
+	details["offset"] = filter.Offset
+	details["limit"] = filter.Limit
+	details["queues"] = sc.queues.GetDetails(filter)
+	details["pools"] = sc.pools.GetDetails(filter)
 	return details
 }
@@ -0,0 +1,163 @@
+package stream_controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+)
+
+// 直接返回检索结果的HTTP客户端，不经过网络
+type fakeSearchClient struct {
+	calls int32
+}
+
+func (f *fakeSearchClient) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&f.calls, 1)
+	body := `{"data":{"list":[{"filePath":"util.js","content":"export const one = 1"}]}}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// 立即返回固定补全内容的模型
+type instantLLM struct {
+	cfg   config.ModelConfig
+	text  string
+	calls int32
+}
+
+func (f *instantLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	atomic.AddInt32(&f.calls, 1)
+	return &model.CompletionResponse{Choices: []model.CompletionChoice{{Text: f.text}}}, &model.CompletionVerbose{}, model.StatusSuccess, nil
+}
+
+func (f *instantLLM) Config() *config.ModelConfig {
+	return &f.cfg
+}
+
+func (f *instantLLM) Tokenizer() *tokenizers.Tokenizer {
+	return nil
+}
+
+// to test concurrent first requests share the injected context client, run with -race
+// go test ./pkg/stream_controller/ -race -v -run Test_ConcurrentFirstRequests
+func Test_ConcurrentFirstRequests(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	const n = 100
+	search := &fakeSearchClient{}
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: n, MaxOutput: 50, DisablePrune: true}, text: "ok"}
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, context: codebase_context.NewContextClientWith(search)}
+
+	var wg sync.WaitGroup
+	inputs := make([]*completions.CompletionInput, n)
+	responses := make([]*completions.CompletionResponse, n)
+	for i := 0; i < n; i++ {
+		inputs[i] = newDedupInput(fmt.Sprintf("client-%d", i), fmt.Sprintf("C%d", i))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = sc.ProcessCompletionV1(context.Background(), inputs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, rsp := range responses {
+		if rsp.Status != model.StatusSuccess {
+			t.Fatalf("request %d failed: %s %s", i, rsp.Status, rsp.Error)
+		}
+		if inputs[i].ContextOutcome != completions.ContextFound {
+			t.Errorf("request %d: expected context found, got %s", i, inputs[i].ContextOutcome)
+		}
+	}
+	if calls := atomic.LoadInt32(&llm.calls); calls != n {
+		t.Errorf("expected %d model calls, got %d", n, calls)
+	}
+	if atomic.LoadInt32(&search.calls) < n {
+		t.Errorf("expected every request to search the codebase, got %d searches", search.calls)
+	}
+}
+
+// to test an identical retry after an empty result is answered from the memo without a model call
+// go test ./pkg/stream_controller/ -v -run Test_EmptyResultMemo
+func Test_EmptyResultMemo(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 1, MaxOutput: 50, DisablePrune: true}}
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, context: codebase_context.NewContextClientWith(&fakeSearchClient{})}
+
+	first := sc.ProcessCompletionV1(context.Background(), newDedupInput("client-memo", "E1"))
+	if first.Status != model.StatusEmpty || first.RetryAdvice != completions.RetryAfterEdit {
+		t.Fatalf("expected an empty result advising retry after edit, got %s %q", first.Status, first.RetryAdvice)
+	}
+	retry := newDedupInput("client-memo", "E2")
+	second := sc.ProcessCompletionV1(context.Background(), retry)
+	if second.Status != model.StatusEmpty || retry.Empty == nil || !retry.Empty.Memoized {
+		t.Fatalf("expected the retry served from the memo, got %s %+v", second.Status, retry.Empty)
+	}
+	if calls := atomic.LoadInt32(&llm.calls); calls != 1 {
+		t.Errorf("expected 1 model call, got %d", calls)
+	}
+	if note, ok := second.Verbose.Input["empty"].(*completions.EmptyResult); !ok || note.Reason != completions.EmptyReasonModel {
+		t.Errorf("expected the memoized reason in verbose, got %+v", second.Verbose.Input["empty"])
+	}
+
+	// 手动触发和光标行变化后都会调用模型
+	manual := newDedupInput("client-memo", "E3")
+	manual.TriggerMode = "manual"
+	sc.ProcessCompletionV1(context.Background(), manual)
+	edited := newDedupInput("client-memo", "E4")
+	edited.Prompts.Prefix = "const three = one + "
+	sc.ProcessCompletionV1(context.Background(), edited)
+	if calls := atomic.LoadInt32(&llm.calls); calls != 3 {
+		t.Errorf("expected manual and edited requests to call the model, got %d calls", calls)
+	}
+}
+
+// to test the V2 and OpenAI routes share the hooks of V1: fingerprint, oversized context reduction and response sequence
+// go test ./pkg/stream_controller/ -v -run Test_SharedHooksAcrossRoutes
+func Test_SharedHooksAcrossRoutes(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	saved := config.Wrapper.Reduce
+	config.Wrapper.Reduce = config.ReduceConfig{MaxContextBytes: 64, NearCursorLines: 3}
+	defer func() { config.Wrapper.Reduce = saved }()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 2, MaxOutput: 50, DisablePrune: true}, text: "ok"}
+	m := NewPoolManager()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m}
+
+	para := &model.CompletionParameter{CompletionID: "v2-hooks", ClientID: "c-hooks", Model: "fake", Language: "javascript",
+		Prefix: "const two = one + ", CodeContext: strings.Repeat("// unrelated snippet\n", 10), MaxTokens: 50}
+	rsp := sc.ProcessCompletionV2(context.Background(), para)
+	if rsp.Fingerprint == "" || rsp.ServerSequence != 1 {
+		t.Errorf("expected the v2 response fingerprinted and sequenced, got %q %d", rsp.Fingerprint, rsp.ServerSequence)
+	}
+	if len(para.CodeContext) > 64 || rsp.Verbose == nil || rsp.Verbose.Input["reductions"] == nil {
+		t.Errorf("expected the oversized v2 context reduced and recorded, got %d bytes", len(para.CodeContext))
+	}
+
+	rsp = sc.ProcessCompletionOpenAI(context.Background(), &model.CompletionRequest{Model: "fake", Prompt: "const two = one + ", MaxTokens: 10})
+	if rsp.Status != model.StatusSuccess || rsp.Fingerprint == "" {
+		t.Errorf("expected the openai response fingerprinted, got %s %q", rsp.Status, rsp.Fingerprint)
+	}
+}
@@ -0,0 +1,148 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"context"
+	"errors"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 严格截止时间模式的结果，用作completion_strict_deadline_total的outcome标签
+const (
+	DeadlineMet = "met" // 在看门狗触发之前正常完成
+	DeadlineHit = "hit" // 看门狗触发，返回了已得到的最好结果
+)
+
+// 处理结果，处理协程panic时为panic的值和当时的协程栈
+type strictResult struct {
+	rsp    *completions.CompletionResponse
+	result RouteResult
+	panic  interface{}
+	stack  []byte
+}
+
+// 请求是否按严格截止时间模式处理：指定了截止时长且请求的开关feature_flag.StrictDeadline开启
+func strictEnabled(flags *feature_flag.Evaluator, deadlineMs int) bool {
+	return deadlineMs > 0 && flags.Enabled(feature_flag.StrictDeadline)
+}
+
+// 严格截止时间的开始计时时间，为接口中间件记录的收到请求的时间(含读取和解析请求体)，没有记录时为当前时间
+func strictReceiveTime(ctx context.Context) time.Time {
+	if t := completions.ReceiveTimeFrom(ctx); !t.IsZero() {
+		return t
+	}
+	return time.Now()
+}
+
+/**
+ * 按严格截止时间模式处理V1格式的补全请求，请求没有指定strict_deadline_ms或开关关闭时同ProcessCompletionOnce
+ * @param {context.Context} ctx - 请求上下文，带有中间件记录的收到请求的时间，见completions.WithReceiveTime
+ * @param {string} route - 收到请求的路由
+ * @param {*completions.CompletionInput} input - 补全输入，StrictDeadlineMs为截止时长
+ * @returns {*completions.CompletionResponse, RouteResult} 返回补全响应，以及由哪个路由处理
+ * @description
+ * - 截止时间从收到请求时开始计算，读取和解析请求体的耗时也计算在内
+ * - 处理过程见runStrict，V2和OpenAI格式的请求见ProcessCompletionV2/ProcessCompletionOpenAI
+ * @example
+ * input.StrictDeadlineMs = 900
+ * rsp, result := sc.ProcessCompletionStrict(ctx, route, input)
+ * // 最迟在收到请求后850ms(安全余量50ms)时返回，rsp.Deadline.Interrupted为被打断的阶段
+ */
+func (sc *StreamController) ProcessCompletionStrict(ctx context.Context, route string,
+	input *completions.CompletionInput) (*completions.CompletionResponse, RouteResult) {
+	ctx, flags := requestFlags(ctx, input.ClientID, TenantOf(input.Headers))
+	if !strictEnabled(flags, input.StrictDeadlineMs) {
+		return sc.ProcessCompletionOnce(ctx, route, input)
+	}
+	margin := config.Config.StreamController.StrictDeadline.SafetyMargin
+	deadline := completions.NewStrictDeadline(input, strictReceiveTime(ctx), margin)
+	return sc.runStrict(ctx, deadline, RouteResult{ServedBy: route, Outcome: RouteServed},
+		func(ctx context.Context) (*completions.CompletionResponse, RouteResult) {
+			return sc.ProcessCompletionOnce(ctx, route, input)
+		})
+}
+
+/**
+ * 在看门狗的监视下处理请求
+ * @param {context.Context} ctx - 请求上下文
+ * @param {*completions.StrictDeadline} deadline - 请求的看门狗状态
+ * @param {RouteResult} fired - 看门狗触发时返回的路由结果
+ * @param {func} process - 处理请求，使用传入的带截止时间的ctx
+ * @returns {*completions.CompletionResponse, RouteResult} 返回补全响应和路由结果
+ * @description
+ * - 处理在另一个协程中进行，请求上下文的截止时间为看门狗触发的时间(截止时间减去streamController.strictDeadline.safetyMargin)，
+ *   排队、获取上下文和模型调用到时按截止时间取消，后置处理跳过剩余的处理器
+ * - 看门狗触发时不等待处理协程，立即返回预先构建的最小响应(目前最好的补全内容或空)，见completions.StrictDeadline.Fire；
+ *   处理协程之后的结果只用于统计，不再返回；之后发生的panic不能再交给gin.Recovery，按错误记录日志和协程栈
+ * - 正常完成的响应也附带deadline，按met/hit和被打断的阶段计数
+ * - 客户端断开等其他原因结束时按普通请求等待处理完成
+ */
+func (sc *StreamController) runStrict(ctx context.Context, deadline *completions.StrictDeadline, fired RouteResult,
+	process func(context.Context) (*completions.CompletionResponse, RouteResult)) (*completions.CompletionResponse, RouteResult) {
+	ctx, cancel := context.WithDeadline(completions.WithStrictDeadline(ctx, deadline), deadline.FireAt)
+
+	done := make(chan strictResult, 1)
+	go func() {
+		defer cancel()
+		defer func() {
+			if p := recover(); p != nil {
+				done <- strictResult{panic: p, stack: debug.Stack()}
+			}
+		}()
+		rsp, result := process(ctx)
+		done <- strictResult{rsp: rsp, result: result}
+	}()
+
+	// pending为处理协程还没有结束，之后的panic只能记录日志
+	fire := func(pending bool) (*completions.CompletionResponse, RouteResult) {
+		rsp := deadline.Fire()
+		metrics.IncrementStrictDeadline(DeadlineHit, rsp.Deadline.Interrupted)
+		log := logger.FromContext(ctx)
+		log.Warn("Strict deadline reached, return the best result so far",
+			zap.String("completionID", rsp.ID), zap.Int("deadlineMs", deadline.DeadlineMs),
+			zap.String("interrupted", rsp.Deadline.Interrupted), zap.Bool("empty", rsp.Choices[0].Text == ""))
+		if pending {
+			sc.abandoned.Add(1)
+			go func() {
+				defer sc.abandoned.Done()
+				if r := <-done; r.panic != nil {
+					log.Error("Strict deadline request panicked after the watchdog fired",
+						zap.String("completionID", rsp.ID), zap.Any("panic", r.panic), zap.ByteString("stack", r.stack))
+				}
+			}()
+		}
+		return rsp, fired
+	}
+	var r strictResult
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			r = <-done
+			break
+		}
+		// 处理恰好同时完成时使用完整的结果
+		select {
+		case r = <-done:
+		default:
+			return fire(true)
+		}
+	}
+	if r.panic != nil {
+		panic(r.panic)
+	}
+	// 处理因看门狗触发而中断(排队或模型调用被取消)，与触发同时结束时仍按触发返回
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && r.rsp.Status != model.StatusSuccess {
+		return fire(false)
+	}
+	metrics.IncrementStrictDeadline(DeadlineMet, "")
+	return deadline.Met(r.rsp), r.result
+}
@@ -0,0 +1,184 @@
+package stream_controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/logger"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// slowLLM answers after delay, or when its context ends like the OpenAI client
+type slowLLM struct {
+	cfg   config.ModelConfig
+	delay time.Duration
+}
+
+func (f *slowLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	select {
+	case <-time.After(f.delay):
+		return &model.CompletionResponse{Choices: []model.CompletionChoice{{Text: "two"}}}, &model.CompletionVerbose{}, model.StatusSuccess, nil
+	case <-ctx.Done():
+		return nil, &model.CompletionVerbose{}, model.StatusTimeout, ctx.Err()
+	}
+}
+
+func (f *slowLLM) Config() *config.ModelConfig {
+	return &f.cfg
+}
+
+func (f *slowLLM) Tokenizer() *tokenizers.Tokenizer {
+	return nil
+}
+
+// setupStrictDeadline sets the watchdog safety margin, returns a restore func
+func setupStrictDeadline(margin time.Duration) func() {
+	saved := config.Config.StreamController.StrictDeadline
+	config.Config.StreamController.StrictDeadline = config.StrictDeadlineConfig{SafetyMargin: margin}
+	return func() {
+		config.Config.StreamController.StrictDeadline = saved
+	}
+}
+
+// to test strict deadline responses land inside the deadline with a slow model, and fast ones are unchanged
+// go test ./pkg/stream_controller/ -v -run Test_StrictDeadline
+func Test_StrictDeadline(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(time.Millisecond)()
+	defer setupStrictDeadline(20 * time.Millisecond)()
+	const route = "/code-completion/api/v1/completions"
+	const deadline, jitter = 200 * time.Millisecond, 25 * time.Millisecond
+
+	slow := &slowLLM{cfg: config.ModelConfig{ModelName: "slow", MaxConcurrent: 4, MaxOutput: 50, DisablePrune: true}, delay: time.Second}
+	sc := newInvalidateController(slow)
+	defer sc.abandoned.Wait()
+	sc.context = codebase_context.NewContextClient()
+	for i := 0; i < 10; i++ {
+		input := newDedupInput(fmt.Sprintf("c%d", i), fmt.Sprintf("slow-%d", i))
+		input.StrictDeadlineMs = int(deadline.Milliseconds())
+		start := time.Now()
+		rsp, _ := sc.ProcessCompletionStrict(context.Background(), route, input)
+		elapsed := time.Since(start)
+		if elapsed > deadline+jitter || elapsed < deadline-2*jitter {
+			t.Errorf("request %d: expected a response at the deadline, got it after %v", i, elapsed)
+		}
+		if rsp.Status != model.StatusTimeout || rsp.Deadline == nil || rsp.Deadline.Interrupted != completions.PhaseModel ||
+			!slices.Equal(rsp.Deadline.Skipped, []string{completions.PhaseModel, completions.PhasePrune, completions.PhaseFinalize}) {
+			t.Errorf("request %d: unexpected response %s %+v", i, rsp.Status, rsp.Deadline)
+		}
+	}
+
+	// 在截止时间内完成的请求返回完整的结果
+	fast := &instantLLM{cfg: config.ModelConfig{ModelName: "instant", MaxConcurrent: 1, MaxOutput: 50}, text: "two"}
+	sc = newInvalidateController(fast)
+	defer sc.abandoned.Wait()
+	input := newDedupInput("c-fast", "fast")
+	input.StrictDeadlineMs = 500
+	rsp, _ := sc.ProcessCompletionStrict(context.Background(), route, input)
+	if rsp.Status != model.StatusSuccess || rsp.Choices[0].Text != "two" || rsp.Deadline == nil ||
+		rsp.Deadline.DeadlineMs != 500 || rsp.Deadline.Interrupted != "" {
+		t.Errorf("unexpected fast response %s %q %+v", rsp.Status, rsp.Choices[0].Text, rsp.Deadline)
+	}
+	// 没有指定截止时间时按普通请求处理
+	if rsp, _ := sc.ProcessCompletionStrict(context.Background(), route, newDedupInput("c-plain", "plain")); rsp.Deadline != nil {
+		t.Errorf("expected no deadline report, got %+v", rsp.Deadline)
+	}
+
+	// 后置处理中触发时返回已报告的内容
+	sd := completions.NewStrictDeadline(input, time.Now(), 0)
+	sd.Enter(completions.PhasePrune)
+	sd.Offer("instant", "two + three", completions.CompletionAnchor{})
+	rsp = sd.Fire()
+	sd.Offer("", "late", completions.CompletionAnchor{})
+	if rsp.Status != model.StatusSuccess || rsp.Choices[0].Text != "two + three" || rsp.Choices[0].CursorOffset != 11 ||
+		!slices.Equal(rsp.Deadline.Skipped, []string{completions.PhasePrune, completions.PhaseFinalize}) {
+		t.Errorf("unexpected best effort response %s %+v %+v", rsp.Status, rsp.Choices[0], rsp.Deadline)
+	}
+	if again := sd.Fire(); again.Choices[0].Text != "two + three" {
+		t.Errorf("expected offers after firing ignored, got %q", again.Choices[0].Text)
+	}
+	// 到了触发时间之后进入的阶段不改变被打断的阶段
+	late := completions.NewStrictDeadline(input, time.Now().Add(-time.Second), 0)
+	late.Enter(completions.PhaseFinalize)
+	if rsp := late.Fire(); rsp.Deadline.Interrupted != completions.PhasePreprocess {
+		t.Errorf("expected the phase at the deadline kept, got %+v", rsp.Deadline)
+	}
+}
+
+// to test the deadline counts from the receive time, V2 and OpenAI requests honor it, and strict requests do not publish to dedup
+// go test ./pkg/stream_controller/ -v -run Test_StrictDeadlineRoutes
+func Test_StrictDeadlineRoutes(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(time.Millisecond)()
+	defer setupStrictDeadline(20 * time.Millisecond)()
+	const route = "/code-completion/api/v1/completions"
+	const deadline, jitter = 200 * time.Millisecond, 25 * time.Millisecond
+
+	slow := &slowLLM{cfg: config.ModelConfig{ModelName: "slow", MaxConcurrent: 4, MaxOutput: 50, DisablePrune: true}, delay: time.Second}
+	sc := newInvalidateController(slow)
+	defer sc.abandoned.Wait()
+	sc.context = codebase_context.NewContextClient()
+
+	// 读取请求体已用去100ms，看门狗提前触发
+	received := time.Now().Add(-100 * time.Millisecond)
+	ctx := completions.WithReceiveTime(context.Background(), received)
+	input := newDedupInput("c-received", "received")
+	input.StrictDeadlineMs = int(deadline.Milliseconds())
+	rsp, _ := sc.ProcessCompletionStrict(ctx, route, input)
+	if elapsed := time.Since(received); elapsed > deadline+jitter || rsp.Deadline == nil || rsp.Deadline.Interrupted == "" {
+		t.Errorf("expected the watchdog fired at the deadline from the receive time, got %v %+v", elapsed, rsp.Deadline)
+	}
+	// 严格截止时间的请求不写入去重记录，被打断的结果不重放给重试的请求
+	if _, ok := sc.dedup.entries.Get(dedupKey("c-received", "received")); ok {
+		t.Errorf("expected no dedup entry for a strict request")
+	}
+
+	start := time.Now()
+	rsp = sc.ProcessCompletionV2(context.Background(), &model.CompletionParameter{CompletionID: "v2", ClientID: "c-v2",
+		Model: "slow", Prefix: "const two = one + ", MaxTokens: 50, StrictDeadlineMs: int(deadline.Milliseconds())})
+	if elapsed := time.Since(start); elapsed > deadline+jitter || rsp.ID != "v2" || rsp.Deadline == nil ||
+		rsp.Deadline.Interrupted != completions.PhaseModel {
+		t.Errorf("expected the v2 request returned at the deadline, got %v %q %+v", elapsed, rsp.ID, rsp.Deadline)
+	}
+	start = time.Now()
+	rsp = sc.ProcessCompletionOpenAI(context.Background(), &model.CompletionRequest{Model: "slow", Prompt: "const two = one + ",
+		MaxTokens: 50, StrictDeadlineMs: int(deadline.Milliseconds())})
+	if elapsed := time.Since(start); elapsed > deadline+jitter || rsp.Deadline == nil || rsp.Deadline.Interrupted != completions.PhaseModel {
+		t.Errorf("expected the openai request returned at the deadline, got %v %+v", elapsed, rsp.Deadline)
+	}
+}
+
+// to test a panic after the watchdog fired is logged with its stack instead of lost
+// go test ./pkg/stream_controller/ -v -run Test_StrictDeadlinePanic
+func Test_StrictDeadlinePanic(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	ctx := logger.WithContext(context.Background(), zap.New(core))
+	sc := &StreamController{}
+	deadline := completions.NewStrictDeadlineFor(50, "cmpl-panic", "slow", time.Now(), 0)
+	rsp, _ := sc.runStrict(ctx, deadline, RouteResult{}, func(ctx context.Context) (*completions.CompletionResponse, RouteResult) {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		panic("boom")
+	})
+	if rsp.ID != "cmpl-panic" || rsp.Status != model.StatusTimeout {
+		t.Fatalf("unexpected fired response %q %s", rsp.ID, rsp.Status)
+	}
+	for i := 0; i < 100 && logs.Len() == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	entries := logs.FilterMessage("Strict deadline request panicked after the watchdog fired").All()
+	if len(entries) != 1 || entries[0].ContextMap()["panic"] != "boom" || entries[0].ContextMap()["stack"] == "" {
+		t.Fatalf("expected the panic logged with its stack, got %+v", logs.All())
+	}
+}
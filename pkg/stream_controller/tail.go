@@ -0,0 +1,232 @@
+package stream_controller
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 跟踪事件的阶段，按请求的处理顺序
+const (
+	TailReceived   = "received"   // 收到请求
+	TailRejected   = "rejected"   // 没有调用模型就结束(预处理过滤、模型池忙、排队时取消等)，Reason为原因
+	TailQueued     = "queued"     // 进入等待队列
+	TailDispatched = "dispatched" // 模型池取出请求，开始调用模型
+	TailPruned     = "pruned"     // 模型返回并完成后置处理，Hits为命中的后置处理器
+	TailReturned   = "returned"   // 返回响应
+)
+
+var (
+	ErrTailBusy       = errors.New("client is already being tailed")
+	ErrTailClosed     = errors.New("tail is closed")
+	ErrTailTextDenied = errors.New("including completion text is not allowed, 'streamController.tail.allowText' is off")
+	errTailNoClient   = errors.New("missing client id")
+)
+
+/**
+ * 客户端补全活动的一个事件，不含提示词
+ * @description
+ * - Seq: 该跟踪流内的递增序号，序号不连续说明读取跟不上时丢弃了事件
+ * - Text: 只在允许附带补全内容时记录返回的补全内容
+ */
+type TailEvent struct {
+	Seq              uint64    `json:"seq"`
+	Stage            string    `json:"stage"`
+	Time             time.Time `json:"time"`
+	CompletionID     string    `json:"completionId"`
+	Model            string    `json:"model,omitempty"`
+	Language         string    `json:"language,omitempty"`
+	TriggerMode      string    `json:"triggerMode,omitempty"`
+	Status           string    `json:"status,omitempty"`
+	Reason           string    `json:"reason,omitempty"`
+	Hits             []string  `json:"hits,omitempty"`
+	QueueMs          int64     `json:"queueMs,omitempty"`
+	LLMMs            int64     `json:"llmMs,omitempty"`
+	TotalMs          int64     `json:"totalMs,omitempty"`
+	PromptTokens     int       `json:"promptTokens,omitempty"`
+	CompletionTokens int       `json:"completionTokens,omitempty"`
+	Text             string    `json:"text,omitempty"`
+}
+
+// 一个客户端的跟踪订阅
+type TailSubscription struct {
+	client  string
+	text    bool
+	events  chan TailEvent
+	seq     uint64
+	dropped atomic.Int64
+	bus     *activityBus
+}
+
+// 事件通道，订阅关闭或服务关闭时关闭
+func (s *TailSubscription) Events() <-chan TailEvent {
+	return s.events
+}
+
+// 读取跟不上时丢弃的事件数
+func (s *TailSubscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// 取消订阅，可以重复调用
+func (s *TailSubscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+/**
+ * 按客户端分发补全活动事件的总线
+ * @description
+ * - 每个客户端最多一个订阅，没有订阅时发布事件只查一次表
+ * - 发布不阻塞请求的处理，订阅的缓冲满时丢弃事件并计数
+ * - 关闭后关闭所有订阅的事件通道，不再接受新的订阅
+ */
+type activityBus struct {
+	mutex  sync.RWMutex
+	subs   map[string]*TailSubscription
+	closed bool
+}
+
+func newActivityBus() *activityBus {
+	return &activityBus{subs: make(map[string]*TailSubscription)}
+}
+
+// 订阅一个客户端的事件，该客户端已有订阅时返回ErrTailBusy
+func (b *activityBus) subscribe(client string, text bool, buffer int) (*TailSubscription, error) {
+	if client == "" {
+		return nil, errTailNoClient
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrTailClosed
+	}
+	if _, ok := b.subs[client]; ok {
+		return nil, ErrTailBusy
+	}
+	s := &TailSubscription{client: client, text: text, events: make(chan TailEvent, max(buffer, 1)), bus: b}
+	b.subs[client] = s
+	return s, nil
+}
+
+func (b *activityBus) unsubscribe(s *TailSubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subs[s.client] == s {
+		delete(b.subs, s.client)
+		close(s.events)
+	}
+}
+
+// 该客户端是否有订阅，用于避免没有订阅时构造事件
+func (b *activityBus) watching(client string) bool {
+	if b == nil {
+		return false
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.subs[client] != nil
+}
+
+// 发布一个事件，没有订阅时忽略；订阅不允许附带补全内容时去掉Text
+func (b *activityBus) publish(client string, event TailEvent) {
+	if b == nil {
+		return
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	s := b.subs[client]
+	if s == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if !s.text {
+		event.Text = ""
+	}
+	// 读锁下有多个发布者，序号原子递增
+	event.Seq = atomic.AddUint64(&s.seq, 1)
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// 关闭所有订阅，之后不再接受新的订阅
+func (b *activityBus) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	for client, s := range b.subs {
+		close(s.events)
+		delete(b.subs, client)
+	}
+}
+
+// 响应对应的事件，pruned和returned阶段使用
+func responseEvent(stage string, rsp *completions.CompletionResponse) TailEvent {
+	event := TailEvent{
+		Stage:            stage,
+		CompletionID:     rsp.ID,
+		Model:            rsp.Model,
+		Status:           string(rsp.Status),
+		Reason:           responseReason(rsp),
+		QueueMs:          rsp.Usage.QueueDuration,
+		LLMMs:            rsp.Usage.LLMDuration,
+		PromptTokens:     rsp.Usage.PromptTokens,
+		CompletionTokens: rsp.Usage.CompletionTokens,
+	}
+	if len(rsp.Choices) > 0 {
+		event.Text = rsp.Choices[0].Text
+	}
+	return event
+}
+
+// 响应没有补全内容的原因：取消原因、空补全的原因或错误信息
+func responseReason(rsp *completions.CompletionResponse) string {
+	switch {
+	case rsp.Status == model.StatusSuccess:
+		return ""
+	case rsp.CancelCause != "":
+		return string(rsp.CancelCause)
+	case rsp.DiscardedBy != "":
+		return rsp.DiscardedBy
+	case rsp.EmptyReason != "":
+		return rsp.EmptyReason
+	}
+	return rsp.Error
+}
+
+/**
+ * 实时跟踪一个客户端的补全活动
+ * @param {string} client - 客户端ID
+ * @param {bool} includeText - 事件是否附带补全内容，需要配置streamController.tail.allowText
+ * @returns {*TailSubscription, error} 返回订阅，调用方读取完毕后必须Close；该客户端已在跟踪时返回ErrTailBusy
+ * @example
+ * sub, err := Controller.Tail("client-1", false)
+ * defer sub.Close()
+ * for event := range sub.Events() { ... }
+ */
+func (sc *StreamController) Tail(client string, includeText bool) (*TailSubscription, error) {
+	cfg := &config.Config.StreamController.Tail
+	if includeText && !cfg.AllowText {
+		return nil, ErrTailTextDenied
+	}
+	return sc.tails.subscribe(client, includeText, cfg.Buffer)
+}
+
+// 结束所有跟踪流，服务关闭时调用
+func (sc *StreamController) CloseTails() {
+	sc.tails.close()
+}
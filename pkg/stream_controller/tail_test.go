@@ -0,0 +1,107 @@
+package stream_controller
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 读取订阅中已有的事件
+func drainTail(sub *TailSubscription) []TailEvent {
+	var events []TailEvent
+	for {
+		select {
+		case event := <-sub.Events():
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// to test the ordered lifecycle events of one client arriving on its tail
+// go test ./pkg/stream_controller/ -v -run Test_TailEvents
+func Test_TailEvents(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer setupPerfConfig(0)()
+	old := config.Config.StreamController.Tail
+	defer func() { config.Config.StreamController.Tail = old }()
+	config.Config.StreamController.Tail = config.TailConfig{Buffer: 16}
+
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 2, MaxOutput: 50, DisablePrune: true}, text: "two"}
+	m := NewPoolManager()
+	m.tails = newActivityBus()
+	m.initPool("fake", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, tails: m.tails,
+		context: codebase_context.NewContextClientWith(&fakeSearchClient{})}
+
+	if _, err := sc.Tail("client-t", true); !errors.Is(err, ErrTailTextDenied) {
+		t.Errorf("expected the completion text denied by default, got %v", err)
+	}
+	sub, err := sc.Tail("client-t", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if _, err := sc.Tail("client-t", false); !errors.Is(err, ErrTailBusy) {
+		t.Errorf("expected a single tail per client, got %v", err)
+	}
+
+	// 其他客户端的请求不出现在跟踪流中
+	sc.ProcessCompletionV1(context.Background(), newDedupInput("client-other", "T0"))
+	rsp := sc.ProcessCompletionV1(context.Background(), newDedupInput("client-t", "T1"))
+	if rsp.Status != model.StatusSuccess {
+		t.Fatalf("expected success, got %s", rsp.Status)
+	}
+	events := drainTail(sub)
+	var stages []string
+	for _, event := range events {
+		stages = append(stages, event.Stage)
+		if event.CompletionID != "T1" || event.Text != "" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+	if !slices.Equal(stages, []string{TailReceived, TailQueued, TailDispatched, TailPruned, TailReturned}) {
+		t.Fatalf("unexpected stages %v", stages)
+	}
+	if last := events[4]; last.Status != string(model.StatusSuccess) || last.Model != "fake" || last.Seq != 5 || last.Time.IsZero() {
+		t.Errorf("unexpected returned event %+v", last)
+	}
+
+	// 没有调用模型的请求以rejected和returned结束，带拒绝原因
+	rejected := newDedupInput("client-t", "")
+	sc.ProcessCompletionV1(context.Background(), rejected)
+	events = drainTail(sub)
+	if len(events) != 3 || events[1].Stage != TailRejected || events[1].Status != string(model.StatusRejected) ||
+		events[1].Reason == "" || events[2].Stage != TailReturned {
+		t.Errorf("unexpected events of the rejected request %+v", events)
+	}
+
+	// 允许附带补全内容时，关闭后重新订阅
+	sub.Close()
+	config.Config.StreamController.Tail.AllowText = true
+	sub, err = sc.Tail("client-t", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.ProcessCompletionV1(context.Background(), newDedupInput("client-t", "T2"))
+	events = drainTail(sub)
+	if len(events) == 0 || events[len(events)-1].Text != "two" {
+		t.Errorf("expected the completion text on the returned event, got %+v", events)
+	}
+
+	// 服务关闭时关闭事件通道
+	sc.CloseTails()
+	if _, ok := <-sub.Events(); ok {
+		t.Error("expected the events closed")
+	}
+	if _, err := sc.Tail("client-t", false); !errors.Is(err, ErrTailClosed) {
+		t.Errorf("expected no tail after closing, got %v", err)
+	}
+}
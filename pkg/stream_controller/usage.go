@@ -0,0 +1,380 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+//	token用量账本: 按天累计每个租户和客户端的提示词和补全token，定时写入文件，用于按业务单元分摊费用。
+//	Prometheus的直方图在长期保留后丢失精确的合计，不能用于对账
+//
+
+// 超出租户数或客户端数上限时归入的名称
+const usageOverflow = "_other"
+
+// 持久化文件的格式版本
+const usageFileVersion = 1
+
+// 按天统计的日期格式
+const usageDateLayout = "2006-01-02"
+
+/**
+ * 一段时间内的用量合计
+ * @description
+ * - PromptTokens: 提示词token数，上游报告的用量，失败的请求为估算值
+ * - CompletionTokens: 计费的补全token数，模型返回多个候选时为合计生成的用量
+ */
+type UsageTotals struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"promptTokens"`
+	CompletionTokens int64 `json:"completionTokens"`
+}
+
+func (u *UsageTotals) add(o UsageTotals) {
+	u.Requests += o.Requests
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+}
+
+// 一个租户一天的用量
+type tenantUsage struct {
+	Total   UsageTotals             `json:"total"`
+	Clients map[string]*UsageTotals `json:"clients"`
+}
+
+// 持久化文件的内容，days的key为日期，值的key为租户
+type usageFile struct {
+	Version int                                `json:"version"`
+	Days    map[string]map[string]*tenantUsage `json:"days"`
+}
+
+// 查询的一天的用量
+type UsageDay struct {
+	Date string `json:"date"`
+	UsageTotals
+	TotalTokens int64 `json:"totalTokens"`
+}
+
+// 查询用量的条件，日期为服务时区的yyyy-mm-dd，包含首尾两天
+type UsageFilter struct {
+	Tenant string
+	Client string // 为空时返回租户的合计
+	From   string
+	To     string
+}
+
+/**
+ * 按租户和客户端累计的token用量
+ * @description
+ * - 内存中保存保留期内每天的用量，是查询的数据来源；每FlushInterval把有变化的数据整体写入文件
+ * - 写入先写临时文件再改名，进程在写入时退出不会损坏已有的文件
+ * - 启动时从文件恢复，文件无法解析时改名为.corrupt-<时间戳>后从空账本开始，不覆盖原来的数据
+ * - 用量按接收请求的时间在服务时区(main.go按环境变量TZ设置time.Local)的日期归入，
+ *   与完成时间和写入时间无关：跨午夜完成的请求、午夜之后才写入的数据都计入接收请求的那一天
+ */
+type usageLedger struct {
+	cfg   *config.UsageConfig
+	clock func() time.Time
+	loc   *time.Location // 划分日期的时区
+
+	mutex sync.Mutex
+	days  map[string]map[string]*tenantUsage
+	dirty bool // 有未写入文件的变化
+
+	flushing sync.Mutex // 依次写入文件，先序列化的数据不会覆盖后序列化的数据
+}
+
+/**
+ * 创建用量账本，配置了文件路径时从文件恢复
+ * @param {*config.UsageConfig} cfg - 配置streamController.usage
+ * @param {func() time.Time} clock - 时钟，为nil时使用time.Now
+ * @returns {*usageLedger} 返回账本，未启用时返回nil，nil账本的方法都不做任何事
+ */
+func newUsageLedger(cfg *config.UsageConfig, clock func() time.Time) *usageLedger {
+	if !cfg.Enabled {
+		return nil
+	}
+	if clock == nil {
+		clock = time.Now
+	}
+	l := &usageLedger{
+		cfg:   cfg,
+		clock: clock,
+		loc:   time.Local,
+		days:  make(map[string]map[string]*tenantUsage),
+	}
+	if err := l.load(); err != nil {
+		zap.L().Error("Failed to restore the token usage", zap.String("path", cfg.Path), zap.Error(err))
+	}
+	return l
+}
+
+// 从文件恢复，文件不存在时从空账本开始
+func (l *usageLedger) load() error {
+	if l.cfg.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(l.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f usageFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Version != usageFileVersion {
+		corrupt := fmt.Sprintf("%s.corrupt-%d", l.cfg.Path, l.clock().Unix())
+		if renameErr := os.Rename(l.cfg.Path, corrupt); renameErr != nil {
+			return renameErr
+		}
+		return fmt.Errorf("invalid usage file (version %d), moved to %s: %v", f.Version, corrupt, err)
+	}
+	if f.Days != nil {
+		l.days = f.Days
+	}
+	l.expire()
+	return nil
+}
+
+// 去掉超过保留期的日期，调用者持有锁(或在创建时)
+func (l *usageLedger) expire() {
+	if l.cfg.RetentionDays <= 0 {
+		return
+	}
+	oldest := l.clock().In(l.loc).AddDate(0, 0, -l.cfg.RetentionDays).Format(usageDateLayout)
+	for date := range l.days {
+		if date < oldest {
+			delete(l.days, date)
+			l.dirty = true
+		}
+	}
+}
+
+/**
+ * 累计一个补全请求的用量
+ * @param {string} tenant - 租户，为空时为default
+ * @param {string} clientID - 客户端ID
+ * @param {*completions.CompletionResponse} rsp - 补全响应，用量取自rsp.Usage，与返回给客户端的usage相同
+ * @description
+ * - 自测探针的请求和没有用量的请求(如排队前被拒绝)不计入
+ * - 按rsp.Usage.ReceiveTime归入日期，没有接收时间时使用当前时间
+ * - 当天的租户数或租户的客户端数达到上限时，计入"_other"
+ */
+func (l *usageLedger) record(tenant, clientID string, rsp *completions.CompletionResponse) {
+	if l == nil || rsp == nil || rsp.Usage.Probe {
+		return
+	}
+	billed := rsp.Usage.CompletionTokens
+	if rsp.Usage.GeneratedTokens > 0 {
+		billed = rsp.Usage.GeneratedTokens
+	}
+	usage := UsageTotals{Requests: 1, PromptTokens: int64(rsp.Usage.PromptTokens), CompletionTokens: int64(billed)}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return
+	}
+	received := rsp.Usage.ReceiveTime
+	if received.IsZero() {
+		received = l.clock()
+	}
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	l.add(received.In(l.loc).Format(usageDateLayout), tenant, clientID, usage)
+}
+
+// 累计到某天某个租户和客户端的用量
+func (l *usageLedger) add(date, tenant, clientID string, usage UsageTotals) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	tenants, ok := l.days[date]
+	if !ok {
+		tenants = make(map[string]*tenantUsage)
+		l.days[date] = tenants
+	}
+	t, ok := tenants[tenant]
+	if !ok {
+		if len(tenants) >= l.cfg.MaxTenants && l.cfg.MaxTenants > 0 {
+			tenant, clientID = usageOverflow, usageOverflow
+			t = tenants[tenant]
+		}
+		if t == nil {
+			t = &tenantUsage{Clients: make(map[string]*UsageTotals)}
+			tenants[tenant] = t
+		}
+	}
+	if t.Clients == nil {
+		t.Clients = make(map[string]*UsageTotals)
+	}
+	c, ok := t.Clients[clientID]
+	if !ok {
+		if len(t.Clients) >= l.cfg.MaxClients && l.cfg.MaxClients > 0 {
+			clientID = usageOverflow
+			c = t.Clients[clientID]
+		}
+		if c == nil {
+			c = &UsageTotals{}
+			t.Clients[clientID] = c
+		}
+	}
+	t.Total.add(usage)
+	c.add(usage)
+	l.dirty = true
+}
+
+/**
+ * 把有变化的用量写入文件
+ * @returns {error} 写入失败时返回错误，下次写入时重试
+ * @description
+ * - 没有配置文件路径或没有变化时不写入
+ * - 同时去掉超过保留期的日期
+ */
+func (l *usageLedger) flush() error {
+	if l == nil {
+		return nil
+	}
+	l.flushing.Lock()
+	defer l.flushing.Unlock()
+	l.mutex.Lock()
+	l.expire()
+	if l.cfg.Path == "" || !l.dirty {
+		l.mutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(usageFile{Version: usageFileVersion, Days: l.days})
+	l.dirty = false
+	l.mutex.Unlock()
+	if err == nil {
+		err = writeFileAtomic(l.cfg.Path, data)
+	}
+	if err != nil {
+		l.mutex.Lock()
+		l.dirty = true
+		l.mutex.Unlock()
+	}
+	return err
+}
+
+// 先写入同目录下的临时文件再改名
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// 启动定时写入
+func (l *usageLedger) run() {
+	if l == nil || l.cfg.FlushInterval <= 0 {
+		return
+	}
+	go func() {
+		defer DumpOnPanic()
+		ticker := time.NewTicker(l.cfg.FlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := l.flush(); err != nil {
+				zap.L().Error("Failed to flush the token usage", zap.String("path", l.cfg.Path), zap.Error(err))
+			}
+		}
+	}()
+	zap.L().Info("Start token usage accounting", zap.String("path", l.cfg.Path),
+		zap.Duration("flushInterval", l.cfg.FlushInterval))
+}
+
+/**
+ * 查询租户(或租户的一个客户端)每天的用量
+ * @param {UsageFilter} filter - 查询条件
+ * @returns {[]UsageDay, error} 返回按日期排序的每天的合计，没有用量的日期不返回；条件无效时返回错误
+ */
+func (l *usageLedger) query(filter UsageFilter) ([]UsageDay, error) {
+	if filter.Tenant == "" {
+		return nil, fmt.Errorf("tenant is required")
+	}
+	for _, date := range []string{filter.From, filter.To} {
+		if _, err := time.ParseInLocation(usageDateLayout, date, time.UTC); err != nil {
+			return nil, fmt.Errorf("invalid date '%s', expected yyyy-mm-dd", date)
+		}
+	}
+	if filter.From > filter.To {
+		return nil, fmt.Errorf("from %s is after to %s", filter.From, filter.To)
+	}
+	days := []UsageDay{}
+	if l == nil {
+		return days, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for date, tenants := range l.days {
+		t, ok := tenants[filter.Tenant]
+		if !ok || date < filter.From || date > filter.To {
+			continue
+		}
+		totals := &t.Total
+		if filter.Client != "" {
+			if totals, ok = t.Clients[filter.Client]; !ok {
+				continue
+			}
+		}
+		days = append(days, UsageDay{Date: date, UsageTotals: *totals, TotalTokens: totals.PromptTokens + totals.CompletionTokens})
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days, nil
+}
+
+// 服务时区的今天，查询不指定日期时使用
+func (l *usageLedger) today() string {
+	if l == nil {
+		return time.Now().Format(usageDateLayout)
+	}
+	return l.clock().In(l.loc).Format(usageDateLayout)
+}
+
+/**
+ * 查询租户每天的token用量
+ * @param {UsageFilter} filter - 查询条件，From和To为空时为服务时区的今天
+ * @returns {[]UsageDay, error} 返回按日期排序的每天的合计；条件无效时返回错误
+ */
+func (sc *StreamController) QueryUsage(filter UsageFilter) ([]UsageDay, error) {
+	if filter.To == "" {
+		filter.To = sc.usage.today()
+	}
+	if filter.From == "" {
+		filter.From = filter.To
+	}
+	return sc.usage.query(filter)
+}
+
+// 把累计的token用量写入文件，服务关闭时调用
+func (sc *StreamController) FlushUsage() {
+	if err := sc.usage.flush(); err != nil {
+		zap.L().Error("Failed to flush the token usage", zap.Error(err))
+	}
+}
@@ -0,0 +1,158 @@
+package stream_controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 接收时间为received、用量为prompt/completion的补全响应
+func usageResponse(received time.Time, prompt, completion int) *completions.CompletionResponse {
+	return &completions.CompletionResponse{Usage: completions.CompletionPerformance{
+		ReceiveTime: received, PromptTokens: prompt, CompletionTokens: completion,
+	}}
+}
+
+// to test attributing usage to the day the request was received in the service timezone
+// go test ./pkg/stream_controller/ -v -run Test_UsageDayBoundary
+func Test_UsageDayBoundary(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	now := time.Date(2025, 2, 1, 0, 0, 5, 0, shanghai)
+	l := newUsageLedger(&config.UsageConfig{Enabled: true, RetentionDays: 400}, func() time.Time { return now })
+	l.loc = shanghai
+
+	// 午夜前接收、午夜后才完成并累计的请求计入前一天
+	l.record("bu-a", "c1", usageResponse(time.Date(2025, 1, 31, 23, 59, 59, 500e6, shanghai), 100, 10))
+	// 接收时间是UTC的1月31日，服务时区已是2月1日
+	l.record("bu-a", "c1", usageResponse(time.Date(2025, 1, 31, 16, 0, 1, 0, time.UTC), 200, 20))
+	l.record("bu-a", "c2", usageResponse(time.Date(2025, 2, 1, 0, 0, 2, 0, shanghai), 300, 30))
+	// 多候选按合计生成的用量计费；探针和没有用量的请求不计入
+	multi := usageResponse(time.Date(2025, 2, 1, 0, 0, 3, 0, shanghai), 50, 5)
+	multi.Usage.GeneratedTokens = 15
+	l.record("", "c3", multi)
+	probe := usageResponse(now, 1000, 100)
+	probe.Usage.Probe = true
+	l.record("bu-a", "c1", probe)
+	l.record("bu-a", "c1", usageResponse(now, 0, 0))
+
+	days, err := l.query(UsageFilter{Tenant: "bu-a", From: "2025-01-31", To: "2025-02-01"})
+	if err != nil || len(days) != 2 {
+		t.Fatalf("expected 2 days, got %+v %v", days, err)
+	}
+	if d := days[0]; d.Date != "2025-01-31" || d.Requests != 1 || d.PromptTokens != 100 || d.CompletionTokens != 10 {
+		t.Errorf("unexpected usage before midnight %+v", d)
+	}
+	if d := days[1]; d.Date != "2025-02-01" || d.Requests != 2 || d.PromptTokens != 500 || d.TotalTokens != 550 {
+		t.Errorf("unexpected usage after midnight %+v", d)
+	}
+	if days, _ := l.query(UsageFilter{Tenant: "bu-a", Client: "c2", From: "2025-01-31", To: "2025-02-01"}); len(days) != 1 || days[0].PromptTokens != 300 {
+		t.Errorf("unexpected usage of the client %+v", days)
+	}
+	if days, _ := l.query(UsageFilter{Tenant: DefaultTenant, From: "2025-02-01", To: "2025-02-01"}); len(days) != 1 || days[0].CompletionTokens != 15 {
+		t.Errorf("expected the generated tokens billed to the default tenant, got %+v", days)
+	}
+	for _, invalid := range []UsageFilter{{From: "2025-02-01", To: "2025-02-01"}, {Tenant: "bu-a", From: "2025/02/01", To: "2025-02-01"},
+		{Tenant: "bu-a", From: "2025-02-02", To: "2025-02-01"}} {
+		if _, err := l.query(invalid); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+// 上游报告用量的模型
+type usageLLM struct {
+	instantLLM
+}
+
+func (f *usageLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	rsp, verbose, status, err := f.instantLLM.Completions(ctx, p)
+	rsp.Usage = model.CompletionUsage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}
+	return rsp, verbose, status, err
+}
+
+// to test the openai route records usage under the tenant of the request's credential
+// go test ./pkg/stream_controller/ -v -run Test_UsageOpenAI
+func Test_UsageOpenAI(t *testing.T) {
+	defer setupPerfConfig(0)()
+	llm := &usageLLM{instantLLM{cfg: config.ModelConfig{ModelName: "usage", MaxConcurrent: 1, MaxOutput: 50}, text: "ok"}}
+	m := NewPoolManager()
+	m.initPool("usage", llm, llm.Config())
+	sc := &StreamController{queues: NewQueueManager(), pools: m, errors: newErrorJournal(10),
+		usage: newUsageLedger(&config.UsageConfig{Enabled: true, RetentionDays: 1}, nil)}
+
+	rsp := sc.ProcessCompletionOpenAI(context.Background(), &model.CompletionRequest{Model: "usage", Prompt: "a = ", MaxTokens: 10, Tenant: "bu-a"})
+	if rsp.Status != model.StatusSuccess {
+		t.Fatalf("unexpected status %s", rsp.Status)
+	}
+	today := time.Now().Format(usageDateLayout)
+	days, err := sc.usage.query(UsageFilter{Tenant: "bu-a", From: today, To: today})
+	if err != nil || len(days) != 1 || days[0].Requests != 1 || days[0].PromptTokens != 12 || days[0].CompletionTokens != 3 {
+		t.Errorf("expected the openai completion recorded for the tenant, got %+v %v", days, err)
+	}
+}
+
+// to test restoring the counters from the persisted file after a restart
+// go test ./pkg/stream_controller/ -v -run Test_UsageRestartRecovery
+func Test_UsageRestartRecovery(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	cfg := &config.UsageConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "usage", "usage.json"), RetentionDays: 30, MaxClients: 1}
+	newLedger := func() *usageLedger {
+		l := newUsageLedger(cfg, clock)
+		l.loc = time.UTC
+		return l
+	}
+	query := func(l *usageLedger, client string) UsageDay {
+		days, err := l.query(UsageFilter{Tenant: "bu-a", Client: client, From: "2025-03-10", To: "2025-03-10"})
+		if err != nil || len(days) != 1 {
+			t.Fatalf("expected one day, got %+v %v", days, err)
+		}
+		return days[0]
+	}
+
+	l := newLedger()
+	l.record("bu-a", "c1", usageResponse(now, 100, 10))
+	l.record("bu-a", "c2", usageResponse(now, 200, 20)) // 超出客户端数上限
+	l.add("2025-01-01", "bu-a", "c1", UsageTotals{Requests: 1, PromptTokens: 1})
+	if err := l.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后从文件恢复，继续累计
+	l = newLedger()
+	if d := query(l, ""); d.Requests != 2 || d.PromptTokens != 300 || d.CompletionTokens != 30 {
+		t.Errorf("expected the totals restored, got %+v", d)
+	}
+	if d := query(l, usageOverflow); d.PromptTokens != 200 {
+		t.Errorf("expected the client over the limit counted as %s, got %+v", usageOverflow, d)
+	}
+	if days, _ := l.query(UsageFilter{Tenant: "bu-a", From: "2025-01-01", To: "2025-01-01"}); len(days) != 0 {
+		t.Errorf("expected the days beyond the retention dropped, got %+v", days)
+	}
+	l.record("bu-a", "c1", usageResponse(now, 1, 1))
+	if err := l.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if d := query(newLedger(), "c1"); d.Requests != 2 || d.PromptTokens != 101 {
+		t.Errorf("expected the counters accumulated across restarts, got %+v", d)
+	}
+
+	// 无法解析的文件改名保留，从空账本开始
+	if err := os.WriteFile(cfg.Path, []byte("{truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l = newLedger()
+	if days, _ := l.query(UsageFilter{Tenant: "bu-a", From: "2025-03-10", To: "2025-03-10"}); len(days) != 0 {
+		t.Errorf("expected an empty ledger, got %+v", days)
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.corrupt-%d", cfg.Path, now.Unix())); err != nil {
+		t.Errorf("expected the corrupt file kept: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+//	启动预热: 启动后缓存、分词器和代码上下文缓存都是冷的，先降低模型池的并发数、提高AUTO触发的隐藏分阈值，再逐步恢复
+//
+
+// 预热结束的原因
+const (
+	WarmupEndDuration  = "duration"  // 到达预热时长
+	WarmupEndSuccesses = "successes" // 成功完成的补全数达到配置值
+)
+
+/**
+ * 启动预热的状态，通过/api/stats查看
+ * @description
+ * - Warming: 是否正在预热
+ * - Factor: 模型池并发数占配置值的比例，预热结束后为1
+ * - Successes: 预热期间成功完成的补全数
+ * - EndReason: 预热结束的原因，见WarmupEnd*
+ */
+type WarmupState struct {
+	Warming        bool      `json:"warming"`
+	Factor         float64   `json:"factor"`
+	Since          time.Time `json:"since"`
+	Successes      int       `json:"successes"`
+	ThresholdBoost float64   `json:"thresholdBoost"`
+	EndReason      string    `json:"endReason,omitempty"`
+}
+
+/**
+ * 启动预热
+ * @description
+ * - begin时记录各模型池的并发数作为目标，按StartFactor降低，之后每次step按经过的时间线性恢复
+ * - 预热期间被手动调整过并发数(/api/pools)的模型池不再由预热控制
+ * - 开始、结束和模型池脱离控制时打印日志，比例更新到completion_warmup_factor指标
+ */
+type warmup struct {
+	mutex     sync.Mutex
+	cfg       *config.WarmupConfig
+	pools     *PoolManager
+	clock     func() time.Time
+	start     time.Time
+	warming   bool
+	factor    float64
+	successes int
+	reason    string
+	targets   map[*ModelPool]int // 预热开始时各模型池的并发数
+	applied   map[*ModelPool]int // 预热最近一次设置的并发数
+}
+
+// 创建启动预热，clock为nil时使用time.Now
+func newWarmup(cfg *config.WarmupConfig, pools *PoolManager, clock func() time.Time) *warmup {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &warmup{
+		cfg:     cfg,
+		pools:   pools,
+		clock:   clock,
+		factor:  1,
+		targets: make(map[*ModelPool]int),
+		applied: make(map[*ModelPool]int),
+	}
+}
+
+// 预热开始时并发数的比例，从StartFactor线性增加到1
+func (w *warmup) factorAt(now time.Time) float64 {
+	start := min(max(w.cfg.StartFactor, 0), 1)
+	if w.cfg.Duration <= 0 {
+		return 1
+	}
+	progress := float64(now.Sub(w.start)) / float64(w.cfg.Duration)
+	return min(start+(1-start)*max(progress, 0), 1)
+}
+
+// 按比例计算的并发数，至少为1
+func warmupConcurrency(target int, factor float64) int {
+	return max(1, int(math.Ceil(float64(target)*factor)))
+}
+
+// 开始预热，未启用时不处理
+func (w *warmup) begin() {
+	if w == nil {
+		return
+	}
+	if !w.cfg.Enabled {
+		metrics.UpdateWarmupFactor(1)
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.start = w.clock()
+	w.warming = true
+	for _, pool := range w.pools.allPools() {
+		pool.mutex.RLock()
+		w.targets[pool] = pool.cfg.MaxConcurrent
+		pool.mutex.RUnlock()
+	}
+	completions.SetWarmupBoost(w.cfg.ThresholdBoost)
+	w.apply(w.factorAt(w.start))
+	zap.L().Info("Warm-up started", zap.Duration("duration", w.cfg.Duration),
+		zap.Int("successes", w.cfg.Successes), zap.Float64("factor", w.factor),
+		zap.Float64("thresholdBoost", w.cfg.ThresholdBoost))
+}
+
+// 按比例调整各模型池的并发数，调用者需持有w.mutex
+func (w *warmup) apply(factor float64) {
+	w.factor = factor
+	metrics.UpdateWarmupFactor(factor)
+
+	w.pools.resizeMutex.Lock()
+	defer w.pools.resizeMutex.Unlock()
+	for _, pool := range w.pools.allPools() {
+		target, ok := w.targets[pool]
+		if !ok {
+			continue
+		}
+		pool.mutex.RLock()
+		current := pool.cfg.MaxConcurrent
+		pool.mutex.RUnlock()
+		if last, ok := w.applied[pool]; ok && last != current {
+			delete(w.targets, pool)
+			delete(w.applied, pool)
+			zap.L().Info("Warm-up released resized pool", zap.String("model", pool.cfg.ModelName),
+				zap.Int("maxConcurrent", current))
+			continue
+		}
+		n := warmupConcurrency(target, factor)
+		if n != current {
+			w.pools.resizePool(pool, n)
+			zap.L().Debug("Warm-up resized pool", zap.String("model", pool.cfg.ModelName),
+				zap.Int("from", current), zap.Int("to", n))
+		}
+		w.applied[pool] = n
+	}
+}
+
+/**
+ * 按经过的时间调整并发数，到达预热时长时结束预热
+ * @returns {bool} 返回是否仍在预热
+ */
+func (w *warmup) step() bool {
+	if w == nil {
+		return false
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.warming {
+		return false
+	}
+	now := w.clock()
+	if now.Sub(w.start) >= w.cfg.Duration {
+		w.finish(WarmupEndDuration)
+		return false
+	}
+	w.apply(w.factorAt(now))
+	return true
+}
+
+// 记录一次补全，成功完成的补全数达到配置值时结束预热
+func (w *warmup) record(rsp *completions.CompletionResponse) {
+	if w == nil || rsp == nil || rsp.Status != model.StatusSuccess {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.warming {
+		return
+	}
+	w.successes++
+	if w.cfg.Successes > 0 && w.successes >= w.cfg.Successes {
+		w.finish(WarmupEndSuccesses)
+	}
+}
+
+// 结束预热，恢复各模型池的并发数，调用者需持有w.mutex
+func (w *warmup) finish(reason string) {
+	w.apply(1)
+	w.warming = false
+	w.reason = reason
+	w.targets = make(map[*ModelPool]int)
+	w.applied = make(map[*ModelPool]int)
+	completions.SetWarmupBoost(0)
+	zap.L().Info("Warm-up finished", zap.String("reason", reason),
+		zap.Duration("elapsed", w.clock().Sub(w.start)), zap.Int("successes", w.successes))
+}
+
+// 定时调整并发数，预热结束后退出
+func (w *warmup) run() {
+	if w == nil || !w.cfg.Enabled {
+		return
+	}
+	go func() {
+		defer DumpOnPanic()
+		ticker := time.NewTicker(w.cfg.Step)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !w.step() {
+				return
+			}
+		}
+	}()
+}
+
+// 当前的预热状态
+func (w *warmup) state() WarmupState {
+	if w == nil {
+		return WarmupState{Factor: 1}
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	state := WarmupState{
+		Warming:   w.warming,
+		Factor:    w.factor,
+		Since:     w.start,
+		Successes: w.successes,
+		EndReason: w.reason,
+	}
+	if w.warming {
+		state.ThresholdBoost = w.cfg.ThresholdBoost
+	}
+	return state
+}
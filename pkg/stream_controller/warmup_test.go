@@ -0,0 +1,107 @@
+package stream_controller
+
+import (
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+)
+
+// 预热期间AUTO和MANUAL触发的补全是否因隐藏分被拒绝，补全的隐藏分为0，配置的阈值为0
+func warmupRejects(triggerMode string) bool {
+	filter := &completions.HiddenScoreFilter{}
+	in := &completions.CompletionInput{CompletionRequest: completions.CompletionRequest{
+		ClientID: "client-warmup", TriggerMode: triggerMode, HideScores: &completions.HiddenScoreOptions{}}}
+	return filter.Judge(nil, in) == completions.LowHiddenScore
+}
+
+// to test the warm-up ramp steps the pool concurrency and the AUTO threshold with the injected clock
+// go test ./pkg/stream_controller/ -v -run Test_WarmupRamp
+func Test_WarmupRamp(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer completions.SetWarmupBoost(0)
+	m := NewPoolManager()
+	large := &instantLLM{cfg: config.ModelConfig{ModelName: "large", MaxConcurrent: 8, MaxOutput: 50}}
+	small := &instantLLM{cfg: config.ModelConfig{ModelName: "small", MaxConcurrent: 3, MaxOutput: 50}}
+	largePool := m.initPool("large", large, large.Config())
+	smallPool := m.initPool("small", small, small.Config())
+
+	now := time.Unix(1000, 0)
+	w := newWarmup(&config.WarmupConfig{Enabled: true, Duration: time.Minute, StartFactor: 0.25, ThresholdBoost: 0.1},
+		m, func() time.Time { return now })
+	w.begin()
+
+	stages := []struct {
+		elapsed      time.Duration
+		large, small int
+		warming      bool
+		boost        float64
+	}{
+		{0, 2, 1, true, 0.1},
+		{30 * time.Second, 5, 2, true, 0.1},
+		{45 * time.Second, 7, 3, true, 0.1},
+		{time.Minute, 8, 3, false, 0},
+	}
+	for _, stage := range stages {
+		now = time.Unix(1000, 0).Add(stage.elapsed)
+		if stage.elapsed > 0 {
+			w.step()
+		}
+		if largePool.cfg.MaxConcurrent != stage.large || smallPool.cfg.MaxConcurrent != stage.small {
+			t.Errorf("at %s: expected concurrency %d/%d, got %d/%d", stage.elapsed, stage.large, stage.small,
+				largePool.cfg.MaxConcurrent, smallPool.cfg.MaxConcurrent)
+		}
+		state := w.state()
+		if state.Warming != stage.warming || completions.WarmupBoost() != stage.boost {
+			t.Errorf("at %s: expected warming %v with boost %.2f, got %+v with boost %.2f", stage.elapsed,
+				stage.warming, stage.boost, state, completions.WarmupBoost())
+		}
+		if warmupRejects("AUTO") != stage.warming || warmupRejects("MANUAL") {
+			t.Errorf("at %s: AUTO rejected %v, MANUAL rejected %v", stage.elapsed, warmupRejects("AUTO"), warmupRejects("MANUAL"))
+		}
+	}
+	if state := w.state(); state.EndReason != WarmupEndDuration || state.Factor != 1 {
+		t.Errorf("expected the warm-up ended by duration at full capacity, got %+v", state)
+	}
+}
+
+// to test the warm-up ends early after the configured successes, and a manually resized pool is left alone
+// go test ./pkg/stream_controller/ -v -run Test_WarmupSuccesses
+func Test_WarmupSuccesses(t *testing.T) {
+	defer setupSchedulerConfig(0, 10*time.Second)()
+	defer completions.SetWarmupBoost(0)
+	m := NewPoolManager()
+	llm := &instantLLM{cfg: config.ModelConfig{ModelName: "fake", MaxConcurrent: 4, MaxOutput: 50}}
+	pool := m.initPool("fake", llm, llm.Config())
+
+	now := time.Unix(1000, 0)
+	w := newWarmup(&config.WarmupConfig{Enabled: true, Duration: time.Minute, Successes: 3, StartFactor: 0.5, ThresholdBoost: 0.1},
+		m, func() time.Time { return now })
+	w.begin()
+	if pool.cfg.MaxConcurrent != 2 {
+		t.Fatalf("expected the pool started at half concurrency, got %d", pool.cfg.MaxConcurrent)
+	}
+
+	// 手动调整后预热不再改动该模型池
+	if _, err := m.ResizePools("fake", 3, "test"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(50 * time.Second)
+	w.step()
+	if pool.cfg.MaxConcurrent != 3 {
+		t.Errorf("warm-up should not override the manual resize, got %d", pool.cfg.MaxConcurrent)
+	}
+
+	for i := 0; i < 3; i++ {
+		w.record(&completions.CompletionResponse{Status: model.StatusEmpty})
+		w.record(&completions.CompletionResponse{Status: model.StatusSuccess})
+	}
+	if state := w.state(); state.Warming || state.EndReason != WarmupEndSuccesses || state.Successes != 3 {
+		t.Errorf("expected the warm-up ended by successes, got %+v", state)
+	}
+	if pool.cfg.MaxConcurrent != 3 || completions.WarmupBoost() != 0 {
+		t.Errorf("expected the manual concurrency kept and no boost, got %d and %.2f", pool.cfg.MaxConcurrent, completions.WarmupBoost())
+	}
+}
@@ -0,0 +1,119 @@
+package tokenizers
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultTokenizerPath is used when a model does not configure a tokenizer path
+const DefaultTokenizerPath = "bin/deepseek-tokenizer/tokenizer.json"
+
+// TokenizerStats describes one loaded tokenizer and the models sharing it
+type TokenizerStats struct {
+	Path        string   `json:"path"`
+	MemoryBytes int64    `json:"memoryBytes"` // estimated by the size of the tokenizer file
+	Models      []string `json:"models"`
+}
+
+// RegistryStats describes all tokenizers loaded by a registry
+type RegistryStats struct {
+	Count       int              `json:"count"`
+	MemoryBytes int64            `json:"memoryBytes"`
+	Tokenizers  []TokenizerStats `json:"tokenizers"`
+}
+
+type registryEntry struct {
+	tokenizer *Tokenizer
+	bytes     int64
+	models    []string
+}
+
+/**
+ * Registry shares one tokenizer instance among the models configured with the same tokenizer file
+ * @description
+ * - Tokenizers are read-only, so an instance is kept for the process lifetime once loaded
+ * - Paths are normalized (absolute and cleaned) before lookup, an empty path means DefaultTokenizerPath
+ * - Failed loads are not cached, the next acquire retries
+ * - Shared instances must not be closed by a single model
+ */
+type Registry struct {
+	mutex   sync.Mutex
+	entries map[string]*registryEntry
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]*registryEntry)}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Acquire returns the shared tokenizer of the default registry
+func Acquire(tokenizerPath, model string) (*Tokenizer, error) {
+	return defaultRegistry.Acquire(tokenizerPath, model)
+}
+
+// Stats returns the loaded tokenizers of the default registry
+func Stats() RegistryStats {
+	return defaultRegistry.Stats()
+}
+
+// normalizePath maps the equivalent spellings of a tokenizer path to one key
+func normalizePath(tokenizerPath string) string {
+	if tokenizerPath == "" {
+		tokenizerPath = DefaultTokenizerPath
+	}
+	if abs, err := filepath.Abs(tokenizerPath); err == nil {
+		return abs
+	}
+	return filepath.Clean(tokenizerPath)
+}
+
+/**
+ * Acquire returns the tokenizer loaded from tokenizerPath, loading it on first use
+ * @param {string} tokenizerPath - tokenizer file path of the model config
+ * @param {string} model - model title, recorded to report which models share the tokenizer
+ * @returns {*Tokenizer} the shared tokenizer instance
+ * @returns {error} error of loading the tokenizer file
+ */
+func (r *Registry) Acquire(tokenizerPath, model string) (*Tokenizer, error) {
+	key := normalizePath(tokenizerPath)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if e, ok := r.entries[key]; ok {
+		e.models = append(e.models, model)
+		return e.tokenizer, nil
+	}
+	t, err := NewTokenizer(key)
+	if err != nil {
+		return nil, err
+	}
+	e := &registryEntry{tokenizer: t, models: []string{model}}
+	if info, err := os.Stat(key); err == nil {
+		e.bytes = info.Size()
+	}
+	r.entries[key] = e
+	return t, nil
+}
+
+// Stats returns the loaded tokenizers sorted by path
+func (r *Registry) Stats() RegistryStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stats := RegistryStats{Tokenizers: make([]TokenizerStats, 0, len(r.entries))}
+	for path, e := range r.entries {
+		stats.Count++
+		stats.MemoryBytes += e.bytes
+		stats.Tokenizers = append(stats.Tokenizers, TokenizerStats{
+			Path:        path,
+			MemoryBytes: e.bytes,
+			Models:      append([]string(nil), e.models...),
+		})
+	}
+	sort.Slice(stats.Tokenizers, func(i, j int) bool {
+		return stats.Tokenizers[i].Path < stats.Tokenizers[j].Path
+	})
+	return stats
+}
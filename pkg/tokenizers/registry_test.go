@@ -0,0 +1,115 @@
+package tokenizers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testTokenizerPath = "testdata/tokenizer.json"
+
+// copyTokenizer copies the test tokenizer to another path
+func copyTokenizer(t testing.TB) string {
+	data, err := os.ReadFile(testTokenizerPath)
+	if err != nil {
+		t.Fatalf("read tokenizer: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "tokenizer.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write tokenizer: %v", err)
+	}
+	return path
+}
+
+// to test that models with the same tokenizer path share one instance
+// go test ./pkg/tokenizers/ -v -run Test_RegistryShare
+func Test_RegistryShare(t *testing.T) {
+	r := NewRegistry()
+	a, err := r.Acquire(testTokenizerPath, "a")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	// the same file spelled differently
+	b, err := r.Acquire("./testdata/../testdata/tokenizer.json", "b")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if a != b {
+		t.Errorf("expected the same instance for the same path")
+	}
+	c, err := r.Acquire(copyTokenizer(t), "c")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if c == a {
+		t.Errorf("expected separate instances for different paths")
+	}
+	if _, err := r.Acquire("testdata/missing.json", "d"); err == nil {
+		t.Errorf("expected an error for a missing tokenizer")
+	}
+
+	stats := r.Stats()
+	if stats.Count != 2 || len(stats.Tokenizers) != 2 {
+		t.Fatalf("expected 2 tokenizers, got %+v", stats)
+	}
+	info, _ := os.Stat(testTokenizerPath)
+	if stats.MemoryBytes != 2*info.Size() {
+		t.Errorf("expected %d bytes, got %d", 2*info.Size(), stats.MemoryBytes)
+	}
+	for _, s := range stats.Tokenizers {
+		if filepath.Base(filepath.Dir(s.Path)) == "testdata" && fmt.Sprint(s.Models) != "[a b]" {
+			t.Errorf("expected models [a b] sharing %s, got %v", s.Path, s.Models)
+		}
+	}
+}
+
+// heapGrowth reports the heap growth of loading 10 duplicate model entries, newLoad is called once per iteration
+func heapGrowth(b *testing.B, newLoad func() func(path string) *Tokenizer) {
+	path := copyTokenizer(b)
+	var before, after runtime.MemStats
+	var total uint64
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		load := newLoad()
+		kept := make([]*Tokenizer, 0, 10)
+		for m := 0; m < 10; m++ {
+			kept = append(kept, load(path))
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		if after.HeapAlloc > before.HeapAlloc {
+			total += after.HeapAlloc - before.HeapAlloc
+		}
+		runtime.KeepAlive(kept)
+	}
+	b.ReportMetric(float64(total)/float64(b.N), "heap-bytes/op")
+}
+
+// go test ./pkg/tokenizers/ -bench Benchmark_Tokenizer -run ^$
+func Benchmark_TokenizerPerModel(b *testing.B) {
+	heapGrowth(b, func() func(string) *Tokenizer {
+		return func(path string) *Tokenizer {
+			t, err := NewTokenizer(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			return t
+		}
+	})
+}
+
+func Benchmark_TokenizerShared(b *testing.B) {
+	heapGrowth(b, func() func(string) *Tokenizer {
+		r := NewRegistry()
+		return func(path string) *Tokenizer {
+			t, err := r.Acquire(path, "m")
+			if err != nil {
+				b.Fatal(err)
+			}
+			return t
+		}
+	})
+}
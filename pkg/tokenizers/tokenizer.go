@@ -17,7 +17,7 @@ type Tokenizer struct {
 func NewTokenizer(tokenizerPath string) (*Tokenizer, error) {
 	// Use the DeepSeek tokenizer file from bin/deepseek-tokenizer
 	if tokenizerPath == "" {
-		tokenizerPath = "bin/deepseek-tokenizer/tokenizer.json"
+		tokenizerPath = DefaultTokenizerPath
 	}
 
 	// Check if the file exists
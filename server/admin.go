@@ -0,0 +1,392 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+	"code-completion/pkg/stream_controller"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理接口认证中间件，要求请求头 Authorization: Bearer <admin.token>，没有配置令牌时拒绝所有请求
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := config.Config.Admin.Token
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin api disabled, 'admin.token' is not configured"})
+			return
+		}
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// 解析since参数，支持RFC3339时间或相对当前的时长(如10m)
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// errorsHandler 错误日志查询处理器
+// @Summary 查询最近失败的补全
+// @Description 查询最近失败的补全记录(不含代码)，并按状态、阶段、错误信息前缀聚合，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param status query string false "补全状态，如timeout/modelError"
+// @Param model query string false "模型名称"
+// @Param since query string false "起始时间，RFC3339或相对时长(如10m)"
+// @Param limit query int false "最多返回的记录数，默认100"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/errors [get]
+func errorsHandler(c *gin.Context) {
+	since, err := parseSince(c.Query("since"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: " + err.Error()})
+		return
+	}
+	limit := 100
+	if s := c.Query("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + s})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": stream_controller.Controller.QueryErrors(stream_controller.JournalFilter{
+			Status: c.Query("status"),
+			Model:  c.Query("model"),
+			Since:  since,
+			Limit:  limit,
+		}),
+	})
+}
+
+// samplesHandler 补全样本查询处理器
+// @Summary 查询补全样本
+// @Description 导出同意采样的客户端的补全样本(含截断后的提示词、模型输出和后置处理后的补全)，用于离线质量评审，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param model query string false "模型名称"
+// @Param language query string false "语言"
+// @Param limit query int false "最多返回的样本数，默认100"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/samples [get]
+func samplesHandler(c *gin.Context) {
+	limit := 100
+	if s := c.Query("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + s})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": stream_controller.Controller.QuerySamples(stream_controller.SampleFilter{
+			Model:    c.Query("model"),
+			Language: c.Query("language"),
+			Limit:    limit,
+		}),
+	})
+}
+
+// usageHandler token用量查询处理器
+// @Summary 查询租户每天的token用量
+// @Description 查询租户(或租户的一个客户端)每天累计的提示词和补全token，用于按业务单元分摊费用；日期按服务时区划分，包含首尾两天，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param tenant query string true "租户，没有租户请求头的请求为default"
+// @Param client query string false "客户端ID，不指定时返回租户的合计"
+// @Param from query string false "开始日期(yyyy-mm-dd)，默认与to相同"
+// @Param to query string false "结束日期(yyyy-mm-dd)，默认今天"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/usage [get]
+func usageHandler(c *gin.Context) {
+	days, err := stream_controller.Controller.QueryUsage(stream_controller.UsageFilter{
+		Tenant: c.Query("tenant"),
+		Client: c.Query("client"),
+		From:   c.Query("from"),
+		To:     c.Query("to"),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    days,
+	})
+}
+
+// configHandler 配置查询处理器
+// @Summary 查询功能开关的状态
+// @Description 查询功能开关的来源(static/http)和各开关当前生效的灰度比例与定向规则；http来源附带开关文档的ETag、最近一次拉取成功的时间和最近一次拉取失败的原因。指定client或tenant时附带各开关对其求值的结果，需要管理令牌
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param client query string false "客户端ID"
+// @Param tenant query string false "租户"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/config [get]
+func configHandler(c *gin.Context) {
+	provider := feature_flag.Current()
+	data := gin.H{"featureFlags": provider.Snapshot()}
+	if client, tenant := c.Query("client"), c.Query("tenant"); client != "" || tenant != "" {
+		evaluated := make(map[string]bool)
+		for _, name := range feature_flag.Names() {
+			evaluated[name] = provider.IsEnabled(name, client, tenant)
+		}
+		data["evaluated"] = evaluated
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    data,
+	})
+}
+
+// diagnosticsHandler 诊断快照处理器
+// @Summary 获取诊断快照
+// @Description 获取与崩溃时输出相同的诊断快照：正在处理的请求摘要(不含代码)、内存存储大小、协程数和内存统计，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Success 200 {object} stream_controller.DiagnosticSnapshot
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/diagnostics [get]
+func diagnosticsHandler(c *gin.Context) {
+	snapshot := stream_controller.Controller.Snapshot("admin")
+	c.Data(http.StatusOK, "application/json; charset=utf-8",
+		snapshot.Marshal(config.Config.StreamController.Diagnostics.MaxBytes))
+}
+
+// clientStyleHandler 客户端代码风格查询处理器
+// @Summary 查询客户端的代码风格档案
+// @Description 查询按该客户端历史请求学习到的各语言的缩进、引号和大括号风格，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param client path string true "客户端ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/clients/{client}/style [get]
+func clientStyleHandler(c *gin.Context) {
+	profiles := completions.Styles().Profile(c.Param("client"))
+	if profiles == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no style profile for client " + c.Param("client")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    profiles,
+	})
+}
+
+// scoreExperimentHandler 隐藏分实验查询处理器
+// @Summary 查询隐藏分权重实验
+// @Description 查询隐藏分权重实验的变体、流量份额和冻结状态，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Success 200 {object} completions.ScoreExperimentStatus
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/score-experiment [get]
+func scoreExperimentHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    completions.ScoreExperimentState(),
+	})
+}
+
+type ScoreExperimentAction struct {
+	Action  string `json:"action"`  // freeze/unfreeze/promote
+	Variant string `json:"variant"` // 冻结或推广的变体，unfreeze时不需要
+}
+
+// updateScoreExperimentHandler 隐藏分实验调整处理器
+// @Summary 冻结或推广隐藏分权重的变体
+// @Description freeze让所有客户端使用指定变体，unfreeze恢复按客户端分组，promote以指定变体结束实验；只修改运行时状态，重启后按配置恢复，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param request body ScoreExperimentAction true "操作"
+// @Success 200 {object} completions.ScoreExperimentStatus
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/score-experiment [post]
+func updateScoreExperimentHandler(c *gin.Context) {
+	var req ScoreExperimentAction
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var status completions.ScoreExperimentStatus
+	var err error
+	switch req.Action {
+	case "freeze":
+		if req.Variant == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "variant is required"})
+			return
+		}
+		status, err = completions.FreezeScoreVariant(req.Variant)
+	case "unfreeze":
+		status, err = completions.FreezeScoreVariant("")
+	case "promote":
+		status, err = completions.PromoteScoreVariant(req.Variant)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown action: " + req.Action})
+		return
+	}
+	if errors.Is(err, completions.ErrVariantNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    status,
+	})
+}
+
+type ReplaySettings struct {
+	Model string `json:"model,omitempty"` // 重放使用的模型，为空时使用原补全的模型
+}
+
+// replayHandler 补全重放处理器
+// @Summary 重放保存了样本的补全
+// @Description 按保存的样本(截断后的提示词和上下文)重建请求，以最低优先级走一遍当前的补全流程，返回原补全和重放的补全及差异；重放不影响客户端的缓存和反馈，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param completion_id path string true "原补全的completion_id"
+// @Param request body ReplaySettings false "重放设置"
+// @Success 200 {object} stream_controller.ReplayResult
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/debug/replay/{completion_id} [post]
+func replayHandler(c *gin.Context) {
+	var req ReplaySettings
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	result, err := stream_controller.Controller.Replay(c.Request.Context(), c.Param("completion_id"), req.Model)
+	if errors.Is(err, stream_controller.ErrNotCaptured) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error() +
+			", the prompt is only retained for sampled completions of consenting clients (stream_controller.samples)"})
+		return
+	}
+	if errors.Is(err, stream_controller.ErrPoolNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    result,
+	})
+}
+
+// promptPreviewHandler 提示词预览处理器
+// @Summary 预览补全请求的提示词
+// @Description 按当前的配置对补全请求做预处理(含获取代码上下文)和截断，返回最终发给模型的提示词，不调用模型；附带前言、上下文分隔符、后缀策略和上下文各片段的来源，预览不影响客户端的缓存和反馈，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param request body completions.CompletionRequest true "补全请求"
+// @Success 200 {object} stream_controller.PromptPreview
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/debug/prompt [post]
+func promptPreviewHandler(c *gin.Context) {
+	var req completions.CompletionInput
+	check := func() *completions.BindingError {
+		return req.CheckExtra(&config.Config.Binding)
+	}
+	if !bindCompletion(c, "preview", &req.CompletionRequest, check) {
+		return
+	}
+	req.Headers = c.Request.Header
+	preview, err := stream_controller.Controller.PreviewPrompt(c.Request.Context(), &req)
+	if errors.Is(err, stream_controller.ErrPoolNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    preview,
+	})
+}
+
+// resetClientStyleHandler 客户端代码风格重置处理器
+// @Summary 清除客户端的代码风格档案
+// @Description 清除该客户端学习到的代码风格，之后重新从请求中学习，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param client path string true "客户端ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/clients/{client}/style [delete]
+func resetClientStyleHandler(c *gin.Context) {
+	if !completions.Styles().Reset(c.Param("client")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no style profile for client " + c.Param("client")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "OK"})
+}
+
+// cancelRequestHandler 补全请求取消处理器
+// @Summary 取消在途的补全请求
+// @Description 取消排队或正在调用模型的补全请求，响应的cancel_cause为admin，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param completion_id path string true "补全请求ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/requests/{completion_id} [delete]
+func cancelRequestHandler(c *gin.Context) {
+	canceled := stream_controller.Controller.CancelCompletion(c.Param("completion_id"))
+	if canceled == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no in-flight request " + c.Param("completion_id")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    gin.H{"canceled": canceled},
+	})
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 读取请求体并缓存到gin.BodyBytesKey，与ShouldBindBodyWith共用缓存，同一请求可以多次解析
+func requestBody(c *gin.Context) ([]byte, error) {
+	if cached, ok := c.Get(gin.BodyBytesKey); ok {
+		if body, ok := cached.([]byte); ok {
+			return body, nil
+		}
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(gin.BodyBytesKey, body)
+	return body, nil
+}
+
+/**
+ * 解析补全请求体
+ * @param {*gin.Context} c - 请求上下文
+ * @param {string} route - 接口标识，作为指标的route标签
+ * @param {interface{}} obj - 解析的目标，须为指针
+ * @param {func() *completions.BindingError} check - 解析后的额外检查(如extra的限制)，可以为nil
+ * @returns {bool} 解析成功时返回true；失败时已返回400响应
+ * @description
+ * - 按配置binding的限制解析，见completions.DecodeJSON；压缩的请求体由decompressBody解压
+ * - 失败时响应{"status": "reqError", "error": 错误信息, "binding": 结构化的错误}，并按错误类别计数
+ */
+func bindCompletion(c *gin.Context, route string, obj interface{}, check func() *completions.BindingError) bool {
+	body, err := requestBody(c)
+	var bindErr *completions.BindingError
+	if err != nil {
+		// 压缩方式和大小限制的错误来自decompressBody
+		if !errors.As(err, &bindErr) {
+			bindErr = &completions.BindingError{Class: completions.BindInvalid, Message: "failed to read request body"}
+		}
+	} else if bindErr = completions.DecodeJSON(body, obj, &config.Config.Binding); bindErr == nil && check != nil {
+		bindErr = check()
+	}
+	if bindErr == nil {
+		return true
+	}
+	metrics.IncrementBindingFailures(route, bindErr.Class)
+	zap.L().Warn("invalid completion request body",
+		zap.String("if", route),
+		zap.String("class", bindErr.Class),
+		zap.String("field", bindErr.Field),
+		zap.String("message", bindErr.Message))
+	c.JSON(http.StatusBadRequest, gin.H{
+		"status":  model.StatusReqError,
+		"error":   bindErr.Message,
+		"binding": bindErr,
+	})
+	return false
+}
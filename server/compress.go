@@ -0,0 +1,173 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求体的压缩方式，作为指标completion_request_encodings_total的encoding标签
+const (
+	EncodingIdentity    = "identity"
+	EncodingGzip        = "gzip"
+	EncodingDeflate     = "deflate" // HTTP的deflate是zlib格式(RFC 1950)
+	EncodingUnsupported = "unsupported"
+)
+
+// 按字节数限制的请求体，超过时返回too_large错误，limit为0表示不限制
+type limitedBody struct {
+	r     io.Reader
+	n     int64
+	limit int64
+	what  string
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.limit > 0 && b.n > b.limit {
+		return n, &completions.BindingError{
+			Class:    completions.BindTooLarge,
+			Expected: fmt.Sprintf("<= %d", b.limit),
+			Message:  fmt.Sprintf("%s exceeds %d bytes", b.what, b.limit),
+		}
+	}
+	return n, err
+}
+
+/**
+ * 解压的请求体，边读边解压，读取时才创建解压器
+ * @description
+ * - 压缩前和解压后的大小分别按配置binding.maxBodyBytes/maxDecompressedBytes限制
+ * - 压缩数据损坏时返回encoding错误，错误(包括io.EOF)一旦出现，之后的读取都返回同一错误
+ * - 读完时记录压缩比
+ */
+type decodedBody struct {
+	body     io.ReadCloser
+	raw      *limitedBody
+	decoded  *limitedBody
+	encoding string
+	err      error
+}
+
+// 创建解压器，gzip和zlib在创建时读取数据头
+func (b *decodedBody) open() (io.Reader, error) {
+	switch b.encoding {
+	case EncodingGzip:
+		return gzip.NewReader(b.raw)
+	case EncodingDeflate:
+		return zlib.NewReader(b.raw)
+	}
+	return b.raw, nil
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.decoded.r == nil {
+		r, err := b.open()
+		if err != nil {
+			b.err = b.encodingError(err)
+			return 0, b.err
+		}
+		b.decoded.r = r
+	}
+	n, err := b.decoded.Read(p)
+	switch {
+	case err == io.EOF:
+		b.err = err
+		if b.encoding != EncodingIdentity && b.raw.n > 0 {
+			metrics.ObserveCompressionRatio(b.encoding, float64(b.decoded.n)/float64(b.raw.n))
+		}
+	case err != nil:
+		b.err = b.encodingError(err)
+	}
+	return n, b.err
+}
+
+func (b *decodedBody) Close() error {
+	if closer, ok := b.decoded.r.(io.Closer); ok {
+		closer.Close()
+	}
+	return b.body.Close()
+}
+
+// 解压的错误转为encoding错误，大小超限的错误和读取请求体的错误保持不变
+func (b *decodedBody) encodingError(err error) error {
+	var bindErr *completions.BindingError
+	if errors.As(err, &bindErr) {
+		return err
+	}
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &completions.BindingError{Class: completions.BindEncoding, Got: b.encoding,
+			Message: fmt.Sprintf("malformed %s request body: truncated stream", b.encoding)}
+	case errors.Is(err, gzip.ErrHeader), errors.Is(err, zlib.ErrHeader):
+		return &completions.BindingError{Class: completions.BindEncoding, Got: b.encoding,
+			Message: fmt.Sprintf("malformed %s request body: invalid header", b.encoding)}
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, zlib.ErrChecksum):
+		return &completions.BindingError{Class: completions.BindEncoding, Got: b.encoding,
+			Message: fmt.Sprintf("malformed %s request body: checksum mismatch", b.encoding)}
+	case errors.As(err, &corrupt):
+		return &completions.BindingError{Class: completions.BindEncoding, Got: b.encoding, Offset: int64(corrupt),
+			Message: fmt.Sprintf("malformed %s request body: corrupt data at offset %d", b.encoding, int64(corrupt))}
+	}
+	return err
+}
+
+/**
+ * 补全请求体的解压中间件
+ * @returns {gin.HandlerFunc} 中间件
+ * @description
+ * - 支持Content-Encoding为gzip和deflate的请求体，用于带宽受限(如VPN)的客户端上传较大的提示词
+ * - 替换c.Request.Body为边读边解压的请求体，之后的读取(bindCompletion)不需要关心压缩方式
+ * - 所有请求体都按binding.maxBodyBytes限制大小，压缩的请求体解压后再按binding.maxDecompressedBytes限制
+ * - 不支持的压缩方式和损坏的压缩数据在解析请求体时返回400，binding.class为encoding，超限时为too_large
+ * - 按压缩方式计数，读完压缩的请求体时记录压缩比
+ */
+func decompressBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := &config.Config.Binding
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		if encoding == "" {
+			encoding = EncodingIdentity
+		}
+		body := &decodedBody{
+			body:     c.Request.Body,
+			raw:      &limitedBody{r: c.Request.Body, limit: int64(cfg.MaxBodyBytes), what: "request body"},
+			decoded:  &limitedBody{limit: int64(cfg.MaxDecompressedBytes), what: "decompressed request body"},
+			encoding: encoding,
+		}
+		switch encoding {
+		case EncodingIdentity:
+			// 未压缩时只按请求体的大小限制
+			body.decoded.limit = 0
+		case EncodingGzip, EncodingDeflate:
+			// 解压后的长度未知
+			c.Request.Header.Del("Content-Encoding")
+			c.Request.ContentLength = -1
+		default:
+			body.err = &completions.BindingError{Class: completions.BindEncoding, Got: encoding,
+				Message: fmt.Sprintf("unsupported Content-Encoding '%s', expected gzip or deflate", encoding)}
+			encoding = EncodingUnsupported
+		}
+		metrics.IncrementRequestEncodings(encoding)
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = body
+		}
+		c.Next()
+	}
+}
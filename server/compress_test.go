@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 压缩的请求体
+func compressBody(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	switch encoding {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	case "deflate":
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	default:
+		t.Fatalf("unknown encoding %s", encoding)
+	}
+	return buf.Bytes()
+}
+
+// 经过解压中间件解析请求体的测试服务，解析成功时原样返回prompt_options.prefix
+func newDecompressRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/completions", decompressBody(), func(c *gin.Context) {
+		var req completions.CompletionRequest
+		if !bindCompletion(c, "test", &req, nil) {
+			return
+		}
+		c.String(http.StatusOK, req.Prompts.Prefix)
+	})
+	return r
+}
+
+// to test compressed request bodies are decoded, and broken or oversized ones are rejected with a clear 400
+// go test ./server/ -v -run Test_DecompressBody
+func Test_DecompressBody(t *testing.T) {
+	old := config.Config.Binding
+	defer func() { config.Config.Binding = old }()
+	config.Config.Binding = config.BindingConfig{MaxDepth: 32, MaxBodyBytes: 256 * 1024, MaxDecompressedBytes: 512 * 1024}
+	r := newDecompressRouter()
+
+	prefix := strings.Repeat("def add(a, b):\n    return a + b\n", 6000)
+	payload, _ := json.Marshal(map[string]interface{}{"prompt_options": map[string]string{"prefix": prefix}})
+	gzipped := compressBody(t, "gzip", payload)
+	bomb, _ := json.Marshal(map[string]interface{}{"prompt_options": map[string]string{"prefix": strings.Repeat(" ", 4<<20)}})
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+		class    string
+		message  string
+	}{
+		{"gzip", "gzip", gzipped, http.StatusOK, "", ""},
+		{"deflate", "deflate", compressBody(t, "deflate", payload), http.StatusOK, "", ""},
+		{"upper case gzip", "GZIP", gzipped, http.StatusOK, "", ""},
+		{"truncated gzip", "gzip", gzipped[:len(gzipped)/2], http.StatusBadRequest, completions.BindEncoding, "malformed gzip request body: truncated stream"},
+		{"not gzip", "gzip", payload, http.StatusBadRequest, completions.BindEncoding, "malformed gzip request body: invalid header"},
+		{"unsupported", "br", gzipped, http.StatusBadRequest, completions.BindEncoding, "unsupported Content-Encoding 'br', expected gzip or deflate"},
+		{"zip bomb", "gzip", compressBody(t, "gzip", bomb), http.StatusBadRequest, completions.BindTooLarge, "decompressed request body exceeds 524288 bytes"},
+		{"raw too large", "", bomb, http.StatusBadRequest, completions.BindTooLarge, "request body exceeds 262144 bytes"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/completions", bytes.NewReader(tt.body))
+		if tt.encoding != "" {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %.200s", tt.name, tt.status, w.Code, w.Body.String())
+			continue
+		}
+		if tt.status == http.StatusOK {
+			if w.Body.String() != prefix {
+				t.Errorf("%s: decoded prefix mismatch, got %d bytes", tt.name, w.Body.Len())
+			}
+			continue
+		}
+		var rsp struct {
+			Binding completions.BindingError `json:"binding"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &rsp); err != nil {
+			t.Fatal(err)
+		}
+		if rsp.Binding.Class != tt.class || rsp.Binding.Message != tt.message {
+			t.Errorf("%s: expected %s %q, got %s %q", tt.name, tt.class, tt.message, rsp.Binding.Class, rsp.Binding.Message)
+		}
+	}
+	if len(gzipped) >= len(payload)/10 {
+		t.Errorf("test payload should compress well, got %d from %d bytes", len(gzipped), len(payload))
+	}
+}
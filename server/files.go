@@ -0,0 +1,41 @@
+package server
+
+import (
+	"code-completion/pkg/completions"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 文件保存通知
+type FilesChangedRequest struct {
+	ClientID string   `json:"client_id"`
+	Files    []string `json:"files"` // 保存的文件在项目内的路径，为空表示该客户端的所有文件
+}
+
+// filesChangedHandler 文件保存通知处理器
+// @Summary 文件保存通知
+// @Description 插件保存文件后调用，作废该客户端按光标行缓存的补全结果(如负结果缓存)，避免修改光标上方的代码后仍命中旧结果；请求体很小，可以每次保存都调用
+// @Description 必须带与补全请求相同的Authorization头，只作废用该凭据写入的条目
+// @Tags completions
+// @Accept json
+// @Produce json
+// @Param request body FilesChangedRequest true "保存的文件"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/files/changed [post]
+func filesChangedHandler(c *gin.Context) {
+	credential := c.GetHeader("Authorization")
+	if credential == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization is required"})
+		return
+	}
+	var req FilesChangedRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ClientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id is required"})
+		return
+	}
+	purged := completions.PurgeClientResults(req.ClientID, credential, req.Files)
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
@@ -1,32 +1,59 @@
 package server
 
 import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
 	"code-completion/pkg/model"
 	"code-completion/pkg/stream_controller"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// 旧版插件发到/api/completions的千流格式请求，带有completion_id
+type legacyProbe struct {
+	CompletionID string `json:"completion_id"`
+}
+
+// 请求体是否千流格式的请求，与bindCompletion一样按binding的限制读取和解析，只是不拒绝未知字段
+func legacyRequest(c *gin.Context) bool {
+	body, err := requestBody(c)
+	if err != nil {
+		return false
+	}
+	cfg := config.Config.Binding
+	cfg.DisallowUnknownFields = false
+	var probe legacyProbe
+	return completions.DecodeJSON(body, &probe, &cfg) == nil && probe.CompletionID != ""
+}
+
 // @Summary openai/completions接口的代码补全
 // @Description 根据提供的代码上下文生成代码补全建议（OPENAI协议的请求格式）
+// @Description choices[].confidence为0-1的相对置信度，由隐藏分、后置处理器命中、结束原因、补全长度、语法错误裁剪按配置的权重加权得到，只用于比较补全之间的可信程度(如淡化显示低置信度的补全)，不是校准过的概率
+// @Description 指定strict_deadline_ms时保证在截止时间内返回(从收到请求时开始计算)：到时立即返回已得到的最好结果(可能为空，状态为timeout)，deadline字段说明被打断和跳过的阶段
 // @Tags completions
 // @Accept json
 // @Produce json
 // @Param request body model.CompletionParameter true "补全请求"
+// @Param format query string false "千流格式的请求为legacy时按旧版Python服务的格式返回(completions.LegacyResponse，已弃用)"
 // @Success 200 {object} completions.CompletionResponse
 // @Failure 400 {object} completions.CompletionResponse
 // @Failure 500 {object} completions.CompletionResponse
 // @Router /api/completions [post]
 func CompletionsOpenAI(c *gin.Context) {
+	// 带completion_id的是千流格式的请求(插件的旧路由)，与新路由共用处理和去重记录
+	if legacyRequest(c) {
+		CompletionsV1(c)
+		return
+	}
 	var req model.CompletionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status": model.StatusReqError,
-			"error":  err.Error(),
-		})
+	if !bindCompletion(c, "openai", &req, nil) {
 		return
 	}
+	req.Authorization = c.GetHeader("Authorization")
+	req.Tenant = stream_controller.TenantOf(c.Request.Header)
 	rsp := stream_controller.Controller.ProcessCompletionOpenAI(c.Request.Context(), &req)
-	respCompletion(c, "", "openai", rsp)
+	c.Header(HeaderCompletionRoute, c.FullPath())
+	if err := respCompletion(c, "", "openai", rsp); err != nil {
+		stream_controller.Controller.Undelivered(stream_controller.UndeliveredRequest{Api: "openai"}, rsp, err)
+	}
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/stream_controller"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// preflightHandler 插件配置预检处理器
+// @Summary 插件配置预检
+// @Description 插件安装或修改配置时调用，请求体与补全请求相同，在诊断模式下检查请求字段、拒绝规则链、模型(生成1个token)和各检索服务，返回pass/warn/fail的检查清单和处理建议
+// @Description 按客户端地址限流，结果不缓存，不影响补全的统计和状态，可以重复调用
+// @Tags completions
+// @Accept json
+// @Produce json
+// @Param request body completions.CompletionRequest true "与补全请求相同的样例请求"
+// @Success 200 {object} stream_controller.PreflightReport
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /api/preflight [post]
+func preflightHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	var req completions.CompletionInput
+	if !bindCompletion(c, "preflight", &req.CompletionRequest, nil) {
+		return
+	}
+	req.Headers = c.Request.Header
+
+	report, err := stream_controller.Controller.Preflight(c.Request.Context(), &req, c.ClientIP())
+	switch {
+	case errors.Is(err, stream_controller.ErrPreflightDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, stream_controller.ErrPreflightThrottled):
+		// 补充一次预检的时长
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(1/config.Config.Preflight.Rate))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, report)
+	}
+}
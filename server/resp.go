@@ -2,15 +2,29 @@ package server
 
 import (
 	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
-func respCompletion(c *gin.Context, clientId, ifId string, rsp *completions.CompletionResponse) {
-	if rsp.Status != model.StatusSuccess {
+// 响应头：补全为空或被拒绝时的重试建议，空补全的204响应没有响应体，插件从响应头读取
+const HeaderRetryAdvice = "X-Retry-Advice"
+
+func respCompletion(c *gin.Context, clientId, ifId string, rsp *completions.CompletionResponse) error {
+	if rsp.Deadline != nil && rsp.Deadline.Interrupted != "" {
+		// 看门狗触发的响应在安全余量内写出，不序列化整个响应记录日志
+		zap.L().Warn("completion returned at strict deadline", zap.String("completionID", rsp.ID),
+			zap.String("clientID", clientId),
+			zap.String("status", string(rsp.Status)),
+			zap.String("if", ifId),
+			zap.String("interrupted", rsp.Deadline.Interrupted),
+			zap.Int("textBytes", len(rsp.Choices[0].Text)))
+	} else if rsp.Status != model.StatusSuccess {
 		zap.L().Warn("completion failed", zap.String("completionID", rsp.ID),
 			zap.String("clientID", clientId),
 			zap.String("status", string(rsp.Status)),
@@ -34,6 +48,8 @@ func respCompletion(c *gin.Context, clientId, ifId string, rsp *completions.Comp
 		statusCode = http.StatusGatewayTimeout
 	case model.StatusBusy:
 		statusCode = http.StatusTooManyRequests
+	case model.StatusUnauthorized:
+		statusCode = http.StatusUnauthorized
 	case model.StatusReqError, model.StatusRejected:
 		statusCode = http.StatusBadRequest
 	case model.StatusModelError, model.StatusServerError:
@@ -41,5 +57,87 @@ func respCompletion(c *gin.Context, clientId, ifId string, rsp *completions.Comp
 	default:
 		statusCode = http.StatusInternalServerError
 	}
+	// 服务端发起的取消不是客户端关闭连接，插件可以稍后重试
+	if rsp.CancelCause == completions.CancelDrain || rsp.CancelCause == completions.CancelAdmin {
+		statusCode = http.StatusServiceUnavailable
+	}
+	if rsp.RetryAdvice != "" {
+		c.Header(HeaderRetryAdvice, rsp.RetryAdvice)
+	}
+	before := len(c.Errors)
 	c.JSON(statusCode, rsp)
+	err := deliveryError(c, before)
+	completions.CountResponse(rsp, err == nil)
+	if err != nil {
+		zap.L().Warn("completion not delivered", zap.String("completionID", rsp.ID),
+			zap.String("clientID", clientId),
+			zap.String("status", string(rsp.Status)),
+			zap.String("if", ifId),
+			zap.Error(err))
+	}
+	return err
+}
+
+// 请求是否要求按旧版Python服务的格式返回
+func legacyFormat(c *gin.Context) bool {
+	return c.Query("format") == "legacy" && !config.Config.LegacyFormat.Disabled
+}
+
+// 兼容模式停用日期的HTTP日期格式，配置无效时使用默认日期
+func legacySunset() string {
+	sunset, err := time.Parse(time.DateOnly, config.Config.LegacyFormat.Sunset)
+	if err != nil {
+		sunset, _ = time.Parse(time.DateOnly, config.DefaultLegacySunset)
+	}
+	return sunset.UTC().Format(http.TimeFormat)
+}
+
+/**
+ * 按旧版Python服务的格式返回补全响应
+ * @param {*gin.Context} c - 请求上下文
+ * @param {*completions.CompletionRequest} req - 补全请求，用于回显extra
+ * @param {*completions.CompletionResponse} rsp - 补全响应
+ * @returns {error} 响应没有送达客户端时返回错误，见deliveryError
+ * @description
+ * - 字段、状态取值和HTTP状态码见completions.ToLegacy，补全指标仍按标准状态记录
+ * - 响应头带Deprecation、Sunset和指向标准接口的Link，并计入completion_legacy_responses_total
+ */
+func respLegacy(c *gin.Context, req *completions.CompletionRequest, rsp *completions.CompletionResponse) error {
+	statusCode, legacy := completions.ToLegacy(req, rsp)
+	zap.L().Info("completion returned in legacy format", zap.String("completionID", rsp.ID),
+		zap.String("clientID", req.ClientID),
+		zap.String("status", string(rsp.Status)),
+		zap.String("legacyStatus", legacy.Status))
+	metrics.IncrementLegacyResponses(rsp.Model, string(rsp.Status))
+	c.Header("Deprecation", "true")
+	c.Header("Sunset", legacySunset())
+	c.Header("Link", `</code-completion/api/v1/completions>; rel="successor-version"`)
+	before := len(c.Errors)
+	c.JSON(statusCode, legacy)
+	err := deliveryError(c, before)
+	completions.CountResponse(rsp, err == nil)
+	if err != nil {
+		zap.L().Warn("completion not delivered", zap.String("completionID", rsp.ID),
+			zap.String("clientID", req.ClientID),
+			zap.String("status", string(rsp.Status)),
+			zap.String("if", "legacy"),
+			zap.Error(err))
+	}
+	return err
+}
+
+/**
+ * 检查响应是否送达客户端
+ * @param {*gin.Context} c - 请求上下文
+ * @param {int} before - 写响应前c.Errors的数量
+ * @returns {error} 写响应失败或客户端连接已断开时返回错误，否则返回nil
+ * @description
+ * - gin写响应失败时把错误记入c.Errors
+ * - 写入内核缓冲区成功不代表客户端收到，连接断开时请求上下文被取消，一并视为没有送达
+ */
+func deliveryError(c *gin.Context, before int) error {
+	if len(c.Errors) > before {
+		return c.Errors.Last().Err
+	}
+	return c.Request.Context().Err()
 }
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// 补全响应计数completion_responses_total中指定模型和状态的计数
+func responseCount(t *testing.T, modelName, status string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "completion_responses_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["model"] == modelName && labels["status"] == status {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// to test respCompletion counts a delivered completion by its status and a completion whose client closed the connection as deliveredFailed
+// go test ./server/ -v -run Test_RespCompletionDelivery
+func Test_RespCompletionDelivery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const modelName = "delivery-test"
+	results := make(chan error, 1)
+	r := gin.New()
+	r.POST("/completions", func(c *gin.Context) {
+		// 客户端断开连接后才写响应
+		if c.Query("gone") != "" {
+			<-c.Request.Context().Done()
+		}
+		results <- respCompletion(c, "client", "sangfor/v1",
+			&completions.CompletionResponse{Model: modelName, Status: model.StatusSuccess})
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	success, failed := responseCount(t, modelName, "success"), responseCount(t, modelName, "delivered_failed")
+
+	rsp, err := http.Post(srv.URL+"/completions", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if err := <-results; err != nil {
+		t.Errorf("expected the completion delivered, got %v", err)
+	}
+	if n := responseCount(t, modelName, "success") - success; n != 1 {
+		t.Errorf("expected one delivered success counted, got %v", n)
+	}
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "POST /completions?gone=1 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n")
+	conn.Close()
+	if err := <-results; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the closed connection reported, got %v", err)
+	}
+	if n := responseCount(t, modelName, "success") - success; n != 1 {
+		t.Errorf("expected the undelivered success not counted as success, got %v", n)
+	}
+	if n := responseCount(t, modelName, "delivered_failed") - failed; n != 1 {
+		t.Errorf("expected the undelivered completion counted as deliveredFailed, got %v", n)
+	}
+}
+
+// to test the legacy format carries the deprecation headers and the legacy status code
+// go test ./server/ -v -run Test_RespLegacy
+func Test_RespLegacy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/completions?format=legacy", nil)
+	if !legacyFormat(c) {
+		t.Fatal("expected the legacy format requested")
+	}
+	req := &completions.CompletionRequest{ClientID: "client", CompletionID: "c1"}
+	if err := respLegacy(c, req, &completions.CompletionResponse{ID: "c1", Status: model.StatusEmpty}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"no_result"`) {
+		t.Errorf("unexpected legacy response %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" {
+		t.Errorf("expected the deprecation headers, got %v", w.Header())
+	}
+}
+
+// to test a response returned by the strict deadline watchdog is logged compactly without the whole response
+// go test ./server/ -v -run Test_RespCompletionStrictDeadline
+func Test_RespCompletionStrictDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zapcore.InfoLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/completions", nil)
+	rsp := &completions.CompletionResponse{ID: "cmpl-fired", Model: "strict-test", Status: model.StatusSuccess,
+		Choices: []completions.CompletionChoice{{Text: "two"}},
+		Deadline: &completions.DeadlineReport{DeadlineMs: 200, Interrupted: completions.PhasePrune,
+			Skipped: []string{completions.PhasePrune, completions.PhaseFinalize}}}
+	if err := respCompletion(c, "client", "sangfor/v1", rsp); err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Message != "completion returned at strict deadline" {
+		t.Fatalf("expected one strict deadline log, got %+v", entries)
+	}
+	fields := entries[0].ContextMap()
+	if _, ok := fields["response"]; ok || fields["interrupted"] != completions.PhasePrune || fields["textBytes"] != int64(3) {
+		t.Errorf("unexpected log fields %v", fields)
+	}
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"code-completion/pkg/completions"
 	"code-completion/pkg/logger"
 	"code-completion/pkg/metrics"
 	"code-completion/pkg/stream_controller"
@@ -14,8 +17,8 @@ import (
 	"go.uber.org/zap"
 )
 
-// SetupRouter 设置路由
-func SetupRouter() *gin.Engine {
+// SetupRouter 设置路由，info为版本接口和补全响应头使用的构建信息
+func SetupRouter(info BuildInfo) *gin.Engine {
 	// 创建Gin实例
 	r := gin.New()
 
@@ -27,6 +30,7 @@ func SetupRouter() *gin.Engine {
 
 	// 健康检查接口
 	r.GET("/healthz", healthCheck)
+	r.GET("/health", healthCheck)
 
 	// Prometheus指标接口
 	r.GET("/metrics", func(c *gin.Context) {
@@ -42,19 +46,48 @@ func SetupRouter() *gin.Engine {
 		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 		c.Next()
 	})
-	api.POST("/logs", logHandler)
-	api.GET("/stats", statsHandler)
-	api.GET("/details", detailsHandler)
+	// 管理接口，超时见配置timeouts.admin
+	admin := api.Group("", routeTimeout(TimeoutAdmin))
+	admin.POST("/logs", logHandler)
+	admin.GET("/version", versionHandler(info))
+	admin.GET("/stats", statsHandler)
+	admin.GET("/metrics-mapping", metricsMappingHandler)
+	admin.GET("/details", detailsHandler)
+	admin.GET("/thresholds", thresholdsHandler)
+	admin.DELETE("/thresholds", adminAuth(), resetThresholdsHandler)
+	admin.PATCH("/pools/:model", adminAuth(), resizePoolHandler)
+	admin.GET("/clients/:client/style", adminAuth(), clientStyleHandler)
+	admin.DELETE("/clients/:client/style", adminAuth(), resetClientStyleHandler)
+	admin.GET("/score-experiment", adminAuth(), scoreExperimentHandler)
+	admin.POST("/score-experiment", adminAuth(), updateScoreExperimentHandler)
+	admin.DELETE("/requests/:completion_id", adminAuth(), cancelRequestHandler)
+	admin.GET("/usage", adminAuth(), usageHandler)
+	admin.GET("/config", adminAuth(), configHandler)
+	// 调试查询接口，数据量较大，超时见配置timeouts.debug
+	debug := api.Group("", routeTimeout(TimeoutDebug))
+	debug.GET("/errors", adminAuth(), errorsHandler)
+	debug.GET("/samples", adminAuth(), samplesHandler)
+	debug.GET("/diagnostics", adminAuth(), diagnosticsHandler)
+	debug.POST("/debug/replay/:completion_id", adminAuth(), replayHandler)
+	debug.POST("/debug/prompt", adminAuth(), promptPreviewHandler)
 
+	// 实时跟踪客户端的补全活动，长连接，流的持续时间见配置streamController.tail.maxDuration
+	api.GET("/clients/:client/tail", adminAuth(), tailHandler)
+	// 补全和预检接口自己控制截止时间，不设置接口超时
 	// 支持OPENAI标准的补全接口，默认并不开放
-	api.POST("/completions", CompletionsOpenAI)
+	api.POST("/completions", receiveTime(), versionHeader(info), decompressBody(), CompletionsOpenAI)
+	// 插件配置预检
+	api.POST("/preflight", decompressBody(), preflightHandler)
+	// 文件保存通知，作废客户端缓存的补全结果
+	api.POST("/files/changed", filesChangedHandler)
 	// 补全接口 - 新版本路径（与客户端脚本保持一致）
 	completionRouter := r.Group("/code-completion")
-	completionRouter.Use(func(c *gin.Context) {
+	completionRouter.Use(receiveTime(), func(c *gin.Context) {
 		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 		c.Next()
-	})
+	}, versionHeader(info), decompressBody())
 	completionRouter.POST("/api/v1/completions", CompletionsV1)
+	completionRouter.POST("/api/v1/completions/:completion_id/invalidate", InvalidateCompletion)
 	completionRouter.POST("/api/v2/completions", CompletionsV2)
 
 	return r
@@ -62,17 +95,28 @@ func SetupRouter() *gin.Engine {
 
 // healthCheck 健康检查处理器
 // @Summary 健康检查
-// @Description 检查服务是否正常运行
+// @Description 检查服务是否正常运行，deep=true时附带各模型的安全模式状态，有模型处于安全模式时status为degraded
 // @Tags health
 // @Accept json
 // @Produce json
+// @Param deep query bool false "是否返回各模型的安全模式状态"
 // @Success 200 {object} map[string]interface{}
 // @Router /healthz [get]
 func healthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	rsp := gin.H{
 		"status": "ok",
 		"time":   time.Now().Format(time.RFC3339),
-	})
+	}
+	if c.Query("deep") == "true" && stream_controller.Controller != nil {
+		states := stream_controller.Controller.SafeModes()
+		for _, s := range states {
+			if s.SafeMode {
+				rsp["status"] = "degraded"
+			}
+		}
+		rsp["safeMode"] = states
+	}
+	c.JSON(http.StatusOK, rsp)
 }
 
 // statsHandler 统计信息处理器
@@ -90,18 +134,128 @@ func statsHandler(c *gin.Context) {
 	})
 }
 
+// metricsMappingHandler 指标改名对照处理器
+// @Summary 获取指标新旧名称对照
+// @Description 获取改名指标的旧名称、新名称、标签以及新指标中取值被规范化的标签，用于迁移看板；legacy表示当前是否仍输出旧名称
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /api/metrics-mapping [get]
+func metricsMappingHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"legacy":  metrics.LegacyNamesEnabled(),
+			"renames": metrics.Renames(),
+		},
+	})
+}
+
 // detailsHandler 详细信息处理器
 // @Summary 获取详细信息
-// @Description 获取代码补全服务的详细信息
+// @Description 获取代码补全服务的详细信息，列表按固定顺序输出：模型池按模型名称，客户端按最近活动时间从新到旧，请求按接收时间；seq和time用于判断快照是否过时
 // @Tags debug
 // @Accept json
 // @Produce json
+// @Param fields query string false "字段选择，summary或full，默认full"
+// @Param offset query int false "客户端和请求列表跳过的条数，默认0"
+// @Param limit query int false "客户端和请求列表最多返回的条数，默认100"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
 // @Router /api/details [get]
 func detailsHandler(c *gin.Context) {
+	filter := stream_controller.DetailsFilter{Fields: c.DefaultQuery("fields", stream_controller.DetailsFull), Limit: 100}
+	if filter.Fields != stream_controller.DetailsSummary && filter.Fields != stream_controller.DetailsFull {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fields: " + filter.Fields})
+		return
+	}
+	var err error
+	if s := c.Query("offset"); s != "" {
+		if filter.Offset, err = strconv.Atoi(s); err != nil || filter.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset: " + s})
+			return
+		}
+	}
+	if s := c.Query("limit"); s != "" {
+		if filter.Limit, err = strconv.Atoi(s); err != nil || filter.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + s})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    stream_controller.Controller.GetDetails(filter),
+	})
+}
+
+// thresholdsHandler 隐藏分阈值查询处理器
+// @Summary 获取隐藏分阈值
+// @Description 获取各语言自动调整后的隐藏分阈值
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /api/thresholds [get]
+func thresholdsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    completions.Tuner.GetStats(),
+	})
+}
+
+// resetThresholdsHandler 隐藏分阈值撤销处理器
+// @Summary 撤销隐藏分阈值调整
+// @Description 撤销各语言自动调整的隐藏分阈值，恢复为全局阈值，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /api/thresholds [delete]
+func resetThresholdsHandler(c *gin.Context) {
+	completions.Tuner.Reset()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    completions.Tuner.GetStats(),
+	})
+}
+
+type PoolSettings struct {
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+// resizePoolHandler 模型池并发数调整处理器
+// @Summary 调整模型池并发数
+// @Description 运行时调整指定模型所有请求池的最大并发数，无需重启或重新加载配置，需要管理令牌
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param model path string true "模型名称"
+// @Param request body PoolSettings true "模型池设置"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/pools/{model} [patch]
+func resizePoolHandler(c *gin.Context) {
+	var req PoolSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := stream_controller.Controller.ResizePools(c.Param("model"), req.MaxConcurrent, c.ClientIP())
+	if errors.Is(err, stream_controller.ErrPoolNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message": "OK",
-		"data":    stream_controller.Controller.GetDetails(),
+		"data":    records,
 	})
 }
 
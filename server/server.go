@@ -1,7 +1,9 @@
 package server
 
 import (
+	"code-completion/pkg/feature_flag"
 	"code-completion/pkg/logger"
+	"code-completion/pkg/stream_controller"
 	"context"
 	"net/http"
 	"os"
@@ -38,18 +40,27 @@ type Server struct {
  * - 创建并初始化HTTP服务器配置
  * - 设置服务器监听地址和请求处理器
  * - 初始化日志记录器引用
+ * - 服务关闭时以shutdown事件结束所有SSE流(含补全活动的跟踪流)
  * - 返回可用于启动服务器的实例
  * @example
  * router := gin.Default()
  * srv := NewServer("127.0.0.1:8080", router)
  */
 func NewServer(addr string, router *gin.Engine) *Server {
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+	// 关闭时先结束所有SSE流，否则长连接会拖住优雅关闭
+	httpServer.RegisterOnShutdown(Streams.Shutdown)
+	httpServer.RegisterOnShutdown(func() {
+		if stream_controller.Controller != nil {
+			stream_controller.Controller.CloseTails()
+		}
+	})
 	return &Server{
-		httpServer: &http.Server{
-			Addr:    addr,
-			Handler: router,
-		},
-		logger: logger.Logger,
+		httpServer: httpServer,
+		logger:     logger.Logger,
 	}
 }
 
@@ -88,6 +99,11 @@ func (s *Server) Start() error {
 
 	s.logger.Info("正在关闭服务器...")
 
+	// 排队的请求立即返回，不必等到截止时间
+	if stream_controller.Controller != nil {
+		stream_controller.Controller.Drain()
+	}
+
 	// 创建超时上下文
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -98,6 +114,12 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	// 正在处理的请求都已返回，写入最后累计的token用量
+	if stream_controller.Controller != nil {
+		stream_controller.Controller.FlushUsage()
+	}
+	feature_flag.Stop()
+
 	s.logger.Info("服务器已优雅关闭")
 	return nil
 }
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 流的结束原因，作为最后一个end事件的reason
+const (
+	StreamCompleted  = "completed"  // 补全正常结束
+	StreamSuperseded = "superseded" // 同一客户端打开了更多的流，最早打开的流被替换
+	StreamShutdown   = "shutdown"   // 服务关闭
+	StreamExpired    = "expired"    // 超过流的最长持续时间
+	StreamCanceled   = "canceled"   // 客户端断开，不再发送end事件
+)
+
+// 一个打开的流，end接收结束原因(只会发送一次)
+type openStream struct {
+	client string
+	end    chan string
+}
+
+/**
+ * SSE流的连接管理
+ * @description
+ * - 按客户端记录打开的流，超过上限时最早打开的流以superseded结束
+ * - 服务关闭时以shutdown结束所有流，之后不再接受新的流
+ */
+type StreamHub struct {
+	mutex   sync.Mutex
+	clients map[string][]*openStream
+	total   int
+	closed  bool
+}
+
+// 所有SSE流共享的连接管理，HTTP服务关闭时结束所有流
+var Streams = NewStreamHub()
+
+func NewStreamHub() *StreamHub {
+	return &StreamHub{clients: make(map[string][]*openStream)}
+}
+
+// 打开一个流，超过客户端的上限时结束最早打开的流；服务已关闭时返回nil
+func (h *StreamHub) open(client string, maxPerClient int) *openStream {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return nil
+	}
+	s := &openStream{client: client, end: make(chan string, 1)}
+	streams := append(h.clients[client], s)
+	for maxPerClient > 0 && len(streams) > maxPerClient {
+		streams[0].end <- StreamSuperseded
+		streams = streams[1:]
+		h.total--
+	}
+	h.clients[client] = streams
+	h.total++
+	metrics.UpdateOpenStreams(h.total, len(streams))
+	return s
+}
+
+// 关闭流，已被替换或因服务关闭结束的流不在列表中
+func (h *StreamHub) close(s *openStream) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	streams := h.clients[s.client]
+	for i, o := range streams {
+		if o == s {
+			streams = append(streams[:i:i], streams[i+1:]...)
+			h.total--
+			break
+		}
+	}
+	if len(streams) == 0 {
+		delete(h.clients, s.client)
+	} else {
+		h.clients[s.client] = streams
+	}
+	metrics.UpdateOpenStreams(h.total, 0)
+}
+
+// 以shutdown结束所有流，之后不再接受新的流
+func (h *StreamHub) Shutdown() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for client, streams := range h.clients {
+		for _, s := range streams {
+			s.end <- StreamShutdown
+		}
+		delete(h.clients, client)
+	}
+	h.total = 0
+	metrics.UpdateOpenStreams(0, 0)
+}
+
+// 客户端当前打开的流数
+func (h *StreamHub) Count(client string) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.clients[client])
+}
+
+/**
+ * 以SSE输出一个补全流
+ * @param {*gin.Context} c - 请求上下文
+ * @param {string} client - 客户端ID，用于限制每个客户端同时打开的流数
+ * @param {<-chan string} events - 补全的数据事件，关闭表示补全结束
+ * @returns {string} 返回流的结束原因
+ * @description
+ * - 每个数据事件输出为data帧；连续Heartbeat没有数据时输出": heartbeat"注释，避免代理断开空闲连接
+ * - 结束时输出end事件，data为{"reason":"completed|superseded|shutdown|expired"}
+ * - 流的持续时间不超过MaxDuration，与补全超时无关
+ * - 客户端断开时直接返回canceled，不再输出
+ * - 返回后调用方应停止生产事件(如取消模型请求的context)
+ * - 服务关闭后打开的流返回503
+ * @example
+ * ctx, cancel := context.WithCancel(c.Request.Context())
+ * defer cancel()
+ * Streams.Serve(c, req.ClientID, streamCompletion(ctx, req))
+ */
+func (h *StreamHub) Serve(c *gin.Context, client string, events <-chan string) string {
+	cfg := &config.Config.StreamController.Streams
+	s := h.open(client, cfg.MaxPerClient)
+	if s == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+		return StreamShutdown
+	}
+	defer h.close(s)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(cfg.Heartbeat)
+	defer heartbeat.Stop()
+	deadline := time.NewTimer(cfg.MaxDuration)
+	defer deadline.Stop()
+
+	reason := ""
+	for reason == "" {
+		select {
+		case data, ok := <-events:
+			if !ok {
+				reason = StreamCompleted
+				continue
+			}
+			writeEvent(c.Writer, "", data)
+			heartbeat.Reset(cfg.Heartbeat)
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": heartbeat\n\n")
+			metrics.IncrementStreamHeartbeats()
+		case reason = <-s.end:
+			continue
+		case <-deadline.C:
+			reason = StreamExpired
+			continue
+		case <-c.Request.Context().Done():
+			metrics.IncrementStreamEnds(StreamCanceled)
+			return StreamCanceled
+		}
+		c.Writer.Flush()
+	}
+	writeEvent(c.Writer, "end", `{"reason":"`+reason+`"}`)
+	c.Writer.Flush()
+	metrics.IncrementStreamEnds(reason)
+	return reason
+}
+
+// 输出一个SSE事件，多行数据拆成多个data行
+func writeEvent(w io.Writer, event, data string) {
+	var sb strings.Builder
+	if event != "" {
+		sb.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	io.WriteString(w, sb.String())
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 模拟流式输出的模型：每隔interval输出一个token，输出n个后结束，ctx取消时停止
+func fakeStreamingModel(ctx context.Context, n int, interval time.Duration) <-chan string {
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		for i := 0; i < n; i++ {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case events <- "token" + string(rune('0'+i)):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// 用模拟模型输出SSE流的测试服务，client和模型的token数、间隔由查询参数指定
+func newStreamServer(t *testing.T, hub *StreamHub, streams config.StreamsConfig) *httptest.Server {
+	old := config.Config.StreamController.Streams
+	config.Config.StreamController.Streams = streams
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/stream", func(c *gin.Context) {
+		n := len(c.Query("tokens"))
+		interval, _ := time.ParseDuration(c.Query("interval"))
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		hub.Serve(c, c.Query("client"), fakeStreamingModel(ctx, n, interval))
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		srv.Close()
+		config.Config.StreamController.Streams = old
+	})
+	return srv
+}
+
+// 读取的SSE流：数据事件、心跳数和结束原因
+type streamResult struct {
+	status     int
+	data       []string
+	heartbeats int
+	end        string
+}
+
+// 读取整个SSE流
+func readStream(t *testing.T, url string) streamResult {
+	rsp, err := http.Get(url)
+	if err != nil {
+		t.Error(err)
+		return streamResult{}
+	}
+	defer rsp.Body.Close()
+	body, _ := io.ReadAll(rsp.Body)
+	result := streamResult{status: rsp.StatusCode}
+	for _, frame := range strings.Split(string(body), "\n\n") {
+		switch {
+		case frame == ": heartbeat":
+			result.heartbeats++
+		case strings.HasPrefix(frame, "event: end\n"):
+			result.end = strings.TrimPrefix(frame, "event: end\ndata: ")
+		case strings.HasPrefix(frame, "data: "):
+			result.data = append(result.data, strings.TrimPrefix(frame, "data: "))
+		}
+	}
+	return result
+}
+
+// 等待客户端打开的流数达到n
+func waitStreams(t *testing.T, hub *StreamHub, client string, n int) {
+	for i := 0; i < 200 && hub.Count(client) != n; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hub.Count(client) != n {
+		t.Fatalf("expected %d open streams for %s, got %d", n, client, hub.Count(client))
+	}
+}
+
+// to test a stream forwards the model tokens and ends with a completed event
+// go test ./server/ -v -run Test_StreamCompleted
+func Test_StreamCompleted(t *testing.T) {
+	hub := NewStreamHub()
+	srv := newStreamServer(t, hub, config.StreamsConfig{MaxPerClient: 2, Heartbeat: time.Hour, MaxDuration: time.Minute})
+
+	result := readStream(t, srv.URL+"/stream?client=c1&tokens=xxx&interval=5ms")
+	if strings.Join(result.data, ",") != "token0,token1,token2" {
+		t.Errorf("unexpected data events: %v", result.data)
+	}
+	if result.end != `{"reason":"completed"}` {
+		t.Errorf("expected completed end event, got %q", result.end)
+	}
+	if hub.Count("c1") != 0 {
+		t.Errorf("stream should be closed, got %d open", hub.Count("c1"))
+	}
+}
+
+// to test heartbeats are sent at the configured cadence while idle, and the wall-clock limit ends the stream
+// go test ./server/ -v -run Test_StreamHeartbeat
+func Test_StreamHeartbeat(t *testing.T) {
+	hub := NewStreamHub()
+	srv := newStreamServer(t, hub, config.StreamsConfig{MaxPerClient: 2, Heartbeat: 40 * time.Millisecond, MaxDuration: 220 * time.Millisecond})
+
+	// 模型一直没有输出，220ms内应有5次心跳
+	start := time.Now()
+	result := readStream(t, srv.URL+"/stream?client=c1&tokens=x&interval=1h")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stream should end at the wall-clock limit, took %v", elapsed)
+	}
+	if result.heartbeats < 4 || result.heartbeats > 6 {
+		t.Errorf("expected about 5 heartbeats, got %d", result.heartbeats)
+	}
+	if result.end != `{"reason":"expired"}` {
+		t.Errorf("expected expired end event, got %q", result.end)
+	}
+}
+
+// to test a client exceeding the stream cap gets its oldest stream ended with a superseded event
+// go test ./server/ -v -run Test_StreamSuperseded
+func Test_StreamSuperseded(t *testing.T) {
+	hub := NewStreamHub()
+	srv := newStreamServer(t, hub, config.StreamsConfig{MaxPerClient: 1, Heartbeat: time.Hour, MaxDuration: time.Minute})
+
+	oldest := make(chan streamResult, 1)
+	go func() {
+		oldest <- readStream(t, srv.URL+"/stream?client=c1&tokens=x&interval=1h")
+	}()
+	waitStreams(t, hub, "c1", 1)
+
+	// 其它客户端的流不受影响
+	go readStream(t, srv.URL+"/stream?client=c2&tokens=x&interval=1h")
+	waitStreams(t, hub, "c2", 1)
+
+	newest := readStream(t, srv.URL+"/stream?client=c1&tokens=x&interval=5ms")
+	if newest.end != `{"reason":"completed"}` {
+		t.Errorf("newest stream should complete, got %q", newest.end)
+	}
+	select {
+	case result := <-oldest:
+		if result.end != `{"reason":"superseded"}` {
+			t.Errorf("oldest stream should be superseded, got %q", result.end)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("oldest stream was not ended")
+	}
+	if hub.Count("c2") != 1 {
+		t.Errorf("other client's stream should stay open, got %d", hub.Count("c2"))
+	}
+	hub.Shutdown()
+}
+
+// to test shutting down ends open streams with a shutdown event and rejects new streams
+// go test ./server/ -v -run Test_StreamShutdown
+func Test_StreamShutdown(t *testing.T) {
+	hub := NewStreamHub()
+	srv := newStreamServer(t, hub, config.StreamsConfig{MaxPerClient: 2, Heartbeat: time.Hour, MaxDuration: time.Minute})
+
+	open := make(chan streamResult, 1)
+	go func() {
+		open <- readStream(t, srv.URL+"/stream?client=c1&tokens=x&interval=1h")
+	}()
+	waitStreams(t, hub, "c1", 1)
+	hub.Shutdown()
+
+	select {
+	case result := <-open:
+		if result.end != `{"reason":"shutdown"}` {
+			t.Errorf("expected shutdown end event, got %q", result.end)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream was not ended by shutdown")
+	}
+	if result := readStream(t, srv.URL+"/stream?client=c1&tokens=x&interval=5ms"); result.status != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after shutdown, got %d", result.status)
+	}
+}
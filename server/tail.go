@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/stream_controller"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tailHandler 客户端补全活动实时跟踪处理器
+// @Summary 实时跟踪一个客户端的补全活动
+// @Description 以SSE输出该客户端每个请求各阶段(received/rejected/queued/dispatched/pruned/returned)的事件，含时间和决策数据，不含代码；每个客户端同时只能有一个跟踪流，超过streamController.tail.maxDuration后以end事件结束，需要管理令牌
+// @Tags completions
+// @Produce text/event-stream
+// @Param client path string true "客户端ID"
+// @Param include_text query bool false "事件附带返回的补全内容，需要配置streamController.tail.allowText"
+// @Success 200 {string} string "SSE事件流"
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /api/clients/{client}/tail [get]
+func tailHandler(c *gin.Context) {
+	sub, err := stream_controller.Controller.Tail(c.Param("client"), c.Query("include_text") == "true")
+	switch {
+	case errors.Is(err, stream_controller.ErrTailTextDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, stream_controller.ErrTailBusy):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, stream_controller.ErrTailClosed):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer sub.Close()
+	serveTail(c, sub, &config.Config.StreamController.Tail)
+}
+
+/**
+ * 以SSE输出跟踪订阅的事件
+ * @param {*gin.Context} c - 请求上下文
+ * @param {*stream_controller.TailSubscription} sub - 跟踪订阅
+ * @param {*config.TailConfig} cfg - 心跳间隔和最长持续时间
+ * @returns {string} 返回流的结束原因
+ * @description
+ * - 每个事件的event为阶段名，data为事件的JSON
+ * - 连续Heartbeat没有事件时输出": heartbeat"注释，客户端一直没有请求时流也保持连接
+ * - 超过MaxDuration时以expired结束，服务关闭时以shutdown结束，end事件带读取跟不上时丢弃的事件数
+ */
+func serveTail(c *gin.Context, sub *stream_controller.TailSubscription, cfg *config.TailConfig) string {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(cfg.Heartbeat)
+	defer heartbeat.Stop()
+	deadline := time.NewTimer(cfg.MaxDuration)
+	defer deadline.Stop()
+
+	reason := ""
+	for reason == "" {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				reason = StreamShutdown
+				continue
+			}
+			data, _ := json.Marshal(event)
+			writeEvent(c.Writer, event.Stage, string(data))
+			heartbeat.Reset(cfg.Heartbeat)
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": heartbeat\n\n")
+		case <-deadline.C:
+			reason = StreamExpired
+			continue
+		case <-c.Request.Context().Done():
+			return StreamCanceled
+		}
+		c.Writer.Flush()
+	}
+	data, _ := json.Marshal(gin.H{"reason": reason, "dropped": sub.Dropped()})
+	writeEvent(c.Writer, "end", string(data))
+	c.Writer.Flush()
+	return reason
+}
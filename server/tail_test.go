@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/stream_controller"
+
+	"github.com/gin-gonic/gin"
+)
+
+// to test a tail without traffic keeps alive with heartbeats, expires and allows one tail per client
+// go test ./server/ -v -run Test_TailStream
+func Test_TailStream(t *testing.T) {
+	oldController, oldTail := stream_controller.Controller, config.Config.StreamController.Tail
+	defer func() {
+		stream_controller.Controller, config.Config.StreamController.Tail = oldController, oldTail
+	}()
+	stream_controller.Controller = stream_controller.NewStreamController(nil)
+	config.Config.StreamController.Tail = config.TailConfig{MaxDuration: 120 * time.Millisecond, Heartbeat: 20 * time.Millisecond, Buffer: 16}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/clients/:client/tail", tailHandler)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	done := make(chan streamResult)
+	go func() { done <- readStream(t, srv.URL+"/api/clients/client-1/tail") }()
+	time.Sleep(30 * time.Millisecond)
+	rsp, err := http.Get(srv.URL + "/api/clients/client-1/tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusConflict {
+		t.Errorf("expected the second tail rejected, got %d", rsp.StatusCode)
+	}
+	result := <-done
+	if result.status != http.StatusOK || result.heartbeats == 0 || result.end != `{"dropped":0,"reason":"expired"}` {
+		t.Errorf("unexpected tail %+v", result)
+	}
+
+	// 没有配置allowText时不允许附带补全内容
+	rsp, err = http.Get(srv.URL + "/api/clients/client-1/tail?include_text=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the completion text forbidden, got %d", rsp.StatusCode)
+	}
+}
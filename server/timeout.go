@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 接口超时的分组，见配置timeouts
+const (
+	TimeoutAdmin = "admin" // 管理接口
+	TimeoutDebug = "debug" // 调试查询接口
+)
+
+/**
+ * 记录收到请求的时间的中间件
+ * @returns {gin.HandlerFunc} 中间件
+ * @description
+ * - 放在补全接口的最前面，严格截止时间从这里开始计算，读取、解压和解析请求体的耗时也计算在内，见completions.ReceiveTimeFrom
+ */
+func receiveTime() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(completions.WithReceiveTime(c.Request.Context(), time.Now()))
+		c.Next()
+	}
+}
+
+// 分组的超时，为0表示不限制
+func routeTimeoutOf(group string) time.Duration {
+	cfg := &config.Config.Timeouts
+	if cfg.Disabled {
+		return 0
+	}
+	switch group {
+	case TimeoutAdmin:
+		return cfg.Admin
+	case TimeoutDebug:
+		return cfg.Debug
+	}
+	return 0
+}
+
+/**
+ * 超时的响应写入器，缓存处理函数的响应，处理完成时一次写出
+ * @description
+ * - 超时后直接向原写入器写出503，之后处理函数的写入被丢弃并返回http.ErrHandlerTimeout
+ * - 处理函数在另一个协程中运行，所有方法都需要加锁
+ */
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mutex    sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	written  bool
+	timedOut bool
+}
+
+func newTimeoutWriter(w gin.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut || w.written || code <= 0 {
+		return
+	}
+	w.status = code
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.timedOut {
+		w.written = true
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut || w.status == 0 {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut || !w.written {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.timedOut || w.written
+}
+
+// 响应已缓存，处理完成时才写出
+func (w *timeoutWriter) Flush() {
+}
+
+// 处理完成，写出缓存的响应；已超时时不处理
+func (w *timeoutWriter) finish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut {
+		return
+	}
+	dst := w.ResponseWriter.Header()
+	for k, v := range w.header {
+		dst[k] = v
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.body.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	} else if w.written {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// 超时，丢弃缓存的响应并写出503
+func (w *timeoutWriter) timeout(route string, timeout time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.timedOut = true
+	w.body.Reset()
+
+	body, _ := json.Marshal(gin.H{
+		"status":  model.StatusTimeout,
+		"error":   "request timed out after " + timeout.String(),
+		"route":   route,
+		"timeout": timeout.String(),
+	})
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.ResponseWriter.Write(body)
+	// 等待处理函数返回期间客户端已能收到完整的响应
+	w.ResponseWriter.Flush()
+}
+
+/**
+ * 按分组限制接口处理时长的中间件
+ * @param {string} group - 超时分组，见TimeoutAdmin/TimeoutDebug
+ * @returns {gin.HandlerFunc} 中间件
+ * @description
+ * - 每次请求读取配置的超时，请求的context带上截止时间，处理函数可以据此提前结束
+ * - 超时时返回503和结构化的错误，按路由计数，处理函数之后的写入被丢弃
+ * - gin的Context会被复用，超时后仍等待处理函数返回再结束请求，客户端在此之前已收到503
+ * - 处理函数的panic转到当前协程，由gin.Recovery处理
+ * @example
+ * admin := api.Group("", routeTimeout(TimeoutAdmin))
+ * admin.GET("/stats", statsHandler)
+ */
+func routeTimeout(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := routeTimeoutOf(group)
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		w := newTimeoutWriter(c.Writer)
+		c.Writer = w
+
+		done := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				done <- recover()
+			}()
+			c.Next()
+		}()
+
+		var p interface{}
+		select {
+		case p = <-done:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				route := c.FullPath()
+				w.timeout(route, timeout)
+				metrics.IncrementRouteTimeouts(route)
+				zap.L().Warn("route timed out", zap.String("route", route),
+					zap.String("group", group), zap.Duration("timeout", timeout))
+			}
+			p = <-done
+		}
+		// 处理函数已返回，恢复原写入器
+		c.Writer = w.ResponseWriter
+		if p != nil {
+			panic(p)
+		}
+		w.finish()
+	}
+}
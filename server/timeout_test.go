@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 设置接口超时，返回恢复原配置的函数
+func setupTimeouts(admin, debug time.Duration) func() {
+	old := config.Config.Timeouts
+	config.Config.Timeouts = config.TimeoutsConfig{Admin: admin, Debug: debug}
+	return func() {
+		config.Config.Timeouts = old
+	}
+}
+
+// 先等待delay再返回内容的请求体，模拟读取缓慢的请求
+type slowBody struct {
+	delay time.Duration
+	data  []byte
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if b.delay > 0 {
+		time.Sleep(b.delay)
+		b.delay = 0
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+// 解析超时响应
+func decodeTimeout(t *testing.T, body []byte) map[string]string {
+	var rsp map[string]string
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		t.Fatalf("invalid timeout response %q: %v", body, err)
+	}
+	return rsp
+}
+
+// to test a slow handler gets a 503 before it returns, its late writes are dropped, and a fast handler is unchanged
+// go test ./server/ -v -run Test_RouteTimeout
+func Test_RouteTimeout(t *testing.T) {
+	defer setupTimeouts(50*time.Millisecond, time.Minute)()
+	gin.SetMode(gin.TestMode)
+	release := make(chan struct{})
+	lateErr := make(chan error, 1)
+
+	r := gin.New()
+	r.Use(gin.Recovery())
+	admin := r.Group("", routeTimeout(TimeoutAdmin))
+	admin.GET("/slow", func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusOK)
+		_, err := c.Writer.WriteString("late")
+		lateErr <- err
+	})
+	admin.GET("/fast", func(c *gin.Context) {
+		c.Header("X-Fast", "yes")
+		c.JSON(http.StatusCreated, gin.H{"message": "OK"})
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// 处理函数阻塞期间客户端收到完整的503
+	rsp, err := http.Get(srv.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", rsp.StatusCode, body)
+	}
+	if got := decodeTimeout(t, body); got["status"] != string(model.StatusTimeout) || got["route"] != "/slow" || got["timeout"] != "50ms" {
+		t.Errorf("unexpected timeout response %v", got)
+	}
+	close(release)
+	select {
+	case err := <-lateErr:
+		if err != http.ErrHandlerTimeout {
+			t.Errorf("expected the late write rejected with ErrHandlerTimeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler did not finish")
+	}
+
+	rsp, err = http.Get(srv.URL + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusCreated || rsp.Header.Get("X-Fast") != "yes" || string(body) != `{"message":"OK"}` {
+		t.Errorf("fast handler response changed: %d %v %s", rsp.StatusCode, rsp.Header, body)
+	}
+}
+
+// to test the admin routes of the router time out while the completion routes keep their own deadline
+// go test ./server/ -v -run Test_RouteTimeoutGroups
+func Test_RouteTimeoutGroups(t *testing.T) {
+	defer setupTimeouts(20*time.Millisecond, 20*time.Millisecond)()
+	gin.SetMode(gin.TestMode)
+	r := SetupRouter(BuildInfo{Version: "1.2.3"})
+
+	// 请求体读取超过超时，管理接口返回503，补全接口在绑定阶段返回400
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/api/logs", http.StatusServiceUnavailable},
+		{"/code-completion/api/v1/completions", http.StatusBadRequest},
+		{"/code-completion/api/v2/completions", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		req.Body = io.NopCloser(&slowBody{delay: 100 * time.Millisecond, data: []byte("{")})
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.path, tt.status, w.Code, w.Body.String())
+			continue
+		}
+		if tt.status == http.StatusServiceUnavailable {
+			if got := decodeTimeout(t, w.Body.Bytes()); got["route"] != tt.path {
+				t.Errorf("%s: unexpected timeout response %v", tt.path, got)
+			}
+		}
+	}
+}
+
+// to test the receive time middleware records when the request arrived before the body is read
+// go test ./server/ -v -run Test_ReceiveTime
+func Test_ReceiveTime(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var received time.Time
+	r := gin.New()
+	r.POST("/completions", receiveTime(), func(c *gin.Context) {
+		received = completions.ReceiveTimeFrom(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	before := time.Now()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/completions", strings.NewReader("{}")))
+	if received.Before(before) || received.After(time.Now()) {
+		t.Errorf("unexpected receive time %v", received)
+	}
+}
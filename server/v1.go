@@ -2,34 +2,90 @@ package server
 
 import (
 	"code-completion/pkg/completions"
-	"code-completion/pkg/model"
+	"code-completion/pkg/config"
 	"code-completion/pkg/stream_controller"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// 响应头：实际处理补全请求的路由，以及重复请求的去重结果(attached/replayed)
+const (
+	HeaderCompletionRoute = "X-Completion-Route"
+	HeaderCompletionDedup = "X-Completion-Dedup"
+)
+
 // @Summary 兼容千流补全接口的代码补全
 // @Description 根据提供的代码上下文生成代码补全建议
+// @Description 指定strict_deadline_ms时保证在截止时间内返回(从收到请求时开始计算)：到时立即返回已得到的最好结果(可能为空，状态为timeout)，deadline字段说明被打断和跳过的阶段
+// @Description choices[].confidence为0-1的相对置信度，由隐藏分、后置处理器命中、结束原因、补全长度、语法错误裁剪按配置的权重加权得到，只用于比较补全之间的可信程度(如淡化显示低置信度的补全)，不是校准过的概率
 // @Tags completions
 // @Accept json
 // @Produce json
 // @Param request body completions.CompletionRequest true "补全请求"
+// @Param format query string false "legacy时按旧版Python服务的格式返回(completions.LegacyResponse，已弃用)"
 // @Success 200 {object} completions.CompletionResponse
 // @Failure 400 {object} completions.CompletionResponse
 // @Failure 500 {object} completions.CompletionResponse
 // @Router /code-completion/api/v1/completions [post]
 func CompletionsV1(c *gin.Context) {
 	var req completions.CompletionInput
-	if err := c.ShouldBindJSON(&req.CompletionRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status": model.StatusReqError,
-			"error":  err.Error(),
-		})
+	check := func() *completions.BindingError {
+		return req.CheckExtra(&config.Config.Binding)
+	}
+	if !bindCompletion(c, "sangfor/v1", &req.CompletionRequest, check) {
 		return
 	}
 	req.Headers = c.Request.Header
 
-	rsp := stream_controller.Controller.ProcessCompletionV1(c.Request.Context(), &req)
-	respCompletion(c, req.ClientID, "sangfor/v1", rsp)
+	// 同一completion_id从新旧路由各来一次时只处理一次；指定了strict_deadline_ms时到截止时间立即返回
+	rsp, result := stream_controller.Controller.ProcessCompletionStrict(c.Request.Context(), c.FullPath(), &req)
+	c.Header(HeaderCompletionRoute, result.ServedBy)
+	if result.Outcome != stream_controller.RouteServed {
+		c.Header(HeaderCompletionDedup, result.Outcome)
+	}
+	var err error
+	if legacyFormat(c) {
+		err = respLegacy(c, &req.CompletionRequest, rsp)
+	} else {
+		err = respCompletion(c, req.ClientID, "sangfor/v1", rsp)
+	}
+	if err != nil {
+		stream_controller.Controller.Undelivered(stream_controller.UndeliveredRequest{
+			Api:          "v1",
+			Route:        result.ServedBy,
+			ClientID:     req.ClientID,
+			CompletionID: req.CompletionID,
+			Language:     req.LanguageID,
+		}, rsp, err)
+	}
+}
+
+type InvalidateRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+// @Summary 作废在途或刚完成的补全
+// @Description 插件发现光标已移动或文档已编辑后调用，在途的请求被取消(cancel_cause为cursor_moved)，已完成的结果不再重放；重复调用返回相同的结果，也可以在下一次补全请求的invalidate字段中附带
+// @Tags completions
+// @Accept json
+// @Produce json
+// @Param completion_id path string true "作废的补全的completion_id"
+// @Param request body InvalidateRequest true "发出补全请求的客户端"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /code-completion/api/v1/completions/{completion_id}/invalidate [post]
+func InvalidateCompletion(c *gin.Context) {
+	var req InvalidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ClientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id is required"})
+		return
+	}
+	state := stream_controller.Controller.Invalidate(req.ClientID, c.Param("completion_id"))
+	statusCode := http.StatusOK
+	if state == stream_controller.InvalidateUnknown {
+		statusCode = http.StatusNotFound
+	}
+	c.JSON(statusCode, gin.H{"state": state})
 }
@@ -3,13 +3,14 @@ package server
 import (
 	"code-completion/pkg/model"
 	"code-completion/pkg/stream_controller"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 // @Summary sangfor/completions接口的代码补全
 // @Description 根据提供的代码上下文生成代码补全建议，该接口使用sangfor/completions接口，请求参数在客户端已经被预处理过了
+// @Description choices[].confidence为0-1的相对置信度，由隐藏分、后置处理器命中、结束原因、补全长度、语法错误裁剪按配置的权重加权得到，只用于比较补全之间的可信程度(如淡化显示低置信度的补全)，不是校准过的概率
+// @Description 指定strictDeadlineMs时保证在截止时间内返回(从收到请求时开始计算)：到时立即返回已得到的最好结果(可能为空，状态为timeout)，deadline字段说明被打断和跳过的阶段
 // @Tags completions
 // @Accept json
 // @Produce json
@@ -20,13 +21,19 @@ import (
 // @Router /code-completion/api/v2/completions [post]
 func CompletionsV2(c *gin.Context) {
 	var para model.CompletionParameter
-	if err := c.ShouldBindJSON(&para); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status": model.StatusReqError,
-			"error":  err.Error(),
-		})
+	if !bindCompletion(c, "sangfor/v2", &para, nil) {
 		return
 	}
+	para.Authorization = c.GetHeader("Authorization")
+	para.Tenant = stream_controller.TenantOf(c.Request.Header)
 	rsp := stream_controller.Controller.ProcessCompletionV2(c.Request.Context(), &para)
-	respCompletion(c, para.ClientID, "sangfor/v2", rsp)
+	c.Header(HeaderCompletionRoute, c.FullPath())
+	if err := respCompletion(c, para.ClientID, "sangfor/v2", rsp); err != nil {
+		stream_controller.Controller.Undelivered(stream_controller.UndeliveredRequest{
+			Api:          "v2",
+			ClientID:     para.ClientID,
+			CompletionID: para.CompletionID,
+			Language:     para.Language,
+		}, rsp, err)
+	}
 }
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 响应头：处理补全请求的服务版本
+const HeaderVersion = "X-CC-Version"
+
+/**
+ * 构建信息，由main在编译时注入的变量传入
+ * @description
+ * - Version: 软件版本
+ * - BuildTime: 构建时间
+ * - BuildTag: 构建标签
+ * - CommitID: 构建时的提交ID
+ */
+type BuildInfo struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"buildTime"`
+	BuildTag  string `json:"buildTag"`
+	CommitID  string `json:"commitId"`
+}
+
+/**
+ * 版本接口的响应
+ * @description
+ * - 构建信息之外，附带Go运行时版本、配置文件结构版本，以及启用的功能
+ * - 功能开关(见feature_flag)按当前的开关来源，对至少一部分客户端开启即为true；其它功能按当前配置
+ */
+type VersionInfo struct {
+	BuildInfo
+	GoVersion     string          `json:"goVersion"`
+	ConfigVersion int             `json:"configVersion"`
+	Features      map[string]bool `json:"features"`
+}
+
+// 启用的功能，key为功能名称；功能开关取自当前的开关来源，与请求的求值一致
+func enabledFeatures() map[string]bool {
+	ctx := config.Context
+	w := config.Wrapper
+	features := map[string]bool{
+		"streaming":    false, // 补全接口总是一次性返回完整结果
+		"adminAuth":    config.Config.Admin.Token != "",
+		"poolAudit":    true, // 模型池调整总是记录审计
+		"preflight":    !config.Config.Preflight.Disabled,
+		"anomaly":      config.Config.StreamController.Anomaly.Enabled,
+		"dedup":        config.Config.StreamController.DedupWindow > 0,
+		"definition":   !ctx.Definition.Disabled,
+		"semantic":     !ctx.Semantic.Disabled,
+		"relation":     !ctx.Relation.Disabled,
+		"scoreFilter":  !w.Score.Disabled,
+		"syntaxFilter": !w.Syntax.Disabled,
+		"prune":        !w.Prune.Disabled,
+	}
+	for name, flag := range feature_flag.Current().Snapshot().Flags {
+		features[name] = flag.AnyEnabled()
+	}
+	return features
+}
+
+// versionHandler 版本信息处理器
+// @Summary 获取服务版本
+// @Description 获取服务的构建信息、Go运行时版本、配置文件结构版本，以及按当前配置启用的功能
+// @Tags health
+// @Accept json
+// @Produce json
+// @Success 200 {object} VersionInfo
+// @Router /api/version [get]
+func versionHandler(info BuildInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, VersionInfo{
+			BuildInfo:     info,
+			GoVersion:     runtime.Version(),
+			ConfigVersion: config.CurrentConfigVersion,
+			Features:      enabledFeatures(),
+		})
+	}
+}
+
+// 在补全响应上附加服务版本的中间件
+func versionHeader(info BuildInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(HeaderVersion, info.Version)
+		c.Next()
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/feature_flag"
+
+	"github.com/gin-gonic/gin"
+)
+
+// to test the version endpoint reports the build info, runtime and config schema versions
+// go test ./server/ -v -run Test_VersionHandler
+func Test_VersionHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := SetupRouter(BuildInfo{Version: "1.2.3", BuildTime: "2026-10-17", BuildTag: "release", CommitID: "abc123"})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var info VersionInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.2.3" || info.CommitID != "abc123" || info.BuildTag != "release" {
+		t.Errorf("unexpected build info %+v", info.BuildInfo)
+	}
+	if info.GoVersion != runtime.Version() || info.ConfigVersion != config.CurrentConfigVersion {
+		t.Errorf("unexpected runtime/config versions %s/%d", info.GoVersion, info.ConfigVersion)
+	}
+	if _, ok := info.Features["preflight"]; !ok {
+		t.Errorf("expected feature flags, got %v", info.Features)
+	}
+}
+
+// to test the version header is attached to completion responses on both routes
+// go test ./server/ -v -run Test_VersionHeader
+func Test_VersionHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := SetupRouter(BuildInfo{Version: "1.2.3"})
+
+	// 请求体无效时在绑定阶段返回，不需要补全控制器
+	for _, path := range []string{"/code-completion/api/v1/completions", "/code-completion/api/v2/completions"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader("{")))
+		if got := w.Header().Get(HeaderVersion); got != "1.2.3" {
+			t.Errorf("%s: expected version header, got %q (status %d)", path, got, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	if got := w.Header().Get(HeaderVersion); got != "" {
+		t.Errorf("expected no version header on debug apis, got %q", got)
+	}
+}
+
+// 按固定的开关状态返回的开关来源
+type snapshotProvider struct {
+	feature_flag.StaticProvider
+	flags map[string]feature_flag.Flag
+}
+
+func (p snapshotProvider) Snapshot() feature_flag.Snapshot {
+	return feature_flag.Snapshot{Provider: "test", Flags: p.flags}
+}
+
+// to test the version endpoint reports the feature flags of the active provider
+// go test ./server/ -v -run Test_VersionFeatureFlags
+func Test_VersionFeatureFlags(t *testing.T) {
+	feature_flag.Use(snapshotProvider{flags: map[string]feature_flag.Flag{
+		feature_flag.Style:    {Rollout: 20},
+		feature_flag.AutoTune: {Rules: []feature_flag.Rule{{Attribute: feature_flag.AttributeTenant, Values: []string{"beta"}, Enabled: true}}},
+		feature_flag.Closer:   {},
+	}})
+	defer feature_flag.Use(nil)
+
+	features := enabledFeatures()
+	if !features[feature_flag.Style] || !features[feature_flag.AutoTune] || features[feature_flag.Closer] {
+		t.Errorf("expected the flags of the provider, got %v", features)
+	}
+	if _, ok := features["preflight"]; !ok {
+		t.Errorf("expected the service features kept, got %v", features)
+	}
+}